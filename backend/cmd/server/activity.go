@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"stockmate/internal/timeutil"
+)
+
+const (
+	defaultActivityLimit = 50
+	maxActivityLimit     = 200
+	// defaultActivityDays is the window without ?from=: this week.
+	defaultActivityDays = 7
+)
+
+// Activity kinds, one per source the feed merges.
+const (
+	activityItem  = "item"
+	activityStock = "stock"
+	activityBOM   = "bom"
+	activityBuild = "build"
+)
+
+// ActivityEntry is one thing that happened, for the dashboard's activity
+// panel.
+type ActivityEntry struct {
+	Kind      string        `json:"kind"`
+	CreatedAt timeutil.Time `json:"created_at"`
+	// Actor is the user (by email) or key:<name> behind the change, empty
+	// for anonymous requests and data from before actors were recorded.
+	Actor   string `json:"actor,omitempty"`
+	ItemID  int64  `json:"item_id"`
+	SKU     string `json:"sku"`
+	Name    string `json:"name"`
+	Summary string `json:"summary"`
+	// Ref is the source row: the item change, transaction or BOM revision
+	// id, or the build's ref_id.
+	Ref string `json:"ref"`
+}
+
+type ActivityPage struct {
+	Entries []ActivityEntry `json:"entries"`
+	// NextCursor fetches the next, older page; it is empty on the last.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// activitySources select every entry with the same columns, so any of them
+// can be UNIONed; seq breaks ties in created_at. The rows of a build are
+// folded into one entry.
+var activitySources = map[string]string{
+	activityItem: `
+SELECT 'item' AS kind, c.created_at AS created_at, printf('item:%012d', c.change_id) AS seq,
+  c.item_id AS item_id, c.sku AS sku, c.name AS name, COALESCE(c.actor, '') AS actor,
+  c.action AS action, 0 AS qty, '' AS unit, '' AS detail, CAST(c.change_id AS TEXT) AS ref
+FROM item_changes c`,
+	activityStock: `
+SELECT 'stock' AS kind, st.created_at AS created_at, printf('stock:%012d', st.transaction_id) AS seq,
+  st.item_id AS item_id, i.sku AS sku, i.name AS name, COALESCE(st.actor, '') AS actor,
+  st.transaction_type AS action, st.qty AS qty, i.managed_unit AS unit,
+  COALESCE(st.reason_code, '') AS detail, CAST(st.transaction_id AS TEXT) AS ref
+FROM stock_transactions st
+JOIN items i ON i.item_id = st.item_id
+WHERE st.ref_type IS NULL OR st.ref_type <> 'build'`,
+	activityBOM: `
+SELECT 'bom' AS kind, ar.created_at AS created_at, printf('bom:%012d', ar.record_id) AS seq,
+  ar.item_id AS item_id, i.sku AS sku, i.name AS name, COALESCE(ar.changed_by, '') AS actor,
+  'rev' AS action, ar.rev_no AS qty, '' AS unit, COALESCE(ar.change_note, '') AS detail,
+  CAST(ar.record_id AS TEXT) AS ref
+FROM assembly_records ar
+JOIN items i ON i.item_id = ar.item_id`,
+	activityBuild: `
+SELECT 'build' AS kind, MIN(st.created_at) AS created_at, 'build:' || st.ref_id || ':' || ar.item_id AS seq,
+  ar.item_id AS item_id, i.sku AS sku, i.name AS name, COALESCE(MAX(st.actor), '') AS actor,
+  'build' AS action,
+  SUM(CASE WHEN st.transaction_type = 'IN' AND st.item_id = ar.item_id THEN st.qty ELSE 0 END) AS qty,
+  i.managed_unit AS unit, CAST(SUM(st.transaction_type = 'OUT') AS TEXT) AS detail, st.ref_id AS ref
+FROM stock_transactions st
+JOIN assembly_records ar ON ar.record_id = st.bom_record_id
+JOIN items i ON i.item_id = ar.item_id
+WHERE st.ref_type = 'build'
+GROUP BY st.ref_id, ar.item_id`,
+}
+
+var activityKinds = []string{activityItem, activityStock, activityBOM, activityBuild}
+
+// logItemChange records an item create, edit or delete for the feed.
+func logItemChange(ctx context.Context, tx *sql.Tx, itemID int64, sku, name, action string) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO item_changes(item_id, sku, name, action, actor, request_id) VALUES(?,?,?,?,?,?)
+`, itemID, sku, name, action, actorArg(ctx), requestIDArg(ctx))
+	return err
+}
+
+// activitySummary describes an entry in a line.
+func activitySummary(kind, action string, qty float64, unit, detail string) string {
+	switch kind {
+	case activityItem:
+		return action
+	case activityStock:
+		s := fmt.Sprintf("%s %g %s", action, qty, unit)
+		if detail != "" {
+			s += " (" + detail + ")"
+		}
+		return s
+	case activityBOM:
+		s := fmt.Sprintf("BOM rev %g", qty)
+		if detail != "" {
+			s += ": " + detail
+		}
+		return s
+	case activityBuild:
+		if qty > 0 {
+			return fmt.Sprintf("built %g %s", qty, unit)
+		}
+		return fmt.Sprintf("picked %s component lines", detail)
+	}
+	return ""
+}
+
+// encodeActivityCursor and decodeActivityCursor keep the position of the
+// last entry of a page opaque to clients.
+func encodeActivityCursor(at, seq string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(at + "|" + seq))
+}
+
+func decodeActivityCursor(s string) (at, seq string, ok bool) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return "", "", false
+	}
+	at, seq, ok = strings.Cut(string(b), "|")
+	return at, seq, ok && at != "" && seq != ""
+}
+
+func queryActivity(ctx context.Context, q queryer, kinds []string, from, cursorAt, cursorSeq string, limit int) (ActivityPage, error) {
+	parts := make([]string, 0, len(kinds))
+	for _, k := range kinds {
+		parts = append(parts, activitySources[k])
+	}
+	args := []any{from}
+	where := "created_at >= ?"
+	if cursorAt != "" {
+		where += " AND (created_at < ? OR (created_at = ? AND seq < ?))"
+		args = append(args, cursorAt, cursorAt, cursorSeq)
+	}
+	args = append(args, limit+1)
+	rows, err := q.QueryContext(ctx, `
+SELECT kind, created_at, seq, item_id, sku, name, actor, action, qty, unit, detail, ref
+FROM (`+strings.Join(parts, "\nUNION ALL")+`
+)
+WHERE `+where+`
+ORDER BY created_at DESC, seq DESC
+LIMIT ?
+`, args...)
+	if err != nil {
+		return ActivityPage{}, err
+	}
+	defer rows.Close()
+
+	page := ActivityPage{Entries: make([]ActivityEntry, 0)}
+	var lastAt, lastSeq string
+	for rows.Next() {
+		var e ActivityEntry
+		var at, seq, action, unit, detail string
+		var qty float64
+		if err := rows.Scan(&e.Kind, &at, &seq, &e.ItemID, &e.SKU, &e.Name, &e.Actor, &action, &qty, &unit, &detail, &e.Ref); err != nil {
+			return ActivityPage{}, err
+		}
+		if len(page.Entries) == limit {
+			page.NextCursor = encodeActivityCursor(lastAt, lastSeq)
+			break
+		}
+		if err := e.CreatedAt.Scan(at); err != nil {
+			return ActivityPage{}, err
+		}
+		e.Summary = activitySummary(e.Kind, action, qty, unit, detail)
+		page.Entries = append(page.Entries, e)
+		lastAt, lastSeq = at, seq
+	}
+	return page, rows.Err()
+}
+
+// getActivity is the merged feed of item edits, stock movements, BOM
+// revisions and builds, newest first. ?from= (YYYY-MM-DD, default six days
+// ago) bounds it, ?kind= picks sources (comma separated: item, stock, bom,
+// build), ?limit= sizes pages (default 50, max 200) and ?cursor= continues
+// from a previous page's next_cursor.
+func getActivity(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		limit, ok := queryInt(query.Get("limit"), defaultActivityLimit, 1, maxActivityLimit)
+		if !ok {
+			http.Error(w, "limit must be 1-200", http.StatusBadRequest)
+			return
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fromDay := strings.TrimSpace(query.Get("from"))
+		if fromDay == "" {
+			fromDay = time.Now().In(loc).AddDate(0, 0, 1-defaultActivityDays).Format(time.DateOnly)
+		}
+		from, err := timeutil.StartOfDay(fromDay, loc)
+		if err != nil {
+			http.Error(w, "invalid from (want YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+
+		kinds := activityKinds
+		if v := strings.TrimSpace(query.Get("kind")); v != "" {
+			kinds = nil
+			for _, k := range strings.Split(v, ",") {
+				k = strings.ToLower(strings.TrimSpace(k))
+				if _, ok := activitySources[k]; !ok {
+					http.Error(w, "kind must be item, stock, bom or build", http.StatusBadRequest)
+					return
+				}
+				if !slices.Contains(kinds, k) {
+					kinds = append(kinds, k)
+				}
+			}
+		}
+
+		var cursorAt, cursorSeq string
+		if v := strings.TrimSpace(query.Get("cursor")); v != "" {
+			if cursorAt, cursorSeq, ok = decodeActivityCursor(v); !ok {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+		}
+
+		page, err := queryActivity(r.Context(), dbx, kinds, timeutil.Format(from), cursorAt, cursorSeq, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(page)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+type ForeignKeyViolation struct {
+	Table  string `json:"table"`
+	RowID  *int64 `json:"rowid,omitempty"`
+	Parent string `json:"parent"`
+	FKID   int64  `json:"fkid"`
+}
+
+type StockMismatch struct {
+	ItemID     int64   `json:"item_id"`
+	LedgerQty  float64 `json:"ledger_qty"`
+	BalanceQty float64 `json:"balance_qty"`
+}
+
+type DBCheckReport struct {
+	OK                   bool                  `json:"ok"`
+	Integrity            []string              `json:"integrity"`
+	ForeignKeyViolations []ForeignKeyViolation `json:"foreign_key_violations"`
+	BalanceTablePresent  bool                  `json:"balance_table_present"`
+	StockMismatches      []StockMismatch       `json:"stock_mismatches"`
+}
+
+func checkDatabase(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		report := DBCheckReport{
+			Integrity:            make([]string, 0),
+			ForeignKeyViolations: make([]ForeignKeyViolation, 0),
+			StockMismatches:      make([]StockMismatch, 0),
+		}
+
+		intRows, err := dbx.QueryContext(ctx, `PRAGMA integrity_check;`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for intRows.Next() {
+			var msg string
+			if err := intRows.Scan(&msg); err != nil {
+				intRows.Close()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			report.Integrity = append(report.Integrity, msg)
+		}
+		if err := intRows.Err(); err != nil {
+			intRows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		intRows.Close()
+
+		fkRows, err := dbx.QueryContext(ctx, `PRAGMA foreign_key_check;`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for fkRows.Next() {
+			var v ForeignKeyViolation
+			var rowID sql.NullInt64
+			if err := fkRows.Scan(&v.Table, &rowID, &v.Parent, &v.FKID); err != nil {
+				fkRows.Close()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if rowID.Valid {
+				id := rowID.Int64
+				v.RowID = &id
+			}
+			report.ForeignKeyViolations = append(report.ForeignKeyViolations, v)
+		}
+		if err := fkRows.Err(); err != nil {
+			fkRows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fkRows.Close()
+
+		// The ledger reconciliation only runs once a cached balance table exists.
+		present, err := balanceTablePresent(ctx, dbx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		report.BalanceTablePresent = present
+		if report.BalanceTablePresent {
+			mismatches, err := findStockMismatches(ctx, dbx)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			report.StockMismatches = mismatches
+		}
+
+		report.OK = len(report.Integrity) == 1 && report.Integrity[0] == "ok" &&
+			len(report.ForeignKeyViolations) == 0 &&
+			len(report.StockMismatches) == 0
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
+
+func balanceTablePresent(ctx context.Context, dbx *sql.DB) (bool, error) {
+	var n int
+	err := dbx.QueryRowContext(ctx, `
+SELECT COUNT(1)
+FROM sqlite_master
+WHERE type = 'table' AND name = 'stock_balances'
+`).Scan(&n)
+	return n > 0, err
+}
+
+type StockRebuildReport struct {
+	BalanceTablePresent bool            `json:"balance_table_present"`
+	Mismatches          []StockMismatch `json:"mismatches"`
+	Repaired            bool            `json:"repaired"`
+	RowsRebuilt         int64           `json:"rows_rebuilt"`
+}
+
+// rebuildStock recomputes balances from stock_transactions and reports every
+// item whose cached balance differs. With ?repair=1 the cache is rewritten
+// from the ledger. Without a stock_balances table stock is always read from
+// the ledger, so there is nothing to compare or repair.
+func rebuildStock(st *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		repair := false
+		switch r.URL.Query().Get("repair") {
+		case "", "0", "false":
+		case "1", "true":
+			repair = true
+		default:
+			http.Error(w, "repair must be 1 or 0", http.StatusBadRequest)
+			return
+		}
+
+		report := StockRebuildReport{Mismatches: make([]StockMismatch, 0)}
+		present, err := balanceTablePresent(ctx, st.DB())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		report.BalanceTablePresent = present
+		if present {
+			mismatches, err := findStockMismatches(ctx, st.DB())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			report.Mismatches = mismatches
+		}
+
+		if present && repair {
+			rebuilt, n, err := st.RebuildBalances(ctx)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			report.Repaired, report.RowsRebuilt = rebuilt, n
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
+
+func findStockMismatches(ctx context.Context, dbx *sql.DB) ([]StockMismatch, error) {
+	rows, err := dbx.QueryContext(ctx, `
+SELECT
+  i.item_id,
+  COALESCE(l.qty, 0) AS ledger_qty,
+  COALESCE(b.qty, 0) AS balance_qty
+FROM items i
+LEFT JOIN (
+  SELECT
+    item_id,
+    SUM(CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END) AS qty
+  FROM stock_transactions
+  GROUP BY item_id
+) l ON l.item_id = i.item_id
+LEFT JOIN stock_balances b ON b.item_id = i.item_id
+WHERE ABS(COALESCE(l.qty, 0) - COALESCE(b.qty, 0)) > 1e-9
+ORDER BY i.item_id
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]StockMismatch, 0)
+	for rows.Next() {
+		var m StockMismatch
+		if err := rows.Scan(&m.ItemID, &m.LedgerQty, &m.BalanceQty); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// maintainDatabase checkpoints the WAL and vacuums on demand. ?vacuum= is
+// incremental (default), full or none; full locks the database while it
+// rewrites the file.
+func maintainDatabase(st *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := store.VacuumMode(r.URL.Query().Get("vacuum"))
+		switch mode {
+		case "":
+			mode = store.VacuumIncremental
+		case store.VacuumIncremental, store.VacuumFull, store.VacuumNone:
+		default:
+			http.Error(w, "vacuum must be incremental, full or none", http.StatusBadRequest)
+			return
+		}
+		rep, err := st.Maintain(r.Context(), mode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rep)
+	}
+}
+
+func maintenanceJob(st *store.Store) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		rep, err := st.Maintain(ctx, store.VacuumIncremental)
+		if err != nil {
+			return err
+		}
+		log.Printf("db maintenance: %d -> %d bytes, wal %d -> %d bytes",
+			rep.Before.FileBytes, rep.After.FileBytes, rep.Before.WALBytes, rep.After.WALBytes)
+		return nil
+	}
+}
+
+// archiveTransactions moves old ledger rows to the archive table behind
+// opening balances. The cutoff is either before (a date or timestamp) or
+// years back from now; ?dry_run=1 reports what would move.
+func archiveTransactions(st *store.Store) http.HandlerFunc {
+	type Req struct {
+		Before string `json:"before"`
+		Years  int    `json:"years"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun, ok := queryDryRun(r)
+		if !ok {
+			http.Error(w, "invalid dry_run", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		var before time.Time
+		switch v := strings.TrimSpace(req.Before); {
+		case (v == "") == (req.Years == 0):
+			http.Error(w, "one of before or years is required", http.StatusBadRequest)
+			return
+		case v != "":
+			t, err := timeutil.Parse(v)
+			if err != nil {
+				http.Error(w, "before must be a date or RFC3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			before = t
+		case req.Years < 0:
+			http.Error(w, "years must be > 0", http.StatusBadRequest)
+			return
+		default:
+			before = now.AddDate(-req.Years, 0, 0)
+		}
+		if before.After(now) {
+			http.Error(w, "before must not be in the future", http.StatusBadRequest)
+			return
+		}
+
+		rep, err := st.ArchiveTransactions(r.Context(), before, dryRun)
+		if errors.Is(err, store.ErrLedgerAppendOnly) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rep)
+	}
+}
+
+func archiveJob(st *store.Store, years int) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		rep, err := st.ArchiveTransactions(ctx, time.Now().AddDate(-years, 0, 0), false)
+		if errors.Is(err, store.ErrLedgerAppendOnly) {
+			// Nothing will ever be archivable again, which is not a failure.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rep.Archived > 0 || rep.Held > 0 {
+			log.Printf("transaction archive: %d rows before %s, %d opening balances, %d held",
+				rep.Archived, rep.Before, rep.OpeningBalances, rep.Held)
+		}
+		return nil
+	}
+}
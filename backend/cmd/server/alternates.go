@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"stockmate/internal/validate"
+)
+
+// BOMAlternate is a substitute for a BOM line. Lower priority is tried first.
+type BOMAlternate struct {
+	ItemID   int64  `json:"item_id"`
+	SKU      string `json:"sku,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Priority int64  `json:"priority"`
+}
+
+// loadBOMAlternates returns the alternates of every line of a revision, keyed
+// by the line's component_item_id and ordered by priority.
+func loadBOMAlternates(ctx context.Context, q queryer, recordID int64) (map[int64][]BOMAlternate, error) {
+	rows, err := q.QueryContext(ctx, `
+SELECT aca.component_item_id, aca.alternate_item_id, i.sku, i.name, aca.priority
+FROM assembly_component_alternates aca
+JOIN items i ON i.item_id = aca.alternate_item_id
+WHERE aca.record_id = ?
+ORDER BY aca.component_item_id, aca.priority, i.sku
+`, recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64][]BOMAlternate)
+	for rows.Next() {
+		var componentID int64
+		var a BOMAlternate
+		if err := rows.Scan(&componentID, &a.ItemID, &a.SKU, &a.Name, &a.Priority); err != nil {
+			return nil, err
+		}
+		out[componentID] = append(out[componentID], a)
+	}
+	return out, rows.Err()
+}
+
+// checkBOMAlternates validates the alternates of one line, reporting under
+// field, and fills in missing priorities from list order. Whether the items
+// exist is left to the caller, which looks up the whole revision at once.
+func checkBOMAlternates(errs *validate.Errors, field string, parentItemID, componentID int64, alts []BOMAlternate) {
+	seen := make(map[int64]struct{}, len(alts))
+	for i := range alts {
+		a := &alts[i]
+		f := fmt.Sprintf("%s[%d]", field, i)
+		switch {
+		case a.ItemID <= 0:
+			errs.Add(f+".item_id", "must be > 0")
+		case a.ItemID == componentID || a.ItemID == parentItemID:
+			errs.Add(f+".item_id", "cannot be the component itself or the parent")
+		default:
+			if _, dup := seen[a.ItemID]; dup {
+				errs.Add(f+".item_id", fmt.Sprintf("duplicate alternate %d", a.ItemID))
+			}
+			seen[a.ItemID] = struct{}{}
+		}
+		if a.Priority < 0 {
+			errs.Add(f+".priority", "must be > 0")
+		}
+		if a.Priority == 0 {
+			a.Priority = int64(i + 1)
+		}
+	}
+}
+
+// alternateIDs returns the item ids of alts.
+func alternateIDs(alts []BOMAlternate) []int64 {
+	ids := make([]int64, len(alts))
+	for i, a := range alts {
+		ids[i] = a.ItemID
+	}
+	return ids
+}
+
+// latestBOMRecordID returns the parent's latest revision that is not
+// obsolete, or 0 when it has none.
+func latestBOMRecordID(ctx context.Context, q queryer, parentItemID int64) (int64, error) {
+	var recordID int64
+	err := q.QueryRowContext(ctx, `
+SELECT record_id FROM assembly_records WHERE item_id = ? AND obsolete_at IS NULL ORDER BY rev_no DESC LIMIT 1
+`, parentItemID).Scan(&recordID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return recordID, err
+}
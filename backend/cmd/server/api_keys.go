@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+	"stockmate/internal/validate"
+)
+
+// API key scopes, from least to most access.
+const (
+	scopeReadOnly   = "read-only"
+	scopeStockWrite = "stock-write"
+	scopeAdmin      = "admin"
+)
+
+const (
+	apiKeyPrefix    = "smk_"
+	apiKeyPrefixLen = len(apiKeyPrefix) + 8
+	// apiKeyTouchEvery throttles last_used_at writes for busy keys.
+	apiKeyTouchEvery = time.Minute
+)
+
+// apiKeyExempt are /api paths that authenticate callers on their own.
+var apiKeyExempt = map[string]bool{"/api/integrations/orders/webhook": true}
+
+type APIKey struct {
+	ID         int64         `json:"id"`
+	Name       string        `json:"name"`
+	Scope      string        `json:"scope"`
+	Prefix     string        `json:"prefix"`
+	CreatedAt  timeutil.Time `json:"created_at"`
+	LastUsedAt timeutil.Time `json:"last_used_at"`
+	RevokedAt  timeutil.Time `json:"revoked_at"`
+}
+
+const apiKeySelect = `
+SELECT key_id, name, scope, prefix, created_at, last_used_at, revoked_at
+FROM api_keys
+`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.Name, &k.Scope, &k.Prefix, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	return k, err
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestAPIKey reads the key from "Authorization: Bearer" or X-API-Key.
+func requestAPIKey(r *http.Request) string {
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(v)
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// stockWriteRoutes are the routes that move stock, which the stock-write
+// scope allows on top of what read-only does: adjustments and receipts,
+// picks, production and shipments, ledger fixes, bins and stocktakes.
+var stockWriteRoutes = map[string]bool{
+	"POST /api/assemblies/{id}/adjust":         true,
+	"POST /api/assemblies/{id}/picklist":       true,
+	"POST /api/production/parts/{id}/complete": true,
+	"POST /api/production/components/complete": true,
+	"POST /api/production/shipments/complete":  true,
+	"POST /api/transactions/{id}/reverse":      true,
+	"POST /api/transactions/{id}/correct":      true,
+	"POST /api/items/{id}/bins/transfer":       true,
+	"PUT /api/items/{id}/bins/{code}":          true,
+	"DELETE /api/items/{id}/bins/{code}":       true,
+	"POST /api/stocktakes":                     true,
+}
+
+// scopeAllows reports whether an API key scope or user role may make
+// request r: admin may do anything, read-only may only read outside
+// /api/admin (GraphQL included), and stock-write may also use
+// stockWriteRoutes. authenticate runs ahead of routing, so the route is
+// looked up here.
+func scopeAllows(scope string, r *http.Request) bool {
+	if scope == scopeAdmin {
+		return true
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/admin/") {
+		return false
+	}
+	if scope == scopeStockWrite {
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.Routes != nil {
+			if stockWriteRoutes[r.Method+" "+rctx.Routes.Find(chi.NewRouteContext(), r.Method, r.URL.Path)] {
+				return true
+			}
+		}
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return r.URL.Path == graphQLPath
+}
+
+// authenticate resolves who sent a request: the API key it carries, else
+// the signed-in user of its session cookie, whose scope or role must allow
+// the request. A request from neither passes unless required is set, in
+// which case /api answers 401 to it.
+func authenticate(dbx *sql.DB, required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope := ""
+			if key := requestAPIKey(r); key != "" {
+				var id int64
+				var name string
+				var lastUsed sql.NullString
+				err := dbx.QueryRowContext(r.Context(), `
+SELECT key_id, name, scope, last_used_at FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL
+`, hashAPIKey(key)).Scan(&id, &name, &scope, &lastUsed)
+				if err == sql.ErrNoRows {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="stockmate", error="invalid_token"`)
+					http.Error(w, "invalid api key", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					http.Error(w, "failed to check api key", http.StatusInternalServerError)
+					return
+				}
+				if err := touchAPIKey(r.Context(), dbx, id, lastUsed.String); err != nil {
+					http.Error(w, "failed to record api key use", http.StatusInternalServerError)
+					return
+				}
+				r = r.WithContext(context.WithValue(r.Context(), actorKey{}, "key:"+name))
+			} else {
+				u, err := sessionUser(r.Context(), dbx, r)
+				if err != nil {
+					http.Error(w, "failed to check session", http.StatusInternalServerError)
+					return
+				}
+				if u != nil {
+					scope = u.Role
+					actor := u.Email
+					if actor == "" {
+						actor = u.Name
+					}
+					ctx := context.WithValue(r.Context(), userKey{}, u)
+					r = r.WithContext(context.WithValue(ctx, actorKey{}, actor))
+				}
+			}
+
+			if scope == "" {
+				if required && strings.HasPrefix(r.URL.Path, "/api/") && !apiKeyExempt[r.URL.Path] {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="stockmate"`)
+					http.Error(w, "sign-in or api key required", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !scopeAllows(scope, r) {
+				http.Error(w, fmt.Sprintf("%s access does not allow %s %s", scope, r.Method, r.URL.Path), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type actorKey struct{}
+
+// actorArg is who a request acts for, recorded with what it changes: the
+// signed-in user's email, or key:<name> for an API key. It is nil for
+// anonymous requests.
+func actorArg(ctx context.Context) any {
+	if a, _ := ctx.Value(actorKey{}).(string); a != "" {
+		return a
+	}
+	return nil
+}
+
+// touchAPIKey records a key's use, at most once per apiKeyTouchEvery.
+func touchAPIKey(ctx context.Context, dbx *sql.DB, id int64, lastUsed string) error {
+	now := time.Now().UTC()
+	if t, err := timeutil.Parse(lastUsed); err == nil && now.Sub(t) < apiKeyTouchEvery {
+		return nil
+	}
+	_, err := dbx.ExecContext(ctx, `UPDATE api_keys SET last_used_at = ? WHERE key_id = ?`, timeutil.Format(now), id)
+	return err
+}
+
+func listAPIKeys(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := dbx.QueryContext(r.Context(), apiKeySelect+` ORDER BY key_id`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		out := make([]APIKey, 0)
+		for rows.Next() {
+			k, err := scanAPIKey(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, k)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// createAPIKey issues a key. The key itself is only in this response;
+// the server keeps its hash.
+func createAPIKey(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Name  string `json:"name"`
+		Scope string `json:"scope"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		req.Scope = strings.ToLower(strings.TrimSpace(req.Scope))
+		var errs validate.Errors
+		errs.Required("name", req.Name)
+		errs.OneOf("scope", req.Scope, scopeReadOnly, scopeStockWrite, scopeAdmin)
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			http.Error(w, "failed to generate key", http.StatusInternalServerError)
+			return
+		}
+		key := apiKeyPrefix + hex.EncodeToString(b)
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(r.Context(), `
+INSERT INTO api_keys(name, scope, prefix, key_hash) VALUES(?, ?, ?, ?)
+`, req.Name, req.Scope, key[:apiKeyPrefixLen], hashAPIKey(key))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		id, _ := res.LastInsertId()
+		k, err := scanAPIKey(tx.QueryRowContext(r.Context(), apiKeySelect+` WHERE key_id = ?`, id))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(struct {
+			APIKey
+			Key string `json:"key"`
+		}{k, key})
+	}
+}
+
+// revokeAPIKey stops a key from working; the key stays listed.
+func revokeAPIKey(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		if _, err := store.Exec(r.Context(), dbx, `
+UPDATE api_keys SET revoked_at = strftime('%Y-%m-%dT%H:%M:%SZ','now')
+WHERE key_id = ? AND revoked_at IS NULL
+`, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		k, err := scanAPIKey(dbx.QueryRowContext(r.Context(), apiKeySelect+` WHERE key_id = ?`, id))
+		if err == sql.ErrNoRows {
+			http.Error(w, "api key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(k)
+	}
+}
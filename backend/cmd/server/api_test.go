@@ -0,0 +1,2001 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"stockmate/internal/config"
+	"stockmate/internal/db"
+	"stockmate/internal/notify"
+	"stockmate/internal/oidc"
+	"stockmate/internal/storage"
+	"stockmate/internal/store"
+	"stockmate/internal/testutil"
+)
+
+// newTestRouter serves the full API on a fresh in-memory database holding
+// the demo catalogue. The seed gives fixed ids:
+//
+//	series 1       Demo Lamp
+//	items 1-5      MAT-PLA-WHT, PRT-SHADE, PRT-LED, PRT-CABLE, CNS-SCREW
+//	item 6         ASM-LAMP (assembly, BOM rev 1)
+//	transactions   1-6 initial IN per item, 7 OUT of one ASM-LAMP
+func newTestRouter(t *testing.T) *chi.Mux {
+	t.Helper()
+	return testRouter(t, testutil.SeededDB(t))
+}
+
+// testRouter serves the full API on conn, for tests that also inspect the
+// database directly. opts adjust the test config.
+func testRouter(t *testing.T, conn *sql.DB, opts ...func(*config.Config)) *chi.Mux {
+	t.Helper()
+	st := store.New(conn)
+	t.Cleanup(func() { st.Close() })
+	attachments, err := storage.NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly, err := loadReadOnlyMode(context.Background(), conn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Config{MaxBodyBytes: 1 << 20, CompressMinBytes: -1, BOMMaxComponents: 3}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return newRouter(cfg, deps{
+		conn:        conn,
+		store:       st,
+		attachments: attachments,
+		readOnly:    readOnly,
+		syncer:      &shopSyncer{},
+		mailer:      notify.NewMailer(notify.SMTPConfig{}),
+	})
+}
+
+type apiCase struct {
+	name   string
+	method string
+	path   string
+	body   any
+	status int
+}
+
+// apiCases pin the response of every route. Each case runs on its own
+// database, so writes don't leak into later cases.
+var apiCases = []apiCase{
+	{"health", "GET", "/health", nil, 200},
+	{"healthz", "GET", "/healthz", nil, 200},
+	{"readyz", "GET", "/readyz", nil, 200},
+	{"auth_login_unconfigured", "GET", "/auth/login", nil, 503},
+	{"auth_callback_unconfigured", "GET", "/auth/callback?code=x&state=y", nil, 503},
+	{"auth_logout", "POST", "/auth/logout", nil, 204},
+	{"me_anonymous", "GET", "/api/me", nil, 401},
+
+	{"items_list", "GET", "/api/items", nil, 200},
+	{"items_list_filtered", "GET", "/api/items?item_type=component&output_category=part,consumable&managed=1&q=S", nil, 200},
+	{"items_list_series", "GET", "/api/items?series_id=1&final=yes&sort=sku", nil, 200},
+	{"items_list_invalid_category", "GET", "/api/items?output_category=widget", nil, 400},
+	{"items_list_invalid_flag", "GET", "/api/items?sellable=maybe", nil, 400},
+	{"items_list_fields", "GET", "/api/items?fields=id,sku,name&sort=sku", nil, 200},
+	{"items_list_invalid_fields", "GET", "/api/items?fields=sku,nope", nil, 400},
+	{"items_list_compact", "GET", "/api/items?compact=1&sort=sku", nil, 200},
+	{"items_list_invalid_compact", "GET", "/api/items?compact=maybe", nil, 400},
+	{"items_create", "POST", "/api/items", map[string]any{
+		"sku": "PRT-KNOB", "name": "Knob", "item_type": "component",
+		"component": map[string]any{"component_type": "part"},
+	}, 200},
+	{"items_create_invalid", "POST", "/api/items", map[string]any{"name": "no sku", "item_type": "widget"}, 400},
+	{"items_create_measures", "POST", "/api/items", map[string]any{
+		"sku": "PRT-BASE", "name": "Lamp base", "item_type": "component", "managed_unit": "pcs",
+		"weight": 120, "length": 100, "width": 80, "height": 25,
+		"component": map[string]any{"component_type": "part"},
+	}, 200},
+	{"items_create_invalid_measures", "POST", "/api/items", map[string]any{
+		"sku": "MAT-PETG", "name": "PETG", "item_type": "component", "managed_unit": "g",
+		"weight": 1, "length": 10, "volume": -1,
+	}, 400},
+	{"items_picker", "GET", "/api/items/picker?q=PRT&type=part&limit=2", nil, 200},
+	{"items_picker_invalid_type", "GET", "/api/items/picker?type=widget", nil, 400},
+	{"items_picker_fields", "GET", "/api/items/picker?q=PRT&fields=sku,name,stock_qty", nil, 200},
+	{"items_lookup", "POST", "/api/items/lookup", map[string]any{"skus": []string{"ASM-LAMP", "PRT-LED", "NOPE"}}, 200},
+	{"items_get", "GET", "/api/items/3", nil, 200},
+	{"items_get_missing", "GET", "/api/items/999", nil, 404},
+	{"alerts_low_stock", "GET", "/api/alerts/low-stock", nil, 200},
+	{"items_low_stock_snooze_not_low", "PUT", "/api/items/3/low-stock-snooze", map[string]any{}, 409},
+	{"items_low_stock_snooze_invalid_until", "PUT", "/api/items/4/low-stock-snooze", map[string]any{"until": "soon"}, 400},
+	{"items_low_stock_unsnooze_missing", "DELETE", "/api/items/4/low-stock-snooze", nil, 404},
+	{"items_bins", "GET", "/api/items/3/bins", nil, 200},
+	{"items_bins_missing", "GET", "/api/items/999/bins", nil, 404},
+	{"items_bins_put", "PUT", "/api/items/3/bins/A-01", nil, 200},
+	{"items_bins_transfer_invalid", "POST", "/api/items/3/bins/transfer", map[string]any{"from_bin": "A-01", "to_bin": "a-01", "qty": 0}, 400},
+	{"items_bins_delete_missing", "DELETE", "/api/items/3/bins/Z-99", nil, 404},
+	{"items_update_bad_json", "PUT", "/api/items/1", "{", 400},
+	{"items_delete_missing", "DELETE", "/api/items/999", nil, 404},
+	{"items_dependencies", "GET", "/api/items/1/dependencies", nil, 200},
+	{"items_lifecycle_eol", "PUT", "/api/items/3/lifecycle", map[string]any{"status": "eol"}, 200},
+	{"items_lifecycle_invalid", "PUT", "/api/items/3/lifecycle", map[string]any{"status": "retired"}, 400},
+	{"items_by_series", "GET", "/api/items/by-series", nil, 200},
+	{"items_ledger", "GET", "/api/items/6/ledger", nil, 200},
+	{"items_ledger_note_search", "GET", "/api/items/6/ledger?q=shipped", nil, 200},
+
+	{"assemblies_list", "GET", "/api/assemblies", nil, 200},
+	{"assemblies_components", "GET", "/api/assemblies/6/components", nil, 200},
+	{"assemblies_components_revise", "PUT", "/api/assemblies/6/components", map[string]any{
+		"components": []map[string]any{
+			{"component_item_id": 2, "qty_per_unit": 1},
+			{"component_item_id": 3, "qty_per_unit": 2},
+			{"component_item_id": 5, "qty_per_unit": 6},
+		},
+		"change_note": "Screws instead of cable ties", "changed_by": "demo",
+	}, 200},
+	{"assemblies_components_revise_invalid_lines", "PUT", "/api/assemblies/6/components", map[string]any{
+		"components": []map[string]any{
+			{"component_item_id": 2, "qty_per_unit": 0},
+			{"component_item_id": 999, "qty_per_unit": 1, "alternates": []map[string]any{{"item_id": 998}}},
+			{"component_item_id": 2, "qty_per_unit": 1, "scrap_factor": 1},
+		},
+	}, 400},
+	{"assemblies_components_revise_too_many", "PUT", "/api/assemblies/6/components", map[string]any{
+		"components": []map[string]any{
+			{"component_item_id": 1, "qty_per_unit": 1},
+			{"component_item_id": 2, "qty_per_unit": 1},
+			{"component_item_id": 3, "qty_per_unit": 1},
+			{"component_item_id": 4, "qty_per_unit": 1},
+		},
+	}, 400},
+	{"assemblies_components_delete_invalid_rev", "DELETE", "/api/assemblies/6/components/0", nil, 400},
+	{"assemblies_weight", "GET", "/api/assemblies/6/weight", nil, 200},
+	{"assemblies_weight_missing", "GET", "/api/assemblies/1/weight", nil, 404},
+	{"assemblies_weight_recalculate_unknown", "POST", "/api/assemblies/6/weight/recalculate", map[string]any{"auto": true}, 409},
+	{"bom_replace_component_dry_run", "POST", "/api/bom/replace-component?dry_run=1", map[string]any{"old_item_id": 4, "new_item_id": 3}, 200},
+	{"bom_replace_component_invalid", "POST", "/api/bom/replace-component", map[string]any{"old_item_id": 4, "new_item_id": 4, "qty_factor": 0}, 400},
+	{"bom_templates", "GET", "/api/bom-templates", nil, 200},
+	{"bom_templates_create_from_item", "POST", "/api/bom-templates", map[string]any{"name": "Lamp", "from_item_id": 6}, 201},
+	{"bom_templates_create_invalid", "POST", "/api/bom-templates", map[string]any{"components": []map[string]any{{"component_item_id": 99, "qty_per_unit": 0}}}, 400},
+	{"bom_templates_get_missing", "GET", "/api/bom-templates/99", nil, 404},
+	{"bom_templates_delete_missing", "DELETE", "/api/bom-templates/99", nil, 404},
+	{"assemblies_components_restore_live", "POST", "/api/assemblies/6/components/1/restore", nil, 409},
+	{"purchase_links_restore_missing", "POST", "/api/purchase-links/99/restore", nil, 404},
+	{"trash", "GET", "/api/trash", nil, 200},
+	{"stocktakes_list", "GET", "/api/stocktakes", nil, 200},
+	{"stocktakes_create", "POST", "/api/stocktakes", map[string]any{"name": "Parts shelf", "category": "part", "blind": true}, 201},
+	{"stocktakes_create_invalid", "POST", "/api/stocktakes", map[string]any{"category": "widget"}, 400},
+	{"stocktakes_sheet_csv_missing", "GET", "/api/stocktakes/99/sheet.csv", nil, 404},
+	{"assemblies_bom_csv", "GET", "/api/assemblies/6/bom.csv", nil, 200},
+	{"assemblies_bom_tree_csv", "GET", "/api/assemblies/6/bom-tree.csv?qty=10", nil, 200},
+	{"assemblies_bom_tree_csv_no_bom", "GET", "/api/assemblies/1/bom-tree.csv", nil, 404},
+	{"import_templates_bom", "GET", "/api/import-templates/bom.csv", nil, 200},
+	{"import_templates_unknown", "GET", "/api/import-templates/widgets.csv", nil, 404},
+	{"assemblies_bom_preview_missing", "POST", "/api/assemblies/999/bom/preview", "sku,qty_per_unit\n", 404},
+	{"assemblies_bom_import_empty", "POST", "/api/assemblies/6/bom/import", "", 400},
+	{"assemblies_stock", "GET", "/api/assemblies/stock", nil, 200},
+	{"assemblies_stock_buildable", "GET", "/api/assemblies/stock?include=buildable", nil, 200},
+	{"assemblies_stock_invalid_include", "GET", "/api/assemblies/stock?include=everything", nil, 400},
+	{"assemblies_adjust_in", "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "qty": 2}, 200},
+	{"assemblies_adjust_in_dry_run", "POST", "/api/assemblies/6/adjust?dry_run=1", map[string]any{"direction": "IN", "qty": 2}, 200},
+	{"assemblies_adjust_out_no_reason", "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "OUT", "qty": 1}, 400},
+	{"assemblies_adjust_expr", "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "expr": "3*4 + 2pcs"}, 200},
+	{"assemblies_adjust_expr_invalid", "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "expr": "3*(4+2"}, 400},
+	{"assemblies_adjust_component", "POST", "/api/assemblies/1/adjust", map[string]any{"direction": "IN", "qty": 1}, 400},
+	{"assemblies_picklist", "GET", "/api/assemblies/6/picklist?qty=2", nil, 200},
+	{"assemblies_picklist_commit_bad_json", "POST", "/api/assemblies/6/picklist", "{", 400},
+
+	{"components_stock", "GET", "/api/components/stock", nil, 200},
+	{"stock_summary", "GET", "/api/stock/summary", nil, 200},
+	{"stock_summary_low", "GET", "/api/stock/summary?low=1", nil, 200},
+
+	{"production_parts", "GET", "/api/production/parts", nil, 200},
+	{"production_parts_complete_bad_json", "POST", "/api/production/parts/2/complete", "{", 400},
+	{"production_components", "GET", "/api/production/components", nil, 200},
+	{"production_components_complete_empty", "POST", "/api/production/components/complete", map[string]any{}, 400},
+	{"production_shipments", "GET", "/api/production/shipments/assemblies", nil, 200},
+	{"production_shipments_complete_empty", "POST", "/api/production/shipments/complete", map[string]any{}, 400},
+
+	{"attachments_list", "GET", "/api/items/6/attachments", nil, 200},
+	{"attachments_upload_not_multipart", "POST", "/api/items/6/attachments", map[string]any{}, 400},
+	{"attachments_get_missing", "GET", "/api/attachments/1", nil, 404},
+	{"attachments_delete_missing", "DELETE", "/api/attachments/1", nil, 404},
+
+	{"custom_fields_list", "GET", "/api/custom-fields", nil, 200},
+	{"custom_fields_create_bad_json", "POST", "/api/custom-fields", "{", 400},
+	{"custom_fields_update_bad_json", "PUT", "/api/custom-fields/1", "{", 400},
+	{"custom_fields_delete_missing", "DELETE", "/api/custom-fields/1", nil, 404},
+	{"custom_fields_set_bad_json", "PUT", "/api/items/6/custom-fields", "{", 400},
+
+	{"negative_stock_item", "GET", "/api/items/6/negative-stock-policy", nil, 200},
+	{"negative_stock_item_set_bad_json", "PUT", "/api/items/6/negative-stock-policy", "{", 400},
+	{"negative_stock_setting", "GET", "/api/settings/negative-stock", nil, 200},
+	{"negative_stock_setting_set_bad_json", "PUT", "/api/settings/negative-stock", "{", 400},
+
+	{"eco_setting", "GET", "/api/settings/eco", nil, 200},
+	{"eco_setting_set_bad_json", "PUT", "/api/settings/eco", "{", 400},
+	{"bom_change_note_setting", "GET", "/api/settings/bom-change-note", nil, 200},
+	{"bom_change_note_setting_set", "PUT", "/api/settings/bom-change-note", map[string]any{"required": true}, 200},
+	{"ecos_list", "GET", "/api/ecos", nil, 200},
+	{"ecos_create_bad_json", "POST", "/api/ecos", "{", 400},
+	{"ecos_get_missing", "GET", "/api/ecos/1", nil, 404},
+	{"ecos_assemblies_missing", "GET", "/api/ecos/1/assemblies", nil, 404},
+	{"ecos_change_bad_json", "PUT", "/api/ecos/1/changes/6", "{", 400},
+	{"ecos_change_delete_missing", "DELETE", "/api/ecos/1/changes/6", nil, 404},
+	{"ecos_approve_bad_json", "POST", "/api/ecos/1/approve", "{", 400},
+	{"ecos_cancel_missing", "POST", "/api/ecos/1/cancel", nil, 404},
+
+	{"base_currency", "GET", "/api/settings/base-currency", nil, 200},
+	{"base_currency_set_bad_json", "PUT", "/api/settings/base-currency", "{", 400},
+	{"currencies_list", "GET", "/api/currencies", nil, 200},
+	{"currencies_upsert_bad_json", "PUT", "/api/currencies/USD", "{", 400},
+	{"currencies_delete_base", "DELETE", "/api/currencies/JPY", nil, 409},
+
+	{"landed_costs_list", "GET", "/api/landed-costs", nil, 200},
+	{"landed_costs_create_no_transactions", "POST", "/api/landed-costs", map[string]any{"method": "value"}, 400},
+	{"landed_costs_get_missing", "GET", "/api/landed-costs/1", nil, 404},
+
+	{"suppliers_list", "GET", "/api/suppliers", nil, 200},
+	{"suppliers_create", "POST", "/api/suppliers", map[string]any{"name": "Acme Parts"}, 201},
+	{"suppliers_get_missing", "GET", "/api/suppliers/1", nil, 404},
+	{"suppliers_update_bad_json", "PUT", "/api/suppliers/1", "{", 400},
+	{"suppliers_delete_invalid_id", "DELETE", "/api/suppliers/abc", nil, 400},
+
+	{"offers_list", "GET", "/api/items/1/offers", nil, 200},
+	{"offers_upsert_bad_json", "PUT", "/api/items/1/offers/1", "{", 400},
+	{"offers_delete_missing", "DELETE", "/api/items/1/offers/1", nil, 404},
+	{"offers_best", "GET", "/api/items/1/best-offer", nil, 200},
+
+	{"po_lines_list", "GET", "/api/purchase-orders/lines?status=open", nil, 200},
+	{"po_lines_create", "POST", "/api/purchase-orders/lines", map[string]any{
+		"po_ref": "PO-1001", "item_id": 5, "qty": 500, "expected_date": "2030-01-09",
+	}, 201},
+	{"po_lines_create_invalid", "POST", "/api/purchase-orders/lines", map[string]any{"item_id": 5, "qty": 0, "expected_date": "soon"}, 400},
+	{"po_lines_create_assembly", "POST", "/api/purchase-orders/lines", map[string]any{"po_ref": "PO-1001", "item_id": 6, "qty": 1}, 400},
+	{"po_lines_update_missing", "PUT", "/api/purchase-orders/lines/1", map[string]any{"expected_date": "2030-01-16"}, 404},
+	{"po_lines_cancel_missing", "POST", "/api/purchase-orders/lines/1/cancel", nil, 404},
+
+	{"listings_list", "GET", "/api/items/6/listings", nil, 200},
+	{"listings_upsert_bad_json", "PUT", "/api/items/6/listings/shopify", "{", 400},
+	{"listings_delete_missing", "DELETE", "/api/items/6/listings/shopify", nil, 404},
+	{"shop_sync_status", "GET", "/api/integrations/shop-sync", nil, 200},
+	{"shop_sync_run_unconfigured", "POST", "/api/integrations/shop-sync/run", nil, 503},
+	{"orders_webhook_unconfigured", "POST", "/api/integrations/orders/webhook", map[string]any{}, 503},
+	{"orders_dead_letters", "GET", "/api/integrations/orders/dead-letters", nil, 200},
+	{"orders_dead_letters_retry_invalid_id", "POST", "/api/integrations/orders/dead-letters/abc/retry", nil, 400},
+	{"orders_dead_letters_dismiss_invalid_id", "POST", "/api/integrations/orders/dead-letters/abc/dismiss", nil, 400},
+
+	{"series_list", "GET", "/api/series", nil, 200},
+	{"series_create", "POST", "/api/series", map[string]any{"name": "Demo Fan"}, 201},
+	{"series_create_no_name", "POST", "/api/series", map[string]any{}, 400},
+	{"series_items", "GET", "/api/series/1/items", nil, 200},
+
+	{"skus_patterns", "GET", "/api/skus/patterns", nil, 200},
+	{"skus_patterns_upsert_bad_json", "PUT", "/api/skus/patterns", "{", 400},
+	{"skus_next_bad_json", "POST", "/api/skus/next", "{", 400},
+
+	{"transactions_list", "GET", "/api/transactions", nil, 200},
+	{"activity_bom", "GET", "/api/activity?from=2000-01-01&kind=bom", nil, 200},
+	{"activity_invalid_kind", "GET", "/api/activity?kind=widget", nil, 400},
+	{"activity_invalid_cursor", "GET", "/api/activity?cursor=bogus", nil, 400},
+	{"transactions_note_search", "GET", "/api/transactions?q=DEMO+initial", nil, 200},
+	{"transactions_note_search_wildcard", "GET", "/api/transactions?q=%25", nil, 200},
+	{"transactions_reverse", "POST", "/api/transactions/7/reverse", map[string]any{"note": "returned"}, 201},
+	{"transactions_reverse_missing", "POST", "/api/transactions/999/reverse", nil, 404},
+	{"transactions_correct", "POST", "/api/transactions/7/correct", map[string]any{"qty": 2, "note": "miscounted"}, 201},
+	{"transactions_correct_missing_qty", "POST", "/api/transactions/7/correct", map[string]any{}, 400},
+
+	{"graphql_get_no_query", "GET", graphQLPath, nil, 400},
+	{"graphql_post_bad_json", "POST", graphQLPath, "{", 400},
+
+	{"reason_codes_list", "GET", "/api/reason-codes", nil, 200},
+	{"reason_codes_upsert_bad_json", "PUT", "/api/reason-codes/SCRAP", "{", 400},
+	{"units_list", "GET", "/api/units", nil, 200},
+	{"units_update", "PUT", "/api/units/g", map[string]any{"decimals": 3, "rounding": "round"}, 200},
+	{"units_update_invalid", "PUT", "/api/units/g", map[string]any{"decimals": 7, "rounding": "truncate"}, 400},
+	{"units_update_unknown", "PUT", "/api/units/kg", map[string]any{"decimals": 3, "rounding": "round"}, 404},
+	{"reports_stock_reasons", "GET", "/api/reports/stock-reasons?from=2000-01-01&to=2000-01-31", nil, 200},
+	{"reports_stock_history", "GET", "/api/reports/stock-history?item_id=6", nil, 200},
+	{"reports_incoming", "GET", "/api/reports/incoming?from=2030-01-07&weeks=2", nil, 200},
+	{"reports_builds", "GET", "/api/reports/builds?period=month", nil, 200},
+	{"reports_builds_invalid_period", "GET", "/api/reports/builds?period=year", nil, 400},
+	{"plans_requirements_empty", "POST", "/api/plans/requirements", map[string]any{"lines": []any{}}, 400},
+
+	{"admin_db_check", "GET", "/api/admin/db/check", nil, 200},
+	{"admin_orphans", "GET", "/api/admin/orphans", nil, 200},
+	{"admin_orphans_clean_dry_run", "POST", "/api/admin/orphans?dry_run=1", nil, 200},
+	{"admin_db_maintenance_invalid", "POST", "/api/admin/db/maintenance?vacuum=bogus", nil, 400},
+	{"admin_stock_rebuild_invalid", "POST", "/api/admin/stock/rebuild?repair=2", nil, 400},
+	{"admin_stock_archive_dry_run", "POST", "/api/admin/stock/archive?dry_run=1", map[string]any{"before": "2020-01-01"}, 200},
+	{"admin_stock_archive_invalid", "POST", "/api/admin/stock/archive", map[string]any{"years": 5, "before": "2020-01-01"}, 400},
+	{"admin_read_only", "GET", readOnlyPath, nil, 200},
+	{"admin_read_only_set_bad_json", "PUT", readOnlyPath, "{", 400},
+	{"admin_ledger_append_only", "GET", "/api/admin/ledger/append-only", nil, 200},
+	{"admin_ledger_append_only_missing_enabled", "PUT", "/api/admin/ledger/append-only", map[string]any{}, 400},
+	{"admin_api_keys", "GET", "/api/admin/api-keys", nil, 200},
+	{"admin_api_keys_create_invalid", "POST", "/api/admin/api-keys", map[string]any{"name": "printer", "scope": "root"}, 400},
+	{"admin_api_keys_revoke_missing", "DELETE", "/api/admin/api-keys/99", nil, 404},
+	{"admin_users", "GET", "/api/admin/users", nil, 200},
+	{"admin_users_update_missing", "PUT", "/api/admin/users/99", map[string]any{"role": "admin"}, 404},
+	{"admin_users_update_invalid_role", "PUT", "/api/admin/users/99", map[string]any{"role": "owner"}, 400},
+	{"admin_snapshots_invalid_date", "POST", "/api/admin/snapshots?date=yesterday", nil, 400},
+	{"admin_notifications_test_unconfigured", "POST", "/api/admin/notifications/test", nil, 503},
+	{"admin_notifications_low_stock_unconfigured", "POST", "/api/admin/notifications/low-stock", nil, 503},
+	{"admin_export_invalid_format", "GET", "/api/admin/export?format=tar", nil, 400},
+	{"admin_import_invalid_conflict", "POST", "/api/admin/import?conflict=bogus", "{}", 400},
+}
+
+// apiUncovered lists the routes whose output can't be pinned by a golden
+// file, with the reason.
+var apiUncovered = map[string]string{
+	"GET /version":                       "build info depends on the toolchain",
+	"GET /api/events":                    "streams until the client disconnects",
+	"GET /api/items/{id}/forecast":       "depends on today's date",
+	"GET /api/assemblies/{id}/bom.pdf":   "binary PDF",
+	"GET /api/reports/stock.pdf":         "binary PDF",
+	"GET /api/stocktakes/{id}/sheet.pdf": "binary PDF",
+}
+
+func TestAPI(t *testing.T) {
+	for _, tc := range apiCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestRouter(t)
+			rec := testutil.Do(t, h, tc.method, tc.path, tc.body)
+			if rec.Code != tc.status {
+				t.Fatalf("%s %s: status %d, want %d\n%s", tc.method, tc.path, rec.Code, tc.status, rec.Body)
+			}
+			testutil.Golden(t, tc.name, rec.Body.Bytes())
+		})
+	}
+}
+
+// TestAPIRoutesCovered fails when a route is added without a case in
+// apiCases or an entry in apiUncovered.
+func TestAPIRoutesCovered(t *testing.T) {
+	mux := newTestRouter(t)
+	covered := map[string]bool{}
+	for _, tc := range apiCases {
+		u, err := url.Parse(tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pattern := mux.Find(chi.NewRouteContext(), tc.method, u.Path); pattern != "" {
+			covered[tc.method+" "+pattern] = true
+		} else {
+			t.Errorf("case %s: no route for %s %s", tc.name, tc.method, tc.path)
+		}
+	}
+
+	var missing []string
+	err := chi.Walk(mux, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		key := method + " " + route
+		if _, skip := apiUncovered[key]; skip {
+			if covered[key] {
+				t.Errorf("%s is in apiUncovered but has a case", key)
+			}
+			return nil
+		}
+		if !covered[key] {
+			missing = append(missing, key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("routes without an API case:\n%s", strings.Join(missing, "\n"))
+	}
+}
+
+// TestObsoleteItems checks that an obsolete item keeps its history but can't
+// go into a new BOM revision or supplier offer, and only comes back via eol.
+// TestItemsBySeriesCase checks that series whose names differ only in case
+// stay one group each.
+func TestItemsBySeriesCase(t *testing.T) {
+	conn := testutil.SeededDB(t)
+	h := testRouter(t, conn)
+	for _, q := range []string{
+		`INSERT INTO series(series_id, name) VALUES (10, 'Lamps'), (11, 'lamps')`,
+		`INSERT INTO items(sku, name, item_type, managed_unit, series_id) VALUES
+		  ('L-A', 'a', 'component', 'pcs', 10), ('L-B', 'b', 'component', 'pcs', 11),
+		  ('L-C', 'c', 'component', 'pcs', 10), ('L-D', 'd', 'component', 'pcs', 11)`,
+	} {
+		if _, err := conn.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := testutil.Do(t, h, "GET", "/api/items/by-series", nil)
+	var groups []SeriesGroup
+	if err := json.Unmarshal(rec.Body.Bytes(), &groups); err != nil {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	seen := make(map[int64]int)
+	for _, g := range groups {
+		if g.SeriesID != nil {
+			seen[*g.SeriesID]++
+		}
+	}
+	if seen[10] != 1 || seen[11] != 1 {
+		t.Fatalf("groups per series = %v", seen)
+	}
+}
+
+func TestObsoleteItems(t *testing.T) {
+	h := newTestRouter(t)
+	const led = 3 // PRT-LED, used by ASM-LAMP
+	rec := testutil.Do(t, h, "PUT", "/api/items/3/lifecycle", map[string]any{"status": "obsolete"})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"sku":"ASM-LAMP"`) {
+		t.Fatalf("obsolete: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = testutil.Do(t, h, "PUT", "/api/assemblies/6/components", map[string]any{
+		"components": []map[string]any{{"component_item_id": led, "qty_per_unit": 1}},
+	})
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "components[0].component_item_id") {
+		t.Errorf("revision with obsolete item: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "POST", "/api/suppliers", map[string]any{"name": "Acme Parts"}); rec.Code != http.StatusCreated {
+		t.Fatalf("supplier: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "PUT", "/api/items/3/offers/1", map[string]any{"price": 10}); rec.Code != http.StatusConflict {
+		t.Errorf("offer for obsolete item: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "GET", "/api/items/3/ledger", nil); rec.Code != http.StatusOK {
+		t.Errorf("ledger of obsolete item: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "GET", "/api/items?lifecycle=obsolete", nil); !strings.Contains(rec.Body.String(), `"sku":"PRT-LED"`) {
+		t.Errorf("lifecycle filter: %s", rec.Body)
+	}
+
+	if rec := testutil.Do(t, h, "PUT", "/api/items/3/lifecycle", map[string]any{"status": "active"}); rec.Code != http.StatusConflict {
+		t.Errorf("obsolete -> active: %d, want 409", rec.Code)
+	}
+	if rec := testutil.Do(t, h, "PUT", "/api/items/3/lifecycle", map[string]any{"status": "eol"}); rec.Code != http.StatusOK {
+		t.Errorf("obsolete -> eol: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestPlanOrderRounding checks that a purchase suggestion is rounded up to
+// the offer's MOQ and then to its order multiple.
+func TestPlanOrderRounding(t *testing.T) {
+	h := newTestRouter(t)
+	if rec := testutil.Do(t, h, "POST", "/api/suppliers", map[string]any{"name": "Acme Parts"}); rec.Code != http.StatusCreated {
+		t.Fatalf("supplier: %d %s", rec.Code, rec.Body)
+	}
+	// CNS-SCREW: sold 100 to a bag, at least 150.
+	if rec := testutil.Do(t, h, "PUT", "/api/items/5/offers/1", map[string]any{"price": 1, "moq": 150, "order_multiple": 100}); rec.Code != http.StatusOK {
+		t.Fatalf("offer: %d %s", rec.Code, rec.Body)
+	}
+	// 78 lamps with 3 in stock need 300 screws, 100 beyond the 200 on hand.
+	rec := testutil.Do(t, h, "POST", "/api/plans/requirements", map[string]any{"lines": []map[string]any{{"assembly_id": 6, "qty": 78}}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("plan: %d %s", rec.Code, rec.Body)
+	}
+	var plan struct {
+		Requirements []PlanRequirement `json:"requirements"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &plan); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range plan.Requirements {
+		if req.SKU != "CNS-SCREW" {
+			continue
+		}
+		if req.NetQty != 100 || req.OrderQty != 200 {
+			t.Errorf("CNS-SCREW net %v order %v, want 100 and 200", req.NetQty, req.OrderQty)
+		}
+		return
+	}
+	t.Errorf("no CNS-SCREW requirement in %s", rec.Body)
+}
+
+// TestPurchaseOrderReceipts checks that receipts booked with a PO number
+// fill that order's lines in expected-date order, and that the incoming
+// report buckets the open rest by week.
+func TestPurchaseOrderReceipts(t *testing.T) {
+	h := newTestRouter(t)
+	for _, line := range []map[string]any{
+		{"po_ref": "PO-7", "item_id": 5, "qty": 300, "expected_date": "2030-01-09"},
+		{"po_ref": "PO-7", "item_id": 5, "qty": 100, "expected_date": "2030-01-15"},
+		{"po_ref": "PO-8", "item_id": 5, "qty": 100, "expected_date": "2030-01-10"},
+	} {
+		if rec := testutil.Do(t, h, "POST", "/api/purchase-orders/lines", line); rec.Code != http.StatusCreated {
+			t.Fatalf("create %v: %d %s", line, rec.Code, rec.Body)
+		}
+	}
+	rec := testutil.Do(t, h, "POST", "/api/production/components/complete", map[string]any{
+		"rows": []map[string]any{{"item_id": 5, "qty": 350}}, "ref_id": "PO-7",
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("receive: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = testutil.Do(t, h, "GET", "/api/reports/incoming?from=2030-01-07&weeks=2", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("incoming: %d %s", rec.Code, rec.Body)
+	}
+	var report struct {
+		Weeks []IncomingWeek `json:"weeks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{100, 50} // PO-8 in the first week, the rest of PO-7 in the second
+	for i, wk := range report.Weeks {
+		if len(wk.Items) != 1 || wk.Items[0].Qty != want[i] {
+			t.Errorf("week %s: %+v, want %v of CNS-SCREW", wk.Start, wk.Items, want[i])
+		}
+	}
+}
+
+// TestBuildThroughput checks that the builds report counts completed builds
+// and their consumption, and leaves reversed builds out.
+func TestBuildThroughput(t *testing.T) {
+	h := newTestRouter(t)
+	var ids []string
+	for _, qty := range []int{2, 3} {
+		rec := testutil.Do(t, h, "POST", "/api/production/parts/2/complete", map[string]any{"qty": qty})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("build: %d %s", rec.Code, rec.Body)
+		}
+		var res struct {
+			RefID string `json:"ref_id"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, res.RefID)
+	}
+	// The second build's IN row is numbered after it; reverse it.
+	if rec := testutil.Do(t, h, "POST", "/api/transactions/"+ids[1]+"/reverse", map[string]any{"note": "miscounted"}); rec.Code != http.StatusCreated {
+		t.Fatalf("reverse: %d %s", rec.Code, rec.Body)
+	}
+
+	rec := testutil.Do(t, h, "GET", "/api/reports/builds?item_id=2", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("report: %d %s", rec.Code, rec.Body)
+	}
+	var report struct {
+		Totals []BuildThroughput `json:"totals"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Totals) != 1 || report.Totals[0].SKU != "PRT-SHADE" || report.Totals[0].UnitsBuilt != 2 {
+		t.Fatalf("totals: %+v", report.Totals)
+	}
+	// The second build's consumption was not reversed, so both count.
+	if c := report.Totals[0].Components; len(c) != 1 || c[0].SKU != "MAT-PLA-WHT" || c[0].Qty != 5*85 {
+		t.Errorf("components: %+v", c)
+	}
+}
+
+// TestPickerETag checks that the picker revalidates until stock moves.
+func TestPickerETag(t *testing.T) {
+	h := newTestRouter(t)
+	rec := testutil.Do(t, h, "GET", "/api/items/picker?q=LAMP", nil)
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("picker: %d etag %q", rec.Code, etag)
+	}
+	get := func() int {
+		req := httptest.NewRequest("GET", "/api/items/picker?q=LAMP", nil)
+		req.Header.Set("If-None-Match", etag)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := get(); code != http.StatusNotModified {
+		t.Fatalf("unchanged: %d, want 304", code)
+	}
+	if rec := testutil.Do(t, h, "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "qty": 1}); rec.Code != http.StatusOK {
+		t.Fatalf("adjust: %d %s", rec.Code, rec.Body)
+	}
+	if code := get(); code != http.StatusOK {
+		t.Errorf("after a stock move: %d, want 200", code)
+	}
+}
+
+// TestBodyLimit checks that labelling a body multipart only raises its cap
+// to the upload size.
+func TestBodyLimit(t *testing.T) {
+	h := newTestRouter(t)
+	for _, c := range []struct {
+		contentType string
+		size        int64
+	}{
+		{"application/json", 1<<20 + 1},
+		{"multipart/form-data; boundary=x", maxUploadBytes + 1},
+	} {
+		req := httptest.NewRequest("POST", "/api/bom/replace-component", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", c.contentType)
+		req.ContentLength = c.size
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s of %d bytes: %d %s", c.contentType, c.size, rec.Code, rec.Body)
+		}
+	}
+}
+
+// TestAssemblyBuildable checks the can-make count of the demo lamp: six
+// shades in stock for one per lamp limit it.
+func TestAssemblyBuildable(t *testing.T) {
+	h := newTestRouter(t)
+	rec := testutil.Do(t, h, "GET", "/api/assemblies/stock?include=buildable", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("stock: %d %s", rec.Code, rec.Body)
+	}
+	var rows []ItemStock
+	if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Buildable == nil || *rows[0].Buildable != 6 || rows[0].BuildableLimit != "PRT-SHADE" {
+		t.Fatalf("rows: %s", rec.Body)
+	}
+}
+
+// TestLedgerAppendOnly checks that the append-only guard blocks edits,
+// still lets new rows be stamped and corrected, and survives Migrate.
+func TestLedgerAppendOnly(t *testing.T) {
+	conn := testutil.SeededDB(t)
+	h := testRouter(t, conn)
+	if rec := testutil.Do(t, h, "PUT", "/api/admin/ledger/append-only", map[string]any{"enabled": true}); rec.Code != http.StatusOK {
+		t.Fatalf("enable: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "PUT", "/api/admin/ledger/append-only", map[string]any{"enabled": false}); rec.Code != http.StatusConflict {
+		t.Fatalf("disable: %d %s", rec.Code, rec.Body)
+	}
+
+	rec := testutil.Do(t, h, "POST", "/api/production/components/complete", map[string]any{
+		"rows": []map[string]any{{"item_id": 5, "qty": 10}}, "ref_id": "PO-1",
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("receive: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "POST", "/api/transactions/7/correct", map[string]any{"qty": 2}); rec.Code != http.StatusCreated {
+		t.Fatalf("correct: %d %s", rec.Code, rec.Body)
+	}
+
+	if err := db.Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`DELETE FROM stock_transactions WHERE transaction_id = 7`,
+		`UPDATE stock_transactions SET qty = 3 WHERE transaction_id = 7`,
+		`UPDATE stock_transactions SET ref_id = 'PO-2' WHERE ref_id = 'PO-1'`,
+	} {
+		if _, err := conn.Exec(stmt); err == nil || !strings.Contains(err.Error(), "append-only") {
+			t.Errorf("%s: err %v, want append-only", stmt, err)
+		}
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	h := newTestRouter(t)
+	keys := map[string]string{}
+	for _, scope := range []string{scopeReadOnly, scopeStockWrite} {
+		rec := testutil.Do(t, h, "POST", "/api/admin/api-keys", map[string]any{"name": scope, "scope": scope})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", scope, rec.Code, rec.Body)
+		}
+		var out struct {
+			ID  int64  `json:"id"`
+			Key string `json:"key"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		keys[scope] = out.Key
+	}
+
+	do := func(key, method, path string, body any) int {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, strings.NewReader(string(b)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	adjust := map[string]any{"direction": "IN", "qty": 1}
+	for _, c := range []struct {
+		key, method, path string
+		body              any
+		want              int
+	}{
+		{keys[scopeReadOnly], "GET", "/api/items", nil, http.StatusOK},
+		{keys[scopeReadOnly], "POST", "/api/assemblies/6/adjust", adjust, http.StatusForbidden},
+		{keys[scopeStockWrite], "POST", "/api/assemblies/6/adjust", adjust, http.StatusOK},
+		{keys[scopeStockWrite], "GET", "/api/admin/api-keys", nil, http.StatusForbidden},
+		{keys[scopeStockWrite], "POST", "/api/items/6/bins/transfer", map[string]any{}, http.StatusBadRequest},
+		{keys[scopeStockWrite], "PUT", "/api/items/6", map[string]any{"name": "renamed"}, http.StatusForbidden},
+		{keys[scopeStockWrite], "DELETE", "/api/items/6", nil, http.StatusForbidden},
+		{keys[scopeStockWrite], "PUT", "/api/settings/negative-stock", map[string]any{"policy": "allow"}, http.StatusForbidden},
+		{keys[scopeStockWrite], "PUT", "/api/assemblies/6/components", map[string]any{}, http.StatusForbidden},
+		{"smk_bogus", "GET", "/api/items", nil, http.StatusUnauthorized},
+	} {
+		if got := do(c.key, c.method, c.path, c.body); got != c.want {
+			t.Errorf("%s %s with %.12s: %d, want %d", c.method, c.path, c.key, got, c.want)
+		}
+	}
+
+	for key := range stockWriteRoutes {
+		method, path, _ := strings.Cut(key, " ")
+		if h.Find(chi.NewRouteContext(), method, path) != path {
+			t.Errorf("stockWriteRoutes has %s, which is not a route", key)
+		}
+	}
+
+	if rec := testutil.Do(t, h, "DELETE", "/api/admin/api-keys/1", nil); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", rec.Code, rec.Body)
+	}
+	if got := do(keys[scopeReadOnly], "GET", "/api/items", nil); got != http.StatusUnauthorized {
+		t.Errorf("revoked key: %d, want 401", got)
+	}
+}
+
+// TestSessionRole checks that a signed-in user acts with their role and
+// loses access once disabled.
+func TestSessionRole(t *testing.T) {
+	conn := testutil.SeededDB(t)
+	h := testRouter(t, conn)
+	if _, err := conn.Exec(`INSERT INTO users(issuer, subject, email, role) VALUES('https://idp.example.com', 'u1', 'staff@example.com', 'read-only')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`INSERT INTO sessions(session_hash, user_id, expires_at) VALUES(?, 1, '2999-01-01T00:00:00Z')`, hashAPIKey("tok")); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, strings.NewReader(string(b)))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: "tok"})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := do("GET", "/api/me", nil); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "staff@example.com") {
+		t.Fatalf("me: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "qty": 1}); rec.Code != http.StatusForbidden {
+		t.Fatalf("adjust as read-only: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := testutil.Do(t, h, "PUT", "/api/admin/users/1", map[string]any{"disabled": true}); rec.Code != http.StatusOK {
+		t.Fatalf("disable: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("GET", "/api/me", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("me after disable: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestClaimCreatedUser checks that a user created ahead of sign-in is linked
+// to the account signing in with the same verified email, and only then.
+func TestClaimCreatedUser(t *testing.T) {
+	conn := testutil.SeededDB(t)
+	if _, err := conn.Exec(`INSERT INTO users(issuer, subject, email, role) VALUES('', 'boss@example.com', 'Boss@example.com', 'admin')`); err != nil {
+		t.Fatal(err)
+	}
+	claim := func(claims oidc.Claims) (int64, error) {
+		tx, err := conn.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Commit()
+		id, _, err := claimCreatedUser(context.Background(), tx, "https://idp.example.com", claims)
+		return id, err
+	}
+
+	if _, err := claim(oidc.Claims{"sub": "s1", "email": "BOSS@example.com"}); err != sql.ErrNoRows {
+		t.Fatalf("unverified email: %v", err)
+	}
+	if _, err := claim(oidc.Claims{"sub": "s1", "email": "other@example.com", "email_verified": true}); err != sql.ErrNoRows {
+		t.Fatalf("other email: %v", err)
+	}
+	if id, err := claim(oidc.Claims{"sub": "s1", "email": "BOSS@example.com", "email_verified": true}); err != nil || id != 1 {
+		t.Fatalf("claim = %d, %v", id, err)
+	}
+	if _, err := claim(oidc.Claims{"sub": "s2", "email": "boss@example.com", "email_verified": true}); err != sql.ErrNoRows {
+		t.Fatalf("second account claimed the user: %v", err)
+	}
+	var issuer, subject string
+	if err := conn.QueryRow(`SELECT issuer, subject FROM users WHERE user_id = 1`).Scan(&issuer, &subject); err != nil {
+		t.Fatal(err)
+	}
+	if issuer != "https://idp.example.com" || subject != "s1" {
+		t.Fatalf("linked to %q %q", issuer, subject)
+	}
+}
+
+// TestActivityFeed checks that the feed credits changes to their actor and
+// that paging through it by cursor yields every entry exactly once.
+func TestActivityFeed(t *testing.T) {
+	h := newTestRouter(t)
+	rec := testutil.Do(t, h, "POST", "/api/admin/api-keys", map[string]any{"name": "bot", "scope": scopeStockWrite})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create key: %d %s", rec.Code, rec.Body)
+	}
+	var key struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &key); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/api/assemblies/6/adjust", strings.NewReader(`{"direction":"IN","qty":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", key.Key)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("adjust: %d %s", rec.Code, rec.Body)
+	}
+	rec = testutil.Do(t, h, "POST", "/api/items", map[string]any{
+		"sku": "PRT-KNOB", "name": "Knob", "item_type": "component",
+		"component": map[string]any{"component_type": "part"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create item: %d %s", rec.Code, rec.Body)
+	}
+
+	var page ActivityPage
+	get := func(path string) {
+		t.Helper()
+		rec := testutil.Do(t, h, "GET", path, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", path, rec.Code, rec.Body)
+		}
+		page = ActivityPage{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get("/api/activity?kind=stock&limit=1")
+	if len(page.Entries) != 1 || page.Entries[0].SKU != "ASM-LAMP" || page.Entries[0].Actor != "key:bot" {
+		t.Fatalf("latest stock entry = %+v", page.Entries)
+	}
+
+	get("/api/activity?limit=200")
+	all := page.Entries
+	if !slices.ContainsFunc(all, func(e ActivityEntry) bool {
+		return e.Kind == activityItem && e.SKU == "PRT-KNOB" && e.Summary == "created"
+	}) {
+		t.Fatalf("no entry for the new item in %+v", all)
+	}
+	seen := map[string]bool{}
+	get("/api/activity?limit=3")
+	for {
+		for _, e := range page.Entries {
+			id := e.Kind + ":" + e.Ref
+			if seen[id] {
+				t.Fatalf("%s on two pages", id)
+			}
+			seen[id] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		get("/api/activity?limit=3&cursor=" + page.NextCursor)
+	}
+	if len(seen) != len(all) {
+		t.Fatalf("paged %d entries, want %d", len(seen), len(all))
+	}
+}
+
+// TestTrash checks that deleted revisions and purchase links can be
+// restored until they are purged, and that the purge spares an item's
+// latest revision number.
+func TestTrash(t *testing.T) {
+	conn := testutil.SeededDB(t)
+	h := testRouter(t, conn)
+	revise := map[string]any{"components": []map[string]any{{"component_item_id": 3, "qty_per_unit": 1}}}
+	for i := 0; i < 2; i++ {
+		if rec := testutil.Do(t, h, "PUT", "/api/assemblies/6/components", revise); rec.Code != http.StatusOK {
+			t.Fatalf("revise: %d %s", rec.Code, rec.Body)
+		}
+	}
+	for _, rev := range []string{"2", "3"} {
+		if rec := testutil.Do(t, h, "DELETE", "/api/assemblies/6/components/"+rev, nil); rec.Code != http.StatusNoContent {
+			t.Fatalf("delete rev %s: %d %s", rev, rec.Code, rec.Body)
+		}
+	}
+	if rec := testutil.Do(t, h, "POST", "/api/assemblies/6/components/3/restore", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("restore rev 3: %d %s", rec.Code, rec.Body)
+	}
+
+	if _, err := conn.Exec(`
+INSERT INTO component_purchase_links(component_id, url, sort_order, deleted_at)
+SELECT component_id, 'https://shop.example.com/led', 0, '2000-01-01T00:00:00Z' FROM components WHERE item_id = 3
+`); err != nil {
+		t.Fatal(err)
+	}
+	rec := testutil.Do(t, h, "GET", "/api/trash", nil)
+	var trash Trash
+	if err := json.Unmarshal(rec.Body.Bytes(), &trash); err != nil {
+		t.Fatal(err)
+	}
+	if len(trash.BOMRevisions) != 1 || trash.BOMRevisions[0].RevNo != 2 || len(trash.PurchaseLinks) != 1 {
+		t.Fatalf("trash = %+v", trash)
+	}
+	if rec := testutil.Do(t, h, "POST", fmt.Sprintf("/api/purchase-links/%d/restore", trash.PurchaseLinks[0].ID), nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("restore link: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := testutil.Do(t, h, "DELETE", "/api/assemblies/6/components/3", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete rev 3 again: %d %s", rec.Code, rec.Body)
+	}
+	revisions, links, err := purgeTrash(context.Background(), conn, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revisions != 1 || links != 0 {
+		t.Fatalf("purged %d revisions and %d links, want 1 and 0", revisions, links)
+	}
+	var left []int64
+	rows, err := conn.Query(`SELECT rev_no FROM assembly_records WHERE item_id = 6 ORDER BY rev_no`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		left = append(left, n)
+	}
+	if !slices.Equal(left, []int64{1, 3}) {
+		t.Fatalf("revisions left = %v, want [1 3]", left)
+	}
+}
+
+// TestStocktakeSheet checks that blind count sheets leave out system
+// quantities unless asked for them.
+func TestStocktakeSheet(t *testing.T) {
+	h := newTestRouter(t)
+	rec := testutil.Do(t, h, "POST", "/api/stocktakes", map[string]any{"name": "Parts", "category": "part", "blind": true})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	var st Stocktake
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.LineCount != 3 {
+		t.Fatalf("line_count = %d, want 3 (the demo parts)", st.LineCount)
+	}
+	path := fmt.Sprintf("/api/stocktakes/%d/sheet", st.ID)
+
+	rec = testutil.Do(t, h, "GET", path+".csv", nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "category,sku,name,unit,bin,counted_qty\npart,PRT-CABLE,") {
+		t.Fatalf("blind csv: %d %s", rec.Code, rec.Body)
+	}
+	rec = testutil.Do(t, h, "GET", path+".csv?show_qty=1", nil)
+	if !strings.HasPrefix(rec.Body.String(), "category,sku,name,unit,bin,system_qty,counted_qty\n") {
+		t.Fatalf("csv with quantities: %s", rec.Body)
+	}
+	rec = testutil.Do(t, h, "GET", path+".pdf", nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "%PDF-") {
+		t.Fatalf("pdf: %d %.40s", rec.Code, rec.Body)
+	}
+}
+
+// TestItemBins checks that transfers move stock out of the default bin,
+// which holds whatever the other bins do not, and that pick lists and
+// count sheets name it.
+func TestItemBins(t *testing.T) {
+	h := newTestRouter(t)
+	bins := func(rec *httptest.ResponseRecorder) map[string]ItemBin {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("bins: %d %s", rec.Code, rec.Body)
+		}
+		var list []ItemBin
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string]ItemBin, len(list))
+		for _, b := range list {
+			out[b.BinCode] = b
+		}
+		return out
+	}
+
+	got := bins(testutil.Do(t, h, "PUT", "/api/items/3/bins/A-01", nil))
+	if b := got["A-01"]; !b.IsDefault || b.Qty != 20 {
+		t.Fatalf("first bin = %+v, want the default with all 20", b)
+	}
+	bins(testutil.Do(t, h, "PUT", "/api/items/3/bins/B-02", nil))
+	got = bins(testutil.Do(t, h, "POST", "/api/items/3/bins/transfer", map[string]any{"from_bin": "a-01", "to_bin": "B-02", "qty": 5}))
+	if got["A-01"].Qty != 15 || got["B-02"].Qty != 5 {
+		t.Fatalf("after transfer: %+v", got)
+	}
+	rec := testutil.Do(t, h, "POST", "/api/items/3/bins/transfer", map[string]any{"from_bin": "B-02", "to_bin": "A-01", "qty": 6})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("overdrawn transfer: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "DELETE", "/api/items/3/bins/B-02", nil); rec.Code != http.StatusConflict {
+		t.Fatalf("delete non-empty bin: %d %s", rec.Code, rec.Body)
+	}
+
+	// The old default keeps what it held; the new one takes the rest.
+	got = bins(testutil.Do(t, h, "PUT", "/api/items/3/bins/B-02", map[string]any{"default": true}))
+	if !got["B-02"].IsDefault || got["B-02"].Qty != 5 || got["A-01"].IsDefault || got["A-01"].Qty != 15 {
+		t.Fatalf("after default change: %+v", got)
+	}
+
+	rec = testutil.Do(t, h, "GET", "/api/assemblies/6/picklist?qty=1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("picklist: %d %s", rec.Code, rec.Body)
+	}
+	var pl Picklist
+	if err := json.Unmarshal(rec.Body.Bytes(), &pl); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range pl.Lines {
+		if l.SKU == "PRT-LED" && l.Bin != "B-02" {
+			t.Errorf("picklist bin for PRT-LED = %q, want B-02", l.Bin)
+		}
+	}
+
+	rec = testutil.Do(t, h, "POST", "/api/stocktakes", map[string]any{"name": "Parts", "category": "part"})
+	var st Stocktake
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	rec = testutil.Do(t, h, "GET", fmt.Sprintf("/api/stocktakes/%d/sheet.csv", st.ID), nil)
+	if !strings.Contains(rec.Body.String(), "part,PRT-LED,LED module 5W,pcs,B-02,") {
+		t.Errorf("count sheet: %s", rec.Body)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	h := newTestRouter(t)
+	field := func(rec *httptest.ResponseRecorder, key string) any {
+		t.Helper()
+		var out map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%d %s", rec.Code, rec.Body)
+		}
+		return out[key]
+	}
+
+	rec := testutil.Do(t, h, "POST", "/api/assemblies/6/adjust?dry_run=1", map[string]any{"direction": "IN", "qty": 2})
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Dry-Run") != "1" || field(rec, "stock_qty") != 5.0 {
+		t.Fatalf("dry adjust: %d %q %s", rec.Code, rec.Header().Get("X-Dry-Run"), rec.Body)
+	}
+	rec = testutil.Do(t, h, "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "qty": 1})
+	if got := field(rec, "stock_qty"); got != 4.0 {
+		t.Fatalf("stock after dry run and real IN 1 = %v, want 4", got)
+	}
+
+	revise := map[string]any{"components": []map[string]any{{"component_item_id": 2, "qty_per_unit": 1}}}
+	for _, target := range []string{"/api/assemblies/6/components?dry_run=true", "/api/assemblies/6/components"} {
+		if got := field(testutil.Do(t, h, "PUT", target, revise), "rev_no"); got != 2.0 {
+			t.Fatalf("%s: rev_no = %v, want 2", target, got)
+		}
+	}
+
+	// Rows created by a dry run are reported but not kept, so the name is
+	// still free.
+	rec = testutil.Do(t, h, "POST", "/api/suppliers?dry_run=1", map[string]any{"name": "Acme Parts"})
+	if rec.Code != http.StatusCreated || field(rec, "name") != "Acme Parts" {
+		t.Fatalf("dry supplier: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "POST", "/api/suppliers", map[string]any{"name": "Acme Parts"}); rec.Code != http.StatusCreated {
+		t.Fatalf("supplier after dry run: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "POST", "/api/suppliers?dry_run=1", map[string]any{"name": "Acme Parts"}); rec.Code != http.StatusConflict {
+		t.Fatalf("dry duplicate supplier: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := testutil.Do(t, h, "POST", "/api/admin/notifications/test?dry_run=1", nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unsupported dry run: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "POST", "/api/assemblies/6/adjust?dry_run=maybe", map[string]any{"direction": "IN", "qty": 1}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid dry_run: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	conn := testutil.SeededDB(t)
+	h := testRouter(t, conn, func(cfg *config.Config) { cfg.OutboxWebhookURL = "http://receiver.invalid/hook" })
+
+	// Only the committed change is recorded: not the dry run, not the
+	// rejected request.
+	testutil.Do(t, h, "POST", "/api/assemblies/6/adjust?dry_run=1", map[string]any{"direction": "IN", "qty": 2})
+	testutil.Do(t, h, "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "SIDEWAYS", "qty": 1})
+	if rec := testutil.Do(t, h, "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "qty": 1}); rec.Code != http.StatusOK {
+		t.Fatalf("adjust: %d %s", rec.Code, rec.Body)
+	}
+	var topic, payload string
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*), MAX(topic), MAX(payload) FROM outbox`).Scan(&n, &topic, &payload); err != nil {
+		t.Fatal(err)
+	}
+	var change outboxChange
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || topic != "stock.adjusted" || change.ItemID == nil || *change.ItemID != 6 || change.RequestID == nil {
+		t.Fatalf("outbox = %d %q %s", n, topic, payload)
+	}
+
+	var got []*http.Request
+	status := http.StatusInternalServerError
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+	dispatch := outboxDispatchJob(store.New(conn), notify.NewWebhook(receiver.URL, "s3cret"))
+
+	if err := dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var attempts int
+	var lastError sql.NullString
+	conn.QueryRow(`SELECT attempts, last_error FROM outbox`).Scan(&attempts, &lastError)
+	if len(got) != 1 || attempts != 1 || !lastError.Valid {
+		t.Fatalf("after failed post: %d posts, attempts %d, last_error %v", len(got), attempts, lastError)
+	}
+	// Not due again until the backoff passes.
+	if err := dispatch(context.Background()); err != nil || len(got) != 1 {
+		t.Fatalf("retried before backoff: %d posts, %v", len(got), err)
+	}
+
+	status = http.StatusNoContent
+	if _, err := conn.Exec(`UPDATE outbox SET next_attempt_at = '2000-01-01T00:00:00Z'`); err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1].Header.Get("X-Delivery-ID") != got[0].Header.Get("X-Delivery-ID") || got[1].Header.Get("X-Signature-SHA256") == "" {
+		t.Fatalf("retry: %d posts", len(got))
+	}
+	var delivered sql.NullString
+	conn.QueryRow(`SELECT delivered_at FROM outbox`).Scan(&delivered)
+	if !delivered.Valid {
+		t.Fatal("message not marked delivered")
+	}
+}
+
+// TestConcurrentBOMRevisions revises one BOM over several connections: a
+// request that read the next revision number before another stored it must
+// not store it too, and requests racing each other each get their own
+// number or a 409.
+func TestConcurrentBOMRevisions(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.OpenWith("sqlite:"+filepath.Join(t.TempDir(), "stockmate.db"), db.Options{MaxOpenConns: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := db.Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SeedDemo(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+	h := testRouter(t, conn)
+	revise := map[string]any{"components": []map[string]any{{"component_item_id": 2, "qty_per_unit": 1}}}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var next int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(rev_no), 0) + 1 FROM assembly_records WHERE item_id = 6`).Scan(&next); err != nil {
+		t.Fatal(err)
+	}
+	if rec := testutil.Do(t, h, "PUT", "/api/assemblies/6/components", revise); rec.Code != http.StatusOK {
+		t.Fatalf("revision from another connection: %d %s", rec.Code, rec.Body)
+	}
+	_, _, err = insertBOMRevision(ctx, tx, 6, &BOMRevisionReq{Components: []AssemblyComponent{{ComponentItemID: 2, QtyPerUnit: 1}}})
+	tx.Rollback()
+	if err == nil || revisionErrorStatus(err) != http.StatusConflict {
+		t.Fatalf("storing revision %d again: %v", next, err)
+	}
+
+	const n = 8
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = testutil.Do(t, h, "PUT", "/api/assemblies/6/components", revise).Code
+		}()
+	}
+	wg.Wait()
+	ok := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			ok++
+		case http.StatusConflict:
+		default:
+			t.Fatalf("concurrent revision: status %d", code)
+		}
+	}
+	var revs, distinct, maxRev int
+	if err := conn.QueryRow(`SELECT COUNT(1), COUNT(DISTINCT rev_no), MAX(rev_no) FROM assembly_records WHERE item_id = 6`).Scan(&revs, &distinct, &maxRev); err != nil {
+		t.Fatal(err)
+	}
+	if ok == 0 || revs != ok+2 || distinct != revs || maxRev != revs {
+		t.Fatalf("%d requests succeeded, %d revisions (%d numbers, up to %d)", ok, revs, distinct, maxRev)
+	}
+
+	calls := 0
+	err = retryRevision(func() error {
+		calls++
+		if calls == 1 {
+			return errRevisionConflict
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("retry after a lost race: %v after %d calls", err, calls)
+	}
+	calls = 0
+	err = retryRevision(func() error { calls++; return errRevisionConflict })
+	if !errors.Is(err, errRevisionConflict) || calls != revisionRetries {
+		t.Fatalf("racing throughout: %v after %d calls", err, calls)
+	}
+}
+
+func TestOrphans(t *testing.T) {
+	conn := testutil.SeededDB(t)
+	h := testRouter(t, conn)
+	// Retyped items leave their detail rows behind; an obsolete part stays
+	// on the lamp's BOM.
+	for _, q := range []string{
+		`UPDATE items SET item_type = 'component' WHERE item_id = 6`,
+		`UPDATE items SET item_type = 'assembly' WHERE item_id = 1`,
+		`UPDATE items SET lifecycle_status = 'obsolete' WHERE item_id = 2`,
+	} {
+		if _, err := conn.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := func(rec *httptest.ResponseRecorder) OrphanReport {
+		t.Helper()
+		var rep OrphanReport
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &rep) != nil {
+			t.Fatalf("%d %s", rec.Code, rec.Body)
+		}
+		return rep
+	}
+
+	want := report(testutil.Do(t, h, "GET", "/api/admin/orphans", nil))
+	if want.AssemblyRows != 1 || want.ComponentRows != 1 || want.BOMLinesObsoleteItem != 1 || want.Cleaned {
+		t.Fatalf("report = %+v", want)
+	}
+	got := report(testutil.Do(t, h, "POST", "/api/admin/orphans", nil))
+	want.Cleaned = true
+	if got != want {
+		t.Fatalf("clean = %+v, want %+v", got, want)
+	}
+	got = report(testutil.Do(t, h, "GET", "/api/admin/orphans", nil))
+	if got != (OrphanReport{BOMLinesObsoleteItem: 1}) {
+		t.Fatalf("after clean = %+v", got)
+	}
+}
+
+func TestUnitPrecision(t *testing.T) {
+	h := newTestRouter(t)
+	rec := testutil.Do(t, h, "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "qty": 1.5})
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "whole number of pcs") {
+		t.Fatalf("fractional pcs: %d %s", rec.Code, rec.Body)
+	}
+	rec = testutil.Do(t, h, "PUT", "/api/assemblies/6/components", map[string]any{
+		"components": []map[string]any{{"component_item_id": 3, "qty_per_unit": 0.5}},
+	})
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "components[0].qty_per_unit") {
+		t.Fatalf("fractional pcs bom line: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = testutil.Do(t, h, "POST", "/api/production/components/complete", map[string]any{
+		"rows": []map[string]any{{"item_id": 1, "qty": 10.126}},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("receive grams: %d %s", rec.Code, rec.Body)
+	}
+	rec = testutil.Do(t, h, "GET", "/api/items/1/ledger", nil)
+	var ledger ItemLedger
+	if err := json.Unmarshal(rec.Body.Bytes(), &ledger); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ledger.Entries); n == 0 || ledger.Entries[n-1].Qty != 10.13 {
+		t.Fatalf("received grams not rounded to 2 places: %+v", ledger.Entries)
+	}
+
+	rec = testutil.Do(t, h, "PUT", "/api/units/g", map[string]any{"decimals": 0, "rounding": "reject"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update rule: %d %s", rec.Code, rec.Body)
+	}
+	rec = testutil.Do(t, h, "POST", "/api/production/components/complete", map[string]any{
+		"rows": []map[string]any{{"item_id": 1, "qty": 0.5}},
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("fractional grams under reject: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAdjustExpr(t *testing.T) {
+	conn := testutil.SeededDB(t)
+	h := testRouter(t, conn)
+	if _, err := conn.Exec(`UPDATE items SET pack_qty = 10 WHERE item_id = 6`); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := testutil.Do(t, h, "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "expr": "2 packs + 3x2 - 1"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("adjust: %d %s", rec.Code, rec.Body)
+	}
+	var out struct {
+		Delta float64 `json:"delta"`
+		Expr  QtyExpr `json:"expr"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := []QtyTerm{{"2 packs", 20}, {"3x2", 6}, {"1", -1}}
+	if out.Delta != 25 || !slices.Equal(out.Expr.Terms, want) {
+		t.Fatalf("got delta %v, terms %+v; want 25, %+v", out.Delta, out.Expr.Terms, want)
+	}
+
+	for expr, code := range map[string]int{
+		"2 kg":    http.StatusBadRequest, // not a unit of pcs
+		"1/0":     http.StatusBadRequest,
+		"3 - 5":   http.StatusBadRequest, // below zero for IN
+		"2.5":     http.StatusBadRequest, // fractional pcs
+		"(1+1)*2": http.StatusOK,
+	} {
+		rec := testutil.Do(t, h, "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "expr": expr})
+		if rec.Code != code {
+			t.Errorf("%q: %d %s, want %d", expr, rec.Code, rec.Body, code)
+		}
+	}
+	rec = testutil.Do(t, h, "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "qty": 1, "expr": "1"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("qty and expr: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestItemUsage(t *testing.T) {
+	h := newTestRouter(t)
+	usage := func(id int) ItemUsage {
+		t.Helper()
+		rec := testutil.Do(t, h, "GET", fmt.Sprintf("/api/items/%d", id), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("get item %d: %d %s", id, rec.Code, rec.Body)
+		}
+		var d ItemDetail
+		if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		return d.Usage
+	}
+
+	if u := usage(3); u.UsedInAssemblies != 1 || u.ConsumedRecent != 0 || u.LastMovementAt == nil {
+		t.Fatalf("PRT-LED usage = %+v, want used in ASM-LAMP, nothing consumed, a last movement", u)
+	}
+	// The demo shipment of one lamp is the only consumption; with under a
+	// month of history it is also the monthly average.
+	if u := usage(6); u.UsedInAssemblies != 0 || u.ConsumedRecent != 1 || u.AvgMonthlyConsumption != 1 {
+		t.Fatalf("ASM-LAMP usage = %+v", u)
+	}
+	if rec := testutil.Do(t, h, "POST", "/api/transactions/7/reverse", map[string]any{}); rec.Code != http.StatusCreated {
+		t.Fatalf("reverse: %d %s", rec.Code, rec.Body)
+	}
+	if u := usage(6); u.ConsumedRecent != 0 || u.AvgMonthlyConsumption != 0 {
+		t.Fatalf("reversed shipment still counted: %+v", u)
+	}
+}
+
+func TestLowStockSnooze(t *testing.T) {
+	h := newTestRouter(t)
+	alerts := func(query string) []LowStockAlert {
+		t.Helper()
+		rec := testutil.Do(t, h, "GET", "/api/alerts/low-stock"+query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("alerts: %d %s", rec.Code, rec.Body)
+		}
+		var out []LowStockAlert
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	skus := func(as []LowStockAlert) []string {
+		out := make([]string, 0, len(as))
+		for _, a := range as {
+			out = append(out, a.SKU+":"+a.Severity)
+		}
+		return out
+	}
+
+	// ASM-LAMP has 3 of 5, PRT-CABLE 8 of 10.
+	if got, want := skus(alerts("")), []string{"ASM-LAMP:low", "PRT-CABLE:low"}; !slices.Equal(got, want) {
+		t.Fatalf("alerts = %v, want %v", got, want)
+	}
+	if rec := testutil.Do(t, h, "PUT", "/api/items/6/low-stock-snooze", map[string]any{"note": "discontinuing"}); rec.Code != http.StatusNoContent {
+		t.Fatalf("snooze: %d %s", rec.Code, rec.Body)
+	}
+	if got, want := skus(alerts("")), []string{"PRT-CABLE:low"}; !slices.Equal(got, want) {
+		t.Fatalf("alerts after snooze = %v, want %v", got, want)
+	}
+	all := alerts("?all=1")
+	if len(all) != 2 || all[0].Snooze == nil || all[0].Snooze.Note != "discontinuing" || !all[0].Snooze.Until.IsZero() {
+		t.Fatalf("?all=1 = %+v, want the acknowledged lamp first", all)
+	}
+
+	// Running out is worse than the snoozed severity, so the alert is back.
+	rec := testutil.Do(t, h, "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "OUT", "qty": 3, "reason_code": "sale"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ship: %d %s", rec.Code, rec.Body)
+	}
+	if got, want := skus(alerts("")), []string{"ASM-LAMP:out", "PRT-CABLE:low"}; !slices.Equal(got, want) {
+		t.Fatalf("alerts after running out = %v, want %v", got, want)
+	}
+
+	if rec := testutil.Do(t, h, "PUT", "/api/items/4/low-stock-snooze", map[string]any{"until": "2000-01-01"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("snooze into the past: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "DELETE", "/api/items/6/low-stock-snooze", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("unsnooze: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestReplaceBOMComponent(t *testing.T) {
+	h := newTestRouter(t)
+	replace := func(query string, body map[string]any) ComponentReplaceResult {
+		t.Helper()
+		rec := testutil.Do(t, h, "POST", "/api/bom/replace-component"+query, body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("replace: %d %s", rec.Code, rec.Body)
+		}
+		var out ComponentReplaceResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	lamp := func() AssemblyComponentSet {
+		t.Helper()
+		var set AssemblyComponentSet
+		rec := testutil.Do(t, h, "GET", "/api/assemblies/6/components", nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+			t.Fatal(err)
+		}
+		return set
+	}
+
+	rec := testutil.Do(t, h, "POST", "/api/items", map[string]any{
+		"sku": "PRT-CABLE2", "name": "USB-C cable", "item_type": "component",
+		"component": map[string]any{"component_type": "part"},
+	})
+	var cable2 Item
+	if err := json.Unmarshal(rec.Body.Bytes(), &cable2); err != nil || cable2.ID == 0 {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+
+	body := map[string]any{"old_item_id": 4, "new_item_id": cable2.ID, "qty_factor": 2}
+	dry := replace("?dry_run=1", body)
+	want := []ComponentReplacement{{ItemID: 6, SKU: "ASM-LAMP", FromRevNo: 1, RevNo: 2, OldQty: 1, NewQty: 2}}
+	if !dry.DryRun || !slices.Equal(dry.Assemblies, want) {
+		t.Fatalf("dry run = %+v, want %+v", dry, want)
+	}
+	if set := lamp(); *set.CurrentRevNo != 1 {
+		t.Fatalf("dry run kept revision %d", *set.CurrentRevNo)
+	}
+
+	if got := replace("", body); !slices.Equal(got.Assemblies, want) {
+		t.Fatalf("replace = %+v, want %+v", got, want)
+	}
+	set := lamp()
+	i := slices.IndexFunc(set.Components, func(c AssemblyComponent) bool { return c.ComponentItemID == cable2.ID })
+	if *set.CurrentRevNo != 2 || i < 0 || set.Components[i].QtyPerUnit != 2 || len(set.Components) != 4 {
+		t.Fatalf("revision 2 = %+v", set)
+	}
+
+	// Replacing with an item already on the BOM merges the lines.
+	got := replace("", map[string]any{"old_item_id": cable2.ID, "new_item_id": 3})
+	if len(got.Assemblies) != 1 || !got.Assemblies[0].Merged || got.Assemblies[0].NewQty != 3 {
+		t.Fatalf("merge = %+v", got)
+	}
+	if set := lamp(); len(set.Components) != 3 {
+		t.Fatalf("merged revision has %d lines, want 3", len(set.Components))
+	}
+}
+
+func TestBOMTemplates(t *testing.T) {
+	h := newTestRouter(t)
+	rec := testutil.Do(t, h, "POST", "/api/bom-templates", map[string]any{"name": "Desk lamp", "from_item_id": 6})
+	var tmpl BOMTemplateDetail
+	if err := json.Unmarshal(rec.Body.Bytes(), &tmpl); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("create template: %d %s", rec.Code, rec.Body)
+	}
+	if tmpl.LineCount != 4 || len(tmpl.Components) != 4 || tmpl.Yield != 1 {
+		t.Fatalf("template = %+v", tmpl)
+	}
+	if rec := testutil.Do(t, h, "POST", "/api/bom-templates", map[string]any{"name": "Desk lamp", "from_item_id": 6}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("duplicate name: %d %s", rec.Code, rec.Body)
+	}
+
+	// The variant takes the template's lines, with more screws.
+	rec = testutil.Do(t, h, "POST", "/api/items", map[string]any{
+		"sku": "ASM-LAMP-XL", "name": "Desk lamp XL", "item_type": "assembly",
+		"bom_template_id": tmpl.ID, "bom_qty": map[string]any{"5": 6},
+	})
+	var xl Item
+	if err := json.Unmarshal(rec.Body.Bytes(), &xl); err != nil || xl.ID == 0 {
+		t.Fatalf("create from template: %d %s", rec.Code, rec.Body)
+	}
+	var set AssemblyComponentSet
+	rec = testutil.Do(t, h, "GET", fmt.Sprintf("/api/assemblies/%d/components", xl.ID), nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	qty := make(map[int64]float64)
+	for _, c := range set.Components {
+		qty[c.ComponentItemID] = c.QtyPerUnit
+	}
+	if set.CurrentRevNo == nil || *set.CurrentRevNo != 1 || len(qty) != 4 || qty[5] != 6 || qty[2] != 1 {
+		t.Fatalf("components = %+v", set)
+	}
+
+	rec = testutil.Do(t, h, "POST", "/api/items", map[string]any{
+		"sku": "ASM-LAMP-S", "name": "Desk lamp S", "item_type": "assembly",
+		"bom_template_id": tmpl.ID, "bom_qty": map[string]any{"1": 10},
+	})
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "bom_qty.1") {
+		t.Fatalf("override of a missing line: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := testutil.Do(t, h, "DELETE", fmt.Sprintf("/api/bom-templates/%d", tmpl.ID), nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestItemDeleteReferences checks that purchase order lines, stocktake
+// counts and BOM templates keep their item: they block the delete, and the
+// schema refuses it too.
+func TestItemDeleteReferences(t *testing.T) {
+	conn := testutil.SeededDB(t)
+	h := testRouter(t, conn)
+	newPart := func(sku string) int64 {
+		rec := testutil.Do(t, h, "POST", "/api/items", map[string]any{
+			"sku": sku, "name": sku, "item_type": "component",
+			"component": map[string]any{"component_type": "part"},
+		})
+		var it Item
+		if err := json.Unmarshal(rec.Body.Bytes(), &it); err != nil || it.ID == 0 {
+			t.Fatalf("create %s: %d %s", sku, rec.Code, rec.Body)
+		}
+		return it.ID
+	}
+	del := func(id int64, query string) (int, ItemDependencyReport) {
+		rec := testutil.Do(t, h, "DELETE", fmt.Sprintf("/api/items/%d%s", id, query), nil)
+		var rep ItemDependencyReport
+		if rec.Code == http.StatusConflict {
+			if err := json.Unmarshal(rec.Body.Bytes(), &rep); err != nil {
+				t.Fatalf("delete %d: %s", id, rec.Body)
+			}
+		}
+		return rec.Code, rep
+	}
+
+	ordered := newPart("PRT-ORDERED")
+	rec := testutil.Do(t, h, "POST", "/api/purchase-orders/lines", map[string]any{"po_ref": "PO-9", "item_id": ordered, "qty": 10})
+	var line PurchaseOrderLine
+	if err := json.Unmarshal(rec.Body.Bytes(), &line); err != nil || line.ID == 0 {
+		t.Fatalf("po line: %d %s", rec.Code, rec.Body)
+	}
+	if code, rep := del(ordered, "?force=1"); code != http.StatusConflict || rep.Deletable || rep.Blocking.PurchaseOrderLines != 1 {
+		t.Fatalf("delete with open po line: %d %+v", code, rep)
+	}
+	// A line cancelled before anything arrived goes with the item.
+	if rec := testutil.Do(t, h, "POST", fmt.Sprintf("/api/purchase-orders/lines/%d/cancel", line.ID), nil); rec.Code != http.StatusOK {
+		t.Fatalf("cancel: %d %s", rec.Code, rec.Body)
+	}
+	if code, rep := del(ordered, ""); code != http.StatusConflict || !rep.Deletable || rep.Owned.CancelledPOLines != 1 {
+		t.Fatalf("delete with cancelled po line: %d %+v", code, rep)
+	}
+	if code, _ := del(ordered, "?force=1"); code != http.StatusNoContent {
+		t.Fatalf("forced delete: %d", code)
+	}
+
+	templated := newPart("PRT-TEMPLATED")
+	if rec := testutil.Do(t, h, "POST", "/api/bom-templates", map[string]any{
+		"name": "Kit", "components": []map[string]any{{"component_item_id": templated, "qty_per_unit": 1}},
+	}); rec.Code != http.StatusCreated {
+		t.Fatalf("template: %d %s", rec.Code, rec.Body)
+	}
+	if code, rep := del(templated, "?force=1"); code != http.StatusConflict || rep.Blocking.TemplateLines != 1 {
+		t.Fatalf("delete with template line: %d %+v", code, rep)
+	}
+
+	counted := newPart("PRT-COUNTED")
+	if _, err := conn.Exec(`INSERT INTO stocktakes(stocktake_id, name) VALUES (90, 'Shelf')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`INSERT INTO stocktake_lines(stocktake_id, item_id, system_qty) VALUES (90, ?, 0)`, counted); err != nil {
+		t.Fatal(err)
+	}
+	if code, rep := del(counted, "?force=1"); code != http.StatusConflict || rep.Blocking.StocktakeLines != 1 {
+		t.Fatalf("delete with stocktake line: %d %+v", code, rep)
+	}
+	if _, err := conn.Exec(`DELETE FROM items WHERE item_id = ?`, counted); err == nil {
+		t.Fatal("schema let a counted item be deleted")
+	}
+}
+
+func TestArchiveTransactions(t *testing.T) {
+	conn := testutil.SeededDB(t)
+	h := testRouter(t, conn)
+	st := store.New(conn)
+	ctx := context.Background()
+
+	// The reversal of the lamp shipment is new, so it holds the shipment
+	// back when the demo stock is backdated.
+	if rec := testutil.Do(t, h, "POST", "/api/transactions/7/reverse", map[string]any{}); rec.Code != http.StatusCreated {
+		t.Fatalf("reverse: %d %s", rec.Code, rec.Body)
+	}
+	if _, err := conn.Exec(`UPDATE stock_transactions SET created_at = '2019-06-01T00:00:00Z' WHERE transaction_id <= 7`); err != nil {
+		t.Fatal(err)
+	}
+	want, err := st.LedgerStock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(table string) int {
+		t.Helper()
+		var n int
+		if err := conn.QueryRow(`SELECT COUNT(1) FROM ` + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	archive := func(query string) store.ArchiveReport {
+		t.Helper()
+		rec := testutil.Do(t, h, "POST", "/api/admin/stock/archive"+query, map[string]any{"before": "2020-01-01"})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("archive: %d %s", rec.Code, rec.Body)
+		}
+		var rep store.ArchiveReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &rep); err != nil {
+			t.Fatal(err)
+		}
+		return rep
+	}
+
+	wantRep := store.ArchiveReport{Before: "2020-01-01T00:00:00Z", Archived: 6, OpeningBalances: 6, Held: 1, DryRun: true}
+	if rep := archive("?dry_run=1"); rep != wantRep {
+		t.Fatalf("dry run = %+v, want %+v", rep, wantRep)
+	}
+	if n := count("stock_transactions_archive"); n != 0 {
+		t.Fatalf("dry run archived %d rows", n)
+	}
+
+	wantRep.DryRun = false
+	if rep := archive(""); rep != wantRep {
+		t.Fatalf("archive = %+v, want %+v", rep, wantRep)
+	}
+	if n := count("stock_transactions_archive"); n != 6 {
+		t.Fatalf("archive has %d rows, want 6", n)
+	}
+	got, err := st.LedgerStock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("stock after archiving = %v, want %v", got, want)
+	}
+	var openings int
+	if err := conn.QueryRow(`SELECT COUNT(1) FROM stock_transactions WHERE reason_code = ?`, store.OpeningBalanceReason).Scan(&openings); err != nil {
+		t.Fatal(err)
+	}
+	if openings != 6 {
+		t.Fatalf("%d opening balances, want 6", openings)
+	}
+
+	if rec := testutil.Do(t, h, "PUT", "/api/admin/ledger/append-only", map[string]any{"enabled": true}); rec.Code != http.StatusOK {
+		t.Fatalf("append-only: %d %s", rec.Code, rec.Body)
+	}
+	if rec := testutil.Do(t, h, "POST", "/api/admin/stock/archive", map[string]any{"years": 1}); rec.Code != http.StatusConflict {
+		t.Fatalf("archive when append-only: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestNDJSONExport streams the ledger and the catalogue as one JSON object
+// per line, past the limits of the array responses.
+func TestNDJSONExport(t *testing.T) {
+	h := newTestRouter(t)
+	for range 3 {
+		if rec := testutil.Do(t, h, "POST", "/api/assemblies/6/adjust", map[string]any{"direction": "IN", "qty": 1}); rec.Code != http.StatusOK {
+			t.Fatalf("adjust: %d %s", rec.Code, rec.Body)
+		}
+	}
+	export := func(path string) []map[string]any {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "application/x-ndjson")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", path, rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Fatalf("%s: content type %q", path, ct)
+		}
+		out := make([]map[string]any, 0)
+		for line := range strings.Lines(rec.Body.String()) {
+			var row map[string]any
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatalf("%s: line %q: %v", path, line, err)
+			}
+			out = append(out, row)
+		}
+		return out
+	}
+
+	rows := export("/api/transactions")
+	if len(rows) != 10 {
+		t.Fatalf("%d transactions, want 10", len(rows))
+	}
+	if id := rows[0]["id"]; id != float64(10) {
+		t.Errorf("first transaction %v, want the newest, 10", id)
+	}
+	if rows := export("/api/transactions?item_id=6&limit=2"); len(rows) != 2 {
+		t.Errorf("%d limited transactions, want 2", len(rows))
+	}
+	if rows := export("/api/transactions?type=ADJUST&item_id=1"); len(rows) != 0 {
+		t.Errorf("%d transactions for an empty filter, want 0", len(rows))
+	}
+
+	items := export("/api/items?sort=sku")
+	if len(items) != 6 {
+		t.Fatalf("%d items, want 6", len(items))
+	}
+	if sku := items[0]["sku"]; sku != "ASM-LAMP" {
+		t.Errorf("first item %v, want ASM-LAMP", sku)
+	}
+}
+
+// flushHook runs fn on every flush of a streamed response.
+type flushHook struct {
+	*httptest.ResponseRecorder
+	fn func()
+}
+
+func (f flushHook) Flush() {
+	f.fn()
+	f.ResponseRecorder.Flush()
+}
+
+// TestNDJSONExportPages streams more rows than fit in a page and checks
+// that the pool's one connection is free while the client reads a page.
+func TestNDJSONExportPages(t *testing.T) {
+	conn := testutil.SeededDB(t)
+	conn.SetMaxOpenConns(1)
+	h := testRouter(t, conn, func(c *config.Config) { c.QueryTimeout = 5 * time.Second })
+	for i := range 1200 {
+		if _, err := conn.Exec(`INSERT INTO stock_transactions(item_id, qty, transaction_type) VALUES (5, 1, 'IN')`); err != nil {
+			t.Fatal(err)
+		}
+		if i < 600 {
+			if _, err := conn.Exec(`INSERT INTO items(sku, name, item_type, managed_unit) VALUES (?, 'bulk', 'component', 'pcs')`, fmt.Sprintf("BLK-%04d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	export := func(path string) []map[string]any {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "application/x-ndjson")
+		flushes := 0
+		rec := flushHook{httptest.NewRecorder(), func() {
+			flushes++
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			var n int
+			if err := conn.QueryRowContext(ctx, `SELECT COUNT(1) FROM items`).Scan(&n); err != nil {
+				t.Errorf("%s: query during flush %d: %v", path, flushes, err)
+			}
+		}}
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || flushes < 2 {
+			t.Fatalf("%s: %d after %d flushes", path, rec.Code, flushes)
+		}
+		out := make([]map[string]any, 0)
+		for line := range strings.Lines(rec.Body.String()) {
+			var row map[string]any
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatalf("%s: line %q: %v", path, line, err)
+			}
+			out = append(out, row)
+		}
+		return out
+	}
+
+	rows := export("/api/transactions?fields=id")
+	if len(rows) != 1207 {
+		t.Fatalf("%d transactions, want 1207", len(rows))
+	}
+	for i := 1; i < len(rows); i++ {
+		if rows[i]["id"].(float64) >= rows[i-1]["id"].(float64) {
+			t.Fatalf("row %d: id %v after %v", i, rows[i]["id"], rows[i-1]["id"])
+		}
+	}
+	if rows := export("/api/transactions?limit=700"); len(rows) != 700 {
+		t.Errorf("%d limited transactions, want 700", len(rows))
+	}
+
+	items := export("/api/items?sort=-sku&fields=sku")
+	if len(items) != 606 {
+		t.Fatalf("%d items, want 606", len(items))
+	}
+	if items[0]["sku"] != "PRT-SHADE" || items[len(items)-1]["sku"] != "ASM-LAMP" {
+		t.Errorf("items from %v to %v, want PRT-SHADE to ASM-LAMP", items[0]["sku"], items[len(items)-1]["sku"])
+	}
+	for i := 1; i < len(items); i++ {
+		if strings.ToLower(items[i]["sku"].(string)) > strings.ToLower(items[i-1]["sku"].(string)) {
+			t.Fatalf("item %d: %v after %v", i, items[i]["sku"], items[i-1]["sku"])
+		}
+	}
+}
+
+// TestAssemblyWeight checks that an assembly following its BOM takes the
+// weight of its lines and keeps it as weights and revisions change.
+func TestAssemblyWeight(t *testing.T) {
+	h := newTestRouter(t)
+	weight := func() AssemblyWeight {
+		t.Helper()
+		rec := testutil.Do(t, h, "GET", "/api/assemblies/6/weight", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("weight: %d %s", rec.Code, rec.Body)
+		}
+		var out AssemblyWeight
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	setWeight := func(id int, sku, componentType string, w float64) {
+		t.Helper()
+		rec := testutil.Do(t, h, "PUT", fmt.Sprintf("/api/items/%d", id), map[string]any{
+			"sku": sku, "name": sku, "managed_unit": "pcs", "stock_managed": true, "weight": w,
+			"component": map[string]any{"component_type": componentType},
+		})
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("update %s: %d %s", sku, rec.Code, rec.Body)
+		}
+	}
+
+	if got := weight(); got.Computed != nil || len(got.Missing) != 4 {
+		t.Fatalf("before weights: %+v", got)
+	}
+	setWeight(2, "PRT-SHADE", "part", 90)
+	setWeight(3, "PRT-LED", "part", 20)
+	setWeight(4, "PRT-CABLE", "part", 40)
+	setWeight(5, "CNS-SCREW", "consumable", 1.5)
+
+	rec := testutil.Do(t, h, "POST", "/api/assemblies/6/weight/recalculate", map[string]any{"auto": true})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recalculate: %d %s", rec.Code, rec.Body)
+	}
+	if got := weight(); !got.Auto || got.TotalWeight == nil || *got.TotalWeight != 156 {
+		t.Fatalf("after recalculate: %+v", got)
+	}
+
+	setWeight(3, "PRT-LED", "part", 30)
+	if got := weight(); *got.TotalWeight != 166 {
+		t.Errorf("after a component weight change: %v, want 166", *got.TotalWeight)
+	}
+
+	rec = testutil.Do(t, h, "PUT", "/api/assemblies/6/components", map[string]any{
+		"components": []map[string]any{
+			{"component_item_id": 2, "qty_per_unit": 1},
+			{"component_item_id": 3, "qty_per_unit": 2},
+			{"component_item_id": 5, "qty_per_unit": 6},
+		},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("revise: %d %s", rec.Code, rec.Body)
+	}
+	if got := weight(); *got.TotalWeight != 159 {
+		t.Errorf("after a revision: %v, want 159", *got.TotalWeight)
+	}
+	if rec := testutil.Do(t, h, "DELETE", "/api/assemblies/6/components/2", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete revision: %d %s", rec.Code, rec.Body)
+	}
+	if got := weight(); *got.TotalWeight != 166 {
+		t.Errorf("after deleting the revision: %v, want 166", *got.TotalWeight)
+	}
+}
+
+// TestCompactKeys checks that no list row has two keys with the same
+// compact form, and that compact rows lose their nulls.
+func TestCompactKeys(t *testing.T) {
+	for _, typ := range []reflect.Type{
+		reflect.TypeFor[PickerItem](), reflect.TypeFor[StockSummaryRow](), reflect.TypeFor[Item](),
+		reflect.TypeFor[ItemStock](), reflect.TypeFor[ProductionPart](), reflect.TypeFor[ProductionComponent](),
+		reflect.TypeFor[ShippingAssembly](), reflect.TypeFor[Supplier](), reflect.TypeFor[StockTransaction](),
+	} {
+		seen := map[string]string{}
+		for _, k := range jsonKeys(typ) {
+			short := k
+			if s, ok := compactKeys[k]; ok {
+				short = s
+			}
+			if prev, ok := seen[short]; ok {
+				t.Errorf("%s: %s and %s are both %q", typ.Name(), prev, k, short)
+			}
+			seen[short] = k
+		}
+	}
+
+	fs := fieldSet{compact: true}
+	v, err := fs.project([]Item{{ID: 1, SKU: "X", ManagedUnit: "pcs", Assembly: &AssemblyDetail{Manufacturer: "M"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(v)
+	want := `[{"assembly":{"manufacturer":"M"},"fn":false,"id":1,"lc":"","n":"","s":"X","sl":false,"sm":false,"t":"","u":"pcs"}]`
+	if string(b) != want {
+		t.Errorf("compact = %s\nwant %s", b, want)
+	}
+}
+
+// TestFieldSelection checks that ?fields= leaves only the named keys in
+// array and NDJSON rows.
+func TestFieldSelection(t *testing.T) {
+	h := newTestRouter(t)
+	rec := testutil.Do(t, h, "GET", "/api/items/picker?q=ASM-LAMP&fields=sku,stock_qty", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("picker: %d %s", rec.Code, rec.Body)
+	}
+	var rows []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || len(rows[0]) != 2 || rows[0]["sku"] != "ASM-LAMP" || rows[0]["stock_qty"] != float64(3) {
+		t.Fatalf("picker rows = %v", rows)
+	}
+
+	req := httptest.NewRequest("GET", "/api/transactions?item_id=6&fields=id,qty", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("transactions: %d %s", rec.Code, rec.Body)
+	}
+	for line := range strings.Lines(rec.Body.String()) {
+		var row map[string]any
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatal(err)
+		}
+		if len(row) != 2 || row["id"] == nil || row["qty"] == nil {
+			t.Errorf("transaction row = %v", row)
+		}
+	}
+}
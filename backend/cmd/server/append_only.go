@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"stockmate/internal/db"
+)
+
+func writeLedgerAppendOnly(w http.ResponseWriter, r *http.Request, dbx *sql.DB) {
+	enabled, since, err := db.LedgerAppendOnly(r.Context(), dbx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"enabled":    enabled,
+		"enabled_at": since,
+	})
+}
+
+func getLedgerAppendOnly(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeLedgerAppendOnly(w, r, dbx)
+	}
+}
+
+// setLedgerAppendOnly makes stock_transactions append-only: from then on
+// the database refuses to delete or change ledger rows, and mistakes are
+// fixed with the reverse and correct endpoints. Once on, the mode cannot be
+// turned off.
+func setLedgerAppendOnly(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Enabled *bool `json:"enabled"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if req.Enabled == nil {
+			http.Error(w, "enabled is required", http.StatusBadRequest)
+			return
+		}
+		enabled, _, err := db.LedgerAppendOnly(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !*req.Enabled {
+			if enabled {
+				http.Error(w, "append-only mode cannot be turned off", http.StatusConflict)
+				return
+			}
+			writeLedgerAppendOnly(w, r, dbx)
+			return
+		}
+		if err := db.EnableLedgerAppendOnly(r.Context(), dbx); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeLedgerAppendOnly(w, r, dbx)
+	}
+}
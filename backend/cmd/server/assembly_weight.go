@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+)
+
+// AssemblyWeight is an assembly's total_weight next to the weight its
+// latest BOM revision adds up to.
+type AssemblyWeight struct {
+	ItemID int64 `json:"item_id"`
+	// TotalWeight is the stored value, in grams per unit.
+	TotalWeight *float64 `json:"total_weight"`
+	// Auto is set when total_weight follows the BOM; otherwise it was
+	// entered by hand and is only overwritten on request.
+	Auto bool `json:"auto"`
+	// Computed is the BOM weight, null when the item has no BOM or Missing
+	// is not empty.
+	Computed *float64 `json:"computed"`
+	// Missing lists the SKUs of lines whose weight is unknown.
+	Missing []string `json:"missing"`
+}
+
+// bomWeight adds up the weight of one unit of an assembly from the lines of
+// its latest revision. A line weighs qty_per_unit times its component's
+// unit weight: the quantity itself for gram-managed items, the item's
+// weight, or a sub-assembly's total_weight. Scrap and yield are left out,
+// since they are lost in production rather than shipped.
+func bomWeight(ctx context.Context, q queryer, itemID int64) (weight float64, missing []string, ok bool, err error) {
+	recordID, err := latestBOMRecordID(ctx, q, itemID)
+	if err != nil || recordID == 0 {
+		return 0, nil, false, err
+	}
+	rows, err := q.QueryContext(ctx, `
+SELECT i.sku, ac.qty_per_unit,
+  CASE WHEN i.managed_unit = 'g' THEN 1 ELSE COALESCE(i.weight, a.total_weight) END
+FROM assembly_components ac
+JOIN items i ON i.item_id = ac.component_item_id
+LEFT JOIN assemblies a ON a.item_id = i.item_id
+WHERE ac.record_id = ?
+ORDER BY ac.position, i.sku
+`, recordID)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	defer rows.Close()
+	missing = make([]string, 0)
+	for rows.Next() {
+		var sku string
+		var qty float64
+		var unitWeight sql.NullFloat64
+		if err := rows.Scan(&sku, &qty, &unitWeight); err != nil {
+			return 0, nil, false, err
+		}
+		if !unitWeight.Valid {
+			missing = append(missing, sku)
+			continue
+		}
+		weight += qty * unitWeight.Float64
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, false, err
+	}
+	return weight, missing, len(missing) == 0 && weight > 0, nil
+}
+
+// loadAssemblyWeight reads an assembly's stored and computed weight;
+// sql.ErrNoRows when itemID is not an assembly.
+func loadAssemblyWeight(ctx context.Context, q queryer, itemID int64) (AssemblyWeight, error) {
+	out := AssemblyWeight{ItemID: itemID}
+	var stored sql.NullFloat64
+	if err := q.QueryRowContext(ctx, `SELECT total_weight, total_weight_auto FROM assemblies WHERE item_id = ?`, itemID).Scan(&stored, &out.Auto); err != nil {
+		return out, err
+	}
+	if stored.Valid {
+		out.TotalWeight = &stored.Float64
+	}
+	weight, missing, ok, err := bomWeight(ctx, q, itemID)
+	if err != nil {
+		return out, err
+	}
+	out.Missing = missing
+	if out.Missing == nil {
+		out.Missing = make([]string, 0)
+	}
+	if ok {
+		out.Computed = &weight
+	}
+	return out, nil
+}
+
+// refreshAutoWeights recomputes the total_weight of itemID, if it is an
+// assembly that follows its BOM, and then of every such assembly whose
+// latest revision uses it, up the tree. A weight that cannot be computed is
+// left as it was.
+func refreshAutoWeights(ctx context.Context, tx *sql.Tx, itemID int64) error {
+	seen := map[int64]bool{}
+	queue := []int64{itemID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		var auto bool
+		err := tx.QueryRowContext(ctx, `SELECT total_weight_auto FROM assemblies WHERE item_id = ?`, id).Scan(&auto)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if auto {
+			weight, _, ok, err := bomWeight(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if _, err := tx.ExecContext(ctx, `UPDATE assemblies SET total_weight = ? WHERE item_id = ?`, weight, id); err != nil {
+					return err
+				}
+			}
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+SELECT DISTINCT ar.item_id
+FROM assembly_components ac
+JOIN assembly_records ar ON ar.record_id = ac.record_id
+JOIN assemblies a ON a.item_id = ar.item_id AND a.total_weight_auto = 1
+WHERE ac.component_item_id = ?
+  AND ar.obsolete_at IS NULL
+  AND ar.rev_no = (
+    SELECT MAX(ar2.rev_no) FROM assembly_records ar2
+    WHERE ar2.item_id = ar.item_id AND ar2.obsolete_at IS NULL
+  )
+`, id)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var parentID int64
+			if err := rows.Scan(&parentID); err != nil {
+				rows.Close()
+				return err
+			}
+			queue = append(queue, parentID)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getAssemblyWeight(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		out, err := loadAssemblyWeight(r.Context(), dbx, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "assembly not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// recalculateAssemblyWeight stores the BOM weight as the assembly's
+// total_weight and, with {"auto": true} or false, turns following the BOM
+// on or off; without auto the setting is kept. Parents that follow their
+// BOMs are updated with it.
+func recalculateAssemblyWeight(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Auto *bool `json:"auto"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "bad json", http.StatusBadRequest)
+				return
+			}
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		cur, err := loadAssemblyWeight(r.Context(), tx, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "assembly not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if cur.Computed == nil {
+			msg := "assembly has no bom"
+			if len(cur.Missing) > 0 {
+				msg = "bom lines without weight: " + strings.Join(cur.Missing, ", ")
+			}
+			http.Error(w, msg, http.StatusConflict)
+			return
+		}
+		auto := cur.Auto
+		if req.Auto != nil {
+			auto = *req.Auto
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+UPDATE assemblies SET total_weight = ?, total_weight_auto = ? WHERE item_id = ?
+`, *cur.Computed, auto, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := refreshAutoWeights(r.Context(), tx, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out, err := loadAssemblyWeight(r.Context(), tx, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
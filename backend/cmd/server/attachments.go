@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"stockmate/internal/storage"
+	"stockmate/internal/timeutil"
+)
+
+const maxAttachmentBytes = 20 << 20
+
+// maxUploadBytes caps a whole upload request: one attachment with headroom
+// for the multipart envelope and the note field.
+const maxUploadBytes = maxAttachmentBytes + 1<<20
+
+var attachmentContentTypes = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+type ItemAttachment struct {
+	ID          int64         `json:"id"`
+	ItemID      int64         `json:"item_id"`
+	FileName    string        `json:"file_name"`
+	ContentType string        `json:"content_type"`
+	SizeBytes   int64         `json:"size_bytes"`
+	Note        string        `json:"note,omitempty"`
+	CreatedAt   timeutil.Time `json:"created_at"`
+	URL         string        `json:"url"`
+}
+
+func attachmentURL(id int64) string {
+	return fmt.Sprintf("/api/attachments/%d", id)
+}
+
+func uploadItemAttachment(dbx *sql.DB, store storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		itemID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var exists int
+		if err := dbx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM items WHERE item_id = ?`, itemID).Scan(&exists); err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if exists == 0 {
+			http.Error(w, "item not found", http.StatusNotFound)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "multipart/form-data required", http.StatusBadRequest)
+			return
+		}
+
+		note := ""
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				http.Error(w, "file is required", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				http.Error(w, "bad multipart body", http.StatusBadRequest)
+				return
+			}
+			if part.FormName() == "note" {
+				b, _ := io.ReadAll(io.LimitReader(part, 4096))
+				note = strings.TrimSpace(string(b))
+				continue
+			}
+			if part.FormName() != "file" {
+				continue
+			}
+
+			fileName := filepath.Base(strings.TrimSpace(part.FileName()))
+			if fileName == "" || fileName == "." || fileName == "/" {
+				http.Error(w, "file name is required", http.StatusBadRequest)
+				return
+			}
+
+			br := bufio.NewReaderSize(part, 512)
+			head, _ := br.Peek(512)
+			contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+			ext, ok := attachmentContentTypes[contentType]
+			if !ok {
+				http.Error(w, "unsupported file type: "+contentType, http.StatusUnsupportedMediaType)
+				return
+			}
+
+			keyBytes := make([]byte, 16)
+			if _, err := rand.Read(keyBytes); err != nil {
+				http.Error(w, "failed to generate storage key", http.StatusInternalServerError)
+				return
+			}
+			key := fmt.Sprintf("items/%d/%s%s", itemID, hex.EncodeToString(keyBytes), ext)
+
+			// Spool to a temp file so the size is known before handing off to storage.
+			tmp, err := os.CreateTemp("", "stockmate-upload-*")
+			if err != nil {
+				http.Error(w, "failed to buffer upload", http.StatusInternalServerError)
+				return
+			}
+			defer os.Remove(tmp.Name())
+			defer tmp.Close()
+			size, err := io.Copy(tmp, io.LimitReader(br, maxAttachmentBytes+1))
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "failed to read upload", http.StatusBadRequest)
+				return
+			}
+			if size > maxAttachmentBytes {
+				http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+				http.Error(w, "failed to buffer upload", http.StatusInternalServerError)
+				return
+			}
+			if err := store.Put(r.Context(), key, tmp, size, contentType); err != nil {
+				http.Error(w, "failed to store file", http.StatusInternalServerError)
+				return
+			}
+
+			res, err := dbx.ExecContext(r.Context(), `
+INSERT INTO item_attachments(item_id, file_name, content_type, size_bytes, storage_key, note)
+VALUES(?,?,?,?,?,?)
+`, itemID, fileName, contentType, size, key, note)
+			if err != nil {
+				_ = store.Delete(r.Context(), key)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			attachmentID, _ := res.LastInsertId()
+
+			var out ItemAttachment
+			if err := dbx.QueryRowContext(r.Context(), `
+SELECT attachment_id, item_id, file_name, content_type, size_bytes, COALESCE(note, ''), created_at
+FROM item_attachments
+WHERE attachment_id = ?
+`, attachmentID).Scan(&out.ID, &out.ItemID, &out.FileName, &out.ContentType, &out.SizeBytes, &out.Note, &out.CreatedAt); err != nil {
+				http.Error(w, "failed to load attachment", http.StatusInternalServerError)
+				return
+			}
+			out.URL = attachmentURL(out.ID)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(out)
+			return
+		}
+	}
+}
+
+func listItemAttachments(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		itemID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		rows, err := dbx.QueryContext(r.Context(), `
+SELECT attachment_id, item_id, file_name, content_type, size_bytes, note, created_at
+FROM item_attachments
+WHERE item_id = ?
+ORDER BY attachment_id
+`, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]ItemAttachment, 0)
+		for rows.Next() {
+			var a ItemAttachment
+			var note sql.NullString
+			if err := rows.Scan(&a.ID, &a.ItemID, &a.FileName, &a.ContentType, &a.SizeBytes, &note, &a.CreatedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if note.Valid {
+				a.Note = note.String
+			}
+			a.URL = attachmentURL(a.ID)
+			out = append(out, a)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func downloadAttachment(dbx *sql.DB, store storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		attachmentID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || attachmentID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var fileName, contentType, key string
+		var size int64
+		if err := dbx.QueryRowContext(r.Context(), `
+SELECT file_name, content_type, size_bytes, storage_key
+FROM item_attachments
+WHERE attachment_id = ?
+`, attachmentID).Scan(&fileName, &contentType, &size, &key); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "attachment not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load attachment", http.StatusInternalServerError)
+			return
+		}
+
+		body, err := store.Get(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				http.Error(w, "attachment file missing", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to read attachment", http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+
+		disposition := "inline"
+		if r.URL.Query().Get("download") == "1" {
+			disposition = "attachment"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": fileName}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		_, _ = io.Copy(w, body)
+	}
+}
+
+func deleteAttachment(dbx *sql.DB, store storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		attachmentID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || attachmentID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var key string
+		if err := dbx.QueryRowContext(r.Context(), `SELECT storage_key FROM item_attachments WHERE attachment_id = ?`, attachmentID).Scan(&key); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "attachment not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load attachment", http.StatusInternalServerError)
+			return
+		}
+		if _, err := dbx.ExecContext(r.Context(), `DELETE FROM item_attachments WHERE attachment_id = ?`, attachmentID); err != nil {
+			http.Error(w, "failed to delete attachment", http.StatusInternalServerError)
+			return
+		}
+		if err := store.Delete(r.Context(), key); err != nil {
+			fmt.Println("attachment blob delete failed:", key, err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
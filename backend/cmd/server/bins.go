@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/validate"
+)
+
+// ItemBin is a shelf, drawer or box an item is kept in. Stock moves between
+// an item's bins only by transfer: the other bins hold what was moved into
+// them, and the default bin holds the rest of the on-hand quantity, so
+// receipts and issues are booked against it.
+type ItemBin struct {
+	BinCode   string  `json:"bin_code"`
+	IsDefault bool    `json:"is_default"`
+	Qty       float64 `json:"qty"`
+}
+
+// BinTransfer moves qty of an item from one of its bins to another.
+type BinTransfer struct {
+	FromBin string  `json:"from_bin"`
+	ToBin   string  `json:"to_bin"`
+	Qty     float64 `json:"qty"`
+	Note    string  `json:"note,omitempty"`
+}
+
+const maxBinCodeLen = 32
+
+// defaultBinSelect is the default bin code of item i, for list queries.
+const defaultBinSelect = `(SELECT b.bin_code FROM item_bins b WHERE b.item_id = i.item_id AND b.is_default = 1)`
+
+// loadItemBins lists an item's bins, default first. A default bin can show
+// less than zero after issues that were really taken from another bin.
+func loadItemBins(ctx context.Context, q queryer, itemID int64) ([]ItemBin, error) {
+	rows, err := q.QueryContext(ctx, `
+SELECT bin_code, is_default, qty FROM item_bins WHERE item_id = ?
+ORDER BY is_default DESC, bin_code COLLATE NOCASE
+`, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]ItemBin, 0)
+	placed := 0.0
+	for rows.Next() {
+		var b ItemBin
+		if err := rows.Scan(&b.BinCode, &b.IsDefault, &b.Qty); err != nil {
+			return nil, err
+		}
+		if !b.IsDefault {
+			placed += b.Qty
+		}
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) > 0 && out[0].IsDefault {
+		onHand, err := itemStockQty(ctx, q, itemID)
+		if err != nil {
+			return nil, err
+		}
+		out[0].Qty = onHand - placed
+	}
+	return out, nil
+}
+
+func itemBinParams(r *http.Request) (int64, string, string) {
+	itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || itemID <= 0 {
+		return 0, "", "invalid id"
+	}
+	code := strings.TrimSpace(chi.URLParam(r, "code"))
+	if chi.URLParam(r, "code") != "" && (code == "" || len(code) > maxBinCodeLen) {
+		return 0, "", "invalid bin code"
+	}
+	return itemID, code, ""
+}
+
+func writeItemBins(w http.ResponseWriter, bins []ItemBin) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(bins)
+}
+
+// requireItem answers 404 for an unknown item and reports whether it
+// exists.
+func requireItem(ctx context.Context, w http.ResponseWriter, q queryer, itemID int64) bool {
+	var n int
+	if err := q.QueryRowContext(ctx, `SELECT COUNT(1) FROM items WHERE item_id = ?`, itemID).Scan(&n); err != nil {
+		http.Error(w, "failed to load item", http.StatusInternalServerError)
+		return false
+	}
+	if n == 0 {
+		http.Error(w, "item not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func listItemBins(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, _, problem := itemBinParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		if !requireItem(r.Context(), w, dbx, itemID) {
+			return
+		}
+		bins, err := loadItemBins(r.Context(), dbx, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeItemBins(w, bins)
+	}
+}
+
+// putItemBin adds a bin to an item, or with {"default": true} makes an
+// existing one the default. An item's first bin is its default. The old
+// default keeps what it held, as if it had been moved there.
+func putItemBin(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Default bool `json:"default"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, code, problem := itemBinParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "bad json", http.StatusBadRequest)
+				return
+			}
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if !requireItem(r.Context(), w, tx, itemID) {
+			return
+		}
+		bins, err := loadItemBins(r.Context(), tx, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		makeDefault := req.Default || len(bins) == 0
+		if makeDefault && len(bins) > 0 && bins[0].IsDefault && !strings.EqualFold(bins[0].BinCode, code) {
+			if _, err := tx.ExecContext(r.Context(), `
+UPDATE item_bins SET is_default = 0, qty = ? WHERE item_id = ? AND is_default = 1
+`, max(bins[0].Qty, 0), itemID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO item_bins(item_id, bin_code, is_default) VALUES(?,?,?)
+ON CONFLICT(item_id, bin_code) DO NOTHING
+`, itemID, code, makeDefault); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if makeDefault {
+			// Whatever the new default held is now part of the rest.
+			if _, err := tx.ExecContext(r.Context(), `
+UPDATE item_bins SET is_default = 1, qty = 0 WHERE item_id = ? AND bin_code = ?
+`, itemID, code); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		// Read back on tx, so a dry run shows the bins it would leave.
+		if bins, err = loadItemBins(r.Context(), tx, itemID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		writeItemBins(w, bins)
+	}
+}
+
+// deleteItemBin removes an empty bin. The default bin can only go once it
+// is the item's last.
+func deleteItemBin(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, code, problem := itemBinParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var isDefault bool
+		var qty float64
+		err = tx.QueryRowContext(r.Context(), `
+SELECT is_default, qty FROM item_bins WHERE item_id = ? AND bin_code = ?
+`, itemID, code).Scan(&isDefault, &qty)
+		if err == sql.ErrNoRows {
+			http.Error(w, "bin not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to load bin", http.StatusInternalServerError)
+			return
+		}
+		if isDefault {
+			var others int
+			if err := tx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM item_bins WHERE item_id = ? AND is_default = 0`, itemID).Scan(&others); err != nil {
+				http.Error(w, "failed to load bins", http.StatusInternalServerError)
+				return
+			}
+			if others > 0 {
+				http.Error(w, "bin is the default; make another bin the default first", http.StatusConflict)
+				return
+			}
+		} else if qty > 1e-9 {
+			http.Error(w, fmt.Sprintf("bin still holds %s; transfer it first", formatQty(qty)), http.StatusConflict)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM item_bins WHERE item_id = ? AND bin_code = ?`, itemID, code); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// transferItemBin moves stock between two bins of an item and logs the
+// move. The on-hand quantity is unchanged, so nothing is booked in the
+// ledger.
+func transferItemBin(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, _, problem := itemBinParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		var req BinTransfer
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.FromBin = strings.TrimSpace(req.FromBin)
+		req.ToBin = strings.TrimSpace(req.ToBin)
+		var errs validate.Errors
+		errs.Required("from_bin", req.FromBin)
+		errs.Required("to_bin", req.ToBin)
+		errs.Check(req.FromBin == "" || !strings.EqualFold(req.FromBin, req.ToBin), "to_bin", "must differ from from_bin")
+		errs.Check(req.Qty > 0, "qty", "must be > 0")
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if !requireItem(r.Context(), w, tx, itemID) {
+			return
+		}
+		bins, err := loadItemBins(r.Context(), tx, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		find := func(code string) *ItemBin {
+			for i := range bins {
+				if strings.EqualFold(bins[i].BinCode, code) {
+					return &bins[i]
+				}
+			}
+			return nil
+		}
+		from, to := find(req.FromBin), find(req.ToBin)
+		if from == nil || to == nil {
+			http.Error(w, "bin not found", http.StatusNotFound)
+			return
+		}
+		if req.Qty > from.Qty+1e-9 {
+			http.Error(w, fmt.Sprintf("bin %s holds only %s", from.BinCode, formatQty(max(from.Qty, 0))), http.StatusConflict)
+			return
+		}
+		// The default bin's quantity is derived, so only the others move.
+		for _, m := range []struct {
+			bin  *ItemBin
+			diff float64
+		}{{from, -req.Qty}, {to, req.Qty}} {
+			if m.bin.IsDefault {
+				continue
+			}
+			if _, err := tx.ExecContext(r.Context(), `
+UPDATE item_bins SET qty = MAX(qty + ?, 0) WHERE item_id = ? AND bin_code = ?
+`, m.diff, itemID, m.bin.BinCode); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO bin_transfers(item_id, from_bin, to_bin, qty, note, actor) VALUES(?,?,?,?,?,?)
+`, itemID, from.BinCode, to.BinCode, req.Qty, strings.TrimSpace(req.Note), actorArg(r.Context())); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if bins, err = loadItemBins(r.Context(), tx, itemID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		writeItemBins(w, bins)
+	}
+}
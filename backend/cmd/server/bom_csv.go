@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+	"stockmate/internal/validate"
+)
+
+const maxBOMCSVBytes = 5 << 20
+
+var bomCSVHeader = []string{"sku", "name", "qty_per_unit", "scrap_factor", "refs", "position", "managed_unit", "note"}
+
+// Header aliases for BOM files exported by CAD tools. KiCad writes
+// Reference/Value/Qty, Altium Designator/LibRef/Quantity; the SKU has to be in
+// one of the sku columns (a custom field in the CAD library).
+var (
+	bomSKUColumns        = []string{"sku", "part number", "part_number", "partnumber", "mpn", "manufacturer part number", "libref"}
+	bomQtyColumns        = []string{"qty_per_unit", "qty", "quantity"}
+	bomNoteColumns       = []string{"note", "comment"}
+	bomScrapColumns      = []string{"scrap_factor", "scrap"}
+	bomDesignatorColumns = []string{"refs", "reference", "references", "designator", "designators"}
+	bomPositionColumns   = []string{"position", "pos", "item"}
+)
+
+// normalizeRefs tidies a designator list ("R1, R2 R7") into "R1,R2,R7".
+func normalizeRefs(s string) string {
+	return strings.Join(splitRefs(s), ",")
+}
+
+func splitRefs(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' || r == ' ' || r == '\t' })
+}
+
+// BOMImportLine is one component of an imported BOM, after rows with the
+// same SKU are summed.
+type BOMImportLine struct {
+	SKU         string  `json:"sku"`
+	ItemID      int64   `json:"item_id,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	QtyPerUnit  float64 `json:"qty_per_unit"`
+	ScrapFactor float64 `json:"scrap_factor,omitempty"`
+	// Refs is nil when the file has no designator column.
+	Refs     *string `json:"refs,omitempty"`
+	Position int64   `json:"position"`
+	Note     string  `json:"note,omitempty"`
+}
+
+type BOMDiffLine struct {
+	SKU    string   `json:"sku"`
+	ItemID int64    `json:"item_id"`
+	OldQty *float64 `json:"old_qty,omitempty"`
+	NewQty *float64 `json:"new_qty,omitempty"`
+}
+
+type BOMImportPreview struct {
+	Format    string          `json:"format"`
+	Lines     []BOMImportLine `json:"lines"`
+	BaseRevNo *int64          `json:"base_rev_no,omitempty"`
+	Added     []BOMDiffLine   `json:"added"`
+	Removed   []BOMDiffLine   `json:"removed"`
+	Changed   []BOMDiffLine   `json:"changed"`
+	Unchanged int             `json:"unchanged"`
+	Errors    []string        `json:"errors"`
+	RecordID  *int64          `json:"record_id,omitempty"`
+	RevNo     *int64          `json:"rev_no,omitempty"`
+}
+
+func bomParentID(ctx context.Context, q queryer, r *http.Request) (int64, string, int, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, "invalid id", http.StatusBadRequest, nil
+	}
+	var itemType string
+	if err := q.QueryRowContext(ctx, `SELECT item_type FROM items WHERE item_id = ?`, id).Scan(&itemType); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "item not found", http.StatusNotFound, nil
+		}
+		return 0, "", 0, err
+	}
+	if itemType != "assembly" && itemType != "component" {
+		return 0, "item must be assembly or component", http.StatusBadRequest, nil
+	}
+	return id, "", 0, nil
+}
+
+// exportBOMCSV writes the latest revision, or ?rev_no=, as CSV.
+func exportBOMCSV(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parentID, problem, status, err := bomParentID(r.Context(), dbx, r)
+		if err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		var sku string
+		var revNo, recordID int64
+		query := `
+SELECT i.sku, ar.rev_no, ar.record_id
+FROM assembly_records ar
+JOIN items i ON i.item_id = ar.item_id
+WHERE ar.item_id = ?`
+		args := []any{parentID}
+		if v := strings.TrimSpace(r.URL.Query().Get("rev_no")); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid rev_no", http.StatusBadRequest)
+				return
+			}
+			query += ` AND ar.rev_no = ?`
+			args = append(args, n)
+		} else if v := strings.TrimSpace(r.URL.Query().Get("as_of")); v != "" {
+			asOf, err := parseBOMAsOf(v)
+			if err != nil {
+				http.Error(w, "invalid as_of", http.StatusBadRequest)
+				return
+			}
+			query += ` AND ar.obsolete_at IS NULL AND COALESCE(ar.effective_from, ar.created_at) <= ?`
+			args = append(args, asOf)
+		} else {
+			query += ` AND ar.obsolete_at IS NULL`
+		}
+		query += ` ORDER BY ar.rev_no DESC LIMIT 1`
+		if err := dbx.QueryRowContext(r.Context(), query, args...).Scan(&sku, &revNo, &recordID); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "revision not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load revision", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := dbx.QueryContext(r.Context(), `
+SELECT i.sku, i.name, ac.qty_per_unit, ac.scrap_factor, COALESCE(ac.refs, ''), ac.position, i.managed_unit, COALESCE(ac.note, '')
+FROM assembly_components ac
+JOIN items i ON i.item_id = ac.component_item_id
+WHERE ac.record_id = ?
+ORDER BY ac.position, i.sku
+`, recordID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		var buf bytes.Buffer
+		cw := csv.NewWriter(&buf)
+		cw.Write(bomCSVHeader)
+		for rows.Next() {
+			var compSKU, name, refs, unit, note string
+			var qty, scrap float64
+			var position int64
+			if err := rows.Scan(&compSKU, &name, &qty, &scrap, &refs, &position, &unit, &note); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			cw.Write([]string{compSKU, name, strconv.FormatFloat(qty, 'f', -1, 64), strconv.FormatFloat(scrap, 'f', -1, 64), refs, strconv.FormatInt(position, 10), unit, note})
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cw.Flush()
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-rev%d-bom.csv"`, sku, revNo))
+		_, _ = w.Write(buf.Bytes())
+	}
+}
+
+func findColumn(col map[string]int, names []string) int {
+	for _, n := range names {
+		if i, ok := col[n]; ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseBOMCSV reads a BOM in the native format or a KiCad/Altium export.
+// Lines without a quantity count their designators. Problems are collected
+// per row instead of stopping at the first one.
+func parseBOMCSV(data []byte) (format string, lines []BOMImportLine, problems []string, err error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	cr := csv.NewReader(bytes.NewReader(data))
+	switch {
+	case bytes.Contains(firstLine, []byte("\t")):
+		cr.Comma = '\t'
+	case bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")):
+		cr.Comma = ';'
+	}
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	// Lines starting with # are notes, as in the import template.
+	cr.Comment = '#'
+
+	header, err := cr.Read()
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("read header: %w", err)
+	}
+	col := make(map[string]int, len(header))
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	skuCol := findColumn(col, bomSKUColumns)
+	qtyCol := findColumn(col, bomQtyColumns)
+	noteCol := findColumn(col, bomNoteColumns)
+	scrapCol := findColumn(col, bomScrapColumns)
+	desCol := findColumn(col, bomDesignatorColumns)
+	posCol := findColumn(col, bomPositionColumns)
+	if skuCol < 0 {
+		return "", nil, nil, fmt.Errorf("sku column not found (expected one of %s)", strings.Join(bomSKUColumns, ", "))
+	}
+	if qtyCol < 0 && desCol < 0 {
+		return "", nil, nil, fmt.Errorf("quantity column not found")
+	}
+	format = "csv"
+	if _, ok := col["designator"]; ok {
+		format = "altium"
+	} else if _, ok := col["reference"]; ok {
+		format = "kicad"
+	}
+
+	field := func(rec []string, i int) string {
+		if i < 0 || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+	bySKU := make(map[string]int)
+	for rowNo := 2; ; rowNo++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("row %d: %w", rowNo, err)
+		}
+		sku := field(rec, skuCol)
+		if sku == "" {
+			if strings.Join(rec, "") != "" {
+				problems = append(problems, fmt.Sprintf("row %d: sku is empty", rowNo))
+			}
+			continue
+		}
+		var qty float64
+		if v := field(rec, qtyCol); v != "" {
+			qty, err = strconv.ParseFloat(v, 64)
+			if err != nil || qty <= 0 {
+				problems = append(problems, fmt.Sprintf("row %d: invalid quantity %q", rowNo, v))
+				continue
+			}
+		} else {
+			qty = float64(len(splitRefs(field(rec, desCol))))
+			if qty == 0 {
+				problems = append(problems, fmt.Sprintf("row %d: quantity missing", rowNo))
+				continue
+			}
+		}
+		var scrap float64
+		if v := field(rec, scrapCol); v != "" {
+			scrap, err = strconv.ParseFloat(v, 64)
+			if err != nil || scrap < 0 || scrap >= 1 {
+				problems = append(problems, fmt.Sprintf("row %d: invalid scrap_factor %q (want >= 0 and < 1)", rowNo, v))
+				continue
+			}
+		}
+		var position int64
+		if v := field(rec, posCol); v != "" {
+			position, err = strconv.ParseInt(v, 10, 64)
+			if err != nil || position < 0 {
+				problems = append(problems, fmt.Sprintf("row %d: invalid position %q", rowNo, v))
+				continue
+			}
+		}
+		refs := normalizeRefs(field(rec, desCol))
+		if i, ok := bySKU[sku]; ok {
+			lines[i].QtyPerUnit += qty
+			if lines[i].ScrapFactor != scrap {
+				problems = append(problems, fmt.Sprintf("row %d: scrap_factor differs from an earlier row for %s", rowNo, sku))
+			}
+			if lines[i].Refs != nil && refs != "" {
+				joined := normalizeRefs(*lines[i].Refs + "," + refs)
+				lines[i].Refs = &joined
+			}
+			continue
+		}
+		l := BOMImportLine{SKU: sku, QtyPerUnit: qty, ScrapFactor: scrap, Position: position, Note: field(rec, noteCol)}
+		if desCol >= 0 {
+			l.Refs = &refs
+		}
+		bySKU[sku] = len(lines)
+		lines = append(lines, l)
+	}
+	return format, lines, problems, nil
+}
+
+// previewBOMImport resolves SKUs and diffs the file against the latest
+// revision of parentID.
+func previewBOMImport(ctx context.Context, q queryer, parentID int64, data []byte) (*BOMImportPreview, error) {
+	format, lines, problems, err := parseBOMCSV(data)
+	if err != nil {
+		return nil, err
+	}
+	p := &BOMImportPreview{
+		Format:  format,
+		Lines:   make([]BOMImportLine, 0, len(lines)),
+		Added:   make([]BOMDiffLine, 0),
+		Removed: make([]BOMDiffLine, 0),
+		Changed: make([]BOMDiffLine, 0),
+		Errors:  append(make([]string, 0), problems...),
+	}
+	for _, l := range lines {
+		var status string
+		err := q.QueryRowContext(ctx, `SELECT item_id, name, lifecycle_status FROM items WHERE sku = ?`, l.SKU).Scan(&l.ItemID, &l.Name, &status)
+		if err == sql.ErrNoRows {
+			p.Errors = append(p.Errors, fmt.Sprintf("unknown sku: %s", l.SKU))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if status == lifecycleObsolete {
+			p.Errors = append(p.Errors, fmt.Sprintf("item is obsolete: %s", l.SKU))
+			continue
+		}
+		if l.ItemID == parentID {
+			p.Errors = append(p.Errors, fmt.Sprintf("self reference is not allowed: %s", l.SKU))
+			continue
+		}
+		p.Lines = append(p.Lines, l)
+	}
+
+	current := make(map[int64]BOMDiffLine)
+	var revNo int64
+	rows, err := q.QueryContext(ctx, `
+SELECT ar.rev_no, ac.component_item_id, i.sku, ac.qty_per_unit
+FROM assembly_components ac
+JOIN assembly_records ar ON ar.record_id = ac.record_id
+JOIN items i ON i.item_id = ac.component_item_id
+WHERE ar.record_id = (SELECT record_id FROM assembly_records WHERE item_id = ? AND obsolete_at IS NULL ORDER BY rev_no DESC LIMIT 1)
+`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var d BOMDiffLine
+		var qty float64
+		if err := rows.Scan(&revNo, &d.ItemID, &d.SKU, &qty); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.OldQty = &qty
+		current[d.ItemID] = d
+		p.BaseRevNo = &revNo
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, l := range p.Lines {
+		newQty := l.QtyPerUnit
+		d, ok := current[l.ItemID]
+		if !ok {
+			p.Added = append(p.Added, BOMDiffLine{SKU: l.SKU, ItemID: l.ItemID, NewQty: &newQty})
+			continue
+		}
+		delete(current, l.ItemID)
+		if *d.OldQty == newQty {
+			p.Unchanged++
+			continue
+		}
+		d.NewQty = &newQty
+		p.Changed = append(p.Changed, d)
+	}
+	for _, d := range current {
+		p.Removed = append(p.Removed, d)
+	}
+	sort.Slice(p.Removed, func(i, j int) bool { return p.Removed[i].SKU < p.Removed[j].SKU })
+	return p, nil
+}
+
+func readBOMBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBOMCSVBytes)
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "bom file too large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		http.Error(w, "bom file is empty", http.StatusBadRequest)
+		return nil, false
+	}
+	return data, true
+}
+
+// previewBOMCSV shows what importing the posted CSV would change, without
+// saving anything.
+func previewBOMCSV(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parentID, problem, status, err := bomParentID(r.Context(), dbx, r)
+		if err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		data, ok := readBOMBody(w, r)
+		if !ok {
+			return
+		}
+		p, err := previewBOMImport(r.Context(), dbx, parentID, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(p)
+	}
+}
+
+// importBOMCSV saves the posted CSV as a new revision. Any error in the file
+// rejects the whole import with the preview in the response. ?effective_from=
+// delays the new revision.
+func importBOMCSV(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, ok := readBOMBody(w, r)
+		if !ok {
+			return
+		}
+		var effectiveFrom string
+		if v := strings.TrimSpace(r.URL.Query().Get("effective_from")); v != "" {
+			ts, err := timeutil.Normalize(v)
+			if err != nil {
+				http.Error(w, "effective_from must be a date or RFC3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			effectiveFrom = ts
+		}
+		rev := BOMRevisionReq{
+			EffectiveFrom: effectiveFrom,
+			ChangeNote:    r.URL.Query().Get("change_note"),
+			ChangedBy:     r.URL.Query().Get("changed_by"),
+		}
+		noteRequired, err := changeNoteRequired(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, "failed to load setting", http.StatusInternalServerError)
+			return
+		}
+		var errs validate.Errors
+		checkChangeNote(&errs, &rev, noteRequired)
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+		if required, err := ecoRequired(r.Context(), dbx); err != nil {
+			http.Error(w, "failed to load setting", http.StatusInternalServerError)
+			return
+		} else if required {
+			http.Error(w, ecoRequiredMessage, http.StatusConflict)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		parentID, problem, status, err := bomParentID(r.Context(), tx, r)
+		if err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		p, err := previewBOMImport(r.Context(), tx, parentID, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(p.Lines) == 0 && len(p.Errors) == 0 {
+			p.Errors = append(p.Errors, "components are required")
+		}
+		if len(p.Errors) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(p)
+			return
+		}
+
+		componentIDs := make([]int64, 0, len(p.Lines))
+		rev.Components = make([]AssemblyComponent, 0, len(p.Lines))
+		for _, l := range p.Lines {
+			componentIDs = append(componentIDs, l.ItemID)
+			rev.Components = append(rev.Components, AssemblyComponent{ComponentItemID: l.ItemID, QtyPerUnit: l.QtyPerUnit, ScrapFactor: l.ScrapFactor, Refs: l.Refs, Position: l.Position, Note: l.Note})
+		}
+		if cycleVia, found, err := findBOMCycle(r.Context(), tx, parentID, componentIDs); err != nil {
+			http.Error(w, "failed to check bom cycles", http.StatusInternalServerError)
+			return
+		} else if found {
+			http.Error(w, fmt.Sprintf("bom cycle detected: component %d already contains item %d", cycleVia, parentID), http.StatusBadRequest)
+			return
+		}
+		recordID, revNo, err := insertBOMRevision(r.Context(), tx, parentID, &rev)
+		if err != nil {
+			http.Error(w, err.Error(), revisionErrorStatus(err))
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		p.RecordID = &recordID
+		p.RevNo = &revNo
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(p)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"stockmate/internal/store"
+	"stockmate/internal/validate"
+)
+
+// Each BOM revision can carry a change note and its author, so the reason
+// for a revision can still be found long after it was made.
+const bomChangeNoteSettingKey = "bom_require_change_note"
+
+const (
+	maxChangeNoteLen = 2000
+	maxChangedByLen  = 100
+)
+
+// changeNoteRequired reports whether new BOM revisions must have a change
+// note.
+func changeNoteRequired(ctx context.Context, q queryer) (bool, error) {
+	var v string
+	err := q.QueryRowContext(ctx, `SELECT value FROM app_settings WHERE key = ?`, bomChangeNoteSettingKey).Scan(&v)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return v == "1", nil
+}
+
+// checkChangeNote trims the note and author of a revision and checks their
+// length, and that the note is present when required.
+func checkChangeNote(errs *validate.Errors, req *BOMRevisionReq, required bool) {
+	req.ChangeNote = strings.TrimSpace(req.ChangeNote)
+	req.ChangedBy = strings.TrimSpace(req.ChangedBy)
+	if required {
+		errs.Required("change_note", req.ChangeNote)
+	}
+	errs.Check(utf8.RuneCountInString(req.ChangeNote) <= maxChangeNoteLen, "change_note", "is too long")
+	errs.Check(utf8.RuneCountInString(req.ChangedBy) <= maxChangedByLen, "changed_by", "is too long")
+}
+
+func getChangeNoteSetting(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		required, err := changeNoteRequired(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, "failed to load setting", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"required": required})
+	}
+}
+
+func setChangeNoteSetting(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Required bool `json:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		v := "0"
+		if req.Required {
+			v = "1"
+		}
+		if _, err := store.Exec(r.Context(), dbx, `
+INSERT INTO app_settings(key, value) VALUES(?, ?)
+ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+`, bomChangeNoteSettingKey, v); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"required": req.Required})
+	}
+}
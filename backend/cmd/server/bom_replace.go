@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+	"stockmate/internal/validate"
+)
+
+// loadBOMComponents reads the lines of a BOM revision with their
+// alternates, in position order.
+func loadBOMComponents(ctx context.Context, q queryer, recordID int64) ([]AssemblyComponent, error) {
+	rows, err := q.QueryContext(ctx, `
+SELECT
+  ac.component_item_id,
+  i.sku,
+  i.name,
+  i.item_type,
+  i.managed_unit,
+  ac.qty_per_unit,
+  ac.scrap_factor,
+  ac.refs,
+  ac.position,
+  ac.note
+FROM assembly_components ac
+JOIN items i ON i.item_id = ac.component_item_id
+WHERE ac.record_id = ?
+ORDER BY ac.position, i.sku
+`, recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]AssemblyComponent, 0)
+	for rows.Next() {
+		var row AssemblyComponent
+		var refs, note sql.NullString
+		if err := rows.Scan(
+			&row.ComponentItemID,
+			&row.SKU,
+			&row.Name,
+			&row.ItemType,
+			&row.ManagedUnit,
+			&row.QtyPerUnit,
+			&row.ScrapFactor,
+			&refs,
+			&row.Position,
+			&note,
+		); err != nil {
+			return nil, err
+		}
+		if refs.Valid && refs.String != "" {
+			row.Refs = &refs.String
+		}
+		if note.Valid {
+			row.Note = note.String
+		}
+		out = append(out, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	alts, err := loadBOMAlternates(ctx, q, recordID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Alternates = alts[out[i].ComponentItemID]
+	}
+	return out, nil
+}
+
+// ComponentReplacement is one assembly revised by a component replace.
+type ComponentReplacement struct {
+	ItemID    int64  `json:"item_id"`
+	SKU       string `json:"sku"`
+	FromRevNo int64  `json:"from_rev_no"`
+	// RevNo is the new revision, or the one a dry run would create.
+	RevNo  int64   `json:"rev_no"`
+	OldQty float64 `json:"old_qty"`
+	NewQty float64 `json:"new_qty"`
+	// Merged is set when the BOM already listed the new item, whose line
+	// then takes the old line's quantity on top of its own.
+	Merged bool `json:"merged,omitempty"`
+}
+
+type ComponentReplaceResult struct {
+	OldItemID  int64                  `json:"old_item_id"`
+	NewItemID  int64                  `json:"new_item_id"`
+	QtyFactor  float64                `json:"qty_factor"`
+	DryRun     bool                   `json:"dry_run"`
+	Assemblies []ComponentReplacement `json:"assemblies"`
+}
+
+// replaceBOMComponent swaps one component for another in the latest BOM
+// revision of every item that lists it as a line, as a new revision each,
+// scaling the quantity by qty_factor. Alternates are left alone. With
+// ?dry_run=1 the revisions are validated and listed but not kept. The lines
+// come from stored revisions, so BOM_MAX_COMPONENTS, which caps request
+// payloads, does not apply.
+func replaceBOMComponent(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		OldItemID int64 `json:"old_item_id"`
+		NewItemID int64 `json:"new_item_id"`
+		// QtyFactor defaults to 1.
+		QtyFactor     *float64 `json:"qty_factor"`
+		EffectiveFrom string   `json:"effective_from"`
+		// ChangeNote defaults to "Replace <old SKU> with <new SKU>".
+		ChangeNote string `json:"change_note"`
+		ChangedBy  string `json:"changed_by"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun, ok := queryDryRun(r)
+		if !ok {
+			http.Error(w, "invalid dry_run", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		factor := 1.0
+		if req.QtyFactor != nil {
+			factor = *req.QtyFactor
+		}
+		var errs validate.Errors
+		errs.Check(req.OldItemID > 0, "old_item_id", "must be > 0")
+		errs.Check(req.NewItemID > 0, "new_item_id", "must be > 0")
+		errs.Check(req.OldItemID != req.NewItemID, "new_item_id", "must differ from old_item_id")
+		errs.Check(factor > 0, "qty_factor", "must be > 0")
+		if v := strings.TrimSpace(req.EffectiveFrom); v != "" {
+			if ts, err := timeutil.Normalize(v); err != nil {
+				errs.Add("effective_from", "must be a date or RFC3339 timestamp")
+			} else {
+				req.EffectiveFrom = ts
+			}
+		}
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+		if !dryRun {
+			if required, err := ecoRequired(r.Context(), dbx); err != nil {
+				http.Error(w, "failed to load setting", http.StatusInternalServerError)
+				return
+			} else if required {
+				http.Error(w, ecoRequiredMessage, http.StatusConflict)
+				return
+			}
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var oldSKU, newSKU string
+		for _, it := range []struct {
+			id  int64
+			sku *string
+		}{{req.OldItemID, &oldSKU}, {req.NewItemID, &newSKU}} {
+			err := tx.QueryRowContext(r.Context(), `SELECT sku FROM items WHERE item_id = ?`, it.id).Scan(it.sku)
+			if err == sql.ErrNoRows {
+				http.Error(w, fmt.Sprintf("item not found: %d", it.id), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, "failed to load item", http.StatusInternalServerError)
+				return
+			}
+		}
+		if strings.TrimSpace(req.ChangeNote) == "" {
+			req.ChangeNote = fmt.Sprintf("Replace %s with %s", oldSKU, newSKU)
+		}
+
+		type target struct {
+			ComponentReplacement
+			recordID int64
+		}
+		rows, err := tx.QueryContext(r.Context(), `
+SELECT ar.item_id, i.sku, ar.rev_no, ar.record_id, ac.qty_per_unit
+FROM assembly_components ac
+JOIN assembly_records ar ON ar.record_id = ac.record_id
+JOIN items i ON i.item_id = ar.item_id
+WHERE ac.component_item_id = ?
+  AND ar.obsolete_at IS NULL
+  AND ar.rev_no = (
+    SELECT MAX(ar2.rev_no) FROM assembly_records ar2
+    WHERE ar2.item_id = ar.item_id AND ar2.obsolete_at IS NULL
+  )
+ORDER BY i.sku
+`, req.OldItemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		targets := make([]target, 0)
+		for rows.Next() {
+			var t target
+			if err := rows.Scan(&t.ItemID, &t.SKU, &t.FromRevNo, &t.recordID, &t.OldQty); err != nil {
+				rows.Close()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			targets = append(targets, t)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rows.Close()
+
+		out := ComponentReplaceResult{
+			OldItemID:  req.OldItemID,
+			NewItemID:  req.NewItemID,
+			QtyFactor:  factor,
+			DryRun:     dryRun,
+			Assemblies: make([]ComponentReplacement, 0, len(targets)),
+		}
+		for _, t := range targets {
+			lines, err := loadBOMComponents(r.Context(), tx, t.recordID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			rev := BOMRevisionReq{
+				Components:    make([]AssemblyComponent, 0, len(lines)),
+				EffectiveFrom: req.EffectiveFrom,
+				ChangeNote:    req.ChangeNote,
+				ChangedBy:     req.ChangedBy,
+			}
+			t.NewQty = t.OldQty * factor
+			existing := slices.IndexFunc(lines, func(c AssemblyComponent) bool { return c.ComponentItemID == req.NewItemID })
+			for _, c := range lines {
+				// Alternates are carried over explicitly, since the
+				// replaced line has no previous revision to keep them from.
+				if c.Alternates == nil {
+					c.Alternates = []BOMAlternate{}
+				}
+				switch c.ComponentItemID {
+				case req.OldItemID:
+					if existing >= 0 {
+						continue
+					}
+					c.ComponentItemID = req.NewItemID
+					c.QtyPerUnit = t.NewQty
+					c.Alternates = slices.DeleteFunc(c.Alternates, func(a BOMAlternate) bool { return a.ItemID == req.NewItemID })
+				case req.NewItemID:
+					t.Merged = true
+					c.QtyPerUnit += t.NewQty
+					t.NewQty = c.QtyPerUnit
+					c.Alternates = slices.DeleteFunc(c.Alternates, func(a BOMAlternate) bool { return a.ItemID == req.OldItemID })
+				}
+				rev.Components = append(rev.Components, c)
+			}
+
+			errs, problem, _, err := validateBOMRevision(r.Context(), tx, t.ItemID, &rev, 0)
+			if err != nil {
+				http.Error(w, "failed to validate bom", http.StatusInternalServerError)
+				return
+			}
+			if problem == "" && !errs.Empty() {
+				problem = errs.Error()
+			}
+			if problem != "" {
+				http.Error(w, t.SKU+": "+problem, http.StatusBadRequest)
+				return
+			}
+			_, t.RevNo, problem, err = applyBOMRevision(r.Context(), tx, t.ItemID, &rev)
+			if err != nil {
+				http.Error(w, t.SKU+": "+err.Error(), revisionErrorStatus(err))
+				return
+			}
+			if problem != "" {
+				http.Error(w, t.SKU+": "+problem, http.StatusBadRequest)
+				return
+			}
+			out.Assemblies = append(out.Assemblies, t.ComponentReplacement)
+		}
+
+		if !dryRun {
+			if err := store.Commit(r.Context(), tx); err != nil {
+				http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+	"stockmate/internal/validate"
+)
+
+// BOMTemplate is a named BOM structure that new items can start from, so a
+// family of similar products need not be entered line by line.
+type BOMTemplate struct {
+	ID        int64         `json:"id"`
+	Name      string        `json:"name"`
+	Note      string        `json:"note,omitempty"`
+	Yield     float64       `json:"yield"`
+	LineCount int64         `json:"line_count"`
+	CreatedAt timeutil.Time `json:"created_at"`
+}
+
+// BOMTemplateDetail is a template with its lines. Lines carry no refs or
+// alternates, which belong to a product rather than a family.
+type BOMTemplateDetail struct {
+	BOMTemplate
+	Components []AssemblyComponent `json:"components"`
+}
+
+const bomTemplateSelect = `
+SELECT t.template_id, t.name, COALESCE(t.note, ''), t.yield,
+  (SELECT COUNT(1) FROM bom_template_lines l WHERE l.template_id = t.template_id),
+  t.created_at
+FROM bom_templates t
+`
+
+func scanBOMTemplate(row interface{ Scan(...any) error }) (BOMTemplate, error) {
+	var t BOMTemplate
+	err := row.Scan(&t.ID, &t.Name, &t.Note, &t.Yield, &t.LineCount, &t.CreatedAt)
+	return t, err
+}
+
+// loadBOMTemplate returns a template with its lines; sql.ErrNoRows when
+// there is none.
+func loadBOMTemplate(ctx context.Context, q queryer, templateID int64) (BOMTemplateDetail, error) {
+	var out BOMTemplateDetail
+	t, err := scanBOMTemplate(q.QueryRowContext(ctx, bomTemplateSelect+` WHERE t.template_id = ?`, templateID))
+	if err != nil {
+		return out, err
+	}
+	out.BOMTemplate = t
+
+	rows, err := q.QueryContext(ctx, `
+SELECT l.component_item_id, i.sku, i.name, i.item_type, i.managed_unit,
+  l.qty_per_unit, l.scrap_factor, l.position, COALESCE(l.note, '')
+FROM bom_template_lines l
+JOIN items i ON i.item_id = l.component_item_id
+WHERE l.template_id = ?
+ORDER BY l.position, i.sku
+`, templateID)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	out.Components = make([]AssemblyComponent, 0)
+	for rows.Next() {
+		var c AssemblyComponent
+		if err := rows.Scan(&c.ComponentItemID, &c.SKU, &c.Name, &c.ItemType, &c.ManagedUnit,
+			&c.QtyPerUnit, &c.ScrapFactor, &c.Position, &c.Note); err != nil {
+			return out, err
+		}
+		out.Components = append(out.Components, c)
+	}
+	return out, rows.Err()
+}
+
+func listBOMTemplates(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := dbx.QueryContext(r.Context(), bomTemplateSelect+` ORDER BY t.name COLLATE NOCASE, t.template_id`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]BOMTemplate, 0)
+		for rows.Next() {
+			t, err := scanBOMTemplate(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, t)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func getBOMTemplate(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		out, err := loadBOMTemplate(r.Context(), dbx, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "template not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// createBOMTemplate saves a template from the given lines or, with
+// from_item_id, from the latest BOM revision of an existing item.
+func createBOMTemplate(dbx *sql.DB, maxComponents int) http.HandlerFunc {
+	type Req struct {
+		Name string `json:"name"`
+		Note string `json:"note"`
+		// FromItemID copies the lines and yield of the item's latest
+		// revision; it excludes components and yield.
+		FromItemID *int64              `json:"from_item_id"`
+		Components []AssemblyComponent `json:"components"`
+		// Yield defaults to 1.
+		Yield *float64 `json:"yield"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		req.Note = strings.TrimSpace(req.Note)
+
+		var errs validate.Errors
+		errs.Required("name", req.Name)
+		if req.FromItemID != nil {
+			errs.Check(len(req.Components) == 0, "components", "must be empty with from_item_id")
+			errs.Check(req.Yield == nil, "yield", "must be empty with from_item_id")
+		}
+		errs.Check(req.Yield == nil || (*req.Yield > 0 && *req.Yield <= 1), "yield", "must be > 0 and <= 1")
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		yield := 1.0
+		if req.Yield != nil {
+			yield = *req.Yield
+		}
+		if req.FromItemID != nil {
+			var exists int
+			if err := tx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM items WHERE item_id = ?`, *req.FromItemID).Scan(&exists); err != nil {
+				http.Error(w, "failed to load item", http.StatusInternalServerError)
+				return
+			}
+			if exists == 0 {
+				http.Error(w, "item not found", http.StatusNotFound)
+				return
+			}
+			recordID, err := latestBOMRecordID(r.Context(), tx, *req.FromItemID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if recordID == 0 {
+				http.Error(w, "item has no bom", http.StatusConflict)
+				return
+			}
+			if req.Components, err = loadBOMComponents(r.Context(), tx, recordID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if yield, err = latestBOMYield(r.Context(), tx, *req.FromItemID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		for i := range req.Components {
+			req.Components[i].Refs = nil
+			req.Components[i].Alternates = nil
+		}
+		// Only lines sent in the request count against BOM_MAX_COMPONENTS.
+		limit := maxComponents
+		if req.FromItemID != nil {
+			limit = 0
+		}
+		if err := validateBOMLines(r.Context(), tx, 0, "components", req.Components, limit, &errs); err != nil {
+			http.Error(w, "failed to validate bom", http.StatusInternalServerError)
+			return
+		}
+		if !errs.Has("name") {
+			var taken int
+			if err := tx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM bom_templates WHERE name = ?`, req.Name).Scan(&taken); err != nil {
+				http.Error(w, "failed to check name", http.StatusInternalServerError)
+				return
+			}
+			errs.Check(taken == 0, "name", "is already used")
+		}
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		var note any
+		if req.Note != "" {
+			note = req.Note
+		}
+		res, err := tx.ExecContext(r.Context(), `INSERT INTO bom_templates(name, note, yield) VALUES(?,?,?)`, req.Name, note, yield)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, _ := res.LastInsertId()
+		for i, c := range req.Components {
+			position := c.Position
+			if position == 0 {
+				position = int64(i + 1)
+			}
+			var lineNote any
+			if v := strings.TrimSpace(c.Note); v != "" {
+				lineNote = v
+			}
+			if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO bom_template_lines(template_id, component_item_id, qty_per_unit, scrap_factor, position, note)
+VALUES(?,?,?,?,?,?)
+`, id, c.ComponentItemID, c.QtyPerUnit, c.ScrapFactor, position, lineNote); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		out, err := loadBOMTemplate(r.Context(), tx, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// deleteBOMTemplate removes a template. Items created from it keep their
+// BOMs, which were copied.
+func deleteBOMTemplate(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		res, err := store.Exec(r.Context(), dbx, `DELETE FROM bom_templates WHERE template_id = ?`, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "template not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// templateBOMRevision builds the first revision of a new item from a
+// template, with qty overriding line quantities by component item id. The
+// lines are checked against the current items under field, since some may
+// have gone obsolete since the template was saved.
+func templateBOMRevision(ctx context.Context, q queryer, templateID int64, qty map[int64]float64, field string, errs *validate.Errors) (BOMRevisionReq, error) {
+	var rev BOMRevisionReq
+	t, err := loadBOMTemplate(ctx, q, templateID)
+	if err == sql.ErrNoRows {
+		errs.Add(field, fmt.Sprintf("template not found: %d", templateID))
+		return rev, nil
+	}
+	if err != nil {
+		return rev, err
+	}
+	rev.Components = t.Components
+	rev.Yield = &t.Yield
+	rev.ChangeNote = "From template " + t.Name
+
+	listed := make(map[int64]bool, len(rev.Components))
+	for i := range rev.Components {
+		c := &rev.Components[i]
+		listed[c.ComponentItemID] = true
+		if v, ok := qty[c.ComponentItemID]; ok {
+			c.QtyPerUnit = v
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(qty)) {
+		errs.Check(listed[id], fmt.Sprintf("bom_qty.%d", id), "is not a line of the template")
+	}
+	err = validateBOMLines(ctx, q, 0, field+".components", rev.Components, 0, errs)
+	return rev, err
+}
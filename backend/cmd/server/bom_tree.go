@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxBOMTreeDepth bounds the levels of an exported BOM tree; cycles are
+// rejected on save.
+const maxBOMTreeDepth = 16
+
+var bomTreeCSVHeader = []string{"level", "indented_sku", "sku", "name", "item_type", "qty_per_unit", "extended_qty", "managed_unit", "scrap_factor", "refs", "bom_rev", "path"}
+
+// bomTreeLine is one row of an exported BOM tree.
+type bomTreeLine struct {
+	level       int
+	itemID      int64
+	sku         string
+	name        string
+	itemType    string
+	qtyPerUnit  float64
+	extendedQty float64
+	unit        string
+	scrapFactor float64
+	refs        string
+	bomRev      int64
+	path        string
+}
+
+func (l bomTreeLine) csv() []string {
+	rev := ""
+	if l.bomRev > 0 {
+		rev = strconv.FormatInt(l.bomRev, 10)
+	}
+	return []string{
+		strconv.Itoa(l.level),
+		strings.Repeat(".", l.level-1) + l.sku,
+		l.sku,
+		l.name,
+		l.itemType,
+		strconv.FormatFloat(l.qtyPerUnit, 'f', -1, 64),
+		strconv.FormatFloat(l.extendedQty, 'f', -1, 64),
+		l.unit,
+		strconv.FormatFloat(l.scrapFactor, 'f', -1, 64),
+		l.refs,
+		rev,
+		l.path,
+	}
+}
+
+// appendBOMTree appends the lines of a revision at level, each followed by
+// the tree of its own BOM revision in effect at asOf. qty is how many of the
+// revision's parent are needed, path the SKUs above its lines.
+func appendBOMTree(ctx context.Context, q queryer, out []bomTreeLine, recordID int64, qty float64, path string, level int, asOf string) ([]bomTreeLine, string, error) {
+	if level > maxBOMTreeDepth {
+		return nil, "bom is nested too deep", nil
+	}
+	rows, err := q.QueryContext(ctx, `
+SELECT ac.component_item_id, i.sku, i.name, i.item_type, ac.qty_per_unit, i.managed_unit, ac.scrap_factor, COALESCE(ac.refs, '')
+FROM assembly_components ac
+JOIN items i ON i.item_id = ac.component_item_id
+WHERE ac.record_id = ?
+ORDER BY ac.position, i.sku
+`, recordID)
+	if err != nil {
+		return nil, "", err
+	}
+	lines := make([]bomTreeLine, 0)
+	for rows.Next() {
+		l := bomTreeLine{level: level, path: path}
+		if err := rows.Scan(&l.itemID, &l.sku, &l.name, &l.itemType, &l.qtyPerUnit, &l.unit, &l.scrapFactor, &l.refs); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+		l.extendedQty = qty * l.qtyPerUnit
+		lines = append(lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, "", err
+	}
+	rows.Close()
+
+	for _, l := range lines {
+		childRecordID, revNo, _, err := effectiveBOMRevision(ctx, q, l.itemID, asOf)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, "", err
+		}
+		l.bomRev = revNo
+		out = append(out, l)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		var problem string
+		out, problem, err = appendBOMTree(ctx, q, out, childRecordID, l.extendedQty, path+" > "+l.sku, level+1, asOf)
+		if err != nil || problem != "" {
+			return nil, problem, err
+		}
+	}
+	return out, "", nil
+}
+
+// exportBOMTreeCSV writes the multi-level BOM of an item as CSV, one row per
+// line with its level, and the SKU indented by a dot per level below the
+// first. Sub-assemblies and built parts are expanded with their revisions in
+// effect at ?as_of= (default now). extended_qty is the quantity for ?qty=
+// (default 1) of the top item, without scrap or yield.
+func exportBOMTreeCSV(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parentID, problem, status, err := bomParentID(r.Context(), dbx, r)
+		if err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		qty := 1.0
+		if v := strings.TrimSpace(r.URL.Query().Get("qty")); v != "" {
+			qty, err = strconv.ParseFloat(v, 64)
+			if err != nil || qty <= 0 {
+				http.Error(w, "qty must be > 0", http.StatusBadRequest)
+				return
+			}
+		}
+		asOf, err := parseBOMAsOf(r.URL.Query().Get("as_of"))
+		if err != nil {
+			http.Error(w, "invalid as_of", http.StatusBadRequest)
+			return
+		}
+
+		var sku string
+		if err := dbx.QueryRowContext(r.Context(), `SELECT sku FROM items WHERE item_id = ?`, parentID).Scan(&sku); err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		recordID, revNo, _, err := effectiveBOMRevision(r.Context(), dbx, parentID, asOf)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "revision not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load revision", http.StatusInternalServerError)
+			return
+		}
+		lines, problem, err := appendBOMTree(r.Context(), dbx, nil, recordID, qty, sku, 1, asOf)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+
+		var buf bytes.Buffer
+		cw := csv.NewWriter(&buf)
+		cw.Write(bomTreeCSVHeader)
+		for _, l := range lines {
+			cw.Write(l.csv())
+		}
+		cw.Flush()
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-rev%d-bom-tree.csv"`, sku, revNo))
+		_, _ = w.Write(buf.Bytes())
+	}
+}
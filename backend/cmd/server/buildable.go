@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math"
+)
+
+// componentStock is the on-hand stock of an item and whether it is counted.
+type componentStock struct {
+	sku     string
+	managed bool
+	qty     float64
+}
+
+func loadComponentStock(ctx context.Context, q queryer) (map[int64]componentStock, error) {
+	rows, err := q.QueryContext(ctx, `
+SELECT
+  i.item_id, i.sku, i.stock_managed,
+  COALESCE(SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END), 0)
+FROM items i
+LEFT JOIN stock_transactions st ON st.item_id = i.item_id
+GROUP BY i.item_id
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int64]componentStock{}
+	for rows.Next() {
+		var id int64
+		var s componentStock
+		var managed int
+		if err := rows.Scan(&id, &s.sku, &managed, &s.qty); err != nil {
+			return nil, err
+		}
+		s.managed = managed != 0
+		out[id] = s
+	}
+	return out, rows.Err()
+}
+
+// buildableQty is how many more units of an item the on-hand stock of its
+// components covers under the revision in effect at asOf, with phantoms
+// blown through and scrap and yield included. Alternates are not counted,
+// and components that aren't stock managed never run out. ok is false when
+// the item has no revision or nothing on it limits the count; limit is the
+// SKU that runs out first.
+func buildableQty(ctx context.Context, q queryer, itemID int64, asOf string, stock map[int64]componentStock) (qty float64, limit string, ok bool, err error) {
+	recordID, _, yield, err := effectiveBOMRevision(ctx, q, itemID, asOf)
+	if err == sql.ErrNoRows {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	needs, problem, err := explodeBOMRecord(ctx, q, recordID, yield, asOf, 0)
+	if err != nil || problem != "" {
+		return 0, "", false, err
+	}
+	// Lines of the same component (e.g. via phantoms) draw on one stock.
+	gross := map[int64]float64{}
+	for _, n := range needs {
+		gross[n.ItemID] += n.Gross
+	}
+	qty = math.Inf(1)
+	for id, g := range gross {
+		s := stock[id]
+		if !s.managed || g <= 0 {
+			continue
+		}
+		// The tolerance keeps exact covers from rounding down on float noise.
+		n := math.Max(math.Floor(s.qty/g+1e-9), 0)
+		if n < qty || (n == qty && s.sku < limit) {
+			qty, limit = n, s.sku
+		}
+	}
+	if math.IsInf(qty, 1) {
+		return 0, "", false, nil
+	}
+	return qty, limit, true, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"stockmate/internal/bundle"
+)
+
+// maxBundleBytes caps bundle uploads. Bundles are sent as multipart so the
+// global body limit does not apply to them.
+const maxBundleBytes = 256 << 20
+
+func exportBundle(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zipped := false
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+		case "zip":
+			zipped = true
+		default:
+			http.Error(w, "invalid format", http.StatusBadRequest)
+			return
+		}
+
+		b, err := bundle.Export(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Backups are usually taken in read-only mode; a restore must not
+		// come up read-only.
+		b.Tables["app_settings"] = slices.DeleteFunc(b.Tables["app_settings"], func(row bundle.Row) bool {
+			return row["key"] == readOnlySettingKey || row["key"] == readOnlyReasonSettingKey
+		})
+
+		name := "stockmate-" + time.Now().UTC().Format("20060102-150405")
+		if zipped {
+			w.Header().Set("Content-Type", "application/zip")
+			name += ".zip"
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			name += ".json"
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		if err := b.WriteJSON(w, zipped); err != nil {
+			fmt.Println("bundle export write failed:", err)
+		}
+	}
+}
+
+// importBundle loads a bundle uploaded as multipart field "file" (JSON or
+// zip) or as a plain JSON body. ?conflict= picks fail (default), skip or
+// replace for rows whose key already exists.
+func importBundle(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		strategy, err := bundle.ParseStrategy(r.URL.Query().Get("conflict"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var src io.Reader = r.Body
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBundleBytes)
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, "file required", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			src = file
+		}
+		data, err := io.ReadAll(src)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "bundle too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "failed to read bundle", http.StatusBadRequest)
+			return
+		}
+
+		b, err := bundle.Decode(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results, err := bundle.Import(r.Context(), dbx, b, strategy)
+		if err != nil {
+			if errors.Is(err, bundle.ErrConflict) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"conflict": strategy,
+			"tables":   results,
+		})
+	}
+}
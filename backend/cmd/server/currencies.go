@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+const baseCurrencySettingKey = "base_currency"
+
+type Currency struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Rate is the value of one unit in the base currency.
+	Rate      float64       `json:"rate"`
+	IsBase    bool          `json:"is_base"`
+	UpdatedAt timeutil.Time `json:"updated_at"`
+}
+
+// baseCurrency returns the currency reports are normalized to, JPY when unset.
+func baseCurrency(ctx context.Context, q queryer) (string, error) {
+	var v string
+	err := q.QueryRowContext(ctx, `SELECT value FROM app_settings WHERE key = ?`, baseCurrencySettingKey).Scan(&v)
+	if err == sql.ErrNoRows {
+		return "JPY", nil
+	}
+	return v, err
+}
+
+// resolveCurrency normalizes a currency code and checks that it has a rate.
+func resolveCurrency(ctx context.Context, q queryer, code string) (string, string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", "currency must be a 3-letter code", nil
+	}
+	var n int
+	if err := q.QueryRowContext(ctx, `SELECT COUNT(1) FROM currencies WHERE code = ?`, code).Scan(&n); err != nil {
+		return "", "", err
+	}
+	if n == 0 {
+		return "", "unknown currency: " + code, nil
+	}
+	return code, "", nil
+}
+
+func listCurrencies(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		base, err := baseCurrency(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, "failed to load base currency", http.StatusInternalServerError)
+			return
+		}
+		rows, err := dbx.QueryContext(r.Context(), `SELECT code, name, rate, updated_at FROM currencies ORDER BY code`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]Currency, 0)
+		for rows.Next() {
+			var c Currency
+			if err := rows.Scan(&c.Code, &c.Name, &c.Rate, &c.UpdatedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			c.IsBase = c.Code == base
+			out = append(out, c)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// upsertCurrency sets the exchange rate of a currency. The base currency's
+// rate is fixed at 1.
+func upsertCurrency(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Name string  `json:"name"`
+		Rate float64 `json:"rate"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := strings.ToUpper(strings.TrimSpace(chi.URLParam(r, "code")))
+		if len(code) != 3 {
+			http.Error(w, "currency must be a 3-letter code", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if req.Rate <= 0 {
+			http.Error(w, "rate must be > 0", http.StatusBadRequest)
+			return
+		}
+		base, err := baseCurrency(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, "failed to load base currency", http.StatusInternalServerError)
+			return
+		}
+		if code == base && req.Rate != 1 {
+			http.Error(w, "base currency rate must be 1", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO currencies(code, name, rate) VALUES(?,?,?)
+ON CONFLICT(code) DO UPDATE SET name = excluded.name, rate = excluded.rate, updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+`, code, strings.TrimSpace(req.Name), req.Rate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var c Currency
+		if err := tx.QueryRowContext(r.Context(), `SELECT code, name, rate, updated_at FROM currencies WHERE code = ?`, code).
+			Scan(&c.Code, &c.Name, &c.Rate, &c.UpdatedAt); err != nil {
+			http.Error(w, "failed to load currency", http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		c.IsBase = c.Code == base
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(c)
+	}
+}
+
+// deleteCurrency removes a rate that neither the base setting, an item cost
+// nor a supplier offer uses.
+func deleteCurrency(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := strings.ToUpper(strings.TrimSpace(chi.URLParam(r, "code")))
+		base, err := baseCurrency(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, "failed to load base currency", http.StatusInternalServerError)
+			return
+		}
+		if code == base {
+			http.Error(w, "cannot delete the base currency", http.StatusConflict)
+			return
+		}
+		var refs int64
+		if err := dbx.QueryRowContext(r.Context(), `
+SELECT (SELECT COUNT(1) FROM items WHERE unit_cost_currency = ?) + (SELECT COUNT(1) FROM supplier_offers WHERE currency = ?)
+`, code, code).Scan(&refs); err != nil {
+			http.Error(w, "failed to check references", http.StatusInternalServerError)
+			return
+		}
+		if refs > 0 {
+			http.Error(w, "currency is in use", http.StatusConflict)
+			return
+		}
+		res, err := store.Exec(r.Context(), dbx, `DELETE FROM currencies WHERE code = ?`, code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "currency not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getBaseCurrencySetting(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		base, err := baseCurrency(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, "failed to load base currency", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"currency": base})
+	}
+}
+
+// setBaseCurrencySetting switches the base currency and rescales every rate
+// so the new base has rate 1. Item costs keep their own currency.
+func setBaseCurrencySetting(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Currency string `json:"currency"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		code, problem, err := resolveCurrency(r.Context(), tx, req.Currency)
+		if err != nil {
+			http.Error(w, "failed to load currency", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		var rate float64
+		if err := tx.QueryRowContext(r.Context(), `SELECT rate FROM currencies WHERE code = ?`, code).Scan(&rate); err != nil {
+			http.Error(w, "failed to load currency", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `UPDATE currencies SET rate = rate / ?, updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')`, rate); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Pin the new base exactly, free of rounding from the division.
+		if _, err := tx.ExecContext(r.Context(), `UPDATE currencies SET rate = 1 WHERE code = ?`, code); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO app_settings(key, value) VALUES(?, ?)
+ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+`, baseCurrencySettingKey, code); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"currency": code})
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/validate"
+)
+
+var customFieldKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+type CustomField struct {
+	ID        int64  `json:"id"`
+	Key       string `json:"key"`
+	Name      string `json:"name"`
+	FieldType string `json:"field_type"`
+	AppliesTo string `json:"applies_to,omitempty"`
+	SortOrder int    `json:"sort_order"`
+}
+
+// canonicalCustomValue validates a value against the field type and returns
+// the text stored in item_custom_values.
+func canonicalCustomValue(fieldType string, v any) (string, error) {
+	switch fieldType {
+	case "text":
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("must be a string")
+		}
+		return strings.TrimSpace(s), nil
+	case "number":
+		switch n := v.(type) {
+		case float64:
+			return strconv.FormatFloat(n, 'f', -1, 64), nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			if err != nil {
+				return "", fmt.Errorf("must be a number")
+			}
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		}
+		return "", fmt.Errorf("must be a number")
+	case "boolean":
+		switch b := v.(type) {
+		case bool:
+			return strconv.FormatBool(b), nil
+		case string:
+			parsed, err := strconv.ParseBool(strings.TrimSpace(b))
+			if err != nil {
+				return "", fmt.Errorf("must be a boolean")
+			}
+			return strconv.FormatBool(parsed), nil
+		}
+		return "", fmt.Errorf("must be a boolean")
+	case "date":
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("must be a YYYY-MM-DD string")
+		}
+		d, err := time.Parse("2006-01-02", strings.TrimSpace(s))
+		if err != nil {
+			return "", fmt.Errorf("must be a YYYY-MM-DD string")
+		}
+		return d.Format("2006-01-02"), nil
+	}
+	return "", fmt.Errorf("unknown field type %s", fieldType)
+}
+
+func typedCustomValue(fieldType, stored string) any {
+	switch fieldType {
+	case "number":
+		if f, err := strconv.ParseFloat(stored, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(stored); err == nil {
+			return b
+		}
+	}
+	return stored
+}
+
+// loadCustomFieldValues returns the custom field values of the given items
+// keyed by item id and field key.
+func loadCustomFieldValues(ctx context.Context, q queryer, itemIDs []int64) (map[int64]map[string]any, error) {
+	out := make(map[int64]map[string]any)
+	if len(itemIDs) == 0 {
+		return out, nil
+	}
+	args := make([]any, len(itemIDs))
+	for i, id := range itemIDs {
+		args[i] = id
+	}
+	rows, err := q.QueryContext(ctx, `
+SELECT v.item_id, f.field_key, f.field_type, v.value
+FROM item_custom_values v
+JOIN custom_fields f ON f.field_id = v.field_id
+WHERE v.item_id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(itemIDs)), ",")+`)
+`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itemID int64
+		var key, fieldType, value string
+		if err := rows.Scan(&itemID, &key, &fieldType, &value); err != nil {
+			return nil, err
+		}
+		if out[itemID] == nil {
+			out[itemID] = make(map[string]any)
+		}
+		out[itemID][key] = typedCustomValue(fieldType, value)
+	}
+	return out, rows.Err()
+}
+
+// attachCustomFields fills Item.CustomFields for a listing.
+func attachCustomFields(ctx context.Context, q queryer, items []Item) error {
+	ids := make([]int64, len(items))
+	for i, it := range items {
+		ids[i] = it.ID
+	}
+	values, err := loadCustomFieldValues(ctx, q, ids)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].CustomFields = values[items[i].ID]
+	}
+	return nil
+}
+
+// customFieldFilter turns ?cf.<key>=<value> parameters into EXISTS clauses on
+// the items alias i. Values are canonicalized like stored ones, so
+// cf.diameter=1.75 matches 1.750.
+func customFieldFilter(ctx context.Context, q queryer, query url.Values) (string, []any, error) {
+	sb := strings.Builder{}
+	args := make([]any, 0)
+	for param, vals := range query {
+		key, ok := strings.CutPrefix(param, "cf.")
+		if !ok || len(vals) == 0 {
+			continue
+		}
+		var fieldID int64
+		var fieldType string
+		if err := q.QueryRowContext(ctx, `SELECT field_id, field_type FROM custom_fields WHERE field_key = ?`, key).Scan(&fieldID, &fieldType); err != nil {
+			if err == sql.ErrNoRows {
+				return "", nil, fmt.Errorf("unknown custom field: %s", key)
+			}
+			return "", nil, err
+		}
+		value, err := canonicalCustomValue(fieldType, vals[0])
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid cf.%s: %v", key, err)
+		}
+		sb.WriteString(" AND EXISTS (SELECT 1 FROM item_custom_values cv WHERE cv.item_id = i.item_id AND cv.field_id = ? AND cv.value = ?)")
+		args = append(args, fieldID, value)
+	}
+	return sb.String(), args, nil
+}
+
+func listCustomFields(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := `SELECT field_id, field_key, name, field_type, applies_to, sort_order FROM custom_fields`
+		args := make([]any, 0)
+		if itemType := strings.TrimSpace(r.URL.Query().Get("item_type")); itemType != "" {
+			if itemType != "component" && itemType != "assembly" {
+				http.Error(w, "item_type must be component or assembly", http.StatusBadRequest)
+				return
+			}
+			query += ` WHERE applies_to IS NULL OR applies_to = ?`
+			args = append(args, itemType)
+		}
+		query += ` ORDER BY sort_order, field_key`
+
+		rows, err := dbx.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]CustomField, 0)
+		for rows.Next() {
+			var f CustomField
+			var appliesTo sql.NullString
+			if err := rows.Scan(&f.ID, &f.Key, &f.Name, &f.FieldType, &appliesTo, &f.SortOrder); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if appliesTo.Valid {
+				f.AppliesTo = appliesTo.String
+			}
+			out = append(out, f)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func createCustomField(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Key       string `json:"key"`
+		Name      string `json:"name"`
+		FieldType string `json:"field_type"`
+		AppliesTo string `json:"applies_to"`
+		SortOrder int    `json:"sort_order"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Key = strings.ToLower(strings.TrimSpace(req.Key))
+		req.Name = strings.TrimSpace(req.Name)
+		req.FieldType = strings.ToLower(strings.TrimSpace(req.FieldType))
+		req.AppliesTo = strings.TrimSpace(req.AppliesTo)
+		var errs validate.Errors
+		errs.Check(customFieldKeyPattern.MatchString(req.Key), "key", "must match [a-z][a-z0-9_]*")
+		errs.Required("name", req.Name)
+		errs.OneOf("field_type", req.FieldType, "text", "number", "boolean", "date")
+		var appliesTo any = nil
+		if req.AppliesTo != "" {
+			errs.OneOf("applies_to", req.AppliesTo, "component", "assembly")
+			appliesTo = req.AppliesTo
+		}
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		res, err := store.Exec(r.Context(), dbx, `
+INSERT INTO custom_fields(field_key, name, field_type, applies_to, sort_order)
+VALUES(?,?,?,?,?)
+`, req.Key, req.Name, req.FieldType, appliesTo, req.SortOrder)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, _ := res.LastInsertId()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(CustomField{
+			ID:        id,
+			Key:       req.Key,
+			Name:      req.Name,
+			FieldType: req.FieldType,
+			AppliesTo: req.AppliesTo,
+			SortOrder: req.SortOrder,
+		})
+	}
+}
+
+// updateCustomField changes the label, scope or order of a field. The key and
+// type are fixed once created because stored values depend on them.
+func updateCustomField(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Name      string `json:"name"`
+		AppliesTo string `json:"applies_to"`
+		SortOrder int    `json:"sort_order"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		fieldID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || fieldID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		req.AppliesTo = strings.TrimSpace(req.AppliesTo)
+		var errs validate.Errors
+		errs.Required("name", req.Name)
+		var appliesTo any = nil
+		if req.AppliesTo != "" {
+			errs.OneOf("applies_to", req.AppliesTo, "component", "assembly")
+			appliesTo = req.AppliesTo
+		}
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		res, err := store.Exec(r.Context(), dbx, `
+UPDATE custom_fields SET name = ?, applies_to = ?, sort_order = ? WHERE field_id = ?
+`, req.Name, appliesTo, req.SortOrder, fieldID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "custom field not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deleteCustomField removes the definition together with every stored value.
+func deleteCustomField(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		fieldID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || fieldID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		res, err := store.Exec(r.Context(), dbx, `DELETE FROM custom_fields WHERE field_id = ?`, fieldID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "custom field not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// setItemCustomFields merges values into an item's custom fields; a null
+// value clears that field.
+func setItemCustomFields(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		itemID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var itemType string
+		if err := tx.QueryRowContext(r.Context(), `SELECT item_type FROM items WHERE item_id = ?`, itemID).Scan(&itemType); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "item not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+
+		// Every key is checked before answering so all bad values are reported
+		// together; nothing is committed when any fails.
+		var errs validate.Errors
+		for _, key := range slices.Sorted(maps.Keys(req)) {
+			raw := req[key]
+			var fieldID int64
+			var fieldType string
+			var appliesTo sql.NullString
+			if err := tx.QueryRowContext(r.Context(), `
+SELECT field_id, field_type, applies_to FROM custom_fields WHERE field_key = ?
+`, key).Scan(&fieldID, &fieldType, &appliesTo); err != nil {
+				if err == sql.ErrNoRows {
+					errs.Add(key, "unknown custom field")
+					continue
+				}
+				http.Error(w, "failed to load custom field", http.StatusInternalServerError)
+				return
+			}
+			if raw == nil {
+				if _, err := tx.ExecContext(r.Context(), `DELETE FROM item_custom_values WHERE item_id = ? AND field_id = ?`, itemID, fieldID); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				continue
+			}
+			if appliesTo.Valid && appliesTo.String != itemType {
+				errs.Add(key, fmt.Sprintf("applies to %s items only", appliesTo.String))
+				continue
+			}
+			value, err := canonicalCustomValue(fieldType, raw)
+			if err != nil {
+				errs.Add(key, err.Error())
+				continue
+			}
+			if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO item_custom_values(item_id, field_id, value)
+VALUES(?,?,?)
+ON CONFLICT(item_id, field_id) DO UPDATE SET value = excluded.value
+`, itemID, fieldID, value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+		// Custom values are part of the item list, so they count as an edit.
+		if _, err := tx.ExecContext(r.Context(), `UPDATE items SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE item_id = ?`, itemID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		values, err := loadCustomFieldValues(r.Context(), tx, []int64{itemID})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		out := values[itemID]
+		if out == nil {
+			out = make(map[string]any)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
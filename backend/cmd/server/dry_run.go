@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+)
+
+// dryRunUnsupported are the mutating routes a rollback cannot undo: they
+// write files, send mail, call other services, change state held in memory
+// or clear the caller's cookie, or they cannot run in a transaction at all.
+// A dry run is refused there rather than carried out for real.
+var dryRunUnsupported = map[string]bool{
+	"POST /auth/logout":                       true,
+	"POST /api/items/{id}/attachments":        true,
+	"DELETE /api/attachments/{id}":            true,
+	"POST /api/integrations/shop-sync/run":    true,
+	"POST /api/admin/db/maintenance":          true,
+	"PUT " + readOnlyPath:                     true,
+	"PUT /api/admin/ledger/append-only":       true,
+	"POST /api/admin/notifications/test":      true,
+	"POST /api/admin/notifications/low-stock": true,
+}
+
+// queryDryRun reads ?dry_run=; ok is false for a value that is not a
+// boolean.
+func queryDryRun(r *http.Request) (dry, ok bool) {
+	switch r.URL.Query().Get("dry_run") {
+	case "", "0", "false":
+		return false, true
+	case "1", "true":
+		return true, true
+	}
+	return false, false
+}
+
+// dryRun runs a mutating request with ?dry_run=1 in full, validation and
+// constraints included, but marks its context with store.WithDryRun so
+// every transaction rolls back where it would commit. The response is the
+// one the write would get, with X-Dry-Run: 1, so a client can preview new
+// stock, the next rev_no or a conflict before sending the request for real.
+func dryRun(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		dry, ok := queryDryRun(r)
+		if !ok {
+			http.Error(w, "invalid dry_run", http.StatusBadRequest)
+			return
+		}
+		if !dry {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.Routes != nil {
+			pattern := rctx.Routes.Find(chi.NewRouteContext(), r.Method, r.URL.Path)
+			if dryRunUnsupported[r.Method+" "+pattern] {
+				http.Error(w, "dry_run is not supported by this endpoint", http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("X-Dry-Run", "1")
+		next.ServeHTTP(w, r.WithContext(store.WithDryRun(r.Context())))
+	})
+}
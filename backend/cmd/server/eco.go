@@ -0,0 +1,637 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+// An engineering change order (ECO) stages new BOM revisions for one or more
+// assemblies. Nothing changes until it is approved; approval then creates all
+// of its revisions in one transaction.
+const (
+	ecoDraft     = "draft"
+	ecoApproved  = "approved"
+	ecoCancelled = "cancelled"
+)
+
+const ecoRequiredSettingKey = "bom_require_eco"
+
+const ecoRequiredMessage = "bom changes require an eco; stage the revision with PUT /api/ecos/{id}/changes/{item_id}"
+
+type ECO struct {
+	ID            int64         `json:"id"`
+	Title         string        `json:"title"`
+	Description   string        `json:"description,omitempty"`
+	Status        string        `json:"status"`
+	EffectiveDate string        `json:"effective_date,omitempty"`
+	ApprovedBy    string        `json:"approved_by,omitempty"`
+	ApprovedAt    timeutil.Time `json:"approved_at"`
+	CreatedAt     timeutil.Time `json:"created_at"`
+	ChangeCount   int64         `json:"change_count"`
+	Assemblies    []ECOAssembly `json:"assemblies,omitempty"`
+}
+
+// ECOAssembly is one assembly changed by an ECO. RevNo is set once the ECO
+// has been approved.
+type ECOAssembly struct {
+	ParentItemID   int64    `json:"parent_item_id"`
+	SKU            string   `json:"sku"`
+	Name           string   `json:"name"`
+	CurrentRevNo   *int64   `json:"current_rev_no,omitempty"`
+	ComponentCount int      `json:"component_count"`
+	Yield          *float64 `json:"yield,omitempty"`
+	RecordID       *int64   `json:"record_id,omitempty"`
+	RevNo          *int64   `json:"rev_no,omitempty"`
+}
+
+// ecoRequired reports whether BOM revisions may only be created through ECOs.
+func ecoRequired(ctx context.Context, q queryer) (bool, error) {
+	var v string
+	err := q.QueryRowContext(ctx, `SELECT value FROM app_settings WHERE key = ?`, ecoRequiredSettingKey).Scan(&v)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return v == "1", nil
+}
+
+func getECOSetting(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		required, err := ecoRequired(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, "failed to load setting", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"required": required})
+	}
+}
+
+func setECOSetting(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Required bool `json:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		v := "0"
+		if req.Required {
+			v = "1"
+		}
+		if _, err := store.Exec(r.Context(), dbx, `
+INSERT INTO app_settings(key, value) VALUES(?, ?)
+ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+`, ecoRequiredSettingKey, v); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"required": req.Required})
+	}
+}
+
+const ecoSelect = `
+SELECT
+  e.eco_id,
+  e.title,
+  COALESCE(e.description, ''),
+  e.status,
+  COALESCE(e.effective_date, ''),
+  COALESCE(e.approved_by, ''),
+  e.approved_at,
+  e.created_at,
+  (SELECT COUNT(1) FROM eco_changes ec WHERE ec.eco_id = e.eco_id)
+FROM ecos e
+`
+
+func scanECO(row interface{ Scan(...any) error }) (ECO, error) {
+	var e ECO
+	err := row.Scan(&e.ID, &e.Title, &e.Description, &e.Status, &e.EffectiveDate, &e.ApprovedBy, &e.ApprovedAt, &e.CreatedAt, &e.ChangeCount)
+	return e, err
+}
+
+func ecoParam(r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// loadECO returns the ECO with its assemblies, or nil when it does not exist.
+func loadECO(ctx context.Context, q queryer, ecoID int64) (*ECO, error) {
+	e, err := scanECO(q.QueryRowContext(ctx, ecoSelect+` WHERE e.eco_id = ?`, ecoID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.Assemblies, err = loadECOAssemblies(ctx, q, ecoID)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func loadECOAssemblies(ctx context.Context, q queryer, ecoID int64) ([]ECOAssembly, error) {
+	rows, err := q.QueryContext(ctx, `
+SELECT
+  ec.parent_item_id,
+  i.sku,
+  i.name,
+  (SELECT MAX(ar.rev_no) FROM assembly_records ar WHERE ar.item_id = ec.parent_item_id AND ar.obsolete_at IS NULL),
+  ec.payload,
+  ec.record_id,
+  ar.rev_no
+FROM eco_changes ec
+JOIN items i ON i.item_id = ec.parent_item_id
+LEFT JOIN assembly_records ar ON ar.record_id = ec.record_id
+WHERE ec.eco_id = ?
+ORDER BY i.sku
+`, ecoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ECOAssembly, 0)
+	for rows.Next() {
+		var a ECOAssembly
+		var currentRev, recordID, revNo sql.NullInt64
+		var payload string
+		if err := rows.Scan(&a.ParentItemID, &a.SKU, &a.Name, &currentRev, &payload, &recordID, &revNo); err != nil {
+			return nil, err
+		}
+		var req BOMRevisionReq
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return nil, fmt.Errorf("eco %d change for item %d: %w", ecoID, a.ParentItemID, err)
+		}
+		a.ComponentCount = len(req.Components)
+		a.Yield = req.Yield
+		if currentRev.Valid {
+			v := currentRev.Int64
+			a.CurrentRevNo = &v
+		}
+		if recordID.Valid {
+			v := recordID.Int64
+			a.RecordID = &v
+		}
+		if revNo.Valid {
+			v := revNo.Int64
+			a.RevNo = &v
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func listECOs(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := ecoSelect
+		args := make([]any, 0)
+		if status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status"))); status != "" {
+			if status != ecoDraft && status != ecoApproved && status != ecoCancelled {
+				http.Error(w, "status must be draft, approved or cancelled", http.StatusBadRequest)
+				return
+			}
+			query += ` WHERE e.status = ?`
+			args = append(args, status)
+		}
+		query += ` ORDER BY e.eco_id DESC`
+
+		rows, err := dbx.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]ECO, 0)
+		for rows.Next() {
+			e, err := scanECO(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, e)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func createECO(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Title         string `json:"title"`
+		Description   string `json:"description"`
+		EffectiveDate string `json:"effective_date"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Title = strings.TrimSpace(req.Title)
+		if req.Title == "" {
+			http.Error(w, "title required", http.StatusBadRequest)
+			return
+		}
+		var effective any
+		if v := strings.TrimSpace(req.EffectiveDate); v != "" {
+			if _, err := time.Parse(time.DateOnly, v); err != nil {
+				http.Error(w, "effective_date must be YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			effective = v
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(r.Context(), `
+INSERT INTO ecos(title, description, effective_date) VALUES(?,?,?)
+`, req.Title, strings.TrimSpace(req.Description), effective)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, _ := res.LastInsertId()
+		e, err := loadECO(r.Context(), tx, id)
+		if err != nil || e == nil {
+			http.Error(w, "failed to load eco", http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(e)
+	}
+}
+
+func getECO(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ecoID, ok := ecoParam(r)
+		if !ok {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		e, err := loadECO(r.Context(), dbx, ecoID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if e == nil {
+			http.Error(w, "eco not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(e)
+	}
+}
+
+// listECOAssemblies lists the assemblies an ECO changes.
+func listECOAssemblies(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ecoID, ok := ecoParam(r)
+		if !ok {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		e, err := loadECO(r.Context(), dbx, ecoID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if e == nil {
+			http.Error(w, "eco not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(e.Assemblies)
+	}
+}
+
+// draftECO returns a problem and HTTP status unless the ECO exists and is
+// still a draft.
+func draftECO(ctx context.Context, q queryer, ecoID int64) (problem string, status int, err error) {
+	var s string
+	if err := q.QueryRowContext(ctx, `SELECT status FROM ecos WHERE eco_id = ?`, ecoID).Scan(&s); err != nil {
+		if err == sql.ErrNoRows {
+			return "eco not found", http.StatusNotFound, nil
+		}
+		return "", 0, err
+	}
+	if s != ecoDraft {
+		return "eco is " + s, http.StatusConflict, nil
+	}
+	return "", 0, nil
+}
+
+// putECOChange stages a BOM revision for one assembly on a draft ECO. The body
+// is the same as PUT /api/assemblies/{id}/components, with the same
+// maxComponents limit.
+func putECOChange(dbx *sql.DB, maxComponents int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ecoID, ok := ecoParam(r)
+		if !ok {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		parentItemID, err := strconv.ParseInt(chi.URLParam(r, "itemID"), 10, 64)
+		if err != nil || parentItemID <= 0 {
+			http.Error(w, "invalid item id", http.StatusBadRequest)
+			return
+		}
+		// The body is kept as sent so that omitted and empty fields still mean
+		// "keep" and "clear" when the ECO is approved.
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		var req BOMRevisionReq
+		if err := json.Unmarshal(payload, &req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if problem, status, err := draftECO(r.Context(), tx, ecoID); err != nil {
+			http.Error(w, "failed to load eco", http.StatusInternalServerError)
+			return
+		} else if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		errs, problem, status, err := validateBOMRevision(r.Context(), tx, parentItemID, &req, maxComponents)
+		if err != nil {
+			http.Error(w, "failed to validate bom", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		// The note is optional here; approval falls back to the ECO's title.
+		checkChangeNote(&errs, &req, false)
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO eco_changes(eco_id, parent_item_id, payload) VALUES(?,?,?)
+ON CONFLICT(eco_id, parent_item_id) DO UPDATE SET payload = excluded.payload
+`, ecoID, parentItemID, string(payload)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func deleteECOChange(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ecoID, ok := ecoParam(r)
+		if !ok {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		parentItemID, err := strconv.ParseInt(chi.URLParam(r, "itemID"), 10, 64)
+		if err != nil || parentItemID <= 0 {
+			http.Error(w, "invalid item id", http.StatusBadRequest)
+			return
+		}
+		if problem, status, err := draftECO(r.Context(), dbx, ecoID); err != nil {
+			http.Error(w, "failed to load eco", http.StatusInternalServerError)
+			return
+		} else if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		res, err := store.Exec(r.Context(), dbx, `DELETE FROM eco_changes WHERE eco_id = ? AND parent_item_id = ?`, ecoID, parentItemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "change not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// approveECO creates the staged revisions. Every change is validated again
+// against the current items and BOMs; any problem rejects the whole ECO.
+func approveECO(dbx *sql.DB, maxComponents int) http.HandlerFunc {
+	type Req struct {
+		ApprovedBy string `json:"approved_by"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ecoID, ok := ecoParam(r)
+		if !ok {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.ApprovedBy = strings.TrimSpace(req.ApprovedBy)
+		if req.ApprovedBy == "" {
+			http.Error(w, "approved_by required", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if problem, status, err := draftECO(r.Context(), tx, ecoID); err != nil {
+			http.Error(w, "failed to load eco", http.StatusInternalServerError)
+			return
+		} else if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+
+		// Changes without their own effective_from take effect on the ECO's
+		// effective date, and those without a change note are annotated
+		// with its title.
+		var effectiveDate, title string
+		if err := tx.QueryRowContext(r.Context(), `SELECT COALESCE(effective_date, ''), title FROM ecos WHERE eco_id = ?`, ecoID).Scan(&effectiveDate, &title); err != nil {
+			http.Error(w, "failed to load eco", http.StatusInternalServerError)
+			return
+		}
+
+		type change struct {
+			parentItemID int64
+			sku          string
+			req          BOMRevisionReq
+		}
+		rows, err := tx.QueryContext(r.Context(), `
+SELECT ec.parent_item_id, i.sku, ec.payload
+FROM eco_changes ec
+JOIN items i ON i.item_id = ec.parent_item_id
+WHERE ec.eco_id = ?
+ORDER BY i.sku
+`, ecoID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		changes := make([]change, 0)
+		for rows.Next() {
+			var c change
+			var payload string
+			if err := rows.Scan(&c.parentItemID, &c.sku, &payload); err != nil {
+				rows.Close()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := json.Unmarshal([]byte(payload), &c.req); err != nil {
+				rows.Close()
+				http.Error(w, fmt.Sprintf("%s: %v", c.sku, err), http.StatusInternalServerError)
+				return
+			}
+			changes = append(changes, c)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rows.Close()
+		if len(changes) == 0 {
+			http.Error(w, "eco has no changes", http.StatusBadRequest)
+			return
+		}
+
+		for _, c := range changes {
+			if c.req.EffectiveFrom == "" {
+				c.req.EffectiveFrom = effectiveDate
+			}
+			if strings.TrimSpace(c.req.ChangeNote) == "" {
+				c.req.ChangeNote = title
+			}
+			if strings.TrimSpace(c.req.ChangedBy) == "" {
+				c.req.ChangedBy = req.ApprovedBy
+			}
+			errs, problem, _, err := validateBOMRevision(r.Context(), tx, c.parentItemID, &c.req, maxComponents)
+			if err != nil {
+				http.Error(w, "failed to validate bom", http.StatusInternalServerError)
+				return
+			}
+			if problem == "" && !errs.Empty() {
+				problem = errs.Error()
+			}
+			if problem != "" {
+				http.Error(w, c.sku+": "+problem, http.StatusBadRequest)
+				return
+			}
+			recordID, _, problem, err := applyBOMRevision(r.Context(), tx, c.parentItemID, &c.req)
+			if err != nil {
+				http.Error(w, c.sku+": "+err.Error(), revisionErrorStatus(err))
+				return
+			}
+			if problem != "" {
+				http.Error(w, c.sku+": "+problem, http.StatusBadRequest)
+				return
+			}
+			if _, err := tx.ExecContext(r.Context(), `
+UPDATE eco_changes SET record_id = ? WHERE eco_id = ? AND parent_item_id = ?
+`, recordID, ecoID, c.parentItemID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if _, err := tx.ExecContext(r.Context(), `
+UPDATE ecos
+SET status = ?, approved_by = ?, approved_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+WHERE eco_id = ?
+`, ecoApproved, req.ApprovedBy, ecoID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e, err := loadECO(r.Context(), tx, ecoID)
+		if err != nil || e == nil {
+			http.Error(w, "failed to load eco", http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(e)
+	}
+}
+
+func cancelECO(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ecoID, ok := ecoParam(r)
+		if !ok {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		if problem, status, err := draftECO(r.Context(), dbx, ecoID); err != nil {
+			http.Error(w, "failed to load eco", http.StatusInternalServerError)
+			return
+		} else if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		if _, err := store.Exec(r.Context(), dbx, `UPDATE ecos SET status = ? WHERE eco_id = ?`, ecoCancelled, ecoID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"stockmate/internal/timeutil"
+)
+
+// bomEffectiveFrom is when a revision takes effect. Revisions saved without
+// effective_from are in effect from when they were created.
+const bomEffectiveFrom = `COALESCE(effective_from, created_at)`
+
+// parseBOMAsOf reads an as_of value into the stored timestamp form. Empty
+// means now; a bare date means the end of that day, so revisions taking
+// effect on it count.
+func parseBOMAsOf(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return timeutil.Format(time.Now()), nil
+	}
+	if d, err := time.Parse(time.DateOnly, s); err == nil {
+		return timeutil.Format(d.Add(24*time.Hour - time.Second)), nil
+	}
+	return timeutil.Normalize(s)
+}
+
+// effectiveBOMRevision returns the parent's revision in effect at asOf: the
+// highest rev_no that has taken effect by then and is not obsolete. It returns
+// sql.ErrNoRows when there is none.
+func effectiveBOMRevision(ctx context.Context, q queryer, parentItemID int64, asOf string) (recordID, revNo int64, yield float64, err error) {
+	err = q.QueryRowContext(ctx, `
+SELECT record_id, rev_no, yield
+FROM assembly_records
+WHERE item_id = ? AND obsolete_at IS NULL AND `+bomEffectiveFrom+` <= ?
+ORDER BY rev_no DESC
+LIMIT 1
+`, parentItemID, asOf).Scan(&recordID, &revNo, &yield)
+	return recordID, revNo, yield, err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// itemListETag identifies the state behind an item list: the query string
+// plus the number of matching items and their latest updated_at. It is
+// cheap next to building the list, so polling clients get a 304 while
+// nothing changed.
+func itemListETag(ctx context.Context, q queryer, r *http.Request, itemType string) (string, error) {
+	var count int64
+	var latest string
+	if err := q.QueryRowContext(ctx, `
+SELECT COUNT(1), COALESCE(MAX(updated_at), '')
+FROM items
+WHERE ?1 = '' OR item_type = ?1
+`, itemType).Scan(&count, &latest); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(r.URL.RawQuery + "\n" + strconv.FormatInt(count, 10) + "\n" + latest))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// notModified sets the ETag and answers 304 when If-None-Match already
+// holds it.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, v := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
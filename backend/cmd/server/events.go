@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"stockmate/internal/events"
+)
+
+// eventRoutes maps mutating routes to the change event they announce.
+// itemParam marks routes whose {id} is an item id.
+var eventRoutes = map[string]struct {
+	typ       string
+	action    string
+	itemParam bool
+}{
+	"POST /api/items":                                       {"item", "created", false},
+	"PUT /api/items/{id}":                                   {"item", "updated", true},
+	"DELETE /api/items/{id}":                                {"item", "deleted", true},
+	"PUT /api/items/{id}/negative-stock-policy":             {"item", "updated", true},
+	"PUT /api/items/{id}/offers/{supplierID}":               {"item", "updated", true},
+	"DELETE /api/items/{id}/offers/{supplierID}":            {"item", "updated", true},
+	"PUT /api/items/{id}/listings/{channel}":                {"item", "updated", true},
+	"DELETE /api/items/{id}/listings/{channel}":             {"item", "updated", true},
+	"PUT /api/assemblies/{id}/components":                   {"bom", "revised", true},
+	"DELETE /api/assemblies/{id}/components/{rev}":          {"bom", "revision_deleted", true},
+	"POST /api/assemblies/{id}/components/{rev}/restore":    {"bom", "revision_restored", true},
+	"POST /api/purchase-links/{id}/restore":                 {"item", "updated", false},
+	"POST /api/assemblies/{id}/bom/import":                  {"bom", "revised", true},
+	"POST /api/bom/replace-component":                       {"bom", "revised", false},
+	"POST /api/ecos/{id}/approve":                           {"bom", "revised", false},
+	"POST /api/assemblies/{id}/adjust":                      {"stock", "adjusted", true},
+	"PUT /api/items/{id}/low-stock-snooze":                  {"item", "snoozed", true},
+	"DELETE /api/items/{id}/low-stock-snooze":               {"item", "unsnoozed", true},
+	"POST /api/assemblies/{id}/picklist":                    {"stock", "picked", false},
+	"POST /api/production/parts/{id}/complete":              {"stock", "produced", true},
+	"POST /api/production/components/complete":              {"stock", "received", false},
+	"POST /api/production/shipments/complete":               {"stock", "shipped", false},
+	"POST /api/transactions/{id}/reverse":                   {"stock", "reversed", false},
+	"POST /api/integrations/orders/webhook":                 {"stock", "shipped", false},
+	"POST /api/integrations/orders/dead-letters/{id}/retry": {"stock", "shipped", false},
+	"POST /api/landed-costs":                                {"item", "updated", false},
+	"POST /api/admin/import":                                {"item", "imported", false},
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// publishChanges emits an event after a mutating request listed in
+// eventRoutes completes successfully.
+func publishChanges(broker *events.Broker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			if rec.status >= 300 {
+				return
+			}
+			if dry, _ := queryDryRun(r); dry {
+				return
+			}
+
+			rctx := chi.RouteContext(r.Context())
+			if rctx == nil {
+				return
+			}
+			route, ok := eventRoutes[r.Method+" "+rctx.RoutePattern()]
+			if !ok {
+				return
+			}
+			e := events.Event{Type: route.typ, Action: route.action}
+			if route.itemParam {
+				if id, err := strconv.ParseInt(rctx.URLParam("id"), 10, 64); err == nil {
+					e.ItemID = &id
+				}
+			}
+			broker.Publish(e)
+		})
+	}
+}
+
+// streamEvents is the SSE endpoint. Each change is sent as
+// "event: <type>" with the JSON event as data; a comment line every 25s
+// keeps idle proxies from closing the connection.
+func streamEvents(broker *events.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		ch, unsubscribe := broker.Subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		fmt.Fprint(w, "retry: 3000\n\n")
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(25 * time.Second)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			case e := <-ch:
+				data, _ := json.Marshal(e)
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			}
+			flusher.Flush()
+		}
+	}
+}
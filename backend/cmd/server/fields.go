@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"stockmate/internal/querybuilder"
+)
+
+// fieldSet is how a list request wants its rows written: the JSON keys
+// each row keeps (?fields=, nil keeps them all) and whether they are
+// compact (?compact=1). The zero value writes rows as they are.
+type fieldSet struct {
+	keep    map[string]bool
+	compact bool
+}
+
+// compactKeys are the short keys of ?compact=1, for clients on slow
+// connections. Keys not listed are kept as they are; no list row has two
+// keys with the same short form.
+var compactKeys = map[string]string{
+	"item_id":            "i",
+	"sku":                "s",
+	"name":               "n",
+	"item_type":          "t",
+	"component_type":     "ct",
+	"managed_unit":       "u",
+	"unit":               "u",
+	"stock_managed":      "sm",
+	"lifecycle_status":   "lc",
+	"reorder_point":      "rp",
+	"below_reorder":      "br",
+	"pack_qty":           "pk",
+	"stock_qty":          "q",
+	"stock_packs":        "sp",
+	"buildable":          "b",
+	"buildable_limit":    "bl",
+	"current_rev_no":     "rv",
+	"purchase_url":       "pu",
+	"series_id":          "si",
+	"series_name":        "sn",
+	"moq":                "mq",
+	"order_multiple":     "om",
+	"lead_time_days":     "lt",
+	"unit_cost":          "uc",
+	"unit_cost_currency": "cc",
+	"is_sellable":        "sl",
+	"is_final":           "fn",
+	"custom_fields":      "cf",
+	"item_count":         "ic",
+	"qty":                "qt",
+	"transaction_type":   "tt",
+	"reversal_of":        "ro",
+	"reversed_by":        "rb",
+	"correction_of":      "co",
+	"corrected_by":       "cb",
+	"reason_code":        "rc",
+	"ref_type":           "rt",
+	"ref_id":             "ri",
+	"request_id":         "rq",
+	"actor":              "a",
+	"note":               "nt",
+	"created_at":         "ca",
+	"updated_at":         "ua",
+}
+
+// parseFields reads ?fields=sku,name,... and ?compact= for a list of T.
+// Every name must be a JSON key of T, so a typo is a 400 rather than an
+// empty row.
+func parseFields[T any](r *http.Request) (fieldSet, error) {
+	var fs fieldSet
+	_, compact, err := querybuilder.Bool(r.URL.Query(), "compact")
+	if err != nil {
+		return fs, err
+	}
+	fs.compact = compact
+	raw := strings.TrimSpace(r.URL.Query().Get("fields"))
+	if raw == "" {
+		return fs, nil
+	}
+	known := jsonKeys(reflect.TypeFor[T]())
+	fs.keep = make(map[string]bool)
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !slices.Contains(known, f) {
+			return fs, fmt.Errorf("invalid fields: %s (allowed: %s)", f, strings.Join(known, ", "))
+		}
+		fs.keep[f] = true
+	}
+	if len(fs.keep) == 0 {
+		return fs, fmt.Errorf("invalid fields")
+	}
+	return fs, nil
+}
+
+// jsonKeys lists the top-level JSON keys of struct type t, including those
+// of embedded structs.
+func jsonKeys(t reflect.Type) []string {
+	out := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			out = append(out, jsonKeys(f.Type)...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+// project returns v, a row or a slice of rows, written as fs asks.
+func (fs fieldSet) project(v any) (any, error) {
+	if fs.keep == nil && !fs.compact {
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 0 && b[0] == '[' {
+		var rows []map[string]json.RawMessage
+		if err := json.Unmarshal(b, &rows); err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			if rows[i], err = fs.row(row); err != nil {
+				return nil, err
+			}
+		}
+		return rows, nil
+	}
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(b, &row); err != nil {
+		return nil, err
+	}
+	return fs.row(row)
+}
+
+func (fs fieldSet) row(row map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	if fs.keep != nil {
+		for k := range row {
+			if !fs.keep[k] {
+				delete(row, k)
+			}
+		}
+	}
+	if !fs.compact {
+		return row, nil
+	}
+	out := make(map[string]json.RawMessage, len(row))
+	for k, v := range row {
+		v, err := dropNulls(v)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		if short, ok := compactKeys[k]; ok {
+			k = short
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// dropNulls returns v without null object members at any depth, or nil
+// when v is itself null. Timestamps are already RFC3339 and pass through as they are.
+func dropNulls(v json.RawMessage) (json.RawMessage, error) {
+	switch {
+	case string(v) == "null":
+		return nil, nil
+	case len(v) > 0 && v[0] == '{':
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(v, &m); err != nil {
+			return nil, err
+		}
+		for k, mv := range m {
+			mv, err := dropNulls(mv)
+			if err != nil {
+				return nil, err
+			}
+			if mv == nil {
+				delete(m, k)
+			} else {
+				m[k] = mv
+			}
+		}
+		return json.Marshal(m)
+	case len(v) > 0 && v[0] == '[':
+		var a []json.RawMessage
+		if err := json.Unmarshal(v, &a); err != nil {
+			return nil, err
+		}
+		// Elements keep their places; only objects in them lose members.
+		for i, av := range a {
+			av, err := dropNulls(av)
+			if err != nil {
+				return nil, err
+			}
+			if av != nil {
+				a[i] = av
+			}
+		}
+		return json.Marshal(a)
+	}
+	return v, nil
+}
+
+// writeList writes a list response as fs asks.
+func writeList(w http.ResponseWriter, fs fieldSet, out any) {
+	v, err := fs.project(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
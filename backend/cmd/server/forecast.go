@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type ForecastWeek struct {
+	Week  int     `json:"week"`
+	Start string  `json:"start"`
+	Qty   float64 `json:"qty"`
+}
+
+type Forecast struct {
+	ItemID       int64  `json:"item_id"`
+	Method       string `json:"method"`
+	HistoryWeeks int    `json:"history_weeks"`
+	// WeeklyHistory is consumption per week, oldest first; the last entry is
+	// the current (partial) week.
+	WeeklyHistory []float64      `json:"weekly_history"`
+	WeeklyRate    float64        `json:"weekly_rate"`
+	Weeks         []ForecastWeek `json:"weeks"`
+	Total         float64        `json:"total"`
+	StockQty      float64        `json:"stock_qty"`
+	// IncomingQty is open purchase order quantity with an expected date,
+	// which DaysUntilStockout counts in on that date.
+	IncomingQty       float64  `json:"incoming_qty"`
+	DaysUntilStockout *float64 `json:"days_until_stockout"`
+}
+
+// getItemForecast projects consumption from the item's weekly OUT history.
+// method=sma averages the last `window` weeks; method=ses applies simple
+// exponential smoothing with factor `alpha`. Reversed OUT entries are not
+// counted as consumption. days_until_stockout counts open purchase order
+// lines in on their expected dates.
+func getItemForecast(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		method := strings.ToLower(strings.TrimSpace(q.Get("method")))
+		if method == "" {
+			method = "sma"
+		}
+		if method != "sma" && method != "ses" {
+			http.Error(w, "method must be sma or ses", http.StatusBadRequest)
+			return
+		}
+		weeks, ok := queryInt(q.Get("weeks"), 4, 1, 52)
+		if !ok {
+			http.Error(w, "weeks must be 1-52", http.StatusBadRequest)
+			return
+		}
+		history, ok := queryInt(q.Get("history"), 12, 1, 104)
+		if !ok {
+			http.Error(w, "history must be 1-104", http.StatusBadRequest)
+			return
+		}
+		window, ok := queryInt(q.Get("window"), min(4, history), 1, history)
+		if !ok {
+			http.Error(w, "window must be between 1 and history", http.StatusBadRequest)
+			return
+		}
+		alpha := 0.3
+		if v := strings.TrimSpace(q.Get("alpha")); v != "" {
+			alpha, err = strconv.ParseFloat(v, 64)
+			if err != nil || alpha <= 0 || alpha > 1 {
+				http.Error(w, "alpha must be in (0, 1]", http.StatusBadRequest)
+				return
+			}
+		}
+		// Week start dates are days in ?tz=.
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var stockQty float64
+		if err := dbx.QueryRowContext(r.Context(), `
+SELECT COALESCE(SUM(
+  CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END
+), 0)
+FROM items i
+LEFT JOIN stock_transactions st ON st.item_id = i.item_id
+WHERE i.item_id = ?
+GROUP BY i.item_id
+`, itemID).Scan(&stockQty); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "item not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to compute stock", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := dbx.QueryContext(r.Context(), `
+SELECT CAST((julianday('now') - julianday(st.created_at)) / 7 AS INTEGER) AS weeks_ago, SUM(st.qty)
+FROM stock_transactions st
+WHERE st.item_id = ?
+  AND st.transaction_type = 'OUT'
+  AND st.created_at >= strftime('%Y-%m-%dT%H:%M:%SZ', 'now', ?)
+  AND NOT EXISTS (SELECT 1 FROM stock_transactions rv WHERE rv.reversal_of = st.transaction_id)
+GROUP BY weeks_ago
+`, itemID, "-"+strconv.Itoa(history*7)+" days")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		series := make([]float64, history)
+		for rows.Next() {
+			var ago int
+			var qty float64
+			if err := rows.Scan(&ago, &qty); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if ago >= 0 && ago < history {
+				series[history-1-ago] += qty
+			}
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var rate float64
+		switch method {
+		case "sma":
+			for _, v := range series[history-window:] {
+				rate += v
+			}
+			rate /= float64(window)
+		case "ses":
+			rate = series[0]
+			for _, v := range series[1:] {
+				rate = alpha*v + (1-alpha)*rate
+			}
+		}
+
+		out := Forecast{
+			ItemID:        itemID,
+			Method:        method,
+			HistoryWeeks:  history,
+			WeeklyHistory: series,
+			WeeklyRate:    rate,
+			Weeks:         make([]ForecastWeek, 0, weeks),
+			StockQty:      stockQty,
+		}
+		today := time.Now().In(loc)
+		for i := 0; i < weeks; i++ {
+			out.Weeks = append(out.Weeks, ForecastWeek{
+				Week:  i + 1,
+				Start: today.AddDate(0, 0, 7*i).Format(time.DateOnly),
+				Qty:   rate,
+			})
+			out.Total += rate
+		}
+		receipts, err := loadExpectedReceipts(r.Context(), dbx, itemID, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, rc := range receipts {
+			out.IncomingQty += rc.qty
+		}
+		if rate > 0 {
+			days := daysUntilStockout(stockQty, rate/7, receipts)
+			out.DaysUntilStockout = &days
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// queryInt parses an optional integer query value within [lo, hi].
+func queryInt(v string, def, lo, hi int) (int, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return def, def >= lo && def <= hi
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"stockmate/internal/graphql"
+	"stockmate/internal/timeutil"
+)
+
+// graphQLPath only reads, so it stays open while read-only.
+const graphQLPath = "/api/graphql"
+
+// gqlItem is the source value of the Item type.
+type gqlItem struct {
+	ID           int64
+	SKU          string
+	Name         string
+	ItemType     string
+	ManagedUnit  string
+	StockManaged bool
+	IsSellable   bool
+	IsFinal      bool
+	Phantom      bool
+	Lifecycle    string
+	ReorderPoint *float64
+	PackQty      *float64
+	Note         string
+	CreatedAt    timeutil.Time
+	UpdatedAt    timeutil.Time
+}
+
+const gqlItemColumns = `
+  i.item_id, i.sku, i.name, i.item_type, i.managed_unit, i.stock_managed, i.is_sellable, i.is_final,
+  COALESCE(a.phantom, 0), i.lifecycle_status, i.reorder_point, i.pack_qty, COALESCE(i.note, ''), i.created_at, i.updated_at
+FROM items i
+LEFT JOIN assemblies a ON a.item_id = i.item_id
+`
+
+func queryGQLItems(ctx context.Context, dbx *sql.DB, where string, args ...any) ([]*gqlItem, error) {
+	rows, err := dbx.QueryContext(ctx, "SELECT"+gqlItemColumns+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*gqlItem, 0)
+	for rows.Next() {
+		var it gqlItem
+		var stockManaged, sellable, final, phantom int
+		var reorderPoint, packQty sql.NullFloat64
+		if err := rows.Scan(&it.ID, &it.SKU, &it.Name, &it.ItemType, &it.ManagedUnit, &stockManaged, &sellable, &final,
+			&phantom, &it.Lifecycle, &reorderPoint, &packQty, &it.Note, &it.CreatedAt, &it.UpdatedAt); err != nil {
+			return nil, err
+		}
+		it.StockManaged, it.IsSellable, it.IsFinal, it.Phantom = stockManaged != 0, sellable != 0, final != 0, phantom != 0
+		if reorderPoint.Valid {
+			it.ReorderPoint = &reorderPoint.Float64
+		}
+		if packQty.Valid {
+			it.PackQty = &packQty.Float64
+		}
+		out = append(out, &it)
+	}
+	return out, rows.Err()
+}
+
+func loadGQLItem(ctx context.Context, dbx *sql.DB, where string, args ...any) (*gqlItem, error) {
+	items, err := queryGQLItems(ctx, dbx, where, args...)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[0], nil
+}
+
+// gqlBOM is the source value of the BOM type: the revision in effect at asOf.
+type gqlBOM struct {
+	RecordID int64
+	RevNo    int64
+	Yield    float64
+}
+
+type gqlBOMLine struct {
+	ComponentItemID int64
+	QtyPerUnit      float64
+	ScrapFactor     float64
+	Refs            *string
+	Position        int64
+	Note            string
+}
+
+type gqlPurchaseLink struct {
+	ID        int64
+	URL       string
+	Label     string
+	SortOrder int64
+	Enabled   bool
+}
+
+// gqlLimit reads a limit argument: 200 by default, at most 1000.
+func gqlLimit(args graphql.Args) (int64, error) {
+	limit, ok, err := args.Int("limit")
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 200, nil
+	}
+	if limit <= 0 {
+		return 0, fmt.Errorf("limit must be > 0")
+	}
+	return min(limit, 1000), nil
+}
+
+// scalar declares a field read straight off the source value.
+func scalar[T any](get func(T) any) *graphql.Field {
+	return &graphql.Field{Resolve: func(_ context.Context, src any, _ graphql.Args) (any, error) {
+		return get(src.(T)), nil
+	}}
+}
+
+func newGraphQLSchema(dbx *sql.DB) *graphql.Schema {
+	itemType := graphql.NewObject("Item")
+	bomType := graphql.NewObject("BOM")
+	lineType := graphql.NewObject("BOMLine")
+	linkType := graphql.NewObject("PurchaseLink")
+	txnType := graphql.NewObject("Transaction")
+	query := graphql.NewObject("Query")
+
+	itemType.Fields = map[string]*graphql.Field{
+		"id":               scalar(func(it *gqlItem) any { return it.ID }),
+		"sku":              scalar(func(it *gqlItem) any { return it.SKU }),
+		"name":             scalar(func(it *gqlItem) any { return it.Name }),
+		"item_type":        scalar(func(it *gqlItem) any { return it.ItemType }),
+		"managed_unit":     scalar(func(it *gqlItem) any { return it.ManagedUnit }),
+		"stock_managed":    scalar(func(it *gqlItem) any { return it.StockManaged }),
+		"is_sellable":      scalar(func(it *gqlItem) any { return it.IsSellable }),
+		"is_final":         scalar(func(it *gqlItem) any { return it.IsFinal }),
+		"phantom":          scalar(func(it *gqlItem) any { return it.Phantom }),
+		"lifecycle_status": scalar(func(it *gqlItem) any { return it.Lifecycle }),
+		"reorder_point":    scalar(func(it *gqlItem) any { return it.ReorderPoint }),
+		"pack_qty":         scalar(func(it *gqlItem) any { return it.PackQty }),
+		"note":             scalar(func(it *gqlItem) any { return it.Note }),
+		"created_at":       scalar(func(it *gqlItem) any { return it.CreatedAt }),
+		"updated_at":       scalar(func(it *gqlItem) any { return it.UpdatedAt }),
+		"stock_qty": {Resolve: func(ctx context.Context, src any, _ graphql.Args) (any, error) {
+			var qty float64
+			err := dbx.QueryRowContext(ctx, `
+SELECT COALESCE(SUM(
+  CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END
+), 0)
+FROM stock_transactions
+WHERE item_id = ?
+`, src.(*gqlItem).ID).Scan(&qty)
+			return qty, err
+		}},
+		"purchase_links": {Type: linkType, List: true, Resolve: func(ctx context.Context, src any, _ graphql.Args) (any, error) {
+			rows, err := dbx.QueryContext(ctx, `
+SELECT l.id, l.url, COALESCE(l.label, ''), l.sort_order, l.enabled
+FROM component_purchase_links l
+JOIN components c ON c.component_id = l.component_id
+WHERE c.item_id = ? AND l.deleted_at IS NULL
+ORDER BY l.sort_order, l.id
+`, src.(*gqlItem).ID)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+			out := make([]*gqlPurchaseLink, 0)
+			for rows.Next() {
+				var l gqlPurchaseLink
+				var enabled int
+				if err := rows.Scan(&l.ID, &l.URL, &l.Label, &l.SortOrder, &enabled); err != nil {
+					return nil, err
+				}
+				l.Enabled = enabled != 0
+				out = append(out, &l)
+			}
+			return out, rows.Err()
+		}},
+		// bom is the revision in effect now or at as_of; null for components
+		// and assemblies without one.
+		"bom": {Type: bomType, Args: []string{"as_of"}, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			it := src.(*gqlItem)
+			if it.ItemType != "assembly" {
+				return nil, nil
+			}
+			s, err := args.String("as_of")
+			if err != nil {
+				return nil, err
+			}
+			asOf, err := parseBOMAsOf(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid as_of")
+			}
+			recordID, revNo, yield, err := effectiveBOMRevision(ctx, dbx, it.ID, asOf)
+			if err == sql.ErrNoRows {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &gqlBOM{RecordID: recordID, RevNo: revNo, Yield: yield}, nil
+		}},
+		"transactions": {Type: txnType, List: true, Args: []string{"limit"}, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			limit, err := gqlLimit(args)
+			if err != nil {
+				return nil, err
+			}
+			return queryGQLTransactions(ctx, dbx, " AND st.item_id = ?", []any{src.(*gqlItem).ID}, limit)
+		}},
+	}
+
+	bomType.Fields = map[string]*graphql.Field{
+		"record_id": scalar(func(b *gqlBOM) any { return b.RecordID }),
+		"rev_no":    scalar(func(b *gqlBOM) any { return b.RevNo }),
+		"yield":     scalar(func(b *gqlBOM) any { return b.Yield }),
+		"lines": {Type: lineType, List: true, Resolve: func(ctx context.Context, src any, _ graphql.Args) (any, error) {
+			rows, err := dbx.QueryContext(ctx, `
+SELECT component_item_id, qty_per_unit, scrap_factor, refs, position, COALESCE(note, '')
+FROM assembly_components
+WHERE record_id = ?
+ORDER BY position, component_item_id
+`, src.(*gqlBOM).RecordID)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+			out := make([]*gqlBOMLine, 0)
+			for rows.Next() {
+				var l gqlBOMLine
+				var refs sql.NullString
+				if err := rows.Scan(&l.ComponentItemID, &l.QtyPerUnit, &l.ScrapFactor, &refs, &l.Position, &l.Note); err != nil {
+					return nil, err
+				}
+				if refs.Valid {
+					l.Refs = &refs.String
+				}
+				out = append(out, &l)
+			}
+			return out, rows.Err()
+		}},
+	}
+
+	lineType.Fields = map[string]*graphql.Field{
+		"component_item_id": scalar(func(l *gqlBOMLine) any { return l.ComponentItemID }),
+		"qty_per_unit":      scalar(func(l *gqlBOMLine) any { return l.QtyPerUnit }),
+		"scrap_factor":      scalar(func(l *gqlBOMLine) any { return l.ScrapFactor }),
+		"refs":              scalar(func(l *gqlBOMLine) any { return l.Refs }),
+		"position":          scalar(func(l *gqlBOMLine) any { return l.Position }),
+		"note":              scalar(func(l *gqlBOMLine) any { return l.Note }),
+		"item": {Type: itemType, Resolve: func(ctx context.Context, src any, _ graphql.Args) (any, error) {
+			return loadGQLItem(ctx, dbx, "WHERE i.item_id = ?", src.(*gqlBOMLine).ComponentItemID)
+		}},
+	}
+
+	linkType.Fields = map[string]*graphql.Field{
+		"id":         scalar(func(l *gqlPurchaseLink) any { return l.ID }),
+		"url":        scalar(func(l *gqlPurchaseLink) any { return l.URL }),
+		"label":      scalar(func(l *gqlPurchaseLink) any { return l.Label }),
+		"sort_order": scalar(func(l *gqlPurchaseLink) any { return l.SortOrder }),
+		"enabled":    scalar(func(l *gqlPurchaseLink) any { return l.Enabled }),
+	}
+
+	txnType.Fields = map[string]*graphql.Field{
+		"id":               scalar(func(t *StockTransaction) any { return t.ID }),
+		"item_id":          scalar(func(t *StockTransaction) any { return t.ItemID }),
+		"qty":              scalar(func(t *StockTransaction) any { return t.Qty }),
+		"transaction_type": scalar(func(t *StockTransaction) any { return t.TransactionType }),
+		"note":             scalar(func(t *StockTransaction) any { return t.Note }),
+		"reason_code":      scalar(func(t *StockTransaction) any { return t.ReasonCode }),
+		"ref_type":         scalar(func(t *StockTransaction) any { return t.RefType }),
+		"ref_id":           scalar(func(t *StockTransaction) any { return t.RefID }),
+		"reversal_of":      scalar(func(t *StockTransaction) any { return t.ReversalOf }),
+		"reversed_by":      scalar(func(t *StockTransaction) any { return t.ReversedBy }),
+		"correction_of":    scalar(func(t *StockTransaction) any { return t.CorrectionOf }),
+		"corrected_by":     scalar(func(t *StockTransaction) any { return t.CorrectedBy }),
+		"created_at":       scalar(func(t *StockTransaction) any { return t.CreatedAt }),
+		"item": {Type: itemType, Resolve: func(ctx context.Context, src any, _ graphql.Args) (any, error) {
+			return loadGQLItem(ctx, dbx, "WHERE i.item_id = ?", src.(*StockTransaction).ItemID)
+		}},
+	}
+
+	listItems := func(itemTypeFilter string) func(ctx context.Context, _ any, args graphql.Args) (any, error) {
+		return func(ctx context.Context, _ any, args graphql.Args) (any, error) {
+			where := []string{"1=1"}
+			params := make([]any, 0)
+			typ := itemTypeFilter
+			if typ == "" {
+				s, err := args.String("item_type")
+				if err != nil {
+					return nil, err
+				}
+				if s != "" && s != "component" && s != "assembly" {
+					return nil, fmt.Errorf("invalid item_type")
+				}
+				typ = s
+			}
+			if typ != "" {
+				where = append(where, "i.item_type = ?")
+				params = append(params, typ)
+			}
+			search, err := args.String("search")
+			if err != nil {
+				return nil, err
+			}
+			if search = strings.TrimSpace(search); search != "" {
+				where = append(where, "(i.sku LIKE ? OR i.name LIKE ?)")
+				params = append(params, "%"+search+"%", "%"+search+"%")
+			}
+			limit, err := gqlLimit(args)
+			if err != nil {
+				return nil, err
+			}
+			offset, _, err := args.Int("offset")
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, limit, max(offset, 0))
+			return queryGQLItems(ctx, dbx, "WHERE "+strings.Join(where, " AND ")+" ORDER BY i.item_id DESC LIMIT ? OFFSET ?", params...)
+		}
+	}
+
+	query.Fields = map[string]*graphql.Field{
+		"item": {Type: itemType, Args: []string{"id", "sku"}, Resolve: func(ctx context.Context, _ any, args graphql.Args) (any, error) {
+			id, ok, err := args.Int("id")
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return loadGQLItem(ctx, dbx, "WHERE i.item_id = ?", id)
+			}
+			sku, err := args.String("sku")
+			if err != nil {
+				return nil, err
+			}
+			if sku == "" {
+				return nil, fmt.Errorf("id or sku is required")
+			}
+			return loadGQLItem(ctx, dbx, "WHERE i.sku = ?", sku)
+		}},
+		"items":      {Type: itemType, List: true, Args: []string{"item_type", "search", "limit", "offset"}, Resolve: listItems("")},
+		"assemblies": {Type: itemType, List: true, Args: []string{"search", "limit", "offset"}, Resolve: listItems("assembly")},
+		"components": {Type: itemType, List: true, Args: []string{"search", "limit", "offset"}, Resolve: listItems("component")},
+		"transactions": {Type: txnType, List: true, Args: []string{"item_id", "type", "ref_type", "ref_id", "q", "limit"}, Resolve: func(ctx context.Context, _ any, args graphql.Args) (any, error) {
+			var where strings.Builder
+			params := make([]any, 0)
+			itemID, ok, err := args.Int("item_id")
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				where.WriteString(" AND st.item_id = ?")
+				params = append(params, itemID)
+			}
+			for _, name := range []string{"type", "ref_type", "ref_id"} {
+				v, err := args.String(name)
+				if err != nil {
+					return nil, err
+				}
+				if v == "" {
+					continue
+				}
+				col := "st." + name
+				if name == "type" {
+					col, v = "st.transaction_type", strings.ToUpper(v)
+				}
+				where.WriteString(" AND " + col + " = ?")
+				params = append(params, v)
+			}
+			q, err := args.String("q")
+			if err != nil {
+				return nil, err
+			}
+			noteClause, noteArgs := noteSearch("st.note", q)
+			where.WriteString(noteClause)
+			params = append(params, noteArgs...)
+			limit, err := gqlLimit(args)
+			if err != nil {
+				return nil, err
+			}
+			return queryGQLTransactions(ctx, dbx, where.String(), params, limit)
+		}},
+	}
+
+	return &graphql.Schema{Query: query}
+}
+
+func queryGQLTransactions(ctx context.Context, dbx *sql.DB, where string, args []any, limit int64) ([]*StockTransaction, error) {
+	rows, err := dbx.QueryContext(ctx, `
+SELECT
+  st.transaction_id, st.item_id, i.sku, i.name, st.qty, st.transaction_type, st.note, st.created_at,
+  st.reversal_of, rv.transaction_id, st.correction_of, cr.transaction_id, st.reason_code, st.ref_type, st.ref_id, st.request_id, st.actor
+FROM stock_transactions st
+JOIN items i ON i.item_id = st.item_id
+LEFT JOIN stock_transactions rv ON rv.reversal_of = st.transaction_id
+LEFT JOIN stock_transactions cr ON cr.correction_of = st.transaction_id
+WHERE 1=1`+where+`
+ORDER BY st.transaction_id DESC
+LIMIT ?
+`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]*StockTransaction, 0)
+	for rows.Next() {
+		t, err := scanStockTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, &t)
+	}
+	return out, rows.Err()
+}
+
+// serveGraphQL answers GET ?query=&variables=&operationName= and POST
+// {"query","variables","operationName"}. Field errors come back in "errors"
+// with status 200, as GraphQL clients expect.
+func serveGraphQL(schema *graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphql.Request
+		if r.Method == http.MethodGet {
+			q := r.URL.Query()
+			req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+			if v := q.Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					http.Error(w, "invalid variables", http.StatusBadRequest)
+					return
+				}
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			http.Error(w, "query is required", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(schema.Execute(r.Context(), req))
+	}
+}
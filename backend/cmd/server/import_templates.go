@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// importColumn describes one column of an import file for its template.
+type importColumn struct {
+	name string
+	desc string
+	// allowed is the column's vocabulary, if it has one.
+	allowed []string
+}
+
+// importTemplate is the file an import expects: its columns and example
+// rows, which the template writes as # comments so an unedited download
+// imports nothing.
+type importTemplate struct {
+	columns  []importColumn
+	examples [][]string
+}
+
+// importTemplates are the imports with a template, by kind. The BOM columns
+// are those of bomCSVHeader, so an export can be edited and imported back.
+var importTemplates = map[string]importTemplate{
+	"bom": {
+		columns: []importColumn{
+			{name: "sku", desc: "component SKU (required); also read from: " + strings.Join(bomSKUColumns[1:], ", ")},
+			{name: "name", desc: "component name, for reading only"},
+			{name: "qty_per_unit", desc: "quantity per assembly, > 0; without it the refs are counted"},
+			{name: "scrap_factor", desc: "expected loss, >= 0 and < 1 (default 0)"},
+			{name: "refs", desc: "reference designators, e.g. R1,R2"},
+			{name: "position", desc: "line order, a whole number >= 0"},
+			{name: "managed_unit", desc: "component unit, for reading only", allowed: []string{"g", "pcs"}},
+			{name: "note", desc: "line note"},
+		},
+		examples: [][]string{
+			{"PRT-LED", "LED module 5W", "1", "0", "D1", "1", "pcs", ""},
+			{"MAT-PLA-WHT", "PLA filament white", "85", "0.05", "", "2", "g", "printed shade"},
+		},
+	},
+}
+
+func importTemplateKinds() []string {
+	kinds := make([]string, 0, len(importTemplates))
+	for k := range importTemplates {
+		kinds = append(kinds, k)
+	}
+	slices.Sort(kinds)
+	return kinds
+}
+
+// getImportTemplate serves the header row of an import, followed by
+// comments describing each column with its allowed values and example rows.
+func getImportTemplate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kind := chi.URLParam(r, "kind")
+		t, ok := importTemplates[kind]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown import template: %s (available: %s)", kind, strings.Join(importTemplateKinds(), ", ")), http.StatusNotFound)
+			return
+		}
+
+		var buf bytes.Buffer
+		cw := csv.NewWriter(&buf)
+		header := make([]string, len(t.columns))
+		for i, c := range t.columns {
+			header[i] = c.name
+		}
+		cw.Write(header)
+		cw.Flush()
+		for _, c := range t.columns {
+			line := "# " + c.name + ": " + c.desc
+			if len(c.allowed) > 0 {
+				line += " (allowed: " + strings.Join(c.allowed, ", ") + ")"
+			}
+			buf.WriteString(line + "\n")
+		}
+		buf.WriteString("# examples:\n")
+		for _, ex := range t.examples {
+			buf.WriteString("# ")
+			cw.Write(ex)
+			cw.Flush()
+		}
+		if err := cw.Error(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-import-template.csv"`, kind))
+		_, _ = w.Write(buf.Bytes())
+	}
+}
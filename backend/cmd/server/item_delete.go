@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"stockmate/internal/storage"
+	"stockmate/internal/store"
+)
+
+type BOMUsage struct {
+	ParentItemID int64   `json:"parent_item_id"`
+	SKU          string  `json:"sku"`
+	Name         string  `json:"name"`
+	RevNo        int64   `json:"rev_no"`
+	QtyPerUnit   float64 `json:"qty_per_unit"`
+	// Alternate is set when the item is listed as a substitute on the line.
+	Alternate bool `json:"alternate,omitempty"`
+	// Obsolete revisions are kept as history and still block deletion.
+	Obsolete bool `json:"obsolete,omitempty"`
+}
+
+type ItemDependencyReport struct {
+	ItemID int64 `json:"item_id"`
+	// Deletable is true when nothing outside the item itself refers to it.
+	Deletable bool `json:"deletable"`
+	Blocking  struct {
+		BOMUsages        []BOMUsage `json:"bom_usages"`
+		TransactionCount int64      `json:"transaction_count"`
+		// PurchaseOrderLines are open lines and lines with receipts.
+		PurchaseOrderLines int64 `json:"purchase_order_lines"`
+		StocktakeLines     int64 `json:"stocktake_lines"`
+		ECOChanges         int64 `json:"eco_changes"`
+		TemplateLines      int64 `json:"bom_template_lines"`
+	} `json:"blocking"`
+	// Owned rows are removed together with the item on a forced delete.
+	Owned struct {
+		BOMRevisions  int64 `json:"bom_revisions"`
+		Attachments   int64 `json:"attachments"`
+		PurchaseLinks int64 `json:"purchase_links"`
+		// CancelledPOLines were cancelled before anything was received.
+		CancelledPOLines int64 `json:"cancelled_purchase_order_lines"`
+	} `json:"owned"`
+}
+
+func (rep *ItemDependencyReport) hasOwned() bool {
+	return rep.Owned.BOMRevisions > 0 || rep.Owned.Attachments > 0 || rep.Owned.PurchaseLinks > 0 ||
+		rep.Owned.CancelledPOLines > 0
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func loadItemDependencies(ctx context.Context, q queryer, itemID int64) (*ItemDependencyReport, error) {
+	rep := &ItemDependencyReport{ItemID: itemID}
+	rep.Blocking.BOMUsages = make([]BOMUsage, 0)
+
+	rows, err := q.QueryContext(ctx, `
+SELECT ar.item_id, i.sku, i.name, ar.rev_no, ac.qty_per_unit, 0, ar.obsolete_at IS NOT NULL
+FROM assembly_components ac
+JOIN assembly_records ar ON ar.record_id = ac.record_id
+JOIN items i ON i.item_id = ar.item_id
+WHERE ac.component_item_id = ?
+UNION ALL
+SELECT ar.item_id, i.sku, i.name, ar.rev_no, ac.qty_per_unit, 1, ar.obsolete_at IS NOT NULL
+FROM assembly_component_alternates aca
+JOIN assembly_components ac ON ac.record_id = aca.record_id AND ac.component_item_id = aca.component_item_id
+JOIN assembly_records ar ON ar.record_id = aca.record_id
+JOIN items i ON i.item_id = ar.item_id
+WHERE aca.alternate_item_id = ?
+ORDER BY 1, 4
+`, itemID, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("load bom usages: %w", err)
+	}
+	for rows.Next() {
+		var u BOMUsage
+		if err := rows.Scan(&u.ParentItemID, &u.SKU, &u.Name, &u.RevNo, &u.QtyPerUnit, &u.Alternate, &u.Obsolete); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan bom usages: %w", err)
+		}
+		rep.Blocking.BOMUsages = append(rep.Blocking.BOMUsages, u)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("read bom usages: %w", err)
+	}
+	rows.Close()
+
+	counts := []struct {
+		dst   *int64
+		query string
+	}{
+		// Builds of the item's BOM revisions count too, since the revisions
+		// go with the item.
+		{&rep.Blocking.TransactionCount, `
+SELECT COUNT(1)
+FROM stock_transactions
+WHERE item_id = ?1
+   OR bom_record_id IN (SELECT record_id FROM assembly_records WHERE item_id = ?1)
+`},
+		{&rep.Blocking.PurchaseOrderLines, `
+SELECT COUNT(1) FROM purchase_order_lines
+WHERE item_id = ? AND (cancelled_at IS NULL OR received_qty > 0)
+`},
+		{&rep.Blocking.StocktakeLines, `SELECT COUNT(1) FROM stocktake_lines WHERE item_id = ?`},
+		{&rep.Blocking.ECOChanges, `SELECT COUNT(1) FROM eco_changes WHERE parent_item_id = ?`},
+		{&rep.Blocking.TemplateLines, `SELECT COUNT(1) FROM bom_template_lines WHERE component_item_id = ?`},
+		{&rep.Owned.BOMRevisions, `SELECT COUNT(1) FROM assembly_records WHERE item_id = ?`},
+		{&rep.Owned.Attachments, `SELECT COUNT(1) FROM item_attachments WHERE item_id = ?`},
+		{&rep.Owned.PurchaseLinks, `
+SELECT COUNT(1)
+FROM component_purchase_links l
+JOIN components c ON c.component_id = l.component_id
+WHERE c.item_id = ? AND l.deleted_at IS NULL
+`},
+		{&rep.Owned.CancelledPOLines, `
+SELECT COUNT(1) FROM purchase_order_lines
+WHERE item_id = ? AND cancelled_at IS NOT NULL AND received_qty = 0
+`},
+	}
+	for _, c := range counts {
+		if err := q.QueryRowContext(ctx, c.query, itemID).Scan(c.dst); err != nil {
+			return nil, fmt.Errorf("count dependencies: %w", err)
+		}
+	}
+
+	b := rep.Blocking
+	rep.Deletable = len(b.BOMUsages) == 0 && b.TransactionCount == 0 && b.PurchaseOrderLines == 0 &&
+		b.StocktakeLines == 0 && b.ECOChanges == 0 && b.TemplateLines == 0
+	return rep, nil
+}
+
+func getItemDependencies(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		itemID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var exists int
+		if err := dbx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM items WHERE item_id = ?`, itemID).Scan(&exists); err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if exists == 0 {
+			http.Error(w, "item not found", http.StatusNotFound)
+			return
+		}
+
+		rep, err := loadItemDependencies(r.Context(), dbx, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rep)
+	}
+}
+
+// deleteItem removes an item that nothing else depends on. Items that still
+// own BOM revisions, attachments, purchase links or cancelled purchase order
+// lines need ?force=1; items referenced by other BOMs, stock transactions,
+// purchase orders, stocktakes, ECOs or BOM templates are never deleted.
+func deleteItem(dbx *sql.DB, blobs storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		itemID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		force := false
+		switch r.URL.Query().Get("force") {
+		case "", "0", "false":
+		case "1", "true":
+			force = true
+		default:
+			http.Error(w, "invalid force", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var sku, name string
+		err = tx.QueryRowContext(r.Context(), `SELECT sku, name FROM items WHERE item_id = ?`, itemID).Scan(&sku, &name)
+		if err == sql.ErrNoRows {
+			http.Error(w, "item not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+
+		rep, err := loadItemDependencies(r.Context(), tx, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !rep.Deletable || (rep.hasOwned() && !force) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(rep)
+			return
+		}
+
+		attachmentKeys := make([]string, 0)
+		keyRows, err := tx.QueryContext(r.Context(), `SELECT storage_key FROM item_attachments WHERE item_id = ?`, itemID)
+		if err != nil {
+			http.Error(w, "failed to load attachments", http.StatusInternalServerError)
+			return
+		}
+		for keyRows.Next() {
+			var key string
+			if err := keyRows.Scan(&key); err != nil {
+				keyRows.Close()
+				http.Error(w, "failed to load attachments", http.StatusInternalServerError)
+				return
+			}
+			attachmentKeys = append(attachmentKeys, key)
+		}
+		keyRows.Close()
+
+		// assembly/component detail, BOM revisions, purchase links and
+		// attachment rows all cascade from items; purchase order lines are
+		// restricted, so the cancelled ones go first.
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM purchase_order_lines WHERE item_id = ?`, itemID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM items WHERE item_id = ?`, itemID); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err := logItemChange(r.Context(), tx, itemID, sku, name, "deleted"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		// A dry run keeps the attachment rows, so their files stay too.
+		if !store.IsDryRun(r.Context()) {
+			for _, key := range attachmentKeys {
+				if err := blobs.Delete(r.Context(), key); err != nil {
+					fmt.Println("attachment blob delete failed:", key, err)
+				}
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/url"
+
+	"stockmate/internal/querybuilder"
+)
+
+// itemListFilter builds the conditions of GET /api/items from its query,
+// on the i, s, a and c aliases of loadItems:
+//
+//	q                sku or name contains
+//	item_type        component or assembly
+//	series_id
+//	output_category  assembly, material, part or consumable (comma list),
+//	                 the group an item is counted and reported under
+//	managed, sellable, final  yes/no
+//	manufacturer     assembly or component manufacturer contains
+//
+// plus the cf.<key> and lifecycle filters every item listing takes.
+func itemListFilter(ctx context.Context, q queryer, query url.Values) (string, []any, error) {
+	var w querybuilder.Where
+	w.Search(query, "q", "i.sku", "i.name")
+	if err := w.OneOf(query, "item_type", "i.item_type", "component", "assembly"); err != nil {
+		return "", nil, err
+	}
+	if err := w.ID(query, "series_id", "i.series_id"); err != nil {
+		return "", nil, err
+	}
+	if err := w.OneOf(query, "output_category", itemCategory, stocktakeCategories...); err != nil {
+		return "", nil, err
+	}
+	if err := itemFlagFilter(&w, query); err != nil {
+		return "", nil, err
+	}
+	w.Search(query, "manufacturer", "COALESCE(a.manufacturer, c.manufacturer)")
+	if err := commonItemFilter(ctx, q, &w, query); err != nil {
+		return "", nil, err
+	}
+	return w.SQL(), w.Args(), nil
+}
+
+// itemFlagFilter adds the managed, sellable and final yes/no filters.
+func itemFlagFilter(w *querybuilder.Where, query url.Values) error {
+	for _, f := range []struct{ param, column string }{
+		{"managed", "i.stock_managed"},
+		{"sellable", "i.is_sellable"},
+		{"final", "i.is_final"},
+	} {
+		if err := w.Flag(query, f.param, f.column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commonItemFilter adds the cf.<key> and lifecycle filters.
+func commonItemFilter(ctx context.Context, q queryer, w *querybuilder.Where, query url.Values) error {
+	cfClause, cfArgs, err := customFieldFilter(ctx, q, query)
+	if err != nil {
+		return err
+	}
+	w.Append(cfClause, cfArgs)
+	lcClause, lcArgs, err := lifecycleFilter(query)
+	if err != nil {
+		return err
+	}
+	w.Append(lcClause, lcArgs)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"stockmate/internal/validate"
+)
+
+// ItemMeasures are the physical data of one managed unit of an item:
+// weight in grams, length, width and height in millimetres and volume in
+// cm³. Gram-managed items have none, since their unit is the gram itself.
+type ItemMeasures struct {
+	Weight *float64 `json:"weight,omitempty"`
+	Length *float64 `json:"length,omitempty"`
+	Width  *float64 `json:"width,omitempty"`
+	Height *float64 `json:"height,omitempty"`
+	// Volume defaults to the box of the dimensions when they are sent
+	// without it.
+	Volume *float64 `json:"volume,omitempty"`
+}
+
+func (m *ItemMeasures) fields() []struct {
+	name string
+	v    **float64
+} {
+	return []struct {
+		name string
+		v    **float64
+	}{
+		{"weight", &m.Weight},
+		{"length", &m.Length},
+		{"width", &m.Width},
+		{"height", &m.Height},
+		{"volume", &m.Volume},
+	}
+}
+
+// validate checks measures sent for an item managed in unit. On create
+// every value must be positive; on update 0 clears it. The dimensions are
+// sent together, and an explicit volume must fit in their box.
+func (m *ItemMeasures) validate(errs *validate.Errors, unit string, update bool) {
+	for _, f := range m.fields() {
+		if *f.v == nil {
+			continue
+		}
+		v := **f.v
+		if update {
+			errs.Check(v >= 0, f.name, "must be >= 0")
+		} else {
+			errs.Check(v > 0, f.name, "must be > 0")
+		}
+		if unit == "g" && v != 0 {
+			errs.Add(f.name, "not allowed for gram-managed items")
+		}
+	}
+	dims := 0
+	for _, v := range []*float64{m.Length, m.Width, m.Height} {
+		if v != nil {
+			dims++
+		}
+	}
+	errs.Check(dims == 0 || dims == 3, "length", "length, width and height go together")
+	if dims == 3 && m.Volume != nil && *m.Volume > 0 && !errs.Has("length") {
+		errs.Check(*m.Volume <= m.box(), "volume", "must fit in length x width x height")
+	}
+}
+
+// box is the volume in cm³ of the dimensions, 0 when they are cleared.
+func (m *ItemMeasures) box() float64 {
+	if m.Length == nil || m.Width == nil || m.Height == nil {
+		return 0
+	}
+	return *m.Length * *m.Width * *m.Height / 1000
+}
+
+// saveItemMeasures writes the measures that were sent, 0 as NULL. A volume
+// left out is set to the box of new dimensions, in m too. Gram-managed
+// items lose any they had.
+func saveItemMeasures(ctx context.Context, tx *sql.Tx, itemID int64, unit string, m *ItemMeasures) error {
+	if unit == "g" {
+		_, err := tx.ExecContext(ctx, `
+UPDATE items SET weight = NULL, length = NULL, width = NULL, height = NULL, volume = NULL
+WHERE item_id = ?
+`, itemID)
+		return err
+	}
+	if m.Volume == nil && m.Length != nil {
+		box := m.box()
+		m.Volume = &box
+	}
+	for _, f := range m.fields() {
+		if *f.v == nil {
+			continue
+		}
+		var val any
+		if **f.v > 0 {
+			val = **f.v
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE items SET `+f.name+` = ? WHERE item_id = ?`, val, itemID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nullMeasures scans i.weight, i.length, i.width, i.height and i.volume.
+type nullMeasures [5]sql.NullFloat64
+
+func (n *nullMeasures) measures() ItemMeasures {
+	var m ItemMeasures
+	for i, f := range m.fields() {
+		if n[i].Valid {
+			v := n[i].Float64
+			*f.v = &v
+		}
+	}
+	return m
+}
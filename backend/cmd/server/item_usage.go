@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/timeutil"
+)
+
+const (
+	// usageRecentDays is the window of ConsumedRecent.
+	usageRecentDays = 90
+	// usageAverageDays bounds the history AvgMonthlyConsumption is taken
+	// over.
+	usageAverageDays = 365
+	daysPerMonth     = 365.0 / 12
+)
+
+// ItemUsage is how much an item is used, for its detail page.
+type ItemUsage struct {
+	// UsedInAssemblies counts the assemblies whose current BOM revision
+	// lists the item.
+	UsedInAssemblies int64 `json:"used_in_assemblies"`
+	// ConsumedRecent is the OUT quantity of the last 90 days; reversed
+	// rows don't count.
+	ConsumedRecent float64 `json:"consumed_90d"`
+	// AvgMonthlyConsumption spreads the OUT quantity of the last year over
+	// the months it covers, or since the item's first movement if later.
+	AvgMonthlyConsumption float64        `json:"avg_monthly_consumption"`
+	LastMovementAt        *timeutil.Time `json:"last_movement_at"`
+}
+
+type ItemDetail struct {
+	Item
+	Usage ItemUsage `json:"usage"`
+}
+
+// consumedSince sums the item's unreversed OUT rows from since on; it
+// reads the (item_id, created_at) index.
+const consumedSince = `
+SELECT COALESCE(SUM(st.qty), 0)
+FROM stock_transactions st
+WHERE st.item_id = ?
+  AND st.created_at >= ?
+  AND st.transaction_type = 'OUT'
+  AND NOT EXISTS (SELECT 1 FROM stock_transactions rv WHERE rv.reversal_of = st.transaction_id)
+`
+
+func loadItemUsage(ctx context.Context, q queryer, itemID int64, now time.Time) (ItemUsage, error) {
+	var u ItemUsage
+	if err := q.QueryRowContext(ctx, `
+SELECT COUNT(DISTINCT ar.item_id)
+FROM assembly_components ac
+JOIN assembly_records ar ON ar.record_id = ac.record_id
+WHERE ac.component_item_id = ?
+  AND ar.obsolete_at IS NULL
+  AND ar.rev_no = (
+    SELECT MAX(ar2.rev_no) FROM assembly_records ar2
+    WHERE ar2.item_id = ar.item_id AND ar2.obsolete_at IS NULL
+  )
+`, itemID).Scan(&u.UsedInAssemblies); err != nil {
+		return u, err
+	}
+
+	recentFrom := now.AddDate(0, 0, -usageRecentDays)
+	if err := q.QueryRowContext(ctx, consumedSince, itemID, timeutil.Format(recentFrom)).Scan(&u.ConsumedRecent); err != nil {
+		return u, err
+	}
+
+	var first, last sql.NullString
+	if err := q.QueryRowContext(ctx, `
+SELECT MIN(created_at), MAX(created_at) FROM stock_transactions WHERE item_id = ?
+`, itemID).Scan(&first, &last); err != nil {
+		return u, err
+	}
+	if !last.Valid {
+		return u, nil
+	}
+	u.LastMovementAt = &timeutil.Time{}
+	if err := u.LastMovementAt.Scan(last.String); err != nil {
+		return u, err
+	}
+
+	averageFrom := now.AddDate(0, 0, -usageAverageDays)
+	if t, err := timeutil.Parse(first.String); err == nil && t.After(averageFrom) {
+		averageFrom = t
+	}
+	var consumed float64
+	if err := q.QueryRowContext(ctx, consumedSince, itemID, timeutil.Format(averageFrom)).Scan(&consumed); err != nil {
+		return u, err
+	}
+	// Less than a month of history counts as one month.
+	months := math.Max(now.Sub(averageFrom).Hours()/24/daysPerMonth, 1)
+	u.AvgMonthlyConsumption = consumed / months
+	return u, nil
+}
+
+// getItem returns one item with its usage stats.
+func getItem(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		items, err := loadItems(r.Context(), dbx, " AND i.item_id = ?", []any{itemID}, "", 1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(items) == 0 {
+			http.Error(w, "item not found", http.StatusNotFound)
+			return
+		}
+		usage, err := loadItemUsage(r.Context(), dbx, itemID, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ItemDetail{Item: items[0], Usage: usage})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const maxLookupSKUs = 500
+
+type ItemLookupResult struct {
+	Items    []LookupItem `json:"items"`
+	NotFound []string     `json:"not_found"`
+}
+
+type LookupItem struct {
+	Item
+	StockQty float64 `json:"stock_qty"`
+}
+
+// lookupItems resolves a pasted or scanned list of SKUs in one query. Items
+// come back in the order the SKUs were given; duplicates and blanks are
+// dropped.
+func lookupItems(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		SKUs []string `json:"skus"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+
+		skus := make([]string, 0, len(req.SKUs))
+		seen := make(map[string]bool, len(req.SKUs))
+		for _, sku := range req.SKUs {
+			sku = strings.TrimSpace(sku)
+			if sku == "" || seen[sku] {
+				continue
+			}
+			seen[sku] = true
+			skus = append(skus, sku)
+		}
+		if len(skus) == 0 {
+			http.Error(w, "skus required", http.StatusBadRequest)
+			return
+		}
+		if len(skus) > maxLookupSKUs {
+			http.Error(w, fmt.Sprintf("too many skus (max %d)", maxLookupSKUs), http.StatusBadRequest)
+			return
+		}
+
+		args := make([]any, len(skus))
+		for i, sku := range skus {
+			args[i] = sku
+		}
+		rows, err := dbx.QueryContext(r.Context(), `
+SELECT
+  i.item_id,
+  i.series_id,
+  s.name,
+  i.sku,
+  i.name,
+  i.item_type,
+  i.pack_qty,
+  i.reorder_point,
+  i.managed_unit,
+  i.stock_managed,
+  i.is_sellable,
+  i.is_final,
+  i.lifecycle_status,
+  i.updated_at,
+  COALESCE((
+    SELECT SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END)
+    FROM stock_transactions st
+    WHERE st.item_id = i.item_id
+  ), 0)
+FROM items i
+LEFT JOIN series s ON s.series_id = i.series_id
+WHERE i.sku IN (`+strings.TrimSuffix(strings.Repeat("?,", len(skus)), ",")+`)
+`, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		found := make(map[string]LookupItem, len(skus))
+		for rows.Next() {
+			var it LookupItem
+			var seriesID sql.NullInt64
+			var seriesName sql.NullString
+			var packQty, reorderPoint sql.NullFloat64
+			var sm, sellable, final int
+			if err := rows.Scan(
+				&it.ID,
+				&seriesID,
+				&seriesName,
+				&it.SKU,
+				&it.Name,
+				&it.ItemType,
+				&packQty,
+				&reorderPoint,
+				&it.ManagedUnit,
+				&sm,
+				&sellable,
+				&final,
+				&it.LifecycleStatus,
+				&it.UpdatedAt,
+				&it.StockQty,
+			); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if seriesID.Valid {
+				v := seriesID.Int64
+				it.SeriesID = &v
+			}
+			if seriesName.Valid {
+				it.SeriesName = seriesName.String
+			}
+			if packQty.Valid {
+				v := packQty.Float64
+				it.PackQty = &v
+			}
+			if reorderPoint.Valid {
+				v := reorderPoint.Float64
+				it.ReorderPoint = &v
+			}
+			it.StockManaged = sm != 0
+			it.IsSellable = sellable != 0
+			it.IsFinal = final != 0
+			found[it.SKU] = it
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		out := ItemLookupResult{
+			Items:    make([]LookupItem, 0, len(found)),
+			NotFound: make([]string, 0),
+		}
+		for _, sku := range skus {
+			if it, ok := found[sku]; ok {
+				out.Items = append(out.Items, it)
+			} else {
+				out.NotFound = append(out.NotFound, sku)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPickerLimit = 20
+	maxPickerLimit     = 50
+)
+
+// PickerItem is the slim item shape for search-as-you-type pickers.
+type PickerItem struct {
+	ID       int64   `json:"id"`
+	SKU      string  `json:"sku"`
+	Name     string  `json:"name"`
+	Unit     string  `json:"unit"`
+	StockQty float64 `json:"stock_qty"`
+}
+
+// pickerTypes maps ?type= to a condition: an item type, or a component type.
+var pickerTypes = map[string]string{
+	"assembly":   "i.item_type = 'assembly'",
+	"component":  "i.item_type = 'component'",
+	"material":   "c.component_type = 'material'",
+	"part":       "c.component_type = 'part'",
+	"consumable": "c.component_type = 'consumable'",
+}
+
+// pickerETag is itemListETag plus the ledger's high-water mark, since the
+// picker also shows stock.
+func pickerETag(ctx context.Context, q queryer, r *http.Request) (string, error) {
+	var count, lastTxn int64
+	var latest string
+	if err := q.QueryRowContext(ctx, `
+SELECT
+  (SELECT COUNT(1) FROM items),
+  (SELECT COALESCE(MAX(updated_at), '') FROM items),
+  (SELECT COALESCE(MAX(transaction_id), 0) FROM stock_transactions)
+`).Scan(&count, &latest, &lastTxn); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(r.URL.RawQuery + "\n" + strconv.FormatInt(count, 10) + "\n" + latest + "\n" + strconv.FormatInt(lastTxn, 10)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// listPickerItems searches items by ?q= (SKU or name) for pickers, such as
+// the BOM editor's component picker. Obsolete items are left out since they
+// can't be used on new revisions. Exact and leading SKU matches come first,
+// and at most ?limit= (default 20, max 50) items are returned. Clients may
+// reuse a response for a few seconds and revalidate it by ETag after that.
+func listPickerItems(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fields, err := parseFields[PickerItem](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+		limit, ok := queryInt(query.Get("limit"), defaultPickerLimit, 1, maxPickerLimit)
+		if !ok {
+			http.Error(w, "limit must be 1-50", http.StatusBadRequest)
+			return
+		}
+		typ := strings.ToLower(strings.TrimSpace(query.Get("type")))
+		typeClause := ""
+		if typ != "" {
+			cond, ok := pickerTypes[typ]
+			if !ok {
+				http.Error(w, "type must be assembly, component, material, part or consumable", http.StatusBadRequest)
+				return
+			}
+			typeClause = " AND " + cond
+		}
+
+		etag, err := pickerETag(r.Context(), dbx, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if notModified(w, r, etag) {
+			return
+		}
+		w.Header().Set("Cache-Control", "private, max-age=10")
+
+		q := strings.TrimSpace(query.Get("q"))
+		like := "%" + q + "%"
+		rows, err := dbx.QueryContext(r.Context(), `
+SELECT
+  i.item_id, i.sku, i.name, i.managed_unit,
+  COALESCE((
+    SELECT SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END)
+    FROM stock_transactions st
+    WHERE st.item_id = i.item_id
+  ), 0)
+FROM items i
+LEFT JOIN components c ON c.item_id = i.item_id
+WHERE i.lifecycle_status <> 'obsolete'
+  AND (?1 = '' OR i.sku LIKE ?2 OR i.name LIKE ?2)`+typeClause+`
+ORDER BY
+  CASE WHEN i.sku = ?1 THEN 0 WHEN i.sku LIKE ?1 || '%' THEN 1 ELSE 2 END,
+  i.sku
+LIMIT ?3
+`, q, like, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]PickerItem, 0)
+		for rows.Next() {
+			var it PickerItem
+			if err := rows.Scan(&it.ID, &it.SKU, &it.Name, &it.Unit, &it.StockQty); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, it)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeList(w, fields, out)
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/querybuilder"
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+const maxLandedCostLines = 500
+
+type LandedCostCharge struct {
+	Label    string  `json:"label"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Rate     float64 `json:"rate"`
+}
+
+type LandedCostAllocation struct {
+	TransactionID int64   `json:"transaction_id"`
+	ItemID        int64   `json:"item_id"`
+	SKU           string  `json:"sku"`
+	Qty           float64 `json:"qty"`
+	// Basis is the line's value (base currency) or weight (g).
+	Basis          float64  `json:"basis"`
+	Amount         float64  `json:"amount"`
+	UnitCostBefore *float64 `json:"unit_cost_before,omitempty"`
+	UnitCostAfter  float64  `json:"unit_cost_after"`
+}
+
+type LandedCost struct {
+	ID          int64                  `json:"id"`
+	Method      string                 `json:"method"`
+	Amount      float64                `json:"amount"`
+	Currency    string                 `json:"currency"`
+	Note        string                 `json:"note,omitempty"`
+	CreatedAt   timeutil.Time          `json:"created_at"`
+	Charges     []LandedCostCharge     `json:"charges,omitempty"`
+	Allocations []LandedCostAllocation `json:"allocations,omitempty"`
+}
+
+// landedLine is a received transaction with what allocation needs to know.
+type landedLine struct {
+	LandedCostAllocation
+	onHand   float64
+	unitCost sql.NullFloat64
+	// rate converts the item's cost currency to the base currency.
+	rate   sql.NullFloat64
+	weight sql.NullFloat64
+}
+
+// createLandedCost spreads freight/customs charges over received IN
+// transactions by value or by weight, and raises each item's unit cost by its
+// share averaged over the stock on hand.
+func createLandedCost(dbx *sql.DB) http.HandlerFunc {
+	type ChargeReq struct {
+		Label    string  `json:"label"`
+		Amount   float64 `json:"amount"`
+		Currency string  `json:"currency"`
+	}
+	type Req struct {
+		TransactionIDs []int64     `json:"transaction_ids"`
+		Method         string      `json:"method"`
+		Charges        []ChargeReq `json:"charges"`
+		Note           string      `json:"note"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Method = strings.ToLower(strings.TrimSpace(req.Method))
+		if req.Method == "" {
+			req.Method = "value"
+		}
+		if req.Method != "value" && req.Method != "weight" {
+			http.Error(w, "method must be value or weight", http.StatusBadRequest)
+			return
+		}
+		if len(req.TransactionIDs) == 0 {
+			http.Error(w, "transaction_ids required", http.StatusBadRequest)
+			return
+		}
+		if len(req.TransactionIDs) > maxLandedCostLines {
+			http.Error(w, fmt.Sprintf("too many transactions (max %d)", maxLandedCostLines), http.StatusBadRequest)
+			return
+		}
+		if len(req.Charges) == 0 {
+			http.Error(w, "charges required", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		base, err := baseCurrency(r.Context(), tx)
+		if err != nil {
+			http.Error(w, "failed to load base currency", http.StatusInternalServerError)
+			return
+		}
+		lc := LandedCost{Method: req.Method, Currency: base, Note: strings.TrimSpace(req.Note)}
+		for i, c := range req.Charges {
+			label := strings.TrimSpace(c.Label)
+			if label == "" {
+				http.Error(w, fmt.Sprintf("charges[%d]: label required", i), http.StatusBadRequest)
+				return
+			}
+			if c.Amount < 0 {
+				http.Error(w, fmt.Sprintf("charges[%d]: amount must be >= 0", i), http.StatusBadRequest)
+				return
+			}
+			if strings.TrimSpace(c.Currency) == "" {
+				c.Currency = base
+			}
+			code, problem, err := resolveCurrency(r.Context(), tx, c.Currency)
+			if err != nil {
+				http.Error(w, "failed to load currency", http.StatusInternalServerError)
+				return
+			}
+			if problem != "" {
+				http.Error(w, fmt.Sprintf("charges[%d]: %s", i, problem), http.StatusBadRequest)
+				return
+			}
+			var rate float64
+			if err := tx.QueryRowContext(r.Context(), `SELECT rate FROM currencies WHERE code = ?`, code).Scan(&rate); err != nil {
+				http.Error(w, "failed to load currency", http.StatusInternalServerError)
+				return
+			}
+			lc.Charges = append(lc.Charges, LandedCostCharge{Label: label, Amount: c.Amount, Currency: code, Rate: rate})
+			lc.Amount += c.Amount * rate
+		}
+
+		lines := make([]landedLine, 0, len(req.TransactionIDs))
+		seen := make(map[int64]bool)
+		var basisTotal float64
+		for i, txnID := range req.TransactionIDs {
+			if seen[txnID] {
+				http.Error(w, fmt.Sprintf("transaction_ids[%d]: duplicate", i), http.StatusBadRequest)
+				return
+			}
+			seen[txnID] = true
+
+			var l landedLine
+			var txnType, managedUnit string
+			var reversed, isReversal int
+			err := tx.QueryRowContext(r.Context(), `
+SELECT
+  st.transaction_id, st.item_id, i.sku, st.qty, st.transaction_type, i.managed_unit, i.unit_cost,
+  CASE WHEN i.unit_cost_currency IS NULL THEN 1 ELSE (SELECT rate FROM currencies WHERE code = i.unit_cost_currency) END,
+  COALESCE(i.weight, a.total_weight),
+  EXISTS (SELECT 1 FROM stock_transactions rv WHERE rv.reversal_of = st.transaction_id),
+  st.reversal_of IS NOT NULL,
+  COALESCE((
+    SELECT SUM(CASE WHEN x.transaction_type = 'OUT' THEN -x.qty ELSE x.qty END)
+    FROM stock_transactions x
+    WHERE x.item_id = st.item_id
+  ), 0)
+FROM stock_transactions st
+JOIN items i ON i.item_id = st.item_id
+LEFT JOIN assemblies a ON a.item_id = st.item_id
+WHERE st.transaction_id = ?
+`, txnID).Scan(&l.TransactionID, &l.ItemID, &l.SKU, &l.Qty, &txnType, &managedUnit, &l.unitCost,
+				&l.rate, &l.weight, &reversed, &isReversal, &l.onHand)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					http.Error(w, fmt.Sprintf("transaction not found: %d", txnID), http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "failed to load transaction", http.StatusInternalServerError)
+				return
+			}
+			if txnType != "IN" || reversed != 0 || isReversal != 0 {
+				http.Error(w, fmt.Sprintf("transaction %d is not an active receipt", txnID), http.StatusBadRequest)
+				return
+			}
+			if !l.rate.Valid {
+				http.Error(w, fmt.Sprintf("%s: unit cost currency has no rate", l.SKU), http.StatusBadRequest)
+				return
+			}
+
+			switch req.Method {
+			case "value":
+				if !l.unitCost.Valid {
+					http.Error(w, fmt.Sprintf("%s: unit_cost required for value allocation", l.SKU), http.StatusBadRequest)
+					return
+				}
+				l.Basis = l.Qty * l.unitCost.Float64 * l.rate.Float64
+			case "weight":
+				// Items managed in grams weigh their quantity; others use
+				// their weight, or an assembly's total_weight, per unit.
+				switch {
+				case managedUnit == "g":
+					l.Basis = l.Qty
+				case l.weight.Valid:
+					l.Basis = l.Qty * l.weight.Float64
+				default:
+					http.Error(w, fmt.Sprintf("%s: weight unknown for weight allocation", l.SKU), http.StatusBadRequest)
+					return
+				}
+			}
+			basisTotal += l.Basis
+			lines = append(lines, l)
+		}
+		if basisTotal <= 0 {
+			http.Error(w, "allocation basis is zero", http.StatusBadRequest)
+			return
+		}
+
+		res, err := tx.ExecContext(r.Context(), `
+INSERT INTO landed_costs(method, amount, currency, note) VALUES(?,?,?,?)
+`, lc.Method, lc.Amount, lc.Currency, lc.Note)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lc.ID, _ = res.LastInsertId()
+		for _, c := range lc.Charges {
+			if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO landed_cost_charges(landed_cost_id, label, amount, currency, rate) VALUES(?,?,?,?,?)
+`, lc.ID, c.Label, c.Amount, c.Currency, c.Rate); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		for _, l := range lines {
+			a := l.LandedCostAllocation
+			a.Amount = lc.Amount * l.Basis / basisTotal
+			// The share is spread over all units on hand, as the received
+			// units are no longer told apart from older stock.
+			units := max(l.onHand, l.Qty)
+			// Re-read the cost: several lines may share an item.
+			var unitCost sql.NullFloat64
+			if err := tx.QueryRowContext(r.Context(), `SELECT unit_cost FROM items WHERE item_id = ?`, l.ItemID).Scan(&unitCost); err != nil {
+				http.Error(w, "failed to load item", http.StatusInternalServerError)
+				return
+			}
+			if unitCost.Valid {
+				v := unitCost.Float64
+				a.UnitCostBefore = &v
+			}
+			a.UnitCostAfter = unitCost.Float64 + a.Amount/l.rate.Float64/units
+			if _, err := tx.ExecContext(r.Context(), `UPDATE items SET unit_cost = ? WHERE item_id = ?`, a.UnitCostAfter, l.ItemID); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO landed_cost_allocations(landed_cost_id, transaction_id, item_id, qty, basis, amount, unit_cost_before, unit_cost_after)
+VALUES(?,?,?,?,?,?,?,?)
+`, lc.ID, a.TransactionID, a.ItemID, a.Qty, a.Basis, a.Amount, a.UnitCostBefore, a.UnitCostAfter); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		out, err := loadLandedCost(r.Context(), tx, lc.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func getLandedCost(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		writeLandedCost(w, r, dbx, id, http.StatusOK)
+	}
+}
+
+func writeLandedCost(w http.ResponseWriter, r *http.Request, dbx *sql.DB, id int64, status int) {
+	lc, err := loadLandedCost(r.Context(), dbx, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "landed cost not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(lc)
+}
+
+// loadLandedCost reads a landed cost with its charges and allocations;
+// sql.ErrNoRows when there is none.
+func loadLandedCost(ctx context.Context, q queryer, id int64) (LandedCost, error) {
+	lc := LandedCost{Charges: make([]LandedCostCharge, 0), Allocations: make([]LandedCostAllocation, 0)}
+	if err := q.QueryRowContext(ctx, `
+SELECT landed_cost_id, method, amount, currency, note, created_at FROM landed_costs WHERE landed_cost_id = ?
+`, id).Scan(&lc.ID, &lc.Method, &lc.Amount, &lc.Currency, &lc.Note, &lc.CreatedAt); err != nil {
+		return lc, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+SELECT label, amount, currency, rate FROM landed_cost_charges WHERE landed_cost_id = ? ORDER BY charge_id
+`, id)
+	if err != nil {
+		return lc, err
+	}
+	for rows.Next() {
+		var c LandedCostCharge
+		if err := rows.Scan(&c.Label, &c.Amount, &c.Currency, &c.Rate); err != nil {
+			rows.Close()
+			return lc, err
+		}
+		lc.Charges = append(lc.Charges, c)
+	}
+	rows.Close()
+
+	rows, err = q.QueryContext(ctx, `
+SELECT la.transaction_id, la.item_id, i.sku, la.qty, la.basis, la.amount, la.unit_cost_before, la.unit_cost_after
+FROM landed_cost_allocations la
+JOIN items i ON i.item_id = la.item_id
+WHERE la.landed_cost_id = ?
+ORDER BY la.transaction_id
+`, id)
+	if err != nil {
+		return lc, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a LandedCostAllocation
+		var before sql.NullFloat64
+		if err := rows.Scan(&a.TransactionID, &a.ItemID, &a.SKU, &a.Qty, &a.Basis, &a.Amount, &before, &a.UnitCostAfter); err != nil {
+			return lc, err
+		}
+		if before.Valid {
+			v := before.Float64
+			a.UnitCostBefore = &v
+		}
+		lc.Allocations = append(lc.Allocations, a)
+	}
+	return lc, rows.Err()
+}
+
+// listLandedCosts returns recent allocations without lines; ?transaction_id=
+// narrows to those touching one receipt.
+func listLandedCosts(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var where querybuilder.Where
+		if err := where.ID(r.URL.Query(), "transaction_id", "la.transaction_id"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := `
+SELECT lc.landed_cost_id, lc.method, lc.amount, lc.currency, lc.note, lc.created_at
+FROM landed_costs lc
+WHERE 1=1`
+		if where.SQL() != "" {
+			query += ` AND EXISTS (SELECT 1 FROM landed_cost_allocations la WHERE la.landed_cost_id = lc.landed_cost_id` + where.SQL() + `)`
+		}
+		query += `
+ORDER BY lc.landed_cost_id DESC
+LIMIT 200
+`
+
+		rows, err := dbx.QueryContext(r.Context(), query, where.Args()...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]LandedCost, 0)
+		for rows.Next() {
+			var lc LandedCost
+			if err := rows.Scan(&lc.ID, &lc.Method, &lc.Amount, &lc.Currency, &lc.Note, &lc.CreatedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, lc)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
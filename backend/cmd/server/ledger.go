@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/querybuilder"
+	"stockmate/internal/timeutil"
+)
+
+type LedgerEntry struct {
+	ID              int64         `json:"id"`
+	Qty             float64       `json:"qty"`
+	TransactionType string        `json:"transaction_type"`
+	Note            string        `json:"note,omitempty"`
+	CreatedAt       timeutil.Time `json:"created_at"`
+	ReversalOf      *int64        `json:"reversal_of,omitempty"`
+	ReversedBy      *int64        `json:"reversed_by,omitempty"`
+	ReasonCode      string        `json:"reason_code,omitempty"`
+	RefType         string        `json:"ref_type,omitempty"`
+	RefID           string        `json:"ref_id,omitempty"`
+	// Delta is the signed effect on stock (OUT is negative) and Balance the
+	// on-hand quantity right after this entry.
+	Delta   float64 `json:"delta"`
+	Balance float64 `json:"balance"`
+}
+
+type ItemLedger struct {
+	ItemID         int64         `json:"item_id"`
+	SKU            string        `json:"sku"`
+	Name           string        `json:"name"`
+	OpeningBalance float64       `json:"opening_balance"`
+	ClosingBalance float64       `json:"closing_balance"`
+	Entries        []LedgerEntry `json:"entries"`
+}
+
+// getItemLedger lists an item's transactions oldest first with the running
+// balance after each one. Entries are ordered by created_at, then id, the
+// same order snapshots use, and balances always count the full history, so
+// ?from= / ?to= (YYYY-MM-DD in ?tz=), ?ref_type= / ?ref_id=, ?q= (words in
+// the note) and ?limit= (the latest N, default 1000) only narrow what is
+// returned.
+func getItemLedger(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		limit, err := querybuilder.Limit(r.URL.Query(), 1000, 10000)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		where := ""
+		args := []any{itemID}
+		toCutoff := ""
+		for _, p := range []struct {
+			param string
+			op    string
+		}{{"from", ">="}, {"to", "<"}} {
+			v := strings.TrimSpace(r.URL.Query().Get(p.param))
+			if v == "" {
+				continue
+			}
+			d, err := timeutil.StartOfDay(v, loc)
+			if err != nil {
+				http.Error(w, "invalid "+p.param+" (want YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+			if p.param == "to" {
+				// "to" is inclusive of the whole day.
+				d = d.AddDate(0, 0, 1)
+				toCutoff = timeutil.Format(d)
+			}
+			where += " AND created_at " + p.op + " ?"
+			args = append(args, timeutil.Format(d))
+		}
+		if refType := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("ref_type"))); refType != "" {
+			if !refTypes[refType] {
+				http.Error(w, "invalid ref_type", http.StatusBadRequest)
+				return
+			}
+			where += " AND ref_type = ?"
+			args = append(args, refType)
+		}
+		if refID := strings.TrimSpace(r.URL.Query().Get("ref_id")); refID != "" {
+			where += " AND ref_id = ?"
+			args = append(args, refID)
+		}
+		noteClause, noteArgs := noteSearch("note", r.URL.Query().Get("q"))
+		where += noteClause
+		args = append(args, noteArgs...)
+		args = append(args, limit)
+
+		out := ItemLedger{ItemID: itemID, Entries: make([]LedgerEntry, 0)}
+		if err := dbx.QueryRowContext(r.Context(), `SELECT sku, name FROM items WHERE item_id = ?`, itemID).Scan(&out.SKU, &out.Name); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "item not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := dbx.QueryContext(r.Context(), `
+WITH ledger AS (
+  SELECT
+    st.transaction_id,
+    st.qty,
+    st.transaction_type,
+    st.note,
+    st.created_at,
+    st.reversal_of,
+    rv.transaction_id AS reversed_by,
+    st.reason_code,
+    st.ref_type,
+    st.ref_id,
+    CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END AS delta,
+    SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END)
+      OVER (ORDER BY st.created_at, st.transaction_id) AS balance
+  FROM stock_transactions st
+  LEFT JOIN stock_transactions rv ON rv.reversal_of = st.transaction_id
+  WHERE st.item_id = ?
+)
+SELECT * FROM ledger
+WHERE 1=1`+where+`
+ORDER BY created_at DESC, transaction_id DESC
+LIMIT ?
+`, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var e LedgerEntry
+			var note, reasonCode, refType, refID sql.NullString
+			var reversalOf, reversedBy sql.NullInt64
+			if err := rows.Scan(&e.ID, &e.Qty, &e.TransactionType, &note, &e.CreatedAt, &reversalOf, &reversedBy, &reasonCode, &refType, &refID, &e.Delta, &e.Balance); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			e.Note = note.String
+			e.ReasonCode = reasonCode.String
+			e.RefType = refType.String
+			e.RefID = refID.String
+			if reversalOf.Valid {
+				v := reversalOf.Int64
+				e.ReversalOf = &v
+			}
+			if reversedBy.Valid {
+				v := reversedBy.Int64
+				e.ReversedBy = &v
+			}
+			out.Entries = append(out.Entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rows.Close()
+		slices.Reverse(out.Entries)
+
+		if n := len(out.Entries); n > 0 {
+			out.OpeningBalance = out.Entries[0].Balance - out.Entries[0].Delta
+			out.ClosingBalance = out.Entries[n-1].Balance
+		} else {
+			// Nothing in range: the balance is whatever stood at its end.
+			q := `SELECT COALESCE(SUM(CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END), 0) FROM stock_transactions WHERE item_id = ?`
+			balanceArgs := []any{itemID}
+			if toCutoff != "" {
+				q += ` AND created_at < ?`
+				balanceArgs = append(balanceArgs, toCutoff)
+			}
+			if err := dbx.QueryRowContext(r.Context(), q, balanceArgs...).Scan(&out.ClosingBalance); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out.OpeningBalance = out.ClosingBalance
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"testing"
+
+	"stockmate/internal/testutil"
+)
+
+// Demo catalogue items driven by the invariant test.
+const (
+	invPLA   = 1 // MAT-PLA-WHT, received in grams
+	invShade = 2 // PRT-SHADE, built from 85 g of PLA
+	invLamp  = 6 // ASM-LAMP, adjusted directly
+)
+
+type ledgerOp struct {
+	name    string
+	method  string
+	path    string
+	body    any
+	reverse bool
+}
+
+func (op ledgerOp) String() string {
+	b, _ := json.Marshal(op.body)
+	return fmt.Sprintf("%s (%s %s %s)", op.name, op.method, op.path, b)
+}
+
+// randomLedgerOp picks a stock movement. Quantities are whole numbers so the
+// sums compare exactly.
+func randomLedgerOp(rng *rand.Rand, maxTxnID int64) ledgerOp {
+	adjust := fmt.Sprintf("/api/assemblies/%d/adjust", invLamp)
+	switch rng.IntN(6) {
+	case 0:
+		return ledgerOp{name: "in", method: "POST", path: adjust,
+			body: map[string]any{"direction": "IN", "qty": 1 + rng.IntN(5)}}
+	case 1:
+		return ledgerOp{name: "out", method: "POST", path: adjust,
+			body: map[string]any{"direction": "OUT", "qty": 1 + rng.IntN(8), "reason_code": "scrap"}}
+	case 2:
+		return ledgerOp{name: "set", method: "POST", path: adjust,
+			body: map[string]any{"direction": "SET", "qty": rng.IntN(11)}}
+	case 3:
+		return ledgerOp{name: "receive", method: "POST", path: "/api/production/components/complete",
+			body: map[string]any{"rows": []map[string]any{{"item_id": invPLA, "qty": 50 * (1 + rng.IntN(10))}}}}
+	case 4:
+		return ledgerOp{name: "build", method: "POST", path: fmt.Sprintf("/api/production/parts/%d/complete", invShade),
+			body: map[string]any{"qty": 1 + rng.IntN(6)}}
+	}
+	return ledgerOp{name: "reverse", method: "POST", path: fmt.Sprintf("/api/transactions/%d/reverse", 1+rng.Int64N(maxTxnID)),
+		body: map[string]any{"note": "invariant test"}, reverse: true}
+}
+
+// TestLedgerInvariants replays random sequences of movements under the block
+// policy and checks after every step that
+//   - no item's ledger sum is negative,
+//   - the ledger endpoint's balance equals the sum of the rows,
+//   - a rejected request writes nothing,
+//   - a reversed transaction cannot be reversed again.
+func TestLedgerInvariants(t *testing.T) {
+	steps := 200
+	if testing.Short() {
+		steps = 40
+	}
+	for seed := uint64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			conn := testutil.SeededDB(t)
+			h := testRouter(t, conn)
+			if rec := testutil.Do(t, h, "PUT", "/api/settings/negative-stock", map[string]any{"policy": "block"}); rec.Code != http.StatusOK {
+				t.Fatalf("set policy: %d %s", rec.Code, rec.Body)
+			}
+
+			rng := rand.New(rand.NewPCG(seed, seed))
+			for step := 0; step < steps; step++ {
+				before := ledgerRowCount(t, conn)
+				op := randomLedgerOp(rng, maxTransactionID(t, conn))
+				rec := testutil.Do(t, h, op.method, op.path, op.body)
+				switch {
+				case rec.Code < 300:
+				case rec.Code == http.StatusBadRequest || rec.Code == http.StatusConflict:
+					if after := ledgerRowCount(t, conn); after != before {
+						t.Fatalf("step %d %s: rejected with %d but wrote %d rows", step, op, rec.Code, after-before)
+					}
+				default:
+					t.Fatalf("step %d %s: status %d: %s", step, op, rec.Code, rec.Body)
+				}
+
+				if op.reverse && rec.Code == http.StatusCreated {
+					n := ledgerRowCount(t, conn)
+					again := testutil.Do(t, h, op.method, op.path, op.body)
+					if again.Code != http.StatusConflict {
+						t.Fatalf("step %d %s: second reversal got %d, want 409", step, op, again.Code)
+					}
+					if ledgerRowCount(t, conn) != n {
+						t.Fatalf("step %d %s: second reversal wrote rows", step, op)
+					}
+				}
+
+				checkLedgerBalances(t, h, conn, fmt.Sprintf("step %d %s", step, op))
+			}
+		})
+	}
+}
+
+func checkLedgerBalances(t *testing.T, h http.Handler, conn *sql.DB, where string) {
+	t.Helper()
+	sums := ledgerSums(t, conn)
+	for itemID, qty := range sums {
+		if qty < 0 {
+			t.Fatalf("%s: item %d is at %v under the block policy", where, itemID, qty)
+		}
+	}
+	for _, itemID := range []int64{invPLA, invShade, invLamp} {
+		rec := testutil.Do(t, h, "GET", fmt.Sprintf("/api/items/%d/ledger", itemID), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: ledger of item %d: %d %s", where, itemID, rec.Code, rec.Body)
+		}
+		var ledger struct {
+			ClosingBalance float64 `json:"closing_balance"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &ledger); err != nil {
+			t.Fatal(err)
+		}
+		if ledger.ClosingBalance != sums[itemID] {
+			t.Fatalf("%s: item %d balance %v, ledger sum %v", where, itemID, ledger.ClosingBalance, sums[itemID])
+		}
+	}
+}
+
+func ledgerSums(t *testing.T, conn *sql.DB) map[int64]float64 {
+	t.Helper()
+	rows, err := conn.Query(`
+SELECT item_id, SUM(CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END)
+FROM stock_transactions
+GROUP BY item_id
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	sums := map[int64]float64{}
+	for rows.Next() {
+		var id int64
+		var qty float64
+		if err := rows.Scan(&id, &qty); err != nil {
+			t.Fatal(err)
+		}
+		sums[id] = qty
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return sums
+}
+
+func ledgerRowCount(t *testing.T, conn *sql.DB) int64 {
+	t.Helper()
+	var n int64
+	if err := conn.QueryRow(`SELECT COUNT(1) FROM stock_transactions`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func maxTransactionID(t *testing.T, conn *sql.DB) int64 {
+	t.Helper()
+	var id int64
+	if err := conn.QueryRow(`SELECT COALESCE(MAX(transaction_id), 1) FROM stock_transactions`).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
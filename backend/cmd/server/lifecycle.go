@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+)
+
+// An item's lifecycle status says whether it may still be designed in and
+// bought. End-of-life (eol) items still work everywhere but should be
+// replaced; obsolete items keep their history and stock movements but can't
+// be put into new BOM revisions or supplier offers.
+const (
+	lifecycleActive   = "active"
+	lifecycleEOL      = "eol"
+	lifecycleObsolete = "obsolete"
+)
+
+// lifecycleTransitions lists the statuses each status may change to. An
+// obsolete item can only come back through eol.
+var lifecycleTransitions = map[string][]string{
+	lifecycleActive:   {lifecycleEOL, lifecycleObsolete},
+	lifecycleEOL:      {lifecycleActive, lifecycleObsolete},
+	lifecycleObsolete: {lifecycleEOL},
+}
+
+func canChangeLifecycle(from, to string) bool {
+	for _, s := range lifecycleTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// lifecycleFilter reads ?lifecycle=active,eol into a condition on i. An
+// empty parameter matches every status.
+func lifecycleFilter(query url.Values) (string, []any, error) {
+	v := strings.TrimSpace(query.Get("lifecycle"))
+	if v == "" {
+		return "", nil, nil
+	}
+	args := make([]any, 0)
+	for _, s := range strings.Split(v, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if _, ok := lifecycleTransitions[s]; !ok {
+			return "", nil, fmt.Errorf("invalid lifecycle: %s", s)
+		}
+		args = append(args, s)
+	}
+	return " AND i.lifecycle_status IN (" + strings.TrimSuffix(strings.Repeat("?,", len(args)), ",") + ")", args, nil
+}
+
+// LifecycleUse is a BOM whose latest revision still uses an item.
+type LifecycleUse struct {
+	ParentItemID int64  `json:"parent_item_id"`
+	SKU          string `json:"sku"`
+	RevNo        int64  `json:"rev_no"`
+}
+
+// setItemLifecycle changes an item's lifecycle status. Moving an item to eol
+// or obsolete lists the BOMs that still use it, since they need a new
+// revision to design it out.
+func setItemLifecycle(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Status string `json:"status"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		status := strings.ToLower(strings.TrimSpace(req.Status))
+		if _, ok := lifecycleTransitions[status]; !ok {
+			http.Error(w, "status must be active, eol or obsolete", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var previous string
+		if err := tx.QueryRowContext(r.Context(), `SELECT lifecycle_status FROM items WHERE item_id = ?`, itemID).Scan(&previous); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "item not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if status != previous {
+			if !canChangeLifecycle(previous, status) {
+				http.Error(w, fmt.Sprintf("cannot change lifecycle from %s to %s", previous, status), http.StatusConflict)
+				return
+			}
+			if _, err := tx.ExecContext(r.Context(), `UPDATE items SET lifecycle_status = ? WHERE item_id = ?`, status, itemID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		usedIn := make([]LifecycleUse, 0)
+		if status != lifecycleActive {
+			rows, err := tx.QueryContext(r.Context(), `
+SELECT ar.item_id, i.sku, ar.rev_no
+FROM assembly_records ar
+JOIN items i ON i.item_id = ar.item_id
+WHERE ar.obsolete_at IS NULL
+  AND ar.rev_no = (
+    SELECT MAX(ar2.rev_no) FROM assembly_records ar2
+    WHERE ar2.item_id = ar.item_id AND ar2.obsolete_at IS NULL
+  )
+  AND (
+    EXISTS (SELECT 1 FROM assembly_components ac WHERE ac.record_id = ar.record_id AND ac.component_item_id = ?1)
+    OR EXISTS (SELECT 1 FROM assembly_component_alternates aca WHERE aca.record_id = ar.record_id AND aca.alternate_item_id = ?1)
+  )
+ORDER BY i.sku
+`, itemID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for rows.Next() {
+				var u LifecycleUse
+				if err := rows.Scan(&u.ParentItemID, &u.SKU, &u.RevNo); err != nil {
+					rows.Close()
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				usedIn = append(usedIn, u)
+			}
+			if err := rows.Err(); err != nil {
+				rows.Close()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			rows.Close()
+		}
+
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"item_id":          itemID,
+			"lifecycle_status": status,
+			"previous_status":  previous,
+			"used_in":          usedIn,
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+// LowStockAlert is an item at or below its reorder point.
+type LowStockAlert struct {
+	ItemID       int64   `json:"item_id"`
+	SKU          string  `json:"sku"`
+	Name         string  `json:"name"`
+	ManagedUnit  string  `json:"managed_unit"`
+	StockQty     float64 `json:"stock_qty"`
+	ReorderPoint float64 `json:"reorder_point"`
+	// Severity is out when nothing is left, low when below the reorder
+	// point.
+	Severity string          `json:"severity"`
+	Snooze   *LowStockSnooze `json:"snooze,omitempty"`
+}
+
+type LowStockSnooze struct {
+	// Until is null for an acknowledgement, which holds until the item
+	// gets worse or recovers.
+	Until timeutil.Time `json:"until"`
+	Note  string        `json:"note,omitempty"`
+}
+
+// listLowStockAlerts lists the low-stock alerts, out of stock first.
+// Snoozed items are left out unless ?all=1.
+func listLowStockAlerts(st *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, err := st.ListLowStock(r.Context(), r.URL.Query().Get("all") == "1")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out := make([]LowStockAlert, 0, len(items))
+		for _, it := range items {
+			a := LowStockAlert{
+				ItemID:       it.ItemID,
+				SKU:          it.SKU,
+				Name:         it.Name,
+				ManagedUnit:  it.ManagedUnit,
+				StockQty:     it.StockQty,
+				ReorderPoint: it.ReorderPoint,
+				Severity:     it.Severity,
+			}
+			if it.Snoozed {
+				a.Snooze = &LowStockSnooze{Note: it.SnoozeNote}
+				if it.SnoozedUntil != "" {
+					if err := a.Snooze.Until.Scan(it.SnoozedUntil); err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+				}
+			}
+			out = append(out, a)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// snoozeLowStock hides an item's low-stock alert until a date or, without
+// one, acknowledges it. Either way the alert comes back if the item runs
+// out after being snoozed while merely low.
+func snoozeLowStock(st *store.Store) http.HandlerFunc {
+	dbx := st.DB()
+	type Req struct {
+		// Until is a date or RFC3339 timestamp; empty acknowledges.
+		Until string `json:"until"`
+		Note  string `json:"note"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Note = strings.TrimSpace(req.Note)
+		var until any
+		if v := strings.TrimSpace(req.Until); v != "" {
+			t, err := timeutil.Parse(v)
+			if err != nil {
+				http.Error(w, "until must be a date or RFC3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			if !t.After(time.Now()) {
+				http.Error(w, "until must be in the future", http.StatusBadRequest)
+				return
+			}
+			until = timeutil.Format(t)
+		}
+
+		var exists int
+		if err := dbx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM items WHERE item_id = ?`, itemID).Scan(&exists); err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if exists == 0 {
+			http.Error(w, "item not found", http.StatusNotFound)
+			return
+		}
+		severity, low, err := st.LowStockSeverity(r.Context(), itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !low {
+			http.Error(w, "item is not low on stock", http.StatusConflict)
+			return
+		}
+
+		var note any
+		if req.Note != "" {
+			note = req.Note
+		}
+		if _, err := store.Exec(r.Context(), dbx, `
+INSERT INTO low_stock_snoozes(item_id, severity, snoozed_until, note, actor)
+VALUES(?,?,?,?,?)
+ON CONFLICT(item_id) DO UPDATE SET
+  severity = excluded.severity,
+  snoozed_until = excluded.snoozed_until,
+  note = excluded.note,
+  actor = excluded.actor,
+  created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+`, itemID, severity, until, note, actorArg(r.Context())); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// unsnoozeLowStock brings an item's low-stock alert back.
+func unsnoozeLowStock(st *store.Store) http.HandlerFunc {
+	dbx := st.DB()
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		res, err := store.Exec(r.Context(), dbx, `DELETE FROM low_stock_snoozes WHERE item_id = ?`, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "item is not snoozed", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func lowStockSnoozePruneJob(st *store.Store) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := st.PruneLowStockSnoozes(ctx)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("low-stock snoozes: %d lapsed", n)
+		}
+		return nil
+	}
+}
@@ -1,48 +1,84 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
+	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
-	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"stockmate/internal/config"
 	"stockmate/internal/db"
+	"stockmate/internal/jobs"
+	"stockmate/internal/middleware"
+	"stockmate/internal/notify"
+	"stockmate/internal/oidc"
+	"stockmate/internal/querybuilder"
+	"stockmate/internal/shopsync"
+	"stockmate/internal/storage"
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+	"stockmate/internal/validate"
+	"stockmate/web"
 )
 
 type Item struct {
-	ID           int64            `json:"id"`
-	SeriesID     *int64           `json:"series_id,omitempty"`
-	SKU          string           `json:"sku"`
-	Name         string           `json:"name"`
-	ItemType     string           `json:"item_type"`
-	PackQty      *float64         `json:"pack_qty,omitempty"`
-	ReorderPoint *float64         `json:"reorder_point,omitempty"`
-	ManagedUnit  string           `json:"managed_unit"`
-	StockManaged bool             `json:"stock_managed"`
-	IsSellable   bool             `json:"is_sellable"`
-	IsFinal      bool             `json:"is_final"`
-	Note         string           `json:"note,omitempty"`
-	CreatedAt    string           `json:"created_at,omitempty"`
-	UpdatedAt    string           `json:"updated_at,omitempty"`
-	Assembly     *AssemblyDetail  `json:"assembly,omitempty"`
-	Component    *ComponentDetail `json:"component,omitempty"`
+	ID           int64    `json:"id"`
+	SeriesID     *int64   `json:"series_id,omitempty"`
+	SeriesName   string   `json:"series_name,omitempty"`
+	SKU          string   `json:"sku"`
+	Name         string   `json:"name"`
+	ItemType     string   `json:"item_type"`
+	PackQty      *float64 `json:"pack_qty,omitempty"`
+	ReorderPoint *float64 `json:"reorder_point,omitempty"`
+	// MOQ and OrderMultiple constrain purchases from any supplier.
+	MOQ           *float64 `json:"moq,omitempty"`
+	OrderMultiple *float64 `json:"order_multiple,omitempty"`
+	// LeadTimeDays is used when an offer and its supplier have none.
+	LeadTimeDays     *int64   `json:"lead_time_days,omitempty"`
+	UnitCost         *float64 `json:"unit_cost,omitempty"`
+	UnitCostCurrency string   `json:"unit_cost_currency,omitempty"`
+	ManagedUnit      string   `json:"managed_unit"`
+	ItemMeasures
+	StockManaged    bool             `json:"stock_managed"`
+	IsSellable      bool             `json:"is_sellable"`
+	IsFinal         bool             `json:"is_final"`
+	LifecycleStatus string           `json:"lifecycle_status"`
+	Note            string           `json:"note,omitempty"`
+	CreatedAt       *timeutil.Time   `json:"created_at,omitempty"`
+	UpdatedAt       *timeutil.Time   `json:"updated_at,omitempty"`
+	Assembly        *AssemblyDetail  `json:"assembly,omitempty"`
+	Component       *ComponentDetail `json:"component,omitempty"`
+	CustomFields    map[string]any   `json:"custom_fields,omitempty"`
 }
 
 type AssemblyDetail struct {
+	SupplierID   *int64   `json:"supplier_id,omitempty"`
 	Manufacturer string   `json:"manufacturer,omitempty"`
 	TotalWeight  *float64 `json:"total_weight,omitempty"`
-	PackSize     string   `json:"pack_size,omitempty"`
-	Note         string   `json:"note,omitempty"`
+	// TotalWeightAuto keeps TotalWeight at the weight of the BOM.
+	TotalWeightAuto bool   `json:"total_weight_auto,omitempty"`
+	PackSize        string `json:"pack_size,omitempty"`
+	Note            string `json:"note,omitempty"`
+	// Phantom assemblies are never stocked; BOM explosion goes straight
+	// through them to their components.
+	Phantom bool `json:"phantom,omitempty"`
 }
 
 type ComponentDetail struct {
+	SupplierID    *int64                  `json:"supplier_id,omitempty"`
 	Manufacturer  string                  `json:"manufacturer,omitempty"`
 	ComponentType string                  `json:"component_type,omitempty"`
 	Color         string                  `json:"color,omitempty"`
@@ -50,12 +86,12 @@ type ComponentDetail struct {
 }
 
 type ComponentPurchaseLink struct {
-	ID        int64  `json:"id,omitempty"`
-	URL       string `json:"url"`
-	Label     string `json:"label,omitempty"`
-	SortOrder int    `json:"sort_order,omitempty"`
-	CreatedAt string `json:"created_at,omitempty"`
-	Enabled   bool   `json:"enabled"`
+	ID        int64          `json:"id,omitempty"`
+	URL       string         `json:"url"`
+	Label     string         `json:"label,omitempty"`
+	SortOrder int            `json:"sort_order,omitempty"`
+	CreatedAt *timeutil.Time `json:"created_at,omitempty"`
+	Enabled   bool           `json:"enabled"`
 }
 
 type AssemblyComponent struct {
@@ -65,42 +101,77 @@ type AssemblyComponent struct {
 	ItemType        string  `json:"item_type"`
 	ManagedUnit     string  `json:"managed_unit"`
 	QtyPerUnit      float64 `json:"qty_per_unit"`
-	Note            string  `json:"note,omitempty"`
+	ScrapFactor     float64 `json:"scrap_factor,omitempty"`
+	// Refs holds reference designators ("R1,R2,R7"). Nil on write keeps the
+	// previous revision's.
+	Refs *string `json:"refs,omitempty"`
+	// Position orders the lines; 0 on write means list order.
+	Position int64  `json:"position"`
+	Note     string `json:"note,omitempty"`
+	// Alternates nil on write keeps the previous revision's for the line.
+	Alternates []BOMAlternate `json:"alternates,omitempty"`
 }
 
 type AssemblyRevision struct {
-	RecordID       int64  `json:"record_id"`
-	RevNo          int64  `json:"rev_no"`
-	CreatedAt      string `json:"created_at"`
-	ComponentCount int64  `json:"component_count"`
+	RecordID       int64         `json:"record_id"`
+	RevNo          int64         `json:"rev_no"`
+	CreatedAt      timeutil.Time `json:"created_at"`
+	ComponentCount int64         `json:"component_count"`
+	// EffectiveFrom is set when the revision was saved to take effect later.
+	EffectiveFrom *timeutil.Time `json:"effective_from,omitempty"`
+	// ObsoleteAt is set once the revision has been deleted. Obsolete
+	// revisions keep their rev_no and can still be viewed by it.
+	ObsoleteAt *timeutil.Time `json:"obsolete_at,omitempty"`
+	// ECOID is the change order that created the revision, if any.
+	ECOID *int64 `json:"eco_id,omitempty"`
+	// ChangeNote says why the revision was made, ChangedBy who made it.
+	ChangeNote string `json:"change_note,omitempty"`
+	ChangedBy  string `json:"changed_by,omitempty"`
 }
 
 type AssemblyComponentSet struct {
-	ParentItemID     int64               `json:"parent_item_id"`
-	CurrentRecordID  *int64              `json:"current_record_id,omitempty"`
-	CurrentRevNo     *int64              `json:"current_rev_no,omitempty"`
-	CurrentCreatedAt string              `json:"current_created_at,omitempty"`
-	Revisions        []AssemblyRevision  `json:"revisions"`
-	Components       []AssemblyComponent `json:"components"`
+	ParentItemID     int64          `json:"parent_item_id"`
+	CurrentRecordID  *int64         `json:"current_record_id,omitempty"`
+	CurrentRevNo     *int64         `json:"current_rev_no,omitempty"`
+	CurrentCreatedAt *timeutil.Time `json:"current_created_at,omitempty"`
+	CurrentYield     *float64       `json:"current_yield,omitempty"`
+	// EffectiveRevNo is the revision in effect now, or at ?as_of=.
+	EffectiveRevNo *int64              `json:"effective_rev_no,omitempty"`
+	Revisions      []AssemblyRevision  `json:"revisions"`
+	Components     []AssemblyComponent `json:"components"`
 }
 
-type AssemblyStock struct {
-	ItemID    int64   `json:"item_id"`
-	SKU       string  `json:"sku"`
-	Name      string  `json:"name"`
-	StockQty  float64 `json:"stock_qty"`
-	UpdatedAt string  `json:"updated_at,omitempty"`
+// ItemStock is a row of the assembly and component stock lists.
+type ItemStock struct {
+	ItemID       int64  `json:"item_id"`
+	SKU          string `json:"sku"`
+	Name         string `json:"name"`
+	StockManaged bool   `json:"stock_managed"`
+	// LifecycleStatus is active, eol or obsolete.
+	LifecycleStatus string   `json:"lifecycle_status"`
+	ReorderPoint    *float64 `json:"reorder_point,omitempty"`
+	// BelowReorder is true for managed items at or below their reorder
+	// point. Obsolete items are never reordered, so never below it.
+	BelowReorder bool           `json:"below_reorder"`
+	PackQty      *float64       `json:"pack_qty,omitempty"`
+	StockQty     float64        `json:"stock_qty"`
+	StockPacks   *float64       `json:"stock_packs,omitempty"`
+	UpdatedAt    *timeutil.Time `json:"updated_at,omitempty"`
+	// Buildable is set with ?include=buildable: how many more units the
+	// components in stock cover, and the SKU that runs out first.
+	Buildable      *float64 `json:"buildable,omitempty"`
+	BuildableLimit string   `json:"buildable_limit,omitempty"`
 }
 
 type ProductionPart struct {
-	ItemID       int64   `json:"item_id"`
-	SKU          string  `json:"sku"`
-	Name         string  `json:"name"`
-	ItemType     string  `json:"item_type"`
-	ManagedUnit  string  `json:"managed_unit"`
-	CurrentRevNo int64   `json:"current_rev_no"`
-	StockQty     float64 `json:"stock_qty"`
-	UpdatedAt    string  `json:"updated_at,omitempty"`
+	ItemID       int64          `json:"item_id"`
+	SKU          string         `json:"sku"`
+	Name         string         `json:"name"`
+	ItemType     string         `json:"item_type"`
+	ManagedUnit  string         `json:"managed_unit"`
+	CurrentRevNo int64          `json:"current_rev_no"`
+	StockQty     float64        `json:"stock_qty"`
+	UpdatedAt    *timeutil.Time `json:"updated_at,omitempty"`
 }
 
 type ProductionConsumption struct {
@@ -114,46 +185,57 @@ type ProductionConsumption struct {
 }
 
 type ProductionComponent struct {
-	ItemID        int64    `json:"item_id"`
-	SKU           string   `json:"sku"`
-	Name          string   `json:"name"`
-	ManagedUnit   string   `json:"managed_unit"`
-	ComponentType string   `json:"component_type"`
-	PackQty       *float64 `json:"pack_qty,omitempty"`
-	StockQty      float64  `json:"stock_qty"`
-	UpdatedAt     string   `json:"updated_at,omitempty"`
+	ItemID        int64          `json:"item_id"`
+	SKU           string         `json:"sku"`
+	Name          string         `json:"name"`
+	ManagedUnit   string         `json:"managed_unit"`
+	ComponentType string         `json:"component_type"`
+	PackQty       *float64       `json:"pack_qty,omitempty"`
+	StockQty      float64        `json:"stock_qty"`
+	StockPacks    *float64       `json:"stock_packs,omitempty"`
+	UpdatedAt     *timeutil.Time `json:"updated_at,omitempty"`
 }
 
 type ShippingAssembly struct {
-	ItemID       int64   `json:"item_id"`
-	SKU          string  `json:"sku"`
-	Name         string  `json:"name"`
-	ManagedUnit  string  `json:"managed_unit"`
-	CurrentRevNo int64   `json:"current_rev_no"`
-	StockQty     float64 `json:"stock_qty"`
-	UpdatedAt    string  `json:"updated_at,omitempty"`
+	ItemID       int64          `json:"item_id"`
+	SKU          string         `json:"sku"`
+	Name         string         `json:"name"`
+	ManagedUnit  string         `json:"managed_unit"`
+	CurrentRevNo int64          `json:"current_rev_no"`
+	StockQty     float64        `json:"stock_qty"`
+	UpdatedAt    *timeutil.Time `json:"updated_at,omitempty"`
 }
 
 type StockSummaryRow struct {
-	ItemID        int64   `json:"item_id"`
-	SKU           string  `json:"sku"`
-	Name          string  `json:"name"`
-	ItemType      string  `json:"item_type"`
-	ComponentType string  `json:"component_type,omitempty"`
-	PurchaseURL   string  `json:"purchase_url,omitempty"`
-	ManagedUnit   string  `json:"managed_unit"`
-	StockManaged  bool    `json:"stock_managed"`
-	StockQty      float64 `json:"stock_qty"`
-	UpdatedAt     string  `json:"updated_at,omitempty"`
+	ItemID        int64  `json:"item_id"`
+	SKU           string `json:"sku"`
+	Name          string `json:"name"`
+	ItemType      string `json:"item_type"`
+	ComponentType string `json:"component_type,omitempty"`
+	PurchaseURL   string `json:"purchase_url,omitempty"`
+	ManagedUnit   string `json:"managed_unit"`
+	StockManaged  bool   `json:"stock_managed"`
+	// LifecycleStatus is active, eol or obsolete.
+	LifecycleStatus string         `json:"lifecycle_status"`
+	ReorderPoint    *float64       `json:"reorder_point,omitempty"`
+	PackQty         *float64       `json:"pack_qty,omitempty"`
+	StockQty        float64        `json:"stock_qty"`
+	StockPacks      *float64       `json:"stock_packs,omitempty"`
+	UpdatedAt       *timeutil.Time `json:"updated_at,omitempty"`
 }
 
 func main() {
-	dsn := os.Getenv("DB_DSN")
-	if dsn == "" {
-		dsn = "sqlite:./data/stockmate.db"
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
 	}
+	dsn := cfg.DSN
 
-	conn, err := db.Open(dsn)
+	conn, err := db.OpenWith(dsn, db.Options{
+		MaxOpenConns: cfg.DBMaxOpenConns,
+		BusyTimeout:  cfg.DBBusyTimeout,
+		Synchronous:  cfg.DBSynchronous,
+	})
 	if err != nil {
 		panic(err)
 	}
@@ -162,66 +244,134 @@ func main() {
 	if err := db.Migrate(conn); err != nil {
 		panic(err)
 	}
+	if cfg.DemoSeed {
+		if seeded, err := db.SeedDemo(context.Background(), conn); err != nil {
+			panic(err)
+		} else if seeded {
+			log.Println("demo data seeded")
+		}
+	}
 
-	r := chi.NewRouter()
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
+	attachments, err := storage.FromEnv()
+	if err != nil {
+		panic(err)
+	}
+	reports, err := loadReportStyle(cfg.ReportHeader, cfg.ReportLogoFile)
+	if err != nil {
+		panic(err)
+	}
+
+	cookiePolicy = middleware.CookiePolicy{Secure: cfg.SecureCookies}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	readOnly, err := loadReadOnlyMode(ctx, conn, cfg.ReadOnly)
+	if err != nil {
+		panic(err)
+	}
+
+	st := store.New(conn)
+	defer st.Close()
+	if err := st.Prepare(ctx); err != nil {
+		panic(err)
+	}
+	runner := jobs.NewRunner()
+	if cfg.SnapshotEnabled {
+		runner.Daily("stock-snapshot", cfg.SnapshotHour, cfg.SnapshotMinute, readOnly.guardJob("stock-snapshot", snapshotToday(st)))
+	}
+	if cfg.MaintenanceEnabled {
+		runner.Daily("db-maintenance", cfg.MaintenanceHour, cfg.MaintenanceMinute, readOnly.guardJob("db-maintenance", maintenanceJob(st)))
+	}
+	mailer := notify.NewMailer(notify.SMTPConfig{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.SMTPFrom,
+		To:       cfg.NotifyEmailTo,
+	})
+	if mailer.Enabled() {
+		runner.OnFailure(alertJobFailure(mailer))
+		if cfg.DigestEnabled {
+			runner.Daily("low-stock-digest", cfg.DigestHour, cfg.DigestMinute, lowStockDigestJob(st, mailer))
+		}
+	}
+	syncer := &shopSyncer{}
+	syncer.adapter, err = shopsync.New(shopsync.Config{
+		Channel:            cfg.ShopSyncChannel,
+		ShopifyShop:        cfg.ShopifyShop,
+		ShopifyAccessToken: cfg.ShopifyAccessToken,
+		ShopifyLocationID:  cfg.ShopifyLocationID,
+		BaseAccessToken:    cfg.BaseAccessToken,
 	})
+	if err != nil && !errors.Is(err, shopsync.ErrNotConfigured) {
+		panic(err)
+	}
+	if syncer.adapter != nil && cfg.ShopSyncInterval > 0 {
+		runner.Every("shop-sync", cfg.ShopSyncInterval, readOnly.guardJob("shop-sync", shopSyncJob(conn, syncer)))
+	}
+	runner.Every("low-stock-snooze-prune", time.Hour, readOnly.guardJob("low-stock-snooze-prune", lowStockSnoozePruneJob(st)))
+	if cfg.ArchiveAfterYears > 0 {
+		runner.Every("transaction-archive", 24*time.Hour, readOnly.guardJob("transaction-archive", archiveJob(st, cfg.ArchiveAfterYears)))
+	}
+	if cfg.TrashRetention > 0 {
+		runner.Every("trash-purge", time.Hour, readOnly.guardJob("trash-purge", trashPurgeJob(conn, cfg.TrashRetention)))
+	}
+	if webhook := notify.NewWebhook(cfg.OutboxWebhookURL, cfg.OutboxWebhookSecret); webhook.Enabled() {
+		runner.Every("outbox-dispatch", cfg.OutboxInterval, readOnly.guardJob("outbox-dispatch", outboxDispatchJob(st, webhook)))
+	}
+	runner.Start(ctx)
+
+	var sso *oidc.Provider
+	if cfg.OIDCEnabled() {
+		sso, err = oidc.New(oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
 
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, "ok")
+	r := newRouter(cfg, deps{
+		conn:        conn,
+		store:       st,
+		attachments: attachments,
+		reports:     reports,
+		readOnly:    readOnly,
+		syncer:      syncer,
+		mailer:      mailer,
+		sso:         sso,
 	})
 
-	if os.Getenv("APP_ENV") == "dev" {
-		r.Get("/debug/dsn", func(w http.ResponseWriter, r *http.Request) {
-			fmt.Fprintln(w, dsn)
-		})
+	if staticFS, source := resolveStaticFS(); staticFS != nil {
+		fmt.Println("serving frontend from:", source)
+		r.NotFound(spaFileServer(staticFS))
 	}
 
-	r.Post("/api/items", createItem(conn))
-	r.Get("/api/items", listItems(conn))
-	r.Get("/api/assemblies", listAssemblies(conn))
-	r.Get("/api/assemblies/{id}/components", getAssemblyComponents(conn))
-	r.Put("/api/assemblies/{id}/components", createAssemblyComponentsRevision(conn))
-	r.Delete("/api/assemblies/{id}/components/{rev}", deleteAssemblyComponentsRevision(conn))
-	r.Get("/api/assemblies/stock", listAssemblyStock(conn))
-	r.Get("/api/stock/summary", listStockSummary(conn))
-	r.Post("/api/assemblies/{id}/adjust", adjustAssemblyStock(conn))
-	r.Get("/api/production/parts", listProductionParts(conn))
-	r.Post("/api/production/parts/{id}/complete", completePartProduction(conn))
-	r.Get("/api/production/components", listProductionComponents(conn))
-	r.Post("/api/production/components/complete", completeProductionComponents(conn))
-	r.Get("/api/production/shipments/assemblies", listShippingAssemblies(conn))
-	r.Post("/api/production/shipments/complete", completeShipments(conn))
-	r.Put("/api/items/{id}", updateItem(conn))
-
-	if staticDir := resolveStaticDir(); staticDir != "" {
-		fmt.Println("serving frontend from:", staticDir)
-		r.NotFound(spaFileServer(staticDir))
-	}
-
-	fmt.Println("listening on :8080")
-	if err := http.ListenAndServe(":8080", r); err != nil {
+	if err := serve(ctx, cfg, r); err != nil {
 		panic(err)
 	}
 }
 
-func resolveStaticDir() string {
+// resolveStaticFS picks the frontend to serve: an explicit STATIC_DIR wins,
+// then the frontend embedded at build time, then a dist directory next to
+// the binary.
+func resolveStaticFS() (fs.FS, string) {
 	if custom := strings.TrimSpace(os.Getenv("STATIC_DIR")); custom != "" {
 		if isDir(custom) {
-			return custom
+			return os.DirFS(custom), custom
 		}
 		fmt.Println("STATIC_DIR not found:", custom)
-		return ""
+		return nil, ""
+	}
+
+	if embedded := web.Dist(); embedded != nil {
+		return embedded, "embedded"
 	}
 
 	candidates := []string{
@@ -232,10 +382,10 @@ func resolveStaticDir() string {
 
 	for _, dir := range candidates {
 		if isDir(dir) {
-			return dir
+			return os.DirFS(dir), dir
 		}
 	}
-	return ""
+	return nil, ""
 }
 
 func isDir(dir string) bool {
@@ -243,9 +393,8 @@ func isDir(dir string) bool {
 	return err == nil && info.IsDir()
 }
 
-func spaFileServer(staticDir string) http.HandlerFunc {
-	fileFS := os.DirFS(staticDir)
-	fileServer := http.FileServer(http.Dir(staticDir))
+func spaFileServer(fileFS fs.FS) http.HandlerFunc {
+	fileServer := http.FileServer(http.FS(fileFS))
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		cleanPath := path.Clean("/" + r.URL.Path)
@@ -255,33 +404,51 @@ func spaFileServer(staticDir string) http.HandlerFunc {
 		}
 
 		if rel != "index.html" {
-			if _, err := fs.Stat(fileFS, rel); err == nil {
+			if info, err := fs.Stat(fileFS, rel); err == nil && !info.IsDir() {
 				fileServer.ServeHTTP(w, r)
 				return
 			}
 		}
 
-		indexPath := filepath.Join(staticDir, "index.html")
-		http.ServeFile(w, r, indexPath)
+		// Unknown paths are client-side routes; let the SPA handle them.
+		http.ServeFileFS(w, r, fileFS, "index.html")
 	}
 }
 
 func listStockSummary(dbx *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		q := strings.TrimSpace(r.URL.Query().Get("q"))
-		managedStr := strings.TrimSpace(r.URL.Query().Get("managed"))
-		limit := 200
-		if limitStr := strings.TrimSpace(r.URL.Query().Get("limit")); limitStr != "" {
-			v, err := strconv.Atoi(limitStr)
-			if err != nil || v <= 0 {
-				http.Error(w, "invalid limit", http.StatusBadRequest)
-				return
-			}
-			if v > 1000 {
-				v = 1000
-			}
-			limit = v
+		fields, err := parseFields[StockSummaryRow](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		orderBy, err := orderByClause(r, stockSortColumns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, lowOnly, err := querybuilder.Bool(r.URL.Query(), "low")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := querybuilder.Limit(r.URL.Query(), 200, 1000)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var where querybuilder.Where
+		where.Search(r.URL.Query(), "q", "i.sku", "i.name")
+		if err := where.Flag(r.URL.Query(), "managed", "i.stock_managed"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lcClause, lcArgs, err := lifecycleFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		where.Append(lcClause, lcArgs)
 
 		sb := strings.Builder{}
 		sb.WriteString(`
@@ -296,11 +463,15 @@ SELECT
     FROM component_purchase_links l
     WHERE l.component_id = c.component_id
       AND l.enabled = 1
+      AND l.deleted_at IS NULL
     ORDER BY l.sort_order ASC, l.id ASC
     LIMIT 1
   ) AS purchase_url,
   i.managed_unit,
   i.stock_managed,
+  i.lifecycle_status,
+  i.reorder_point,
+  i.pack_qty,
   COALESCE(SUM(
     CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END
   ), 0) AS stock_qty,
@@ -310,32 +481,21 @@ LEFT JOIN components c ON c.item_id = i.item_id
 LEFT JOIN stock_transactions st ON st.item_id = i.item_id
 WHERE 1=1
 `)
-		args := make([]any, 0)
-		if q != "" {
-			sb.WriteString(" AND (i.sku LIKE ? OR i.name LIKE ?)")
-			like := "%" + q + "%"
-			args = append(args, like, like)
-		}
-		if managedStr != "" {
-			switch strings.ToLower(managedStr) {
-			case "1", "true", "yes":
-				sb.WriteString(" AND i.stock_managed = 1")
-			case "0", "false", "no":
-				sb.WriteString(" AND i.stock_managed = 0")
-			default:
-				http.Error(w, "invalid managed", http.StatusBadRequest)
-				return
-			}
-		}
+		sb.WriteString(where.SQL())
+		args := where.Args()
 
 		sb.WriteString(`
-GROUP BY i.item_id, i.sku, i.name, i.item_type, c.component_type, i.managed_unit, i.stock_managed
-ORDER BY i.item_id DESC
-LIMIT ?
+GROUP BY i.item_id, i.sku, i.name, i.item_type, c.component_type, i.managed_unit, i.stock_managed, i.lifecycle_status, i.reorder_point, i.pack_qty
 `)
+		if lowOnly {
+			// Low stock: managed items at or below their reorder point,
+			// except obsolete ones, which are not bought again.
+			sb.WriteString(" HAVING i.stock_managed = 1 AND i.lifecycle_status != 'obsolete' AND i.reorder_point IS NOT NULL AND stock_qty <= i.reorder_point")
+		}
+		sb.WriteString("\n" + orderBy + "\nLIMIT ?\n")
 		args = append(args, limit)
 
-		rows, err := dbx.Query(sb.String(), args...)
+		rows, err := dbx.QueryContext(r.Context(), sb.String(), args...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -348,7 +508,7 @@ LIMIT ?
 			var componentType sql.NullString
 			var purchaseURL sql.NullString
 			var stockManagedInt int
-			var updatedAt sql.NullString
+			var reorderPoint, packQty sql.NullFloat64
 			if err := rows.Scan(
 				&row.ItemID,
 				&row.SKU,
@@ -358,21 +518,30 @@ LIMIT ?
 				&purchaseURL,
 				&row.ManagedUnit,
 				&stockManagedInt,
+				&row.LifecycleStatus,
+				&reorderPoint,
+				&packQty,
 				&row.StockQty,
-				&updatedAt,
+				&row.UpdatedAt,
 			); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 			row.StockManaged = stockManagedInt != 0
+			if reorderPoint.Valid {
+				v := reorderPoint.Float64
+				row.ReorderPoint = &v
+			}
 			if componentType.Valid {
 				row.ComponentType = componentType.String
 			}
 			if purchaseURL.Valid {
 				row.PurchaseURL = purchaseURL.String
 			}
-			if updatedAt.Valid {
-				row.UpdatedAt = updatedAt.String
+			if packQty.Valid {
+				v := packQty.Float64
+				row.PackQty = &v
+				row.StockPacks = stockPacks(row.StockQty, row.PackQty)
 			}
 			out = append(out, row)
 		}
@@ -381,8 +550,7 @@ LIMIT ?
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(out)
+		writeList(w, fields, out)
 	}
 }
 
@@ -399,12 +567,18 @@ func parseItemType(value string) (string, error) {
 
 func createItem(dbx *sql.DB) http.HandlerFunc {
 	type AssemblyReq struct {
+		SupplierID   *int64   `json:"supplier_id"`
 		Manufacturer string   `json:"manufacturer"`
 		TotalWeight  *float64 `json:"total_weight"`
-		PackSize     string   `json:"pack_size"`
-		Note         string   `json:"note"`
+		// TotalWeightAuto computes total_weight from the BOM in place
+		// of the given one, once there is a BOM with known weights.
+		TotalWeightAuto bool   `json:"total_weight_auto"`
+		PackSize        string `json:"pack_size"`
+		Note            string `json:"note"`
+		Phantom         bool   `json:"phantom"`
 	}
 	type ComponentReq struct {
+		SupplierID    *int64 `json:"supplier_id"`
 		Manufacturer  string `json:"manufacturer"`
 		ComponentType string `json:"component_type"`
 		Color         string `json:"color"`
@@ -415,20 +589,31 @@ func createItem(dbx *sql.DB) http.HandlerFunc {
 	}
 
 	type Req struct {
-		SeriesID     *int64        `json:"series_id"`
-		SKU          string        `json:"sku"`
-		Name         string        `json:"name"`
-		ItemType     string        `json:"item_type"`
-		ManagedUnit  string        `json:"managed_unit"`
-		BaseUnit     string        `json:"base_unit"`
-		PackQty      *float64      `json:"pack_qty"`
-		ReorderPoint *float64      `json:"reorder_point"`
-		StockManaged *bool         `json:"stock_managed"`
-		IsSellable   bool          `json:"is_sellable"`
-		IsFinal      bool          `json:"is_final"`
-		Note         string        `json:"note"`
-		Assembly     *AssemblyReq  `json:"assembly"`
-		Component    *ComponentReq `json:"component"`
+		SeriesID         *int64        `json:"series_id"`
+		SKU              string        `json:"sku"`
+		Name             string        `json:"name"`
+		ItemType         string        `json:"item_type"`
+		ManagedUnit      string        `json:"managed_unit"`
+		BaseUnit         string        `json:"base_unit"`
+		PackQty          *float64      `json:"pack_qty"`
+		ReorderPoint     *float64      `json:"reorder_point"`
+		MOQ              *float64      `json:"moq"`
+		OrderMultiple    *float64      `json:"order_multiple"`
+		LeadTimeDays     *int64        `json:"lead_time_days"`
+		UnitCost         *float64      `json:"unit_cost"`
+		UnitCostCurrency string        `json:"unit_cost_currency"`
+		StockManaged     *bool         `json:"stock_managed"`
+		IsSellable       bool          `json:"is_sellable"`
+		IsFinal          bool          `json:"is_final"`
+		Note             string        `json:"note"`
+		Assembly         *AssemblyReq  `json:"assembly"`
+		Component        *ComponentReq `json:"component"`
+		// BOMTemplateID gives the item its first BOM revision from a
+		// template, with BOMQty overriding line quantities by component
+		// item id.
+		BOMTemplateID *int64            `json:"bom_template_id"`
+		BOMQty        map[int64]float64 `json:"bom_qty"`
+		ItemMeasures
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -441,17 +626,14 @@ func createItem(dbx *sql.DB) http.HandlerFunc {
 		req.SKU = strings.TrimSpace(req.SKU)
 		req.Name = strings.TrimSpace(req.Name)
 		req.Note = strings.TrimSpace(req.Note)
-		if req.SKU == "" || req.Name == "" {
-			http.Error(w, "sku and name required", http.StatusBadRequest)
-			return
-		}
 
+		var errs validate.Errors
+		errs.Required("sku", req.SKU)
+		errs.Required("name", req.Name)
 		itemType, err := parseItemType(req.ItemType)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			errs.Add("item_type", "must be component or assembly")
 		}
-
 		unit := strings.TrimSpace(req.ManagedUnit)
 		if unit == "" {
 			unit = strings.TrimSpace(req.BaseUnit)
@@ -459,26 +641,30 @@ func createItem(dbx *sql.DB) http.HandlerFunc {
 		if unit == "" {
 			unit = "pcs"
 		}
-		if unit != "g" && unit != "pcs" {
-			http.Error(w, "managed_unit must be g or pcs", http.StatusBadRequest)
-			return
-		}
-		if req.PackQty != nil && *req.PackQty <= 0 {
-			http.Error(w, "pack_qty must be > 0", http.StatusBadRequest)
-			return
-		}
-		if req.ReorderPoint != nil && *req.ReorderPoint < 0 {
-			http.Error(w, "reorder_point must be >= 0", http.StatusBadRequest)
-			return
-		}
-		if req.Assembly != nil && req.Assembly.TotalWeight != nil && *req.Assembly.TotalWeight <= 0 {
-			http.Error(w, "assembly.total_weight must be > 0", http.StatusBadRequest)
-			return
+		errs.OneOf("managed_unit", unit, "g", "pcs")
+		errs.Check(req.PackQty == nil || *req.PackQty > 0, "pack_qty", "must be > 0")
+		errs.Check(req.ReorderPoint == nil || *req.ReorderPoint >= 0, "reorder_point", "must be >= 0")
+		errs.Check(req.MOQ == nil || *req.MOQ > 0, "moq", "must be > 0")
+		errs.Check(req.OrderMultiple == nil || *req.OrderMultiple > 0, "order_multiple", "must be > 0")
+		errs.Check(req.LeadTimeDays == nil || *req.LeadTimeDays >= 0, "lead_time_days", "must be >= 0")
+		errs.Check(req.UnitCost == nil || *req.UnitCost >= 0, "unit_cost", "must be >= 0")
+		req.ItemMeasures.validate(&errs, unit, false)
+		errs.Check(req.Assembly == nil || req.Assembly.TotalWeight == nil || *req.Assembly.TotalWeight > 0, "assembly.total_weight", "must be > 0")
+		componentType := "material"
+		if req.Component != nil && strings.TrimSpace(req.Component.ComponentType) != "" {
+			componentType = strings.TrimSpace(req.Component.ComponentType)
+		}
+		if itemType == "component" {
+			errs.OneOf("component.component_type", componentType, "part", "material", "consumable")
 		}
 		stockManaged := true
 		if req.StockManaged != nil {
 			stockManaged = *req.StockManaged
 		}
+		phantom := itemType == "assembly" && req.Assembly != nil && req.Assembly.Phantom
+		if phantom {
+			stockManaged = false
+		}
 
 		sm := 0
 		if stockManaged {
@@ -513,40 +699,104 @@ func createItem(dbx *sql.DB) http.HandlerFunc {
 		}
 		defer tx.Rollback()
 
-		res, err := tx.Exec(`
-INSERT INTO items(series_id, sku, name, item_type, stock_managed, is_sellable, is_final, pack_qty, reorder_point, managed_unit, note)
-VALUES(?,?,?,?,?,?,?,?,?,?,?)
-`, seriesID, req.SKU, req.Name, itemType, sm, sellable, final, packQty, reorderPoint, unit, req.Note)
+		if !errs.Has("sku") {
+			var taken int
+			if err := tx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM items WHERE sku = ?`, req.SKU).Scan(&taken); err != nil {
+				http.Error(w, "failed to check sku", http.StatusInternalServerError)
+				return
+			}
+			errs.Check(taken == 0, "sku", "is already used")
+		}
+		var costCurrency any = nil
+		if strings.TrimSpace(req.UnitCostCurrency) != "" {
+			code, problem, err := resolveCurrency(r.Context(), tx, req.UnitCostCurrency)
+			if err != nil {
+				http.Error(w, "failed to load currency", http.StatusInternalServerError)
+				return
+			}
+			if problem != "" {
+				errs.Add("unit_cost_currency", problem)
+			}
+			costCurrency = code
+			req.UnitCostCurrency = code
+		}
+		var supplierRef *int64
+		manufacturer := ""
+		if itemType == "assembly" && req.Assembly != nil {
+			supplierRef = req.Assembly.SupplierID
+			manufacturer = strings.TrimSpace(req.Assembly.Manufacturer)
+		}
+		if itemType == "component" && req.Component != nil {
+			supplierRef = req.Component.SupplierID
+			manufacturer = strings.TrimSpace(req.Component.Manufacturer)
+		}
+		supplierID, manufacturer, problem, err := resolveSupplier(r.Context(), tx, supplierRef, manufacturer)
+		if err != nil {
+			http.Error(w, "failed to resolve supplier", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			errs.Add(itemType+".supplier_id", problem)
+		}
+		var bom *BOMRevisionReq
+		if req.BOMTemplateID != nil {
+			rev, err := templateBOMRevision(r.Context(), tx, *req.BOMTemplateID, req.BOMQty, "bom_template_id", &errs)
+			if err != nil {
+				http.Error(w, "failed to load template", http.StatusInternalServerError)
+				return
+			}
+			bom = &rev
+		} else {
+			errs.Check(len(req.BOMQty) == 0, "bom_qty", "requires bom_template_id")
+		}
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+		if bom != nil {
+			if required, err := ecoRequired(r.Context(), tx); err != nil {
+				http.Error(w, "failed to load setting", http.StatusInternalServerError)
+				return
+			} else if required {
+				http.Error(w, ecoRequiredMessage, http.StatusConflict)
+				return
+			}
+		}
+
+		res, err := tx.ExecContext(r.Context(), `
+INSERT INTO items(series_id, sku, name, item_type, stock_managed, is_sellable, is_final, pack_qty, reorder_point, moq, order_multiple, lead_time_days, unit_cost, unit_cost_currency, managed_unit, note)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+`, seriesID, req.SKU, req.Name, itemType, sm, sellable, final, packQty, reorderPoint, req.MOQ, req.OrderMultiple, req.LeadTimeDays, req.UnitCost, costCurrency, unit, req.Note)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		id, _ := res.LastInsertId()
+		if err := saveItemMeasures(r.Context(), tx, id, unit, &req.ItemMeasures); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		switch itemType {
 		case "assembly":
-			manufacturer := ""
 			var totalWeight any = nil
 			packSize := ""
 			assemblyNote := ""
 			if req.Assembly != nil {
-				manufacturer = strings.TrimSpace(req.Assembly.Manufacturer)
 				if req.Assembly.TotalWeight != nil {
 					totalWeight = *req.Assembly.TotalWeight
 				}
 				packSize = strings.TrimSpace(req.Assembly.PackSize)
 				assemblyNote = strings.TrimSpace(req.Assembly.Note)
 			}
-			if _, err := tx.Exec(`
-INSERT INTO assemblies(item_id, manufacturer, total_weight, pack_size, note)
-VALUES(?,?,?,?,?)
-`, id, manufacturer, totalWeight, packSize, assemblyNote); err != nil {
+			if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO assemblies(item_id, supplier_id, manufacturer, total_weight, total_weight_auto, pack_size, note, phantom)
+VALUES(?,?,?,?,?,?,?,?)
+`, id, supplierID, manufacturer, totalWeight, req.Assembly != nil && req.Assembly.TotalWeightAuto, packSize, assemblyNote, phantom); err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 		case "component":
-			manufacturer := ""
-			componentType := "material"
 			color := ""
 			type purchaseLinkInput struct {
 				URL   string
@@ -554,8 +804,6 @@ VALUES(?,?,?,?,?)
 			}
 			purchaseLinks := make([]purchaseLinkInput, 0)
 			if req.Component != nil {
-				manufacturer = strings.TrimSpace(req.Component.Manufacturer)
-				componentType = strings.TrimSpace(req.Component.ComponentType)
 				color = strings.TrimSpace(req.Component.Color)
 				for _, l := range req.Component.PurchaseLinks {
 					u := strings.TrimSpace(l.URL)
@@ -568,27 +816,20 @@ VALUES(?,?,?,?,?)
 					})
 				}
 			}
-			if componentType == "" {
-				componentType = "material"
-			}
-			if componentType != "part" && componentType != "material" && componentType != "consumable" {
-				http.Error(w, "component.component_type must be part, material, or consumable", http.StatusBadRequest)
-				return
-			}
-			if _, err := tx.Exec(`
-INSERT INTO components(item_id, manufacturer, component_type, color)
-VALUES(?,?,?,?)
-`, id, manufacturer, componentType, color); err != nil {
+			if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO components(item_id, supplier_id, manufacturer, component_type, color)
+VALUES(?,?,?,?,?)
+`, id, supplierID, manufacturer, componentType, color); err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 			var componentID int64
-			if err := tx.QueryRow(`SELECT component_id FROM components WHERE item_id = ?`, id).Scan(&componentID); err != nil {
+			if err := tx.QueryRowContext(r.Context(), `SELECT component_id FROM components WHERE item_id = ?`, id).Scan(&componentID); err != nil {
 				http.Error(w, "failed to load component", http.StatusInternalServerError)
 				return
 			}
 			for idx, link := range purchaseLinks {
-				if _, err := tx.Exec(`
+				if _, err := tx.ExecContext(r.Context(), `
 INSERT INTO component_purchase_links(component_id, url, label, sort_order, enabled)
 VALUES(?,?,?,?,1)
 `, componentID, link.URL, link.Label, idx); err != nil {
@@ -598,7 +839,20 @@ VALUES(?,?,?,?,1)
 			}
 		}
 
-		if err := tx.Commit(); err != nil {
+		if bom != nil {
+			if _, _, problem, err := applyBOMRevision(r.Context(), tx, id, bom); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if problem != "" {
+				http.Error(w, problem, http.StatusBadRequest)
+				return
+			}
+		}
+		if err := logItemChange(r.Context(), tx, id, req.SKU, req.Name, "created"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
 			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
 			return
 		}
@@ -609,182 +863,368 @@ VALUES(?,?,?,?,1)
 			respReorderPoint = *req.ReorderPoint
 		}
 		_ = json.NewEncoder(w).Encode(Item{
-			ID:           id,
-			SeriesID:     req.SeriesID,
-			SKU:          req.SKU,
-			Name:         req.Name,
-			ItemType:     itemType,
-			PackQty:      req.PackQty,
-			ReorderPoint: &respReorderPoint,
-			ManagedUnit:  unit,
-			StockManaged: stockManaged,
-			IsSellable:   req.IsSellable,
-			IsFinal:      req.IsFinal,
-			Note:         req.Note,
+			ID:               id,
+			SeriesID:         req.SeriesID,
+			SKU:              req.SKU,
+			Name:             req.Name,
+			ItemType:         itemType,
+			PackQty:          req.PackQty,
+			ReorderPoint:     &respReorderPoint,
+			MOQ:              req.MOQ,
+			OrderMultiple:    req.OrderMultiple,
+			LeadTimeDays:     req.LeadTimeDays,
+			UnitCost:         req.UnitCost,
+			UnitCostCurrency: req.UnitCostCurrency,
+			ManagedUnit:      unit,
+			ItemMeasures:     req.ItemMeasures,
+			StockManaged:     stockManaged,
+			IsSellable:       req.IsSellable,
+			IsFinal:          req.IsFinal,
+			LifecycleStatus:  lifecycleActive,
+			Note:             req.Note,
 		})
 	}
 }
 
+// listItems lists up to 200 items matching the filters of itemListFilter,
+// or with Accept: application/x-ndjson streams every one, one per line.
 func listItems(dbx *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := dbx.Query(`
+		fields, err := parseFields[Item](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		// An export is not cached, and its ETag would have to differ from
+		// the array's.
+		stream := wantsNDJSON(r)
+		if !stream {
+			etag, err := itemListETag(r.Context(), dbx, r, "")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if notModified(w, r, etag) {
+				return
+			}
+		}
+		orderBy, err := orderByClause(r, itemSortColumns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		where, args, err := itemListFilter(r.Context(), dbx, r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if stream {
+			streamItems(w, r, dbx, where, args, orderBy, fields)
+			return
+		}
+		out, err := loadItems(r.Context(), dbx, where, args, orderBy, 200)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeList(w, fields, out)
+	}
+}
+
+// streamItems writes every item matching where as NDJSON. The matching ids
+// are read first, in order; then the items are loaded a page of ids at a
+// time, each page under its own query deadline, so the connection is free
+// while a slow client reads. Items deleted meanwhile are left out.
+func streamItems(w http.ResponseWriter, r *http.Request, dbx *sql.DB, where string, args []any, orderBy string, fields fieldSet) {
+	ids, err := matchingItemIDs(r.Context(), dbx, where, args, orderBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out := newNDJSONStream(w, fields)
+	for chunk := range slices.Chunk(ids, ndjsonFlushEvery) {
+		pageWhere := where + ` AND i.item_id IN (` + strings.TrimSuffix(strings.Repeat("?,", len(chunk)), ",") + `)`
+		pageArgs := slices.Clone(args)
+		for _, id := range chunk {
+			pageArgs = append(pageArgs, id)
+		}
+		ctx, cancel := middleware.QueryContext(r.Context())
+		page, err := loadItemsPage(ctx, dbx, pageWhere, pageArgs, orderBy, len(chunk), 0)
+		cancel()
+		if err != nil {
+			out.Fail(err)
+			return
+		}
+		for _, it := range page {
+			if err := out.Write(it); err != nil {
+				out.Fail(err)
+				return
+			}
+		}
+	}
+	out.Close()
+}
+
+// matchingItemIDs returns the ids of the items matching where, in order.
+func matchingItemIDs(ctx context.Context, dbx *sql.DB, where string, args []any, orderBy string) ([]int64, error) {
+	ctx, cancel := middleware.QueryContext(ctx)
+	defer cancel()
+	rows, err := dbx.QueryContext(ctx, `
+SELECT i.item_id
+FROM items i
+LEFT JOIN series s ON s.series_id = i.series_id
+LEFT JOIN assemblies a ON a.item_id = i.item_id
+LEFT JOIN components c ON c.item_id = i.item_id
+WHERE 1=1`+where+`
+`+orderBy, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// loadItems reads the items matching where (AND-ed conditions on i, s, a
+// and c) with their purchase links and custom fields.
+func loadItems(ctx context.Context, q queryer, where string, args []any, orderBy string, limit int) ([]Item, error) {
+	return loadItemsPage(ctx, q, where, args, orderBy, limit, 0)
+}
+
+// loadItemsPage is loadItems skipping the first offset items.
+func loadItemsPage(ctx context.Context, q queryer, where string, args []any, orderBy string, limit, offset int) ([]Item, error) {
+	rows, err := q.QueryContext(ctx, `
 SELECT
   i.item_id AS id,
   i.series_id,
+  s.name,
   i.sku,
   i.name,
   i.item_type,
   i.pack_qty,
   i.reorder_point,
+  i.moq,
+  i.order_multiple,
+  i.lead_time_days,
+  i.unit_cost,
+  i.unit_cost_currency,
   i.managed_unit,
+  i.weight,
+  i.length,
+  i.width,
+  i.height,
+  i.volume,
   i.stock_managed,
   i.is_sellable,
   i.is_final,
+  i.lifecycle_status,
   i.note,
   i.created_at,
   i.updated_at,
+  a.supplier_id,
   a.manufacturer,
   a.total_weight,
+  a.total_weight_auto,
   a.pack_size,
   a.note,
+  a.phantom,
+  c.supplier_id,
   c.manufacturer,
   c.component_type,
   c.color
 FROM items i
+LEFT JOIN series s ON s.series_id = i.series_id
 LEFT JOIN assemblies a ON a.item_id = i.item_id
 LEFT JOIN components c ON c.item_id = i.item_id
-ORDER BY i.item_id DESC
-LIMIT 200
-`)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		defer rows.Close()
-
-		out := make([]Item, 0)
-		componentItemIDs := make([]int64, 0)
-		componentItemIndex := make(map[int64]int)
-		for rows.Next() {
-			var it Item
-			var seriesID sql.NullInt64
-			var sku sql.NullString
-			var name sql.NullString
-			var itemType sql.NullString
-			var packQty sql.NullFloat64
-			var reorderPoint sql.NullFloat64
-			var managedUnit sql.NullString
-			var note sql.NullString
-			var createdAt sql.NullString
-			var updatedAt sql.NullString
-			var assemblyManufacturer sql.NullString
-			var assemblyTotalWeight sql.NullFloat64
-			var assemblyPackSize sql.NullString
-			var assemblyNote sql.NullString
-			var componentManufacturer sql.NullString
-			var componentType sql.NullString
-			var componentColor sql.NullString
-			var sm int
-			var sellable int
-			var final int
-			if err := rows.Scan(
-				&it.ID,
-				&seriesID,
-				&sku,
-				&name,
-				&itemType,
-				&packQty,
-				&reorderPoint,
-				&managedUnit,
-				&sm,
-				&sellable,
-				&final,
-				&note,
-				&createdAt,
-				&updatedAt,
-				&assemblyManufacturer,
-				&assemblyTotalWeight,
-				&assemblyPackSize,
-				&assemblyNote,
-				&componentManufacturer,
-				&componentType,
-				&componentColor,
-			); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if seriesID.Valid {
-				sid := seriesID.Int64
-				it.SeriesID = &sid
+WHERE 1=1`+where+`
+`+orderBy+`
+LIMIT ? OFFSET ?
+`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Item, 0)
+	componentItemIDs := make([]int64, 0)
+	componentItemIndex := make(map[int64]int)
+	for rows.Next() {
+		var it Item
+		var seriesID sql.NullInt64
+		var seriesName sql.NullString
+		var sku sql.NullString
+		var name sql.NullString
+		var itemType sql.NullString
+		var packQty sql.NullFloat64
+		var reorderPoint sql.NullFloat64
+		var moq, orderMultiple sql.NullFloat64
+		var leadTime sql.NullInt64
+		var unitCost sql.NullFloat64
+		var unitCostCurrency sql.NullString
+		var managedUnit sql.NullString
+		var measures nullMeasures
+		var note sql.NullString
+		var assemblySupplierID sql.NullInt64
+		var assemblyManufacturer sql.NullString
+		var assemblyTotalWeight sql.NullFloat64
+		var assemblyWeightAuto sql.NullInt64
+		var assemblyPackSize sql.NullString
+		var assemblyNote sql.NullString
+		var assemblyPhantom sql.NullInt64
+		var componentSupplierID sql.NullInt64
+		var componentManufacturer sql.NullString
+		var componentType sql.NullString
+		var componentColor sql.NullString
+		var sm int
+		var sellable int
+		var final int
+		if err := rows.Scan(
+			&it.ID,
+			&seriesID,
+			&seriesName,
+			&sku,
+			&name,
+			&itemType,
+			&packQty,
+			&reorderPoint,
+			&moq,
+			&orderMultiple,
+			&leadTime,
+			&unitCost,
+			&unitCostCurrency,
+			&managedUnit,
+			&measures[0],
+			&measures[1],
+			&measures[2],
+			&measures[3],
+			&measures[4],
+			&sm,
+			&sellable,
+			&final,
+			&it.LifecycleStatus,
+			&note,
+			&it.CreatedAt,
+			&it.UpdatedAt,
+			&assemblySupplierID,
+			&assemblyManufacturer,
+			&assemblyTotalWeight,
+			&assemblyWeightAuto,
+			&assemblyPackSize,
+			&assemblyNote,
+			&assemblyPhantom,
+			&componentSupplierID,
+			&componentManufacturer,
+			&componentType,
+			&componentColor,
+		); err != nil {
+			return nil, err
+		}
+		if seriesID.Valid {
+			sid := seriesID.Int64
+			it.SeriesID = &sid
+		}
+		if seriesName.Valid {
+			it.SeriesName = seriesName.String
+		}
+		if sku.Valid {
+			it.SKU = sku.String
+		}
+		if name.Valid {
+			it.Name = name.String
+		}
+		if itemType.Valid {
+			it.ItemType = itemType.String
+		}
+		if packQty.Valid {
+			pq := packQty.Float64
+			it.PackQty = &pq
+		}
+		rp := 0.0
+		if reorderPoint.Valid {
+			rp = reorderPoint.Float64
+		}
+		it.ReorderPoint = &rp
+		if moq.Valid {
+			v := moq.Float64
+			it.MOQ = &v
+		}
+		if orderMultiple.Valid {
+			v := orderMultiple.Float64
+			it.OrderMultiple = &v
+		}
+		if leadTime.Valid {
+			v := leadTime.Int64
+			it.LeadTimeDays = &v
+		}
+		if unitCost.Valid {
+			uc := unitCost.Float64
+			it.UnitCost = &uc
+		}
+		it.UnitCostCurrency = unitCostCurrency.String
+		if managedUnit.Valid {
+			it.ManagedUnit = managedUnit.String
+		}
+		it.ItemMeasures = measures.measures()
+		if note.Valid {
+			it.Note = note.String
+		}
+		if assemblyManufacturer.Valid || assemblyTotalWeight.Valid || assemblyPackSize.Valid || assemblyNote.Valid {
+			it.Assembly = &AssemblyDetail{
+				Manufacturer:    assemblyManufacturer.String,
+				TotalWeightAuto: assemblyWeightAuto.Int64 != 0,
+				PackSize:        assemblyPackSize.String,
+				Note:            assemblyNote.String,
+				Phantom:         assemblyPhantom.Int64 != 0,
 			}
-			if sku.Valid {
-				it.SKU = sku.String
+			if assemblyTotalWeight.Valid {
+				tw := assemblyTotalWeight.Float64
+				it.Assembly.TotalWeight = &tw
 			}
-			if name.Valid {
-				it.Name = name.String
+			if assemblySupplierID.Valid {
+				sid := assemblySupplierID.Int64
+				it.Assembly.SupplierID = &sid
 			}
-			if itemType.Valid {
-				it.ItemType = itemType.String
+		}
+		if componentManufacturer.Valid || componentType.Valid || componentColor.Valid {
+			it.Component = &ComponentDetail{
+				Manufacturer:  componentManufacturer.String,
+				ComponentType: componentType.String,
+				Color:         componentColor.String,
 			}
-			if packQty.Valid {
-				pq := packQty.Float64
-				it.PackQty = &pq
+			if componentSupplierID.Valid {
+				sid := componentSupplierID.Int64
+				it.Component.SupplierID = &sid
 			}
-			rp := 0.0
-			if reorderPoint.Valid {
-				rp = reorderPoint.Float64
-			}
-			it.ReorderPoint = &rp
-			if managedUnit.Valid {
-				it.ManagedUnit = managedUnit.String
-			}
-			if note.Valid {
-				it.Note = note.String
-			}
-			if createdAt.Valid {
-				it.CreatedAt = createdAt.String
-			}
-			if updatedAt.Valid {
-				it.UpdatedAt = updatedAt.String
-			}
-			if assemblyManufacturer.Valid || assemblyTotalWeight.Valid || assemblyPackSize.Valid || assemblyNote.Valid {
-				it.Assembly = &AssemblyDetail{
-					Manufacturer: assemblyManufacturer.String,
-					PackSize:     assemblyPackSize.String,
-					Note:         assemblyNote.String,
-				}
-				if assemblyTotalWeight.Valid {
-					tw := assemblyTotalWeight.Float64
-					it.Assembly.TotalWeight = &tw
-				}
-			}
-			if componentManufacturer.Valid || componentType.Valid || componentColor.Valid {
-				it.Component = &ComponentDetail{
-					Manufacturer:  componentManufacturer.String,
-					ComponentType: componentType.String,
-					Color:         componentColor.String,
-				}
-				componentItemIndex[it.ID] = len(out)
-				componentItemIDs = append(componentItemIDs, it.ID)
-			}
-			it.StockManaged = (sm != 0)
-			it.IsSellable = (sellable != 0)
-			it.IsFinal = (final != 0)
-			out = append(out, it)
-		}
-		if err := rows.Err(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			componentItemIndex[it.ID] = len(out)
+			componentItemIDs = append(componentItemIDs, it.ID)
 		}
+		it.StockManaged = (sm != 0)
+		it.IsSellable = (sellable != 0)
+		it.IsFinal = (final != 0)
+		out = append(out, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		if len(componentItemIDs) > 0 {
-			args := make([]any, 0, len(componentItemIDs))
-			placeholders := make([]string, 0, len(componentItemIDs))
-			for _, itemID := range componentItemIDs {
-				args = append(args, itemID)
-				placeholders = append(placeholders, "?")
-			}
-			linkRows, err := dbx.Query(fmt.Sprintf(`
+	if len(componentItemIDs) > 0 {
+		linkArgs := make([]any, 0, len(componentItemIDs))
+		placeholders := make([]string, 0, len(componentItemIDs))
+		for _, itemID := range componentItemIDs {
+			linkArgs = append(linkArgs, itemID)
+			placeholders = append(placeholders, "?")
+		}
+		linkRows, err := q.QueryContext(ctx, fmt.Sprintf(`
 SELECT
   c.item_id,
   l.id,
@@ -795,168 +1235,128 @@ SELECT
   l.enabled
 FROM components c
 JOIN component_purchase_links l ON l.component_id = c.component_id
-WHERE c.item_id IN (%s)
+WHERE c.item_id IN (%s) AND l.deleted_at IS NULL
 ORDER BY c.item_id, l.sort_order ASC, l.id ASC
-`, strings.Join(placeholders, ",")), args...)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+`, strings.Join(placeholders, ",")), linkArgs...)
+		if err != nil {
+			return nil, err
+		}
+		defer linkRows.Close()
+
+		for linkRows.Next() {
+			var itemID int64
+			var link ComponentPurchaseLink
+			var label sql.NullString
+			var enabledInt int
+			if err := linkRows.Scan(
+				&itemID,
+				&link.ID,
+				&link.URL,
+				&label,
+				&link.SortOrder,
+				&link.CreatedAt,
+				&enabledInt,
+			); err != nil {
+				return nil, err
 			}
-			defer linkRows.Close()
-
-			for linkRows.Next() {
-				var itemID int64
-				var link ComponentPurchaseLink
-				var label sql.NullString
-				var createdAt sql.NullString
-				var enabledInt int
-				if err := linkRows.Scan(
-					&itemID,
-					&link.ID,
-					&link.URL,
-					&label,
-					&link.SortOrder,
-					&createdAt,
-					&enabledInt,
-				); err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				link.Enabled = enabledInt != 0
-				if label.Valid {
-					link.Label = label.String
-				}
-				if createdAt.Valid {
-					link.CreatedAt = createdAt.String
-				}
-				idx, ok := componentItemIndex[itemID]
-				if !ok {
-					continue
-				}
-				if out[idx].Component == nil {
-					out[idx].Component = &ComponentDetail{}
-				}
-				out[idx].Component.PurchaseLinks = append(out[idx].Component.PurchaseLinks, link)
+			link.Enabled = enabledInt != 0
+			if label.Valid {
+				link.Label = label.String
 			}
-			if err := linkRows.Err(); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+			idx, ok := componentItemIndex[itemID]
+			if !ok {
+				continue
+			}
+			if out[idx].Component == nil {
+				out[idx].Component = &ComponentDetail{}
 			}
+			out[idx].Component.PurchaseLinks = append(out[idx].Component.PurchaseLinks, link)
 		}
+		if err := linkRows.Err(); err != nil {
+			return nil, err
+		}
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(out)
+	if err := attachCustomFields(ctx, q, out); err != nil {
+		return nil, err
 	}
+	return out, nil
 }
 
 func listAssemblies(dbx *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		q := strings.TrimSpace(r.URL.Query().Get("q"))
-		manufacturer := strings.TrimSpace(r.URL.Query().Get("manufacturer"))
-		finalStr := strings.TrimSpace(r.URL.Query().Get("final"))
-		sellableStr := strings.TrimSpace(r.URL.Query().Get("sellable"))
-		managedStr := strings.TrimSpace(r.URL.Query().Get("managed"))
-
-		limit := 50
-		if limitStr := strings.TrimSpace(r.URL.Query().Get("limit")); limitStr != "" {
-			v, err := strconv.Atoi(limitStr)
-			if err != nil || v <= 0 {
-				http.Error(w, "invalid limit", http.StatusBadRequest)
-				return
-			}
-			if v > 200 {
-				v = 200
-			}
-			limit = v
+		fields, err := parseFields[Item](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		etag, err := itemListETag(r.Context(), dbx, r, "assembly")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if notModified(w, r, etag) {
+			return
+		}
+		orderBy, err := orderByClause(r, itemSortColumns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := querybuilder.Limit(r.URL.Query(), 50, 200)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var where querybuilder.Where
+		where.Search(r.URL.Query(), "q", "i.sku", "i.name")
+		where.Search(r.URL.Query(), "manufacturer", "a.manufacturer")
+		if err := itemFlagFilter(&where, r.URL.Query()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := commonItemFilter(r.Context(), dbx, &where, r.URL.Query()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		sb := strings.Builder{}
-		sb.WriteString(`
+		query := `
 SELECT
   i.item_id AS id,
   i.series_id,
+  s.name,
   i.sku,
   i.name,
   i.item_type,
   i.pack_qty,
   i.reorder_point,
+  i.unit_cost,
+  i.unit_cost_currency,
   i.managed_unit,
+  i.weight,
+  i.length,
+  i.width,
+  i.height,
+  i.volume,
   i.stock_managed,
   i.is_sellable,
   i.is_final,
+  i.lifecycle_status,
   i.note,
   i.created_at,
   i.updated_at,
+  a.supplier_id,
   a.manufacturer,
   a.total_weight,
+  a.total_weight_auto,
   a.pack_size,
-  a.note
+  a.note,
+  a.phantom
 FROM items i
+LEFT JOIN series s ON s.series_id = i.series_id
 JOIN assemblies a ON a.item_id = i.item_id
-WHERE i.item_type = 'assembly'
-`)
-		args := make([]any, 0)
-		if q != "" {
-			sb.WriteString(" AND (i.sku LIKE ? OR i.name LIKE ?)")
-			like := "%" + q + "%"
-			args = append(args, like, like)
-		}
-		if manufacturer != "" {
-			sb.WriteString(" AND a.manufacturer LIKE ?")
-			args = append(args, "%"+manufacturer+"%")
-		}
-
-		parseBool := func(name string, value string) (valid bool, b bool, err error) {
-			if value == "" {
-				return false, false, nil
-			}
-			switch strings.ToLower(value) {
-			case "1", "true", "yes":
-				return true, true, nil
-			case "0", "false", "no":
-				return true, false, nil
-			default:
-				return false, false, fmt.Errorf("invalid %s", name)
-			}
-		}
-		if valid, b, err := parseBool("final", finalStr); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		} else if valid {
-			sb.WriteString(" AND i.is_final = ?")
-			if b {
-				args = append(args, 1)
-			} else {
-				args = append(args, 0)
-			}
-		}
-		if valid, b, err := parseBool("sellable", sellableStr); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		} else if valid {
-			sb.WriteString(" AND i.is_sellable = ?")
-			if b {
-				args = append(args, 1)
-			} else {
-				args = append(args, 0)
-			}
-		}
-		if valid, b, err := parseBool("managed", managedStr); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		} else if valid {
-			sb.WriteString(" AND i.stock_managed = ?")
-			if b {
-				args = append(args, 1)
-			} else {
-				args = append(args, 0)
-			}
-		}
-
-		sb.WriteString(" ORDER BY i.item_id DESC LIMIT ?")
-		args = append(args, limit)
-
-		rows, err := dbx.Query(sb.String(), args...)
+WHERE i.item_type = 'assembly'`
+		rows, err := dbx.QueryContext(r.Context(), query+where.SQL()+" "+orderBy+" LIMIT ?", append(where.Args(), limit)...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -967,37 +1367,54 @@ WHERE i.item_type = 'assembly'
 		for rows.Next() {
 			var it Item
 			var seriesID sql.NullInt64
+			var seriesName sql.NullString
 			var packQty sql.NullFloat64
 			var reorderPoint sql.NullFloat64
+			var unitCost sql.NullFloat64
+			var unitCostCurrency sql.NullString
+			var measures nullMeasures
 			var note sql.NullString
-			var createdAt sql.NullString
-			var updatedAt sql.NullString
+			var assemblySupplierID sql.NullInt64
 			var assemblyManufacturer sql.NullString
 			var assemblyTotalWeight sql.NullFloat64
+			var weightAuto int
 			var assemblyPackSize sql.NullString
 			var assemblyNote sql.NullString
+			var phantom int
 			var sm int
 			var sellable int
 			var final int
 			if err := rows.Scan(
 				&it.ID,
 				&seriesID,
+				&seriesName,
 				&it.SKU,
 				&it.Name,
 				&it.ItemType,
 				&packQty,
 				&reorderPoint,
+				&unitCost,
+				&unitCostCurrency,
 				&it.ManagedUnit,
+				&measures[0],
+				&measures[1],
+				&measures[2],
+				&measures[3],
+				&measures[4],
 				&sm,
 				&sellable,
 				&final,
+				&it.LifecycleStatus,
 				&note,
-				&createdAt,
-				&updatedAt,
+				&it.CreatedAt,
+				&it.UpdatedAt,
+				&assemblySupplierID,
 				&assemblyManufacturer,
 				&assemblyTotalWeight,
+				&weightAuto,
 				&assemblyPackSize,
 				&assemblyNote,
+				&phantom,
 			); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -1006,6 +1423,9 @@ WHERE i.item_type = 'assembly'
 				sid := seriesID.Int64
 				it.SeriesID = &sid
 			}
+			if seriesName.Valid {
+				it.SeriesName = seriesName.String
+			}
 			if packQty.Valid {
 				pq := packQty.Float64
 				it.PackQty = &pq
@@ -1015,27 +1435,33 @@ WHERE i.item_type = 'assembly'
 				rp = reorderPoint.Float64
 			}
 			it.ReorderPoint = &rp
+			if unitCost.Valid {
+				uc := unitCost.Float64
+				it.UnitCost = &uc
+			}
+			it.UnitCostCurrency = unitCostCurrency.String
+			it.ItemMeasures = measures.measures()
 			if note.Valid {
 				it.Note = note.String
 			}
-			if createdAt.Valid {
-				it.CreatedAt = createdAt.String
-			}
-			if updatedAt.Valid {
-				it.UpdatedAt = updatedAt.String
-			}
 			it.StockManaged = sm != 0
 			it.IsSellable = sellable != 0
 			it.IsFinal = final != 0
 			it.Assembly = &AssemblyDetail{
-				Manufacturer: assemblyManufacturer.String,
-				PackSize:     assemblyPackSize.String,
-				Note:         assemblyNote.String,
+				Manufacturer:    assemblyManufacturer.String,
+				TotalWeightAuto: weightAuto != 0,
+				PackSize:        assemblyPackSize.String,
+				Note:            assemblyNote.String,
+				Phantom:         phantom != 0,
 			}
 			if assemblyTotalWeight.Valid {
 				tw := assemblyTotalWeight.Float64
 				it.Assembly.TotalWeight = &tw
 			}
+			if assemblySupplierID.Valid {
+				sid := assemblySupplierID.Int64
+				it.Assembly.SupplierID = &sid
+			}
 			out = append(out, it)
 		}
 		if err := rows.Err(); err != nil {
@@ -1043,19 +1469,30 @@ WHERE i.item_type = 'assembly'
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(out)
+		if err := attachCustomFields(r.Context(), dbx, out); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeList(w, fields, out)
 	}
 }
 
 func updateItem(dbx *sql.DB) http.HandlerFunc {
 	type AssemblyReq struct {
+		SupplierID   *int64   `json:"supplier_id"`
 		Manufacturer string   `json:"manufacturer"`
 		TotalWeight  *float64 `json:"total_weight"`
-		PackSize     string   `json:"pack_size"`
-		Note         string   `json:"note"`
+		// TotalWeightAuto is kept as is when omitted; false makes
+		// total_weight a manual value again.
+		TotalWeightAuto *bool  `json:"total_weight_auto"`
+		PackSize        string `json:"pack_size"`
+		Note            string `json:"note"`
+		// Phantom is kept as is when omitted.
+		Phantom *bool `json:"phantom"`
 	}
 	type ComponentReq struct {
+		SupplierID    *int64 `json:"supplier_id"`
 		Manufacturer  string `json:"manufacturer"`
 		ComponentType string `json:"component_type"`
 		Color         string `json:"color"`
@@ -1065,17 +1502,29 @@ func updateItem(dbx *sql.DB) http.HandlerFunc {
 		} `json:"purchase_links"`
 	}
 	type Req struct {
-		SKU          string        `json:"sku"`
-		Name         string        `json:"name"`
-		ManagedUnit  string        `json:"managed_unit"`
-		PackQty      *float64      `json:"pack_qty"`
-		ReorderPoint *float64      `json:"reorder_point"`
+		SKU              string   `json:"sku"`
+		Name             string   `json:"name"`
+		ManagedUnit      string   `json:"managed_unit"`
+		PackQty          *float64 `json:"pack_qty"`
+		ReorderPoint     *float64 `json:"reorder_point"`
+		MOQ              *float64 `json:"moq"`
+		OrderMultiple    *float64 `json:"order_multiple"`
+		LeadTimeDays     *int64   `json:"lead_time_days"`
+		UnitCost         *float64 `json:"unit_cost"`
+		UnitCostCurrency *string  `json:"unit_cost_currency"`
+		// Measures are only changed when sent; 0 clears them.
+		ItemMeasures
 		StockManaged bool          `json:"stock_managed"`
 		IsSellable   bool          `json:"is_sellable"`
 		IsFinal      bool          `json:"is_final"`
 		Note         string        `json:"note"`
 		Assembly     *AssemblyReq  `json:"assembly"`
 		Component    *ComponentReq `json:"component"`
+		// BOMTemplateID gives the item its first BOM revision from a
+		// template, with BOMQty overriding line quantities by component
+		// item id.
+		BOMTemplateID *int64            `json:"bom_template_id"`
+		BOMQty        map[int64]float64 `json:"bom_qty"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1096,26 +1545,19 @@ func updateItem(dbx *sql.DB) http.HandlerFunc {
 		req.Name = strings.TrimSpace(req.Name)
 		req.ManagedUnit = strings.TrimSpace(req.ManagedUnit)
 		req.Note = strings.TrimSpace(req.Note)
-		if req.SKU == "" || req.Name == "" {
-			http.Error(w, "sku and name required", http.StatusBadRequest)
-			return
-		}
-		if req.ManagedUnit != "g" && req.ManagedUnit != "pcs" {
-			http.Error(w, "managed_unit must be g or pcs", http.StatusBadRequest)
-			return
-		}
-		if req.PackQty != nil && *req.PackQty <= 0 {
-			http.Error(w, "pack_qty must be > 0", http.StatusBadRequest)
-			return
-		}
-		if req.ReorderPoint != nil && *req.ReorderPoint < 0 {
-			http.Error(w, "reorder_point must be >= 0", http.StatusBadRequest)
-			return
-		}
-		if req.Assembly != nil && req.Assembly.TotalWeight != nil && *req.Assembly.TotalWeight <= 0 {
-			http.Error(w, "assembly.total_weight must be > 0", http.StatusBadRequest)
-			return
-		}
+
+		var errs validate.Errors
+		errs.Required("sku", req.SKU)
+		errs.Required("name", req.Name)
+		errs.OneOf("managed_unit", req.ManagedUnit, "g", "pcs")
+		errs.Check(req.PackQty == nil || *req.PackQty > 0, "pack_qty", "must be > 0")
+		errs.Check(req.ReorderPoint == nil || *req.ReorderPoint >= 0, "reorder_point", "must be >= 0")
+		errs.Check(req.MOQ == nil || *req.MOQ >= 0, "moq", "must be >= 0")
+		errs.Check(req.OrderMultiple == nil || *req.OrderMultiple >= 0, "order_multiple", "must be >= 0")
+		errs.Check(req.LeadTimeDays == nil || *req.LeadTimeDays >= 0, "lead_time_days", "must be >= 0")
+		errs.Check(req.UnitCost == nil || *req.UnitCost >= 0, "unit_cost", "must be >= 0")
+		req.ItemMeasures.validate(&errs, req.ManagedUnit, true)
+		errs.Check(req.Assembly == nil || req.Assembly.TotalWeight == nil || *req.Assembly.TotalWeight > 0, "assembly.total_weight", "must be > 0")
 
 		tx, err := dbx.BeginTx(r.Context(), nil)
 		if err != nil {
@@ -1125,7 +1567,7 @@ func updateItem(dbx *sql.DB) http.HandlerFunc {
 		defer tx.Rollback()
 
 		var itemType string
-		if err := tx.QueryRow(`SELECT item_type FROM items WHERE item_id = ?`, itemID).Scan(&itemType); err != nil {
+		if err := tx.QueryRowContext(r.Context(), `SELECT item_type FROM items WHERE item_id = ?`, itemID).Scan(&itemType); err != nil {
 			if err == sql.ErrNoRows {
 				http.Error(w, "item not found", http.StatusNotFound)
 				return
@@ -1134,8 +1576,67 @@ func updateItem(dbx *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		componentType := "material"
+		if req.Component != nil && strings.TrimSpace(req.Component.ComponentType) != "" {
+			componentType = strings.TrimSpace(req.Component.ComponentType)
+		}
+		if itemType == "component" {
+			errs.OneOf("component.component_type", componentType, "part", "material", "consumable")
+		}
+		if !errs.Has("sku") {
+			var taken int
+			if err := tx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM items WHERE sku = ? AND item_id <> ?`, req.SKU, itemID).Scan(&taken); err != nil {
+				http.Error(w, "failed to check sku", http.StatusInternalServerError)
+				return
+			}
+			errs.Check(taken == 0, "sku", "is already used")
+		}
+		var costCurrency any = nil
+		if req.UnitCostCurrency != nil && strings.TrimSpace(*req.UnitCostCurrency) != "" {
+			code, problem, err := resolveCurrency(r.Context(), tx, *req.UnitCostCurrency)
+			if err != nil {
+				http.Error(w, "failed to load currency", http.StatusInternalServerError)
+				return
+			}
+			if problem != "" {
+				errs.Add("unit_cost_currency", problem)
+			}
+			costCurrency = code
+		}
+		var supplierRef *int64
+		manufacturer := ""
+		if itemType == "assembly" && req.Assembly != nil {
+			supplierRef = req.Assembly.SupplierID
+			manufacturer = strings.TrimSpace(req.Assembly.Manufacturer)
+		}
+		if itemType == "component" && req.Component != nil {
+			supplierRef = req.Component.SupplierID
+			manufacturer = strings.TrimSpace(req.Component.Manufacturer)
+		}
+		supplierID, manufacturer, problem, err := resolveSupplier(r.Context(), tx, supplierRef, manufacturer)
+		if err != nil {
+			http.Error(w, "failed to resolve supplier", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			errs.Add(itemType+".supplier_id", problem)
+		}
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		phantom := false
+		if itemType == "assembly" {
+			if req.Assembly != nil && req.Assembly.Phantom != nil {
+				phantom = *req.Assembly.Phantom
+			} else if err := tx.QueryRowContext(r.Context(), `SELECT COALESCE(MAX(phantom), 0) FROM assemblies WHERE item_id = ?`, itemID).Scan(&phantom); err != nil {
+				http.Error(w, "failed to load assembly", http.StatusInternalServerError)
+				return
+			}
+		}
 		sm := 0
-		if req.StockManaged {
+		if req.StockManaged && !phantom {
 			sm = 1
 		}
 		sellable := 0
@@ -1155,7 +1656,7 @@ func updateItem(dbx *sql.DB) http.HandlerFunc {
 			reorderPoint = *req.ReorderPoint
 		}
 
-		if _, err := tx.Exec(`
+		if _, err := tx.ExecContext(r.Context(), `
 UPDATE items
 SET sku = ?, name = ?, stock_managed = ?, is_sellable = ?, is_final = ?, pack_qty = ?, reorder_point = ?, managed_unit = ?, note = ?
 WHERE item_id = ?
@@ -1163,36 +1664,78 @@ WHERE item_id = ?
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		// moq and order_multiple are only changed when sent; 0 clears them.
+		for col, v := range map[string]*float64{"moq": req.MOQ, "order_multiple": req.OrderMultiple} {
+			if v == nil {
+				continue
+			}
+			var val any
+			if *v > 0 {
+				val = *v
+			}
+			if _, err := tx.ExecContext(r.Context(), `UPDATE items SET `+col+` = ? WHERE item_id = ?`, val, itemID); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		// lead_time_days too, but 0 is a real (same-day) lead time.
+		if req.LeadTimeDays != nil {
+			if _, err := tx.ExecContext(r.Context(), `UPDATE items SET lead_time_days = ? WHERE item_id = ?`, *req.LeadTimeDays, itemID); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := saveItemMeasures(r.Context(), tx, itemID, req.ManagedUnit, &req.ItemMeasures); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// unit_cost is only changed when sent, so older clients keep it.
+		if req.UnitCost != nil {
+			if _, err := tx.ExecContext(r.Context(), `UPDATE items SET unit_cost = ? WHERE item_id = ?`, *req.UnitCost, itemID); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.UnitCostCurrency != nil {
+			if _, err := tx.ExecContext(r.Context(), `UPDATE items SET unit_cost_currency = ? WHERE item_id = ?`, costCurrency, itemID); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 
 		switch itemType {
 		case "assembly":
-			manufacturer := ""
 			var totalWeight any = nil
 			packSize := ""
 			assemblyNote := ""
 			if req.Assembly != nil {
-				manufacturer = strings.TrimSpace(req.Assembly.Manufacturer)
 				if req.Assembly.TotalWeight != nil {
 					totalWeight = *req.Assembly.TotalWeight
 				}
 				packSize = strings.TrimSpace(req.Assembly.PackSize)
 				assemblyNote = strings.TrimSpace(req.Assembly.Note)
 			}
-			if _, err := tx.Exec(`
-INSERT INTO assemblies(item_id, manufacturer, total_weight, pack_size, note)
-VALUES(?,?,?,?,?)
+			if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO assemblies(item_id, supplier_id, manufacturer, total_weight, pack_size, note, phantom)
+VALUES(?,?,?,?,?,?,?)
 ON CONFLICT(item_id) DO UPDATE SET
+  supplier_id = excluded.supplier_id,
   manufacturer = excluded.manufacturer,
   total_weight = excluded.total_weight,
   pack_size = excluded.pack_size,
-  note = excluded.note
-`, itemID, manufacturer, totalWeight, packSize, assemblyNote); err != nil {
+  note = excluded.note,
+  phantom = excluded.phantom
+`, itemID, supplierID, manufacturer, totalWeight, packSize, assemblyNote, phantom); err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
+			if req.Assembly != nil && req.Assembly.TotalWeightAuto != nil {
+				if _, err := tx.ExecContext(r.Context(), `UPDATE assemblies SET total_weight_auto = ? WHERE item_id = ?`, *req.Assembly.TotalWeightAuto, itemID); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
 		case "component":
-			manufacturer := ""
-			componentType := "material"
 			color := ""
 			type purchaseLinkInput struct {
 				URL   string
@@ -1200,8 +1743,6 @@ ON CONFLICT(item_id) DO UPDATE SET
 			}
 			purchaseLinks := make([]purchaseLinkInput, 0)
 			if req.Component != nil {
-				manufacturer = strings.TrimSpace(req.Component.Manufacturer)
-				componentType = strings.TrimSpace(req.Component.ComponentType)
 				color = strings.TrimSpace(req.Component.Color)
 				for _, l := range req.Component.PurchaseLinks {
 					u := strings.TrimSpace(l.URL)
@@ -1214,35 +1755,42 @@ ON CONFLICT(item_id) DO UPDATE SET
 					})
 				}
 			}
-			if componentType == "" {
-				componentType = "material"
-			}
-			if componentType != "part" && componentType != "material" && componentType != "consumable" {
-				http.Error(w, "component.component_type must be part, material, or consumable", http.StatusBadRequest)
-				return
-			}
-			if _, err := tx.Exec(`
-INSERT INTO components(item_id, manufacturer, component_type, color)
-VALUES(?,?,?,?)
+			if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO components(item_id, supplier_id, manufacturer, component_type, color)
+VALUES(?,?,?,?,?)
 ON CONFLICT(item_id) DO UPDATE SET
+  supplier_id = excluded.supplier_id,
   manufacturer = excluded.manufacturer,
   component_type = excluded.component_type,
   color = excluded.color
-`, itemID, manufacturer, componentType, color); err != nil {
+`, itemID, supplierID, manufacturer, componentType, color); err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 			var componentID int64
-			if err := tx.QueryRow(`SELECT component_id FROM components WHERE item_id = ?`, itemID).Scan(&componentID); err != nil {
+			if err := tx.QueryRowContext(r.Context(), `SELECT component_id FROM components WHERE item_id = ?`, itemID).Scan(&componentID); err != nil {
 				http.Error(w, "failed to load component", http.StatusInternalServerError)
 				return
 			}
-			if _, err := tx.Exec(`DELETE FROM component_purchase_links WHERE component_id = ?`, componentID); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+			// Links are matched by URL; the ones left out go to the trash
+			// rather than being deleted.
+			live, err := livePurchaseLinks(r.Context(), tx, componentID)
+			if err != nil {
+				http.Error(w, "failed to load purchase links", http.StatusInternalServerError)
 				return
 			}
 			for idx, link := range purchaseLinks {
-				if _, err := tx.Exec(`
+				if id, ok := live[link.URL]; ok {
+					delete(live, link.URL)
+					if _, err := tx.ExecContext(r.Context(), `
+UPDATE component_purchase_links SET label = ?, sort_order = ? WHERE id = ?
+`, link.Label, idx, id); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					continue
+				}
+				if _, err := tx.ExecContext(r.Context(), `
 INSERT INTO component_purchase_links(component_id, url, label, sort_order, enabled)
 VALUES(?,?,?,?,1)
 `, componentID, link.URL, link.Label, idx); err != nil {
@@ -1250,9 +1798,27 @@ VALUES(?,?,?,?,1)
 					return
 				}
 			}
+			for _, id := range live {
+				if _, err := tx.ExecContext(r.Context(), `
+UPDATE component_purchase_links SET deleted_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE id = ?
+`, id); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
 		}
 
-		if err := tx.Commit(); err != nil {
+		// A new weight, or a total_weight sent for an assembly that follows
+		// its BOM, is carried up to the assemblies that follow theirs.
+		if err := refreshAutoWeights(r.Context(), tx, itemID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := logItemChange(r.Context(), tx, itemID, req.SKU, req.Name, "updated"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
 			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
 			return
 		}
@@ -1260,21 +1826,58 @@ VALUES(?,?,?,?,1)
 	}
 }
 
-func listAssemblyStock(dbx *sql.DB) http.HandlerFunc {
+// listItemStock lists on-hand stock for items of one type. Components can
+// also be filtered by component_type and manufacturer.
+func listItemStock(dbx *sql.DB, itemType string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		q := strings.TrimSpace(r.URL.Query().Get("q"))
-		limit := 50
-		if limitStr := strings.TrimSpace(r.URL.Query().Get("limit")); limitStr != "" {
-			v, err := strconv.Atoi(limitStr)
-			if err != nil || v <= 0 {
-				http.Error(w, "invalid limit", http.StatusBadRequest)
-				return
-			}
-			if v > 500 {
-				v = 500
+		fields, err := parseFields[ItemStock](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		orderBy, err := orderByClause(r, stockSortColumns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		withBuildable := false
+		if v := strings.TrimSpace(r.URL.Query().Get("include")); v != "" {
+			for _, inc := range strings.Split(v, ",") {
+				if strings.TrimSpace(inc) != "buildable" {
+					http.Error(w, "invalid include", http.StatusBadRequest)
+					return
+				}
+				withBuildable = true
 			}
-			limit = v
 		}
+		belowSet, below, err := querybuilder.Bool(r.URL.Query(), "below_reorder")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := querybuilder.Limit(r.URL.Query(), 50, 500)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var where querybuilder.Where
+		where.Eq("i.item_type", itemType)
+		where.Search(r.URL.Query(), "q", "i.sku", "i.name")
+		if err := where.OneOf(r.URL.Query(), "component_type", "c.component_type", "part", "material", "consumable"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		where.Search(r.URL.Query(), "manufacturer", "COALESCE(c.manufacturer, a.manufacturer)")
+		if err := where.Flag(r.URL.Query(), "managed", "i.stock_managed"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lcClause, lcArgs, err := lifecycleFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		where.Append(lcClause, lcArgs)
 
 		sb := strings.Builder{}
 		sb.WriteString(`
@@ -1282,6 +1885,10 @@ SELECT
   i.item_id,
   i.sku,
   i.name,
+  i.stock_managed,
+  i.lifecycle_status,
+  i.reorder_point,
+  i.pack_qty,
   COALESCE(SUM(
     CASE
       WHEN st.transaction_type = 'OUT' THEN -st.qty
@@ -1290,39 +1897,55 @@ SELECT
   ), 0) AS stock_qty,
   MAX(st.created_at) AS updated_at
 FROM items i
+LEFT JOIN components c ON c.item_id = i.item_id
+LEFT JOIN assemblies a ON a.item_id = i.item_id
 LEFT JOIN stock_transactions st ON st.item_id = i.item_id
-WHERE i.item_type = 'assembly'
+WHERE 1=1`)
+		sb.WriteString(where.SQL())
+		args := where.Args()
+		sb.WriteString(`
+GROUP BY i.item_id, i.sku, i.name, i.stock_managed, i.lifecycle_status, i.reorder_point, i.pack_qty
 `)
-		args := make([]any, 0)
-		if q != "" {
-			sb.WriteString(" AND (i.sku LIKE ? OR i.name LIKE ?)")
-			like := "%" + q + "%"
-			args = append(args, like, like)
+		if belowSet {
+			const low = "i.stock_managed = 1 AND i.lifecycle_status != 'obsolete' AND i.reorder_point IS NOT NULL AND stock_qty <= i.reorder_point"
+			if below {
+				sb.WriteString("HAVING " + low + "\n")
+			} else {
+				sb.WriteString("HAVING NOT (" + low + ")\n")
+			}
 		}
+		sb.WriteString(orderBy)
 		sb.WriteString(`
-GROUP BY i.item_id, i.sku, i.name
-ORDER BY i.item_id DESC
 LIMIT ?
 `)
 		args = append(args, limit)
 
-		rows, err := dbx.Query(sb.String(), args...)
+		rows, err := dbx.QueryContext(r.Context(), sb.String(), args...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		defer rows.Close()
 
-		out := make([]AssemblyStock, 0)
+		out := make([]ItemStock, 0)
 		for rows.Next() {
-			var row AssemblyStock
-			var updatedAt sql.NullString
-			if err := rows.Scan(&row.ItemID, &row.SKU, &row.Name, &row.StockQty, &updatedAt); err != nil {
+			var row ItemStock
+			var stockManaged int
+			var reorderPoint, packQty sql.NullFloat64
+			if err := rows.Scan(&row.ItemID, &row.SKU, &row.Name, &stockManaged, &row.LifecycleStatus, &reorderPoint, &packQty, &row.StockQty, &row.UpdatedAt); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			if updatedAt.Valid {
-				row.UpdatedAt = updatedAt.String
+			row.StockManaged = stockManaged != 0
+			if reorderPoint.Valid {
+				v := reorderPoint.Float64
+				row.ReorderPoint = &v
+				row.BelowReorder = row.StockManaged && row.LifecycleStatus != lifecycleObsolete && row.StockQty <= v
+			}
+			if packQty.Valid {
+				v := packQty.Float64
+				row.PackQty = &v
+				row.StockPacks = stockPacks(row.StockQty, row.PackQty)
 			}
 			out = append(out, row)
 		}
@@ -1331,16 +1954,45 @@ LIMIT ?
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(out)
+		if withBuildable && len(out) > 0 {
+			stock, err := loadComponentStock(r.Context(), dbx)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			asOf, _ := parseBOMAsOf("")
+			for i := range out {
+				qty, limit, ok, err := buildableQty(r.Context(), dbx, out[i].ItemID, asOf, stock)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if ok {
+					out[i].Buildable = &qty
+					out[i].BuildableLimit = limit
+				}
+			}
+		}
+
+		writeList(w, fields, out)
 	}
 }
 
-func adjustAssemblyStock(dbx *sql.DB) http.HandlerFunc {
+func adjustAssemblyStock(st *store.Store) http.HandlerFunc {
+	dbx := st.DB()
 	type Req struct {
 		Direction string  `json:"direction"`
 		Qty       float64 `json:"qty"`
-		Note      string  `json:"note"`
+		// Packs gives the quantity in packs instead of qty; it is
+		// multiplied by the item's pack_qty.
+		Packs      *float64 `json:"packs"`
+		Note       string   `json:"note"`
+		ReasonCode string   `json:"reason_code"`
+		// RefID names the count sheet of a SET; it is ignored otherwise.
+		RefID string `json:"ref_id"`
+		// Expr is a tally to evaluate instead of qty, such as "3*250+120"
+		// or "2 packs + 30".
+		Expr string `json:"expr"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1358,19 +2010,68 @@ func adjustAssemblyStock(dbx *sql.DB) http.HandlerFunc {
 		}
 		req.Direction = strings.ToUpper(strings.TrimSpace(req.Direction))
 		req.Note = strings.TrimSpace(req.Note)
-		if req.Direction != "IN" && req.Direction != "OUT" {
-			http.Error(w, "direction must be IN or OUT", http.StatusBadRequest)
+		// SET records the difference to an absolute counted quantity as an
+		// ADJUST entry.
+		if req.Direction != "IN" && req.Direction != "OUT" && req.Direction != "SET" {
+			http.Error(w, "direction must be IN, OUT or SET", http.StatusBadRequest)
 			return
 		}
-		if req.Qty <= 0 {
-			http.Error(w, "qty must be > 0", http.StatusBadRequest)
-			return
-		}
-
-		var itemType string
-		if err := dbx.QueryRow(`SELECT item_type FROM items WHERE item_id = ?`, itemID).Scan(&itemType); err != nil {
-			if err == sql.ErrNoRows {
-				http.Error(w, "item not found", http.StatusNotFound)
+		qtyField := "qty"
+		if req.Packs != nil {
+			if req.Qty != 0 {
+				http.Error(w, "give qty or packs, not both", http.StatusBadRequest)
+				return
+			}
+			qtyField, req.Qty = "packs", *req.Packs
+		}
+		var expr *QtyExpr
+		if req.Expr = strings.TrimSpace(req.Expr); req.Expr != "" {
+			if qtyField != "qty" || req.Qty != 0 {
+				http.Error(w, "give one of qty, packs or expr", http.StatusBadRequest)
+				return
+			}
+			e, problem, err := evalItemQtyExpr(r.Context(), dbx, itemID, "expr", req.Expr)
+			if err == sql.ErrNoRows {
+				http.Error(w, "item not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, "failed to load item", http.StatusInternalServerError)
+				return
+			}
+			if problem != "" {
+				http.Error(w, problem, http.StatusBadRequest)
+				return
+			}
+			expr, qtyField, req.Qty = &e, "expr", e.Qty
+		}
+		if req.Direction == "SET" {
+			if req.Qty < 0 {
+				http.Error(w, qtyField+" must be >= 0", http.StatusBadRequest)
+				return
+			}
+		} else if req.Qty <= 0 {
+			http.Error(w, qtyField+" must be > 0", http.StatusBadRequest)
+			return
+		}
+		if req.Direction == "OUT" && strings.TrimSpace(req.ReasonCode) == "" {
+			http.Error(w, "reason_code is required for OUT adjustments", http.StatusBadRequest)
+			return
+		}
+		reasonCode, problem, err := resolveReasonCode(r.Context(), dbx, req.ReasonCode)
+		if err != nil {
+			http.Error(w, "failed to load reason code", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+
+		itemType, err := st.ItemType(r.Context(), nil, itemID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "item not found", http.StatusNotFound)
 				return
 			}
 			http.Error(w, "failed to load item", http.StatusInternalServerError)
@@ -1380,66 +2081,126 @@ func adjustAssemblyStock(dbx *sql.DB) http.HandlerFunc {
 			http.Error(w, "item must be assembly", http.StatusBadRequest)
 			return
 		}
-
-		var currentStock float64
-		if err := dbx.QueryRow(`
-SELECT COALESCE(SUM(
-  CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END
-), 0)
-FROM stock_transactions
-WHERE item_id = ?
-`, itemID).Scan(&currentStock); err != nil {
-			http.Error(w, "failed to compute current stock", http.StatusInternalServerError)
+		packQty, err := itemPackQty(r.Context(), dbx, itemID)
+		if err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
 			return
 		}
-		if req.Direction == "OUT" && currentStock < req.Qty {
-			http.Error(w, "insufficient stock: cannot go below zero", http.StatusBadRequest)
+		if req.Packs != nil {
+			if packQty == 0 {
+				http.Error(w, "item has no pack_qty; give qty instead of packs", http.StatusBadRequest)
+				return
+			}
+			req.Qty *= packQty
+		}
+		req.Qty, problem, err = applyItemUnit(r.Context(), dbx, itemID, qtyField, req.Qty)
+		if err != nil {
+			http.Error(w, "failed to load unit rule", http.StatusInternalServerError)
 			return
 		}
-
-		if _, err := dbx.Exec(`
-INSERT INTO stock_transactions(item_id, qty, transaction_type, note)
-VALUES(?,?,?,?)
-`, itemID, req.Qty, req.Direction, req.Note); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
 			return
 		}
 
-		var stockQty float64
-		if err := dbx.QueryRow(`
-SELECT COALESCE(SUM(
-  CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END
-), 0)
-FROM stock_transactions
-WHERE item_id = ?
-`, itemID).Scan(&stockQty); err != nil {
-			http.Error(w, "failed to compute stock", http.StatusInternalServerError)
+		currentStock, err := st.ItemStock(r.Context(), nil, itemID)
+		if err != nil {
+			http.Error(w, "failed to compute current stock", http.StatusInternalServerError)
 			return
 		}
+		warnings := make([]string, 0)
+		if req.Direction == "OUT" {
+			msg, blocked, err := checkNegativeStock(r.Context(), dbx, itemID, currentStock, req.Qty)
+			if err != nil {
+				http.Error(w, "failed to load negative stock policy", http.StatusInternalServerError)
+				return
+			}
+			if blocked {
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
+			if msg != "" {
+				warnings = append(warnings, msg)
+			}
+		}
+
+		txnType, qty := req.Direction, req.Qty
+		if req.Direction == "SET" {
+			txnType, qty = "ADJUST", req.Qty-currentStock
+		}
+		stockQty := currentStock
+		if qty != 0 {
+			tx, err := dbx.BeginTx(r.Context(), nil)
+			if err != nil {
+				http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+				return
+			}
+			defer tx.Rollback()
+			res, err := tx.ExecContext(r.Context(), `
+INSERT INTO stock_transactions(item_id, qty, transaction_type, note, reason_code, request_id, actor)
+VALUES(?,?,?,?,?,?,?)
+`, itemID, qty, txnType, req.Note, reasonCode, requestIDArg(r.Context()), actorArg(r.Context()))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// A SET is a stock count.
+			if req.Direction == "SET" {
+				txnID, _ := res.LastInsertId()
+				ref := txnRef{Type: refStocktake, ID: strings.TrimSpace(req.RefID)}
+				if err := ref.stamp(r.Context(), tx, txnID); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			if stockQty, err = st.ItemStock(r.Context(), tx, itemID); err != nil {
+				http.Error(w, "failed to compute stock", http.StatusInternalServerError)
+				return
+			}
+			if err := store.Commit(r.Context(), tx); err != nil {
+				http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+				return
+			}
+		}
 
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]any{
-			"item_id":   itemID,
-			"stock_qty": stockQty,
-		})
+		out := map[string]any{
+			"item_id":          itemID,
+			"stock_qty":        stockQty,
+			"transaction_type": txnType,
+			"delta":            qty,
+			"warnings":         warnings,
+		}
+		if packQty > 0 {
+			out["pack_qty"] = packQty
+			out["stock_packs"] = stockQty / packQty
+		}
+		if expr != nil {
+			out["expr"] = expr
+		}
+		_ = json.NewEncoder(w).Encode(out)
 	}
 }
 
 func listProductionParts(dbx *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		q := strings.TrimSpace(r.URL.Query().Get("q"))
-		limit := 200
-		if limitStr := strings.TrimSpace(r.URL.Query().Get("limit")); limitStr != "" {
-			v, err := strconv.Atoi(limitStr)
-			if err != nil || v <= 0 {
-				http.Error(w, "invalid limit", http.StatusBadRequest)
-				return
-			}
-			if v > 500 {
-				v = 500
-			}
-			limit = v
+		fields, err := parseFields[ProductionPart](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		orderBy, err := orderByClause(r, productionSortColumns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := querybuilder.Limit(r.URL.Query(), 200, 500)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		var where querybuilder.Where
+		where.Search(r.URL.Query(), "q", "i.sku", "i.name")
 
 		sb := strings.Builder{}
 		sb.WriteString(`
@@ -1472,22 +2233,15 @@ WHERE (
   AND ar.rev_no = (
     SELECT MAX(ar2.rev_no)
     FROM assembly_records ar2
-    WHERE ar2.item_id = i.item_id
+    WHERE ar2.item_id = i.item_id AND ar2.obsolete_at IS NULL
   )
 `)
-		args := make([]any, 0)
-		if q != "" {
-			sb.WriteString(" AND (i.sku LIKE ? OR i.name LIKE ?)")
-			like := "%" + q + "%"
-			args = append(args, like, like)
-		}
-		sb.WriteString(`
-ORDER BY i.item_id DESC
-LIMIT ?
-`)
+		sb.WriteString(where.SQL())
+		args := where.Args()
+		sb.WriteString("\n" + orderBy + "\nLIMIT ?\n")
 		args = append(args, limit)
 
-		rows, err := dbx.Query(sb.String(), args...)
+		rows, err := dbx.QueryContext(r.Context(), sb.String(), args...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -1497,7 +2251,6 @@ LIMIT ?
 		out := make([]ProductionPart, 0)
 		for rows.Next() {
 			var row ProductionPart
-			var updatedAt sql.NullString
 			if err := rows.Scan(
 				&row.ItemID,
 				&row.SKU,
@@ -1506,14 +2259,11 @@ LIMIT ?
 				&row.ManagedUnit,
 				&row.CurrentRevNo,
 				&row.StockQty,
-				&updatedAt,
+				&row.UpdatedAt,
 			); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			if updatedAt.Valid {
-				row.UpdatedAt = updatedAt.String
-			}
 			out = append(out, row)
 		}
 		if err := rows.Err(); err != nil {
@@ -1521,15 +2271,20 @@ LIMIT ?
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(out)
+		writeList(w, fields, out)
 	}
 }
 
-func completePartProduction(dbx *sql.DB) http.HandlerFunc {
+func completePartProduction(st *store.Store) http.HandlerFunc {
+	dbx := st.DB()
 	type Req struct {
 		Qty  float64 `json:"qty"`
 		Note string  `json:"note"`
+		// AsOf picks the BOM revision in effect then; empty means now.
+		AsOf string `json:"as_of"`
+		// RefID is the build (work order) number; by default the build is
+		// numbered after its stock-in row.
+		RefID string `json:"ref_id"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1550,9 +2305,14 @@ func completePartProduction(dbx *sql.DB) http.HandlerFunc {
 			http.Error(w, "qty must be > 0", http.StatusBadRequest)
 			return
 		}
+		asOf, err := parseBOMAsOf(req.AsOf)
+		if err != nil {
+			http.Error(w, "invalid as_of", http.StatusBadRequest)
+			return
+		}
 
 		var count int
-		if err := dbx.QueryRow(`
+		if err := dbx.QueryRowContext(r.Context(), `
 SELECT COUNT(1)
 FROM items i
 LEFT JOIN components c ON c.item_id = i.item_id
@@ -1567,6 +2327,16 @@ WHERE i.item_id = ?
 			http.Error(w, "item must be component(part)", http.StatusBadRequest)
 			return
 		}
+		qty, problem, err := applyItemUnit(r.Context(), dbx, itemID, "qty", req.Qty)
+		if err != nil {
+			http.Error(w, "failed to load unit rule", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		req.Qty = qty
 
 		tx, err := dbx.BeginTx(r.Context(), nil)
 		if err != nil {
@@ -1575,14 +2345,8 @@ WHERE i.item_id = ?
 		}
 		defer tx.Rollback()
 
-		var recordID int64
-		if err := tx.QueryRow(`
-SELECT record_id
-FROM assembly_records
-WHERE item_id = ?
-ORDER BY rev_no DESC
-LIMIT 1
-`, itemID).Scan(&recordID); err != nil {
+		recordID, _, yield, err := effectiveBOMRevision(r.Context(), tx, itemID, asOf)
+		if err != nil {
 			if err == sql.ErrNoRows {
 				http.Error(w, "bom revision not found", http.StatusBadRequest)
 				return
@@ -1591,54 +2355,86 @@ LIMIT 1
 			return
 		}
 
-		if _, err := tx.Exec(`
-INSERT INTO stock_transactions(item_id, qty, transaction_type, note)
-VALUES(?,?,?,?)
-`, itemID, req.Qty, "IN", req.Note); err != nil {
+		res, err := tx.ExecContext(r.Context(), `
+INSERT INTO stock_transactions(item_id, qty, transaction_type, note, bom_record_id, request_id, actor)
+VALUES(?,?,?,?,?,?,?)
+`, itemID, req.Qty, "IN", req.Note, recordID, requestIDArg(r.Context()), actorArg(r.Context()))
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		ref := txnRef{Type: refBuild, ID: strings.TrimSpace(req.RefID)}
+		inID, _ := res.LastInsertId()
+		if err := ref.stamp(r.Context(), tx, inID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-		compRows, err := tx.Query(`
-SELECT component_item_id, qty_per_unit
-FROM assembly_components
-WHERE record_id = ?
-`, recordID)
+		needs, problem, err := explodeBOMRecord(r.Context(), tx, recordID, yield, asOf, 0)
 		if err != nil {
 			http.Error(w, "failed to load bom components", http.StatusInternalServerError)
 			return
 		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
 		consumed := make(map[int64]ProductionConsumption)
-		for compRows.Next() {
-			var componentItemID int64
-			var qtyPerUnit float64
-			if err := compRows.Scan(&componentItemID, &qtyPerUnit); err != nil {
-				compRows.Close()
-				http.Error(w, "failed to scan bom components", http.StatusInternalServerError)
-				return
-			}
-			outQty := req.Qty * qtyPerUnit
+		warnings := make([]string, 0)
+		for _, need := range needs {
+			componentItemID := need.ItemID
+			outQty := req.Qty * need.Gross
 			if outQty <= 0 {
 				continue
 			}
-			if _, err := tx.Exec(`
-INSERT INTO stock_transactions(item_id, qty, transaction_type, note)
-VALUES(?,?,?,?)
-`, componentItemID, outQty, "OUT", "production consumption"); err != nil {
-				compRows.Close()
+			// Consumption is held to the negative stock policy like any
+			// other stock-out.
+			var stockManaged int
+			if err := tx.QueryRowContext(r.Context(), `SELECT stock_managed FROM items WHERE item_id = ?`, componentItemID).Scan(&stockManaged); err != nil {
+				http.Error(w, "failed to load stock setting", http.StatusInternalServerError)
+				return
+			}
+			if stockManaged != 0 {
+				currentStock, err := st.ItemStock(r.Context(), tx, componentItemID)
+				if err != nil {
+					http.Error(w, "failed to compute current stock", http.StatusInternalServerError)
+					return
+				}
+				msg, blocked, err := checkNegativeStock(r.Context(), tx, componentItemID, currentStock, outQty)
+				if err != nil {
+					http.Error(w, "failed to load negative stock policy", http.StatusInternalServerError)
+					return
+				}
+				if blocked {
+					http.Error(w, msg, http.StatusBadRequest)
+					return
+				}
+				if msg != "" {
+					warnings = append(warnings, msg)
+				}
+			}
+			res, err := tx.ExecContext(r.Context(), `
+INSERT INTO stock_transactions(item_id, qty, transaction_type, note, reason_code, bom_record_id, request_id, actor)
+VALUES(?,?,?,?,?,?,?,?)
+`, componentItemID, outQty, "OUT", "production consumption", "build", recordID, requestIDArg(r.Context()), actorArg(r.Context()))
+			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
+			outID, _ := res.LastInsertId()
+			if err := ref.stamp(r.Context(), tx, outID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
 			row := consumed[componentItemID]
 			if row.ItemID == 0 {
 				var componentType sql.NullString
-				if err := tx.QueryRow(`
+				if err := tx.QueryRowContext(r.Context(), `
 SELECT i.sku, i.name, i.item_type, i.managed_unit, c.component_type
 FROM items i
 LEFT JOIN components c ON c.item_id = i.item_id
 WHERE i.item_id = ?
 `, componentItemID).Scan(&row.SKU, &row.Name, &row.ItemType, &row.ManagedUnit, &componentType); err != nil {
-					compRows.Close()
 					http.Error(w, "failed to load consumed item", http.StatusInternalServerError)
 					return
 				}
@@ -1650,29 +2446,14 @@ WHERE i.item_id = ?
 			row.Qty += outQty
 			consumed[componentItemID] = row
 		}
-		if err := compRows.Err(); err != nil {
-			compRows.Close()
-			http.Error(w, "failed to read bom components", http.StatusInternalServerError)
-			return
-		}
-		if err := compRows.Close(); err != nil {
-			http.Error(w, "failed to close bom components", http.StatusInternalServerError)
-			return
-		}
 
-		var stockQty float64
-		if err := tx.QueryRow(`
-SELECT COALESCE(SUM(
-  CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END
-), 0)
-FROM stock_transactions
-WHERE item_id = ?
-`, itemID).Scan(&stockQty); err != nil {
+		stockQty, err := st.ItemStock(r.Context(), tx, itemID)
+		if err != nil {
 			http.Error(w, "failed to compute stock", http.StatusInternalServerError)
 			return
 		}
 
-		if err := tx.Commit(); err != nil {
+		if err := store.Commit(r.Context(), tx); err != nil {
 			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
 			return
 		}
@@ -1686,25 +2467,31 @@ WHERE item_id = ?
 			"item_id":      itemID,
 			"stock_qty":    stockQty,
 			"consumptions": consumedList,
+			"ref_id":       ref.ID,
+			"warnings":     warnings,
 		})
 	}
 }
 
 func listProductionComponents(dbx *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		q := strings.TrimSpace(r.URL.Query().Get("q"))
-		limit := 200
-		if limitStr := strings.TrimSpace(r.URL.Query().Get("limit")); limitStr != "" {
-			v, err := strconv.Atoi(limitStr)
-			if err != nil || v <= 0 {
-				http.Error(w, "invalid limit", http.StatusBadRequest)
-				return
-			}
-			if v > 500 {
-				v = 500
-			}
-			limit = v
+		fields, err := parseFields[ProductionComponent](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		orderBy, err := orderByClause(r, productionSortColumns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := querybuilder.Limit(r.URL.Query(), 200, 500)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		var where querybuilder.Where
+		where.Search(r.URL.Query(), "q", "i.sku", "i.name")
 
 		sb := strings.Builder{}
 		sb.WriteString(`
@@ -1732,19 +2519,12 @@ LEFT JOIN (
 WHERE i.item_type = 'component'
   AND c.component_type IN ('material', 'part', 'consumable')
 `)
-		args := make([]any, 0)
-		if q != "" {
-			sb.WriteString(" AND (i.sku LIKE ? OR i.name LIKE ?)")
-			like := "%" + q + "%"
-			args = append(args, like, like)
-		}
-		sb.WriteString(`
-ORDER BY i.item_id DESC
-LIMIT ?
-`)
+		sb.WriteString(where.SQL())
+		args := where.Args()
+		sb.WriteString("\n" + orderBy + "\nLIMIT ?\n")
 		args = append(args, limit)
 
-		rows, err := dbx.Query(sb.String(), args...)
+		rows, err := dbx.QueryContext(r.Context(), sb.String(), args...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -1755,7 +2535,6 @@ LIMIT ?
 		for rows.Next() {
 			var row ProductionComponent
 			var packQty sql.NullFloat64
-			var updatedAt sql.NullString
 			if err := rows.Scan(
 				&row.ItemID,
 				&row.SKU,
@@ -1764,7 +2543,7 @@ LIMIT ?
 				&packQty,
 				&row.ComponentType,
 				&row.StockQty,
-				&updatedAt,
+				&row.UpdatedAt,
 			); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -1772,9 +2551,7 @@ LIMIT ?
 			if packQty.Valid {
 				pq := packQty.Float64
 				row.PackQty = &pq
-			}
-			if updatedAt.Valid {
-				row.UpdatedAt = updatedAt.String
+				row.StockPacks = stockPacks(row.StockQty, row.PackQty)
 			}
 			out = append(out, row)
 		}
@@ -1783,8 +2560,7 @@ LIMIT ?
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(out)
+		writeList(w, fields, out)
 	}
 }
 
@@ -1792,9 +2568,13 @@ func completeProductionComponents(dbx *sql.DB) http.HandlerFunc {
 	type StockInRow struct {
 		ItemID int64   `json:"item_id"`
 		Qty    float64 `json:"qty"`
+		// Packs is an alternative to qty, multiplied by the item's pack_qty.
+		Packs float64 `json:"packs"`
 	}
 	type Req struct {
 		Rows []StockInRow `json:"rows"`
+		// RefID is the purchase order or delivery note received.
+		RefID string `json:"ref_id"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1814,11 +2594,44 @@ func completeProductionComponents(dbx *sql.DB) http.HandlerFunc {
 				http.Error(w, "item_id must be > 0", http.StatusBadRequest)
 				return
 			}
+			if row.Packs != 0 {
+				if row.Qty != 0 {
+					http.Error(w, "give qty or packs, not both", http.StatusBadRequest)
+					return
+				}
+				if row.Packs < 0 {
+					http.Error(w, "packs must be > 0", http.StatusBadRequest)
+					return
+				}
+				packQty, err := itemPackQty(r.Context(), dbx, row.ItemID)
+				if err == sql.ErrNoRows {
+					http.Error(w, fmt.Sprintf("item not found: %d", row.ItemID), http.StatusBadRequest)
+					return
+				}
+				if err != nil {
+					http.Error(w, "failed to load item", http.StatusInternalServerError)
+					return
+				}
+				if packQty == 0 {
+					http.Error(w, fmt.Sprintf("item has no pack_qty: %d", row.ItemID), http.StatusBadRequest)
+					return
+				}
+				row.Qty = row.Packs * packQty
+			}
 			if row.Qty <= 0 {
 				http.Error(w, "qty must be > 0", http.StatusBadRequest)
 				return
 			}
-			merged[row.ItemID] += row.Qty
+			qty, problem, err := applyItemUnit(r.Context(), dbx, row.ItemID, "qty", row.Qty)
+			if err != nil {
+				http.Error(w, "failed to load unit rule", http.StatusInternalServerError)
+				return
+			}
+			if problem != "" {
+				http.Error(w, fmt.Sprintf("%s: %d", problem, row.ItemID), http.StatusBadRequest)
+				return
+			}
+			merged[row.ItemID] += qty
 		}
 
 		tx, err := dbx.BeginTx(r.Context(), nil)
@@ -1828,9 +2641,10 @@ func completeProductionComponents(dbx *sql.DB) http.HandlerFunc {
 		}
 		defer tx.Rollback()
 
+		ref := txnRef{Type: refPOReceipt, ID: strings.TrimSpace(req.RefID)}
 		for itemID, qty := range merged {
 			var count int
-			if err := tx.QueryRow(`
+			if err := tx.QueryRowContext(r.Context(), `
 SELECT COUNT(1)
 FROM items i
 JOIN components c ON c.item_id = i.item_id
@@ -1845,16 +2659,28 @@ WHERE i.item_id = ?
 				http.Error(w, fmt.Sprintf("item must be component(material/part/consumable): %d", itemID), http.StatusBadRequest)
 				return
 			}
-			if _, err := tx.Exec(`
-INSERT INTO stock_transactions(item_id, qty, transaction_type, note)
-VALUES(?,?,?,?)
-`, itemID, qty, "IN", "component stock in"); err != nil {
+			res, err := tx.ExecContext(r.Context(), `
+INSERT INTO stock_transactions(item_id, qty, transaction_type, note, request_id, actor)
+VALUES(?,?,?,?,?,?)
+`, itemID, qty, "IN", "component stock in", requestIDArg(r.Context()), actorArg(r.Context()))
+			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
+			txnID, _ := res.LastInsertId()
+			if err := ref.stamp(r.Context(), tx, txnID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if req.RefID != "" {
+				if err := receivePOLines(r.Context(), tx, ref.ID, itemID, qty); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
 		}
 
-		if err := tx.Commit(); err != nil {
+		if err := store.Commit(r.Context(), tx); err != nil {
 			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
 			return
 		}
@@ -1862,25 +2688,30 @@ VALUES(?,?,?,?)
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]any{
 			"completed_count": len(merged),
+			"ref_id":          ref.ID,
 		})
 	}
 }
 
 func listShippingAssemblies(dbx *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		q := strings.TrimSpace(r.URL.Query().Get("q"))
-		limit := 200
-		if limitStr := strings.TrimSpace(r.URL.Query().Get("limit")); limitStr != "" {
-			v, err := strconv.Atoi(limitStr)
-			if err != nil || v <= 0 {
-				http.Error(w, "invalid limit", http.StatusBadRequest)
-				return
-			}
-			if v > 500 {
-				v = 500
-			}
-			limit = v
+		fields, err := parseFields[ShippingAssembly](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		orderBy, err := orderByClause(r, productionSortColumns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := querybuilder.Limit(r.URL.Query(), 200, 500)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		var where querybuilder.Where
+		where.Search(r.URL.Query(), "q", "i.sku", "i.name")
 
 		sb := strings.Builder{}
 		sb.WriteString(`
@@ -1908,22 +2739,15 @@ WHERE i.item_type = 'assembly'
   AND ar.rev_no = (
     SELECT MAX(ar2.rev_no)
     FROM assembly_records ar2
-    WHERE ar2.item_id = i.item_id
+    WHERE ar2.item_id = i.item_id AND ar2.obsolete_at IS NULL
   )
 `)
-		args := make([]any, 0)
-		if q != "" {
-			sb.WriteString(" AND (i.sku LIKE ? OR i.name LIKE ?)")
-			like := "%" + q + "%"
-			args = append(args, like, like)
-		}
-		sb.WriteString(`
-ORDER BY i.item_id DESC
-LIMIT ?
-`)
+		sb.WriteString(where.SQL())
+		args := where.Args()
+		sb.WriteString("\n" + orderBy + "\nLIMIT ?\n")
 		args = append(args, limit)
 
-		rows, err := dbx.Query(sb.String(), args...)
+		rows, err := dbx.QueryContext(r.Context(), sb.String(), args...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -1933,7 +2757,6 @@ LIMIT ?
 		out := make([]ShippingAssembly, 0)
 		for rows.Next() {
 			var row ShippingAssembly
-			var updatedAt sql.NullString
 			if err := rows.Scan(
 				&row.ItemID,
 				&row.SKU,
@@ -1941,14 +2764,11 @@ LIMIT ?
 				&row.ManagedUnit,
 				&row.CurrentRevNo,
 				&row.StockQty,
-				&updatedAt,
+				&row.UpdatedAt,
 			); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			if updatedAt.Valid {
-				row.UpdatedAt = updatedAt.String
-			}
 			out = append(out, row)
 		}
 		if err := rows.Err(); err != nil {
@@ -1956,8 +2776,7 @@ LIMIT ?
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(out)
+		writeList(w, fields, out)
 	}
 }
 
@@ -1968,6 +2787,8 @@ func completeShipments(dbx *sql.DB) http.HandlerFunc {
 	}
 	type Req struct {
 		Shipments []ShipmentReq `json:"shipments"`
+		// RefID is the order or delivery number shipped.
+		RefID string `json:"ref_id"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1991,7 +2812,16 @@ func completeShipments(dbx *sql.DB) http.HandlerFunc {
 				http.Error(w, "qty must be > 0", http.StatusBadRequest)
 				return
 			}
-			merged[row.ItemID] += row.Qty
+			qty, problem, err := applyItemUnit(r.Context(), dbx, row.ItemID, "qty", row.Qty)
+			if err != nil {
+				http.Error(w, "failed to load unit rule", http.StatusInternalServerError)
+				return
+			}
+			if problem != "" {
+				http.Error(w, fmt.Sprintf("%s: %d", problem, row.ItemID), http.StatusBadRequest)
+				return
+			}
+			merged[row.ItemID] += qty
 		}
 
 		tx, err := dbx.BeginTx(r.Context(), nil)
@@ -2001,127 +2831,134 @@ func completeShipments(dbx *sql.DB) http.HandlerFunc {
 		}
 		defer tx.Rollback()
 
-		// deduction by item_id (assembly itself + bom children)
-		deductions := make(map[int64]float64)
+		deductions, problem, err := shipmentDeductions(r.Context(), tx, merged)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		ref := txnRef{Type: refShipment, ID: strings.TrimSpace(req.RefID)}
+		warnings, problem, err := bookShipment(r.Context(), tx, deductions, &ref)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
 
-		for itemID, shipQty := range merged {
-			var itemType string
-			if err := tx.QueryRow(`SELECT item_type FROM items WHERE item_id = ?`, itemID).Scan(&itemType); err != nil {
-				if err == sql.ErrNoRows {
-					http.Error(w, fmt.Sprintf("item not found: %d", itemID), http.StatusBadRequest)
-					return
-				}
-				http.Error(w, "failed to load item", http.StatusInternalServerError)
-				return
-			}
-			if itemType != "assembly" {
-				http.Error(w, fmt.Sprintf("item must be assembly: %d", itemID), http.StatusBadRequest)
-				return
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"shipment_count": len(merged),
+			"deducted_items": len(deductions),
+			"warnings":       warnings,
+			"ref_id":         ref.ID,
+		})
+	}
+}
+
+// shipmentDeductions expands shipped assemblies into the stock taken off:
+// the assembly itself plus its components under the BOM in effect now.
+func shipmentDeductions(ctx context.Context, q queryer, shipped map[int64]float64) (map[int64]float64, string, error) {
+	now := timeutil.Format(time.Now())
+	deductions := make(map[int64]float64)
+	for itemID, shipQty := range shipped {
+		var itemType string
+		if err := q.QueryRowContext(ctx, `SELECT item_type FROM items WHERE item_id = ?`, itemID).Scan(&itemType); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Sprintf("item not found: %d", itemID), nil
 			}
+			return nil, "", fmt.Errorf("failed to load item: %w", err)
+		}
+		if itemType != "assembly" {
+			return nil, fmt.Sprintf("item must be assembly: %d", itemID), nil
+		}
 
-			var recordID int64
-			if err := tx.QueryRow(`
-SELECT record_id
-FROM assembly_records
-WHERE item_id = ?
-ORDER BY rev_no DESC
-LIMIT 1
-`, itemID).Scan(&recordID); err != nil {
-				if err == sql.ErrNoRows {
-					http.Error(w, fmt.Sprintf("bom revision not found: %d", itemID), http.StatusBadRequest)
-					return
-				}
-				http.Error(w, "failed to load bom revision", http.StatusInternalServerError)
-				return
+		recordID, _, yield, err := effectiveBOMRevision(ctx, q, itemID, now)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Sprintf("bom revision not found: %d", itemID), nil
 			}
+			return nil, "", fmt.Errorf("failed to load bom revision: %w", err)
+		}
 
-			deductions[itemID] += shipQty
+		deductions[itemID] += shipQty
 
-			compRows, err := tx.Query(`
-SELECT component_item_id, qty_per_unit
-FROM assembly_components
-WHERE record_id = ?
-`, recordID)
-			if err != nil {
-				http.Error(w, "failed to load bom components", http.StatusInternalServerError)
-				return
-			}
-			for compRows.Next() {
-				var componentItemID int64
-				var qtyPerUnit float64
-				if err := compRows.Scan(&componentItemID, &qtyPerUnit); err != nil {
-					compRows.Close()
-					http.Error(w, "failed to scan bom components", http.StatusInternalServerError)
-					return
-				}
-				deductions[componentItemID] += shipQty * qtyPerUnit
-			}
-			if err := compRows.Err(); err != nil {
-				compRows.Close()
-				http.Error(w, "failed to read bom components", http.StatusInternalServerError)
-				return
-			}
-			if err := compRows.Close(); err != nil {
-				http.Error(w, "failed to close bom components", http.StatusInternalServerError)
-				return
-			}
+		needs, problem, err := explodeBOMRecord(ctx, q, recordID, yield, now, 0)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load bom components: %w", err)
+		}
+		if problem != "" {
+			return nil, problem, nil
+		}
+		for _, need := range needs {
+			deductions[need.ItemID] += shipQty * need.Gross
 		}
+	}
+	return deductions, "", nil
+}
 
-		for itemID, outQty := range deductions {
-			var stockManaged int
-			if err := tx.QueryRow(`SELECT stock_managed FROM items WHERE item_id = ?`, itemID).Scan(&stockManaged); err != nil {
-				http.Error(w, "failed to load stock setting", http.StatusInternalServerError)
-				return
-			}
-			if stockManaged == 0 {
-				continue
-			}
+// bookShipment writes the OUT rows of a shipment under ref. problem is set
+// when the negative stock policy blocks it; nothing is written then.
+func bookShipment(ctx context.Context, tx *sql.Tx, deductions map[int64]float64, ref *txnRef) (warnings []string, problem string, err error) {
+	warnings = make([]string, 0)
+	for itemID, outQty := range deductions {
+		var stockManaged int
+		if err := tx.QueryRowContext(ctx, `SELECT stock_managed FROM items WHERE item_id = ?`, itemID).Scan(&stockManaged); err != nil {
+			return nil, "", fmt.Errorf("failed to load stock setting: %w", err)
+		}
+		if stockManaged == 0 {
+			continue
+		}
 
-			var currentStock float64
-			if err := tx.QueryRow(`
+		var currentStock float64
+		if err := tx.QueryRowContext(ctx, `
 SELECT COALESCE(SUM(
   CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END
 ), 0)
 FROM stock_transactions
 WHERE item_id = ?
 `, itemID).Scan(&currentStock); err != nil {
-				http.Error(w, "failed to compute current stock", http.StatusInternalServerError)
-				return
-			}
-			if currentStock < outQty {
-				http.Error(
-					w,
-					fmt.Sprintf("insufficient stock: item_id=%d required=%.3f current=%.3f", itemID, outQty, currentStock),
-					http.StatusBadRequest,
-				)
-				return
-			}
+			return nil, "", fmt.Errorf("failed to compute current stock: %w", err)
 		}
-
-		for itemID, outQty := range deductions {
-			if outQty <= 0 {
-				continue
-			}
-			if _, err := tx.Exec(`
-INSERT INTO stock_transactions(item_id, qty, transaction_type, note)
-VALUES(?,?,?,?)
-`, itemID, outQty, "OUT", "shipment"); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		msg, blocked, err := checkNegativeStock(ctx, tx, itemID, currentStock, outQty)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load negative stock policy: %w", err)
 		}
-
-		if err := tx.Commit(); err != nil {
-			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
-			return
+		if blocked {
+			return nil, msg, nil
+		}
+		if msg != "" {
+			warnings = append(warnings, msg)
 		}
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]any{
-			"shipment_count": len(merged),
-			"deducted_items": len(deductions),
-		})
+	for itemID, outQty := range deductions {
+		if outQty <= 0 {
+			continue
+		}
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO stock_transactions(item_id, qty, transaction_type, note, reason_code, request_id, actor)
+VALUES(?,?,?,?,?,?,?)
+`, itemID, outQty, "OUT", "shipment", "sale", requestIDArg(ctx), actorArg(ctx))
+		if err != nil {
+			return nil, "", err
+		}
+		txnID, _ := res.LastInsertId()
+		if err := ref.stamp(ctx, tx, txnID); err != nil {
+			return nil, "", err
+		}
 	}
+	return warnings, "", nil
 }
 
 func getAssemblyComponents(dbx *sql.DB) http.HandlerFunc {
@@ -2134,7 +2971,7 @@ func getAssemblyComponents(dbx *sql.DB) http.HandlerFunc {
 		}
 
 		var parentType string
-		if err := dbx.QueryRow(`SELECT item_type FROM items WHERE item_id = ?`, parentItemID).Scan(&parentType); err != nil {
+		if err := dbx.QueryRowContext(r.Context(), `SELECT item_type FROM items WHERE item_id = ?`, parentItemID).Scan(&parentType); err != nil {
 			if err == sql.ErrNoRows {
 				http.Error(w, "item not found", http.StatusNotFound)
 				return
@@ -2148,16 +2985,21 @@ func getAssemblyComponents(dbx *sql.DB) http.HandlerFunc {
 		}
 
 		revisions := make([]AssemblyRevision, 0)
-		revRows, err := dbx.Query(`
+		revRows, err := dbx.QueryContext(r.Context(), `
 SELECT
   ar.record_id,
   ar.rev_no,
   ar.created_at,
-  COALESCE(COUNT(ac.component_item_id), 0) AS component_count
+  COALESCE(COUNT(ac.component_item_id), 0) AS component_count,
+  ar.effective_from,
+  ar.obsolete_at,
+  (SELECT ec.eco_id FROM eco_changes ec WHERE ec.record_id = ar.record_id) AS eco_id,
+  COALESCE(ar.change_note, ''),
+  COALESCE(ar.changed_by, '')
 FROM assembly_records ar
 LEFT JOIN assembly_components ac ON ac.record_id = ar.record_id
 WHERE ar.item_id = ?
-GROUP BY ar.record_id, ar.rev_no, ar.created_at
+GROUP BY ar.record_id, ar.rev_no, ar.created_at, ar.effective_from, ar.obsolete_at, ar.change_note, ar.changed_by
 ORDER BY ar.rev_no DESC
 `, parentItemID)
 		if err != nil {
@@ -2166,11 +3008,18 @@ ORDER BY ar.rev_no DESC
 		}
 		for revRows.Next() {
 			var row AssemblyRevision
-			if err := revRows.Scan(&row.RecordID, &row.RevNo, &row.CreatedAt, &row.ComponentCount); err != nil {
+			var effectiveFrom, obsoleteAt timeutil.Time
+			if err := revRows.Scan(&row.RecordID, &row.RevNo, &row.CreatedAt, &row.ComponentCount, &effectiveFrom, &obsoleteAt, &row.ECOID, &row.ChangeNote, &row.ChangedBy); err != nil {
 				revRows.Close()
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			if !effectiveFrom.IsZero() {
+				row.EffectiveFrom = &effectiveFrom
+			}
+			if !obsoleteAt.IsZero() {
+				row.ObsoleteAt = &obsoleteAt
+			}
 			revisions = append(revisions, row)
 		}
 		if err := revRows.Err(); err != nil {
@@ -2194,6 +3043,21 @@ ORDER BY ar.rev_no DESC
 			return
 		}
 
+		asOf, err := parseBOMAsOf(r.URL.Query().Get("as_of"))
+		if err != nil {
+			http.Error(w, "invalid as_of", http.StatusBadRequest)
+			return
+		}
+		_, effectiveRevNo, _, err := effectiveBOMRevision(r.Context(), dbx, parentItemID, asOf)
+		if err == nil {
+			resp.EffectiveRevNo = &effectiveRevNo
+		} else if err != sql.ErrNoRows {
+			http.Error(w, "failed to load revision", http.StatusInternalServerError)
+			return
+		}
+
+		// Without rev_no the latest revision that is not obsolete is shown, or
+		// with as_of the one in effect then.
 		targetRevNo := int64(0)
 		if revNoStr := strings.TrimSpace(r.URL.Query().Get("rev_no")); revNoStr != "" {
 			v, err := strconv.ParseInt(revNoStr, 10, 64)
@@ -2202,17 +3066,34 @@ ORDER BY ar.rev_no DESC
 				return
 			}
 			targetRevNo = v
+		} else if strings.TrimSpace(r.URL.Query().Get("as_of")) != "" {
+			if resp.EffectiveRevNo == nil {
+				http.Error(w, "no revision in effect at as_of", http.StatusNotFound)
+				return
+			}
+			targetRevNo = effectiveRevNo
 		} else {
-			targetRevNo = revisions[0].RevNo
+			for _, rev := range revisions {
+				if rev.ObsoleteAt == nil {
+					targetRevNo = rev.RevNo
+					break
+				}
+			}
+			if targetRevNo == 0 {
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(resp)
+				return
+			}
 		}
 
 		var recordID int64
-		var createdAt string
-		if err := dbx.QueryRow(`
-SELECT record_id, created_at
+		var createdAt timeutil.Time
+		var yield float64
+		if err := dbx.QueryRowContext(r.Context(), `
+SELECT record_id, created_at, yield
 FROM assembly_records
 WHERE item_id = ? AND rev_no = ?
-`, parentItemID, targetRevNo).Scan(&recordID, &createdAt); err != nil {
+`, parentItemID, targetRevNo).Scan(&recordID, &createdAt, &yield); err != nil {
 			if err == sql.ErrNoRows {
 				http.Error(w, "revision not found", http.StatusNotFound)
 				return
@@ -2223,68 +3104,23 @@ WHERE item_id = ? AND rev_no = ?
 
 		resp.CurrentRecordID = &recordID
 		resp.CurrentRevNo = &targetRevNo
-		resp.CurrentCreatedAt = createdAt
+		resp.CurrentCreatedAt = &createdAt
+		resp.CurrentYield = &yield
 
-		rows, err := dbx.Query(`
-SELECT
-  ac.component_item_id,
-  i.sku,
-  i.name,
-  i.item_type,
-  i.managed_unit,
-  ac.qty_per_unit,
-  ac.note
-FROM assembly_components ac
-JOIN items i ON i.item_id = ac.component_item_id
-WHERE ac.record_id = ?
-ORDER BY ac.component_item_id
-`, recordID)
+		resp.Components, err = loadBOMComponents(r.Context(), dbx, recordID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var row AssemblyComponent
-			var note sql.NullString
-			if err := rows.Scan(
-				&row.ComponentItemID,
-				&row.SKU,
-				&row.Name,
-				&row.ItemType,
-				&row.ManagedUnit,
-				&row.QtyPerUnit,
-				&note,
-			); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if note.Valid {
-				row.Note = note.String
-			}
-			resp.Components = append(resp.Components, row)
-		}
-		if err := rows.Err(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
 
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(resp)
 	}
 }
 
-func createAssemblyComponentsRevision(dbx *sql.DB) http.HandlerFunc {
-	type ComponentReq struct {
-		ComponentItemID int64   `json:"component_item_id"`
-		QtyPerUnit      float64 `json:"qty_per_unit"`
-		Note            string  `json:"note"`
-	}
-	type Req struct {
-		Components []ComponentReq `json:"components"`
-	}
-
+// createAssemblyComponentsRevision stores a new BOM revision of at most
+// maxComponents lines (zero means no limit).
+func createAssemblyComponentsRevision(dbx *sql.DB, maxComponents int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		idStr := chi.URLParam(r, "id")
 		parentItemID, err := strconv.ParseInt(idStr, 10, 64)
@@ -2293,112 +3129,423 @@ func createAssemblyComponentsRevision(dbx *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		var req Req
+		var req BOMRevisionReq
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "bad json", http.StatusBadRequest)
 			return
 		}
 
-		var parentType string
-		if err := dbx.QueryRow(`SELECT item_type FROM items WHERE item_id = ?`, parentItemID).Scan(&parentType); err != nil {
-			if err == sql.ErrNoRows {
-				http.Error(w, "item not found", http.StatusNotFound)
-				return
-			}
-			http.Error(w, "failed to load item", http.StatusInternalServerError)
+		errs, problem, status, err := validateBOMRevision(r.Context(), dbx, parentItemID, &req, maxComponents)
+		if err != nil {
+			http.Error(w, "failed to validate bom", http.StatusInternalServerError)
 			return
 		}
-		if parentType != "assembly" && parentType != "component" {
-			http.Error(w, "item must be assembly or component", http.StatusBadRequest)
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		noteRequired, err := changeNoteRequired(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, "failed to load setting", http.StatusInternalServerError)
+			return
+		}
+		checkChangeNote(&errs, &req, noteRequired)
+		if !errs.Empty() {
+			errs.Write(w)
 			return
 		}
-		if len(req.Components) == 0 {
-			http.Error(w, "components are required", http.StatusBadRequest)
+		if required, err := ecoRequired(r.Context(), dbx); err != nil {
+			http.Error(w, "failed to load setting", http.StatusInternalServerError)
+			return
+		} else if required {
+			http.Error(w, ecoRequiredMessage, http.StatusConflict)
 			return
 		}
 
-		seen := make(map[int64]struct{}, len(req.Components))
-		for _, c := range req.Components {
-			if c.ComponentItemID <= 0 {
-				http.Error(w, "component_item_id must be > 0", http.StatusBadRequest)
-				return
-			}
-			if c.ComponentItemID == parentItemID {
-				http.Error(w, "self reference is not allowed", http.StatusBadRequest)
-				return
+		var recordID, nextRevNo int64
+		var applyErr error
+		err = retryRevision(func() error {
+			tx, err := dbx.BeginTx(r.Context(), nil)
+			if err != nil {
+				return err
 			}
-			if c.QtyPerUnit <= 0 {
-				http.Error(w, "qty_per_unit must be > 0", http.StatusBadRequest)
-				return
+			defer tx.Rollback()
+			recordID, nextRevNo, problem, applyErr = applyBOMRevision(r.Context(), tx, parentItemID, &req)
+			if applyErr != nil || problem != "" {
+				return applyErr
 			}
-			if _, exists := seen[c.ComponentItemID]; exists {
-				http.Error(w, "duplicate component_item_id is not allowed", http.StatusBadRequest)
-				return
+			return store.Commit(r.Context(), tx)
+		})
+		switch {
+		case errors.Is(err, errRevisionConflict):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case applyErr != nil:
+			http.Error(w, applyErr.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, "failed to store bom revision", http.StatusInternalServerError)
+			return
+		case problem != "":
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"record_id": recordID,
+			"rev_no":    nextRevNo,
+		})
+	}
+}
+
+// BOMRevisionReq is the body of a new BOM revision, sent directly or staged
+// on an ECO.
+type BOMRevisionReq struct {
+	Components []AssemblyComponent `json:"components"`
+	// Yield defaults to the previous revision's.
+	Yield *float64 `json:"yield"`
+	// EffectiveFrom delays the revision; empty means it applies at once.
+	EffectiveFrom string `json:"effective_from,omitempty"`
+	// ChangeNote explains the revision and ChangedBy names its author.
+	// Revisions made by an ECO default to its title and approver, others
+	// to the signed-in caller.
+	ChangeNote string `json:"change_note,omitempty"`
+	ChangedBy  string `json:"changed_by,omitempty"`
+}
+
+// validateBOMRevision checks a revision for parentItemID against the current
+// items. A missing or unsuitable parent is reported as problem with status;
+// everything wrong with the payload itself is collected in errs, with line
+// errors under components[i].
+func validateBOMRevision(ctx context.Context, q queryer, parentItemID int64, req *BOMRevisionReq, maxComponents int) (errs validate.Errors, problem string, status int, err error) {
+	var parentType string
+	if err := q.QueryRowContext(ctx, `SELECT item_type FROM items WHERE item_id = ?`, parentItemID).Scan(&parentType); err != nil {
+		if err == sql.ErrNoRows {
+			return errs, "item not found", http.StatusNotFound, nil
+		}
+		return errs, "", 0, err
+	}
+	if parentType != "assembly" && parentType != "component" {
+		return errs, "item must be assembly or component", http.StatusBadRequest, nil
+	}
+	if req.Yield != nil && (*req.Yield <= 0 || *req.Yield > 1) {
+		errs.Add("yield", "must be > 0 and <= 1")
+	}
+	if v := strings.TrimSpace(req.EffectiveFrom); v != "" {
+		if ts, err := timeutil.Normalize(v); err != nil {
+			errs.Add("effective_from", "must be a date or RFC3339 timestamp")
+		} else {
+			req.EffectiveFrom = ts
+		}
+	}
+	err = validateBOMLines(ctx, q, parentItemID, "components", req.Components, maxComponents, &errs)
+	return errs, "", 0, err
+}
+
+// validateBOMLines checks the lines of a BOM for parentItemID (0 for a
+// template) against the current items, reporting line errors under
+// field[i]. Quantities are rounded to their component's unit in place.
+func validateBOMLines(ctx context.Context, q queryer, parentItemID int64, field string, components []AssemblyComponent, maxComponents int, errs *validate.Errors) error {
+	switch {
+	case len(components) == 0:
+		errs.Add(field, "is required")
+		return nil
+	case maxComponents > 0 && len(components) > maxComponents:
+		// Checked before any line so an oversized payload costs no queries.
+		errs.Add(field, fmt.Sprintf("at most %d lines are allowed, got %d", maxComponents, len(components)))
+		return nil
+	}
+	lineField := func(i int) string { return fmt.Sprintf("%s[%d]", field, i) }
+
+	firstLine := make(map[int64]int, len(components))
+	for i := range components {
+		c := &components[i]
+		field := lineField(i)
+		switch {
+		case c.ComponentItemID <= 0:
+			errs.Add(field+".component_item_id", "must be > 0")
+		case c.ComponentItemID == parentItemID:
+			errs.Add(field+".component_item_id", "self reference is not allowed")
+		default:
+			if prev, dup := firstLine[c.ComponentItemID]; dup {
+				errs.Add(field+".component_item_id", "duplicates "+lineField(prev))
+			} else {
+				firstLine[c.ComponentItemID] = i
 			}
-			seen[c.ComponentItemID] = struct{}{}
+		}
+		errs.Check(c.QtyPerUnit > 0, field+".qty_per_unit", "must be > 0")
+		errs.Check(c.ScrapFactor >= 0 && c.ScrapFactor < 1, field+".scrap_factor", "must be >= 0 and < 1")
+		errs.Check(c.Position >= 0, field+".position", "must be >= 0")
+		checkBOMAlternates(errs, field+".alternates", parentItemID, c.ComponentItemID, c.Alternates)
+	}
 
-			var exists int
-			if err := dbx.QueryRow(`SELECT COUNT(1) FROM items WHERE item_id = ?`, c.ComponentItemID).Scan(&exists); err != nil {
-				http.Error(w, "failed to validate component item", http.StatusInternalServerError)
-				return
+	// Every referenced item, lines and alternates alike, is looked up at once.
+	var ids []int64
+	seen := make(map[int64]struct{})
+	for _, c := range components {
+		for _, id := range append([]int64{c.ComponentItemID}, alternateIDs(c.Alternates)...) {
+			if _, ok := seen[id]; ok || id <= 0 {
+				continue
 			}
-			if exists == 0 {
-				http.Error(w, fmt.Sprintf("component item not found: %d", c.ComponentItemID), http.StatusBadRequest)
-				return
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	statuses, err := itemLifecycles(ctx, q, ids)
+	if err != nil {
+		return err
+	}
+	checkItem := func(field string, id int64) {
+		status, ok := statuses[id]
+		switch {
+		case id <= 0:
+		case !ok:
+			errs.Add(field, fmt.Sprintf("item not found: %d", id))
+		case status == lifecycleObsolete:
+			errs.Add(field, fmt.Sprintf("item %d is obsolete", id))
+		}
+	}
+	units, err := itemUnitRules(ctx, q, ids)
+	if err != nil {
+		return err
+	}
+	for i, c := range components {
+		field := lineField(i)
+		checkItem(field+".component_item_id", c.ComponentItemID)
+		for j, a := range c.Alternates {
+			checkItem(fmt.Sprintf("%s.alternates[%d].item_id", field, j), a.ItemID)
+		}
+		// Line quantities are kept at the precision of the component's unit.
+		if rule, ok := units[c.ComponentItemID]; ok && c.QtyPerUnit > 0 {
+			qty, problem := rule.apply(c.QtyPerUnit)
+			if problem != "" {
+				errs.Add(field+".qty_per_unit", problem)
 			}
+			components[i].QtyPerUnit = qty
 		}
+	}
+	return nil
+}
 
-		tx, err := dbx.BeginTx(r.Context(), nil)
-		if err != nil {
-			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
-			return
+// itemLifecycles returns the lifecycle status of those of ids that are
+// items, in a single query.
+func itemLifecycles(ctx context.Context, q queryer, ids []int64) (map[int64]string, error) {
+	found := make(map[int64]string, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := q.QueryContext(ctx, `
+SELECT item_id, lifecycle_status FROM items
+WHERE item_id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")+`)
+`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var status string
+		if err := rows.Scan(&id, &status); err != nil {
+			return nil, err
+		}
+		found[id] = status
+	}
+	return found, rows.Err()
+}
+
+// applyBOMRevision stores a validated revision after checking for BOM cycles.
+func applyBOMRevision(ctx context.Context, tx *sql.Tx, parentItemID int64, req *BOMRevisionReq) (recordID, revNo int64, problem string, err error) {
+	componentIDs := make([]int64, 0, len(req.Components))
+	for _, c := range req.Components {
+		componentIDs = append(componentIDs, c.ComponentItemID)
+	}
+	cycleVia, found, err := findBOMCycle(ctx, tx, parentItemID, componentIDs)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if found {
+		return 0, 0, fmt.Sprintf("bom cycle detected: component %d already contains item %d", cycleVia, parentItemID), nil
+	}
+	recordID, revNo, err = insertBOMRevision(ctx, tx, parentItemID, req)
+	return recordID, revNo, "", err
+}
+
+// errRevisionConflict means a concurrent request stored the revision number
+// first; rerunning the whole transaction picks the next one.
+var errRevisionConflict = errors.New("bom was revised concurrently, try again")
+
+// revisionRetries bounds the attempts at storing a revision while
+// concurrent requests keep taking its number.
+const revisionRetries = 3
+
+// retryRevision runs fn, a whole transaction storing a revision, again while
+// it loses the race for the revision number. SQLite mostly reports that race
+// as SQLITE_BUSY on the insert (the snapshot read for MAX(rev_no) went
+// stale), so that is retried too. Still losing after revisionRetries gives
+// errRevisionConflict.
+func retryRevision(fn func() error) error {
+	for range revisionRetries {
+		if err := fn(); !errors.Is(err, errRevisionConflict) && !store.IsBusy(err) {
+			return err
 		}
-		defer tx.Rollback()
+	}
+	return errRevisionConflict
+}
 
-		var nextRevNo int64
-		if err := tx.QueryRow(`
+// revisionErrorStatus is the status for an error storing a revision in a
+// transaction that isn't retried: 409 when it lost the race for the
+// revision number, 400 otherwise.
+func revisionErrorStatus(err error) int {
+	if errors.Is(err, errRevisionConflict) || store.IsBusy(err) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// insertBOMRevision stores req as the next revision of the parent. A nil
+// yield keeps the previous revision's, and so do lines with nil refs or
+// alternates. An empty effective_from puts the revision in effect at once.
+func insertBOMRevision(ctx context.Context, tx *sql.Tx, parentItemID int64, req *BOMRevisionReq) (recordID, revNo int64, err error) {
+	components, yield := req.Components, req.Yield
+	prevRecordID, err := latestBOMRecordID(ctx, tx, parentItemID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := tx.QueryRowContext(ctx, `
 SELECT COALESCE(MAX(rev_no), 0) + 1
 FROM assembly_records
 WHERE item_id = ?
-`, parentItemID).Scan(&nextRevNo); err != nil {
-			http.Error(w, "failed to compute next revision", http.StatusInternalServerError)
-			return
-		}
-
-		res, err := tx.Exec(`
-INSERT INTO assembly_records(item_id, rev_no)
-VALUES(?,?)
-`, parentItemID, nextRevNo)
+`, parentItemID).Scan(&revNo); err != nil {
+		return 0, 0, err
+	}
+	if yield == nil {
+		y, err := latestBOMYield(ctx, tx, parentItemID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return 0, 0, err
 		}
-		recordID, _ := res.LastInsertId()
+		yield = &y
+	}
 
-		for _, c := range req.Components {
-			note := strings.TrimSpace(c.Note)
-			if _, err := tx.Exec(`
-INSERT INTO assembly_components(record_id, component_item_id, qty_per_unit, note)
+	var effective, note any
+	// Without an explicit author the revision is credited to the caller.
+	by := actorArg(ctx)
+	if req.EffectiveFrom != "" {
+		effective = req.EffectiveFrom
+	}
+	if req.ChangeNote != "" {
+		note = req.ChangeNote
+	}
+	if req.ChangedBy != "" {
+		by = req.ChangedBy
+	}
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO assembly_records(item_id, rev_no, yield, effective_from, change_note, changed_by)
+VALUES(?,?,?,?,?,?)
+`, parentItemID, revNo, *yield, effective, note, by)
+	if err != nil {
+		// assembly_records has one unique key, (item_id, rev_no).
+		if store.IsUniqueViolation(err) {
+			return 0, 0, errRevisionConflict
+		}
+		return 0, 0, err
+	}
+	recordID, _ = res.LastInsertId()
+
+	for i, c := range components {
+		var refs any
+		if c.Refs != nil {
+			if v := normalizeRefs(*c.Refs); v != "" {
+				refs = v
+			}
+		} else if prevRecordID != 0 {
+			var prev sql.NullString
+			err := tx.QueryRowContext(ctx, `
+SELECT refs FROM assembly_components WHERE record_id = ? AND component_item_id = ?
+`, prevRecordID, c.ComponentItemID).Scan(&prev)
+			if err != nil && err != sql.ErrNoRows {
+				return 0, 0, err
+			}
+			if prev.Valid {
+				refs = prev.String
+			}
+		}
+		position := c.Position
+		if position == 0 {
+			position = int64(i + 1)
+		}
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO assembly_components(record_id, component_item_id, qty_per_unit, scrap_factor, note, refs, position)
+VALUES(?,?,?,?,?,?,?)
+`, recordID, c.ComponentItemID, c.QtyPerUnit, c.ScrapFactor, strings.TrimSpace(c.Note), refs, position); err != nil {
+			return 0, 0, err
+		}
+		if c.Alternates == nil {
+			if prevRecordID == 0 {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `
+INSERT INTO assembly_component_alternates(record_id, component_item_id, alternate_item_id, priority)
+SELECT ?, component_item_id, alternate_item_id, priority
+FROM assembly_component_alternates
+WHERE record_id = ? AND component_item_id = ? AND alternate_item_id <> ?
+`, recordID, prevRecordID, c.ComponentItemID, parentItemID); err != nil {
+				return 0, 0, err
+			}
+			continue
+		}
+		for _, a := range c.Alternates {
+			if _, err := tx.ExecContext(ctx, `
+INSERT INTO assembly_component_alternates(record_id, component_item_id, alternate_item_id, priority)
 VALUES(?,?,?,?)
-`, recordID, c.ComponentItemID, c.QtyPerUnit, note); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+`, recordID, c.ComponentItemID, a.ItemID, a.Priority); err != nil {
+				return 0, 0, err
 			}
 		}
+	}
+	// The new revision is now the latest, so assemblies following their
+	// BOM weigh what it says.
+	if err := refreshAutoWeights(ctx, tx, parentItemID); err != nil {
+		return 0, 0, err
+	}
+	return recordID, revNo, nil
+}
 
-		if err := tx.Commit(); err != nil {
-			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
-			return
+// findBOMCycle reports whether any of componentIDs already reaches parentItemID
+// through existing BOM lines. Every revision counts as an edge, since any of
+// them can still be selected by rev_no.
+func findBOMCycle(ctx context.Context, tx *sql.Tx, parentItemID int64, componentIDs []int64) (int64, bool, error) {
+	for _, componentID := range componentIDs {
+		var hit int
+		err := tx.QueryRowContext(ctx, `
+WITH RECURSIVE reach(item_id) AS (
+  SELECT ?
+  UNION
+  SELECT ac.component_item_id
+  FROM reach
+  JOIN assembly_records ar ON ar.item_id = reach.item_id
+  JOIN assembly_components ac ON ac.record_id = ar.record_id
+)
+SELECT 1 FROM reach WHERE item_id = ? LIMIT 1
+`, componentID, parentItemID).Scan(&hit)
+		if err == sql.ErrNoRows {
+			continue
 		}
-
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]any{
-			"record_id": recordID,
-			"rev_no":    nextRevNo,
-		})
+		if err != nil {
+			return 0, false, err
+		}
+		return componentID, true, nil
 	}
+	return 0, false, nil
 }
 
+// deleteAssemblyComponentsRevision marks a revision obsolete. rev_no values
+// are never reused or renumbered, and revisions that builds or ECOs refer to
+// cannot be deleted.
 func deleteAssemblyComponentsRevision(dbx *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		idStr := chi.URLParam(r, "id")
@@ -2415,7 +3562,7 @@ func deleteAssemblyComponentsRevision(dbx *sql.DB) http.HandlerFunc {
 		}
 
 		var parentType string
-		if err := dbx.QueryRow(`SELECT item_type FROM items WHERE item_id = ?`, parentItemID).Scan(&parentType); err != nil {
+		if err := dbx.QueryRowContext(r.Context(), `SELECT item_type FROM items WHERE item_id = ?`, parentItemID).Scan(&parentType); err != nil {
 			if err == sql.ErrNoRows {
 				http.Error(w, "item not found", http.StatusNotFound)
 				return
@@ -2436,11 +3583,12 @@ func deleteAssemblyComponentsRevision(dbx *sql.DB) http.HandlerFunc {
 		defer tx.Rollback()
 
 		var recordID int64
-		if err := tx.QueryRow(`
-SELECT record_id
+		var obsolete bool
+		if err := tx.QueryRowContext(r.Context(), `
+SELECT record_id, obsolete_at IS NOT NULL
 FROM assembly_records
 WHERE item_id = ? AND rev_no = ?
-`, parentItemID, revNo).Scan(&recordID); err != nil {
+`, parentItemID, revNo).Scan(&recordID, &obsolete); err != nil {
 			if err == sql.ErrNoRows {
 				http.Error(w, "revision not found", http.StatusNotFound)
 				return
@@ -2448,21 +3596,37 @@ WHERE item_id = ? AND rev_no = ?
 			http.Error(w, "failed to load revision", http.StatusInternalServerError)
 			return
 		}
+		if obsolete {
+			http.Error(w, "revision is already obsolete", http.StatusConflict)
+			return
+		}
 
-		if _, err := tx.Exec(`DELETE FROM assembly_records WHERE record_id = ?`, recordID); err != nil {
+		var builds, ecos int
+		if err := tx.QueryRowContext(r.Context(), `
+SELECT
+  (SELECT COUNT(1) FROM stock_transactions WHERE bom_record_id = ?),
+  (SELECT COUNT(1) FROM eco_changes WHERE record_id = ?)
+`, recordID, recordID).Scan(&builds, &ecos); err != nil {
+			http.Error(w, "failed to check revision references", http.StatusInternalServerError)
+			return
+		}
+		if builds > 0 || ecos > 0 {
+			http.Error(w, fmt.Sprintf("revision is referenced by %d build transactions and %d ecos", builds, ecos), http.StatusConflict)
+			return
+		}
+
+		if _, err := tx.ExecContext(r.Context(), `
+UPDATE assembly_records SET obsolete_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE record_id = ?
+`, recordID); err != nil {
 			http.Error(w, "failed to delete revision", http.StatusInternalServerError)
 			return
 		}
-		if _, err := tx.Exec(`
-UPDATE assembly_records
-SET rev_no = rev_no - 1
-WHERE item_id = ? AND rev_no > ?
-`, parentItemID, revNo); err != nil {
-			http.Error(w, "failed to resequence revisions", http.StatusInternalServerError)
+		if err := refreshAutoWeights(r.Context(), tx, parentItemID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		if err := tx.Commit(); err != nil {
+		if err := store.Commit(r.Context(), tx); err != nil {
 			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
 			return
 		}
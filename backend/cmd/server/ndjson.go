@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const ndjsonType = "application/x-ndjson"
+
+// ndjsonFlushEvery is how many rows are buffered between flushes of a
+// streamed export.
+const ndjsonFlushEvery = 500
+
+// ndjsonExports are the list endpoints that stream with Accept:
+// application/x-ndjson.
+var ndjsonExports = map[string]bool{"/api/items": true, "/api/transactions": true}
+
+// longLived reports the requests that outlast the query timeout: the event
+// stream, and exports, which run each page under the timeout instead.
+func longLived(r *http.Request) bool {
+	if r.URL.Path == "/api/events" {
+		return true
+	}
+	return r.Method == http.MethodGet && ndjsonExports[r.URL.Path] && wantsNDJSON(r)
+}
+
+// wantsNDJSON reports whether the Accept header asks for newline-delimited
+// JSON, which list endpoints that support it stream row by row instead of
+// answering with one array.
+func wantsNDJSON(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mt, _, err := mime.ParseMediaType(strings.TrimSpace(v)); err == nil && mt == ndjsonType {
+			return true
+		}
+	}
+	return false
+}
+
+// ndjsonStream writes one JSON value per line, with only the keys in
+// fields, flushing every ndjsonFlushEvery rows so the client sees rows as
+// they are scanned.
+type ndjsonStream struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	enc    *json.Encoder
+	fields fieldSet
+	n      int
+}
+
+func newNDJSONStream(w http.ResponseWriter, fields fieldSet) *ndjsonStream {
+	w.Header().Set("Content-Type", ndjsonType)
+	return &ndjsonStream{w: w, rc: http.NewResponseController(w), enc: json.NewEncoder(w), fields: fields}
+}
+
+func (s *ndjsonStream) Write(v any) error {
+	v, err := s.fields.project(v)
+	if err != nil {
+		return err
+	}
+	if err := s.enc.Encode(v); err != nil {
+		return err
+	}
+	s.n++
+	if s.n%ndjsonFlushEvery == 0 {
+		return s.flush()
+	}
+	return nil
+}
+
+// Fail reports an error met while streaming. Before the first row it is a
+// plain 500; after it the status is gone, so the connection is aborted and
+// the client sees a truncated body rather than a short but complete one.
+func (s *ndjsonStream) Fail(err error) {
+	if s.n == 0 {
+		http.Error(s.w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("ndjson stream aborted after %d rows: %v", s.n, err)
+	panic(http.ErrAbortHandler)
+}
+
+// Close flushes what is left; an empty result is an empty 200 body.
+func (s *ndjsonStream) Close() {
+	if s.n == 0 {
+		s.w.WriteHeader(http.StatusOK)
+	}
+	_ = s.flush()
+}
+
+func (s *ndjsonStream) flush() error {
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+)
+
+// Negative stock policies decide what happens when an outgoing movement would
+// take on-hand stock below zero: block rejects it, warn records it and returns
+// a warning, allow records it silently.
+const (
+	negativeStockBlock = "block"
+	negativeStockWarn  = "warn"
+	negativeStockAllow = "allow"
+)
+
+const negativeStockSettingKey = "negative_stock_policy"
+
+func validNegativeStockPolicy(p string) bool {
+	return p == negativeStockBlock || p == negativeStockWarn || p == negativeStockAllow
+}
+
+// globalNegativeStockPolicy returns the instance-wide policy, block when unset.
+func globalNegativeStockPolicy(ctx context.Context, q queryer) (string, error) {
+	var v string
+	err := q.QueryRowContext(ctx, `SELECT value FROM app_settings WHERE key = ?`, negativeStockSettingKey).Scan(&v)
+	if err == sql.ErrNoRows {
+		return negativeStockBlock, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !validNegativeStockPolicy(v) {
+		return negativeStockBlock, nil
+	}
+	return v, nil
+}
+
+// itemNegativeStockPolicy returns the item's own policy, falling back to the
+// global one.
+func itemNegativeStockPolicy(ctx context.Context, q queryer, itemID int64) (string, error) {
+	var p sql.NullString
+	if err := q.QueryRowContext(ctx, `SELECT negative_stock_policy FROM items WHERE item_id = ?`, itemID).Scan(&p); err != nil {
+		return "", err
+	}
+	if p.Valid && validNegativeStockPolicy(p.String) {
+		return p.String, nil
+	}
+	return globalNegativeStockPolicy(ctx, q)
+}
+
+// checkNegativeStock applies the item's policy to taking outQty from current.
+// It returns a warning for the response under warn, and blocked=true with the
+// message to report under block.
+func checkNegativeStock(ctx context.Context, q queryer, itemID int64, current, outQty float64) (msg string, blocked bool, err error) {
+	if current >= outQty {
+		return "", false, nil
+	}
+	policy, err := itemNegativeStockPolicy(ctx, q, itemID)
+	if err != nil {
+		return "", false, err
+	}
+	msg = fmt.Sprintf("insufficient stock: item_id=%d required=%.3f current=%.3f", itemID, outQty, current)
+	switch policy {
+	case negativeStockAllow:
+		return "", false, nil
+	case negativeStockWarn:
+		return msg, false, nil
+	}
+	return msg, true, nil
+}
+
+func getNegativeStockSetting(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		policy, err := globalNegativeStockPolicy(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, "failed to load setting", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"policy": policy})
+	}
+}
+
+func setNegativeStockSetting(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Policy string `json:"policy"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Policy = strings.ToLower(strings.TrimSpace(req.Policy))
+		if !validNegativeStockPolicy(req.Policy) {
+			http.Error(w, "policy must be block, warn or allow", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := store.Exec(r.Context(), dbx, `
+INSERT INTO app_settings(key, value) VALUES(?, ?)
+ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+`, negativeStockSettingKey, req.Policy); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"policy": req.Policy})
+	}
+}
+
+func getItemNegativeStockPolicy(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		writeItemNegativeStockPolicy(w, r, dbx, itemID)
+	}
+}
+
+// setItemNegativeStockPolicy sets the item's override; an empty or null
+// policy clears it so the item follows the global setting again.
+func setItemNegativeStockPolicy(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Policy *string `json:"policy"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		var policy any = nil
+		if req.Policy != nil {
+			if p := strings.ToLower(strings.TrimSpace(*req.Policy)); p != "" {
+				if !validNegativeStockPolicy(p) {
+					http.Error(w, "policy must be block, warn, allow or null", http.StatusBadRequest)
+					return
+				}
+				policy = p
+			}
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(r.Context(), `UPDATE items SET negative_stock_policy = ? WHERE item_id = ?`, policy, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "item not found", http.StatusNotFound)
+			return
+		}
+		out, err := loadItemNegativeStockPolicy(r.Context(), tx, itemID)
+		if err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func writeItemNegativeStockPolicy(w http.ResponseWriter, r *http.Request, dbx *sql.DB, itemID int64) {
+	out, err := loadItemNegativeStockPolicy(r.Context(), dbx, itemID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "item not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to load item", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// loadItemNegativeStockPolicy is the body of the per-item policy endpoints:
+// the item's own policy and the one in effect for it.
+func loadItemNegativeStockPolicy(ctx context.Context, q queryer, itemID int64) (map[string]any, error) {
+	var own sql.NullString
+	if err := q.QueryRowContext(ctx, `SELECT negative_stock_policy FROM items WHERE item_id = ?`, itemID).Scan(&own); err != nil {
+		return nil, err
+	}
+	effective, err := itemNegativeStockPolicy(ctx, q, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := map[string]any{
+		"item_id":          itemID,
+		"policy":           nil,
+		"effective_policy": effective,
+	}
+	if own.Valid {
+		out["policy"] = own.String
+	}
+	return out, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"stockmate/internal/notify"
+	"stockmate/internal/store"
+)
+
+// lowStockDigest mails the items at or below their reorder point that are
+// not snoozed. With nothing to report no mail is sent and sent is false.
+func lowStockDigest(ctx context.Context, st *store.Store, mailer *notify.Mailer) (sent bool, count int, err error) {
+	items, err := st.ListLowStock(ctx, false)
+	if err != nil {
+		return false, 0, err
+	}
+	if len(items) == 0 {
+		return false, 0, nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d items are at or below their reorder point (%s).\n\n", len(items), time.Now().Format("2006-01-02 15:04"))
+	for _, it := range items {
+		fmt.Fprintf(&b, "[%s] %s  %s\n    stock %s %s / reorder point %s\n",
+			strings.ToUpper(it.Severity), it.SKU, it.Name,
+			strconv.FormatFloat(it.StockQty, 'f', -1, 64), it.ManagedUnit,
+			strconv.FormatFloat(it.ReorderPoint, 'f', -1, 64))
+	}
+	subject := fmt.Sprintf("[stockmate] Low stock: %d items", len(items))
+	if err := mailer.Send(subject, b.String()); err != nil {
+		return false, len(items), err
+	}
+	return true, len(items), nil
+}
+
+func lowStockDigestJob(st *store.Store, mailer *notify.Mailer) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, _, err := lowStockDigest(ctx, st, mailer)
+		return err
+	}
+}
+
+// alertJobFailure mails a failed background job right away.
+func alertJobFailure(mailer *notify.Mailer) func(name string, err error) {
+	return func(name string, jobErr error) {
+		body := fmt.Sprintf("Job %q failed at %s:\n\n%v\n", name, time.Now().Format(time.RFC3339), jobErr)
+		if err := mailer.Send("[stockmate] Job failed: "+name, body); err != nil {
+			log.Printf("failed to send job failure alert: %v", err)
+		}
+	}
+}
+
+func notifyStatus(err error) int {
+	if errors.Is(err, notify.ErrNotConfigured) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// sendTestNotification checks the SMTP settings by mailing the recipients.
+func sendTestNotification(mailer *notify.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := mailer.Send("[stockmate] Test notification", "Email notifications are working.\n"); err != nil {
+			http.Error(w, err.Error(), notifyStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// sendLowStockDigest sends the daily digest now.
+func sendLowStockDigest(st *store.Store, mailer *notify.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !mailer.Enabled() {
+			http.Error(w, notify.ErrNotConfigured.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		sent, count, err := lowStockDigest(r.Context(), st, mailer)
+		if err != nil {
+			http.Error(w, err.Error(), notifyStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"sent":  sent,
+			"items": count,
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+	"stockmate/internal/validate"
+)
+
+type SupplierOffer struct {
+	ID           int64   `json:"id"`
+	ItemID       int64   `json:"item_id"`
+	SupplierID   int64   `json:"supplier_id"`
+	SupplierName string  `json:"supplier_name"`
+	SupplierSKU  string  `json:"supplier_sku,omitempty"`
+	Price        float64 `json:"price"`
+	Currency     string  `json:"currency"`
+	MOQ          float64 `json:"moq"`
+	// OrderMultiple is the pack size the supplier sells in, if any.
+	OrderMultiple *float64 `json:"order_multiple,omitempty"`
+	// LeadTimeDays is the offer's own lead time, or the supplier's, or the
+	// item's.
+	LeadTimeDays *int64        `json:"lead_time_days,omitempty"`
+	Preferred    bool          `json:"preferred"`
+	URL          string        `json:"url,omitempty"`
+	Note         string        `json:"note,omitempty"`
+	UpdatedAt    timeutil.Time `json:"updated_at"`
+	// rate converts Price to the base currency; invalid when it has no rate.
+	rate sql.NullFloat64
+	// itemMOQ and itemMultiple are the item's own purchase constraints,
+	// which apply on top of the offer's.
+	itemMOQ, itemMultiple sql.NullFloat64
+}
+
+// OfferQuote is an offer priced for a required quantity: the order is rounded
+// up to the MOQ and order multiple (see purchaseQty). TotalBase is Total in
+// the base currency, omitted when the offer's currency has no rate.
+type OfferQuote struct {
+	SupplierOffer
+	OrderQty  float64  `json:"order_qty"`
+	Total     float64  `json:"total"`
+	TotalBase *float64 `json:"total_base,omitempty"`
+}
+
+func loadOffers(ctx context.Context, q queryer, itemID int64) ([]SupplierOffer, error) {
+	rows, err := q.QueryContext(ctx, `
+SELECT
+  o.offer_id, o.item_id, o.supplier_id, s.name, o.supplier_sku, o.price, o.currency, o.moq, o.order_multiple,
+  COALESCE(o.lead_time_days, s.lead_time_days, i.lead_time_days), o.preferred, o.url, o.note, o.updated_at, cur.rate,
+  i.moq, i.order_multiple
+FROM supplier_offers o
+JOIN suppliers s ON s.supplier_id = o.supplier_id
+JOIN items i ON i.item_id = o.item_id
+LEFT JOIN currencies cur ON cur.code = o.currency
+WHERE o.item_id = ?
+ORDER BY o.preferred DESC, s.name COLLATE NOCASE
+`, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]SupplierOffer, 0)
+	for rows.Next() {
+		var o SupplierOffer
+		var leadTime sql.NullInt64
+		var multiple sql.NullFloat64
+		var preferred int
+		if err := rows.Scan(&o.ID, &o.ItemID, &o.SupplierID, &o.SupplierName, &o.SupplierSKU, &o.Price, &o.Currency, &o.MOQ, &multiple,
+			&leadTime, &preferred, &o.URL, &o.Note, &o.UpdatedAt, &o.rate, &o.itemMOQ, &o.itemMultiple); err != nil {
+			return nil, err
+		}
+		if multiple.Valid {
+			v := multiple.Float64
+			o.OrderMultiple = &v
+		}
+		if leadTime.Valid {
+			v := leadTime.Int64
+			o.LeadTimeDays = &v
+		}
+		o.Preferred = preferred != 0
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// rankOffers quotes every offer for qty and sorts the best source first.
+// by=cost compares totals only, by=lead_time prefers the fastest supplier,
+// and the default honours the preferred flag before cost. Costs are compared
+// in the base currency; offers without a rate sort after priced ones.
+func rankOffers(offers []SupplierOffer, qty float64, by string) []OfferQuote {
+	quotes := make([]OfferQuote, 0, len(offers))
+	for _, o := range offers {
+		multiple := o.itemMultiple.Float64
+		if o.OrderMultiple != nil {
+			multiple = *o.OrderMultiple
+		}
+		orderQty := purchaseQty(qty, math.Max(o.MOQ, o.itemMOQ.Float64), multiple)
+		q := OfferQuote{SupplierOffer: o, OrderQty: orderQty, Total: orderQty * o.Price}
+		if o.rate.Valid {
+			v := q.Total * o.rate.Float64
+			q.TotalBase = &v
+		}
+		quotes = append(quotes, q)
+	}
+	cost := func(q OfferQuote) float64 {
+		if q.TotalBase == nil {
+			return math.Inf(1)
+		}
+		return *q.TotalBase
+	}
+	leadTime := func(q OfferQuote) int64 {
+		if q.LeadTimeDays == nil {
+			return math.MaxInt64
+		}
+		return *q.LeadTimeDays
+	}
+	sort.SliceStable(quotes, func(i, j int) bool {
+		a, b := quotes[i], quotes[j]
+		switch by {
+		case "lead_time":
+			if leadTime(a) != leadTime(b) {
+				return leadTime(a) < leadTime(b)
+			}
+		case "cost":
+		default:
+			if a.Preferred != b.Preferred {
+				return a.Preferred
+			}
+		}
+		if cost(a) != cost(b) {
+			return cost(a) < cost(b)
+		}
+		return leadTime(a) < leadTime(b)
+	})
+	return quotes
+}
+
+// purchaseQty rounds need up to moq and then to a whole number of
+// multiples. Zero moq or multiple means no constraint.
+func purchaseQty(need, moq, multiple float64) float64 {
+	q := math.Max(need, moq)
+	if multiple > 0 {
+		// The tolerance keeps exact multiples from rounding up on float noise.
+		q = math.Ceil(q/multiple-1e-9) * multiple
+	}
+	return q
+}
+
+func itemOfferParams(r *http.Request) (int64, int64, string) {
+	itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || itemID <= 0 {
+		return 0, 0, "invalid id"
+	}
+	supplierID := int64(0)
+	if v := chi.URLParam(r, "supplierID"); v != "" {
+		supplierID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || supplierID <= 0 {
+			return 0, 0, "invalid supplier id"
+		}
+	}
+	return itemID, supplierID, ""
+}
+
+func listItemOffers(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, _, problem := itemOfferParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		offers, err := loadOffers(r.Context(), dbx, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(offers)
+	}
+}
+
+// upsertItemOffer creates or replaces the offer of one supplier for a
+// component. Marking it preferred clears the flag on the item's other offers.
+func upsertItemOffer(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		SupplierSKU string   `json:"supplier_sku"`
+		Price       *float64 `json:"price"`
+		Currency    string   `json:"currency"`
+		MOQ         *float64 `json:"moq"`
+		// OrderMultiple is optional; omitted or 0 means any quantity.
+		OrderMultiple *float64 `json:"order_multiple"`
+		LeadTimeDays  *int64   `json:"lead_time_days"`
+		Preferred     bool     `json:"preferred"`
+		URL           string   `json:"url"`
+		Note          string   `json:"note"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, supplierID, problem := itemOfferParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		var errs validate.Errors
+		errs.Check(req.Price != nil && *req.Price >= 0, "price", "must be >= 0")
+		moq := 1.0
+		if req.MOQ != nil {
+			moq = *req.MOQ
+		}
+		errs.Check(moq > 0, "moq", "must be > 0")
+		errs.Check(req.OrderMultiple == nil || *req.OrderMultiple >= 0, "order_multiple", "must be >= 0")
+		var multiple any
+		if req.OrderMultiple != nil && *req.OrderMultiple > 0 {
+			multiple = *req.OrderMultiple
+		}
+		errs.Check(req.LeadTimeDays == nil || *req.LeadTimeDays >= 0, "lead_time_days", "must be >= 0")
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if strings.TrimSpace(req.Currency) == "" {
+			if req.Currency, err = baseCurrency(r.Context(), tx); err != nil {
+				http.Error(w, "failed to load base currency", http.StatusInternalServerError)
+				return
+			}
+		}
+		currency, problem, err := resolveCurrency(r.Context(), tx, req.Currency)
+		if err != nil {
+			http.Error(w, "failed to load currency", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			errs.Add("currency", problem)
+		}
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		var itemType, lifecycle string
+		if err := tx.QueryRowContext(r.Context(), `SELECT item_type, lifecycle_status FROM items WHERE item_id = ?`, itemID).Scan(&itemType, &lifecycle); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "item not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if itemType != "component" {
+			http.Error(w, "item must be component", http.StatusBadRequest)
+			return
+		}
+		if lifecycle == lifecycleObsolete {
+			http.Error(w, "item is obsolete", http.StatusConflict)
+			return
+		}
+		var n int
+		if err := tx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM suppliers WHERE supplier_id = ?`, supplierID).Scan(&n); err != nil {
+			http.Error(w, "failed to load supplier", http.StatusInternalServerError)
+			return
+		}
+		if n == 0 {
+			http.Error(w, "supplier not found", http.StatusNotFound)
+			return
+		}
+
+		preferred := 0
+		if req.Preferred {
+			preferred = 1
+			if _, err := tx.ExecContext(r.Context(), `UPDATE supplier_offers SET preferred = 0 WHERE item_id = ? AND supplier_id <> ?`, itemID, supplierID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO supplier_offers(item_id, supplier_id, supplier_sku, price, currency, moq, order_multiple, lead_time_days, preferred, url, note)
+VALUES(?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(item_id, supplier_id) DO UPDATE SET
+  supplier_sku = excluded.supplier_sku,
+  price = excluded.price,
+  currency = excluded.currency,
+  moq = excluded.moq,
+  order_multiple = excluded.order_multiple,
+  lead_time_days = excluded.lead_time_days,
+  preferred = excluded.preferred,
+  url = excluded.url,
+  note = excluded.note,
+  updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+`, itemID, supplierID, strings.TrimSpace(req.SupplierSKU), *req.Price, currency, moq, multiple, req.LeadTimeDays,
+			preferred, strings.TrimSpace(req.URL), strings.TrimSpace(req.Note)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		offers, err := loadOffers(r.Context(), tx, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(offers)
+	}
+}
+
+func deleteItemOffer(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, supplierID, problem := itemOfferParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		res, err := store.Exec(r.Context(), dbx, `DELETE FROM supplier_offers WHERE item_id = ? AND supplier_id = ?`, itemID, supplierID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "offer not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getBestOffer picks the source for buying ?qty= of the component.
+func getBestOffer(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, _, problem := itemOfferParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		qty := 1.0
+		if v := strings.TrimSpace(r.URL.Query().Get("qty")); v != "" {
+			var err error
+			qty, err = strconv.ParseFloat(v, 64)
+			if err != nil || qty <= 0 {
+				http.Error(w, "qty must be > 0", http.StatusBadRequest)
+				return
+			}
+		}
+		by := strings.TrimSpace(r.URL.Query().Get("by"))
+		if by != "" && by != "preferred" && by != "cost" && by != "lead_time" {
+			http.Error(w, "by must be preferred, cost or lead_time", http.StatusBadRequest)
+			return
+		}
+
+		offers, err := loadOffers(r.Context(), dbx, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		quotes := rankOffers(offers, qty, by)
+		out := map[string]any{
+			"item_id":    itemID,
+			"qty":        qty,
+			"best":       nil,
+			"candidates": quotes,
+		}
+		if len(quotes) > 0 {
+			out["best"] = quotes[0]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+// OrderDeadLetter is an order line the webhook could not book.
+type OrderDeadLetter struct {
+	ID         int64         `json:"id"`
+	Source     string        `json:"source"`
+	OrderID    string        `json:"order_id"`
+	SKU        string        `json:"sku"`
+	Qty        float64       `json:"qty"`
+	Reason     string        `json:"reason"`
+	ResolvedAt timeutil.Time `json:"resolved_at"`
+	Resolution string        `json:"resolution,omitempty"`
+	CreatedAt  timeutil.Time `json:"created_at"`
+}
+
+// orderPayload is the part of an order-created payload that is read. It
+// matches Shopify's order webhook; other senders post the same shape.
+type orderPayload struct {
+	ID        json.RawMessage `json:"id"`
+	LineItems []struct {
+		SKU      string  `json:"sku"`
+		Quantity float64 `json:"quantity"`
+	} `json:"line_items"`
+}
+
+// verifyOrderSignature checks the HMAC-SHA256 of body, sent base64 encoded
+// in X-Shopify-Hmac-Sha256 or hex encoded in X-Signature-SHA256. source names
+// the sender for the order reference.
+func verifyOrderSignature(secret string, h http.Header, body []byte) (source string, ok bool) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	sum := mac.Sum(nil)
+	if v := h.Get("X-Shopify-Hmac-Sha256"); v != "" {
+		got, err := base64.StdEncoding.DecodeString(v)
+		return "shopify", err == nil && hmac.Equal(got, sum)
+	}
+	if v := h.Get("X-Signature-SHA256"); v != "" {
+		got, err := hex.DecodeString(strings.TrimPrefix(v, "sha256="))
+		return "webhook", err == nil && hmac.Equal(got, sum)
+	}
+	return "", false
+}
+
+// resolveOrderSKU finds the assembly an order line's SKU maps to. problem
+// explains why the line cannot be booked.
+func resolveOrderSKU(ctx context.Context, q queryer, sku string) (int64, string, error) {
+	if sku == "" {
+		return 0, "line has no sku", nil
+	}
+	var itemID int64
+	var itemType string
+	err := q.QueryRowContext(ctx, `SELECT item_id, item_type FROM items WHERE sku = ?`, sku).Scan(&itemID, &itemType)
+	if err == sql.ErrNoRows {
+		return 0, "unmapped sku", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if itemType != "assembly" {
+		return 0, "item must be assembly", nil
+	}
+	return itemID, "", nil
+}
+
+func deadLetterOrderLine(ctx context.Context, tx *sql.Tx, source, orderID, sku string, qty float64, reason string, payload []byte) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO order_dead_letters(source, order_id, sku, qty, reason, payload)
+VALUES(?,?,?,?,?,?)
+ON CONFLICT(source, order_id, sku) DO NOTHING
+`, source, orderID, sku, qty, reason, string(payload))
+	return err
+}
+
+// receiveOrderWebhook books a signed order-created payload as a shipment of
+// the ordered assemblies, found by SKU. Lines that cannot be booked go to the
+// dead-letter log; when the whole order is blocked (e.g. by the negative
+// stock policy) every line does. A redelivered order is acknowledged without
+// booking it again.
+func receiveOrderWebhook(dbx *sql.DB, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if secret == "" {
+			http.Error(w, "order webhook is not configured", http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		source, ok := verifyOrderSignature(secret, r.Header, body)
+		if !ok {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		if topic := r.Header.Get("X-Shopify-Topic"); topic != "" && topic != "orders/create" {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"ignored": true})
+			return
+		}
+
+		var order orderPayload
+		if err := json.Unmarshal(body, &order); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		orderID := strings.Trim(string(order.ID), `"`)
+		if orderID == "" || orderID == "null" {
+			http.Error(w, "order id is required", http.StatusBadRequest)
+			return
+		}
+		orderRef := source + ":" + orderID
+
+		lines := make(map[string]float64)
+		orderSKUs := make([]string, 0)
+		for _, li := range order.LineItems {
+			if li.Quantity <= 0 {
+				continue
+			}
+			sku := strings.TrimSpace(li.SKU)
+			if _, seen := lines[sku]; !seen {
+				orderSKUs = append(orderSKUs, sku)
+			}
+			lines[sku] += li.Quantity
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var seen int
+		if err := tx.QueryRowContext(r.Context(), `
+SELECT
+  (SELECT COUNT(1) FROM stock_transactions WHERE ref_type = ? AND ref_id = ?) +
+  (SELECT COUNT(1) FROM order_dead_letters WHERE source = ? AND order_id = ?)
+`, refShipment, orderRef, source, orderID).Scan(&seen); err != nil {
+			http.Error(w, "failed to check order", http.StatusInternalServerError)
+			return
+		}
+		if seen > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"ref_id": orderRef, "duplicate": true})
+			return
+		}
+
+		shipped := make(map[int64]float64)
+		shippedSKUs := make([]string, 0)
+		deadLettered := 0
+		for _, sku := range orderSKUs {
+			itemID, problem, err := resolveOrderSKU(r.Context(), tx, sku)
+			if err != nil {
+				http.Error(w, "failed to load item", http.StatusInternalServerError)
+				return
+			}
+			if problem != "" {
+				if err := deadLetterOrderLine(r.Context(), tx, source, orderID, sku, lines[sku], problem, body); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				deadLettered++
+				continue
+			}
+			shipped[itemID] += lines[sku]
+			shippedSKUs = append(shippedSKUs, sku)
+		}
+
+		warnings := make([]string, 0)
+		if len(shipped) > 0 {
+			deductions, problem, err := shipmentDeductions(r.Context(), tx, shipped)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if problem == "" {
+				ref := txnRef{Type: refShipment, ID: orderRef}
+				warnings, problem, err = bookShipment(r.Context(), tx, deductions, &ref)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			if problem != "" {
+				warnings = make([]string, 0)
+				for _, sku := range shippedSKUs {
+					if err := deadLetterOrderLine(r.Context(), tx, source, orderID, sku, lines[sku], problem, body); err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+				}
+				deadLettered += len(shippedSKUs)
+				shippedSKUs = shippedSKUs[:0]
+			}
+		}
+
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ref_id":        orderRef,
+			"booked":        len(shippedSKUs),
+			"dead_lettered": deadLettered,
+			"warnings":      warnings,
+		})
+	}
+}
+
+// listOrderDeadLetters lists ?status=open (default), resolved or all.
+func listOrderDeadLetters(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		where := "resolved_at IS NULL"
+		switch r.URL.Query().Get("status") {
+		case "", "open":
+		case "resolved":
+			where = "resolved_at IS NOT NULL"
+		case "all":
+			where = "1=1"
+		default:
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+		rows, err := dbx.QueryContext(r.Context(), `
+SELECT dead_letter_id, source, order_id, sku, qty, reason, resolved_at, resolution, created_at
+FROM order_dead_letters
+WHERE `+where+`
+ORDER BY dead_letter_id DESC
+`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]OrderDeadLetter, 0)
+		for rows.Next() {
+			var d OrderDeadLetter
+			var resolution sql.NullString
+			if err := rows.Scan(&d.ID, &d.Source, &d.OrderID, &d.SKU, &d.Qty, &d.Reason, &d.ResolvedAt, &resolution, &d.CreatedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			d.Resolution = resolution.String
+			out = append(out, d)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// loadOpenDeadLetter loads an unresolved dead letter; problem and status say
+// why it cannot be acted on.
+func loadOpenDeadLetter(ctx context.Context, q queryer, id int64) (OrderDeadLetter, string, int, error) {
+	var d OrderDeadLetter
+	err := q.QueryRowContext(ctx, `
+SELECT dead_letter_id, source, order_id, sku, qty, reason, resolved_at
+FROM order_dead_letters WHERE dead_letter_id = ?
+`, id).Scan(&d.ID, &d.Source, &d.OrderID, &d.SKU, &d.Qty, &d.Reason, &d.ResolvedAt)
+	if err == sql.ErrNoRows {
+		return d, "dead letter not found", http.StatusNotFound, nil
+	}
+	if err != nil {
+		return d, "", 0, err
+	}
+	if !d.ResolvedAt.IsZero() {
+		return d, "dead letter is already resolved", http.StatusConflict, nil
+	}
+	return d, "", 0, nil
+}
+
+func deadLetterID(r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// retryOrderDeadLetter books a dead-lettered line under its order's
+// reference. item_id maps the line explicitly, e.g. when the SKU differs
+// from the shop's; otherwise the SKU is looked up again.
+func retryOrderDeadLetter(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		ItemID int64 `json:"item_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := deadLetterID(r)
+		if !ok {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "bad json", http.StatusBadRequest)
+				return
+			}
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		d, problem, status, err := loadOpenDeadLetter(r.Context(), tx, id)
+		if err != nil {
+			http.Error(w, "failed to load dead letter", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+
+		itemID := req.ItemID
+		if itemID <= 0 {
+			itemID, problem, err = resolveOrderSKU(r.Context(), tx, d.SKU)
+			if err != nil {
+				http.Error(w, "failed to load item", http.StatusInternalServerError)
+				return
+			}
+			if problem != "" {
+				http.Error(w, problem, http.StatusBadRequest)
+				return
+			}
+		}
+		deductions, problem, err := shipmentDeductions(r.Context(), tx, map[int64]float64{itemID: d.Qty})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		ref := txnRef{Type: refShipment, ID: d.Source + ":" + d.OrderID}
+		warnings, problem, err := bookShipment(r.Context(), tx, deductions, &ref)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+UPDATE order_dead_letters SET resolved_at = strftime('%Y-%m-%dT%H:%M:%SZ','now'), resolution = 'booked'
+WHERE dead_letter_id = ?
+`, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"item_id":  itemID,
+			"ref_id":   ref.ID,
+			"warnings": warnings,
+		})
+	}
+}
+
+// dismissOrderDeadLetter closes a line that will not be booked.
+func dismissOrderDeadLetter(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := deadLetterID(r)
+		if !ok {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		_, problem, status, err := loadOpenDeadLetter(r.Context(), dbx, id)
+		if err != nil {
+			http.Error(w, "failed to load dead letter", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		if _, err := store.Exec(r.Context(), dbx, `
+UPDATE order_dead_letters SET resolved_at = strftime('%Y-%m-%dT%H:%M:%SZ','now'), resolution = 'dismissed'
+WHERE dead_letter_id = ? AND resolved_at IS NULL
+`, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
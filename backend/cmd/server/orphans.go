@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"stockmate/internal/store"
+)
+
+// OrphanReport counts rows left behind by changes the schema cannot guard
+// against: an item_type changed after its detail row was made, or rows
+// written with foreign keys off (old imports, manual fixes).
+type OrphanReport struct {
+	// AssemblyRows and ComponentRows are detail rows whose item is missing
+	// or no longer of that type.
+	AssemblyRows  int64 `json:"assembly_rows"`
+	ComponentRows int64 `json:"component_rows"`
+	// BOMLinesMissingItem are BOM lines whose component item is gone.
+	BOMLinesMissingItem int64 `json:"bom_lines_missing_item"`
+	// BOMLinesObsoleteItem are lines of revisions still in use that name an
+	// obsolete item. They are reported only: the fix is a new revision, as
+	// history must not change.
+	BOMLinesObsoleteItem int64 `json:"bom_lines_obsolete_item"`
+	// PurchaseLinks belong to a missing component or to one of
+	// ComponentRows, trashed ones included.
+	PurchaseLinks int64 `json:"purchase_links"`
+	// Cleaned is set by POST, which removes everything but
+	// BOMLinesObsoleteItem; the counts are from before.
+	Cleaned bool `json:"cleaned"`
+}
+
+// Each orphan kind is a FROM ... WHERE clause, counted with COUNT(1) and
+// removed by deleting its rowids.
+const (
+	orphanAssemblies = `
+FROM assemblies a
+WHERE NOT EXISTS (SELECT 1 FROM items i WHERE i.item_id = a.item_id AND i.item_type = 'assembly')`
+	orphanComponents = `
+FROM components c
+WHERE NOT EXISTS (SELECT 1 FROM items i WHERE i.item_id = c.item_id AND i.item_type = 'component')`
+	orphanBOMLines = `
+FROM assembly_components ac
+WHERE NOT EXISTS (SELECT 1 FROM items i WHERE i.item_id = ac.component_item_id)`
+	obsoleteBOMLines = `
+FROM assembly_components ac
+JOIN assembly_records ar ON ar.record_id = ac.record_id
+JOIN items i ON i.item_id = ac.component_item_id
+WHERE ar.obsolete_at IS NULL AND i.lifecycle_status = 'obsolete'`
+	orphanPurchaseLinks = `
+FROM component_purchase_links l
+WHERE NOT EXISTS (
+  SELECT 1 FROM components c JOIN items i ON i.item_id = c.item_id
+  WHERE c.component_id = l.component_id AND i.item_type = 'component'
+)`
+)
+
+func loadOrphanReport(ctx context.Context, q queryer) (OrphanReport, error) {
+	var rep OrphanReport
+	for _, c := range []struct {
+		from string
+		n    *int64
+	}{
+		{orphanAssemblies, &rep.AssemblyRows},
+		{orphanComponents, &rep.ComponentRows},
+		{orphanBOMLines, &rep.BOMLinesMissingItem},
+		{obsoleteBOMLines, &rep.BOMLinesObsoleteItem},
+		{orphanPurchaseLinks, &rep.PurchaseLinks},
+	} {
+		if err := q.QueryRowContext(ctx, `SELECT COUNT(1) `+c.from).Scan(c.n); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// getOrphans reports the orphaned rows without touching them.
+func getOrphans(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rep, err := loadOrphanReport(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rep)
+	}
+}
+
+// cleanOrphans removes the orphaned rows in one transaction and reports
+// what there was. Purchase links go before their component rows.
+func cleanOrphans(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		tx, err := dbx.BeginTx(ctx, nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		rep, err := loadOrphanReport(ctx, tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, del := range []string{
+			`DELETE FROM component_purchase_links WHERE id IN (SELECT l.id ` + orphanPurchaseLinks + `)`,
+			`DELETE FROM components WHERE component_id IN (SELECT c.component_id ` + orphanComponents + `)`,
+			`DELETE FROM assemblies WHERE assembly_id IN (SELECT a.assembly_id ` + orphanAssemblies + `)`,
+			`DELETE FROM assembly_components WHERE rowid IN (SELECT ac.rowid ` + orphanBOMLines + `)`,
+		} {
+			if _, err := tx.ExecContext(ctx, del); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := store.Commit(ctx, tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		rep.Cleaned = true
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rep)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"stockmate/internal/notify"
+	"stockmate/internal/store"
+)
+
+const (
+	outboxBatch       = 100
+	outboxMaxAttempts = 10
+	outboxMaxBackoff  = 6 * time.Hour
+	outboxKeep        = 7 * 24 * time.Hour
+)
+
+// outboxChange is the data of a recorded change: the event the stream
+// publishes for it, with who made it and in which request.
+type outboxChange struct {
+	Type      string `json:"type"`
+	Action    string `json:"action"`
+	ItemID    *int64 `json:"item_id,omitempty"`
+	RequestID any    `json:"request_id"`
+	Actor     any    `json:"actor"`
+}
+
+// recordChanges has a mutating request listed in eventRoutes record its
+// change in the outbox, in the same transaction as the change itself. It
+// runs ahead of routing, so the route is looked up here.
+func recordChanges(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions || store.IsDryRun(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rctx := chi.RouteContext(r.Context())
+		if rctx == nil || rctx.Routes == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tctx := chi.NewRouteContext()
+		route, ok := eventRoutes[r.Method+" "+rctx.Routes.Find(tctx, r.Method, r.URL.Path)]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		change := outboxChange{
+			Type:      route.typ,
+			Action:    route.action,
+			RequestID: requestIDArg(r.Context()),
+			Actor:     actorArg(r.Context()),
+		}
+		if route.itemParam {
+			if id, err := strconv.ParseInt(tctx.URLParam("id"), 10, 64); err == nil {
+				change.ItemID = &id
+			}
+		}
+		payload, err := json.Marshal(change)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctx := store.WithOutbox(r.Context(), change.Type+"."+change.Action, payload)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// outboxBackoff is the wait after a message's nth failed attempt: 30s,
+// doubling up to outboxMaxBackoff.
+func outboxBackoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, outboxMaxBackoff)
+}
+
+// outboxDispatchJob posts due outbox messages to the webhook, oldest first.
+// A failed message is retried with backoff and given up on after
+// outboxMaxAttempts, which fails the job so the failure is reported.
+// Delivery is at least once: the receiver drops repeats by X-Delivery-ID.
+func outboxDispatchJob(st *store.Store, webhook *notify.Webhook) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var gaveUp []string
+		for {
+			msgs, err := st.DueOutbox(ctx, time.Now(), outboxBatch)
+			if err != nil {
+				return err
+			}
+			for _, m := range msgs {
+				body, err := json.Marshal(struct {
+					ID        int64           `json:"id"`
+					Topic     string          `json:"topic"`
+					CreatedAt string          `json:"created_at"`
+					Data      json.RawMessage `json:"data"`
+				}{m.ID, m.Topic, m.CreatedAt, m.Payload})
+				if err != nil {
+					return err
+				}
+				postErr := webhook.Post(ctx, strconv.FormatInt(m.ID, 10), body)
+				if postErr == nil {
+					if err := st.OutboxDelivered(ctx, m.ID); err != nil {
+						return err
+					}
+					continue
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				attempts := m.Attempts + 1
+				giveUp := attempts >= outboxMaxAttempts
+				if err := st.OutboxFailed(ctx, m.ID, postErr.Error(), time.Now().Add(outboxBackoff(attempts)), giveUp); err != nil {
+					return err
+				}
+				if giveUp {
+					gaveUp = append(gaveUp, fmt.Sprintf("message %d (%s): %v", m.ID, m.Topic, postErr))
+				}
+			}
+			if len(msgs) < outboxBatch {
+				break
+			}
+		}
+
+		n, err := st.PruneOutbox(ctx, time.Now().Add(-outboxKeep))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("outbox: %d delivered messages pruned", n)
+		}
+		if len(gaveUp) > 0 {
+			return fmt.Errorf("outbox: gave up after %d attempts on %s", outboxMaxAttempts, strings.Join(gaveUp, "; "))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// itemPackQty returns how many managed units one pack (box, reel, ...) of the
+// item holds, or 0 when the item has no pack_qty.
+func itemPackQty(ctx context.Context, q queryer, itemID int64) (float64, error) {
+	var v sql.NullFloat64
+	if err := q.QueryRowContext(ctx, `SELECT pack_qty FROM items WHERE item_id = ?`, itemID).Scan(&v); err != nil {
+		return 0, err
+	}
+	if !v.Valid || v.Float64 <= 0 {
+		return 0, nil
+	}
+	return v.Float64, nil
+}
+
+// stockPacks expresses stockQty in packs; a partly used pack shows as a
+// fraction. It is nil for items without a pack_qty.
+func stockPacks(stockQty float64, packQty *float64) *float64 {
+	if packQty == nil || *packQty <= 0 {
+		return nil
+	}
+	v := stockQty / *packQty
+	return &v
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// maxPhantomDepth bounds nested phantoms; cycles are also rejected on save.
+const maxPhantomDepth = 16
+
+// bomNeed is one component line after phantoms have been blown through.
+// Lines of a phantom carry the phantom's record and SKU.
+type bomNeed struct {
+	RecordID    int64
+	ItemID      int64
+	QtyPerUnit  float64
+	ScrapFactor float64
+	Refs        string
+	// Gross is the quantity per unit of the top parent, losses included.
+	Gross float64
+	Via   string
+}
+
+// explodeBOMRecord lists the component needs of one revision per unit of its
+// parent. Phantom assemblies on it are replaced by the lines of their revision
+// in effect at asOf, scaled by the phantom line's gross quantity. A non-empty
+// problem means a phantom cannot be exploded (no BOM, nested too deep).
+func explodeBOMRecord(ctx context.Context, q queryer, recordID int64, yield float64, asOf string, depth int) ([]bomNeed, string, error) {
+	if depth > maxPhantomDepth {
+		return nil, "phantom assemblies are nested too deep", nil
+	}
+	type line struct {
+		need    bomNeed
+		sku     string
+		phantom bool
+	}
+	rows, err := q.QueryContext(ctx, `
+SELECT ac.component_item_id, i.sku, ac.qty_per_unit, ac.scrap_factor, COALESCE(ac.refs, ''), COALESCE(a.phantom, 0)
+FROM assembly_components ac
+JOIN items i ON i.item_id = ac.component_item_id
+LEFT JOIN assemblies a ON a.item_id = ac.component_item_id
+WHERE ac.record_id = ?
+ORDER BY ac.position, i.sku
+`, recordID)
+	if err != nil {
+		return nil, "", err
+	}
+	lines := make([]line, 0)
+	for rows.Next() {
+		l := line{need: bomNeed{RecordID: recordID}}
+		var phantom int
+		if err := rows.Scan(&l.need.ItemID, &l.sku, &l.need.QtyPerUnit, &l.need.ScrapFactor, &l.need.Refs, &phantom); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+		l.need.Gross = grossQtyPerUnit(l.need.QtyPerUnit, l.need.ScrapFactor, yield)
+		l.phantom = phantom != 0
+		lines = append(lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, "", err
+	}
+	rows.Close()
+
+	out := make([]bomNeed, 0, len(lines))
+	for _, l := range lines {
+		if !l.phantom {
+			out = append(out, l.need)
+			continue
+		}
+		childRecordID, _, childYield, err := effectiveBOMRevision(ctx, q, l.need.ItemID, asOf)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Sprintf("phantom %s has no bom revision", l.sku), nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		children, problem, err := explodeBOMRecord(ctx, q, childRecordID, childYield, asOf, depth+1)
+		if err != nil || problem != "" {
+			return nil, problem, err
+		}
+		for _, c := range children {
+			c.QtyPerUnit *= l.need.QtyPerUnit
+			c.Gross *= l.need.Gross
+			if c.Via == "" {
+				c.Via = l.sku
+			} else {
+				c.Via = l.sku + " > " + c.Via
+			}
+			out = append(out, c)
+		}
+	}
+	return out, "", nil
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+)
+
+type PicklistLine struct {
+	ItemID        int64  `json:"item_id"`
+	SKU           string `json:"sku"`
+	Name          string `json:"name"`
+	ComponentType string `json:"component_type,omitempty"`
+	ManagedUnit   string `json:"managed_unit"`
+	// Bin is the item's default bin, where it is picked from.
+	Bin         string  `json:"bin,omitempty"`
+	QtyPerUnit  float64 `json:"qty_per_unit"`
+	ScrapFactor float64 `json:"scrap_factor,omitempty"`
+	Refs        string  `json:"refs,omitempty"`
+	// Via is the phantom assembly the line was exploded from.
+	Via string `json:"via,omitempty"`
+	// RequiredQty includes scrap and yield losses.
+	RequiredQty float64  `json:"required_qty"`
+	PackQty     *float64 `json:"pack_qty,omitempty"`
+	Packs       *float64 `json:"packs,omitempty"`
+	// PickQty is RequiredQty rounded up to whole packs when pack_qty is set.
+	PickQty      float64 `json:"pick_qty"`
+	StockManaged bool    `json:"stock_managed"`
+	StockQty     float64 `json:"stock_qty"`
+	Short        bool    `json:"short"`
+	// Substitutes cover what the line's own stock cannot.
+	Substitutes []PicklistSubstitute `json:"substitutes,omitempty"`
+
+	recordID int64
+}
+
+type PicklistSubstitute struct {
+	ItemID      int64   `json:"item_id"`
+	SKU         string  `json:"sku"`
+	Name        string  `json:"name"`
+	ManagedUnit string  `json:"managed_unit"`
+	Bin         string  `json:"bin,omitempty"`
+	Priority    int64   `json:"priority"`
+	PickQty     float64 `json:"pick_qty"`
+	StockQty    float64 `json:"stock_qty"`
+}
+
+type Picklist struct {
+	AssemblyID int64          `json:"assembly_id"`
+	SKU        string         `json:"sku"`
+	Name       string         `json:"name"`
+	RevNo      int64          `json:"rev_no"`
+	Yield      float64        `json:"yield"`
+	Qty        float64        `json:"qty"`
+	Lines      []PicklistLine `json:"lines"`
+
+	recordID int64
+}
+
+// buildPicklist lists the components needed to build qty of the assembly from
+// its BOM revision in effect at asOf. A non-empty problem means the request
+// cannot be served (unknown item, not an assembly, no BOM).
+func buildPicklist(ctx context.Context, q queryer, assemblyID int64, qty float64, asOf string) (*Picklist, string, error) {
+	pl := &Picklist{AssemblyID: assemblyID, Qty: qty, Lines: make([]PicklistLine, 0)}
+	var itemType string
+	if err := q.QueryRowContext(ctx, `SELECT sku, name, item_type FROM items WHERE item_id = ?`, assemblyID).Scan(&pl.SKU, &pl.Name, &itemType); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "item not found", nil
+		}
+		return nil, "", err
+	}
+	if itemType != "assembly" {
+		return nil, "item must be assembly", nil
+	}
+	recordID, revNo, yield, err := effectiveBOMRevision(ctx, q, assemblyID, asOf)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "bom revision not found", nil
+		}
+		return nil, "", err
+	}
+
+	pl.recordID, pl.RevNo, pl.Yield = recordID, revNo, yield
+
+	needs, problem, err := explodeBOMRecord(ctx, q, recordID, pl.Yield, asOf, 0)
+	if err != nil || problem != "" {
+		return nil, problem, err
+	}
+	byItem := make(map[int64]int, len(needs))
+	for _, n := range needs {
+		if i, ok := byItem[n.ItemID]; ok {
+			// The same item directly and through a phantom is picked once.
+			l := &pl.Lines[i]
+			l.QtyPerUnit += n.QtyPerUnit
+			l.RequiredQty += n.Gross * qty
+			if l.Via != n.Via {
+				l.Via = ""
+			}
+			if n.Refs != "" {
+				l.Refs = normalizeRefs(l.Refs + "," + n.Refs)
+			}
+			continue
+		}
+		l := PicklistLine{ItemID: n.ItemID, QtyPerUnit: n.QtyPerUnit, ScrapFactor: n.ScrapFactor, Refs: n.Refs, Via: n.Via, RequiredQty: n.Gross * qty, recordID: n.RecordID}
+		var componentType, bin sql.NullString
+		var packQty sql.NullFloat64
+		var sm int
+		if err := q.QueryRowContext(ctx, `
+SELECT
+  i.sku,
+  i.name,
+  c.component_type,
+  i.managed_unit,
+  `+defaultBinSelect+`,
+  i.pack_qty,
+  i.stock_managed,
+  COALESCE((
+    SELECT SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END)
+    FROM stock_transactions st
+    WHERE st.item_id = i.item_id
+  ), 0) AS stock_qty
+FROM items i
+LEFT JOIN components c ON c.item_id = i.item_id
+WHERE i.item_id = ?
+`, n.ItemID).Scan(&l.SKU, &l.Name, &componentType, &l.ManagedUnit, &bin, &packQty, &sm, &l.StockQty); err != nil {
+			return nil, "", err
+		}
+		l.ComponentType = componentType.String
+		l.Bin = bin.String
+		l.StockManaged = sm != 0
+		if packQty.Valid && packQty.Float64 > 0 {
+			pq := packQty.Float64
+			l.PackQty = &pq
+		}
+		byItem[n.ItemID] = len(pl.Lines)
+		pl.Lines = append(pl.Lines, l)
+	}
+	sort.Slice(pl.Lines, func(i, j int) bool { return pl.Lines[i].SKU < pl.Lines[j].SKU })
+	for i := range pl.Lines {
+		l := &pl.Lines[i]
+		l.PickQty = l.RequiredQty
+		if l.PackQty != nil {
+			packs := math.Ceil(l.RequiredQty / *l.PackQty - 1e-9)
+			l.Packs = &packs
+			l.PickQty = packs * *l.PackQty
+		}
+		l.Short = l.StockManaged && l.StockQty < l.PickQty
+	}
+
+	if err := applySubstitutes(ctx, q, pl); err != nil {
+		return nil, "", err
+	}
+	return pl, "", nil
+}
+
+// applySubstitutes moves the shortfall of short lines onto their alternates,
+// in priority order, as far as the alternates' stock allows. Stock taken by
+// another line of the same pick list is not offered twice.
+func applySubstitutes(ctx context.Context, q queryer, pl *Picklist) error {
+	alts := make(map[int64]map[int64][]BOMAlternate)
+	for _, l := range pl.Lines {
+		if _, ok := alts[l.recordID]; ok {
+			continue
+		}
+		m, err := loadBOMAlternates(ctx, q, l.recordID)
+		if err != nil {
+			return err
+		}
+		alts[l.recordID] = m
+	}
+	used := make(map[int64]float64)
+	for _, l := range pl.Lines {
+		if l.StockManaged {
+			used[l.ItemID] += min(l.PickQty, max(l.StockQty, 0))
+		}
+	}
+
+	for i := range pl.Lines {
+		l := &pl.Lines[i]
+		lineAlts := alts[l.recordID][l.ItemID]
+		if !l.Short || len(lineAlts) == 0 {
+			continue
+		}
+		remaining := l.RequiredQty - max(l.StockQty, 0)
+		for _, a := range lineAlts {
+			if remaining <= 1e-9 {
+				break
+			}
+			var unit string
+			var bin sql.NullString
+			var sm int
+			var stock float64
+			if err := q.QueryRowContext(ctx, `
+SELECT
+  i.managed_unit,
+  `+defaultBinSelect+`,
+  i.stock_managed,
+  COALESCE((
+    SELECT SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END)
+    FROM stock_transactions st
+    WHERE st.item_id = i.item_id
+  ), 0)
+FROM items i
+WHERE i.item_id = ?
+`, a.ItemID).Scan(&unit, &bin, &sm, &stock); err != nil {
+				return err
+			}
+			if sm == 0 {
+				continue
+			}
+			take := min(remaining, stock-used[a.ItemID])
+			if take <= 1e-9 {
+				continue
+			}
+			used[a.ItemID] += take
+			remaining -= take
+			l.Substitutes = append(l.Substitutes, PicklistSubstitute{
+				ItemID:      a.ItemID,
+				SKU:         a.SKU,
+				Name:        a.Name,
+				ManagedUnit: unit,
+				Bin:         bin.String,
+				Priority:    a.Priority,
+				PickQty:     take,
+				StockQty:    stock,
+			})
+		}
+		if len(l.Substitutes) == 0 {
+			continue
+		}
+		// Substituted lines pick exact quantities rather than whole packs.
+		l.Packs = nil
+		l.PickQty = l.RequiredQty
+		for _, sub := range l.Substitutes {
+			l.PickQty -= sub.PickQty
+		}
+		l.Short = l.StockQty < l.PickQty-1e-9
+	}
+	return nil
+}
+
+var picklistTemplate = template.Must(template.New("picklist").Parse(`<!doctype html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<title>Pick list {{.SKU}} x {{.Qty}}</title>
+<style>
+body { font-family: sans-serif; font-size: 12px; margin: 16px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #999; padding: 4px 6px; text-align: left; }
+td.num { text-align: right; }
+tr.short td { background: #fde2e2; }
+tr.sub td { color: #555; }
+.check { width: 32px; }
+</style>
+</head>
+<body>
+<h1>{{.SKU}} {{.Name}}</h1>
+<p>Qty: {{.Qty}} / BOM rev {{.RevNo}}</p>
+<table>
+<thead><tr><th class="check"></th><th>Bin</th><th>SKU</th><th>Name</th><th>Pick</th><th>Unit</th><th>Packs</th><th>Stock</th></tr></thead>
+<tbody>
+{{range .Lines}}<tr{{if .Short}} class="short"{{end}}><td class="check">&#9744;</td><td>{{.Bin}}</td><td>{{.SKU}}</td><td>{{.Name}}{{with .Refs}}<br><small>{{.}}</small>{{end}}{{with .Via}}<br><small>via {{.}}</small>{{end}}</td><td class="num">{{.PickQty}}</td><td>{{.ManagedUnit}}</td><td class="num">{{with .Packs}}{{.}} x {{end}}{{with .PackQty}}{{.}}{{end}}</td><td class="num">{{.StockQty}}</td></tr>
+{{range .Substitutes}}<tr class="sub"><td class="check">&#9744;</td><td>{{.Bin}}</td><td>&#8627; {{.SKU}}</td><td>{{.Name}} (alt {{.Priority}})</td><td class="num">{{.PickQty}}</td><td>{{.ManagedUnit}}</td><td class="num"></td><td class="num">{{.StockQty}}</td></tr>
+{{end}}{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+
+func picklistParams(r *http.Request) (int64, float64, string) {
+	assemblyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || assemblyID <= 0 {
+		return 0, 0, "invalid id"
+	}
+	qty := 1.0
+	if v := strings.TrimSpace(r.URL.Query().Get("qty")); v != "" {
+		qty, err = strconv.ParseFloat(v, 64)
+		if err != nil || qty <= 0 {
+			return 0, 0, "qty must be > 0"
+		}
+	}
+	return assemblyID, qty, ""
+}
+
+func picklistStatus(problem string) int {
+	if problem == "item not found" {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// getPicklist returns the pick list as JSON, or as a printable page with
+// ?format=html.
+func getPicklist(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		assemblyID, qty, problem := picklistParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		asOf, err := parseBOMAsOf(r.URL.Query().Get("as_of"))
+		if err != nil {
+			http.Error(w, "invalid as_of", http.StatusBadRequest)
+			return
+		}
+		format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+		if format != "" && format != "json" && format != "html" {
+			http.Error(w, "format must be json or html", http.StatusBadRequest)
+			return
+		}
+
+		pl, problem, err := buildPicklist(r.Context(), dbx, assemblyID, qty, asOf)
+		if err != nil {
+			http.Error(w, "failed to build picklist", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, picklistStatus(problem))
+			return
+		}
+
+		if format == "html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_ = picklistTemplate.Execute(w, pl)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(pl)
+	}
+}
+
+// commitPicklist records the pick as build OUT transactions, one per
+// component, subject to the negative stock policy.
+func commitPicklist(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Qty  float64 `json:"qty"`
+		Note string  `json:"note"`
+		AsOf string  `json:"as_of"`
+		// RefID is the build (work order) number.
+		RefID string `json:"ref_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		assemblyID, _, problem := picklistParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if req.Qty <= 0 {
+			http.Error(w, "qty must be > 0", http.StatusBadRequest)
+			return
+		}
+		asOf, err := parseBOMAsOf(req.AsOf)
+		if err != nil {
+			http.Error(w, "invalid as_of", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		pl, problem, err := buildPicklist(r.Context(), tx, assemblyID, req.Qty, asOf)
+		if err != nil {
+			http.Error(w, "failed to build picklist", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, picklistStatus(problem))
+			return
+		}
+		if len(pl.Lines) == 0 {
+			http.Error(w, "bom has no components", http.StatusBadRequest)
+			return
+		}
+
+		note := fmt.Sprintf("picklist %s x %g", pl.SKU, req.Qty)
+		if n := strings.TrimSpace(req.Note); n != "" {
+			note += ": " + n
+		}
+		warnings := make([]string, 0)
+		ref := txnRef{Type: refBuild, ID: strings.TrimSpace(req.RefID)}
+		for _, l := range pl.Lines {
+			if l.StockManaged {
+				msg, blocked, err := checkNegativeStock(r.Context(), tx, l.ItemID, l.StockQty, l.PickQty)
+				if err != nil {
+					http.Error(w, "failed to load negative stock policy", http.StatusInternalServerError)
+					return
+				}
+				if blocked {
+					http.Error(w, msg, http.StatusBadRequest)
+					return
+				}
+				if msg != "" {
+					warnings = append(warnings, msg)
+				}
+			}
+			// A line fully covered by substitutes has nothing left to pick.
+			if l.PickQty > 1e-9 {
+				res, err := tx.ExecContext(r.Context(), `
+INSERT INTO stock_transactions(item_id, qty, transaction_type, note, reason_code, bom_record_id, request_id, actor)
+VALUES(?,?,?,?,?,?,?,?)
+`, l.ItemID, l.PickQty, "OUT", note, "build", pl.recordID, requestIDArg(r.Context()), actorArg(r.Context()))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				txnID, _ := res.LastInsertId()
+				if err := ref.stamp(r.Context(), tx, txnID); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			for _, sub := range l.Substitutes {
+				res, err := tx.ExecContext(r.Context(), `
+INSERT INTO stock_transactions(item_id, qty, transaction_type, note, reason_code, bom_record_id, request_id, actor)
+VALUES(?,?,?,?,?,?,?,?)
+`, sub.ItemID, sub.PickQty, "OUT", fmt.Sprintf("%s (substitute for %s)", note, l.SKU), "build", pl.recordID, requestIDArg(r.Context()), actorArg(r.Context()))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				txnID, _ := res.LastInsertId()
+				if err := ref.stamp(r.Context(), tx, txnID); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"picklist": pl,
+			"warnings": warnings,
+			"ref_id":   ref.ID,
+		})
+	}
+}
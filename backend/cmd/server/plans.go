@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const maxPlanLines = 500
+
+type PlanRequirement struct {
+	ItemID        int64  `json:"item_id"`
+	SKU           string `json:"sku"`
+	Name          string `json:"name"`
+	ItemType      string `json:"item_type"`
+	ComponentType string `json:"component_type,omitempty"`
+	ManagedUnit   string `json:"managed_unit"`
+	StockManaged  bool   `json:"stock_managed"`
+	// Action is build for assemblies and in-house parts, purchase otherwise.
+	Action   string  `json:"action"`
+	GrossQty float64 `json:"gross_qty"`
+	OnHand   float64 `json:"on_hand"`
+	NetQty   float64 `json:"net_qty"`
+	// SubstitutedQty is demand on this item met by BOM alternates instead.
+	SubstitutedQty float64 `json:"substituted_qty,omitempty"`
+	// NeedBy is the earliest due date with a shortage.
+	NeedBy string `json:"need_by,omitempty"`
+	// OrderQty is NetQty of a purchased item rounded up to the MOQ and
+	// order multiple of Source, or of the item when it has no offers.
+	OrderQty float64 `json:"order_qty,omitempty"`
+	// Source is the best supplier offer for NetQty of a purchased item.
+	Source *OfferQuote `json:"source,omitempty"`
+}
+
+type planItem struct {
+	req       PlanRequirement
+	phantom   bool
+	available float64
+	// moq and multiple are the item's own purchase constraints.
+	moq, multiple sql.NullFloat64
+	// boms caches the components of the revision in effect on each due date.
+	boms map[string][]bomLine
+}
+
+type bomLine struct {
+	itemID     int64
+	qty        float64
+	alternates []BOMAlternate
+}
+
+// planner explodes planned builds through the BOM revisions in effect on their
+// due dates and nets each level against on-hand stock, consuming stock in
+// due-date order.
+type planner struct {
+	ctx      context.Context
+	q        queryer
+	items    map[int64]*planItem
+	warnings []string
+}
+
+func (p *planner) item(itemID int64) (*planItem, error) {
+	if it, ok := p.items[itemID]; ok {
+		return it, nil
+	}
+	it := &planItem{}
+	var componentType sql.NullString
+	var sm, phantom int
+	err := p.q.QueryRowContext(p.ctx, `
+SELECT
+  i.item_id, i.sku, i.name, i.item_type, c.component_type, i.managed_unit, i.stock_managed, COALESCE(a.phantom, 0),
+  i.moq, i.order_multiple,
+  COALESCE((
+    SELECT SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END)
+    FROM stock_transactions st
+    WHERE st.item_id = i.item_id
+  ), 0)
+FROM items i
+LEFT JOIN components c ON c.item_id = i.item_id
+LEFT JOIN assemblies a ON a.item_id = i.item_id
+WHERE i.item_id = ?
+`, itemID).Scan(&it.req.ItemID, &it.req.SKU, &it.req.Name, &it.req.ItemType, &componentType, &it.req.ManagedUnit, &sm, &phantom, &it.moq, &it.multiple, &it.req.OnHand)
+	if err != nil {
+		return nil, err
+	}
+	it.req.ComponentType = componentType.String
+	it.req.StockManaged = sm != 0
+	it.phantom = phantom != 0
+	it.req.Action = "purchase"
+	if it.req.ItemType == "assembly" || it.req.ComponentType == "part" {
+		it.req.Action = "build"
+	}
+	if it.req.StockManaged {
+		it.available = max(it.req.OnHand, 0)
+	}
+	p.items[itemID] = it
+	return it, nil
+}
+
+func (p *planner) loadBOM(it *planItem, due string) ([]bomLine, error) {
+	// Overdue builds will be made to today's BOM.
+	due = max(due, time.Now().UTC().Format(time.DateOnly))
+	if bom, ok := it.boms[due]; ok {
+		return bom, nil
+	}
+	asOf, err := parseBOMAsOf(due)
+	if err != nil {
+		return nil, err
+	}
+	recordID, _, _, err := effectiveBOMRevision(p.ctx, p.q, it.req.ItemID, asOf)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	rows, err := p.q.QueryContext(p.ctx, `
+SELECT ac.component_item_id, ac.qty_per_unit * (1 + ac.scrap_factor) / ar.yield
+FROM assembly_components ac
+JOIN assembly_records ar ON ar.record_id = ac.record_id
+WHERE ar.record_id = ?
+`, recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bom := make([]bomLine, 0)
+	for rows.Next() {
+		var l bomLine
+		if err := rows.Scan(&l.itemID, &l.qty); err != nil {
+			return nil, err
+		}
+		bom = append(bom, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	alts, err := loadBOMAlternates(p.ctx, p.q, recordID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range bom {
+		bom[i].alternates = alts[bom[i].itemID]
+	}
+	if it.boms == nil {
+		it.boms = make(map[string][]bomLine)
+	}
+	it.boms[due] = bom
+	return bom, nil
+}
+
+// substitute takes up to qty of a line's demand from its alternates' spare
+// stock and returns how much was covered. Only the shortfall the primary
+// item's own stock leaves is substituted.
+func (p *planner) substitute(l bomLine, qty float64) (float64, error) {
+	if len(l.alternates) == 0 {
+		return 0, nil
+	}
+	primary, err := p.item(l.itemID)
+	if err != nil {
+		return 0, err
+	}
+	if !primary.req.StockManaged {
+		return 0, nil
+	}
+	gap := qty - primary.available
+	covered := 0.0
+	for _, a := range l.alternates {
+		if gap-covered <= 0 {
+			break
+		}
+		alt, err := p.item(a.ItemID)
+		if err != nil {
+			return 0, err
+		}
+		if !alt.req.StockManaged || alt.available <= 0 {
+			continue
+		}
+		take := min(gap-covered, alt.available)
+		alt.available -= take
+		alt.req.GrossQty += take
+		covered += take
+	}
+	primary.req.SubstitutedQty += covered
+	return covered, nil
+}
+
+// explode adds a requirement of qty for itemID due on due. path holds the
+// assemblies above this level to detect BOM cycles.
+func (p *planner) explode(itemID int64, qty float64, due string, path []int64) error {
+	for _, id := range path {
+		if id == itemID {
+			p.warnings = append(p.warnings, fmt.Sprintf("bom cycle at item_id=%d", itemID))
+			return nil
+		}
+	}
+	it, err := p.item(itemID)
+	if err != nil {
+		return err
+	}
+	if it.phantom {
+		return p.explodeBOM(it, qty, due, path)
+	}
+	it.req.GrossQty += qty
+
+	// Items without stock management are not tracked, so they never show a
+	// shortage.
+	if !it.req.StockManaged {
+		return nil
+	}
+	used := min(qty, it.available)
+	it.available -= used
+	short := qty - used
+	if short <= 0 {
+		return nil
+	}
+	it.req.NetQty += short
+	if it.req.NeedBy == "" || due < it.req.NeedBy {
+		it.req.NeedBy = due
+	}
+
+	if it.req.ItemType != "assembly" {
+		return nil
+	}
+	return p.explodeBOM(it, short, due, path)
+}
+
+// explodeBOM passes qty of the assembly down to its BOM in effect on due.
+// Phantoms come here directly, since they are never expected to be in stock.
+func (p *planner) explodeBOM(it *planItem, qty float64, due string, path []int64) error {
+	bom, err := p.loadBOM(it, due)
+	if err != nil {
+		return err
+	}
+	if len(bom) == 0 {
+		p.warnings = append(p.warnings, fmt.Sprintf("no bom for %s", it.req.SKU))
+		return nil
+	}
+	childPath := append(path[:len(path):len(path)], it.req.ItemID)
+	for _, l := range bom {
+		need := qty * l.qty
+		covered, err := p.substitute(l, need)
+		if err != nil {
+			return err
+		}
+		if need-covered <= 0 {
+			continue
+		}
+		if err := p.explode(l.itemID, need-covered, due, childPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func planRequirements(dbx *sql.DB) http.HandlerFunc {
+	type Line struct {
+		AssemblyID int64   `json:"assembly_id"`
+		Qty        float64 `json:"qty"`
+		DueDate    string  `json:"due_date"`
+	}
+	type Req struct {
+		Lines []Line `json:"lines"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The body is either {"lines": [...]} or the bare list.
+		var raw json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		var err error
+		if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+			err = json.Unmarshal(raw, &req.Lines)
+		} else {
+			err = json.Unmarshal(raw, &req)
+		}
+		if err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if len(req.Lines) == 0 {
+			http.Error(w, "lines required", http.StatusBadRequest)
+			return
+		}
+		if len(req.Lines) > maxPlanLines {
+			http.Error(w, fmt.Sprintf("too many lines (max %d)", maxPlanLines), http.StatusBadRequest)
+			return
+		}
+		today := time.Now().UTC().Format(time.DateOnly)
+		for i := range req.Lines {
+			l := &req.Lines[i]
+			if l.AssemblyID <= 0 {
+				http.Error(w, fmt.Sprintf("lines[%d]: invalid assembly_id", i), http.StatusBadRequest)
+				return
+			}
+			if l.Qty <= 0 {
+				http.Error(w, fmt.Sprintf("lines[%d]: qty must be > 0", i), http.StatusBadRequest)
+				return
+			}
+			l.DueDate = strings.TrimSpace(l.DueDate)
+			if l.DueDate == "" {
+				l.DueDate = today
+			}
+			if _, err := time.Parse(time.DateOnly, l.DueDate); err != nil {
+				http.Error(w, fmt.Sprintf("lines[%d]: due_date must be YYYY-MM-DD", i), http.StatusBadRequest)
+				return
+			}
+		}
+		sort.SliceStable(req.Lines, func(i, j int) bool { return req.Lines[i].DueDate < req.Lines[j].DueDate })
+
+		p := &planner{ctx: r.Context(), q: dbx, items: make(map[int64]*planItem), warnings: make([]string, 0)}
+		for _, l := range req.Lines {
+			it, err := p.item(l.AssemblyID)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					http.Error(w, fmt.Sprintf("item not found: %d", l.AssemblyID), http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "failed to load item", http.StatusInternalServerError)
+				return
+			}
+			if it.req.ItemType != "assembly" {
+				http.Error(w, fmt.Sprintf("item must be assembly: %d", l.AssemblyID), http.StatusBadRequest)
+				return
+			}
+			if err := p.explode(l.AssemblyID, l.Qty, l.DueDate, nil); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		out := make([]PlanRequirement, 0, len(p.items))
+		for _, it := range p.items {
+			// Alternates that were looked at but not drawn on.
+			if it.req.GrossQty == 0 && it.req.SubstitutedQty == 0 {
+				continue
+			}
+			if it.req.Action == "purchase" && it.req.NetQty > 0 {
+				offers, err := loadOffers(r.Context(), dbx, it.req.ItemID)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if quotes := rankOffers(offers, it.req.NetQty, ""); len(quotes) > 0 {
+					it.req.Source = &quotes[0]
+					it.req.OrderQty = quotes[0].OrderQty
+				} else {
+					it.req.OrderQty = purchaseQty(it.req.NetQty, it.moq.Float64, it.multiple.Float64)
+				}
+			}
+			out = append(out, it.req)
+		}
+		sort.Slice(out, func(i, j int) bool {
+			a, b := out[i], out[j]
+			if (a.NetQty > 0) != (b.NetQty > 0) {
+				return a.NetQty > 0
+			}
+			if a.NeedBy != b.NeedBy {
+				return a.NeedBy < b.NeedBy
+			}
+			return a.SKU < b.SKU
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"requirements": out,
+			"warnings":     p.warnings,
+		})
+	}
+}
@@ -0,0 +1,667 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+	"stockmate/internal/validate"
+)
+
+// Purchase order lines record what is on order. Purchase orders themselves
+// live outside stockmate; po_ref is their number, and a receipt booked with
+// the same ref_id (POST /api/production/components/complete) is counted
+// against the lines.
+const (
+	poLineOpen      = "open"
+	poLineReceived  = "received"
+	poLineCancelled = "cancelled"
+)
+
+// poLineStatusWhere is the condition on l for each line status.
+var poLineStatusWhere = map[string]string{
+	poLineOpen:      "l.cancelled_at IS NULL AND l.received_qty < l.qty",
+	poLineReceived:  "l.cancelled_at IS NULL AND l.received_qty >= l.qty",
+	poLineCancelled: "l.cancelled_at IS NOT NULL",
+}
+
+type PurchaseOrderLine struct {
+	ID           int64   `json:"id"`
+	PORef        string  `json:"po_ref"`
+	ItemID       int64   `json:"item_id"`
+	SKU          string  `json:"sku"`
+	Name         string  `json:"name"`
+	ManagedUnit  string  `json:"managed_unit"`
+	SupplierID   *int64  `json:"supplier_id,omitempty"`
+	SupplierName string  `json:"supplier_name,omitempty"`
+	Qty          float64 `json:"qty"`
+	ReceivedQty  float64 `json:"received_qty"`
+	OpenQty      float64 `json:"open_qty"`
+	// ExpectedDate is when the goods should arrive; empty when unknown.
+	ExpectedDate string        `json:"expected_date,omitempty"`
+	Status       string        `json:"status"`
+	Note         string        `json:"note,omitempty"`
+	CancelledAt  timeutil.Time `json:"cancelled_at"`
+	CreatedAt    timeutil.Time `json:"created_at"`
+}
+
+const poLineSelect = `
+SELECT
+  l.line_id, l.po_ref, l.item_id, i.sku, i.name, i.managed_unit, l.supplier_id, s.name,
+  l.qty, l.received_qty, l.expected_date, l.note, l.cancelled_at, l.created_at
+FROM purchase_order_lines l
+JOIN items i ON i.item_id = l.item_id
+LEFT JOIN suppliers s ON s.supplier_id = l.supplier_id
+`
+
+func scanPOLine(row interface{ Scan(...any) error }) (PurchaseOrderLine, error) {
+	var l PurchaseOrderLine
+	var supplierID sql.NullInt64
+	var supplierName, expected sql.NullString
+	if err := row.Scan(&l.ID, &l.PORef, &l.ItemID, &l.SKU, &l.Name, &l.ManagedUnit, &supplierID, &supplierName,
+		&l.Qty, &l.ReceivedQty, &expected, &l.Note, &l.CancelledAt, &l.CreatedAt); err != nil {
+		return l, err
+	}
+	if supplierID.Valid {
+		v := supplierID.Int64
+		l.SupplierID = &v
+	}
+	l.SupplierName = supplierName.String
+	l.ExpectedDate = expected.String
+	switch {
+	case !l.CancelledAt.IsZero():
+		l.Status = poLineCancelled
+	case l.ReceivedQty >= l.Qty:
+		l.Status = poLineReceived
+	default:
+		l.Status = poLineOpen
+		l.OpenQty = l.Qty - l.ReceivedQty
+	}
+	return l, nil
+}
+
+func loadPOLine(ctx context.Context, q queryer, id int64) (PurchaseOrderLine, error) {
+	return scanPOLine(q.QueryRowContext(ctx, poLineSelect+` WHERE l.line_id = ?`, id))
+}
+
+// itemLeadTime is the lead time for buying itemID from supplierID: the
+// offer's, then the supplier's, then the item's. supplierID may be nil.
+func itemLeadTime(ctx context.Context, q queryer, itemID int64, supplierID *int64) (sql.NullInt64, error) {
+	var v sql.NullInt64
+	err := q.QueryRowContext(ctx, `
+SELECT COALESCE(o.lead_time_days, s.lead_time_days, i.lead_time_days)
+FROM items i
+LEFT JOIN suppliers s ON s.supplier_id = ?
+LEFT JOIN supplier_offers o ON o.item_id = i.item_id AND o.supplier_id = s.supplier_id
+WHERE i.item_id = ?
+`, supplierID, itemID).Scan(&v)
+	return v, err
+}
+
+// listPurchaseOrderLines lists lines by ?status=open|received|cancelled
+// (default all), ?item_id= and ?po_ref=, soonest expected first.
+func listPurchaseOrderLines(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		conds := []string{"1=1"}
+		args := make([]any, 0)
+		if status := strings.ToLower(strings.TrimSpace(q.Get("status"))); status != "" {
+			cond, ok := poLineStatusWhere[status]
+			if !ok {
+				http.Error(w, "status must be open, received or cancelled", http.StatusBadRequest)
+				return
+			}
+			conds = append(conds, cond)
+		}
+		if v := strings.TrimSpace(q.Get("item_id")); v != "" {
+			itemID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || itemID <= 0 {
+				http.Error(w, "invalid item_id", http.StatusBadRequest)
+				return
+			}
+			conds = append(conds, "l.item_id = ?")
+			args = append(args, itemID)
+		}
+		if v := strings.TrimSpace(q.Get("po_ref")); v != "" {
+			conds = append(conds, "l.po_ref = ?")
+			args = append(args, v)
+		}
+
+		rows, err := dbx.QueryContext(r.Context(), poLineSelect+`
+WHERE `+strings.Join(conds, " AND ")+`
+ORDER BY l.expected_date IS NULL, l.expected_date, l.line_id
+`, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]PurchaseOrderLine, 0)
+		for rows.Next() {
+			l, err := scanPOLine(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, l)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// createPurchaseOrderLine puts an item on order. Without an expected_date
+// the line is due today plus the lead time (see itemLeadTime), in ?tz=.
+func createPurchaseOrderLine(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		PORef        string  `json:"po_ref"`
+		ItemID       int64   `json:"item_id"`
+		SupplierID   *int64  `json:"supplier_id"`
+		Qty          float64 `json:"qty"`
+		ExpectedDate string  `json:"expected_date"`
+		Note         string  `json:"note"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.PORef = strings.TrimSpace(req.PORef)
+		req.ExpectedDate = strings.TrimSpace(req.ExpectedDate)
+		req.Note = strings.TrimSpace(req.Note)
+
+		var errs validate.Errors
+		errs.Required("po_ref", req.PORef)
+		errs.Check(req.ItemID > 0, "item_id", "must be > 0")
+		errs.Check(req.Qty > 0, "qty", "must be > 0")
+		if req.ExpectedDate != "" {
+			_, err := time.Parse(time.DateOnly, req.ExpectedDate)
+			errs.Check(err == nil, "expected_date", "must be YYYY-MM-DD")
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			errs.Add("tz", err.Error())
+		}
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var lifecycle string
+		var purchasable int
+		if err := tx.QueryRowContext(r.Context(), `
+SELECT i.lifecycle_status, COUNT(c.component_id)
+FROM items i
+LEFT JOIN components c ON c.item_id = i.item_id AND c.component_type IN ('material','part','consumable')
+WHERE i.item_id = ?
+GROUP BY i.item_id
+`, req.ItemID).Scan(&lifecycle, &purchasable); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "item not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if purchasable == 0 {
+			http.Error(w, "item must be component(material/part/consumable)", http.StatusBadRequest)
+			return
+		}
+		if lifecycle == lifecycleObsolete {
+			http.Error(w, "item is obsolete", http.StatusConflict)
+			return
+		}
+		if req.SupplierID != nil {
+			var n int
+			if err := tx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM suppliers WHERE supplier_id = ?`, *req.SupplierID).Scan(&n); err != nil {
+				http.Error(w, "failed to load supplier", http.StatusInternalServerError)
+				return
+			}
+			if n == 0 {
+				http.Error(w, "supplier not found", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var expected any
+		if req.ExpectedDate != "" {
+			expected = req.ExpectedDate
+		} else {
+			leadTime, err := itemLeadTime(r.Context(), tx, req.ItemID, req.SupplierID)
+			if err != nil {
+				http.Error(w, "failed to load lead time", http.StatusInternalServerError)
+				return
+			}
+			if leadTime.Valid {
+				expected = time.Now().In(loc).AddDate(0, 0, int(leadTime.Int64)).Format(time.DateOnly)
+			}
+		}
+
+		res, err := tx.ExecContext(r.Context(), `
+INSERT INTO purchase_order_lines(po_ref, item_id, supplier_id, qty, expected_date, note)
+VALUES(?,?,?,?,?,?)
+`, req.PORef, req.ItemID, req.SupplierID, req.Qty, expected, req.Note)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, _ := res.LastInsertId()
+		line, err := loadPOLine(r.Context(), tx, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(line)
+	}
+}
+
+// updatePurchaseOrderLine changes the qty, expected_date ("" clears it) or
+// note of an open line; fields that are not sent are kept.
+func updatePurchaseOrderLine(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Qty          *float64 `json:"qty"`
+		ExpectedDate *string  `json:"expected_date"`
+		Note         *string  `json:"note"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := poLineID(r)
+		if !ok {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		var errs validate.Errors
+		errs.Check(req.Qty == nil || *req.Qty > 0, "qty", "must be > 0")
+		if req.ExpectedDate != nil {
+			*req.ExpectedDate = strings.TrimSpace(*req.ExpectedDate)
+			if *req.ExpectedDate != "" {
+				_, err := time.Parse(time.DateOnly, *req.ExpectedDate)
+				errs.Check(err == nil, "expected_date", "must be YYYY-MM-DD")
+			}
+		}
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		line, problem, status, err := loadOpenPOLine(r.Context(), tx, id)
+		if err != nil {
+			http.Error(w, "failed to load line", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		if req.Qty != nil {
+			line.Qty = *req.Qty
+		}
+		if req.ExpectedDate != nil {
+			line.ExpectedDate = *req.ExpectedDate
+		}
+		if req.Note != nil {
+			line.Note = strings.TrimSpace(*req.Note)
+		}
+		var expected any
+		if line.ExpectedDate != "" {
+			expected = line.ExpectedDate
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+UPDATE purchase_order_lines SET qty = ?, expected_date = ?, note = ? WHERE line_id = ?
+`, line.Qty, expected, line.Note, id); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if line, err = loadPOLine(r.Context(), tx, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(line)
+	}
+}
+
+// cancelPurchaseOrderLine closes an open line without receiving the rest.
+func cancelPurchaseOrderLine(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := poLineID(r)
+		if !ok {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		_, problem, status, err := loadOpenPOLine(r.Context(), tx, id)
+		if err != nil {
+			http.Error(w, "failed to load line", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+UPDATE purchase_order_lines SET cancelled_at = strftime('%Y-%m-%dT%H:%M:%SZ','now') WHERE line_id = ?
+`, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		line, err := loadPOLine(r.Context(), tx, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(line)
+	}
+}
+
+// loadOpenPOLine loads a line that can still be changed; problem and status
+// say why it cannot.
+func loadOpenPOLine(ctx context.Context, q queryer, id int64) (PurchaseOrderLine, string, int, error) {
+	line, err := loadPOLine(ctx, q, id)
+	if err == sql.ErrNoRows {
+		return line, "line not found", http.StatusNotFound, nil
+	}
+	if err != nil {
+		return line, "", 0, err
+	}
+	if line.Status != poLineOpen {
+		return line, "line is already " + line.Status, http.StatusConflict, nil
+	}
+	return line, "", 0, nil
+}
+
+func poLineID(r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// receivePOLines counts qty of itemID received under poRef against the
+// item's open lines on that order, earliest expected first. Anything beyond
+// the ordered quantity is not allocated. Reversing the receipt does not
+// reopen the lines.
+func receivePOLines(ctx context.Context, tx *sql.Tx, poRef string, itemID int64, qty float64) error {
+	rows, err := tx.QueryContext(ctx, `
+SELECT l.line_id, l.qty - l.received_qty
+FROM purchase_order_lines l
+WHERE l.po_ref = ? AND l.item_id = ? AND `+poLineStatusWhere[poLineOpen]+`
+ORDER BY l.expected_date IS NULL, l.expected_date, l.line_id
+`, poRef, itemID)
+	if err != nil {
+		return err
+	}
+	type open struct {
+		id  int64
+		qty float64
+	}
+	var lines []open
+	for rows.Next() {
+		var l open
+		if err := rows.Scan(&l.id, &l.qty); err != nil {
+			rows.Close()
+			return err
+		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if qty <= 0 {
+			break
+		}
+		n := min(qty, l.qty)
+		if _, err := tx.ExecContext(ctx, `UPDATE purchase_order_lines SET received_qty = received_qty + ? WHERE line_id = ?`, n, l.id); err != nil {
+			return err
+		}
+		qty -= n
+	}
+	return nil
+}
+
+// expectedReceipt is open order quantity due a number of days from today.
+type expectedReceipt struct {
+	days float64
+	qty  float64
+}
+
+// loadExpectedReceipts returns the item's open lines that have an expected
+// date, by days from today in loc; overdue lines are due today.
+func loadExpectedReceipts(ctx context.Context, q queryer, itemID int64, loc *time.Location) ([]expectedReceipt, error) {
+	rows, err := q.QueryContext(ctx, `
+SELECT l.expected_date, SUM(l.qty - l.received_qty)
+FROM purchase_order_lines l
+WHERE l.item_id = ? AND l.expected_date IS NOT NULL AND `+poLineStatusWhere[poLineOpen]+`
+GROUP BY l.expected_date
+ORDER BY l.expected_date
+`, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	today, _ := time.Parse(time.DateOnly, time.Now().In(loc).Format(time.DateOnly))
+	var out []expectedReceipt
+	for rows.Next() {
+		var date string
+		var qty float64
+		if err := rows.Scan(&date, &qty); err != nil {
+			return nil, err
+		}
+		d, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expected_date %q", date)
+		}
+		out = append(out, expectedReceipt{days: max(d.Sub(today).Hours()/24, 0), qty: qty})
+	}
+	return out, rows.Err()
+}
+
+// daysUntilStockout runs stock down at dailyRate, topping it up with each
+// receipt on its day, and returns when it first reaches zero. receipts must
+// be sorted by day.
+func daysUntilStockout(stock, dailyRate float64, receipts []expectedReceipt) float64 {
+	day, level := 0.0, max(stock, 0)
+	for _, rc := range receipts {
+		if rc.days > day {
+			use := dailyRate * (rc.days - day)
+			if level <= use {
+				return day + level/dailyRate
+			}
+			level -= use
+			day = rc.days
+		}
+		level += rc.qty
+	}
+	return day + level/dailyRate
+}
+
+type IncomingItem struct {
+	ItemID int64   `json:"item_id"`
+	SKU    string  `json:"sku"`
+	Qty    float64 `json:"qty"`
+}
+
+type IncomingWeek struct {
+	Start string              `json:"start"`
+	Items []IncomingItem      `json:"items"`
+	Lines []PurchaseOrderLine `json:"lines"`
+}
+
+// reportIncoming groups the open lines by the week they are expected in,
+// over ?weeks= (default 8) weeks starting with the Monday of ?from= (default
+// today, in ?tz=). Lines due before the first week are overdue, after the
+// last one later, and lines without a date unscheduled. ?item_id= limits
+// the report to one item.
+func reportIncoming(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		weeks, ok := queryInt(q.Get("weeks"), 8, 1, 52)
+		if !ok {
+			http.Error(w, "weeks must be 1-52", http.StatusBadRequest)
+			return
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from := time.Now().In(loc).Format(time.DateOnly)
+		if v := strings.TrimSpace(q.Get("from")); v != "" {
+			from = v
+		}
+		start, err := time.Parse(time.DateOnly, from)
+		if err != nil {
+			http.Error(w, "from must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+
+		conds := []string{poLineStatusWhere[poLineOpen]}
+		args := make([]any, 0)
+		if v := strings.TrimSpace(q.Get("item_id")); v != "" {
+			itemID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || itemID <= 0 {
+				http.Error(w, "invalid item_id", http.StatusBadRequest)
+				return
+			}
+			conds = append(conds, "l.item_id = ?")
+			args = append(args, itemID)
+		}
+		rows, err := dbx.QueryContext(r.Context(), poLineSelect+`
+WHERE `+strings.Join(conds, " AND ")+`
+ORDER BY l.expected_date, i.sku, l.line_id
+`, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := struct {
+			From        string              `json:"from"`
+			Weeks       []IncomingWeek      `json:"weeks"`
+			Overdue     []PurchaseOrderLine `json:"overdue"`
+			Later       []PurchaseOrderLine `json:"later"`
+			Unscheduled []PurchaseOrderLine `json:"unscheduled"`
+		}{
+			From:        start.Format(time.DateOnly),
+			Weeks:       make([]IncomingWeek, weeks),
+			Overdue:     make([]PurchaseOrderLine, 0),
+			Later:       make([]PurchaseOrderLine, 0),
+			Unscheduled: make([]PurchaseOrderLine, 0),
+		}
+		for i := range out.Weeks {
+			out.Weeks[i] = IncomingWeek{
+				Start: start.AddDate(0, 0, 7*i).Format(time.DateOnly),
+				Items: make([]IncomingItem, 0),
+				Lines: make([]PurchaseOrderLine, 0),
+			}
+		}
+		for rows.Next() {
+			l, err := scanPOLine(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if l.ExpectedDate == "" {
+				out.Unscheduled = append(out.Unscheduled, l)
+				continue
+			}
+			d, err := time.Parse(time.DateOnly, l.ExpectedDate)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid expected_date on line %d", l.ID), http.StatusInternalServerError)
+				return
+			}
+			week := int(d.Sub(start).Hours() / 24 / 7)
+			switch {
+			case d.Before(start):
+				out.Overdue = append(out.Overdue, l)
+			case week >= weeks:
+				out.Later = append(out.Later, l)
+			default:
+				out.Weeks[week].Lines = append(out.Weeks[week].Lines, l)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range out.Weeks {
+			wk := &out.Weeks[i]
+			totals := map[int64]*IncomingItem{}
+			for _, l := range wk.Lines {
+				t, ok := totals[l.ItemID]
+				if !ok {
+					t = &IncomingItem{ItemID: l.ItemID, SKU: l.SKU}
+					totals[l.ItemID] = t
+				}
+				t.Qty += l.OpenQty
+			}
+			for _, t := range totals {
+				wk.Items = append(wk.Items, *t)
+			}
+			sort.Slice(wk.Items, func(a, b int) bool { return wk.Items[a].SKU < wk.Items[b].SKU })
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
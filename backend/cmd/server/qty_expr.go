@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// QtyExpr is a tallied quantity such as "3*250+120" or "2 packs + 30",
+// with the value of each top-level term so a bench count can be checked.
+type QtyExpr struct {
+	Input string    `json:"input"`
+	Terms []QtyTerm `json:"terms"`
+	Qty   float64   `json:"qty"`
+}
+
+type QtyTerm struct {
+	Text string  `json:"text"`
+	Qty  float64 `json:"qty"`
+}
+
+// qtyUnitFactors are the unit words a number may carry, by managed unit,
+// as multiples of that unit. Packs are handled separately.
+var qtyUnitFactors = map[string]map[string]float64{
+	"g":   {"g": 1, "kg": 1000},
+	"pcs": {"pcs": 1, "pc": 1},
+}
+
+var qtyPackWords = []string{"pack", "packs", "pk"}
+
+type qtyToken struct {
+	kind byte // 'n' number, 'w' word, or the operator itself
+	text string
+	pos  int
+}
+
+func lexQtyExpr(s string) ([]qtyToken, error) {
+	var toks []qtyToken
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			toks = append(toks, qtyToken{'n', string(rs[i:j]), i})
+			i = j
+		case unicode.IsLetter(r):
+			j := i
+			for j < len(rs) && unicode.IsLetter(rs[j]) {
+				j++
+			}
+			toks = append(toks, qtyToken{'w', strings.ToLower(string(rs[i:j])), i})
+			i = j
+		case strings.ContainsRune("+-*/()", r):
+			toks = append(toks, qtyToken{byte(r), string(r), i})
+			i++
+		case r == '×':
+			toks = append(toks, qtyToken{'*', string(r), i})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", r, i+1)
+		}
+	}
+	return toks, nil
+}
+
+// qtyParser evaluates the grammar
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "x" | "/") factor }
+//	factor = number [ unit ] | "(" expr ")" | "-" factor
+//
+// where unit is one of the item's unit words or pack(s), worth pack_qty.
+type qtyParser struct {
+	src     []rune
+	toks    []qtyToken
+	i       int
+	unit    string
+	packQty float64
+}
+
+func (p *qtyParser) peek() *qtyToken {
+	if p.i < len(p.toks) {
+		return &p.toks[p.i]
+	}
+	return nil
+}
+
+// end is the source offset just past the last consumed token.
+func (p *qtyParser) end() int {
+	t := p.toks[p.i-1]
+	return t.pos + len([]rune(t.text))
+}
+
+func (p *qtyParser) expr() (float64, error) {
+	v, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for t := p.peek(); t != nil && (t.kind == '+' || t.kind == '-'); t = p.peek() {
+		p.i++
+		rhs, err := p.term()
+		if err != nil {
+			return 0, err
+		}
+		if t.kind == '+' {
+			v += rhs
+		} else {
+			v -= rhs
+		}
+	}
+	return v, nil
+}
+
+func (p *qtyParser) term() (float64, error) {
+	v, err := p.factor()
+	if err != nil {
+		return 0, err
+	}
+	for t := p.peek(); t != nil && (t.kind == '*' || t.kind == '/' || (t.kind == 'w' && t.text == "x")); t = p.peek() {
+		p.i++
+		rhs, err := p.factor()
+		if err != nil {
+			return 0, err
+		}
+		if t.kind == '/' {
+			if rhs == 0 {
+				return 0, fmt.Errorf("division by zero at %d", t.pos+1)
+			}
+			v /= rhs
+		} else {
+			v *= rhs
+		}
+	}
+	return v, nil
+}
+
+func (p *qtyParser) factor() (float64, error) {
+	t := p.peek()
+	if t == nil {
+		return 0, fmt.Errorf("unexpected end")
+	}
+	p.i++
+	switch t.kind {
+	case '-':
+		v, err := p.factor()
+		return -v, err
+	case '(':
+		v, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if c := p.peek(); c == nil || c.kind != ')' {
+			return 0, fmt.Errorf("missing ) for ( at %d", t.pos+1)
+		}
+		p.i++
+		return v, nil
+	case 'n':
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q at %d", t.text, t.pos+1)
+		}
+		if u := p.peek(); u != nil && u.kind == 'w' && u.text != "x" {
+			p.i++
+			f, err := p.unitFactor(u)
+			if err != nil {
+				return 0, err
+			}
+			v *= f
+		}
+		return v, nil
+	}
+	return 0, fmt.Errorf("unexpected %q at %d", t.text, t.pos+1)
+}
+
+func (p *qtyParser) unitFactor(u *qtyToken) (float64, error) {
+	for _, w := range qtyPackWords {
+		if u.text == w {
+			if p.packQty <= 0 {
+				return 0, fmt.Errorf("item has no pack_qty for %q at %d", u.text, u.pos+1)
+			}
+			return p.packQty, nil
+		}
+	}
+	if f, ok := qtyUnitFactors[p.unit][u.text]; ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("unit %q at %d does not fit an item managed in %s", u.text, u.pos+1, p.unit)
+}
+
+// parseQtyExpr evaluates s for an item managed in unit with the given
+// pack_qty (0 for none).
+func parseQtyExpr(s, unit string, packQty float64) (QtyExpr, error) {
+	out := QtyExpr{Input: s, Terms: make([]QtyTerm, 0)}
+	toks, err := lexQtyExpr(s)
+	if err != nil {
+		return out, err
+	}
+	if len(toks) == 0 {
+		return out, fmt.Errorf("is empty")
+	}
+	p := &qtyParser{src: []rune(s), toks: toks, unit: unit, packQty: packQty}
+	// The top-level sum is walked here rather than in expr so each term
+	// can be echoed with its value; a term's text leaves out the + or -
+	// joining it, which shows in the sign of its qty.
+	sign := 1.0
+	for {
+		if p.peek() == nil {
+			return out, fmt.Errorf("unexpected end")
+		}
+		start := p.peek().pos
+		v, err := p.term()
+		if err != nil {
+			return out, err
+		}
+		v *= sign
+		out.Terms = append(out.Terms, QtyTerm{Text: string(p.src[start:p.end()]), Qty: v})
+		out.Qty += v
+		t := p.peek()
+		if t == nil {
+			break
+		}
+		if t.kind != '+' && t.kind != '-' {
+			return out, fmt.Errorf("unexpected %q at %d", t.text, t.pos+1)
+		}
+		sign = 1
+		if t.kind == '-' {
+			sign = -1
+		}
+		p.i++
+	}
+	return out, nil
+}
+
+// evalItemQtyExpr evaluates s with the unit and pack_qty of an item. It
+// returns sql.ErrNoRows for an unknown item and a problem for a bad
+// expression.
+func evalItemQtyExpr(ctx context.Context, q queryer, itemID int64, field, s string) (QtyExpr, string, error) {
+	var unit string
+	var packQty sql.NullFloat64
+	if err := q.QueryRowContext(ctx, `SELECT managed_unit, pack_qty FROM items WHERE item_id = ?`, itemID).Scan(&unit, &packQty); err != nil {
+		return QtyExpr{}, "", err
+	}
+	e, err := parseQtyExpr(s, unit, packQty.Float64)
+	if err != nil {
+		return e, field + ": " + err.Error(), nil
+	}
+	return e, "", nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"stockmate/internal/store"
+)
+
+const (
+	readOnlySettingKey       = "read_only"
+	readOnlyReasonSettingKey = "read_only_reason"
+)
+
+// readOnlyPath is the toggle endpoint, the one mutation still accepted while
+// read-only.
+const readOnlyPath = "/api/admin/read-only"
+
+// readOnlyMode rejects mutating requests with 503 while enabled, e.g. during
+// backups or an inventory freeze. It is switched on by READ_ONLY (which the
+// endpoint cannot override) or by the endpoint, which keeps the state in
+// app_settings so it survives a restart.
+type readOnlyMode struct {
+	mu      sync.RWMutex
+	forced  bool
+	enabled bool
+	reason  string
+}
+
+func loadReadOnlyMode(ctx context.Context, dbx *sql.DB, forced bool) (*readOnlyMode, error) {
+	m := &readOnlyMode{forced: forced}
+	rows, err := dbx.QueryContext(ctx, `SELECT key, value FROM app_settings WHERE key IN (?, ?)`, readOnlySettingKey, readOnlyReasonSettingKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		switch k {
+		case readOnlySettingKey:
+			m.enabled = v == "1"
+		case readOnlyReasonSettingKey:
+			m.reason = v
+		}
+	}
+	return m, rows.Err()
+}
+
+func (m *readOnlyMode) state() (enabled bool, reason string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.forced || m.enabled, m.reason
+}
+
+// Middleware answers 503 to everything but GET/HEAD/OPTIONS, the toggle
+// endpoint and GraphQL queries while read-only.
+func (m *readOnlyMode) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		enabled, reason := m.state()
+		if !enabled || r.URL.Path == readOnlyPath || r.URL.Path == graphQLPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		msg := "server is in read-only mode"
+		if reason != "" {
+			msg += ": " + reason
+		}
+		http.Error(w, msg, http.StatusServiceUnavailable)
+	})
+}
+
+// guardJob skips a job that writes while read-only.
+func (m *readOnlyMode) guardJob(name string, fn func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if enabled, _ := m.state(); enabled {
+			log.Printf("job %s skipped: read-only mode", name)
+			return nil
+		}
+		return fn(ctx)
+	}
+}
+
+func (m *readOnlyMode) writeState(w http.ResponseWriter) {
+	enabled, reason := m.state()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"enabled": enabled,
+		"reason":  reason,
+		"forced":  m.forced,
+	})
+}
+
+func getReadOnlyMode(m *readOnlyMode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.writeState(w)
+	}
+}
+
+// setReadOnlyMode turns read-only mode on or off. It cannot turn off a mode
+// forced by READ_ONLY.
+func setReadOnlyMode(dbx *sql.DB, m *readOnlyMode) http.HandlerFunc {
+	type Req struct {
+		Enabled *bool  `json:"enabled"`
+		Reason  string `json:"reason"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if req.Enabled == nil {
+			http.Error(w, "enabled is required", http.StatusBadRequest)
+			return
+		}
+		if !*req.Enabled && m.forced {
+			http.Error(w, "read-only mode is set by READ_ONLY and cannot be turned off here", http.StatusConflict)
+			return
+		}
+		reason := strings.TrimSpace(req.Reason)
+		if !*req.Enabled {
+			reason = ""
+		}
+		value := "0"
+		if *req.Enabled {
+			value = "1"
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		for _, kv := range [][2]string{{readOnlySettingKey, value}, {readOnlyReasonSettingKey, reason}} {
+			if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO app_settings(key, value) VALUES(?, ?)
+ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+`, kv[0], kv[1]); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		m.mu.Lock()
+		m.enabled = *req.Enabled
+		m.reason = reason
+		m.mu.Unlock()
+		m.writeState(w)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+var reasonCodePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+type ReasonCode struct {
+	Code      string `json:"code"`
+	Label     string `json:"label"`
+	Active    bool   `json:"active"`
+	SortOrder int    `json:"sort_order"`
+}
+
+type ReasonReportRow struct {
+	ReasonCode       string  `json:"reason_code"`
+	Label            string  `json:"label"`
+	TransactionType  string  `json:"transaction_type"`
+	TransactionCount int64   `json:"transaction_count"`
+	TotalQty         float64 `json:"total_qty"`
+}
+
+// resolveReasonCode validates an optional reason code against the master
+// table and returns the value to store. A non-empty problem means the code is
+// unusable (unknown or inactive); inactive codes stay valid on history only.
+func resolveReasonCode(ctx context.Context, q queryer, code string) (value any, problem string, err error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return nil, "", nil
+	}
+	var active int
+	if err := q.QueryRowContext(ctx, `SELECT active FROM reason_codes WHERE code = ?`, code).Scan(&active); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "unknown reason_code: " + code, nil
+		}
+		return nil, "", err
+	}
+	if active == 0 {
+		return nil, "reason_code is inactive: " + code, nil
+	}
+	return code, "", nil
+}
+
+func listReasonCodes(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := `SELECT code, label, active, sort_order FROM reason_codes`
+		if r.URL.Query().Get("all") != "1" {
+			query += ` WHERE active = 1`
+		}
+		query += ` ORDER BY sort_order, code`
+
+		rows, err := dbx.QueryContext(r.Context(), query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]ReasonCode, 0)
+		for rows.Next() {
+			var rc ReasonCode
+			var active int
+			if err := rows.Scan(&rc.Code, &rc.Label, &active, &rc.SortOrder); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			rc.Active = active != 0
+			out = append(out, rc)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// upsertReasonCode creates or updates a reason code. Codes are never deleted,
+// only deactivated, because existing transactions keep referring to them.
+func upsertReasonCode(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Label     string `json:"label"`
+		Active    *bool  `json:"active"`
+		SortOrder int    `json:"sort_order"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "code")))
+		if !reasonCodePattern.MatchString(code) {
+			http.Error(w, "invalid code", http.StatusBadRequest)
+			return
+		}
+
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Label = strings.TrimSpace(req.Label)
+		if req.Label == "" {
+			http.Error(w, "label required", http.StatusBadRequest)
+			return
+		}
+		active := 1
+		if req.Active != nil && !*req.Active {
+			active = 0
+		}
+
+		if _, err := store.Exec(r.Context(), dbx, `
+INSERT INTO reason_codes(code, label, active, sort_order)
+VALUES(?,?,?,?)
+ON CONFLICT(code) DO UPDATE SET
+  label = excluded.label,
+  active = excluded.active,
+  sort_order = excluded.sort_order
+`, code, req.Label, active, req.SortOrder); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// reportStockReasons totals movements per reason code so losses (scrap,
+// shrinkage) can be told apart from consumption (build, sale).
+func reportStockReasons(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sb := strings.Builder{}
+		sb.WriteString(`
+SELECT
+  COALESCE(st.reason_code, ''),
+  COALESCE(rc.label, ''),
+  st.transaction_type,
+  COUNT(1),
+  COALESCE(SUM(st.qty), 0)
+FROM stock_transactions st
+LEFT JOIN reason_codes rc ON rc.code = st.reason_code
+WHERE 1=1
+`)
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		args := make([]any, 0)
+		for _, p := range []struct {
+			param string
+			op    string
+		}{{"from", ">="}, {"to", "<"}} {
+			v := strings.TrimSpace(r.URL.Query().Get(p.param))
+			if v == "" {
+				continue
+			}
+			d, err := timeutil.StartOfDay(v, loc)
+			if err != nil {
+				http.Error(w, "invalid "+p.param+" (want YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+			if p.param == "to" {
+				// "to" is inclusive of the whole day.
+				d = d.AddDate(0, 0, 1)
+			}
+			sb.WriteString(" AND st.created_at " + p.op + " ?")
+			args = append(args, timeutil.Format(d))
+		}
+		if itemIDStr := strings.TrimSpace(r.URL.Query().Get("item_id")); itemIDStr != "" {
+			itemID, err := strconv.ParseInt(itemIDStr, 10, 64)
+			if err != nil || itemID <= 0 {
+				http.Error(w, "invalid item_id", http.StatusBadRequest)
+				return
+			}
+			sb.WriteString(" AND st.item_id = ?")
+			args = append(args, itemID)
+		}
+		sb.WriteString(`
+GROUP BY st.reason_code, rc.label, st.transaction_type
+ORDER BY st.reason_code IS NULL, st.reason_code, st.transaction_type
+`)
+
+		rows, err := dbx.QueryContext(r.Context(), sb.String(), args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]ReasonReportRow, 0)
+		for rows.Next() {
+			var row ReasonReportRow
+			if err := rows.Scan(&row.ReasonCode, &row.Label, &row.TransactionType, &row.TransactionCount, &row.TotalQty); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, row)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
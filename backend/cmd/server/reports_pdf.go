@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"stockmate/internal/pdf"
+)
+
+// reportStyle is the letterhead shared by PDF reports.
+type reportStyle struct {
+	header string
+	logo   []byte
+}
+
+func loadReportStyle(header, logoFile string) (reportStyle, error) {
+	rs := reportStyle{header: header}
+	if logoFile == "" {
+		return rs, nil
+	}
+	data, err := os.ReadFile(logoFile)
+	if err != nil {
+		return rs, fmt.Errorf("REPORT_LOGO_FILE: %w", err)
+	}
+	if err := pdf.CheckLogo(data); err != nil {
+		return rs, fmt.Errorf("REPORT_LOGO_FILE must be a JPEG: %w", err)
+	}
+	rs.logo = data
+	return rs, nil
+}
+
+func formatQty(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formatMoney prints an amount with two decimals and thousands separators.
+func formatMoney(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	intPart, frac, _ := strings.Cut(s, ".")
+	var b strings.Builder
+	for i, r := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	out := b.String() + "." + frac
+	if neg {
+		out = "-" + out
+	}
+	return out
+}
+
+func servePDF(w http.ResponseWriter, doc *pdf.Document, filename string) {
+	var buf bytes.Buffer
+	if err := doc.Render(&buf); err != nil {
+		http.Error(w, "failed to render pdf", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
+	_, _ = w.Write(buf.Bytes())
+}
+
+// bomPDF prints the latest revision, or ?rev_no=, with unit and extended
+// costs in the base currency.
+func bomPDF(dbx *sql.DB, rs reportStyle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parentID, problem, status, err := bomParentID(r.Context(), dbx, r)
+		if err != nil {
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		base, err := baseCurrency(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, "failed to load base currency", http.StatusInternalServerError)
+			return
+		}
+
+		var sku, name, createdAt string
+		var revNo, recordID int64
+		query := `
+SELECT i.sku, i.name, ar.rev_no, ar.record_id, ar.created_at
+FROM assembly_records ar
+JOIN items i ON i.item_id = ar.item_id
+WHERE ar.item_id = ?`
+		args := []any{parentID}
+		if v := strings.TrimSpace(r.URL.Query().Get("rev_no")); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid rev_no", http.StatusBadRequest)
+				return
+			}
+			query += ` AND ar.rev_no = ?`
+			args = append(args, n)
+		} else if v := strings.TrimSpace(r.URL.Query().Get("as_of")); v != "" {
+			asOf, err := parseBOMAsOf(v)
+			if err != nil {
+				http.Error(w, "invalid as_of", http.StatusBadRequest)
+				return
+			}
+			query += ` AND ar.obsolete_at IS NULL AND COALESCE(ar.effective_from, ar.created_at) <= ?`
+			args = append(args, asOf)
+		} else {
+			query += ` AND ar.obsolete_at IS NULL`
+		}
+		query += ` ORDER BY ar.rev_no DESC LIMIT 1`
+		if err := dbx.QueryRowContext(r.Context(), query, args...).Scan(&sku, &name, &revNo, &recordID, &createdAt); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "revision not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load revision", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := dbx.QueryContext(r.Context(), `
+SELECT
+  i.sku, i.name, ac.qty_per_unit, i.managed_unit,
+  i.unit_cost * CASE
+    WHEN i.unit_cost_currency IS NULL THEN 1
+    ELSE (SELECT rate FROM currencies WHERE code = i.unit_cost_currency)
+  END,
+  COALESCE(ac.refs, ''),
+  COALESCE(ac.note, '')
+FROM assembly_components ac
+JOIN items i ON i.item_id = ac.component_item_id
+WHERE ac.record_id = ?
+ORDER BY ac.position, i.sku
+`, recordID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		doc := &pdf.Document{
+			Title:    fmt.Sprintf("BOM %s %s", sku, name),
+			Subtitle: fmt.Sprintf("Rev %d (%s) / printed %s", revNo, createdAt, time.Now().Format("2006-01-02 15:04")),
+			Header:   rs.header,
+			Logo:     rs.logo,
+			Columns: []pdf.Column{
+				{Header: "#", Width: 4, Right: true},
+				{Header: "SKU", Width: 16},
+				{Header: "Name", Width: 22},
+				{Header: "Refs", Width: 12},
+				{Header: "Qty", Width: 8, Right: true},
+				{Header: "Unit", Width: 6},
+				{Header: "Unit cost", Width: 12, Right: true},
+				{Header: "Ext. cost", Width: 12, Right: true},
+				{Header: "Note", Width: 10},
+			},
+		}
+		var total float64
+		missing := 0
+		for rows.Next() {
+			var compSKU, compName, unit, refs, note string
+			var qty float64
+			var unitCost sql.NullFloat64
+			if err := rows.Scan(&compSKU, &compName, &qty, &unit, &unitCost, &refs, &note); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			costCell, extCell := "-", "-"
+			if unitCost.Valid {
+				costCell = formatMoney(unitCost.Float64)
+				extCell = formatMoney(unitCost.Float64 * qty)
+				total += unitCost.Float64 * qty
+			} else {
+				missing++
+			}
+			doc.Rows = append(doc.Rows, []string{
+				strconv.Itoa(len(doc.Rows) + 1), compSKU, compName, refs, formatQty(qty), unit, costCell, extCell, note,
+			})
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		doc.Totals = []string{
+			fmt.Sprintf("Components: %d", len(doc.Rows)),
+			fmt.Sprintf("Total cost per unit: %s %s", formatMoney(total), base),
+		}
+		if missing > 0 {
+			doc.Totals = append(doc.Totals, fmt.Sprintf("(%d components without cost)", missing))
+		}
+		servePDF(w, doc, fmt.Sprintf("%s-rev%d-bom.pdf", sku, revNo))
+	}
+}
+
+// stockPDF prints on-hand stock of stock-managed items, optionally for one
+// ?item_type=, valued in the base currency.
+func stockPDF(dbx *sql.DB, rs reportStyle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemType := strings.TrimSpace(r.URL.Query().Get("item_type"))
+		if itemType != "" && itemType != "assembly" && itemType != "component" {
+			http.Error(w, "item_type must be assembly or component", http.StatusBadRequest)
+			return
+		}
+		base, err := baseCurrency(r.Context(), dbx)
+		if err != nil {
+			http.Error(w, "failed to load base currency", http.StatusInternalServerError)
+			return
+		}
+
+		sb := strings.Builder{}
+		sb.WriteString(`
+SELECT
+  i.sku, i.name, i.item_type, i.managed_unit, i.reorder_point,
+  COALESCE(SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END), 0) AS stock_qty,
+  i.unit_cost * CASE
+    WHEN i.unit_cost_currency IS NULL THEN 1
+    ELSE (SELECT rate FROM currencies WHERE code = i.unit_cost_currency)
+  END
+FROM items i
+LEFT JOIN stock_transactions st ON st.item_id = i.item_id
+WHERE i.stock_managed = 1`)
+		args := make([]any, 0)
+		if itemType != "" {
+			sb.WriteString(` AND i.item_type = ?`)
+			args = append(args, itemType)
+		}
+		sb.WriteString(`
+GROUP BY i.item_id
+ORDER BY i.sku
+`)
+		rows, err := dbx.QueryContext(r.Context(), sb.String(), args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		subtitle := "All stock-managed items"
+		if itemType != "" {
+			subtitle = "Item type: " + itemType
+		}
+		doc := &pdf.Document{
+			Title:    "Stock report",
+			Subtitle: fmt.Sprintf("%s / printed %s", subtitle, time.Now().Format("2006-01-02 15:04")),
+			Header:   rs.header,
+			Logo:     rs.logo,
+			Columns: []pdf.Column{
+				{Header: "SKU", Width: 16},
+				{Header: "Name", Width: 30},
+				{Header: "Type", Width: 10},
+				{Header: "Stock", Width: 10, Right: true},
+				{Header: "Unit", Width: 6},
+				{Header: "Reorder", Width: 9, Right: true},
+				{Header: "Unit cost", Width: 12, Right: true},
+				{Header: "Value", Width: 14, Right: true},
+			},
+		}
+		var total float64
+		below := 0
+		for rows.Next() {
+			var sku, name, typ, unit string
+			var reorder, unitCost sql.NullFloat64
+			var qty float64
+			if err := rows.Scan(&sku, &name, &typ, &unit, &reorder, &qty, &unitCost); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			reorderCell, costCell, valueCell := "", "-", "-"
+			if reorder.Valid && reorder.Float64 > 0 {
+				reorderCell = formatQty(reorder.Float64)
+				if qty < reorder.Float64 {
+					below++
+					reorderCell = "! " + reorderCell
+				}
+			}
+			if unitCost.Valid {
+				costCell = formatMoney(unitCost.Float64)
+				valueCell = formatMoney(unitCost.Float64 * qty)
+				total += unitCost.Float64 * qty
+			}
+			doc.Rows = append(doc.Rows, []string{sku, name, typ, formatQty(qty), unit, reorderCell, costCell, valueCell})
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		doc.Totals = []string{
+			fmt.Sprintf("Items: %d (below reorder point: %d)", len(doc.Rows), below),
+			fmt.Sprintf("Total value: %s %s", formatMoney(total), base),
+		}
+		servePDF(w, doc, "stock-"+time.Now().Format("20060102")+".pdf")
+	}
+}
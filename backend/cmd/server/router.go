@@ -0,0 +1,243 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"stockmate/internal/config"
+	"stockmate/internal/events"
+	"stockmate/internal/middleware"
+	"stockmate/internal/notify"
+	"stockmate/internal/oidc"
+	"stockmate/internal/storage"
+	"stockmate/internal/store"
+)
+
+// deps are the long-lived services the handlers are built on; main wires
+// them from the config, tests from an in-memory database.
+type deps struct {
+	conn        *sql.DB
+	store       *store.Store
+	attachments storage.Store
+	reports     reportStyle
+	readOnly    *readOnlyMode
+	syncer      *shopSyncer
+	mailer      *notify.Mailer
+	// sso is nil unless OpenID Connect sign-in is configured.
+	sso *oidc.Provider
+}
+
+// newRouter mounts the middleware and every API route. The frontend is left
+// to the caller, which sets it as the NotFound handler.
+func newRouter(cfg config.Config, d deps) *chi.Mux {
+	conn, st, attachments, reports := d.conn, d.store, d.attachments, d.reports
+	readOnly, syncer, mailer := d.readOnly, d.syncer, d.mailer
+
+	r := chi.NewRouter()
+	if len(cfg.TrustedProxies) > 0 {
+		r.Use(middleware.ProxyHeaders(cfg.TrustedProxies))
+	}
+	r.Use(middleware.CORS(middleware.CORSPolicy{
+		Origins:          cfg.CORSOrigins,
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           cfg.CORSMaxAge,
+	}))
+
+	if cfg.CompressMinBytes >= 0 {
+		r.Use(middleware.Compress(cfg.CompressMinBytes))
+	}
+	// Inside Compress so the ID appended to error bodies is compressed with
+	// them; ahead of everything that can reject a request.
+	r.Use(middleware.RequestID)
+	if cfg.RateLimitRPS > 0 {
+		r.Use(middleware.NewRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst).Middleware)
+	}
+	r.Use(middleware.MaxBody(cfg.MaxBodyBytes, maxUploadBytes))
+	r.Use(authenticate(conn, cfg.APIKeysRequired || cfg.OIDCEnabled()))
+	r.Use(readOnly.Middleware)
+	r.Use(dryRun)
+	if cfg.OutboxWebhookURL != "" {
+		r.Use(recordChanges)
+	}
+	if cfg.QueryTimeout > 0 {
+		r.Use(middleware.Timeout(cfg.QueryTimeout, longLived))
+	}
+	broker := events.NewBroker()
+	r.Use(publishChanges(broker))
+
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	r.Get("/healthz", healthz)
+	r.Get("/readyz", readyz(conn))
+	r.Get("/version", versionInfo)
+	r.Get("/auth/login", ssoLogin(d.sso))
+	r.Get("/auth/callback", ssoCallback(conn, d.sso, cfg))
+	r.Post("/auth/logout", ssoLogout(conn))
+	r.Get("/api/me", getMe)
+
+	if cfg.AppEnv == "dev" {
+		r.Get("/debug/dsn", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, cfg.DSN)
+		})
+	}
+
+	r.Post("/api/items", createItem(conn))
+	r.Get("/api/items", listItems(conn))
+	r.Post("/api/items/lookup", lookupItems(conn))
+	r.Get("/api/items/picker", listPickerItems(conn))
+	r.Get("/api/assemblies", listAssemblies(conn))
+	r.Get("/api/assemblies/{id}/components", getAssemblyComponents(conn))
+	r.Put("/api/assemblies/{id}/components", createAssemblyComponentsRevision(conn, cfg.BOMMaxComponents))
+	r.Post("/api/bom/replace-component", replaceBOMComponent(conn))
+	r.Get("/api/bom-templates", listBOMTemplates(conn))
+	r.Post("/api/bom-templates", createBOMTemplate(conn, cfg.BOMMaxComponents))
+	r.Get("/api/bom-templates/{id}", getBOMTemplate(conn))
+	r.Delete("/api/bom-templates/{id}", deleteBOMTemplate(conn))
+	r.Delete("/api/assemblies/{id}/components/{rev}", deleteAssemblyComponentsRevision(conn))
+	r.Post("/api/assemblies/{id}/components/{rev}/restore", restoreAssemblyComponentsRevision(conn))
+	r.Post("/api/purchase-links/{id}/restore", restorePurchaseLink(conn))
+	r.Get("/api/trash", listTrash(conn, cfg.TrashRetention))
+	r.Get("/api/assemblies/{id}/bom.csv", exportBOMCSV(conn))
+	r.Get("/api/assemblies/{id}/bom-tree.csv", exportBOMTreeCSV(conn))
+	r.Get("/api/assemblies/{id}/bom.pdf", bomPDF(conn, reports))
+	r.Post("/api/assemblies/{id}/bom/preview", previewBOMCSV(conn))
+	r.Post("/api/assemblies/{id}/bom/import", importBOMCSV(conn))
+	r.Get("/api/import-templates/{kind}.csv", getImportTemplate())
+	r.Get("/api/assemblies/{id}/weight", getAssemblyWeight(conn))
+	r.Post("/api/assemblies/{id}/weight/recalculate", recalculateAssemblyWeight(conn))
+	r.Get("/api/assemblies/stock", listItemStock(conn, "assembly"))
+	r.Get("/api/components/stock", listItemStock(conn, "component"))
+	r.Get("/api/stock/summary", listStockSummary(conn))
+	r.Get("/api/alerts/low-stock", listLowStockAlerts(st))
+	r.Put("/api/items/{id}/low-stock-snooze", snoozeLowStock(st))
+	r.Delete("/api/items/{id}/low-stock-snooze", unsnoozeLowStock(st))
+	r.Get("/api/items/{id}/bins", listItemBins(conn))
+	r.Post("/api/items/{id}/bins/transfer", transferItemBin(conn))
+	r.Put("/api/items/{id}/bins/{code}", putItemBin(conn))
+	r.Delete("/api/items/{id}/bins/{code}", deleteItemBin(conn))
+	r.Post("/api/assemblies/{id}/adjust", adjustAssemblyStock(st))
+	r.Get("/api/assemblies/{id}/picklist", getPicklist(conn))
+	r.Post("/api/assemblies/{id}/picklist", commitPicklist(conn))
+	r.Get("/api/production/parts", listProductionParts(conn))
+	r.Post("/api/production/parts/{id}/complete", completePartProduction(st))
+	r.Get("/api/production/components", listProductionComponents(conn))
+	r.Post("/api/production/components/complete", completeProductionComponents(conn))
+	r.Get("/api/production/shipments/assemblies", listShippingAssemblies(conn))
+	r.Post("/api/production/shipments/complete", completeShipments(conn))
+	r.Get("/api/items/{id}", getItem(conn))
+	r.Put("/api/items/{id}", updateItem(conn))
+	r.Delete("/api/items/{id}", deleteItem(conn, attachments))
+	r.Get("/api/items/{id}/dependencies", getItemDependencies(conn))
+	r.Put("/api/items/{id}/lifecycle", setItemLifecycle(conn))
+	r.Get("/api/items/{id}/attachments", listItemAttachments(conn))
+	r.Post("/api/items/{id}/attachments", uploadItemAttachment(conn, attachments))
+	r.Get("/api/attachments/{id}", downloadAttachment(conn, attachments))
+	r.Delete("/api/attachments/{id}", deleteAttachment(conn, attachments))
+	r.Get("/api/custom-fields", listCustomFields(conn))
+	r.Post("/api/custom-fields", createCustomField(conn))
+	r.Put("/api/custom-fields/{id}", updateCustomField(conn))
+	r.Delete("/api/custom-fields/{id}", deleteCustomField(conn))
+	r.Put("/api/items/{id}/custom-fields", setItemCustomFields(conn))
+	r.Get("/api/items/{id}/forecast", getItemForecast(conn))
+	r.Get("/api/items/{id}/negative-stock-policy", getItemNegativeStockPolicy(conn))
+	r.Put("/api/items/{id}/negative-stock-policy", setItemNegativeStockPolicy(conn))
+	r.Get("/api/settings/negative-stock", getNegativeStockSetting(conn))
+	r.Put("/api/settings/negative-stock", setNegativeStockSetting(conn))
+	r.Get("/api/settings/eco", getECOSetting(conn))
+	r.Put("/api/settings/eco", setECOSetting(conn))
+	r.Get("/api/settings/bom-change-note", getChangeNoteSetting(conn))
+	r.Put("/api/settings/bom-change-note", setChangeNoteSetting(conn))
+	r.Get("/api/ecos", listECOs(conn))
+	r.Post("/api/ecos", createECO(conn))
+	r.Get("/api/ecos/{id}", getECO(conn))
+	r.Get("/api/ecos/{id}/assemblies", listECOAssemblies(conn))
+	r.Put("/api/ecos/{id}/changes/{itemID}", putECOChange(conn, cfg.BOMMaxComponents))
+	r.Delete("/api/ecos/{id}/changes/{itemID}", deleteECOChange(conn))
+	r.Post("/api/ecos/{id}/approve", approveECO(conn, cfg.BOMMaxComponents))
+	r.Post("/api/ecos/{id}/cancel", cancelECO(conn))
+	r.Get("/api/settings/base-currency", getBaseCurrencySetting(conn))
+	r.Put("/api/settings/base-currency", setBaseCurrencySetting(conn))
+	r.Get("/api/currencies", listCurrencies(conn))
+	r.Put("/api/currencies/{code}", upsertCurrency(conn))
+	r.Delete("/api/currencies/{code}", deleteCurrency(conn))
+	r.Get("/api/landed-costs", listLandedCosts(conn))
+	r.Post("/api/landed-costs", createLandedCost(conn))
+	r.Get("/api/landed-costs/{id}", getLandedCost(conn))
+	r.Get("/api/suppliers", listSuppliers(conn))
+	r.Post("/api/suppliers", createSupplier(conn))
+	r.Get("/api/suppliers/{id}", getSupplier(conn))
+	r.Put("/api/suppliers/{id}", updateSupplier(conn))
+	r.Delete("/api/suppliers/{id}", deleteSupplier(conn))
+	r.Get("/api/items/{id}/offers", listItemOffers(conn))
+	r.Put("/api/items/{id}/offers/{supplierID}", upsertItemOffer(conn))
+	r.Delete("/api/items/{id}/offers/{supplierID}", deleteItemOffer(conn))
+	r.Get("/api/items/{id}/best-offer", getBestOffer(conn))
+	r.Get("/api/purchase-orders/lines", listPurchaseOrderLines(conn))
+	r.Post("/api/purchase-orders/lines", createPurchaseOrderLine(conn))
+	r.Put("/api/purchase-orders/lines/{id}", updatePurchaseOrderLine(conn))
+	r.Post("/api/purchase-orders/lines/{id}/cancel", cancelPurchaseOrderLine(conn))
+	r.Get("/api/items/{id}/listings", listItemListings(conn))
+	r.Put("/api/items/{id}/listings/{channel}", upsertItemListing(conn))
+	r.Delete("/api/items/{id}/listings/{channel}", deleteItemListing(conn))
+	r.Get("/api/integrations/shop-sync", getShopSyncStatus(conn, syncer))
+	r.Post("/api/integrations/shop-sync/run", runShopSync(conn, syncer))
+	r.Post("/api/integrations/orders/webhook", receiveOrderWebhook(conn, cfg.OrderWebhookSecret))
+	r.Get("/api/integrations/orders/dead-letters", listOrderDeadLetters(conn))
+	r.Post("/api/integrations/orders/dead-letters/{id}/retry", retryOrderDeadLetter(conn))
+	r.Post("/api/integrations/orders/dead-letters/{id}/dismiss", dismissOrderDeadLetter(conn))
+	r.Get("/api/series", listSeries(conn))
+	r.Post("/api/series", createSeries(conn))
+	r.Get("/api/series/{id}/items", listSeriesItems(conn))
+	r.Get("/api/items/by-series", listItemsBySeries(conn))
+	r.Get("/api/skus/patterns", listSKUPatterns(conn))
+	r.Put("/api/skus/patterns", upsertSKUPattern(conn))
+	r.Post("/api/skus/next", nextSKU(conn))
+	r.Get("/api/items/{id}/ledger", getItemLedger(conn))
+	r.Get("/api/transactions", listTransactions(conn))
+	gqlSchema := newGraphQLSchema(conn)
+	r.Get(graphQLPath, serveGraphQL(gqlSchema))
+	r.Post(graphQLPath, serveGraphQL(gqlSchema))
+	r.Post("/api/transactions/{id}/reverse", reverseTransaction(conn))
+	r.Post("/api/transactions/{id}/correct", correctTransaction(conn))
+	r.Get("/api/reason-codes", listReasonCodes(conn))
+	r.Put("/api/reason-codes/{code}", upsertReasonCode(conn))
+	r.Get("/api/units", listUnitRules(conn))
+	r.Put("/api/units/{unit}", updateUnitRule(conn))
+	r.Get("/api/reports/stock-reasons", reportStockReasons(conn))
+	r.Post("/api/plans/requirements", planRequirements(conn))
+	r.Get("/api/reports/stock.pdf", stockPDF(conn, reports))
+	r.Get("/api/stocktakes", listStocktakes(conn))
+	r.Post("/api/stocktakes", createStocktake(conn))
+	r.Get("/api/stocktakes/{id}/sheet.pdf", stocktakeSheetPDF(conn, reports))
+	r.Get("/api/stocktakes/{id}/sheet.csv", stocktakeSheetCSV(conn))
+	r.Get("/api/reports/stock-history", reportStockHistory(conn))
+	r.Get("/api/reports/incoming", reportIncoming(conn))
+	r.Get("/api/reports/builds", reportBuilds(conn))
+	r.Get("/api/events", streamEvents(broker))
+	r.Get("/api/activity", getActivity(conn))
+	r.Get("/api/admin/db/check", checkDatabase(conn))
+	r.Get("/api/admin/orphans", getOrphans(conn))
+	r.Post("/api/admin/orphans", cleanOrphans(conn))
+	r.Post("/api/admin/db/maintenance", maintainDatabase(st))
+	r.Post("/api/admin/stock/rebuild", rebuildStock(st))
+	r.Post("/api/admin/stock/archive", archiveTransactions(st))
+	r.Get(readOnlyPath, getReadOnlyMode(readOnly))
+	r.Put(readOnlyPath, setReadOnlyMode(conn, readOnly))
+	r.Get("/api/admin/ledger/append-only", getLedgerAppendOnly(conn))
+	r.Put("/api/admin/ledger/append-only", setLedgerAppendOnly(conn))
+	r.Get("/api/admin/api-keys", listAPIKeys(conn))
+	r.Post("/api/admin/api-keys", createAPIKey(conn))
+	r.Delete("/api/admin/api-keys/{id}", revokeAPIKey(conn))
+	r.Get("/api/admin/users", listUsers(conn))
+	r.Put("/api/admin/users/{id}", updateUser(conn))
+	r.Post("/api/admin/snapshots", takeStockSnapshot(st))
+	r.Post("/api/admin/notifications/test", sendTestNotification(mailer))
+	r.Post("/api/admin/notifications/low-stock", sendLowStockDigest(st, mailer))
+	r.Get("/api/admin/export", exportBundle(conn))
+	r.Post("/api/admin/import", importBundle(conn))
+
+	return r
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// grossQtyPerUnit is how much of a component one good unit of the parent
+// consumes once losses are included: the line's scrap_factor (0.03 = 3% of
+// the material is lost) and the revision's yield (0.95 = 95% of builds are
+// good).
+func grossQtyPerUnit(qtyPerUnit, scrapFactor, yield float64) float64 {
+	if yield <= 0 {
+		yield = 1
+	}
+	return qtyPerUnit * (1 + scrapFactor) / yield
+}
+
+// latestBOMYield returns the yield of the parent's latest revision, or 1 when
+// it has none, so a new revision keeps the yield unless it sets one.
+func latestBOMYield(ctx context.Context, q queryer, parentItemID int64) (float64, error) {
+	var y float64
+	err := q.QueryRowContext(ctx, `
+SELECT yield FROM assembly_records WHERE item_id = ? AND obsolete_at IS NULL ORDER BY rev_no DESC LIMIT 1
+`, parentItemID).Scan(&y)
+	if err == sql.ErrNoRows {
+		return 1, nil
+	}
+	return y, err
+}
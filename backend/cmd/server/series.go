@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+)
+
+type Series struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	ItemCount int64  `json:"item_count"`
+}
+
+type SeriesGroup struct {
+	SeriesID   *int64 `json:"series_id"`
+	SeriesName string `json:"series_name"`
+	Items      []Item `json:"items"`
+}
+
+func listSeries(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := dbx.QueryContext(r.Context(), `
+SELECT s.series_id, s.name, COUNT(i.item_id)
+FROM series s
+LEFT JOIN items i ON i.series_id = s.series_id
+GROUP BY s.series_id, s.name
+ORDER BY s.name COLLATE NOCASE, s.series_id
+`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]Series, 0)
+		for rows.Next() {
+			var row Series
+			if err := rows.Scan(&row.ID, &row.Name, &row.ItemCount); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, row)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func createSeries(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Name string `json:"name"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, "name required", http.StatusBadRequest)
+			return
+		}
+
+		res, err := store.Exec(r.Context(), dbx, `INSERT INTO series(name) VALUES(?)`, req.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, _ := res.LastInsertId()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(Series{ID: id, Name: req.Name})
+	}
+}
+
+// querySeriesItems returns the catalog view of items: grouped by series name,
+// and within a series by sku so variants (FOO-S, FOO-M, FOO-L) stay together.
+func querySeriesItems(ctx context.Context, dbx *sql.DB, seriesID *int64) ([]Item, error) {
+	query := `
+SELECT
+  i.item_id,
+  i.series_id,
+  s.name,
+  i.sku,
+  i.name,
+  i.item_type,
+  i.managed_unit,
+  i.stock_managed,
+  i.is_sellable,
+  i.is_final,
+  i.lifecycle_status,
+  i.updated_at
+FROM items i
+LEFT JOIN series s ON s.series_id = i.series_id
+`
+	args := make([]any, 0)
+	if seriesID != nil {
+		query += " WHERE i.series_id = ?"
+		args = append(args, *seriesID)
+	}
+	query += " ORDER BY s.name IS NULL, s.name COLLATE NOCASE, s.series_id, i.sku COLLATE NOCASE, i.item_id"
+
+	rows, err := dbx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Item, 0)
+	for rows.Next() {
+		var it Item
+		var sid sql.NullInt64
+		var seriesName sql.NullString
+		var sm, sellable, final int
+		if err := rows.Scan(
+			&it.ID,
+			&sid,
+			&seriesName,
+			&it.SKU,
+			&it.Name,
+			&it.ItemType,
+			&it.ManagedUnit,
+			&sm,
+			&sellable,
+			&final,
+			&it.LifecycleStatus,
+			&it.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if sid.Valid {
+			v := sid.Int64
+			it.SeriesID = &v
+		}
+		if seriesName.Valid {
+			it.SeriesName = seriesName.String
+		}
+		it.StockManaged = sm != 0
+		it.IsSellable = sellable != 0
+		it.IsFinal = final != 0
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+func listSeriesItems(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		seriesID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || seriesID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var exists int
+		if err := dbx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM series WHERE series_id = ?`, seriesID).Scan(&exists); err != nil {
+			http.Error(w, "failed to load series", http.StatusInternalServerError)
+			return
+		}
+		if exists == 0 {
+			http.Error(w, "series not found", http.StatusNotFound)
+			return
+		}
+
+		out, err := querySeriesItems(r.Context(), dbx, &seriesID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func listItemsBySeries(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, err := querySeriesItems(r.Context(), dbx, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Items are already ordered by series, so consecutive runs form the groups.
+		out := make([]SeriesGroup, 0)
+		for _, it := range items {
+			n := len(out)
+			if n == 0 || !sameSeries(out[n-1].SeriesID, it.SeriesID) {
+				out = append(out, SeriesGroup{
+					SeriesID:   it.SeriesID,
+					SeriesName: it.SeriesName,
+					Items:      make([]Item, 0),
+				})
+				n++
+			}
+			out[n-1].Items = append(out[n-1].Items, it)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func sameSeries(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
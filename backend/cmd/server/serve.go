@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+	"stockmate/internal/config"
+	"stockmate/internal/middleware"
+)
+
+// cookiePolicy carries the cookie attributes chosen by the config so
+// handlers that issue cookies don't each need to consult it.
+var cookiePolicy middleware.CookiePolicy
+
+// serve runs the HTTP server in the mode selected by the config: plain HTTP,
+// HTTPS from a cert/key pair, or HTTPS with ACME-issued certificates. When
+// ctx is cancelled it stops accepting requests, gives in-flight ones
+// cfg.ShutdownTimeout to finish, then cancels their contexts so any queries
+// still running are abandoned.
+func serve(ctx context.Context, cfg config.Config, handler http.Handler) error {
+	base, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return base },
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		fmt.Println("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		go func() {
+			<-shutdownCtx.Done()
+			cancelBase()
+		}()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	err := listen(srv, cfg)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-done
+		return nil
+	}
+	return err
+}
+
+func listen(srv *http.Server, cfg config.Config) error {
+	if !cfg.TLSEnabled() {
+		fmt.Println("listening on", srv.Addr)
+		return srv.ListenAndServe()
+	}
+
+	redirect := http.Handler(http.HandlerFunc(redirectToHTTPS(cfg.Port)))
+	if len(cfg.AutocertDomains) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+		}
+		srv.TLSConfig = m.TLSConfig()
+		redirect = m.HTTPHandler(redirect)
+		fmt.Println("autocert domains:", cfg.AutocertDomains)
+	} else {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if cfg.HTTPRedirectPort > 0 {
+		go func() {
+			addr := fmt.Sprintf(":%d", cfg.HTTPRedirectPort)
+			fmt.Println("redirecting http on", addr)
+			if err := http.ListenAndServe(addr, redirect); err != nil {
+				fmt.Println("http redirect listener stopped:", err)
+			}
+		}()
+	}
+
+	fmt.Println("listening (tls) on", srv.Addr)
+	return srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+}
+
+func redirectToHTTPS(port int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/shopsync"
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+// ChannelListing links an item to its listing on an e-commerce channel.
+type ChannelListing struct {
+	ItemID        int64         `json:"item_id"`
+	Channel       string        `json:"channel"`
+	ExternalID    string        `json:"external_id"`
+	LastSyncedQty *int64        `json:"last_synced_qty"`
+	LastSyncedAt  timeutil.Time `json:"last_synced_at"`
+	LastAttemptAt timeutil.Time `json:"last_attempt_at"`
+	LastError     string        `json:"last_error,omitempty"`
+}
+
+// ShopSyncEntry is a listing with the quantity the next sync would push.
+// Pending is true when that differs from what was last pushed or the last
+// push failed.
+type ShopSyncEntry struct {
+	ChannelListing
+	SKU      string  `json:"sku"`
+	Name     string  `json:"name"`
+	StockQty float64 `json:"stock_qty"`
+	PushQty  int64   `json:"push_qty"`
+	Pending  bool    `json:"pending"`
+}
+
+type ShopSyncResult struct {
+	Channel string `json:"channel"`
+	Pushed  int    `json:"pushed"`
+	Skipped int    `json:"skipped"`
+	Failed  int    `json:"failed"`
+}
+
+// shopSyncer pushes stock of the configured channel's listings. adapter is
+// nil when syncing is not configured. Runs never overlap.
+type shopSyncer struct {
+	adapter shopsync.Adapter
+	mu      sync.Mutex
+}
+
+// pushQty is the whole, non-negative quantity a platform is told about.
+func pushQty(stock float64) int64 {
+	return int64(math.Floor(math.Max(stock, 0)))
+}
+
+// loadShopSyncEntries lists the listings of sellable final assemblies, or of
+// one channel when channel is set.
+func loadShopSyncEntries(ctx context.Context, q queryer, channel string) ([]ShopSyncEntry, error) {
+	rows, err := q.QueryContext(ctx, `
+SELECT
+  l.item_id, l.channel, l.external_id, l.last_synced_qty, l.last_synced_at, l.last_attempt_at, l.last_error,
+  i.sku, i.name,
+  COALESCE((
+    SELECT SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END)
+    FROM stock_transactions st WHERE st.item_id = l.item_id
+  ), 0)
+FROM channel_listings l
+JOIN items i ON i.item_id = l.item_id
+WHERE i.item_type = 'assembly' AND i.is_sellable = 1 AND i.is_final = 1 AND i.stock_managed = 1
+  AND (?1 = '' OR l.channel = ?1)
+ORDER BY l.channel, i.sku
+`, channel)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ShopSyncEntry, 0)
+	for rows.Next() {
+		var e ShopSyncEntry
+		var lastQty sql.NullInt64
+		var lastErr sql.NullString
+		if err := rows.Scan(&e.ItemID, &e.Channel, &e.ExternalID, &lastQty, &e.LastSyncedAt, &e.LastAttemptAt, &lastErr,
+			&e.SKU, &e.Name, &e.StockQty); err != nil {
+			return nil, err
+		}
+		if lastQty.Valid {
+			v := lastQty.Int64
+			e.LastSyncedQty = &v
+		}
+		e.LastError = lastErr.String
+		e.PushQty = pushQty(e.StockQty)
+		e.Pending = e.LastSyncedQty == nil || *e.LastSyncedQty != e.PushQty || lastErr.Valid
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// run pushes every pending listing of the adapter's channel, or every listing
+// when force is set. A failed push is recorded on the listing and does not
+// stop the others; err is only set when the database fails.
+func (s *shopSyncer) run(ctx context.Context, dbx *sql.DB, force bool) (ShopSyncResult, error) {
+	channel := s.adapter.Channel()
+	res := ShopSyncResult{Channel: channel}
+	entries, err := loadShopSyncEntries(ctx, dbx, channel)
+	if err != nil {
+		return res, err
+	}
+	for _, e := range entries {
+		if !force && !e.Pending {
+			res.Skipped++
+			continue
+		}
+		if pushErr := s.adapter.SetStock(ctx, e.ExternalID, e.PushQty); pushErr != nil {
+			res.Failed++
+			if _, err := dbx.ExecContext(ctx, `
+UPDATE channel_listings SET last_error = ?, last_attempt_at = strftime('%Y-%m-%dT%H:%M:%SZ','now')
+WHERE item_id = ? AND channel = ?
+`, pushErr.Error(), e.ItemID, channel); err != nil {
+				return res, err
+			}
+			continue
+		}
+		res.Pushed++
+		if _, err := dbx.ExecContext(ctx, `
+UPDATE channel_listings SET
+  last_synced_qty = ?,
+  last_synced_at = strftime('%Y-%m-%dT%H:%M:%SZ','now'),
+  last_attempt_at = strftime('%Y-%m-%dT%H:%M:%SZ','now'),
+  last_error = NULL
+WHERE item_id = ? AND channel = ?
+`, e.PushQty, e.ItemID, channel); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// tryRun is run unless another run is in progress; ok is false then.
+func (s *shopSyncer) tryRun(ctx context.Context, dbx *sql.DB, force bool) (res ShopSyncResult, ok bool, err error) {
+	if !s.mu.TryLock() {
+		return res, false, nil
+	}
+	defer s.mu.Unlock()
+	res, err = s.run(ctx, dbx, force)
+	return res, true, err
+}
+
+func shopSyncJob(dbx *sql.DB, s *shopSyncer) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		res, ok, err := s.tryRun(ctx, dbx, false)
+		if err != nil || !ok {
+			return err
+		}
+		if res.Failed > 0 {
+			return fmt.Errorf("%d %s listings failed to sync", res.Failed, res.Channel)
+		}
+		return nil
+	}
+}
+
+func listingParams(r *http.Request) (int64, string, string) {
+	itemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || itemID <= 0 {
+		return 0, "", "invalid id"
+	}
+	channel := strings.ToLower(chi.URLParam(r, "channel"))
+	if channel != "" && !slices.Contains(shopsync.Channels, channel) {
+		return 0, "", "invalid channel"
+	}
+	return itemID, channel, ""
+}
+
+func listItemListings(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, _, problem := listingParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		rows, err := dbx.QueryContext(r.Context(), `
+SELECT item_id, channel, external_id, last_synced_qty, last_synced_at, last_attempt_at, last_error
+FROM channel_listings
+WHERE item_id = ?
+ORDER BY channel
+`, itemID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]ChannelListing, 0)
+		for rows.Next() {
+			var l ChannelListing
+			var lastQty sql.NullInt64
+			var lastErr sql.NullString
+			if err := rows.Scan(&l.ItemID, &l.Channel, &l.ExternalID, &lastQty, &l.LastSyncedAt, &l.LastAttemptAt, &lastErr); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if lastQty.Valid {
+				v := lastQty.Int64
+				l.LastSyncedQty = &v
+			}
+			l.LastError = lastErr.String
+			out = append(out, l)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// upsertItemListing maps a sellable final assembly to its listing on one
+// channel. Changing the external id forgets the previous sync state.
+func upsertItemListing(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		ExternalID string `json:"external_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, channel, problem := listingParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.ExternalID = strings.TrimSpace(req.ExternalID)
+		if req.ExternalID == "" {
+			http.Error(w, "external_id is required", http.StatusBadRequest)
+			return
+		}
+
+		var itemType string
+		var sellable, final int
+		if err := dbx.QueryRowContext(r.Context(), `
+SELECT item_type, is_sellable, is_final FROM items WHERE item_id = ?
+`, itemID).Scan(&itemType, &sellable, &final); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "item not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load item", http.StatusInternalServerError)
+			return
+		}
+		if itemType != "assembly" || sellable == 0 || final == 0 {
+			http.Error(w, "item must be a sellable final assembly", http.StatusBadRequest)
+			return
+		}
+
+		var owner int64
+		err := dbx.QueryRowContext(r.Context(), `
+SELECT item_id FROM channel_listings WHERE channel = ? AND external_id = ? AND item_id <> ?
+`, channel, req.ExternalID, itemID).Scan(&owner)
+		if err == nil {
+			http.Error(w, fmt.Sprintf("external_id is already mapped to item %d", owner), http.StatusConflict)
+			return
+		}
+		if err != sql.ErrNoRows {
+			http.Error(w, "failed to check listing", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := store.Exec(r.Context(), dbx, `
+INSERT INTO channel_listings(item_id, channel, external_id)
+VALUES(?,?,?)
+ON CONFLICT(item_id, channel) DO UPDATE SET
+  external_id = excluded.external_id,
+  last_synced_qty = CASE WHEN external_id = excluded.external_id THEN last_synced_qty END,
+  last_synced_at = CASE WHEN external_id = excluded.external_id THEN last_synced_at END,
+  last_attempt_at = CASE WHEN external_id = excluded.external_id THEN last_attempt_at END,
+  last_error = CASE WHEN external_id = excluded.external_id THEN last_error END
+`, itemID, channel, req.ExternalID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func deleteItemListing(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, channel, problem := listingParams(r)
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		res, err := store.Exec(r.Context(), dbx, `DELETE FROM channel_listings WHERE item_id = ? AND channel = ?`, itemID, channel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "listing not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getShopSyncStatus reports every listing and whether it awaits a push.
+// ?channel narrows the list; by default it shows all channels.
+func getShopSyncStatus(dbx *sql.DB, s *shopSyncer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channel := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("channel")))
+		if channel != "" && !slices.Contains(shopsync.Channels, channel) {
+			http.Error(w, "invalid channel", http.StatusBadRequest)
+			return
+		}
+		entries, err := loadShopSyncEntries(r.Context(), dbx, channel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		configured := ""
+		if s.adapter != nil {
+			configured = s.adapter.Channel()
+		}
+		pending, failed := 0, 0
+		for _, e := range entries {
+			if e.Channel != configured {
+				continue
+			}
+			if e.Pending {
+				pending++
+			}
+			if e.LastError != "" {
+				failed++
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"channel":  configured,
+			"enabled":  s.adapter != nil,
+			"pending":  pending,
+			"failed":   failed,
+			"listings": entries,
+		})
+	}
+}
+
+// runShopSync pushes pending listings now; ?force=1 pushes every listing.
+func runShopSync(dbx *sql.DB, s *shopSyncer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adapter == nil {
+			http.Error(w, shopsync.ErrNotConfigured.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+		res, ok, err := s.tryRun(r.Context(), dbx, force)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "shop sync is already running", http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
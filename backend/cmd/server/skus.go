@@ -0,0 +1,235 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+type SKUPattern struct {
+	ID        int64          `json:"id"`
+	SeriesID  *int64         `json:"series_id,omitempty"`
+	ItemType  string         `json:"item_type,omitempty"`
+	Prefix    string         `json:"prefix"`
+	PadWidth  int            `json:"pad_width"`
+	NextValue int64          `json:"next_value"`
+	Preview   string         `json:"preview"`
+	UpdatedAt *timeutil.Time `json:"updated_at,omitempty"`
+}
+
+func formatSKU(prefix string, padWidth int, value int64) string {
+	return fmt.Sprintf("%s%0*d", prefix, padWidth, value)
+}
+
+func listSKUPatterns(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := dbx.QueryContext(r.Context(), `
+SELECT pattern_id, series_id, item_type, prefix, pad_width, next_value, updated_at
+FROM sku_patterns
+ORDER BY series_id IS NULL, series_id, item_type
+`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]SKUPattern, 0)
+		for rows.Next() {
+			var p SKUPattern
+			var seriesID sql.NullInt64
+			var itemType sql.NullString
+			if err := rows.Scan(&p.ID, &seriesID, &itemType, &p.Prefix, &p.PadWidth, &p.NextValue, &p.UpdatedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if seriesID.Valid {
+				sid := seriesID.Int64
+				p.SeriesID = &sid
+			}
+			if itemType.Valid {
+				p.ItemType = itemType.String
+			}
+			p.Preview = formatSKU(p.Prefix, p.PadWidth, p.NextValue)
+			out = append(out, p)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func upsertSKUPattern(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		SeriesID  *int64 `json:"series_id"`
+		ItemType  string `json:"item_type"`
+		Prefix    string `json:"prefix"`
+		PadWidth  *int   `json:"pad_width"`
+		NextValue *int64 `json:"next_value"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.ItemType = strings.TrimSpace(req.ItemType)
+		req.Prefix = strings.TrimSpace(req.Prefix)
+		if (req.SeriesID == nil) == (req.ItemType == "") {
+			http.Error(w, "exactly one of series_id or item_type is required", http.StatusBadRequest)
+			return
+		}
+		if req.ItemType != "" && req.ItemType != "component" && req.ItemType != "assembly" {
+			http.Error(w, "item_type must be component or assembly", http.StatusBadRequest)
+			return
+		}
+		if req.Prefix == "" {
+			http.Error(w, "prefix required", http.StatusBadRequest)
+			return
+		}
+		padWidth := 4
+		if req.PadWidth != nil {
+			padWidth = *req.PadWidth
+		}
+		if padWidth < 1 || padWidth > 12 {
+			http.Error(w, "pad_width must be between 1 and 12", http.StatusBadRequest)
+			return
+		}
+		if req.NextValue != nil && *req.NextValue <= 0 {
+			http.Error(w, "next_value must be > 0", http.StatusBadRequest)
+			return
+		}
+
+		var seriesID any = nil
+		var itemType any = nil
+		conflict := "item_type"
+		if req.SeriesID != nil {
+			seriesID = *req.SeriesID
+			conflict = "series_id"
+		} else {
+			itemType = req.ItemType
+		}
+		nextValue := int64(1)
+		if req.NextValue != nil {
+			nextValue = *req.NextValue
+		}
+
+		// next_value is only overwritten when explicitly given, so re-saving a
+		// prefix never rewinds the counter.
+		updateNext := "sku_patterns.next_value"
+		if req.NextValue != nil {
+			updateNext = "excluded.next_value"
+		}
+		if _, err := store.Exec(r.Context(), dbx, fmt.Sprintf(`
+INSERT INTO sku_patterns(series_id, item_type, prefix, pad_width, next_value)
+VALUES(?,?,?,?,?)
+ON CONFLICT(%s) DO UPDATE SET
+  prefix = excluded.prefix,
+  pad_width = excluded.pad_width,
+  next_value = %s,
+  updated_at = strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', 'now')
+`, conflict, updateNext), seriesID, itemType, req.Prefix, padWidth, nextValue); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// nextSKU reserves the next free SKU for a series (preferred) or item_type.
+// The counter is bumped with a single UPDATE ... RETURNING, so concurrent
+// callers always receive distinct values.
+func nextSKU(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		SeriesID *int64 `json:"series_id"`
+		ItemType string `json:"item_type"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		itemType, err := parseItemType(req.ItemType)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var patternID int64
+		if req.SeriesID != nil {
+			err = tx.QueryRowContext(r.Context(), `SELECT pattern_id FROM sku_patterns WHERE series_id = ?`, *req.SeriesID).Scan(&patternID)
+		}
+		if req.SeriesID == nil || err == sql.ErrNoRows {
+			err = tx.QueryRowContext(r.Context(), `SELECT pattern_id FROM sku_patterns WHERE item_type = ?`, itemType).Scan(&patternID)
+		}
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "no sku pattern configured", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load sku pattern", http.StatusInternalServerError)
+			return
+		}
+
+		sku := ""
+		for attempt := 0; attempt < 1000; attempt++ {
+			var prefix string
+			var padWidth int
+			var value int64
+			if err := tx.QueryRowContext(r.Context(), `
+UPDATE sku_patterns
+SET next_value = next_value + 1, updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+WHERE pattern_id = ?
+RETURNING prefix, pad_width, next_value - 1
+`, patternID).Scan(&prefix, &padWidth, &value); err != nil {
+				http.Error(w, "failed to reserve sku", http.StatusInternalServerError)
+				return
+			}
+			candidate := formatSKU(prefix, padWidth, value)
+			var taken int
+			if err := tx.QueryRowContext(r.Context(), `SELECT COUNT(1) FROM items WHERE sku = ?`, candidate).Scan(&taken); err != nil {
+				http.Error(w, "failed to check sku", http.StatusInternalServerError)
+				return
+			}
+			if taken == 0 {
+				sku = candidate
+				break
+			}
+		}
+		if sku == "" {
+			http.Error(w, "could not find a free sku", http.StatusConflict)
+			return
+		}
+
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"sku":        sku,
+			"pattern_id": patternID,
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"stockmate/internal/store"
+)
+
+type StockHistoryPoint struct {
+	Date     string   `json:"date"`
+	Qty      float64  `json:"qty"`
+	UnitCost *float64 `json:"unit_cost,omitempty"`
+	Value    *float64 `json:"value,omitempty"`
+	// Currency is the base currency Value was converted to.
+	Currency string `json:"currency,omitempty"`
+}
+
+// snapshotToday is the nightly job: it snapshots stock for the current UTC
+// day, matching the UTC timestamps of the ledger.
+func snapshotToday(st *store.Store) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := st.TakeStockSnapshot(ctx, time.Now().UTC().Format(time.DateOnly), time.UTC)
+		return err
+	}
+}
+
+// takeStockSnapshot runs the snapshot on demand, for today or ?date=. The
+// day ends at midnight in ?tz=, UTC by default.
+func takeStockSnapshot(st *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		day := strings.TrimSpace(r.URL.Query().Get("date"))
+		if day == "" {
+			day = time.Now().In(loc).Format(time.DateOnly)
+		}
+		if _, err := time.Parse(time.DateOnly, day); err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		n, err := st.TakeStockSnapshot(r.Context(), day, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"date":  day,
+			"items": n,
+		})
+	}
+}
+
+func reportStockHistory(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("item_id")), 10, 64)
+		if err != nil || itemID <= 0 {
+			http.Error(w, "invalid item_id", http.StatusBadRequest)
+			return
+		}
+		from := strings.TrimSpace(r.URL.Query().Get("from"))
+		to := strings.TrimSpace(r.URL.Query().Get("to"))
+		for _, v := range []string{from, to} {
+			if v == "" {
+				continue
+			}
+			if _, err := time.Parse(time.DateOnly, v); err != nil {
+				http.Error(w, "from/to must be YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+
+		sb := strings.Builder{}
+		sb.WriteString(`
+SELECT snapshot_date, qty, unit_cost, value, COALESCE(currency, '')
+FROM stock_snapshots
+WHERE item_id = ?
+`)
+		args := []any{itemID}
+		if from != "" {
+			sb.WriteString(" AND snapshot_date >= ?")
+			args = append(args, from)
+		}
+		if to != "" {
+			sb.WriteString(" AND snapshot_date <= ?")
+			args = append(args, to)
+		}
+		sb.WriteString(`
+ORDER BY snapshot_date
+`)
+
+		rows, err := dbx.QueryContext(r.Context(), sb.String(), args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		points := make([]StockHistoryPoint, 0)
+		for rows.Next() {
+			var p StockHistoryPoint
+			var unitCost, value sql.NullFloat64
+			if err := rows.Scan(&p.Date, &p.Qty, &unitCost, &value, &p.Currency); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if unitCost.Valid {
+				v := unitCost.Float64
+				p.UnitCost = &v
+			}
+			if value.Valid {
+				v := value.Float64
+				p.Value = &v
+			}
+			points = append(points, p)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"item_id": itemID,
+			"points":  points,
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+
+	"stockmate/internal/querybuilder"
+)
+
+// itemSortColumns are the sort keys of the item lists.
+var itemSortColumns = querybuilder.SortColumns{
+	"id":         "i.item_id",
+	"name":       "i.name COLLATE NOCASE",
+	"sku":        "i.sku COLLATE NOCASE",
+	"created_at": "i.created_at",
+	"updated_at": "i.updated_at",
+}
+
+// stockSortColumns is for lists aggregated from the ledger, where
+// updated_at is the last movement and stock_qty the on-hand quantity.
+var stockSortColumns = querybuilder.SortColumns{
+	"id":         "i.item_id",
+	"name":       "i.name COLLATE NOCASE",
+	"sku":        "i.sku COLLATE NOCASE",
+	"updated_at": "updated_at",
+	"stock_qty":  "stock_qty",
+}
+
+// productionSortColumns is stockSortColumns for the production lists, which
+// read the last movement from the st subquery.
+var productionSortColumns = querybuilder.SortColumns{
+	"id":         "i.item_id",
+	"name":       "i.name COLLATE NOCASE",
+	"sku":        "i.sku COLLATE NOCASE",
+	"updated_at": "st.updated_at",
+	"stock_qty":  "stock_qty",
+}
+
+// orderByClause builds the ORDER BY clause for ?sort= with item_id as the
+// tie-breaker; see querybuilder.OrderBy. Without ?sort= the lists keep their
+// newest-first order.
+func orderByClause(r *http.Request, cols querybuilder.SortColumns) (string, error) {
+	return querybuilder.OrderBy(r.URL.Query().Get("sort"), cols, "i.item_id")
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/config"
+	"stockmate/internal/oidc"
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+	"stockmate/internal/validate"
+)
+
+const (
+	sessionCookie = "stockmate_session"
+	// ssoCookie carries state, nonce and PKCE verifier through the
+	// provider's sign-in page.
+	ssoCookie    = "stockmate_sso"
+	ssoCookieTTL = 10 * time.Minute
+)
+
+// roleRank orders roles so the strongest mapped one wins.
+var roleRank = map[string]int{scopeReadOnly: 1, scopeStockWrite: 2, scopeAdmin: 3}
+
+type User struct {
+	ID          int64         `json:"id"`
+	Email       string        `json:"email"`
+	Name        string        `json:"name"`
+	Role        string        `json:"role"`
+	CreatedAt   timeutil.Time `json:"created_at"`
+	LastLoginAt timeutil.Time `json:"last_login_at"`
+	DisabledAt  timeutil.Time `json:"disabled_at"`
+}
+
+const userSelect = `
+SELECT u.user_id, u.email, u.name, u.role, u.created_at, u.last_login_at, u.disabled_at
+FROM users u
+`
+
+func scanUser(row interface{ Scan(...any) error }) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Email, &u.Name, &u.Role, &u.CreatedAt, &u.LastLoginAt, &u.DisabledAt)
+	return u, err
+}
+
+type userKey struct{}
+
+// userFrom returns the signed-in user of a request, nil for API keys and
+// anonymous requests.
+func userFrom(ctx context.Context) *User {
+	u, _ := ctx.Value(userKey{}).(*User)
+	return u
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sessionUser resolves the session cookie of r to an enabled user; nil
+// when there is no live session.
+func sessionUser(ctx context.Context, dbx *sql.DB, r *http.Request) (*User, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return nil, nil
+	}
+	u, err := scanUser(dbx.QueryRowContext(ctx, userSelect+`
+JOIN sessions s ON s.user_id = u.user_id
+WHERE s.session_hash = ? AND s.expires_at > ? AND u.disabled_at IS NULL
+`, hashAPIKey(c.Value), timeutil.Format(time.Now())))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// mappedRole is the strongest role the user's role claim maps to, "" when
+// nothing maps.
+func mappedRole(cfg config.Config, claims oidc.Claims) string {
+	if cfg.OIDCRoleClaim == "" {
+		return ""
+	}
+	role := ""
+	for _, v := range claims.Strings(cfg.OIDCRoleClaim) {
+		if r := cfg.OIDCRoleMap[v]; roleRank[r] > roleRank[role] {
+			role = r
+		}
+	}
+	return role
+}
+
+// allowedDomain checks the user's email domain, or Google's hosted domain
+// claim, against OIDCAllowedDomains.
+func allowedDomain(cfg config.Config, claims oidc.Claims) bool {
+	if len(cfg.OIDCAllowedDomains) == 0 {
+		return true
+	}
+	if hd := strings.ToLower(claims.String("hd")); hd != "" && slices.Contains(cfg.OIDCAllowedDomains, hd) {
+		return true
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return false
+	}
+	_, domain, ok := strings.Cut(strings.ToLower(claims.String("email")), "@")
+	return ok && slices.Contains(cfg.OIDCAllowedDomains, domain)
+}
+
+// claimCreatedUser links a user made ahead of sign-in (stockmate user
+// create) to the provider account signing in with the same email, which the
+// provider must have verified. Without one it returns sql.ErrNoRows.
+func claimCreatedUser(ctx context.Context, tx *sql.Tx, issuer string, claims oidc.Claims) (int64, sql.NullString, error) {
+	var id int64
+	var disabled sql.NullString
+	email := strings.TrimSpace(claims.String("email"))
+	if verified, _ := claims["email_verified"].(bool); !verified || email == "" {
+		return 0, disabled, sql.ErrNoRows
+	}
+	err := tx.QueryRowContext(ctx, `
+SELECT user_id, disabled_at FROM users WHERE issuer = '' AND subject = lower(?)
+`, email).Scan(&id, &disabled)
+	if err != nil {
+		return 0, disabled, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET issuer = ?, subject = ? WHERE user_id = ?`, issuer, claims.String("sub"), id); err != nil {
+		return 0, disabled, err
+	}
+	return id, disabled, nil
+}
+
+// ssoLogin sends the browser to the OpenID provider to sign in.
+func ssoLogin(p *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p == nil {
+			http.Error(w, "sso is not configured", http.StatusServiceUnavailable)
+			return
+		}
+		var parts [3]string
+		for i := range parts {
+			v, err := randomToken(24)
+			if err != nil {
+				http.Error(w, "failed to start sign-in", http.StatusInternalServerError)
+				return
+			}
+			parts[i] = v
+		}
+		state, nonce, verifier := parts[0], parts[1], parts[2]
+		u, err := p.AuthCodeURL(r.Context(), state, nonce, verifier)
+		if err != nil {
+			log.Printf("sso login: %v", err)
+			http.Error(w, "identity provider is unavailable", http.StatusBadGateway)
+			return
+		}
+		http.SetCookie(w, cookiePolicy.New(ssoCookie, strings.Join(parts[:], "."), ssoCookieTTL))
+		http.Redirect(w, r, u, http.StatusFound)
+	}
+}
+
+// ssoCallback finishes a sign-in: it verifies the ID token, creates or
+// updates the local user and starts a session.
+func ssoCallback(dbx *sql.DB, p *oidc.Provider, cfg config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p == nil {
+			http.Error(w, "sso is not configured", http.StatusServiceUnavailable)
+			return
+		}
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			http.Error(w, "sign-in failed: "+e, http.StatusUnauthorized)
+			return
+		}
+		c, err := r.Cookie(ssoCookie)
+		if err != nil {
+			http.Error(w, "sign-in expired, please try again", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, cookiePolicy.New(ssoCookie, "", -1))
+		parts := strings.Split(c.Value, ".")
+		if len(parts) != 3 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(q.Get("state"))) != 1 {
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+		claims, err := p.Exchange(r.Context(), q.Get("code"), parts[2], parts[1])
+		if err != nil {
+			log.Printf("sso callback: %v", err)
+			http.Error(w, "sign-in failed", http.StatusUnauthorized)
+			return
+		}
+		if !allowedDomain(cfg, claims) {
+			http.Error(w, "this account's domain is not allowed", http.StatusForbidden)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		now := timeutil.Format(time.Now())
+		role := mappedRole(cfg, claims)
+		var userID int64
+		var disabled sql.NullString
+		err = tx.QueryRowContext(r.Context(), `
+SELECT user_id, disabled_at FROM users WHERE issuer = ? AND subject = ?
+`, cfg.OIDCIssuer, claims.String("sub")).Scan(&userID, &disabled)
+		if err == sql.ErrNoRows {
+			userID, disabled, err = claimCreatedUser(r.Context(), tx, cfg.OIDCIssuer, claims)
+		}
+		switch {
+		case err == sql.ErrNoRows:
+			if role == "" {
+				role = cfg.OIDCDefaultRole
+			}
+			res, err := tx.ExecContext(r.Context(), `
+INSERT INTO users(issuer, subject, email, name, role, last_login_at) VALUES(?,?,?,?,?,?)
+`, cfg.OIDCIssuer, claims.String("sub"), claims.String("email"), claims.String("name"), role, now)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			userID, _ = res.LastInsertId()
+		case err != nil:
+			http.Error(w, "failed to load user", http.StatusInternalServerError)
+			return
+		case disabled.Valid:
+			http.Error(w, "this account is disabled", http.StatusForbidden)
+			return
+		default:
+			// A mapped role follows the provider; otherwise the role an
+			// admin set is kept.
+			if _, err := tx.ExecContext(r.Context(), `
+UPDATE users SET email = ?, name = ?, role = COALESCE(NULLIF(?, ''), role), last_login_at = ? WHERE user_id = ?
+`, claims.String("email"), claims.String("name"), role, now, userID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		token, err := randomToken(32)
+		if err != nil {
+			http.Error(w, "failed to start session", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM sessions WHERE expires_at <= ?`, now); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO sessions(session_hash, user_id, expires_at) VALUES(?,?,?)
+`, hashAPIKey(token), userID, timeutil.Format(time.Now().Add(cfg.SessionTTL))); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, cookiePolicy.New(sessionCookie, token, cfg.SessionTTL))
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+// ssoLogout ends the session of the request, if any.
+func ssoLogout(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+			if _, err := store.Exec(r.Context(), dbx, `DELETE FROM sessions WHERE session_hash = ?`, hashAPIKey(c.Value)); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		http.SetCookie(w, cookiePolicy.New(sessionCookie, "", -1))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getMe(w http.ResponseWriter, r *http.Request) {
+	u := userFrom(r.Context())
+	if u == nil {
+		http.Error(w, "not signed in", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(u)
+}
+
+func listUsers(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := dbx.QueryContext(r.Context(), userSelect+` ORDER BY u.user_id`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		out := make([]User, 0)
+		for rows.Next() {
+			u, err := scanUser(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, u)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// updateUser sets a user's role or disables them; disabling also ends
+// their sessions. A role mapped from the provider is reapplied at the
+// user's next sign-in.
+func updateUser(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		var errs validate.Errors
+		if req.Role != nil {
+			errs.OneOf("role", *req.Role, scopeReadOnly, scopeStockWrite, scopeAdmin)
+		}
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		if _, err := scanUser(tx.QueryRowContext(r.Context(), userSelect+` WHERE u.user_id = ?`, id)); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "user not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load user", http.StatusInternalServerError)
+			return
+		}
+		if req.Role != nil {
+			if _, err := tx.ExecContext(r.Context(), `UPDATE users SET role = ? WHERE user_id = ?`, *req.Role, id); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if req.Disabled != nil {
+			if _, err := tx.ExecContext(r.Context(), `
+UPDATE users SET disabled_at = CASE WHEN ? THEN COALESCE(disabled_at, ?) END WHERE user_id = ?
+`, *req.Disabled, timeutil.Format(time.Now()), id); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if *req.Disabled {
+				if _, err := tx.ExecContext(r.Context(), `DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		u, err := scanUser(tx.QueryRowContext(r.Context(), userSelect+` WHERE u.user_id = ?`, id))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(u)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/pdf"
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+	"stockmate/internal/validate"
+)
+
+// stocktakeCategories are the groups count sheets are split into, in print
+// order.
+var stocktakeCategories = []string{"assembly", "material", "part", "consumable"}
+
+// itemCategory is an item's count sheet group: assembly, or its component
+// type.
+const itemCategory = `CASE WHEN i.item_type = 'assembly' THEN 'assembly' ELSE c.component_type END`
+
+type Stocktake struct {
+	ID int64 `json:"id"`
+	// Category limits the count to one group; empty counts every
+	// stock-managed item.
+	Category string `json:"category,omitempty"`
+	Name     string `json:"name"`
+	// Blind hides system quantities on count sheets unless asked for.
+	Blind     bool          `json:"blind"`
+	LineCount int64         `json:"line_count"`
+	CreatedAt timeutil.Time `json:"created_at"`
+}
+
+const stocktakeSelect = `
+SELECT s.stocktake_id, COALESCE(s.category, ''), s.name, s.blind,
+  (SELECT COUNT(1) FROM stocktake_lines l WHERE l.stocktake_id = s.stocktake_id),
+  s.created_at
+FROM stocktakes s
+`
+
+func scanStocktake(row interface{ Scan(...any) error }) (Stocktake, error) {
+	var s Stocktake
+	err := row.Scan(&s.ID, &s.Category, &s.Name, &s.Blind, &s.LineCount, &s.CreatedAt)
+	return s, err
+}
+
+func listStocktakes(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := dbx.QueryContext(r.Context(), stocktakeSelect+` ORDER BY s.stocktake_id DESC`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		out := make([]Stocktake, 0)
+		for rows.Next() {
+			s, err := scanStocktake(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, s)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// createStocktake starts a count of the stock-managed items, or those of
+// one category, freezing their current system quantities.
+func createStocktake(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Name     string `json:"name"`
+		Category string `json:"category"`
+		Blind    bool   `json:"blind"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		req.Category = strings.ToLower(strings.TrimSpace(req.Category))
+		var errs validate.Errors
+		errs.Required("name", req.Name)
+		if req.Category != "" {
+			errs.OneOf("category", req.Category, stocktakeCategories...)
+		}
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var category any
+		if req.Category != "" {
+			category = req.Category
+		}
+		res, err := tx.ExecContext(r.Context(), `
+INSERT INTO stocktakes(name, category, blind) VALUES(?, ?, ?)
+`, req.Name, category, req.Blind)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		id, _ := res.LastInsertId()
+		if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO stocktake_lines(stocktake_id, item_id, system_qty)
+SELECT ?1, i.item_id,
+  COALESCE((
+    SELECT SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END)
+    FROM stock_transactions st
+    WHERE st.item_id = i.item_id
+  ), 0)
+FROM items i
+LEFT JOIN components c ON c.item_id = i.item_id
+WHERE i.stock_managed = 1
+  AND i.lifecycle_status <> 'obsolete'
+  AND (?2 IS NULL OR `+itemCategory+` = ?2)
+`, id, category); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s, err := scanStocktake(tx.QueryRowContext(r.Context(), stocktakeSelect+` WHERE s.stocktake_id = ?`, id))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(s)
+	}
+}
+
+// countSheetLine is one item to count.
+type countSheetLine struct {
+	category  string
+	sku       string
+	name      string
+	unit      string
+	bin       string
+	systemQty float64
+}
+
+// loadCountSheet reads a stocktake and its lines, by category and SKU.
+func loadCountSheet(ctx context.Context, q queryer, r *http.Request) (s Stocktake, lines []countSheetLine, showQty bool, problem string, status int, err error) {
+	id, perr := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if perr != nil || id <= 0 {
+		return s, nil, false, "invalid id", http.StatusBadRequest, nil
+	}
+	s, err = scanStocktake(q.QueryRowContext(ctx, stocktakeSelect+` WHERE s.stocktake_id = ?`, id))
+	if err == sql.ErrNoRows {
+		return s, nil, false, "stocktake not found", http.StatusNotFound, nil
+	}
+	if err != nil {
+		return s, nil, false, "", 0, err
+	}
+	// System quantities follow the stocktake's blind setting unless
+	// ?show_qty= says otherwise.
+	showQty = !s.Blind
+	switch r.URL.Query().Get("show_qty") {
+	case "":
+	case "1", "true":
+		showQty = true
+	case "0", "false":
+		showQty = false
+	default:
+		return s, nil, false, "invalid show_qty", http.StatusBadRequest, nil
+	}
+
+	rows, err := q.QueryContext(ctx, `
+SELECT COALESCE(`+itemCategory+`, ''), i.sku, i.name, i.managed_unit, COALESCE(`+defaultBinSelect+`, ''), l.system_qty
+FROM stocktake_lines l
+JOIN items i ON i.item_id = l.item_id
+LEFT JOIN components c ON c.item_id = i.item_id
+WHERE l.stocktake_id = ?
+ORDER BY CASE `+itemCategory+` WHEN 'assembly' THEN 0 WHEN 'material' THEN 1 WHEN 'part' THEN 2 ELSE 3 END, i.sku
+`, id)
+	if err != nil {
+		return s, nil, false, "", 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l countSheetLine
+		if err := rows.Scan(&l.category, &l.sku, &l.name, &l.unit, &l.bin, &l.systemQty); err != nil {
+			return s, nil, false, "", 0, err
+		}
+		lines = append(lines, l)
+	}
+	return s, lines, showQty, "", 0, rows.Err()
+}
+
+// stocktakeSheetPDF prints count sheets for a stocktake: one group per
+// category, a barcode per SKU and blank columns to write the count in.
+func stocktakeSheetPDF(dbx *sql.DB, rs reportStyle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, lines, showQty, problem, status, err := loadCountSheet(r.Context(), dbx, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+
+		columns := []pdf.Column{
+			{Header: "SKU", Width: 14},
+			{Header: "Barcode", Width: 22, Barcode: true},
+			{Header: "Name", Width: 22},
+			{Header: "Unit", Width: 5},
+			{Header: "Bin", Width: 8},
+		}
+		if showQty {
+			columns = append(columns, pdf.Column{Header: "System", Width: 9, Right: true})
+		}
+		columns = append(columns,
+			pdf.Column{Header: "Count", Width: 10},
+			pdf.Column{Header: "Checked by", Width: 12},
+		)
+		subtitle := fmt.Sprintf("Stocktake #%d / started %s / printed %s", s.ID, s.CreatedAt.Format("2006-01-02 15:04"), time.Now().Format("2006-01-02 15:04"))
+		if !showQty {
+			subtitle += " / blind count"
+		}
+		doc := &pdf.Document{
+			Title:    "Count sheet " + s.Name,
+			Subtitle: subtitle,
+			Header:   rs.header,
+			Logo:     rs.logo,
+			Columns:  columns,
+		}
+		for _, l := range lines {
+			if n := len(doc.Groups); n == 0 || doc.Groups[n-1].Title != l.category {
+				doc.Groups = append(doc.Groups, pdf.Group{Title: l.category})
+			}
+			row := []string{l.sku, l.sku, l.name, l.unit, l.bin}
+			if showQty {
+				row = append(row, formatQty(l.systemQty))
+			}
+			g := &doc.Groups[len(doc.Groups)-1]
+			g.Rows = append(g.Rows, append(row, "", ""))
+		}
+		doc.Totals = []string{fmt.Sprintf("%d items", len(lines))}
+		servePDF(w, doc, fmt.Sprintf("stocktake-%d-sheet.pdf", s.ID))
+	}
+}
+
+// stocktakeSheetCSV is the count sheet as CSV, with an empty counted_qty
+// column to fill in.
+func stocktakeSheetCSV(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, lines, showQty, problem, status, err := loadCountSheet(r.Context(), dbx, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+
+		var buf bytes.Buffer
+		cw := csv.NewWriter(&buf)
+		header := []string{"category", "sku", "name", "unit", "bin"}
+		if showQty {
+			header = append(header, "system_qty")
+		}
+		cw.Write(append(header, "counted_qty"))
+		for _, l := range lines {
+			row := []string{l.category, l.sku, l.name, l.unit, l.bin}
+			if showQty {
+				row = append(row, formatQty(l.systemQty))
+			}
+			cw.Write(append(row, ""))
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="stocktake-%d-sheet.csv"`, s.ID))
+		_, _ = w.Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/querybuilder"
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+	"stockmate/internal/validate"
+)
+
+type Supplier struct {
+	ID           int64         `json:"id"`
+	Name         string        `json:"name"`
+	ContactName  string        `json:"contact_name"`
+	Email        string        `json:"email"`
+	Phone        string        `json:"phone"`
+	URL          string        `json:"url"`
+	LeadTimeDays *int64        `json:"lead_time_days,omitempty"`
+	Note         string        `json:"note"`
+	ItemCount    int64         `json:"item_count"`
+	CreatedAt    timeutil.Time `json:"created_at"`
+	UpdatedAt    timeutil.Time `json:"updated_at"`
+}
+
+type supplierReq struct {
+	Name         string `json:"name"`
+	ContactName  string `json:"contact_name"`
+	Email        string `json:"email"`
+	Phone        string `json:"phone"`
+	URL          string `json:"url"`
+	LeadTimeDays *int64 `json:"lead_time_days"`
+	Note         string `json:"note"`
+}
+
+func (req *supplierReq) normalize() validate.Errors {
+	req.Name = strings.TrimSpace(req.Name)
+	req.ContactName = strings.TrimSpace(req.ContactName)
+	req.Email = strings.TrimSpace(req.Email)
+	req.Phone = strings.TrimSpace(req.Phone)
+	req.URL = strings.TrimSpace(req.URL)
+	req.Note = strings.TrimSpace(req.Note)
+
+	var errs validate.Errors
+	errs.Required("name", req.Name)
+	errs.Check(req.Email == "" || strings.Contains(req.Email, "@"), "email", "must be an email address")
+	errs.Check(req.URL == "" || strings.HasPrefix(req.URL, "http://") || strings.HasPrefix(req.URL, "https://"), "url", "must start with http:// or https://")
+	errs.Check(req.LeadTimeDays == nil || *req.LeadTimeDays >= 0, "lead_time_days", "must be >= 0")
+	return errs
+}
+
+// resolveSupplier returns the supplier_id and manufacturer text to store on a
+// component or assembly. An explicit supplier_id wins and its name becomes
+// the manufacturer text; otherwise a non-empty manufacturer is matched to a
+// supplier by name, creating one when missing.
+func resolveSupplier(ctx context.Context, tx *sql.Tx, supplierID *int64, manufacturer string) (id any, name string, problem string, err error) {
+	manufacturer = strings.TrimSpace(manufacturer)
+	if supplierID != nil {
+		if err := tx.QueryRowContext(ctx, `SELECT name FROM suppliers WHERE supplier_id = ?`, *supplierID).Scan(&name); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, "", "supplier not found", nil
+			}
+			return nil, "", "", err
+		}
+		return *supplierID, name, "", nil
+	}
+	if manufacturer == "" {
+		return nil, "", "", nil
+	}
+	var sid int64
+	err = tx.QueryRowContext(ctx, `SELECT supplier_id FROM suppliers WHERE name = ?`, manufacturer).Scan(&sid)
+	if err == sql.ErrNoRows {
+		res, err := tx.ExecContext(ctx, `INSERT INTO suppliers(name) VALUES(?)`, manufacturer)
+		if err != nil {
+			return nil, "", "", err
+		}
+		sid, _ = res.LastInsertId()
+	} else if err != nil {
+		return nil, "", "", err
+	}
+	return sid, manufacturer, "", nil
+}
+
+const selectSupplier = `
+SELECT
+  s.supplier_id, s.name, s.contact_name, s.email, s.phone, s.url, s.lead_time_days, s.note,
+  (SELECT COUNT(1) FROM components c WHERE c.supplier_id = s.supplier_id)
+    + (SELECT COUNT(1) FROM assemblies a WHERE a.supplier_id = s.supplier_id),
+  s.created_at, s.updated_at
+FROM suppliers s
+`
+
+func scanSupplier(sc interface{ Scan(...any) error }) (Supplier, error) {
+	var s Supplier
+	var leadTime sql.NullInt64
+	if err := sc.Scan(&s.ID, &s.Name, &s.ContactName, &s.Email, &s.Phone, &s.URL, &leadTime, &s.Note, &s.ItemCount, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return s, err
+	}
+	if leadTime.Valid {
+		v := leadTime.Int64
+		s.LeadTimeDays = &v
+	}
+	return s, nil
+}
+
+func listSuppliers(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fields, err := parseFields[Supplier](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var where querybuilder.Where
+		where.Search(r.URL.Query(), "q", "s.name", "s.contact_name")
+		query := selectSupplier + ` WHERE 1=1` + where.SQL() + ` ORDER BY s.name COLLATE NOCASE, s.supplier_id`
+
+		rows, err := dbx.QueryContext(r.Context(), query, where.Args()...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]Supplier, 0)
+		for rows.Next() {
+			s, err := scanSupplier(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, s)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeList(w, fields, out)
+	}
+}
+
+func getSupplier(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		writeSupplier(w, r, dbx, id, http.StatusOK)
+	}
+}
+
+func writeSupplier(w http.ResponseWriter, r *http.Request, dbx *sql.DB, id int64, status int) {
+	s, err := scanSupplier(dbx.QueryRowContext(r.Context(), selectSupplier+` WHERE s.supplier_id = ?`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "supplier not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to load supplier", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(s)
+}
+
+func createSupplier(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req supplierReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if errs := req.normalize(); !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(r.Context(), `
+INSERT INTO suppliers(name, contact_name, email, phone, url, lead_time_days, note)
+VALUES(?,?,?,?,?,?,?)
+`, req.Name, req.ContactName, req.Email, req.Phone, req.URL, req.LeadTimeDays, req.Note)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				http.Error(w, "supplier name already exists", http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, _ := res.LastInsertId()
+		out, err := scanSupplier(tx.QueryRowContext(r.Context(), selectSupplier+` WHERE s.supplier_id = ?`, id))
+		if err != nil {
+			http.Error(w, "failed to load supplier", http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// updateSupplier replaces the supplier's fields. A rename is copied to the
+// manufacturer text of linked components and assemblies.
+func updateSupplier(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var req supplierReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if errs := req.normalize(); !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(r.Context(), `
+UPDATE suppliers
+SET name = ?, contact_name = ?, email = ?, phone = ?, url = ?, lead_time_days = ?, note = ?, updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+WHERE supplier_id = ?
+`, req.Name, req.ContactName, req.Email, req.Phone, req.URL, req.LeadTimeDays, req.Note, id)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				http.Error(w, "supplier name already exists", http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "supplier not found", http.StatusNotFound)
+			return
+		}
+		for _, table := range []string{"components", "assemblies"} {
+			if _, err := tx.ExecContext(r.Context(), `UPDATE `+table+` SET manufacturer = ? WHERE supplier_id = ?`, req.Name, id); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		out, err := scanSupplier(tx.QueryRowContext(r.Context(), selectSupplier+` WHERE s.supplier_id = ?`, id))
+		if err != nil {
+			http.Error(w, "failed to load supplier", http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// deleteSupplier removes a supplier that no item references; ?force=1 unlinks
+// the items first (their manufacturer text is kept).
+func deleteSupplier(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		force := r.URL.Query().Get("force") == "1"
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var refs int64
+		if err := tx.QueryRowContext(r.Context(), `
+SELECT (SELECT COUNT(1) FROM components WHERE supplier_id = ?) + (SELECT COUNT(1) FROM assemblies WHERE supplier_id = ?)
+`, id, id).Scan(&refs); err != nil {
+			http.Error(w, "failed to check references", http.StatusInternalServerError)
+			return
+		}
+		if refs > 0 && !force {
+			http.Error(w, "supplier is referenced by items (use ?force=1 to unlink)", http.StatusConflict)
+			return
+		}
+		for _, table := range []string{"components", "assemblies"} {
+			if _, err := tx.ExecContext(r.Context(), `UPDATE `+table+` SET supplier_id = NULL WHERE supplier_id = ?`, id); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		res, err := tx.ExecContext(r.Context(), `DELETE FROM suppliers WHERE supplier_id = ?`, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "supplier not found", http.StatusNotFound)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
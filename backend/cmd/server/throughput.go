@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"stockmate/internal/timeutil"
+)
+
+type BuildConsumption struct {
+	ItemID      int64   `json:"item_id"`
+	SKU         string  `json:"sku"`
+	Name        string  `json:"name"`
+	ManagedUnit string  `json:"managed_unit"`
+	Qty         float64 `json:"qty"`
+}
+
+// BuildThroughput is what was built of one item in a period. UnitsBuilt
+// counts completed builds; builds recorded by a picklist only consume, so
+// they show up in Components alone.
+type BuildThroughput struct {
+	ItemID     int64              `json:"item_id"`
+	SKU        string             `json:"sku"`
+	Name       string             `json:"name"`
+	UnitsBuilt float64            `json:"units_built"`
+	Components []BuildConsumption `json:"components"`
+}
+
+type BuildPeriod struct {
+	Start string            `json:"start"`
+	Items []BuildThroughput `json:"items"`
+}
+
+// buildTally sums build rows by parent item and component.
+type buildTally struct {
+	parents map[int64]*BuildThroughput
+	used    map[int64]map[int64]*BuildConsumption
+}
+
+func newBuildTally() *buildTally {
+	return &buildTally{parents: map[int64]*BuildThroughput{}, used: map[int64]map[int64]*BuildConsumption{}}
+}
+
+func (t *buildTally) parent(id int64, sku, name string) *BuildThroughput {
+	p, ok := t.parents[id]
+	if !ok {
+		p = &BuildThroughput{ItemID: id, SKU: sku, Name: name}
+		t.parents[id] = p
+		t.used[id] = map[int64]*BuildConsumption{}
+	}
+	return p
+}
+
+func (t *buildTally) items() []BuildThroughput {
+	out := make([]BuildThroughput, 0, len(t.parents))
+	for id, p := range t.parents {
+		p.Components = make([]BuildConsumption, 0, len(t.used[id]))
+		for _, c := range t.used[id] {
+			p.Components = append(p.Components, *c)
+		}
+		sort.Slice(p.Components, func(i, j int) bool { return p.Components[i].SKU < p.Components[j].SKU })
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SKU < out[j].SKU })
+	return out
+}
+
+// periodStart is the first day of the week (Monday) or month holding t.
+func periodStart(t time.Time, period string) time.Time {
+	y, m, d := t.Date()
+	if period == "month" {
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	day := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// reportBuilds sums build movements (ref_type build) per ?period=week|month
+// (default week) in ?tz=: units of each item built, and the components its
+// builds consumed. ?from= and ?to= (YYYY-MM-DD, inclusive) bound the range
+// and ?item_id= limits it to one built item. Reversed rows are left out.
+func reportBuilds(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		period := strings.ToLower(strings.TrimSpace(q.Get("period")))
+		if period == "" {
+			period = "week"
+		}
+		if period != "week" && period != "month" {
+			http.Error(w, "period must be week or month", http.StatusBadRequest)
+			return
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sb := strings.Builder{}
+		sb.WriteString(`
+SELECT
+  st.created_at, st.transaction_type, st.qty,
+  p.item_id, p.sku, p.name,
+  c.item_id, c.sku, c.name, c.managed_unit
+FROM stock_transactions st
+JOIN items c ON c.item_id = st.item_id
+LEFT JOIN assembly_records ar ON ar.record_id = st.bom_record_id
+JOIN items p ON p.item_id = CASE WHEN st.transaction_type = 'IN' THEN st.item_id ELSE ar.item_id END
+WHERE st.ref_type = 'build'
+  AND st.transaction_type IN ('IN', 'OUT')
+  AND NOT EXISTS (SELECT 1 FROM stock_transactions rv WHERE rv.reversal_of = st.transaction_id)
+`)
+		args := make([]any, 0)
+		for _, p := range []struct {
+			param string
+			op    string
+		}{{"from", ">="}, {"to", "<"}} {
+			v := strings.TrimSpace(q.Get(p.param))
+			if v == "" {
+				continue
+			}
+			d, err := timeutil.StartOfDay(v, loc)
+			if err != nil {
+				http.Error(w, "invalid "+p.param+" (want YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+			if p.param == "to" {
+				// "to" is inclusive of the whole day.
+				d = d.AddDate(0, 0, 1)
+			}
+			sb.WriteString(" AND st.created_at " + p.op + " ?")
+			args = append(args, timeutil.Format(d))
+		}
+		if v := strings.TrimSpace(q.Get("item_id")); v != "" {
+			itemID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || itemID <= 0 {
+				http.Error(w, "invalid item_id", http.StatusBadRequest)
+				return
+			}
+			sb.WriteString(" AND p.item_id = ?")
+			args = append(args, itemID)
+		}
+		sb.WriteString(" ORDER BY st.created_at, st.transaction_id")
+
+		rows, err := dbx.QueryContext(r.Context(), sb.String(), args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		periods := map[string]*buildTally{}
+		total := newBuildTally()
+		for rows.Next() {
+			var at timeutil.Time
+			var typ string
+			var qty float64
+			var parentID int64
+			var parentSKU, parentName string
+			var c BuildConsumption
+			if err := rows.Scan(&at, &typ, &qty, &parentID, &parentSKU, &parentName, &c.ItemID, &c.SKU, &c.Name, &c.ManagedUnit); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			start := periodStart(at.In(loc), period).Format(time.DateOnly)
+			if periods[start] == nil {
+				periods[start] = newBuildTally()
+			}
+			for _, t := range []*buildTally{periods[start], total} {
+				p := t.parent(parentID, parentSKU, parentName)
+				if typ == "IN" {
+					p.UnitsBuilt += qty
+					continue
+				}
+				used := t.used[parentID][c.ItemID]
+				if used == nil {
+					used = &BuildConsumption{ItemID: c.ItemID, SKU: c.SKU, Name: c.Name, ManagedUnit: c.ManagedUnit}
+					t.used[parentID][c.ItemID] = used
+				}
+				used.Qty += qty
+			}
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		out := struct {
+			Period  string            `json:"period"`
+			Periods []BuildPeriod     `json:"periods"`
+			Totals  []BuildThroughput `json:"totals"`
+		}{Period: period, Periods: make([]BuildPeriod, 0, len(periods)), Totals: total.items()}
+		for start, t := range periods {
+			out.Periods = append(out.Periods, BuildPeriod{Start: start, Items: t.items()})
+		}
+		sort.Slice(out.Periods, func(i, j int) bool { return out.Periods[i].Start < out.Periods[j].Start })
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	// Zone data is embedded so ?tz= works on images without /usr/share/zoneinfo.
+	_ "time/tzdata"
+)
+
+// requestLocation is the time zone reports bucket days in: ?tz= or the
+// X-Timezone header as an IANA name (e.g. Asia/Tokyo). Timestamps are stored
+// in UTC, so without either days are UTC days.
+func requestLocation(r *http.Request) (*time.Location, error) {
+	name := strings.TrimSpace(r.URL.Query().Get("tz"))
+	if name == "" {
+		name = strings.TrimSpace(r.Header.Get("X-Timezone"))
+	}
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tz: %s", name)
+	}
+	return loc, nil
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/middleware"
+	"stockmate/internal/querybuilder"
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+// Reference types name the kind of business document a movement belongs to.
+const (
+	refBuild     = "build"
+	refPOReceipt = "po_receipt"
+	refShipment  = "shipment"
+	refStocktake = "stocktake"
+	refReversal  = "reversal"
+)
+
+var refTypes = map[string]bool{refBuild: true, refPOReceipt: true, refShipment: true, refStocktake: true, refReversal: true}
+
+// txnRef is the document a set of ledger rows belongs to. A document that
+// was given no id is numbered after its first row, so its rows can still be
+// found together.
+type txnRef struct {
+	Type string
+	ID   string
+}
+
+// stamp records the reference on a new ledger row.
+func (ref *txnRef) stamp(ctx context.Context, tx *sql.Tx, txnID int64) error {
+	if ref.ID == "" {
+		ref.ID = strconv.FormatInt(txnID, 10)
+	}
+	_, err := tx.ExecContext(ctx, `
+UPDATE stock_transactions SET ref_type = ?, ref_id = ? WHERE transaction_id = ?
+`, ref.Type, ref.ID, txnID)
+	return err
+}
+
+// requestIDArg is the request_id stored on the ledger rows a request
+// writes, NULL outside a request.
+func requestIDArg(ctx context.Context) any {
+	if id := middleware.RequestIDFrom(ctx); id != "" {
+		return id
+	}
+	return nil
+}
+
+// noteSearch turns ?q= into a condition on a note column: every word must
+// appear in the note, ignoring ASCII case. LIKE wildcards in q match
+// literally.
+func noteSearch(column, q string) (string, []any) {
+	var sb strings.Builder
+	args := make([]any, 0)
+	for _, word := range strings.Fields(q) {
+		word = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(word)
+		sb.WriteString(" AND " + column + ` LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+word+"%")
+	}
+	return sb.String(), args
+}
+
+type StockTransaction struct {
+	ID              int64         `json:"id"`
+	ItemID          int64         `json:"item_id"`
+	SKU             string        `json:"sku"`
+	Name            string        `json:"name"`
+	Qty             float64       `json:"qty"`
+	TransactionType string        `json:"transaction_type"`
+	Note            string        `json:"note,omitempty"`
+	CreatedAt       timeutil.Time `json:"created_at"`
+	ReversalOf      *int64        `json:"reversal_of,omitempty"`
+	ReversedBy      *int64        `json:"reversed_by,omitempty"`
+	CorrectionOf    *int64        `json:"correction_of,omitempty"`
+	CorrectedBy     *int64        `json:"corrected_by,omitempty"`
+	ReasonCode      string        `json:"reason_code,omitempty"`
+	RefType         string        `json:"ref_type,omitempty"`
+	RefID           string        `json:"ref_id,omitempty"`
+	// RequestID is the X-Request-ID of the API call that wrote the row.
+	RequestID string `json:"request_id,omitempty"`
+	// Actor is the user (by email) or key:<name> that booked the row.
+	Actor string `json:"actor,omitempty"`
+}
+
+// listTransactions lists ledger rows, newest first. With Accept:
+// application/x-ndjson the rows are streamed one per line, and limit is
+// neither defaulted nor capped, so the whole ledger can be exported.
+func listTransactions(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fields, err := parseFields[StockTransaction](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		query := r.URL.Query()
+		stream := wantsNDJSON(r)
+		var limit int
+		if stream {
+			// SQLite reads a negative LIMIT as none.
+			limit, err = querybuilder.Limit(query, -1, 0)
+		} else {
+			limit, err = querybuilder.Limit(query, 200, 1000)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var where querybuilder.Where
+		if err := where.ID(query, "item_id", "st.item_id"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := where.OneOf(query, "type", "st.transaction_type", "IN", "OUT", "ADJUST"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if reason := strings.ToLower(strings.TrimSpace(query.Get("reason"))); reason != "" {
+			where.Eq("st.reason_code", reason)
+		}
+		if refType := strings.ToLower(strings.TrimSpace(query.Get("ref_type"))); refType != "" {
+			if !refTypes[refType] {
+				http.Error(w, "invalid ref_type", http.StatusBadRequest)
+				return
+			}
+			where.Eq("st.ref_type", refType)
+		}
+		if refID := strings.TrimSpace(query.Get("ref_id")); refID != "" {
+			where.Eq("st.ref_id", refID)
+		}
+		noteClause, noteArgs := noteSearch("st.note", query.Get("q"))
+		where.Append(noteClause, noteArgs)
+
+		sb := strings.Builder{}
+		sb.WriteString(`
+SELECT
+  st.transaction_id,
+  st.item_id,
+  i.sku,
+  i.name,
+  st.qty,
+  st.transaction_type,
+  st.note,
+  st.created_at,
+  st.reversal_of,
+  rv.transaction_id AS reversed_by,
+  st.correction_of,
+  cr.transaction_id AS corrected_by,
+  st.reason_code,
+  st.ref_type,
+  st.ref_id,
+  st.request_id,
+  st.actor
+FROM stock_transactions st
+JOIN items i ON i.item_id = st.item_id
+LEFT JOIN stock_transactions rv ON rv.reversal_of = st.transaction_id
+LEFT JOIN stock_transactions cr ON cr.correction_of = st.transaction_id
+WHERE 1=1
+`)
+		sb.WriteString(where.SQL())
+		args := where.Args()
+		if stream {
+			streamTransactions(w, r, dbx, sb.String(), args, limit, fields)
+			return
+		}
+		sb.WriteString(`
+ORDER BY st.transaction_id DESC
+LIMIT ?
+`)
+		args = append(args, limit)
+
+		rows, err := dbx.QueryContext(r.Context(), sb.String(), args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]StockTransaction, 0)
+		for rows.Next() {
+			row, err := scanStockTransaction(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, row)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeList(w, fields, out)
+	}
+}
+
+// streamTransactions writes the rows of query (listTransactions' SELECT
+// and filters), newest first and up to limit (negative: all), as NDJSON.
+// Rows are read in pages below the last transaction_id sent, each page
+// under its own query deadline, so the connection is free while a slow
+// client reads.
+func streamTransactions(w http.ResponseWriter, r *http.Request, dbx *sql.DB, query string, args []any, limit int, fields fieldSet) {
+	out := newNDJSONStream(w, fields)
+	var before int64
+	for sent := 0; limit < 0 || sent < limit; {
+		size := ndjsonFlushEvery
+		if limit >= 0 {
+			size = min(size, limit-sent)
+		}
+		q, pageArgs := query, slices.Clone(args)
+		if before > 0 {
+			q += " AND st.transaction_id < ?"
+			pageArgs = append(pageArgs, before)
+		}
+		page, err := loadTransactionPage(r.Context(), dbx, q+"\nORDER BY st.transaction_id DESC\nLIMIT ?", append(pageArgs, size))
+		if err != nil {
+			out.Fail(err)
+			return
+		}
+		for _, row := range page {
+			if err := out.Write(row); err != nil {
+				out.Fail(err)
+				return
+			}
+		}
+		if len(page) < size {
+			break
+		}
+		sent += len(page)
+		before = page[len(page)-1].ID
+	}
+	out.Close()
+}
+
+func loadTransactionPage(ctx context.Context, dbx *sql.DB, query string, args []any) ([]StockTransaction, error) {
+	ctx, cancel := middleware.QueryContext(ctx)
+	defer cancel()
+	rows, err := dbx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]StockTransaction, 0)
+	for rows.Next() {
+		row, err := scanStockTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+func scanStockTransaction(rows *sql.Rows) (StockTransaction, error) {
+	var row StockTransaction
+	var note sql.NullString
+	var reversalOf sql.NullInt64
+	var reversedBy sql.NullInt64
+	var correctionOf, correctedBy sql.NullInt64
+	var reasonCode, refType, refID, requestID, actor sql.NullString
+	if err := rows.Scan(
+		&row.ID,
+		&row.ItemID,
+		&row.SKU,
+		&row.Name,
+		&row.Qty,
+		&row.TransactionType,
+		&note,
+		&row.CreatedAt,
+		&reversalOf,
+		&reversedBy,
+		&correctionOf,
+		&correctedBy,
+		&reasonCode,
+		&refType,
+		&refID,
+		&requestID,
+		&actor,
+	); err != nil {
+		return row, err
+	}
+	if note.Valid {
+		row.Note = note.String
+	}
+	if reversalOf.Valid {
+		v := reversalOf.Int64
+		row.ReversalOf = &v
+	}
+	if reversedBy.Valid {
+		v := reversedBy.Int64
+		row.ReversedBy = &v
+	}
+	if correctionOf.Valid {
+		v := correctionOf.Int64
+		row.CorrectionOf = &v
+	}
+	if correctedBy.Valid {
+		v := correctedBy.Int64
+		row.CorrectedBy = &v
+	}
+	if reasonCode.Valid {
+		row.ReasonCode = reasonCode.String
+	}
+	row.RefType = refType.String
+	row.RefID = refID.String
+	row.RequestID = requestID.String
+	row.Actor = actor.String
+	return row, nil
+}
+
+// ledgerRow is the part of a ledger row that reversals and corrections
+// carry over.
+type ledgerRow struct {
+	itemID       int64
+	qty          float64
+	txnType      string
+	reasonCode   sql.NullString
+	refType      sql.NullString
+	refID        sql.NullString
+	bomRecordID  sql.NullInt64
+	stockManaged bool
+}
+
+func itemStockQty(ctx context.Context, q queryer, itemID int64) (float64, error) {
+	var qty float64
+	err := q.QueryRowContext(ctx, `
+SELECT COALESCE(SUM(
+  CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END
+), 0)
+FROM stock_transactions
+WHERE item_id = ?
+`, itemID).Scan(&qty)
+	return qty, err
+}
+
+// bookReversal books the compensating row for txnID and returns its id
+// along with the reversed row. A reversal, or a row that was already
+// reversed, is refused with a problem and status.
+func bookReversal(ctx context.Context, tx *sql.Tx, txnID int64, note string) (newID int64, orig ledgerRow, warnings []string, problem string, status int, err error) {
+	var reversalOf sql.NullInt64
+	var stockManaged int
+	if err := tx.QueryRowContext(ctx, `
+SELECT st.item_id, st.qty, st.transaction_type, st.reversal_of, st.reason_code, st.ref_type, st.ref_id, st.bom_record_id, i.stock_managed
+FROM stock_transactions st
+JOIN items i ON i.item_id = st.item_id
+WHERE st.transaction_id = ?
+`, txnID).Scan(&orig.itemID, &orig.qty, &orig.txnType, &reversalOf, &orig.reasonCode, &orig.refType, &orig.refID, &orig.bomRecordID, &stockManaged); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, orig, nil, "transaction not found", http.StatusNotFound, nil
+		}
+		return 0, orig, nil, "", 0, err
+	}
+	orig.stockManaged = stockManaged != 0
+	if reversalOf.Valid {
+		return 0, orig, nil, "cannot reverse a reversal transaction", http.StatusConflict, nil
+	}
+	var existing int64
+	err = tx.QueryRowContext(ctx, `SELECT transaction_id FROM stock_transactions WHERE reversal_of = ?`, txnID).Scan(&existing)
+	if err == nil {
+		return 0, orig, nil, fmt.Sprintf("transaction already reversed by %d", existing), http.StatusConflict, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, orig, nil, "", 0, err
+	}
+
+	// ADJUST rows are signed, so they are reversed by negating the delta.
+	// outQty is how much the reversal takes off stock.
+	reverseType, reverseQty, outQty := "OUT", orig.qty, orig.qty
+	switch orig.txnType {
+	case "OUT":
+		reverseType, outQty = "IN", 0
+	case "ADJUST":
+		reverseType, reverseQty, outQty = "ADJUST", -orig.qty, max(orig.qty, 0)
+	}
+
+	warnings = make([]string, 0)
+	if outQty > 0 && orig.stockManaged {
+		currentStock, err := itemStockQty(ctx, tx, orig.itemID)
+		if err != nil {
+			return 0, orig, nil, "", 0, err
+		}
+		msg, blocked, err := checkNegativeStock(ctx, tx, orig.itemID, currentStock, outQty)
+		if err != nil {
+			return 0, orig, nil, "", 0, err
+		}
+		if blocked {
+			return 0, orig, nil, msg, http.StatusBadRequest, nil
+		}
+		if msg != "" {
+			warnings = append(warnings, msg)
+		}
+	}
+
+	rvNote := fmt.Sprintf("reversal of #%d", txnID)
+	if note != "" {
+		rvNote += ": " + note
+	}
+	// The reversal keeps the original reason so per-reason totals net out.
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO stock_transactions(item_id, qty, transaction_type, note, reversal_of, reason_code, ref_type, ref_id, request_id, actor)
+VALUES(?,?,?,?,?,?,?,?,?,?)
+`, orig.itemID, reverseQty, reverseType, rvNote, txnID, orig.reasonCode, refReversal, strconv.FormatInt(txnID, 10), requestIDArg(ctx), actorArg(ctx))
+	if err != nil {
+		return 0, orig, nil, err.Error(), http.StatusConflict, nil
+	}
+	newID, _ = res.LastInsertId()
+	return newID, orig, warnings, "", 0, nil
+}
+
+// reverseTransaction books a compensating entry for a mistaken transaction.
+// The original row is left untouched; the link lives on the new row's
+// reversal_of, which is unique so a transaction can only be reversed once.
+func reverseTransaction(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Note string `json:"note"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		txnID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || txnID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var req Req
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "bad json", http.StatusBadRequest)
+				return
+			}
+		}
+		req.Note = strings.TrimSpace(req.Note)
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		newID, orig, warnings, problem, status, err := bookReversal(r.Context(), tx, txnID, req.Note)
+		if err != nil {
+			http.Error(w, "failed to reverse transaction", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+
+		stockQty, err := itemStockQty(r.Context(), tx, orig.itemID)
+		if err != nil {
+			http.Error(w, "failed to compute stock", http.StatusInternalServerError)
+			return
+		}
+
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"transaction_id":          newID,
+			"reversed_transaction_id": txnID,
+			"item_id":                 orig.itemID,
+			"stock_qty":               stockQty,
+			"warnings":                warnings,
+		})
+	}
+}
+
+// correctTransaction fixes the quantity of a transaction without touching
+// it: the row is reversed and re-booked with the new qty on a row whose
+// correction_of points at it, keeping its type, reason and reference. A
+// correction can itself be corrected, so a chain of corrections reads from
+// the original row onward.
+func correctTransaction(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Qty  *float64 `json:"qty"`
+		Note string   `json:"note"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		txnID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || txnID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if req.Qty == nil {
+			http.Error(w, "qty is required", http.StatusBadRequest)
+			return
+		}
+		req.Note = strings.TrimSpace(req.Note)
+
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		reversalID, orig, warnings, problem, status, err := bookReversal(r.Context(), tx, txnID, req.Note)
+		if err != nil {
+			http.Error(w, "failed to reverse transaction", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		qty := *req.Qty
+		if orig.txnType == "ADJUST" {
+			if qty == 0 {
+				http.Error(w, "qty must not be 0 for ADJUST", http.StatusBadRequest)
+				return
+			}
+		} else if qty <= 0 {
+			http.Error(w, "qty must be > 0", http.StatusBadRequest)
+			return
+		}
+		qty, problem, err = applyItemUnit(r.Context(), tx, orig.itemID, "qty", qty)
+		if err != nil {
+			http.Error(w, "failed to load unit rule", http.StatusInternalServerError)
+			return
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+
+		outQty := 0.0
+		switch orig.txnType {
+		case "OUT":
+			outQty = qty
+		case "ADJUST":
+			outQty = max(-qty, 0)
+		}
+		if outQty > 0 && orig.stockManaged {
+			currentStock, err := itemStockQty(r.Context(), tx, orig.itemID)
+			if err != nil {
+				http.Error(w, "failed to compute current stock", http.StatusInternalServerError)
+				return
+			}
+			msg, blocked, err := checkNegativeStock(r.Context(), tx, orig.itemID, currentStock, outQty)
+			if err != nil {
+				http.Error(w, "failed to load negative stock policy", http.StatusInternalServerError)
+				return
+			}
+			if blocked {
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
+			if msg != "" {
+				warnings = append(warnings, msg)
+			}
+		}
+
+		note := fmt.Sprintf("correction of #%d", txnID)
+		if req.Note != "" {
+			note += ": " + req.Note
+		}
+		res, err := tx.ExecContext(r.Context(), `
+INSERT INTO stock_transactions(item_id, qty, transaction_type, note, reason_code, ref_type, ref_id, bom_record_id, correction_of, request_id, actor)
+VALUES(?,?,?,?,?,?,?,?,?,?,?)
+`, orig.itemID, qty, orig.txnType, note, orig.reasonCode, orig.refType, orig.refID, orig.bomRecordID, txnID, requestIDArg(r.Context()), actorArg(r.Context()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		newID, _ := res.LastInsertId()
+
+		stockQty, err := itemStockQty(r.Context(), tx, orig.itemID)
+		if err != nil {
+			http.Error(w, "failed to compute stock", http.StatusInternalServerError)
+			return
+		}
+
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"transaction_id":           newID,
+			"reversal_transaction_id":  reversalID,
+			"corrected_transaction_id": txnID,
+			"item_id":                  orig.itemID,
+			"stock_qty":                stockQty,
+			"warnings":                 warnings,
+		})
+	}
+}
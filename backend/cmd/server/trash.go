@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+// The trash holds deleted BOM revisions (obsolete_at) and purchase links
+// (deleted_at) until they are restored or purged after the retention
+// window. A zero retention keeps them forever.
+
+// TrashedRevision is a BOM revision in the trash.
+type TrashedRevision struct {
+	RecordID  int64         `json:"record_id"`
+	ItemID    int64         `json:"item_id"`
+	SKU       string        `json:"sku"`
+	RevNo     int64         `json:"rev_no"`
+	DeletedAt timeutil.Time `json:"deleted_at"`
+	// PurgeAt is when the revision goes for good; null when it is kept,
+	// either by a zero retention or because it is the item's latest
+	// revision number.
+	PurgeAt timeutil.Time `json:"purge_at"`
+}
+
+// TrashedLink is a purchase link in the trash.
+type TrashedLink struct {
+	ID        int64         `json:"id"`
+	ItemID    int64         `json:"item_id"`
+	SKU       string        `json:"sku"`
+	URL       string        `json:"url"`
+	Label     string        `json:"label"`
+	DeletedAt timeutil.Time `json:"deleted_at"`
+	PurgeAt   timeutil.Time `json:"purge_at"`
+}
+
+type Trash struct {
+	BOMRevisions  []TrashedRevision `json:"bom_revisions"`
+	PurchaseLinks []TrashedLink     `json:"purchase_links"`
+}
+
+// livePurchaseLinks maps the URLs of a component's links outside the trash
+// to their ids.
+func livePurchaseLinks(ctx context.Context, q queryer, componentID int64) (map[string]int64, error) {
+	rows, err := q.QueryContext(ctx, `
+SELECT id, url FROM component_purchase_links WHERE component_id = ? AND deleted_at IS NULL
+`, componentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]int64{}
+	for rows.Next() {
+		var id int64
+		var url string
+		if err := rows.Scan(&id, &url); err != nil {
+			return nil, err
+		}
+		out[url] = id
+	}
+	return out, rows.Err()
+}
+
+// revisionKept is true for revisions the purge must leave alone: the
+// latest rev_no of an item, so the number is never handed out again, and
+// anything builds or ECOs still point at.
+const revisionKept = `(
+  ar.rev_no = (SELECT MAX(x.rev_no) FROM assembly_records x WHERE x.item_id = ar.item_id)
+  OR EXISTS (SELECT 1 FROM stock_transactions st WHERE st.bom_record_id = ar.record_id)
+  OR EXISTS (SELECT 1 FROM eco_changes ec WHERE ec.record_id = ar.record_id)
+)`
+
+func purgeAt(deleted timeutil.Time, retention time.Duration, kept bool) timeutil.Time {
+	if retention <= 0 || kept {
+		return timeutil.Time{}
+	}
+	return timeutil.Time{Time: deleted.Add(retention)}
+}
+
+func listTrash(dbx *sql.DB, retention time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out := Trash{BOMRevisions: make([]TrashedRevision, 0), PurchaseLinks: make([]TrashedLink, 0)}
+
+		rows, err := dbx.QueryContext(r.Context(), `
+SELECT ar.record_id, ar.item_id, i.sku, ar.rev_no, ar.obsolete_at, `+revisionKept+`
+FROM assembly_records ar
+JOIN items i ON i.item_id = ar.item_id
+WHERE ar.obsolete_at IS NOT NULL
+ORDER BY ar.obsolete_at DESC, ar.record_id DESC
+`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var t TrashedRevision
+			var kept bool
+			if err := rows.Scan(&t.RecordID, &t.ItemID, &t.SKU, &t.RevNo, &t.DeletedAt, &kept); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			t.PurgeAt = purgeAt(t.DeletedAt, retention, kept)
+			out.BOMRevisions = append(out.BOMRevisions, t)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		linkRows, err := dbx.QueryContext(r.Context(), `
+SELECT l.id, c.item_id, i.sku, l.url, COALESCE(l.label, ''), l.deleted_at
+FROM component_purchase_links l
+JOIN components c ON c.component_id = l.component_id
+JOIN items i ON i.item_id = c.item_id
+WHERE l.deleted_at IS NOT NULL
+ORDER BY l.deleted_at DESC, l.id DESC
+`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer linkRows.Close()
+		for linkRows.Next() {
+			var t TrashedLink
+			if err := linkRows.Scan(&t.ID, &t.ItemID, &t.SKU, &t.URL, &t.Label, &t.DeletedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			t.PurgeAt = purgeAt(t.DeletedAt, retention, false)
+			out.PurchaseLinks = append(out.PurchaseLinks, t)
+		}
+		if err := linkRows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// restoreAssemblyComponentsRevision takes a revision back out of the trash;
+// it competes for the latest and effective revision again.
+func restoreAssemblyComponentsRevision(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parentItemID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || parentItemID <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		revNo, err := strconv.ParseInt(chi.URLParam(r, "rev"), 10, 64)
+		if err != nil || revNo <= 0 {
+			http.Error(w, "invalid rev", http.StatusBadRequest)
+			return
+		}
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var obsolete bool
+		err = tx.QueryRowContext(r.Context(), `
+SELECT obsolete_at IS NOT NULL FROM assembly_records WHERE item_id = ? AND rev_no = ?
+`, parentItemID, revNo).Scan(&obsolete)
+		if err == sql.ErrNoRows {
+			http.Error(w, "revision not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to load revision", http.StatusInternalServerError)
+			return
+		}
+		if !obsolete {
+			http.Error(w, "revision is not in the trash", http.StatusConflict)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+UPDATE assembly_records SET obsolete_at = NULL WHERE item_id = ? AND rev_no = ?
+`, parentItemID, revNo); err != nil {
+			http.Error(w, "failed to restore revision", http.StatusInternalServerError)
+			return
+		}
+		if err := refreshAutoWeights(r.Context(), tx, parentItemID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// restorePurchaseLink takes a link back out of the trash, at the end of its
+// component's list. A link with the same URL added since blocks it.
+func restorePurchaseLink(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		tx, err := dbx.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var componentID int64
+		var url string
+		var deleted bool
+		err = tx.QueryRowContext(r.Context(), `
+SELECT component_id, url, deleted_at IS NOT NULL FROM component_purchase_links WHERE id = ?
+`, id).Scan(&componentID, &url, &deleted)
+		if err == sql.ErrNoRows {
+			http.Error(w, "purchase link not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to load purchase link", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "purchase link is not in the trash", http.StatusConflict)
+			return
+		}
+		live, err := livePurchaseLinks(r.Context(), tx, componentID)
+		if err != nil {
+			http.Error(w, "failed to load purchase links", http.StatusInternalServerError)
+			return
+		}
+		if _, ok := live[url]; ok {
+			http.Error(w, "the component already has a link to "+url, http.StatusConflict)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `
+UPDATE component_purchase_links
+SET deleted_at = NULL,
+    sort_order = (SELECT COALESCE(MAX(sort_order), -1) + 1 FROM component_purchase_links WHERE component_id = ?1 AND deleted_at IS NULL)
+WHERE id = ?2
+`, componentID, id); err != nil {
+			http.Error(w, "failed to restore purchase link", http.StatusInternalServerError)
+			return
+		}
+		if err := store.Commit(r.Context(), tx); err != nil {
+			http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// purgeTrash removes what has been in the trash since before cutoff.
+func purgeTrash(ctx context.Context, dbx *sql.DB, cutoff time.Time) (revisions, links int64, err error) {
+	tx, err := dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	before := timeutil.Format(cutoff)
+	res, err := tx.ExecContext(ctx, `
+DELETE FROM assembly_records
+WHERE record_id IN (
+  SELECT ar.record_id FROM assembly_records ar
+  WHERE ar.obsolete_at IS NOT NULL AND ar.obsolete_at < ? AND NOT `+revisionKept+`
+)
+`, before)
+	if err != nil {
+		return 0, 0, err
+	}
+	revisions, _ = res.RowsAffected()
+	res, err = tx.ExecContext(ctx, `
+DELETE FROM component_purchase_links WHERE deleted_at IS NOT NULL AND deleted_at < ?
+`, before)
+	if err != nil {
+		return 0, 0, err
+	}
+	links, _ = res.RowsAffected()
+	return revisions, links, tx.Commit()
+}
+
+func trashPurgeJob(dbx *sql.DB, retention time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		revisions, links, err := purgeTrash(ctx, dbx, time.Now().Add(-retention))
+		if err != nil {
+			return err
+		}
+		if revisions > 0 || links > 0 {
+			log.Printf("trash purge: %d bom revisions, %d purchase links", revisions, links)
+		}
+		return nil
+	}
+}
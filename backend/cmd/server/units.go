@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stockmate/internal/store"
+	"stockmate/internal/validate"
+)
+
+// UnitRule is the precision quantities in a managed unit are kept at.
+type UnitRule struct {
+	Unit     string `json:"unit"`
+	Decimals int    `json:"decimals"`
+	// Rounding is what happens to a quantity with more decimals: round
+	// (half away from zero) or reject.
+	Rounding string `json:"rounding"`
+}
+
+// apply brings qty to the rule's precision. A non-empty problem means it
+// can't be: it has too many decimals under reject, or it rounds to zero.
+func (u UnitRule) apply(qty float64) (float64, string) {
+	scale := math.Pow10(u.Decimals)
+	rounded := math.Round(qty*scale) / scale
+	if math.Abs(rounded-qty) > 1e-9*max(1, math.Abs(qty)) {
+		if u.Rounding == "reject" {
+			if u.Decimals == 0 {
+				return qty, "must be a whole number of " + u.Unit
+			}
+			return qty, fmt.Sprintf("must have at most %d decimal places for %s", u.Decimals, u.Unit)
+		}
+		if rounded == 0 {
+			return qty, fmt.Sprintf("rounds to 0 at %d decimal places", u.Decimals)
+		}
+	}
+	return rounded, ""
+}
+
+// itemUnitRules loads the unit rule of each item in ids; unknown items are
+// left out.
+func itemUnitRules(ctx context.Context, q queryer, ids []int64) (map[int64]UnitRule, error) {
+	out := map[int64]UnitRule{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := q.QueryContext(ctx, `
+SELECT i.item_id, u.unit, u.decimals, u.rounding
+FROM items i
+JOIN unit_rules u ON u.unit = i.managed_unit
+WHERE i.item_id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")+`)
+`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var u UnitRule
+		if err := rows.Scan(&id, &u.Unit, &u.Decimals, &u.Rounding); err != nil {
+			return nil, err
+		}
+		out[id] = u
+	}
+	return out, rows.Err()
+}
+
+// applyItemUnit brings qty to the precision of the item's unit. problem is
+// prefixed with field; an unknown item passes qty through for the caller's
+// own lookup to report.
+func applyItemUnit(ctx context.Context, q queryer, itemID int64, field string, qty float64) (float64, string, error) {
+	rules, err := itemUnitRules(ctx, q, []int64{itemID})
+	if err != nil {
+		return qty, "", err
+	}
+	rule, ok := rules[itemID]
+	if !ok {
+		return qty, "", nil
+	}
+	qty, problem := rule.apply(qty)
+	if problem != "" {
+		problem = field + " " + problem
+	}
+	return qty, problem, nil
+}
+
+func listUnitRules(dbx *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := dbx.QueryContext(r.Context(), `SELECT unit, decimals, rounding FROM unit_rules ORDER BY unit`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		out := make([]UnitRule, 0)
+		for rows.Next() {
+			var u UnitRule
+			if err := rows.Scan(&u.Unit, &u.Decimals, &u.Rounding); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, u)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// updateUnitRule changes a unit's precision. It applies to quantities
+// entered from then on; the ledger is not rewritten.
+func updateUnitRule(dbx *sql.DB) http.HandlerFunc {
+	type Req struct {
+		Decimals *int   `json:"decimals"`
+		Rounding string `json:"rounding"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		unit := strings.TrimSpace(chi.URLParam(r, "unit"))
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		req.Rounding = strings.ToLower(strings.TrimSpace(req.Rounding))
+		var errs validate.Errors
+		if req.Decimals == nil {
+			errs.Add("decimals", "is required")
+		} else {
+			errs.Check(*req.Decimals >= 0 && *req.Decimals <= 6, "decimals", "must be 0-6")
+		}
+		errs.OneOf("rounding", req.Rounding, "round", "reject")
+		if !errs.Empty() {
+			errs.Write(w)
+			return
+		}
+
+		res, err := store.Exec(r.Context(), dbx, `
+UPDATE unit_rules SET decimals = ?, rounding = ? WHERE unit = ?
+`, *req.Decimals, req.Rounding, unit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "unit not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(UnitRule{Unit: unit, Decimals: *req.Decimals, Rounding: req.Rounding})
+	}
+}
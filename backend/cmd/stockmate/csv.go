@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"stockmate/internal/store"
+)
+
+var itemCSVHeader = []string{
+	"sku", "name", "item_type", "managed_unit", "series",
+	"stock_managed", "is_sellable", "is_final", "pack_qty", "reorder_point", "note",
+}
+
+var transactionCSVHeader = []string{
+	"transaction_id", "created_at", "sku", "transaction_type", "qty", "reason_code", "note",
+}
+
+func runExportCSV(ctx context.Context, args []string) error {
+	fs, dsn := newFlagSet("export-csv")
+	table := fs.String("table", "items", "items or transactions")
+	out := fs.String("o", "-", "output file (- for stdout)")
+	fs.Parse(args)
+
+	st, err := openExisting(*dsn)
+	if err != nil {
+		return err
+	}
+	defer st.DB().Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	cw := csv.NewWriter(w)
+
+	switch *table {
+	case "items":
+		items, err := st.ListItemRecords(ctx)
+		if err != nil {
+			return err
+		}
+		cw.Write(itemCSVHeader)
+		for _, it := range items {
+			cw.Write([]string{
+				it.SKU, it.Name, it.ItemType, it.ManagedUnit, it.SeriesName,
+				strconv.FormatBool(it.StockManaged), strconv.FormatBool(it.IsSellable), strconv.FormatBool(it.IsFinal),
+				formatOptFloat(it.PackQty), formatOptFloat(it.ReorderPoint), it.Note,
+			})
+		}
+	case "transactions":
+		txns, err := st.ListTransactionRecords(ctx)
+		if err != nil {
+			return err
+		}
+		cw.Write(transactionCSVHeader)
+		for _, t := range txns {
+			cw.Write([]string{
+				strconv.FormatInt(t.ID, 10), t.CreatedAt, t.SKU, t.TransactionType,
+				strconv.FormatFloat(t.Qty, 'f', -1, 64), t.ReasonCode, t.Note,
+			})
+		}
+	default:
+		return fmt.Errorf("invalid -table: %s", *table)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// runImportCSV loads a CSV in one database transaction; a bad row aborts
+// the whole file. Items are matched by SKU and updated in place; ledger rows
+// are always appended (transaction_id in the file is ignored).
+//
+// Ledger rows are loaded as history, not booked: unlike movements posted to
+// the server they are not held to the negative stock policy or the unit's
+// precision, and they send no outbox notifications. recalc-stock reports
+// what the import left below zero.
+func runImportCSV(ctx context.Context, args []string) error {
+	fs, dsn := newFlagSet("import-csv")
+	table := fs.String("table", "items", "items or transactions")
+	in := fs.String("f", "", "input file (- for stdin)")
+	fs.Parse(args)
+	if *in == "" {
+		return fmt.Errorf("-f is required")
+	}
+	if *table != "items" && *table != "transactions" {
+		return fmt.Errorf("invalid -table: %s", *table)
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	col := make(map[string]int, len(header))
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+
+	st, err := openStore(*dsn)
+	if err != nil {
+		return err
+	}
+	defer st.DB().Close()
+
+	tx, err := st.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	created, updated := 0, 0
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		get := func(name string) string {
+			if i, ok := col[name]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+
+		if *table == "items" {
+			it, err := parseItemRecord(get)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			_, isNew, err := st.UpsertItemRecord(ctx, tx, it)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if isNew {
+				created++
+			} else {
+				updated++
+			}
+			continue
+		}
+
+		qty, err := strconv.ParseFloat(get("qty"), 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid qty", line)
+		}
+		if _, err := st.AddTransactionRecord(ctx, tx, store.TransactionRecord{
+			CreatedAt:       get("created_at"),
+			SKU:             get("sku"),
+			TransactionType: get("transaction_type"),
+			Qty:             qty,
+			ReasonCode:      get("reason_code"),
+			Note:            get("note"),
+		}); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		created++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Printf("imported %s: %d created, %d updated\n", *table, created, updated)
+	return nil
+}
+
+func parseItemRecord(get func(string) string) (store.ItemRecord, error) {
+	it := store.ItemRecord{
+		SKU:          get("sku"),
+		Name:         get("name"),
+		ItemType:     strings.ToLower(get("item_type")),
+		ManagedUnit:  get("managed_unit"),
+		SeriesName:   get("series"),
+		StockManaged: true,
+		Note:         get("note"),
+	}
+	for _, f := range []struct {
+		name string
+		dst  *bool
+	}{{"stock_managed", &it.StockManaged}, {"is_sellable", &it.IsSellable}, {"is_final", &it.IsFinal}} {
+		v := get(f.name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return it, fmt.Errorf("invalid %s: %q", f.name, v)
+		}
+		*f.dst = b
+	}
+	for _, f := range []struct {
+		name string
+		dst  **float64
+	}{{"pack_qty", &it.PackQty}, {"reorder_point", &it.ReorderPoint}} {
+		v := get(f.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return it, fmt.Errorf("invalid %s: %q", f.name, v)
+		}
+		*f.dst = &n
+	}
+	return it, nil
+}
+
+func formatOptFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
@@ -0,0 +1,300 @@
+// Command stockmate is the administration tool for a stockmate database:
+// migrations, backups, CSV exchange and stock maintenance without going
+// through the HTTP server.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"stockmate/internal/db"
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"migrate", "apply schema migrations", runMigrate},
+	{"backup", "write a consistent copy of the database (-o FILE)", runBackup},
+	{"export-csv", "export items or transactions as CSV (-table, -o)", runExportCSV},
+	{"import-csv", "import items or transactions from CSV (-table, -f)", runImportCSV},
+	{"recalc-stock", "recompute stock from the ledger and report items below zero or short of their bins", runRecalcStock},
+	{"snapshot", "record the daily stock snapshot (-date, default today UTC)", runSnapshot},
+	{"doctor", "check schema version, integrity and the query plans of hot stock queries", runDoctor},
+	{"archive", "archive ledger rows older than -years or -before behind opening balances", runArchive},
+	{"user", "list users, or create EMAIL ROLE, set-role EMAIL ROLE, disable EMAIL, enable EMAIL", runUser},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(context.Background(), flag.Args()[1:]); err != nil {
+				fmt.Fprintln(os.Stderr, "stockmate "+name+":", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintln(os.Stderr, "unknown command:", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: stockmate <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Every command accepts -dsn (default $DB_DSN or sqlite:./data/stockmate.db).")
+}
+
+// newFlagSet returns a flag set with the shared -dsn flag registered.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	def := strings.TrimSpace(os.Getenv("DB_DSN"))
+	if def == "" {
+		def = "sqlite:./data/stockmate.db"
+	}
+	dsn := fs.String("dsn", def, "database DSN")
+	return fs, dsn
+}
+
+// openStore opens and migrates the database so commands that write work
+// against the current schema.
+func openStore(dsn string) (*store.Store, error) {
+	st, err := openExisting(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Migrate(st.DB()); err != nil {
+		st.DB().Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+// openExisting opens the database as it is, for commands that must not
+// change it: a backup copies the schema it finds, and an export of an old
+// database should not upgrade it first.
+func openExisting(dsn string) (*store.Store, error) {
+	conn, err := db.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return store.New(conn), nil
+}
+
+func runMigrate(ctx context.Context, args []string) error {
+	fs, dsn := newFlagSet("migrate")
+	fs.Parse(args)
+
+	st, err := openStore(*dsn)
+	if err != nil {
+		return err
+	}
+	defer st.DB().Close()
+
+	v, err := db.AppliedSchemaVersion(ctx, st.DB())
+	if err != nil {
+		return err
+	}
+	fmt.Println("schema version:", v)
+	return nil
+}
+
+func runBackup(ctx context.Context, args []string) error {
+	fs, dsn := newFlagSet("backup")
+	out := fs.String("o", "", "output file (must not exist)")
+	fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("-o is required")
+	}
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%s already exists", *out)
+	}
+
+	st, err := openExisting(*dsn)
+	if err != nil {
+		return err
+	}
+	defer st.DB().Close()
+
+	if err := st.Backup(ctx, *out); err != nil {
+		return err
+	}
+	fmt.Println("backup written:", *out)
+	return nil
+}
+
+// runRecalcStock recomputes the on-hand stock of every stock-managed item
+// from the ledger, which is the only place stock is kept, and reports the
+// items it leaves below zero under the block policy or holding less than
+// their bins. It fails when there are any.
+func runRecalcStock(ctx context.Context, args []string) error {
+	fs, dsn := newFlagSet("recalc-stock")
+	fs.Parse(args)
+
+	st, err := openStore(*dsn)
+	if err != nil {
+		return err
+	}
+	defer st.DB().Close()
+
+	n, issues, err := st.CheckStock(ctx)
+	if err != nil {
+		return err
+	}
+	for _, is := range issues {
+		fmt.Printf("%s: %s\n", is.SKU, is.Problem)
+	}
+	fmt.Printf("stock of %d items recomputed from the ledger: %d issues\n", n, len(issues))
+	if len(issues) > 0 {
+		return fmt.Errorf("%d items need a correction", len(issues))
+	}
+	return nil
+}
+
+func runSnapshot(ctx context.Context, args []string) error {
+	fs, dsn := newFlagSet("snapshot")
+	day := fs.String("date", time.Now().UTC().Format(time.DateOnly), "snapshot date (YYYY-MM-DD)")
+	tz := fs.String("tz", "UTC", "time zone the day ends in (IANA name)")
+	fs.Parse(args)
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return fmt.Errorf("invalid -tz: %w", err)
+	}
+
+	st, err := openStore(*dsn)
+	if err != nil {
+		return err
+	}
+	defer st.DB().Close()
+
+	n, err := st.TakeStockSnapshot(ctx, *day, loc)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("snapshot %s: %d items\n", *day, n)
+	return nil
+}
+
+func runArchive(ctx context.Context, args []string) error {
+	fs, dsn := newFlagSet("archive")
+	years := fs.Int("years", 0, "archive rows older than this many years")
+	before := fs.String("before", "", "archive rows created before this date (YYYY-MM-DD or RFC3339)")
+	dryRun := fs.Bool("dry-run", false, "report what would be archived without changing anything")
+	fs.Parse(args)
+
+	var cutoff time.Time
+	switch {
+	case (*years == 0) == (*before == ""):
+		return fmt.Errorf("one of -years or -before is required")
+	case *before != "":
+		t, err := timeutil.Parse(*before)
+		if err != nil {
+			return fmt.Errorf("invalid -before: %w", err)
+		}
+		cutoff = t
+	case *years < 0:
+		return fmt.Errorf("-years must be > 0")
+	default:
+		cutoff = time.Now().AddDate(-*years, 0, 0)
+	}
+	if cutoff.After(time.Now()) {
+		return fmt.Errorf("-before must not be in the future")
+	}
+
+	st, err := openStore(*dsn)
+	if err != nil {
+		return err
+	}
+	defer st.DB().Close()
+
+	rep, err := st.ArchiveTransactions(ctx, cutoff, *dryRun)
+	if err != nil {
+		return err
+	}
+	verb := "archived"
+	if rep.DryRun {
+		verb = "would archive"
+	}
+	fmt.Printf("%s %d rows before %s: %d opening balances, %d rows held by newer links\n",
+		verb, rep.Archived, rep.Before, rep.OpeningBalances, rep.Held)
+	return nil
+}
+
+// runDoctor checks that the database is at the current schema, passes
+// SQLite's quick check and still answers the hot stock queries through
+// indexes. It fails when any check does. The database is opened without
+// migrating, so an outdated one is reported rather than upgraded.
+func runDoctor(ctx context.Context, args []string) error {
+	fs, dsn := newFlagSet("doctor")
+	fs.Parse(args)
+
+	st, err := openExisting(*dsn)
+	if err != nil {
+		return err
+	}
+	defer st.DB().Close()
+
+	failed := 0
+	report := func(ok bool, name, detail string) {
+		status := "ok  "
+		if !ok {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%s %s: %s\n", status, name, detail)
+	}
+
+	v, err := db.AppliedSchemaVersion(ctx, st.DB())
+	if err != nil {
+		return err
+	}
+	report(v == db.SchemaVersion, "schema version", fmt.Sprintf("%d (want %d)", v, db.SchemaVersion))
+
+	var check string
+	if err := st.DB().QueryRowContext(ctx, `PRAGMA quick_check`).Scan(&check); err != nil {
+		return err
+	}
+	report(check == "ok", "quick check", check)
+
+	// An outdated schema may lack what the checked queries use.
+	plans, err := st.CheckQueryPlans(ctx)
+	if err != nil {
+		report(false, "query plans", err.Error())
+	}
+	for _, p := range plans {
+		detail := strings.Join(p.Plan, "; ")
+		if !p.OK() {
+			detail = "full scan: " + strings.Join(p.Scans, "; ")
+		}
+		report(p.OK(), "plan "+p.Name, detail)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
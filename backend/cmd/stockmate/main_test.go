@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"stockmate/internal/db"
+)
+
+// tempDSN is a file database in a fresh directory; the commands open and
+// close it themselves.
+func tempDSN(t *testing.T) (dsn, dir string) {
+	dir = t.TempDir()
+	return "sqlite:" + filepath.Join(dir, "stockmate.db"), dir
+}
+
+func run(t *testing.T, cmd func(context.Context, []string) error, args ...string) error {
+	t.Helper()
+	return cmd(context.Background(), args)
+}
+
+func openDSN(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+	conn, err := db.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestUserCreate(t *testing.T) {
+	dsn, _ := tempDSN(t)
+	if err := run(t, runUser, "create", "-dsn", dsn, "Ann@Example.com", "owner"); err == nil || !strings.Contains(err.Error(), "role") {
+		t.Fatalf("invalid role: %v", err)
+	}
+	if err := run(t, runUser, "create", "-dsn", dsn, "Ann@Example.com", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, runUser, "create", "-dsn", dsn, "ann@example.com", "read-only"); err == nil {
+		t.Fatal("second user with the same email was created")
+	}
+	if err := run(t, runUser, "set-role", "-dsn", dsn, "ann@example.com", "stock-write"); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, runUser, "list", "-dsn", dsn); err != nil {
+		t.Fatal(err)
+	}
+
+	var issuer, subject, email, role string
+	if err := openDSN(t, dsn).QueryRow(`SELECT issuer, subject, email, role FROM users`).Scan(&issuer, &subject, &email, &role); err != nil {
+		t.Fatal(err)
+	}
+	if issuer != "" || subject != "ann@example.com" || email != "Ann@Example.com" || role != "stock-write" {
+		t.Fatalf("user = %q %q %q %q", issuer, subject, email, role)
+	}
+}
+
+// TestBackupLeavesSchema checks that a backup copies an old database as it
+// is instead of migrating it first.
+func TestBackupLeavesSchema(t *testing.T) {
+	dsn, dir := tempDSN(t)
+	conn := openDSN(t, dsn)
+	for _, q := range []string{`CREATE TABLE items (item_id INTEGER PRIMARY KEY)`, `PRAGMA user_version = 3`} {
+		if _, err := conn.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+
+	out := filepath.Join(dir, "backup.db")
+	if err := run(t, runBackup, "-dsn", dsn, "-o", out); err != nil {
+		t.Fatal(err)
+	}
+	for _, dsn := range []string{dsn, "sqlite:" + out} {
+		v, err := db.AppliedSchemaVersion(context.Background(), openDSN(t, dsn))
+		if err != nil || v != 3 {
+			t.Errorf("%s: schema version %d, %v; want 3", dsn, v, err)
+		}
+	}
+}
+
+// TestCSVRoundTrip exports the demo catalogue and ledger, imports them into
+// an empty database and has recalc-stock check the result.
+func TestCSVRoundTrip(t *testing.T) {
+	src, dir := tempDSN(t)
+	conn := openDSN(t, src)
+	if err := db.Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SeedDemo(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	items, txns := filepath.Join(dir, "items.csv"), filepath.Join(dir, "txns.csv")
+	if err := run(t, runExportCSV, "-dsn", src, "-table", "items", "-o", items); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, runExportCSV, "-dsn", src, "-table", "transactions", "-o", txns); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := "sqlite:" + filepath.Join(dir, "copy.db")
+	if err := run(t, runImportCSV, "-dsn", dst, "-table", "items", "-f", items); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, runImportCSV, "-dsn", dst, "-table", "transactions", "-f", txns); err != nil {
+		t.Fatal(err)
+	}
+	var want, got float64
+	stock := `SELECT SUM(CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END) FROM stock_transactions`
+	if err := conn.QueryRow(stock).Scan(&want); err != nil {
+		t.Fatal(err)
+	}
+	if err := openDSN(t, dst).QueryRow(stock).Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("imported ledger sums to %v, want %v", got, want)
+	}
+	if err := run(t, runRecalcStock, "-dsn", dst); err != nil {
+		t.Fatal(err)
+	}
+
+	// Imported history skips the negative stock policy; recalc-stock
+	// reports what it left below zero.
+	short := filepath.Join(dir, "short.csv")
+	if err := os.WriteFile(short, []byte("sku,transaction_type,qty\nPRT-LED,OUT,1000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, runImportCSV, "-dsn", dst, "-table", "transactions", "-f", short); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, runRecalcStock, "-dsn", dst); err == nil || !strings.Contains(err.Error(), "1 items") {
+		t.Fatalf("recalc after an overdrawn import: %v", err)
+	}
+}
+
+// TestDoctorSchemaVersion checks that doctor reports an outdated schema
+// instead of migrating it first.
+func TestDoctorSchemaVersion(t *testing.T) {
+	dsn, _ := tempDSN(t)
+	if err := run(t, runMigrate, "-dsn", dsn); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, runDoctor, "-dsn", dsn); err != nil {
+		t.Fatalf("doctor on a current database: %v", err)
+	}
+
+	conn := openDSN(t, dsn)
+	if _, err := conn.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, db.SchemaVersion-1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, runDoctor, "-dsn", dsn); err == nil {
+		t.Fatal("doctor passed an outdated database")
+	}
+	if v, err := db.AppliedSchemaVersion(context.Background(), conn); err != nil || v != db.SchemaVersion-1 {
+		t.Fatalf("schema version after doctor = %d, %v; want it left at %d", v, err, db.SchemaVersion-1)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"stockmate/internal/timeutil"
+)
+
+var userRoles = []string{"read-only", "stock-write", "admin"}
+
+// runUser manages the users of OpenID Connect sign-in. Users appear on their
+// first sign-in, or ahead of it with create, which is how the first admin
+// is made: the created user is linked to the provider account that first
+// signs in with the same, verified, email.
+func runUser(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("want list, create EMAIL ROLE, set-role EMAIL ROLE, disable EMAIL or enable EMAIL")
+	}
+	sub := args[0]
+	fs, dsn := newFlagSet("user " + sub)
+	fs.Parse(args[1:])
+	want := map[string]int{"list": 0, "create": 2, "set-role": 2, "disable": 1, "enable": 1}
+	n, ok := want[sub]
+	if !ok {
+		return fmt.Errorf("unknown subcommand %q", sub)
+	}
+	if fs.NArg() != n {
+		return fmt.Errorf("%s takes %d arguments, got %d", sub, n, fs.NArg())
+	}
+	if role := fs.Arg(1); n == 2 && !slices.Contains(userRoles, role) {
+		return fmt.Errorf("role must be one of %v", userRoles)
+	}
+
+	st, err := openStore(*dsn)
+	if err != nil {
+		return err
+	}
+	defer st.DB().Close()
+	conn := st.DB()
+
+	if sub == "list" {
+		return listUsers(ctx, conn)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if sub == "create" {
+		err = createUser(ctx, tx, fs.Arg(0), fs.Arg(1))
+	} else {
+		err = updateUser(ctx, tx, sub, fs.Arg(0), fs.Arg(1))
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Printf("user %s: %s done\n", fs.Arg(0), sub)
+	return nil
+}
+
+// createUser adds a user who has not signed in yet. Until then the issuer
+// is empty and the subject is the lowercased email, which the server
+// matches on first sign-in.
+func createUser(ctx context.Context, tx *sql.Tx, email, role string) error {
+	email = strings.TrimSpace(email)
+	if _, _, ok := strings.Cut(email, "@"); !ok {
+		return fmt.Errorf("invalid email %q", email)
+	}
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(1) FROM users WHERE lower(email) = lower(?) OR (issuer = '' AND subject = lower(?))`, email, email).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("a user with email %s already exists", email)
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO users(issuer, subject, email, role) VALUES ('', lower(?), ?, ?)`, email, email, role)
+	return err
+}
+
+// updateUser applies set-role, disable or enable to the user with email.
+func updateUser(ctx context.Context, tx *sql.Tx, sub, email, role string) error {
+	id, err := userByEmail(ctx, tx, email)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "set-role":
+		_, err = tx.ExecContext(ctx, `UPDATE users SET role = ? WHERE user_id = ?`, role, id)
+	case "disable":
+		// Signing out every browser of the user goes with it.
+		if _, err = tx.ExecContext(ctx, `
+UPDATE users SET disabled_at = COALESCE(disabled_at, `+timeutil.SQLNow+`) WHERE user_id = ?
+`, id); err == nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, id)
+		}
+	case "enable":
+		_, err = tx.ExecContext(ctx, `UPDATE users SET disabled_at = NULL WHERE user_id = ?`, id)
+	}
+	return err
+}
+
+// userByEmail finds the one user with email, ignoring case.
+func userByEmail(ctx context.Context, tx *sql.Tx, email string) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT user_id FROM users WHERE lower(email) = lower(?)`, email)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	switch len(ids) {
+	case 0:
+		return 0, fmt.Errorf("no user with email %s (create them, or wait for their first sign-in)", email)
+	case 1:
+		return ids[0], nil
+	}
+	return 0, fmt.Errorf("%d users share email %s; use PUT /api/admin/users/{id}", len(ids), email)
+}
+
+func listUsers(ctx context.Context, conn *sql.DB) error {
+	rows, err := conn.QueryContext(ctx, `
+SELECT user_id, email, name, role, COALESCE(last_login_at, ''), disabled_at IS NOT NULL
+FROM users ORDER BY user_id
+`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tNAME\tROLE\tLAST LOGIN\tDISABLED")
+	for rows.Next() {
+		var id int64
+		var email, name, role, lastLogin string
+		var disabled bool
+		if err := rows.Scan(&id, &email, &name, &role, &lastLogin, &disabled); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%v\n", id, email, name, role, lastLogin, disabled)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
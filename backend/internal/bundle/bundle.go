@@ -0,0 +1,390 @@
+// Package bundle exports and imports the whole catalog and stock ledger as a
+// portable, versioned JSON document. Rows keep their primary keys, so a
+// bundle restores the same ids on the target instance.
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"stockmate/internal/db"
+	"stockmate/internal/store"
+	"stockmate/internal/timeutil"
+)
+
+const (
+	Format  = "stockmate-bundle"
+	Version = 1
+
+	// zipEntry is the file name of the JSON document inside a zip bundle.
+	zipEntry = "bundle.json"
+)
+
+// Tables lists the exported tables in foreign-key order: every table only
+// references tables that appear before it. Attachment blobs live outside the
+// database and are not part of a bundle.
+//
+// Seeded tables are pre-filled by migrations, so a fresh instance already has
+// their rows; under StrategyFail they are merged rather than rejected.
+var Tables = []struct {
+	Name   string
+	Key    string
+	Seeded bool
+}{
+	{"reason_codes", "code", true},
+	{"unit_rules", "unit", true},
+	{"app_settings", "key", false},
+	{"currencies", "code", true},
+	{"series", "series_id", false},
+	{"suppliers", "supplier_id", false},
+	{"items", "item_id", false},
+	{"components", "component_id", false},
+	{"assemblies", "assembly_id", false},
+	{"component_purchase_links", "id", false},
+	{"supplier_offers", "offer_id", false},
+	{"assembly_records", "record_id", false},
+	{"assembly_components", "record_id, component_item_id", false},
+	{"assembly_component_alternates", "record_id, component_item_id, alternate_item_id", false},
+	{"ecos", "eco_id", false},
+	{"eco_changes", "eco_id, parent_item_id", false},
+	{"bom_templates", "template_id", false},
+	{"bom_template_lines", "template_id, component_item_id", false},
+	{"channel_listings", "item_id, channel", false},
+	{"order_dead_letters", "dead_letter_id", false},
+	{"purchase_order_lines", "line_id", false},
+	{"sku_patterns", "pattern_id", false},
+	{"custom_fields", "field_id", false},
+	{"item_custom_values", "item_id, field_id", false},
+	{"stock_transactions", "transaction_id", false},
+	{"stock_transactions_archive", "transaction_id", false},
+	{"item_changes", "change_id", false},
+	{"stocktakes", "stocktake_id", false},
+	{"stocktake_lines", "stocktake_id, item_id", false},
+	{"low_stock_snoozes", "item_id", false},
+	{"item_bins", "item_id, bin_code", false},
+	{"bin_transfers", "transfer_id", false},
+	{"stock_snapshots", "item_id, snapshot_date", false},
+	{"landed_costs", "landed_cost_id", false},
+	{"landed_cost_charges", "charge_id", false},
+	{"landed_cost_allocations", "landed_cost_id, transaction_id", false},
+}
+
+type Row map[string]any
+
+type Bundle struct {
+	Format        string           `json:"format"`
+	Version       int              `json:"version"`
+	SchemaVersion int              `json:"schema_version"`
+	ExportedAt    string           `json:"exported_at"`
+	Tables        map[string][]Row `json:"tables"`
+}
+
+// Strategy decides what happens when an imported row's primary key already
+// exists on the target.
+type Strategy string
+
+const (
+	StrategyFail    Strategy = "fail"
+	StrategySkip    Strategy = "skip"
+	StrategyReplace Strategy = "replace"
+)
+
+func ParseStrategy(v string) (Strategy, error) {
+	switch s := Strategy(strings.ToLower(strings.TrimSpace(v))); s {
+	case "":
+		return StrategyFail, nil
+	case StrategyFail, StrategySkip, StrategyReplace:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid conflict strategy: %q", v)
+}
+
+type TableResult struct {
+	Table   string `json:"table"`
+	Rows    int    `json:"rows"`
+	Written int    `json:"written"`
+	Skipped int    `json:"skipped"`
+}
+
+// ErrConflict is returned by Import under StrategyFail when a row collides
+// with existing data.
+var ErrConflict = errors.New("bundle conflicts with existing data")
+
+func Export(ctx context.Context, dbx *sql.DB) (*Bundle, error) {
+	schemaVersion, err := db.AppliedSchemaVersion(ctx, dbx)
+	if err != nil {
+		return nil, fmt.Errorf("read schema version: %w", err)
+	}
+
+	tx, err := dbx.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := &Bundle{
+		Format:        Format,
+		Version:       Version,
+		SchemaVersion: schemaVersion,
+		ExportedAt:    timeutil.Format(time.Now()),
+		Tables:        make(map[string][]Row, len(Tables)),
+	}
+	for _, t := range Tables {
+		rows, err := dumpTable(ctx, tx, t.Name, t.Key)
+		if err != nil {
+			return nil, err
+		}
+		b.Tables[t.Name] = rows
+	}
+	return b, nil
+}
+
+func dumpTable(ctx context.Context, tx *sql.Tx, table, key string) ([]Row, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %s ORDER BY %s`, table, key))
+	if err != nil {
+		return nil, fmt.Errorf("export %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("export %s: %w", table, err)
+	}
+	out := make([]Row, 0)
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("export %s: %w", table, err)
+		}
+		row := make(Row, len(cols))
+		for i, c := range cols {
+			if b, ok := vals[i].([]byte); ok {
+				vals[i] = string(b)
+			}
+			row[c] = vals[i]
+		}
+		out = append(out, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("export %s: %w", table, err)
+	}
+	return out, nil
+}
+
+// WriteJSON encodes the bundle, optionally wrapped in a zip archive.
+func (b *Bundle) WriteJSON(w io.Writer, zipped bool) error {
+	if !zipped {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(b)
+	}
+	zw := zip.NewWriter(w)
+	f, err := zw.Create(zipEntry)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(b); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Decode reads a bundle from either plain JSON or a zip archive holding
+// bundle.json.
+func Decode(data []byte) (*Bundle, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("read zip: %w", err)
+		}
+		f, err := zr.Open(zipEntry)
+		if err != nil {
+			return nil, fmt.Errorf("zip has no %s", zipEntry)
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			return nil, fmt.Errorf("read zip: %w", err)
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var b Bundle
+	if err := dec.Decode(&b); err != nil {
+		return nil, fmt.Errorf("bad json: %w", err)
+	}
+	if b.Format != Format {
+		return nil, fmt.Errorf("not a %s document", Format)
+	}
+	if b.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version: %d", b.Version)
+	}
+	return &b, nil
+}
+
+// Import writes a bundle in foreign-key order inside one transaction; any
+// error rolls the whole import back, and so does a dry run (see
+// store.WithDryRun). Columns missing from the bundle (older exports) take
+// their defaults; columns unknown to this schema are rejected.
+func Import(ctx context.Context, dbx *sql.DB, b *Bundle, strategy Strategy) ([]TableResult, error) {
+	known := make(map[string]bool, len(Tables))
+	for _, t := range Tables {
+		known[t.Name] = true
+	}
+	for name := range b.Tables {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown table in bundle: %s", name)
+		}
+	}
+
+	tx, err := dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]TableResult, 0, len(Tables))
+	for _, t := range Tables {
+		tableStrategy := strategy
+		if t.Seeded && strategy == StrategyFail {
+			tableStrategy = StrategyReplace
+		}
+		res, err := importTable(ctx, tx, t.Name, t.Key, b.Tables[t.Name], tableStrategy)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+
+	if err := store.Commit(ctx, tx); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func importTable(ctx context.Context, tx *sql.Tx, table, key string, rows []Row, strategy Strategy) (TableResult, error) {
+	res := TableResult{Table: table, Rows: len(rows)}
+	if len(rows) == 0 {
+		return res, nil
+	}
+
+	columns, err := tableColumns(ctx, tx, table)
+	if err != nil {
+		return res, err
+	}
+	keyCols := make(map[string]bool)
+	for _, k := range strings.Split(key, ",") {
+		keyCols[strings.TrimSpace(k)] = true
+	}
+
+	for i, row := range rows {
+		cols := make([]string, 0, len(row))
+		args := make([]any, 0, len(row))
+		for _, c := range columns {
+			v, ok := row[c]
+			if !ok {
+				continue
+			}
+			// Older bundles carry "YYYY-MM-DD HH:MM:SS" timestamps.
+			if ts, ok := v.(string); ok && strings.HasSuffix(c, "_at") {
+				if norm, err := timeutil.Normalize(ts); err == nil {
+					v = norm
+				}
+			}
+			cols = append(cols, c)
+			args = append(args, sqlValue(v))
+		}
+		if len(cols) != len(row) {
+			for c := range row {
+				if !slices.Contains(columns, c) {
+					return res, fmt.Errorf("%s row %d: unknown column %s", table, i+1, c)
+				}
+			}
+		}
+		for k := range keyCols {
+			if _, ok := row[k]; !ok {
+				return res, fmt.Errorf("%s row %d: missing key column %s", table, i+1, k)
+			}
+		}
+
+		query := fmt.Sprintf(`INSERT INTO %s(%s) VALUES(%s)`,
+			table, strings.Join(cols, ", "), strings.TrimSuffix(strings.Repeat("?,", len(cols)), ","))
+		switch strategy {
+		case StrategySkip:
+			query += fmt.Sprintf(` ON CONFLICT(%s) DO NOTHING`, key)
+		case StrategyReplace:
+			sets := make([]string, 0, len(cols))
+			for _, c := range cols {
+				if !keyCols[c] {
+					sets = append(sets, fmt.Sprintf("%s = excluded.%s", c, c))
+				}
+			}
+			if len(sets) == 0 {
+				query += fmt.Sprintf(` ON CONFLICT(%s) DO NOTHING`, key)
+			} else {
+				query += fmt.Sprintf(` ON CONFLICT(%s) DO UPDATE SET %s`, key, strings.Join(sets, ", "))
+			}
+		}
+
+		r, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			if strategy == StrategyFail && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				return res, fmt.Errorf("%w: %s row %d: %v", ErrConflict, table, i+1, err)
+			}
+			return res, fmt.Errorf("%s row %d: %w", table, i+1, err)
+		}
+		if n, _ := r.RowsAffected(); n > 0 {
+			res.Written++
+		} else {
+			res.Skipped++
+		}
+	}
+	return res, nil
+}
+
+func tableColumns(ctx context.Context, tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT name FROM pragma_table_info('%s')`, table))
+	if err != nil {
+		return nil, fmt.Errorf("load columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	out := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("load columns of %s: %w", table, err)
+		}
+		out = append(out, name)
+	}
+	return out, rows.Err()
+}
+
+// sqlValue turns decoded JSON numbers back into int64/float64 so they are
+// stored with the right type.
+func sqlValue(v any) any {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if f, err := n.Float64(); err == nil {
+		return f
+	}
+	return n.String()
+}
@@ -0,0 +1,114 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"stockmate/internal/testutil"
+)
+
+// roundTrip exports conn as a zip and decodes it again, as the import
+// endpoint would receive it.
+func roundTrip(t *testing.T, conn *sql.DB) *Bundle {
+	t.Helper()
+	b, err := Export(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := b.WriteJSON(&buf, true); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func itemName(t *testing.T, conn *sql.DB, id int64) string {
+	t.Helper()
+	var name string
+	if err := conn.QueryRow(`SELECT name FROM items WHERE item_id = ?`, id).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := testutil.SeededDB(t)
+	b := roundTrip(t, src)
+	if len(b.Tables["items"]) == 0 || len(b.Tables["stock_transactions"]) == 0 {
+		t.Fatal("demo bundle has no items or transactions")
+	}
+
+	dst := testutil.OpenDB(t)
+	if _, err := Import(ctx, dst, b, StrategyFail); err != nil {
+		t.Fatal(err)
+	}
+	if got := roundTrip(t, dst); !reflect.DeepEqual(got.Tables, b.Tables) {
+		for _, tb := range Tables {
+			if !reflect.DeepEqual(got.Tables[tb.Name], b.Tables[tb.Name]) {
+				t.Errorf("%s differs after import", tb.Name)
+			}
+		}
+	}
+
+	// The same bundle again collides under fail and changes nothing.
+	if _, err := dst.Exec(`UPDATE items SET name = 'local edit' WHERE item_id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Import(ctx, dst, b, StrategyFail); !errors.Is(err, ErrConflict) {
+		t.Fatalf("second import under fail: %v, want ErrConflict", err)
+	}
+
+	res, err := Import(ctx, dst, b, StrategySkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		if r.Written != 0 || r.Skipped != r.Rows {
+			t.Errorf("skip %s: %+v, want every row skipped", r.Table, r)
+		}
+	}
+	if got := itemName(t, dst, 1); got != "local edit" {
+		t.Fatalf("item after skip = %q, want the local edit kept", got)
+	}
+
+	res, err = Import(ctx, dst, b, StrategyReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		if r.Written != r.Rows {
+			t.Errorf("replace %s: %+v, want every row written", r.Table, r)
+		}
+	}
+	if got, want := itemName(t, dst, 1), itemName(t, src, 1); got != want {
+		t.Fatalf("item after replace = %q, want %q", got, want)
+	}
+}
+
+func TestImportUnknownColumn(t *testing.T) {
+	ctx := context.Background()
+	b := roundTrip(t, testutil.SeededDB(t))
+	b.Tables["items"][0]["colour"] = "red"
+
+	dst := testutil.OpenDB(t)
+	if _, err := Import(ctx, dst, b, StrategyFail); err == nil || !strings.Contains(err.Error(), "unknown column colour") {
+		t.Fatalf("import: %v, want unknown column", err)
+	}
+	var n int
+	if err := dst.QueryRow(`SELECT COUNT(1) FROM items`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("%d items left by a rejected import", n)
+	}
+}
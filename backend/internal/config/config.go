@@ -0,0 +1,516 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the server configuration, read from environment variables.
+type Config struct {
+	AppEnv string
+	DSN    string
+	Port   int
+	// DB pool and pragma settings; parameters in the DSN take precedence.
+	DBMaxOpenConns int
+	DBBusyTimeout  time.Duration
+	DBSynchronous  string
+
+	// DemoSeed fills an empty database with sample data at startup; pair it
+	// with DSN sqlite::memory: for a throwaway demo.
+	DemoSeed bool
+
+	// RateLimitRPS is the sustained request rate allowed per client IP.
+	// Zero disables rate limiting.
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// MaxBodyBytes caps request bodies other than multipart uploads, which
+	// are capped by the attachment size.
+	MaxBodyBytes int64
+	// BOMMaxComponents caps the lines of one BOM revision.
+	BOMMaxComponents int
+	// CompressMinBytes is the smallest response body that is gzip/deflate
+	// encoded. Negative disables compression.
+	CompressMinBytes int
+	// QueryTimeout bounds the database work of one request. Zero disables it.
+	QueryTimeout time.Duration
+	// ReadOnly starts the server rejecting mutations; the admin toggle
+	// cannot turn it off.
+	ReadOnly bool
+	// APIKeysRequired rejects /api requests that carry no API key. Keys
+	// are checked against their scope whenever one is sent.
+	APIKeysRequired bool
+	// ShutdownTimeout is how long in-flight requests may finish after
+	// SIGINT/SIGTERM before their queries are cancelled.
+	ShutdownTimeout time.Duration
+
+	// TLS is served directly either from a cert/key pair or from
+	// certificates obtained via ACME for AutocertDomains.
+	TLSCertFile      string
+	TLSKeyFile       string
+	AutocertDomains  []string
+	AutocertCacheDir string
+	// HTTPRedirectPort, when set, runs a plain HTTP listener that redirects
+	// to HTTPS (and answers ACME HTTP-01 challenges).
+	HTTPRedirectPort int
+
+	// TrustedProxies lists peers whose X-Forwarded-For/Proto headers are
+	// believed. Empty means forwarded headers are ignored.
+	TrustedProxies []netip.Prefix
+	// SecureCookies marks cookies Secure; defaults to on when TLS is enabled.
+	SecureCookies bool
+	// CORSOrigins are the browser origins allowed to call the API: exact,
+	// wildcard subdomain (https://*.example.com) or "*".
+	CORSOrigins          []string
+	CORSAllowCredentials bool
+	// CORSMaxAge is how long browsers may cache a preflight answer.
+	CORSMaxAge time.Duration
+
+	// The stock snapshot job runs daily at SnapshotHour:SnapshotMinute local
+	// time unless disabled.
+	SnapshotEnabled bool
+	SnapshotHour    int
+	SnapshotMinute  int
+	// WAL checkpoint and incremental vacuum run daily at
+	// MaintenanceHour:MaintenanceMinute unless disabled.
+	MaintenanceEnabled bool
+	MaintenanceHour    int
+	MaintenanceMinute  int
+
+	// PDF reports print ReportHeader (e.g. the company name) on every page
+	// and the JPEG at ReportLogoFile on the first.
+	ReportHeader   string
+	ReportLogoFile string
+
+	// Email notifications are sent when SMTPHost, SMTPFrom and NotifyEmailTo
+	// are all set.
+	SMTPHost      string
+	SMTPPort      int
+	SMTPUsername  string
+	SMTPPassword  string
+	SMTPFrom      string
+	NotifyEmailTo []string
+	// The low-stock digest is mailed daily at DigestHour:DigestMinute.
+	DigestEnabled bool
+	DigestHour    int
+	DigestMinute  int
+
+	// ShopSyncChannel (shopify or base) enables pushing on-hand quantities
+	// of listed items every ShopSyncInterval; zero interval means manual only.
+	ShopSyncChannel    string
+	ShopSyncInterval   time.Duration
+	ShopifyShop        string
+	ShopifyAccessToken string
+	ShopifyLocationID  string
+	BaseAccessToken    string
+
+	// OrderWebhookSecret signs order webhooks; empty disables the endpoint.
+	OrderWebhookSecret string
+
+	// OutboxWebhookURL receives the recorded changes (the ones the event
+	// stream publishes), sent from the outbox every OutboxInterval and signed
+	// with OutboxWebhookSecret when set; empty disables recording them.
+	OutboxWebhookURL    string
+	OutboxWebhookSecret string
+	OutboxInterval      time.Duration
+
+	// OpenID Connect sign-in is enabled when OIDCIssuer is set. A user's
+	// role comes from the values of OIDCRoleClaim mapped by OIDCRoleMap
+	// (claim value to role) at every sign-in; without a match, new users
+	// get OIDCDefaultRole. OIDCAllowedDomains limits sign-in by email
+	// domain.
+	OIDCIssuer         string
+	OIDCClientID       string
+	OIDCClientSecret   string
+	OIDCRedirectURL    string
+	OIDCScopes         []string
+	OIDCRoleClaim      string
+	OIDCRoleMap        map[string]string
+	OIDCDefaultRole    string
+	OIDCAllowedDomains []string
+	// SessionTTL is how long a sign-in lasts.
+	SessionTTL time.Duration
+
+	// TrashRetention is how long deleted BOM revisions and purchase links
+	// stay restorable before they are purged; zero keeps them forever.
+	TrashRetention time.Duration
+
+	// ArchiveAfterYears is the age in years past which ledger rows are
+	// archived daily behind an opening balance; zero turns archival off.
+	ArchiveAfterYears int
+}
+
+// OIDCEnabled reports whether users sign in through an OpenID provider.
+func (c Config) OIDCEnabled() bool {
+	return c.OIDCIssuer != ""
+}
+
+// TLSEnabled reports whether the server terminates TLS itself.
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.AutocertDomains) > 0
+}
+
+func Load() (Config, error) {
+	cfg := Config{
+		AppEnv:         strings.TrimSpace(os.Getenv("APP_ENV")),
+		DSN:            strings.TrimSpace(os.Getenv("DB_DSN")),
+		Port:           8080,
+		RateLimitRPS:   20,
+		RateLimitBurst: 40,
+		MaxBodyBytes:   1 << 20,
+
+		BOMMaxComponents: 500,
+
+		DBMaxOpenConns: 1,
+		DBBusyTimeout:  5 * time.Second,
+
+		CompressMinBytes: 1024,
+
+		QueryTimeout:    30 * time.Second,
+		ShutdownTimeout: 10 * time.Second,
+
+		SnapshotEnabled: true,
+		SnapshotHour:    2,
+
+		MaintenanceEnabled: true,
+		MaintenanceHour:    3,
+		MaintenanceMinute:  30,
+
+		SMTPPort:      587,
+		DigestEnabled: true,
+		DigestHour:    8,
+
+		ShopSyncInterval: 15 * time.Minute,
+
+		OutboxInterval: 5 * time.Second,
+
+		CORSOrigins: []string{"http://localhost:5173"},
+		CORSMaxAge:  10 * time.Minute,
+
+		OIDCScopes:      []string{"openid", "email", "profile"},
+		OIDCDefaultRole: "read-only",
+		SessionTTL:      24 * time.Hour,
+
+		TrashRetention: 30 * 24 * time.Hour,
+	}
+	if cfg.DSN == "" {
+		cfg.DSN = "sqlite:./data/stockmate.db"
+	}
+
+	var err error
+	if cfg.Port, err = envInt("PORT", cfg.Port); err != nil {
+		return cfg, err
+	}
+	if cfg.RateLimitRPS, err = envFloat("RATE_LIMIT_RPS", cfg.RateLimitRPS); err != nil {
+		return cfg, err
+	}
+	if cfg.RateLimitBurst, err = envInt("RATE_LIMIT_BURST", cfg.RateLimitBurst); err != nil {
+		return cfg, err
+	}
+	maxBody, err := envInt("MAX_BODY_BYTES", int(cfg.MaxBodyBytes))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.MaxBodyBytes = int64(maxBody)
+	if cfg.BOMMaxComponents, err = envInt("BOM_MAX_COMPONENTS", cfg.BOMMaxComponents); err != nil {
+		return cfg, err
+	}
+	if cfg.CompressMinBytes, err = envInt("COMPRESS_MIN_BYTES", cfg.CompressMinBytes); err != nil {
+		return cfg, err
+	}
+	if cfg.QueryTimeout, err = envDuration("QUERY_TIMEOUT", cfg.QueryTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.ReadOnly, err = envBool("READ_ONLY", false); err != nil {
+		return cfg, err
+	}
+	if cfg.APIKeysRequired, err = envBool("API_KEYS_REQUIRED", false); err != nil {
+		return cfg, err
+	}
+	if cfg.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.DemoSeed, err = envBool("DEMO_SEED", false); err != nil {
+		return cfg, err
+	}
+	if cfg.DBMaxOpenConns, err = envInt("DB_MAX_OPEN_CONNS", cfg.DBMaxOpenConns); err != nil {
+		return cfg, err
+	}
+	if cfg.DBMaxOpenConns < 1 {
+		return cfg, fmt.Errorf("DB_MAX_OPEN_CONNS must be at least 1: %d", cfg.DBMaxOpenConns)
+	}
+	if cfg.DBBusyTimeout, err = envDuration("DB_BUSY_TIMEOUT", cfg.DBBusyTimeout); err != nil {
+		return cfg, err
+	}
+	cfg.DBSynchronous = strings.TrimSpace(os.Getenv("DB_SYNCHRONOUS"))
+
+	cfg.TLSCertFile = strings.TrimSpace(os.Getenv("TLS_CERT_FILE"))
+	cfg.TLSKeyFile = strings.TrimSpace(os.Getenv("TLS_KEY_FILE"))
+	cfg.AutocertDomains = envList("TLS_AUTOCERT_DOMAINS")
+	cfg.AutocertCacheDir = strings.TrimSpace(os.Getenv("TLS_AUTOCERT_CACHE"))
+	if cfg.AutocertCacheDir == "" {
+		cfg.AutocertCacheDir = "./data/autocert"
+	}
+	if cfg.HTTPRedirectPort, err = envInt("HTTP_REDIRECT_PORT", 0); err != nil {
+		return cfg, err
+	}
+	for _, v := range envList("TRUSTED_PROXIES") {
+		prefix, err := parsePrefix(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid TRUSTED_PROXIES entry: %q", v)
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, prefix)
+	}
+	if cfg.SecureCookies, err = envBool("COOKIE_SECURE", cfg.TLSEnabled()); err != nil {
+		return cfg, err
+	}
+	if _, ok := os.LookupEnv("CORS_ORIGINS"); ok {
+		cfg.CORSOrigins = envList("CORS_ORIGINS")
+	}
+	for _, v := range cfg.CORSOrigins {
+		if !validOrigin(v) {
+			return cfg, fmt.Errorf("invalid CORS_ORIGINS entry: %q", v)
+		}
+	}
+	if cfg.CORSAllowCredentials, err = envBool("CORS_ALLOW_CREDENTIALS", false); err != nil {
+		return cfg, err
+	}
+	if cfg.CORSAllowCredentials && slices.Contains(cfg.CORSOrigins, "*") {
+		return cfg, fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be used with CORS_ORIGINS=*")
+	}
+	if cfg.CORSMaxAge, err = envDuration("CORS_MAX_AGE", cfg.CORSMaxAge); err != nil {
+		return cfg, err
+	}
+
+	if cfg.SnapshotEnabled, cfg.SnapshotHour, cfg.SnapshotMinute, err = envDailyTime("STOCK_SNAPSHOT_TIME", cfg.SnapshotHour, cfg.SnapshotMinute); err != nil {
+		return cfg, err
+	}
+
+	if cfg.MaintenanceEnabled, cfg.MaintenanceHour, cfg.MaintenanceMinute, err = envDailyTime("DB_MAINTENANCE_TIME", cfg.MaintenanceHour, cfg.MaintenanceMinute); err != nil {
+		return cfg, err
+	}
+
+	cfg.ReportHeader = strings.TrimSpace(os.Getenv("REPORT_HEADER"))
+	cfg.ReportLogoFile = strings.TrimSpace(os.Getenv("REPORT_LOGO_FILE"))
+
+	cfg.SMTPHost = strings.TrimSpace(os.Getenv("SMTP_HOST"))
+	if cfg.SMTPPort, err = envInt("SMTP_PORT", cfg.SMTPPort); err != nil {
+		return cfg, err
+	}
+	cfg.SMTPUsername = strings.TrimSpace(os.Getenv("SMTP_USERNAME"))
+	cfg.SMTPPassword = os.Getenv("SMTP_PASSWORD")
+	cfg.SMTPFrom = strings.TrimSpace(os.Getenv("SMTP_FROM"))
+	cfg.NotifyEmailTo = envList("NOTIFY_EMAIL_TO")
+	if cfg.DigestEnabled, cfg.DigestHour, cfg.DigestMinute, err = envDailyTime("LOW_STOCK_DIGEST_TIME", cfg.DigestHour, cfg.DigestMinute); err != nil {
+		return cfg, err
+	}
+
+	cfg.ShopSyncChannel = strings.ToLower(strings.TrimSpace(os.Getenv("SHOP_SYNC_CHANNEL")))
+	if cfg.ShopSyncInterval, err = envDuration("SHOP_SYNC_INTERVAL", cfg.ShopSyncInterval); err != nil {
+		return cfg, err
+	}
+	cfg.ShopifyShop = strings.TrimSpace(os.Getenv("SHOPIFY_SHOP"))
+	cfg.ShopifyAccessToken = strings.TrimSpace(os.Getenv("SHOPIFY_ACCESS_TOKEN"))
+	cfg.ShopifyLocationID = strings.TrimSpace(os.Getenv("SHOPIFY_LOCATION_ID"))
+	cfg.BaseAccessToken = strings.TrimSpace(os.Getenv("BASE_ACCESS_TOKEN"))
+	cfg.OrderWebhookSecret = os.Getenv("ORDER_WEBHOOK_SECRET")
+	cfg.OutboxWebhookURL = strings.TrimSpace(os.Getenv("OUTBOX_WEBHOOK_URL"))
+	cfg.OutboxWebhookSecret = os.Getenv("OUTBOX_WEBHOOK_SECRET")
+	if cfg.OutboxInterval, err = envDuration("OUTBOX_DISPATCH_INTERVAL", cfg.OutboxInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.OutboxInterval <= 0 {
+		return cfg, fmt.Errorf("OUTBOX_DISPATCH_INTERVAL must be > 0")
+	}
+
+	cfg.OIDCIssuer = strings.TrimSpace(os.Getenv("OIDC_ISSUER"))
+	cfg.OIDCClientID = strings.TrimSpace(os.Getenv("OIDC_CLIENT_ID"))
+	cfg.OIDCClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	cfg.OIDCRedirectURL = strings.TrimSpace(os.Getenv("OIDC_REDIRECT_URL"))
+	if v := strings.Fields(os.Getenv("OIDC_SCOPES")); len(v) > 0 {
+		cfg.OIDCScopes = v
+	}
+	cfg.OIDCRoleClaim = strings.TrimSpace(os.Getenv("OIDC_ROLE_CLAIM"))
+	for _, v := range envList("OIDC_ROLE_MAP") {
+		role, value, ok := strings.Cut(v, "=")
+		role, value = strings.TrimSpace(role), strings.TrimSpace(value)
+		if !ok || !validRole(role) || value == "" {
+			return cfg, fmt.Errorf("invalid OIDC_ROLE_MAP entry: %q", v)
+		}
+		if cfg.OIDCRoleMap == nil {
+			cfg.OIDCRoleMap = map[string]string{}
+		}
+		cfg.OIDCRoleMap[value] = role
+	}
+	if v := strings.TrimSpace(os.Getenv("OIDC_DEFAULT_ROLE")); v != "" {
+		cfg.OIDCDefaultRole = v
+	}
+	if !validRole(cfg.OIDCDefaultRole) {
+		return cfg, fmt.Errorf("invalid OIDC_DEFAULT_ROLE: %q", cfg.OIDCDefaultRole)
+	}
+	for _, v := range envList("OIDC_ALLOWED_DOMAINS") {
+		cfg.OIDCAllowedDomains = append(cfg.OIDCAllowedDomains, strings.ToLower(v))
+	}
+	if cfg.OIDCEnabled() && (cfg.OIDCClientID == "" || cfg.OIDCRedirectURL == "") {
+		return cfg, fmt.Errorf("OIDC_ISSUER needs OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+	}
+	if cfg.SessionTTL, err = envDuration("SESSION_TTL", cfg.SessionTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.SessionTTL <= 0 {
+		return cfg, fmt.Errorf("SESSION_TTL must be > 0")
+	}
+	if cfg.TrashRetention, err = envDuration("TRASH_RETENTION", cfg.TrashRetention); err != nil {
+		return cfg, err
+	}
+	if cfg.ArchiveAfterYears, err = envInt("ARCHIVE_AFTER_YEARS", cfg.ArchiveAfterYears); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return cfg, fmt.Errorf("PORT out of range: %d", cfg.Port)
+	}
+	if cfg.RateLimitRPS < 0 {
+		return cfg, fmt.Errorf("RATE_LIMIT_RPS must be >= 0")
+	}
+	if cfg.RateLimitBurst <= 0 {
+		return cfg, fmt.Errorf("RATE_LIMIT_BURST must be > 0")
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		return cfg, fmt.Errorf("MAX_BODY_BYTES must be > 0")
+	}
+	if cfg.BOMMaxComponents <= 0 {
+		return cfg, fmt.Errorf("BOM_MAX_COMPONENTS must be > 0")
+	}
+	if cfg.ArchiveAfterYears < 0 {
+		return cfg, fmt.Errorf("ARCHIVE_AFTER_YEARS must be >= 0")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return cfg, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.TLSCertFile != "" && len(cfg.AutocertDomains) > 0 {
+		return cfg, fmt.Errorf("TLS_CERT_FILE and TLS_AUTOCERT_DOMAINS are mutually exclusive")
+	}
+	if cfg.HTTPRedirectPort < 0 || cfg.HTTPRedirectPort > 65535 {
+		return cfg, fmt.Errorf("HTTP_REDIRECT_PORT out of range: %d", cfg.HTTPRedirectPort)
+	}
+	if cfg.SMTPPort <= 0 || cfg.SMTPPort > 65535 {
+		return cfg, fmt.Errorf("SMTP_PORT out of range: %d", cfg.SMTPPort)
+	}
+	if cfg.HTTPRedirectPort != 0 && !cfg.TLSEnabled() {
+		return cfg, fmt.Errorf("HTTP_REDIRECT_PORT requires TLS to be enabled")
+	}
+	return cfg, nil
+}
+
+// envDailyTime reads a daily HH:MM schedule; "off" disables it.
+func envDailyTime(name string, hour, minute int) (bool, int, int, error) {
+	switch v := strings.ToLower(strings.TrimSpace(os.Getenv(name))); v {
+	case "":
+		return true, hour, minute, nil
+	case "off":
+		return false, hour, minute, nil
+	default:
+		t, err := time.Parse("15:04", v)
+		if err != nil {
+			return false, 0, 0, fmt.Errorf("invalid %s: %q (want HH:MM or off)", name, v)
+		}
+		return true, t.Hour(), t.Minute(), nil
+	}
+}
+
+func envInt(name string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return n, nil
+}
+
+func envFloat(name string, def float64) (float64, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return f, nil
+}
+
+// envDuration accepts a Go duration such as "30s" or "2m".
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return d, nil
+}
+
+func envBool(name string, def bool) (bool, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return b, nil
+}
+
+// envList splits a comma separated variable, dropping empty entries.
+func envList(name string) []string {
+	out := make([]string, 0)
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// validRole reports whether v is a user role (an API key scope).
+func validRole(v string) bool {
+	return v == "read-only" || v == "stock-write" || v == "admin"
+}
+
+// validOrigin accepts "*" or scheme://host[:port], where host may start
+// with "*." to match any subdomain.
+func validOrigin(v string) bool {
+	if v == "*" {
+		return true
+	}
+	scheme, host, ok := strings.Cut(v, "://")
+	if !ok || (scheme != "http" && scheme != "https") {
+		return false
+	}
+	host = strings.TrimPrefix(host, "*.")
+	return host != "" && !strings.ContainsAny(host, "/*?#")
+}
+
+// parsePrefix accepts either a CIDR or a single address.
+func parsePrefix(v string) (netip.Prefix, error) {
+	if strings.Contains(v, "/") {
+		p, err := netip.ParsePrefix(v)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(v)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -3,27 +3,141 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
+// Options tune the connection pool and per-connection pragmas. Zero values
+// keep the defaults: one connection, a 5s busy timeout and SQLite's own
+// synchronous setting.
+type Options struct {
+	MaxOpenConns int
+	BusyTimeout  time.Duration
+	// Synchronous is OFF, NORMAL, FULL or EXTRA. NORMAL is safe with WAL
+	// against application crashes but may lose the last commits on power loss.
+	Synchronous string
+}
+
+const defaultBusyTimeout = 5 * time.Second
+
+// MemoryDSN opens a private in-memory database, e.g. for tests and demos.
+const MemoryDSN = "sqlite::memory:"
+
+// memSeq names in-memory databases so that each Open gets its own.
+var memSeq atomic.Int64
+
 func Open(dsn string) (*sql.DB, error) {
+	return OpenWith(dsn, Options{})
+}
+
+// OpenWith opens dsn with opts. For sqlite the DSN may override them with
+// query parameters, e.g.
+// sqlite:./data/stockmate.db?max_open_conns=4&busy_timeout=10s&synchronous=normal
+// Other parameters are passed to the driver unchanged.
+//
+// sqlite::memory: opens a fresh in-memory database shared by all
+// connections of the returned pool and already migrated. It lives until the
+// pool is closed.
+func OpenWith(dsn string, opts Options) (*sql.DB, error) {
 	// 例: sqlite:./data/stockmate.db
 	if strings.HasPrefix(dsn, "sqlite:") {
-		path := strings.TrimPrefix(dsn, "sqlite:")
+		path, rawQuery, _ := strings.Cut(strings.TrimPrefix(dsn, "sqlite:"), "?")
+		params, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DSN parameters: %w", err)
+		}
+		if err := opts.fromParams(params); err != nil {
+			return nil, err
+		}
+		if opts.MaxOpenConns <= 0 {
+			opts.MaxOpenConns = 1
+		}
+		if opts.BusyTimeout <= 0 {
+			opts.BusyTimeout = defaultBusyTimeout
+		}
+
+		memory := path == ":memory:"
+		if memory {
+			// A named shared-cache database is visible to every connection
+			// of the pool but not to other pools.
+			path = fmt.Sprintf("stockmate-mem-%d", memSeq.Add(1))
+			params.Set("mode", "memory")
+			params.Set("cache", "shared")
+		}
+
 		// modernc sqliteは file: 形式も使える。相対パスならこのままでもOK。
 		// WAL推奨（突然の電源断/抜き取り耐性を上げる）
-		conn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)", path)
-		db, err := sql.Open("sqlite", conn)
+		// _pragma は接続ごとに適用されるので、プールを広げても全接続に効く。
+		params.Add("_pragma", "journal_mode(WAL)")
+		params.Add("_pragma", "foreign_keys(1)")
+		params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", opts.BusyTimeout.Milliseconds()))
+		if opts.Synchronous != "" {
+			params.Add("_pragma", fmt.Sprintf("synchronous(%s)", opts.Synchronous))
+		}
+		db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
 		if err != nil {
 			return nil, err
 		}
-		// SQLiteは基本1接続運用が安定
-		db.SetMaxOpenConns(1)
-		db.SetMaxIdleConns(1)
+		// SQLiteは基本1接続運用が安定。WAL なら複数接続で読み取りを並行できる。
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+		db.SetMaxIdleConns(opts.MaxOpenConns)
+		if memory {
+			// The database is dropped with its last connection, so idle
+			// connections must never expire.
+			db.SetConnMaxIdleTime(0)
+			db.SetConnMaxLifetime(0)
+			if err := Migrate(db); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
 		return db, nil
 	}
 
 	return nil, fmt.Errorf("unsupported DSN: %s", dsn)
-}
\ No newline at end of file
+}
+
+// fromParams takes the pool settings out of the DSN query, leaving the rest
+// for the driver.
+func (o *Options) fromParams(params url.Values) error {
+	if v := params.Get("max_open_conns"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid max_open_conns: %q", v)
+		}
+		o.MaxOpenConns = n
+	}
+	if v := params.Get("busy_timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			// Bare numbers are milliseconds, as in PRAGMA busy_timeout.
+			ms, err2 := strconv.Atoi(v)
+			if err2 != nil {
+				return fmt.Errorf("invalid busy_timeout: %q", v)
+			}
+			d = time.Duration(ms) * time.Millisecond
+		}
+		if d < 0 {
+			return fmt.Errorf("invalid busy_timeout: %q", v)
+		}
+		o.BusyTimeout = d
+	}
+	if v := params.Get("synchronous"); v != "" {
+		o.Synchronous = v
+	}
+	params.Del("max_open_conns")
+	params.Del("busy_timeout")
+	params.Del("synchronous")
+
+	o.Synchronous = strings.ToUpper(strings.TrimSpace(o.Synchronous))
+	switch o.Synchronous {
+	case "", "OFF", "NORMAL", "FULL", "EXTRA":
+		return nil
+	}
+	return fmt.Errorf("invalid synchronous: %q (want off, normal, full or extra)", o.Synchronous)
+}
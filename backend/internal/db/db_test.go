@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestOpenMemoryIsMigrated(t *testing.T) {
+	conn, err := Open(MemoryDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	v, err := AppliedSchemaVersion(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != SchemaVersion {
+		t.Fatalf("schema version = %d, want %d", v, SchemaVersion)
+	}
+}
+
+func TestOpenMemoryIsPrivate(t *testing.T) {
+	a, err := Open(MemoryDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := Open(MemoryDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if _, err := a.Exec(`INSERT INTO series(name) VALUES ('only in a')`); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := b.QueryRow(`SELECT COUNT(1) FROM series`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("second database sees %d series, want 0", n)
+	}
+}
+
+func TestOpenMemorySharedAcrossPool(t *testing.T) {
+	conn, err := Open(MemoryDSN + "?max_open_conns=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	c1, err := conn.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := conn.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	if _, err := c1.ExecContext(ctx, `INSERT INTO series(name) VALUES ('shared')`); err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err := c2.QueryRowContext(ctx, `SELECT name FROM series`).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "shared" {
+		t.Fatalf("name = %q", name)
+	}
+}
+
+func TestSeedDemo(t *testing.T) {
+	conn, err := Open(MemoryDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	seeded, err := SeedDemo(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seeded {
+		t.Fatal("empty database was not seeded")
+	}
+	if seeded, err = SeedDemo(ctx, conn); err != nil || seeded {
+		t.Fatalf("second seed = %v, %v; want no-op", seeded, err)
+	}
+
+	var stock float64
+	if err := conn.QueryRow(`
+SELECT SUM(CASE WHEN t.transaction_type = 'OUT' THEN -t.qty ELSE t.qty END)
+FROM stock_transactions t
+JOIN items i ON i.item_id = t.item_id
+WHERE i.sku = 'ASM-LAMP'
+`).Scan(&stock); err != nil {
+		t.Fatal(err)
+	}
+	if stock != 3 {
+		t.Fatalf("lamp stock = %v, want 3", stock)
+	}
+}
+
+func TestMigrateRestrictsItemRefs(t *testing.T) {
+	conn, err := Open(MemoryDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A database from before the change has the cascading definition.
+	old := strings.Replace(createStocktakeLines, "ON DELETE RESTRICT", "ON DELETE CASCADE", 1)
+	for _, q := range []string{
+		`DROP TABLE stocktake_lines`,
+		old,
+		`INSERT INTO items(sku, name, item_type, managed_unit) VALUES ('PRT-X', 'x', 'component', 'pcs')`,
+		`INSERT INTO stocktakes(stocktake_id, name) VALUES (1, 'Shelf')`,
+		`INSERT INTO stocktake_lines(stocktake_id, item_id, system_qty) SELECT 1, item_id, 4 FROM items`,
+	} {
+		if _, err := conn.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	if err := Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	var tableSQL string
+	var n int
+	if err := conn.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'stocktake_lines'`).Scan(&tableSQL); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRow(`SELECT COUNT(1) FROM stocktake_lines WHERE system_qty = 4`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(tableSQL, "ON DELETE RESTRICT") || n != 1 {
+		t.Fatalf("rebuilt with %d rows:%s", n, tableSQL)
+	}
+	if _, err := conn.Exec(`DELETE FROM items`); err == nil {
+		t.Fatal("deleting a counted item succeeded")
+	}
+}
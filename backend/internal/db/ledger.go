@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// LedgerAppendOnlyKey is the app_settings key that makes stock_transactions
+// append-only once set to "1". Corrections are then booked as new rows
+// linked by reversal_of and correction_of.
+const LedgerAppendOnlyKey = "ledger_append_only"
+
+// ledgerRefColumns may still be filled in on a row that has no reference,
+// which is how movements are tied to their document right after insert.
+var ledgerRefColumns = map[string]bool{"ref_type": true, "ref_id": true}
+
+// LedgerAppendOnly reports whether stock_transactions is append-only and
+// since when.
+func LedgerAppendOnly(ctx context.Context, db *sql.DB) (bool, string, error) {
+	var value, since string
+	err := db.QueryRowContext(ctx, `SELECT value, updated_at FROM app_settings WHERE key = ?`, LedgerAppendOnlyKey).Scan(&value, &since)
+	if err == sql.ErrNoRows {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	if value != "1" {
+		return false, "", nil
+	}
+	return true, since, nil
+}
+
+// EnableLedgerAppendOnly makes stock_transactions append-only. It cannot be
+// undone short of editing the database by hand.
+func EnableLedgerAppendOnly(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO app_settings(key, value) VALUES(?, '1')
+ON CONFLICT(key) DO UPDATE SET value = '1', updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+WHERE value <> '1'
+`, LedgerAppendOnlyKey); err != nil {
+		return err
+	}
+	if err := createLedgerGuard(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureLedgerGuard re-creates the append-only triggers when the mode is
+// on: a table rebuild drops them, and new columns must be covered.
+func ensureLedgerGuard(db *sql.DB) error {
+	on, _, err := LedgerAppendOnly(context.Background(), db)
+	if err != nil {
+		return fmt.Errorf("migration failed at load ledger_append_only: %w", err)
+	}
+	if !on {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("migration failed at begin ledger guard: %w", err)
+	}
+	defer tx.Rollback()
+	if err := createLedgerGuard(tx); err != nil {
+		return fmt.Errorf("migration failed at ledger guard: %w", err)
+	}
+	return tx.Commit()
+}
+
+// createLedgerGuard (re)creates the triggers that reject deleting ledger
+// rows and changing them, save for stamping the reference on a row that has
+// none. An update that leaves a row as it was, like re-importing it, passes.
+func createLedgerGuard(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info('stock_transactions') ORDER BY cid`)
+	if err != nil {
+		return err
+	}
+	var changed, refChanged []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		cond := fmt.Sprintf("NEW.%[1]s IS NOT OLD.%[1]s", name)
+		if ledgerRefColumns[name] {
+			refChanged = append(refChanged, cond)
+		} else {
+			changed = append(changed, cond)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmts := []string{
+		`DROP TRIGGER IF EXISTS trg_st_append_only_delete;`,
+		`DROP TRIGGER IF EXISTS trg_st_append_only_update;`,
+		`
+CREATE TRIGGER trg_st_append_only_delete
+BEFORE DELETE ON stock_transactions
+FOR EACH ROW
+BEGIN
+  SELECT RAISE(ABORT, 'stock_transactions is append-only');
+END;
+`,
+		fmt.Sprintf(`
+CREATE TRIGGER trg_st_append_only_update
+BEFORE UPDATE ON stock_transactions
+FOR EACH ROW
+WHEN %s
+  OR (OLD.ref_type IS NOT NULL AND (%s))
+BEGIN
+  SELECT RAISE(ABORT, 'stock_transactions is append-only');
+END;
+`, strings.Join(changed, "\n  OR "), strings.Join(refChanged, " OR ")),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,11 +1,17 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
 )
 
+// SchemaVersion is written to PRAGMA user_version once Migrate succeeds.
+// Bump it whenever a migration step is added so readiness checks can tell
+// an up-to-date database from one that still needs migrating.
+const SchemaVersion = 44
+
 const pragmaFK = `PRAGMA foreign_keys = ON;`
 
 const createSeries = `
@@ -29,8 +35,8 @@ CREATE TABLE IF NOT EXISTS items (
   reorder_point REAL CHECK (reorder_point > 0),
   managed_unit TEXT NOT NULL CHECK (managed_unit IN ('g','pcs')),
   note TEXT,
-  created_at TEXT NOT NULL DEFAULT (datetime('now')),
-  updated_at TEXT NOT NULL DEFAULT (datetime('now')),
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  updated_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
   FOREIGN KEY (series_id) REFERENCES series(series_id)
 );
 `
@@ -39,12 +45,18 @@ const createIdxItemsSeries = `
 CREATE INDEX IF NOT EXISTS idx_items_series ON items(series_id);
 `
 
+// Stock listings pick one item type and usually only stock-managed items;
+// without this index they start from a scan of every item.
+const createIdxItemsTypeManaged = `
+CREATE INDEX IF NOT EXISTS idx_items_type_managed ON items(item_type, stock_managed);
+`
+
 const triggerItemsUpdatedAt = `
 CREATE TRIGGER IF NOT EXISTS trg_items_updated_at
 AFTER UPDATE ON items
 FOR EACH ROW
 BEGIN
-  UPDATE items SET updated_at = datetime('now') WHERE item_id = OLD.item_id;
+  UPDATE items SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE item_id = OLD.item_id;
 END;
 `
 
@@ -55,7 +67,7 @@ CREATE TABLE IF NOT EXISTS components (
   manufacturer TEXT,
   component_type TEXT NOT NULL DEFAULT 'material' CHECK (component_type IN ('part','material','consumable')),
   color TEXT,
-  created_at TEXT NOT NULL DEFAULT (datetime('now')),
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
   FOREIGN KEY (item_id) REFERENCES items(item_id) ON DELETE CASCADE
 );
 `
@@ -68,7 +80,8 @@ CREATE TABLE IF NOT EXISTS assemblies (
   total_weight REAL,
   pack_size TEXT,
   note TEXT,
-  created_at TEXT NOT NULL DEFAULT (datetime('now')),
+  phantom INTEGER NOT NULL DEFAULT 0 CHECK (phantom IN (0, 1)),
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
   FOREIGN KEY (item_id) REFERENCES items(item_id) ON DELETE CASCADE
 );
 `
@@ -80,7 +93,7 @@ CREATE TABLE IF NOT EXISTS component_purchase_links (
   url TEXT NOT NULL,
   label TEXT,
   sort_order INTEGER NOT NULL DEFAULT 0,
-  created_at TEXT NOT NULL DEFAULT (datetime('now')),
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
   enabled INTEGER NOT NULL DEFAULT 1 CHECK (enabled IN (0,1)),
   FOREIGN KEY (component_id) REFERENCES components(component_id) ON DELETE CASCADE
 );
@@ -95,10 +108,10 @@ const createStockTransactions = `
 CREATE TABLE IF NOT EXISTS stock_transactions (
   transaction_id INTEGER PRIMARY KEY AUTOINCREMENT,
   item_id INTEGER NOT NULL,
-  qty REAL NOT NULL CHECK (qty > 0),
+  qty REAL NOT NULL CHECK (qty > 0 OR (transaction_type = 'ADJUST' AND qty <> 0)),
   transaction_type TEXT NOT NULL CHECK (transaction_type IN ('IN','OUT','ADJUST')),
   note TEXT,
-  created_at TEXT NOT NULL DEFAULT (datetime('now')),
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
   FOREIGN KEY (item_id) REFERENCES items(item_id)
 );
 `
@@ -107,12 +120,39 @@ const createIdxStockTransactionsItem = `
 CREATE INDEX IF NOT EXISTS idx_st_item ON stock_transactions(item_id);
 `
 
+// Per-item windows (usage stats, last movement) read a range of this index.
+const createIdxStockTransactionsItemCreated = `
+CREATE INDEX IF NOT EXISTS idx_st_item_created ON stock_transactions(item_id, created_at);
+`
+
+// A transaction can be reversed at most once.
+const createIdxStockTransactionsBOMRecord = `
+CREATE INDEX IF NOT EXISTS idx_st_bom_record ON stock_transactions(bom_record_id) WHERE bom_record_id IS NOT NULL;
+`
+
+const createIdxStockTransactionsRef = `
+CREATE INDEX IF NOT EXISTS idx_st_ref ON stock_transactions(ref_type, ref_id) WHERE ref_type IS NOT NULL;
+`
+
+const createIdxStockTransactionsReversalOf = `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_st_reversal_of ON stock_transactions(reversal_of) WHERE reversal_of IS NOT NULL;
+`
+
+// A transaction can be corrected at most once; the correction is corrected
+// in turn.
+const createIdxStockTransactionsCorrectionOf = `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_st_correction_of ON stock_transactions(correction_of) WHERE correction_of IS NOT NULL;
+`
+
 const createAssemblyRecords = `
 CREATE TABLE IF NOT EXISTS assembly_records (
   record_id INTEGER PRIMARY KEY AUTOINCREMENT,
   item_id INTEGER NOT NULL,
   rev_no INTEGER NOT NULL CHECK (rev_no > 0),
-  created_at TEXT NOT NULL DEFAULT (datetime('now')),
+  yield REAL NOT NULL DEFAULT 1 CHECK (yield > 0 AND yield <= 1),
+  effective_from TEXT,
+  obsolete_at TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
   FOREIGN KEY (item_id) REFERENCES items(item_id) ON DELETE CASCADE,
   UNIQUE (item_id, rev_no)
 );
@@ -127,7 +167,10 @@ CREATE TABLE IF NOT EXISTS assembly_components (
   record_id INTEGER NOT NULL,
   component_item_id INTEGER NOT NULL,
   qty_per_unit REAL NOT NULL CHECK (qty_per_unit > 0),
+  scrap_factor REAL NOT NULL DEFAULT 0 CHECK (scrap_factor >= 0 AND scrap_factor < 1),
   note TEXT,
+  refs TEXT,
+  position INTEGER NOT NULL DEFAULT 0,
   PRIMARY KEY (record_id, component_item_id),
   FOREIGN KEY (record_id) REFERENCES assembly_records(record_id) ON DELETE CASCADE,
   FOREIGN KEY (component_item_id) REFERENCES items(item_id)
@@ -138,7 +181,568 @@ const createIdxAssemblyComponentsComponent = `
 CREATE INDEX IF NOT EXISTS idx_assembly_components_component ON assembly_components(component_item_id);
 `
 
+const createItemAttachments = `
+CREATE TABLE IF NOT EXISTS item_attachments (
+  attachment_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  item_id INTEGER NOT NULL,
+  file_name TEXT NOT NULL,
+  content_type TEXT NOT NULL,
+  size_bytes INTEGER NOT NULL CHECK (size_bytes >= 0),
+  storage_key TEXT NOT NULL UNIQUE,
+  note TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  FOREIGN KEY (item_id) REFERENCES items(item_id) ON DELETE CASCADE
+);
+`
+
+const createIdxItemAttachmentsItem = `
+CREATE INDEX IF NOT EXISTS idx_item_attachments_item ON item_attachments(item_id);
+`
+
+const createSKUPatterns = `
+CREATE TABLE IF NOT EXISTS sku_patterns (
+  pattern_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  series_id INTEGER UNIQUE,
+  item_type TEXT UNIQUE CHECK (item_type IN ('component','assembly')),
+  prefix TEXT NOT NULL,
+  pad_width INTEGER NOT NULL DEFAULT 4 CHECK (pad_width BETWEEN 1 AND 12),
+  next_value INTEGER NOT NULL DEFAULT 1 CHECK (next_value > 0),
+  updated_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  CHECK ((series_id IS NULL) <> (item_type IS NULL)),
+  FOREIGN KEY (series_id) REFERENCES series(series_id) ON DELETE CASCADE
+);
+`
+
+const createReasonCodes = `
+CREATE TABLE IF NOT EXISTS reason_codes (
+  code TEXT PRIMARY KEY,
+  label TEXT NOT NULL,
+  active INTEGER NOT NULL DEFAULT 1 CHECK (active IN (0,1)),
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+`
+
+const seedReasonCodes = `
+INSERT OR IGNORE INTO reason_codes(code, label, sort_order) VALUES
+  ('build', 'Build consumption', 10),
+  ('sale', 'Sale / shipment', 20),
+  ('scrap', 'Scrap', 30),
+  ('sample', 'Sample', 40),
+  ('rework', 'Rework', 50),
+  ('shrinkage', 'Shrinkage', 60),
+  ('opening_balance', 'Opening balance', 70);
+`
+
+const createUnitRules = `
+CREATE TABLE IF NOT EXISTS unit_rules (
+  unit TEXT PRIMARY KEY CHECK (unit IN ('g','pcs')),
+  decimals INTEGER NOT NULL CHECK (decimals BETWEEN 0 AND 6),
+  rounding TEXT NOT NULL CHECK (rounding IN ('round','reject'))
+);
+`
+
+const seedUnitRules = `
+INSERT OR IGNORE INTO unit_rules(unit, decimals, rounding) VALUES
+  ('g', 2, 'round'),
+  ('pcs', 0, 'reject');
+`
+
+const createCustomFields = `
+CREATE TABLE IF NOT EXISTS custom_fields (
+  field_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  field_key TEXT NOT NULL UNIQUE,
+  name TEXT NOT NULL,
+  field_type TEXT NOT NULL CHECK (field_type IN ('text','number','boolean','date')),
+  applies_to TEXT CHECK (applies_to IN ('component','assembly')),
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+`
+
+// Values are stored as canonical text and typed on read by field_type.
+const createItemCustomValues = `
+CREATE TABLE IF NOT EXISTS item_custom_values (
+  item_id INTEGER NOT NULL,
+  field_id INTEGER NOT NULL,
+  value TEXT NOT NULL,
+  PRIMARY KEY (item_id, field_id),
+  FOREIGN KEY (item_id) REFERENCES items(item_id) ON DELETE CASCADE,
+  FOREIGN KEY (field_id) REFERENCES custom_fields(field_id) ON DELETE CASCADE
+);
+`
+
+const createIdxItemCustomValuesField = `
+CREATE INDEX IF NOT EXISTS idx_item_custom_values_field ON item_custom_values(field_id, value);
+`
+
+// app_settings holds instance-wide settings as key/value text.
+const createAppSettings = `
+CREATE TABLE IF NOT EXISTS app_settings (
+  key TEXT PRIMARY KEY,
+  value TEXT NOT NULL,
+  updated_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+`
+
+// stock_snapshots keeps one row per item and day; value is NULL when the
+// item had no unit cost at snapshot time.
+const createStockSnapshots = `
+CREATE TABLE IF NOT EXISTS stock_snapshots (
+  snapshot_date TEXT NOT NULL,
+  item_id INTEGER NOT NULL,
+  qty REAL NOT NULL,
+  unit_cost REAL,
+  value REAL,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  PRIMARY KEY (item_id, snapshot_date),
+  FOREIGN KEY (item_id) REFERENCES items(item_id) ON DELETE CASCADE
+);
+`
+
+const createIdxStockSnapshotsDate = `
+CREATE INDEX IF NOT EXISTS idx_stock_snapshots_date ON stock_snapshots(snapshot_date);
+`
+
+const createSuppliers = `
+CREATE TABLE IF NOT EXISTS suppliers (
+  supplier_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL UNIQUE,
+  contact_name TEXT NOT NULL DEFAULT '',
+  email TEXT NOT NULL DEFAULT '',
+  phone TEXT NOT NULL DEFAULT '',
+  url TEXT NOT NULL DEFAULT '',
+  lead_time_days INTEGER CHECK (lead_time_days >= 0),
+  note TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  updated_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+`
+
+// supplier_offers holds one price list entry per component and supplier;
+// lead_time_days falls back to the supplier's when NULL.
+const createSupplierOffers = `
+CREATE TABLE IF NOT EXISTS supplier_offers (
+  offer_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  item_id INTEGER NOT NULL,
+  supplier_id INTEGER NOT NULL,
+  supplier_sku TEXT NOT NULL DEFAULT '',
+  price REAL NOT NULL CHECK (price >= 0),
+  currency TEXT NOT NULL DEFAULT 'JPY',
+  moq REAL NOT NULL DEFAULT 1 CHECK (moq > 0),
+  lead_time_days INTEGER CHECK (lead_time_days >= 0),
+  preferred INTEGER NOT NULL DEFAULT 0 CHECK (preferred IN (0,1)),
+  url TEXT NOT NULL DEFAULT '',
+  note TEXT NOT NULL DEFAULT '',
+  updated_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  UNIQUE (item_id, supplier_id),
+  FOREIGN KEY (item_id) REFERENCES items(item_id) ON DELETE CASCADE,
+  FOREIGN KEY (supplier_id) REFERENCES suppliers(supplier_id) ON DELETE CASCADE
+);
+`
+
+const createIdxSupplierOffersSupplier = `
+CREATE INDEX IF NOT EXISTS idx_supplier_offers_supplier ON supplier_offers(supplier_id);
+`
+
+// currencies holds exchange rates as the value of one unit in the base
+// currency (app_settings base_currency, JPY unless set), so the base has rate 1.
+const createCurrencies = `
+CREATE TABLE IF NOT EXISTS currencies (
+  code TEXT PRIMARY KEY CHECK (length(code) = 3),
+  name TEXT NOT NULL DEFAULT '',
+  rate REAL NOT NULL CHECK (rate > 0),
+  updated_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+`
+
+const seedCurrencies = `
+INSERT OR IGNORE INTO currencies(code, name, rate) VALUES ('JPY', 'Japanese Yen', 1);
+`
+
+// landed_costs records one allocation of freight/customs charges over
+// received (IN) transactions. Amounts are in the base currency of the time.
+const createLandedCosts = `
+CREATE TABLE IF NOT EXISTS landed_costs (
+  landed_cost_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  method TEXT NOT NULL CHECK (method IN ('value','weight')),
+  amount REAL NOT NULL CHECK (amount >= 0),
+  currency TEXT NOT NULL,
+  note TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+`
+
+const createLandedCostCharges = `
+CREATE TABLE IF NOT EXISTS landed_cost_charges (
+  charge_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  landed_cost_id INTEGER NOT NULL,
+  label TEXT NOT NULL,
+  amount REAL NOT NULL CHECK (amount >= 0),
+  currency TEXT NOT NULL,
+  rate REAL NOT NULL CHECK (rate > 0),
+  FOREIGN KEY (landed_cost_id) REFERENCES landed_costs(landed_cost_id) ON DELETE CASCADE
+);
+`
+
+// landed_cost_allocations keeps each line's share and the unit cost before
+// and after, for audit.
+const createLandedCostAllocations = `
+CREATE TABLE IF NOT EXISTS landed_cost_allocations (
+  landed_cost_id INTEGER NOT NULL,
+  transaction_id INTEGER NOT NULL,
+  item_id INTEGER NOT NULL,
+  qty REAL NOT NULL,
+  basis REAL NOT NULL,
+  amount REAL NOT NULL,
+  unit_cost_before REAL,
+  unit_cost_after REAL NOT NULL,
+  PRIMARY KEY (landed_cost_id, transaction_id),
+  FOREIGN KEY (landed_cost_id) REFERENCES landed_costs(landed_cost_id) ON DELETE CASCADE,
+  FOREIGN KEY (transaction_id) REFERENCES stock_transactions(transaction_id),
+  FOREIGN KEY (item_id) REFERENCES items(item_id) ON DELETE CASCADE
+);
+`
+
+const createIdxLandedCostAllocationsTxn = `
+CREATE INDEX IF NOT EXISTS idx_landed_cost_allocations_txn ON landed_cost_allocations(transaction_id);
+`
+
+// assembly_component_alternates lists substitutes for a BOM line, lowest
+// priority first. They are used when the line's own item is short.
+const createAssemblyComponentAlternates = `
+CREATE TABLE IF NOT EXISTS assembly_component_alternates (
+  record_id INTEGER NOT NULL,
+  component_item_id INTEGER NOT NULL,
+  alternate_item_id INTEGER NOT NULL REFERENCES items(item_id),
+  priority INTEGER NOT NULL DEFAULT 1 CHECK (priority > 0),
+  PRIMARY KEY (record_id, component_item_id, alternate_item_id),
+  FOREIGN KEY (record_id, component_item_id) REFERENCES assembly_components(record_id, component_item_id) ON DELETE CASCADE,
+  CHECK (alternate_item_id <> component_item_id)
+);
+`
+
+const createIdxAssemblyComponentAlternatesItem = `
+CREATE INDEX IF NOT EXISTS idx_assembly_component_alternates_item ON assembly_component_alternates(alternate_item_id);
+`
+
+// ecos are engineering change orders. eco_changes holds the BOM revision staged
+// for each affected assembly; record_id points at the revision created when the
+// ECO was approved.
+const createECOs = `
+CREATE TABLE IF NOT EXISTS ecos (
+  eco_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title TEXT NOT NULL,
+  description TEXT,
+  status TEXT NOT NULL DEFAULT 'draft' CHECK (status IN ('draft','approved','cancelled')),
+  effective_date TEXT,
+  approved_by TEXT,
+  approved_at TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ','now'))
+);
+`
+
+const createECOChanges = `
+CREATE TABLE IF NOT EXISTS eco_changes (
+  eco_id INTEGER NOT NULL REFERENCES ecos(eco_id) ON DELETE CASCADE,
+  parent_item_id INTEGER NOT NULL REFERENCES items(item_id) ON DELETE RESTRICT,
+  payload TEXT NOT NULL,
+  record_id INTEGER REFERENCES assembly_records(record_id) ON DELETE SET NULL,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ','now')),
+  PRIMARY KEY (eco_id, parent_item_id)
+);
+`
+
+const createIdxECOChangesRecord = `
+CREATE INDEX IF NOT EXISTS idx_eco_changes_record ON eco_changes(record_id);
+`
+
+// channel_listings maps a sellable item to its listing on an e-commerce
+// channel. The last_* columns record the outcome of the latest stock push.
+const createChannelListings = `
+CREATE TABLE IF NOT EXISTS channel_listings (
+  item_id INTEGER NOT NULL REFERENCES items(item_id) ON DELETE CASCADE,
+  channel TEXT NOT NULL CHECK (channel IN ('shopify','base')),
+  external_id TEXT NOT NULL,
+  last_synced_qty INTEGER,
+  last_synced_at TEXT,
+  last_error TEXT,
+  last_attempt_at TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ','now')),
+  PRIMARY KEY (item_id, channel),
+  UNIQUE (channel, external_id)
+);
+`
+
+// order_dead_letters keeps order lines received by the order webhook that
+// could not be booked, until they are retried or dismissed.
+const createOrderDeadLetters = `
+CREATE TABLE IF NOT EXISTS order_dead_letters (
+  dead_letter_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  source TEXT NOT NULL,
+  order_id TEXT NOT NULL,
+  sku TEXT NOT NULL,
+  qty REAL NOT NULL CHECK (qty > 0),
+  reason TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  resolved_at TEXT,
+  resolution TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ','now')),
+  UNIQUE (source, order_id, sku)
+);
+`
+
+// purchase_order_lines are items on order: what is expected, from whom and
+// when. Receipts booked with the line's po_ref count towards received_qty;
+// a line is open until fully received or cancelled.
+const createPurchaseOrderLines = `
+CREATE TABLE IF NOT EXISTS purchase_order_lines (
+  line_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  po_ref TEXT NOT NULL,
+  item_id INTEGER NOT NULL REFERENCES items(item_id) ON DELETE RESTRICT,
+  supplier_id INTEGER REFERENCES suppliers(supplier_id) ON DELETE SET NULL,
+  qty REAL NOT NULL CHECK (qty > 0),
+  received_qty REAL NOT NULL DEFAULT 0 CHECK (received_qty >= 0),
+  expected_date TEXT,
+  note TEXT NOT NULL DEFAULT '',
+  cancelled_at TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ','now'))
+);
+`
+
+const createIdxPurchaseOrderLinesItem = `
+CREATE INDEX IF NOT EXISTS idx_purchase_order_lines_item
+ON purchase_order_lines(item_id, expected_date);
+`
+
+const createIdxPurchaseOrderLinesRef = `
+CREATE INDEX IF NOT EXISTS idx_purchase_order_lines_ref
+ON purchase_order_lines(po_ref, item_id);
+`
+
+// api_keys are long-lived keys for scripts and devices. Only the SHA-256
+// of a key is stored; prefix is its first characters, to tell keys apart.
+// Revoked keys are kept so their history stays readable.
+const createAPIKeys = `
+CREATE TABLE IF NOT EXISTS api_keys (
+  key_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  scope TEXT NOT NULL CHECK (scope IN ('read-only','stock-write','admin')),
+  prefix TEXT NOT NULL,
+  key_hash TEXT NOT NULL UNIQUE,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ','now')),
+  last_used_at TEXT,
+  revoked_at TEXT
+);
+`
+
+// users are people who signed in through the OpenID provider, keyed by the
+// provider's subject. role takes the API key scopes.
+const createUsers = `
+CREATE TABLE IF NOT EXISTS users (
+  user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  issuer TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  email TEXT NOT NULL DEFAULT '',
+  name TEXT NOT NULL DEFAULT '',
+  role TEXT NOT NULL CHECK (role IN ('read-only','stock-write','admin')),
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ','now')),
+  last_login_at TEXT,
+  disabled_at TEXT,
+  UNIQUE (issuer, subject)
+);
+`
+
+// sessions are signed-in browsers; like API keys, only the SHA-256 of the
+// session cookie is stored.
+const createSessions = `
+CREATE TABLE IF NOT EXISTS sessions (
+  session_hash TEXT PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ','now')),
+  expires_at TEXT NOT NULL
+);
+`
+
+// item_changes logs item creates, edits and deletes for the activity feed.
+// It has no foreign key so an item's history outlives the item.
+const createItemChanges = `
+CREATE TABLE IF NOT EXISTS item_changes (
+  change_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  item_id INTEGER NOT NULL,
+  sku TEXT NOT NULL,
+  name TEXT NOT NULL,
+  action TEXT NOT NULL CHECK (action IN ('created','updated','deleted')),
+  actor TEXT,
+  request_id TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ','now'))
+);
+`
+
+const createIdxItemChangesCreatedAt = `
+CREATE INDEX IF NOT EXISTS idx_item_changes_created_at ON item_changes(created_at);
+`
+
+// stocktakes are physical counts. Their lines freeze the items to count
+// and the system quantity when the count was started, which count sheets
+// print (or hide, for blind counts).
+const createStocktakes = `
+CREATE TABLE IF NOT EXISTS stocktakes (
+  stocktake_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  category TEXT CHECK (category IN ('assembly','material','part','consumable')),
+  blind INTEGER NOT NULL DEFAULT 0 CHECK (blind IN (0,1)),
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ','now'))
+);
+`
+
+const createStocktakeLines = `
+CREATE TABLE IF NOT EXISTS stocktake_lines (
+  stocktake_id INTEGER NOT NULL REFERENCES stocktakes(stocktake_id) ON DELETE CASCADE,
+  item_id INTEGER NOT NULL REFERENCES items(item_id) ON DELETE RESTRICT,
+  system_qty REAL NOT NULL,
+  PRIMARY KEY (stocktake_id, item_id)
+);
+`
+
+// A low-stock snooze hides an item from the alert list until snoozed_until
+// (NULL: acknowledged, no end) or until it gets worse than the severity it
+// was snoozed at.
+const createLowStockSnoozes = `
+CREATE TABLE IF NOT EXISTS low_stock_snoozes (
+  item_id INTEGER PRIMARY KEY REFERENCES items(item_id) ON DELETE CASCADE,
+  severity TEXT NOT NULL CHECK (severity IN ('low','out')),
+  snoozed_until TEXT,
+  note TEXT,
+  actor TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+`
+
+// stock_transactions_archive keeps ledger rows moved out of
+// stock_transactions by archival, which leaves an opening-balance row in
+// their place. Like item_changes it has no foreign keys, so the history
+// outlives what it refers to.
+const createStockTransactionsArchive = `
+CREATE TABLE IF NOT EXISTS stock_transactions_archive (
+  transaction_id INTEGER PRIMARY KEY,
+  item_id INTEGER NOT NULL,
+  qty REAL NOT NULL,
+  transaction_type TEXT NOT NULL,
+  note TEXT,
+  created_at TEXT NOT NULL,
+  reversal_of INTEGER,
+  reason_code TEXT,
+  bom_record_id INTEGER,
+  ref_type TEXT,
+  ref_id TEXT,
+  request_id TEXT,
+  correction_of INTEGER,
+  actor TEXT,
+  archived_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+`
+
+const createIdxStockTransactionsArchiveItem = `
+CREATE INDEX IF NOT EXISTS idx_st_archive_item_created ON stock_transactions_archive(item_id, created_at);
+`
+
+// bom_templates are named BOM structures that new items can start from.
+// Their lines carry no designators or alternates, which belong to a
+// product; a line goes away with its component item.
+const createBOMTemplates = `
+CREATE TABLE IF NOT EXISTS bom_templates (
+  template_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL UNIQUE,
+  note TEXT,
+  yield REAL NOT NULL DEFAULT 1 CHECK (yield > 0 AND yield <= 1),
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+`
+
+const createBOMTemplateLines = `
+CREATE TABLE IF NOT EXISTS bom_template_lines (
+  template_id INTEGER NOT NULL REFERENCES bom_templates(template_id) ON DELETE CASCADE,
+  component_item_id INTEGER NOT NULL REFERENCES items(item_id) ON DELETE RESTRICT,
+  qty_per_unit REAL NOT NULL CHECK (qty_per_unit > 0),
+  scrap_factor REAL NOT NULL DEFAULT 0 CHECK (scrap_factor >= 0 AND scrap_factor < 1),
+  position INTEGER NOT NULL DEFAULT 0,
+  note TEXT,
+  PRIMARY KEY (template_id, component_item_id)
+);
+`
+
+// item_bins are the bins an item is kept in. qty is what transfers put in
+// a bin; the default bin's is unused, since it holds the rest of the
+// on-hand quantity.
+const createItemBins = `
+CREATE TABLE IF NOT EXISTS item_bins (
+  item_id INTEGER NOT NULL REFERENCES items(item_id) ON DELETE CASCADE,
+  bin_code TEXT NOT NULL COLLATE NOCASE,
+  is_default INTEGER NOT NULL DEFAULT 0 CHECK (is_default IN (0, 1)),
+  qty REAL NOT NULL DEFAULT 0 CHECK (qty >= 0),
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  PRIMARY KEY (item_id, bin_code)
+);
+`
+
+const createIdxItemBinsDefault = `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_item_bins_default ON item_bins(item_id) WHERE is_default = 1;
+`
+
+// bin_transfers logs moves between bins. Bin codes are copied, so the log
+// outlives removed bins.
+const createBinTransfers = `
+CREATE TABLE IF NOT EXISTS bin_transfers (
+  transfer_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  item_id INTEGER NOT NULL REFERENCES items(item_id) ON DELETE CASCADE,
+  from_bin TEXT NOT NULL,
+  to_bin TEXT NOT NULL,
+  qty REAL NOT NULL CHECK (qty > 0),
+  note TEXT NOT NULL DEFAULT '',
+  actor TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+`
+
+// outbox holds notifications for other systems, written in the transaction
+// of the change they announce and delivered afterwards, so a rolled back
+// change sends nothing and a committed one is sent even after a crash. A
+// message is pending until delivered_at, or failed_at once its retries run
+// out.
+const createOutbox = `
+CREATE TABLE IF NOT EXISTS outbox (
+  outbox_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  topic TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+  last_error TEXT,
+  delivered_at TEXT,
+  failed_at TEXT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+`
+
+const createIdxOutboxPending = `
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(next_attempt_at)
+WHERE delivered_at IS NULL AND failed_at IS NULL;
+`
+
 func Migrate(db *sql.DB) error {
+	// Some steps toggle foreign_keys, which is per connection, so the whole
+	// migration runs on one.
+	// Open sizes the idle pool like the open one, so both are restored.
+	if n := db.Stats().MaxOpenConnections; n != 1 {
+		db.SetMaxOpenConns(1)
+		defer func() {
+			db.SetMaxOpenConns(n)
+			if n > 0 {
+				db.SetMaxIdleConns(n)
+			}
+		}()
+	}
+
 	stmts := []struct {
 		name string
 		sql  string
@@ -148,14 +752,65 @@ func Migrate(db *sql.DB) error {
 		{"create items", createItems},
 		{"trigger items.updated_at", triggerItemsUpdatedAt},
 		{"index items(series_id)", createIdxItemsSeries},
+		{"index items(item_type, stock_managed)", createIdxItemsTypeManaged},
 		{"create components", createComponents},
 		{"create assemblies", createAssemblies},
 		{"create stock_transactions", createStockTransactions},
 		{"index stock_transactions(item_id)", createIdxStockTransactionsItem},
+		{"index stock_transactions(item_id, created_at)", createIdxStockTransactionsItemCreated},
 		{"create assembly_records", createAssemblyRecords},
 		{"index assembly_records(item_id)", createIdxAssemblyRecordsItem},
 		{"create assembly_components", createAssemblyComponents},
 		{"index assembly_components(component_item_id)", createIdxAssemblyComponentsComponent},
+		{"create item_attachments", createItemAttachments},
+		{"index item_attachments(item_id)", createIdxItemAttachmentsItem},
+		{"create sku_patterns", createSKUPatterns},
+		{"create reason_codes", createReasonCodes},
+		{"seed reason_codes", seedReasonCodes},
+		{"create unit_rules", createUnitRules},
+		{"seed unit_rules", seedUnitRules},
+		{"create custom_fields", createCustomFields},
+		{"create item_custom_values", createItemCustomValues},
+		{"index item_custom_values(field_id, value)", createIdxItemCustomValuesField},
+		{"create app_settings", createAppSettings},
+		{"create stock_snapshots", createStockSnapshots},
+		{"index stock_snapshots(snapshot_date)", createIdxStockSnapshotsDate},
+		{"create suppliers", createSuppliers},
+		{"create supplier_offers", createSupplierOffers},
+		{"index supplier_offers(supplier_id)", createIdxSupplierOffersSupplier},
+		{"create currencies", createCurrencies},
+		{"seed currencies", seedCurrencies},
+		{"create landed_costs", createLandedCosts},
+		{"create landed_cost_charges", createLandedCostCharges},
+		{"create landed_cost_allocations", createLandedCostAllocations},
+		{"index landed_cost_allocations(transaction_id)", createIdxLandedCostAllocationsTxn},
+		{"create assembly_component_alternates", createAssemblyComponentAlternates},
+		{"index assembly_component_alternates(alternate_item_id)", createIdxAssemblyComponentAlternatesItem},
+		{"create ecos", createECOs},
+		{"create eco_changes", createECOChanges},
+		{"index eco_changes(record_id)", createIdxECOChangesRecord},
+		{"create channel_listings", createChannelListings},
+		{"create order_dead_letters", createOrderDeadLetters},
+		{"create purchase_order_lines", createPurchaseOrderLines},
+		{"index purchase_order_lines(item_id, expected_date)", createIdxPurchaseOrderLinesItem},
+		{"index purchase_order_lines(po_ref, item_id)", createIdxPurchaseOrderLinesRef},
+		{"create api_keys", createAPIKeys},
+		{"create users", createUsers},
+		{"create sessions", createSessions},
+		{"create item_changes", createItemChanges},
+		{"index item_changes(created_at)", createIdxItemChangesCreatedAt},
+		{"create stocktakes", createStocktakes},
+		{"create stocktake_lines", createStocktakeLines},
+		{"create low_stock_snoozes", createLowStockSnoozes},
+		{"create bom_templates", createBOMTemplates},
+		{"create bom_template_lines", createBOMTemplateLines},
+		{"create stock_transactions_archive", createStockTransactionsArchive},
+		{"index stock_transactions_archive(item_id, created_at)", createIdxStockTransactionsArchiveItem},
+		{"create item_bins", createItemBins},
+		{"index item_bins(item_id) default", createIdxItemBinsDefault},
+		{"create bin_transfers", createBinTransfers},
+		{"create outbox", createOutbox},
+		{"index outbox(next_attempt_at) pending", createIdxOutboxPending},
 	}
 
 	for _, s := range stmts {
@@ -173,9 +828,176 @@ func Migrate(db *sql.DB) error {
 		return err
 	}
 
+	if err := ensureColumn(db, "stock_transactions", "reversal_of", `INTEGER REFERENCES stock_transactions(transaction_id)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(createIdxStockTransactionsReversalOf); err != nil {
+		return fmt.Errorf("migration failed at index stock_transactions(reversal_of): %w", err)
+	}
+
+	if err := ensureColumn(db, "stock_transactions", "reason_code", `TEXT REFERENCES reason_codes(code)`); err != nil {
+		return err
+	}
+
+	if err := ensureColumn(db, "items", "unit_cost", `REAL CHECK (unit_cost >= 0)`); err != nil {
+		return err
+	}
+
+	if err := ensureSupplierRefs(db); err != nil {
+		return err
+	}
+
+	if err := ensureSignedAdjust(db); err != nil {
+		return err
+	}
+
+	// NULL inherits the global negative stock policy from app_settings.
+	if err := ensureColumn(db, "items", "negative_stock_policy", `TEXT CHECK (negative_stock_policy IN ('block','warn','allow'))`); err != nil {
+		return err
+	}
+
+	// NULL means the unit cost is in the base currency.
+	if err := ensureColumn(db, "items", "unit_cost_currency", `TEXT`); err != nil {
+		return err
+	}
+	// The currency snapshot values were converted to.
+	if err := ensureColumn(db, "stock_snapshots", "currency", `TEXT`); err != nil {
+		return err
+	}
+
+	if err := ensureUTCTimestamps(db); err != nil {
+		return err
+	}
+
+	// Build consumption is qty_per_unit * (1 + scrap_factor) / yield.
+	if err := ensureColumn(db, "assembly_components", "scrap_factor", `REAL NOT NULL DEFAULT 0 CHECK (scrap_factor >= 0 AND scrap_factor < 1)`); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "assembly_records", "yield", `REAL NOT NULL DEFAULT 1 CHECK (yield > 0 AND yield <= 1)`); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "assembly_components", "refs", `TEXT`); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "assembly_components", "position", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	// A revision is in effect from effective_from (created_at when NULL)
+	// until a later revision takes effect.
+	if err := ensureColumn(db, "assembly_records", "effective_from", `TEXT`); err != nil {
+		return err
+	}
+	// Revisions are never renumbered or removed; deleting one marks it
+	// obsolete. Builds record the revision they consumed.
+	if err := ensureColumn(db, "assembly_records", "obsolete_at", `TEXT`); err != nil {
+		return err
+	}
+	// Why a revision was made and by whom.
+	if err := ensureColumn(db, "assembly_records", "change_note", `TEXT`); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "assembly_records", "changed_by", `TEXT`); err != nil {
+		return err
+	}
+	// active, end-of-life or obsolete; obsolete items are kept for history.
+	if err := ensureColumn(db, "items", "lifecycle_status", `TEXT NOT NULL DEFAULT 'active' CHECK (lifecycle_status IN ('active','eol','obsolete'))`); err != nil {
+		return err
+	}
+	// Purchases are rounded up to the MOQ and then to whole order
+	// multiples; item values apply to every supplier, offer values to one.
+	if err := ensureColumn(db, "items", "moq", `REAL CHECK (moq > 0)`); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "items", "order_multiple", `REAL CHECK (order_multiple > 0)`); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "supplier_offers", "order_multiple", `REAL CHECK (order_multiple > 0)`); err != nil {
+		return err
+	}
+	// The item's lead time applies when neither the offer nor the supplier
+	// has one.
+	if err := ensureColumn(db, "items", "lead_time_days", `INTEGER CHECK (lead_time_days >= 0)`); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "stock_transactions", "bom_record_id", `INTEGER REFERENCES assembly_records(record_id)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(createIdxStockTransactionsBOMRecord); err != nil {
+		return fmt.Errorf("migration failed at index stock_transactions(bom_record_id): %w", err)
+	}
+	// ref_type/ref_id name the business document behind a movement.
+	if err := ensureColumn(db, "stock_transactions", "ref_type", `TEXT CHECK (ref_type IN ('build','po_receipt','shipment','stocktake','reversal'))`); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "stock_transactions", "ref_id", `TEXT`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(createIdxStockTransactionsRef); err != nil {
+		return fmt.Errorf("migration failed at index stock_transactions(ref_type, ref_id): %w", err)
+	}
+	if err := ensureColumn(db, "assemblies", "phantom", `INTEGER NOT NULL DEFAULT 0 CHECK (phantom IN (0, 1))`); err != nil {
+		return err
+	}
+	// request_id ties a movement to the API call (X-Request-ID) that
+	// booked it.
+	if err := ensureColumn(db, "stock_transactions", "request_id", `TEXT`); err != nil {
+		return err
+	}
+	// correction_of links the row that re-books a corrected movement; the
+	// movement itself is reversed by its own row.
+	if err := ensureColumn(db, "stock_transactions", "correction_of", `INTEGER REFERENCES stock_transactions(transaction_id)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(createIdxStockTransactionsCorrectionOf); err != nil {
+		return fmt.Errorf("migration failed at index stock_transactions(correction_of): %w", err)
+	}
+	// Links removed from a component wait in the trash until deleted_at
+	// is past the retention window.
+	if err := ensureColumn(db, "component_purchase_links", "deleted_at", `TEXT`); err != nil {
+		return err
+	}
+	// actor is who booked a movement: a user's email or key:<name>.
+	if err := ensureColumn(db, "stock_transactions", "actor", `TEXT`); err != nil {
+		return err
+	}
+	// weight is grams per managed unit; gram-managed items weigh their
+	// quantity. An assembly with total_weight_auto set keeps total_weight
+	// at the sum of its BOM lines' weights.
+	if err := ensureColumn(db, "items", "weight", `REAL CHECK (weight > 0)`); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "assemblies", "total_weight_auto", `INTEGER NOT NULL DEFAULT 0 CHECK (total_weight_auto IN (0, 1))`); err != nil {
+		return err
+	}
+	// Dimensions of one unit in millimetres and its volume in cm³.
+	for _, col := range []string{"length", "width", "height", "volume"} {
+		if err := ensureColumn(db, "items", col, `REAL CHECK (`+col+` > 0)`); err != nil {
+			return err
+		}
+	}
+	if err := ensureItemRefsRestrict(db); err != nil {
+		return err
+	}
+	// Last, so the guard covers every column and survives table rebuilds.
+	if err := ensureLedgerGuard(db); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d;`, SchemaVersion)); err != nil {
+		return fmt.Errorf("migration failed at set user_version: %w", err)
+	}
 	return nil
 }
 
+// AppliedSchemaVersion returns the schema version recorded by the last Migrate.
+func AppliedSchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var v int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version;`).Scan(&v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
 func ensureItemsReorderPoint(db *sql.DB) error {
 	rows, err := db.Query(`PRAGMA table_info(items);`)
 	if err != nil {
@@ -210,6 +1032,172 @@ func ensureItemsReorderPoint(db *sql.DB) error {
 	return nil
 }
 
+// ensureSupplierRefs links components and assemblies to the suppliers
+// master. When the columns are first added, the existing free-text
+// manufacturer names are copied into suppliers and linked by name; the text
+// column is kept in sync with the supplier name from then on.
+func ensureSupplierRefs(db *sql.DB) error {
+	for _, table := range []string{"components", "assemblies"} {
+		exists, err := hasColumn(db, table, "supplier_id")
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := ensureColumn(db, table, "supplier_id", `INTEGER REFERENCES suppliers(supplier_id) ON DELETE SET NULL`); err != nil {
+			return err
+		}
+		if _, err := db.Exec(fmt.Sprintf(`
+INSERT OR IGNORE INTO suppliers(name)
+SELECT DISTINCT TRIM(manufacturer) FROM %s WHERE TRIM(COALESCE(manufacturer, '')) <> '';
+`, table)); err != nil {
+			return fmt.Errorf("migration failed at copy %s.manufacturer to suppliers: %w", table, err)
+		}
+		if _, err := db.Exec(fmt.Sprintf(`
+UPDATE %[1]s
+SET supplier_id = (SELECT s.supplier_id FROM suppliers s WHERE s.name = TRIM(%[1]s.manufacturer))
+WHERE TRIM(COALESCE(manufacturer, '')) <> '';
+`, table)); err != nil {
+			return fmt.Errorf("migration failed at link %s.supplier_id: %w", table, err)
+		}
+		if _, err := db.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%[1]s_supplier ON %[1]s(supplier_id);`, table)); err != nil {
+			return fmt.Errorf("migration failed at index %s(supplier_id): %w", table, err)
+		}
+	}
+	return nil
+}
+
+// ensureSignedAdjust relaxes the stock_transactions qty check so ADJUST rows
+// may carry a negative delta (a count below the book quantity). SQLite cannot
+// alter a CHECK constraint, so the table is rebuilt.
+func ensureSignedAdjust(db *sql.DB) error {
+	return rebuildTable(db, "stock_transactions",
+		"CHECK (qty > 0)",
+		"CHECK (qty > 0 OR (transaction_type = 'ADJUST' AND qty <> 0))",
+		[]step{
+			{"index stock_transactions(item_id)", createIdxStockTransactionsItem},
+			{"index stock_transactions(item_id, created_at)", createIdxStockTransactionsItemCreated},
+			{"index stock_transactions(reversal_of)", createIdxStockTransactionsReversalOf},
+		})
+}
+
+// ensureItemRefsRestrict stops deleting an item from taking purchase order
+// lines, stocktake counts, staged ECO changes and template lines with it:
+// the tables were made with ON DELETE CASCADE and are rebuilt with RESTRICT.
+func ensureItemRefsRestrict(db *sql.DB) error {
+	for _, t := range []struct {
+		table, column string
+		indexes       []step
+	}{
+		{"purchase_order_lines", "item_id", []step{
+			{"index purchase_order_lines(item_id, expected_date)", createIdxPurchaseOrderLinesItem},
+			{"index purchase_order_lines(po_ref, item_id)", createIdxPurchaseOrderLinesRef},
+		}},
+		{"stocktake_lines", "item_id", nil},
+		{"eco_changes", "parent_item_id", []step{
+			{"index eco_changes(record_id)", createIdxECOChangesRecord},
+		}},
+		{"bom_template_lines", "component_item_id", nil},
+	} {
+		ref := t.column + " INTEGER NOT NULL REFERENCES items(item_id) ON DELETE "
+		if err := rebuildTable(db, t.table, ref+"CASCADE", ref+"RESTRICT", t.indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type step struct {
+	name string
+	sql  string
+}
+
+// rebuildTable replaces oldClause with newClause in the definition of
+// table, which SQLite can only do by copying the rows into a new table.
+// It runs with foreign keys off and recreates the given indexes; triggers
+// are left to their ensure steps. A table without oldClause is left alone.
+func rebuildTable(db *sql.DB, table, oldClause, newClause string, indexes []step) error {
+	var tableSQL string
+	if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&tableSQL); err != nil {
+		return fmt.Errorf("migration failed at load %s schema: %w", table, err)
+	}
+	if !strings.Contains(tableSQL, oldClause) {
+		return nil
+	}
+	i := strings.Index(tableSQL, "(")
+	if i < 0 {
+		return fmt.Errorf("migration failed at parse %s schema", table)
+	}
+	newSQL := "CREATE TABLE " + table + "_new " + strings.Replace(tableSQL[i:], oldClause, newClause, 1)
+
+	if _, err := db.Exec(`PRAGMA foreign_keys = OFF;`); err != nil {
+		return fmt.Errorf("migration failed at disable foreign_keys: %w", err)
+	}
+	defer db.Exec(`PRAGMA foreign_keys = ON;`)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("migration failed at begin rebuild %s: %w", table, err)
+	}
+	defer tx.Rollback()
+
+	steps := append([]step{
+		{"create " + table + "_new", newSQL},
+		{"copy " + table, `INSERT INTO ` + table + `_new SELECT * FROM ` + table + `;`},
+		{"drop " + table, `DROP TABLE ` + table + `;`},
+		{"rename " + table + "_new", `ALTER TABLE ` + table + `_new RENAME TO ` + table + `;`},
+	}, indexes...)
+	for _, st := range steps {
+		if _, err := tx.Exec(st.sql); err != nil {
+			return fmt.Errorf("migration failed at %s: %w", st.name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n); err != nil {
+		return false, fmt.Errorf("migration failed at table_info(%s): %w", table, err)
+	}
+	return n > 0, nil
+}
+
+// ensureColumn adds table.column with the given type/constraint clause when
+// the column is missing. SQLite cannot add columns with non-constant defaults,
+// so the clause must be ALTER TABLE compatible.
+func ensureColumn(db *sql.DB, table, column, clause string) error {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s);`, table))
+	if err != nil {
+		return fmt.Errorf("migration failed at pragma table_info(%s): %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid int
+		var name, colType string
+		var notNull int
+		var defaultValue sql.NullString
+		var pk int
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("migration failed at scan table_info(%s): %w", table, err)
+		}
+		if strings.EqualFold(name, column) {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("migration failed at rows table_info(%s): %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, table, column, clause)); err != nil {
+		return fmt.Errorf("migration failed at add %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
 func ensureComponentsConsumable(db *sql.DB) error {
 	var createSQL sql.NullString
 	if err := db.QueryRow(`
@@ -242,7 +1230,7 @@ CREATE TABLE components (
   manufacturer TEXT,
   component_type TEXT NOT NULL DEFAULT 'material' CHECK (component_type IN ('part','material','consumable')),
   color TEXT,
-  created_at TEXT NOT NULL DEFAULT (datetime('now')),
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
   FOREIGN KEY (item_id) REFERENCES items(item_id) ON DELETE CASCADE
 );
 `); err != nil {
@@ -336,3 +1324,105 @@ FROM component_purchase_links_old;
 	}
 	return nil
 }
+
+// ensureUTCTimestamps moves databases created with datetime('now') defaults
+// ("YYYY-MM-DD HH:MM:SS") to RFC3339 UTC ("YYYY-MM-DDTHH:MM:SSZ"). Existing
+// *_at values are rewritten and the column defaults are changed in place
+// through writable_schema, which SQLite documents as safe for defaults since
+// the stored format of the table does not change.
+func ensureUTCTimestamps(db *sql.DB) error {
+	const oldNow = "datetime('now')"
+	const newNow = "strftime('%Y-%m-%dT%H:%M:%SZ', 'now')"
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM sqlite_master WHERE sql LIKE '%' || ? || '%'`, oldNow).Scan(&n); err != nil {
+		return fmt.Errorf("migration failed at check timestamp defaults: %w", err)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	tables := make([]string, 0)
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return fmt.Errorf("migration failed at list tables: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("migration failed at list tables: %w", err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("migration failed at list tables: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("migration failed at begin timestamp migration: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The trigger would stamp updated_at while created_at is rewritten.
+	if _, err := tx.Exec(`DROP TRIGGER IF EXISTS trg_items_updated_at;`); err != nil {
+		return fmt.Errorf("migration failed at drop trigger items.updated_at: %w", err)
+	}
+	for _, table := range tables {
+		cols, err := tx.Query(`SELECT name FROM pragma_table_info(?) WHERE name LIKE '%\_at' ESCAPE '\'`, table)
+		if err != nil {
+			return fmt.Errorf("migration failed at table_info(%s): %w", table, err)
+		}
+		names := make([]string, 0)
+		for cols.Next() {
+			var name string
+			if err := cols.Scan(&name); err != nil {
+				cols.Close()
+				return fmt.Errorf("migration failed at table_info(%s): %w", table, err)
+			}
+			names = append(names, name)
+		}
+		cols.Close()
+		for _, col := range names {
+			if _, err := tx.Exec(fmt.Sprintf(`
+UPDATE %[1]s SET %[2]s = strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', %[2]s)
+WHERE %[2]s NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9]Z'
+  AND strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', %[2]s) IS NOT NULL;
+`, table, col)); err != nil {
+				return fmt.Errorf("migration failed at convert %s.%s: %w", table, col, err)
+			}
+		}
+	}
+
+	var schemaVersion int
+	if err := tx.QueryRow(`PRAGMA schema_version;`).Scan(&schemaVersion); err != nil {
+		return fmt.Errorf("migration failed at read schema_version: %w", err)
+	}
+	steps := []struct {
+		name string
+		sql  string
+	}{
+		{"enable writable_schema", `PRAGMA writable_schema = ON;`},
+		{"rewrite timestamp defaults", fmt.Sprintf(`UPDATE sqlite_master SET sql = replace(sql, '%s', '%s') WHERE type = 'table';`,
+			strings.ReplaceAll(oldNow, "'", "''"), strings.ReplaceAll(newNow, "'", "''"))},
+		{"bump schema_version", fmt.Sprintf(`PRAGMA schema_version = %d;`, schemaVersion+1)},
+		{"disable writable_schema", `PRAGMA writable_schema = OFF;`},
+		{"trigger items.updated_at", triggerItemsUpdatedAt},
+	}
+	for _, st := range steps {
+		if _, err := tx.Exec(st.sql); err != nil {
+			return fmt.Errorf("migration failed at %s: %w", st.name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration failed at commit timestamp migration: %w", err)
+	}
+
+	var check string
+	if err := db.QueryRow(`PRAGMA integrity_check;`).Scan(&check); err != nil || check != "ok" {
+		return fmt.Errorf("migration failed at integrity_check after timestamp migration: %v %s", err, check)
+	}
+	return nil
+}
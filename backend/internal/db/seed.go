@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SeedDemo fills an empty database with a small catalogue: materials and
+// parts, a final assembly with its BOM, and some stock movements. It does
+// nothing when items already exist, so it is safe to call on every start.
+func SeedDemo(ctx context.Context, db *sql.DB) (seeded bool, err error) {
+	var n int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(1) FROM items`).Scan(&n); err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO series(name) VALUES ('Demo Lamp')`)
+	if err != nil {
+		return false, fmt.Errorf("seed series: %w", err)
+	}
+	seriesID, _ := res.LastInsertId()
+
+	type demoItem struct {
+		sku, name, itemType, unit, componentType string
+		reorderPoint                             any
+		sellable, final                          int
+	}
+	items := []demoItem{
+		{"MAT-PLA-WHT", "PLA filament white", "component", "g", "material", 500, 0, 0},
+		{"PRT-SHADE", "Lamp shade (printed)", "component", "pcs", "part", nil, 0, 0},
+		{"PRT-LED", "LED module 5W", "component", "pcs", "part", 10, 0, 0},
+		{"PRT-CABLE", "USB cable 1.5m", "component", "pcs", "part", 10, 0, 0},
+		{"CNS-SCREW", "M3 screw", "component", "pcs", "consumable", 100, 0, 0},
+		{"ASM-LAMP", "Desk lamp", "assembly", "pcs", "", 5, 1, 1},
+	}
+	ids := map[string]int64{}
+	for _, it := range items {
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO items(series_id, sku, name, item_type, managed_unit, reorder_point, is_sellable, is_final)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`, seriesID, it.sku, it.name, it.itemType, it.unit, it.reorderPoint, it.sellable, it.final)
+		if err != nil {
+			return false, fmt.Errorf("seed item %s: %w", it.sku, err)
+		}
+		id, _ := res.LastInsertId()
+		ids[it.sku] = id
+		if it.itemType == "assembly" {
+			_, err = tx.ExecContext(ctx, `INSERT INTO assemblies(item_id) VALUES (?)`, id)
+		} else {
+			_, err = tx.ExecContext(ctx, `INSERT INTO components(item_id, component_type) VALUES (?, ?)`, id, it.componentType)
+		}
+		if err != nil {
+			return false, fmt.Errorf("seed item %s: %w", it.sku, err)
+		}
+	}
+
+	type bomLine struct {
+		sku string
+		qty float64
+	}
+	boms := []struct {
+		parent string
+		lines  []bomLine
+	}{
+		{"PRT-SHADE", []bomLine{{"MAT-PLA-WHT", 85}}},
+		{"ASM-LAMP", []bomLine{{"PRT-SHADE", 1}, {"PRT-LED", 1}, {"PRT-CABLE", 1}, {"CNS-SCREW", 4}}},
+	}
+	for _, b := range boms {
+		res, err := tx.ExecContext(ctx, `INSERT INTO assembly_records(item_id, rev_no) VALUES (?, 1)`, ids[b.parent])
+		if err != nil {
+			return false, fmt.Errorf("seed bom %s: %w", b.parent, err)
+		}
+		recordID, _ := res.LastInsertId()
+		for pos, l := range b.lines {
+			if _, err := tx.ExecContext(ctx, `
+INSERT INTO assembly_components(record_id, component_item_id, qty_per_unit, position)
+VALUES (?, ?, ?, ?)
+`, recordID, ids[l.sku], l.qty, pos); err != nil {
+				return false, fmt.Errorf("seed bom %s: %w", b.parent, err)
+			}
+		}
+	}
+
+	moves := []struct {
+		sku, typ string
+		qty      float64
+		note     string
+	}{
+		{"MAT-PLA-WHT", "IN", 1000, "demo: initial stock"},
+		{"PRT-SHADE", "IN", 6, "demo: initial stock"},
+		{"PRT-LED", "IN", 20, "demo: initial stock"},
+		{"PRT-CABLE", "IN", 8, "demo: initial stock"},
+		{"CNS-SCREW", "IN", 200, "demo: initial stock"},
+		{"ASM-LAMP", "IN", 4, "demo: initial stock"},
+		{"ASM-LAMP", "OUT", 1, "demo: shipped"},
+	}
+	for _, m := range moves {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO stock_transactions(item_id, transaction_type, qty, note)
+VALUES (?, ?, ?, ?)
+`, ids[m.sku], m.typ, m.qty, m.note); err != nil {
+			return false, fmt.Errorf("seed stock %s: %w", m.sku, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,58 @@
+// Package events fans out change notifications to connected listeners
+// (the SSE endpoint). Delivery is best effort: a listener that falls behind
+// loses events rather than slowing down writers.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Event struct {
+	ID     uint64    `json:"id"`
+	Type   string    `json:"type"`
+	Action string    `json:"action"`
+	ItemID *int64    `json:"item_id,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+type Broker struct {
+	mu   sync.Mutex
+	seq  uint64
+	subs map[chan Event]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[chan Event]struct{})}
+}
+
+// Publish assigns the event an id and timestamp and hands it to every
+// subscriber whose buffer has room.
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	e.ID = b.seq
+	e.At = time.Now().UTC()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of future events and a function that must be
+// called to unsubscribe.
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
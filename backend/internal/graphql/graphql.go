@@ -0,0 +1,339 @@
+// Package graphql executes read-only GraphQL queries against a schema whose
+// types and resolvers are declared in Go. It covers the query language
+// (aliases, arguments, variables, fragments, @skip/@include) but not
+// mutations, subscriptions or introspection, and it does not type check
+// queries beyond rejecting unknown fields and arguments.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+)
+
+// MaxDepth bounds how deeply selections may nest, so a recursive type such
+// as a BOM tree cannot be expanded without limit.
+const MaxDepth = 20
+
+// Object is an output type. Fields are added after creation so types can
+// refer to each other.
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+func NewObject(name string) *Object {
+	return &Object{Name: name, Fields: map[string]*Field{}}
+}
+
+// Field resolves one field of an object. Type is nil for scalars, whose
+// resolved value is encoded as JSON as is. A List field resolves to a slice.
+type Field struct {
+	Type    *Object
+	List    bool
+	Args    []string
+	Resolve func(ctx context.Context, source any, args Args) (any, error)
+}
+
+type Schema struct {
+	Query *Object
+}
+
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+type Response struct {
+	Data   any     `json:"data"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+// Args holds a field's arguments with variables substituted.
+type Args map[string]any
+
+func (a Args) String(name string) (string, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case enumValue:
+		return string(v), nil
+	}
+	return "", fmt.Errorf("argument %s must be a string", name)
+}
+
+// Int returns the argument as an integer; ok is false when it is absent or
+// null.
+func (a Args) Int(name string) (n int64, ok bool, err error) {
+	switch v := a[name].(type) {
+	case nil:
+		return 0, false, nil
+	case int64:
+		return v, true, nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), true, nil
+		}
+	}
+	return 0, false, fmt.Errorf("argument %s must be an integer", name)
+}
+
+func (a Args) Bool(name string) (b bool, ok bool, err error) {
+	switch v := a[name].(type) {
+	case nil:
+		return false, false, nil
+	case bool:
+		return v, true, nil
+	}
+	return false, false, fmt.Errorf("argument %s must be a boolean", name)
+}
+
+// Execute runs the query. Errors in one field null it and are reported
+// alongside the rest of the data; a malformed query returns no data.
+func (s *Schema) Execute(ctx context.Context, req Request) Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	var op *operation
+	for _, o := range doc.operations {
+		if req.OperationName == "" || o.name == req.OperationName {
+			if op != nil {
+				return Response{Errors: []Error{{Message: "operationName is required when the document has several operations"}}}
+			}
+			op = o
+		}
+	}
+	if op == nil {
+		return Response{Errors: []Error{{Message: fmt.Sprintf("unknown operation %q", req.OperationName)}}}
+	}
+	if op.kind != "query" {
+		return Response{Errors: []Error{{Message: fmt.Sprintf("%s operations are not supported", op.kind)}}}
+	}
+
+	vars := map[string]any{}
+	for _, v := range op.vars {
+		if val, ok := req.Variables[v.name]; ok {
+			vars[v.name] = val
+		} else if v.hasDefault {
+			vars[v.name] = v.def
+		}
+	}
+	e := &executor{doc: doc, vars: vars, spreading: map[string]bool{}}
+	data := e.selectObject(ctx, s.Query, nil, op.selections, nil, 1)
+	return Response{Data: data, Errors: e.errors}
+}
+
+type executor struct {
+	doc    *document
+	vars   map[string]any
+	errors []Error
+	// spreading holds the fragments being expanded, to stop cycles.
+	spreading map[string]bool
+}
+
+func (e *executor) fail(path []any, format string, args ...any) {
+	e.errors = append(e.errors, Error{Message: fmt.Sprintf(format, args...), Path: slices.Clone(path)})
+}
+
+// orderedMap keeps response keys in selection order.
+type orderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		val, err := json.Marshal(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// collect flattens fragments into fields grouped by response key.
+func (e *executor) collect(obj *Object, sels []selection, keys *[]string, fields map[string][]*field, path []any) {
+	for _, sel := range sels {
+		switch s := sel.(type) {
+		case *field:
+			if !e.included(s.directives, path) {
+				continue
+			}
+			key := s.alias
+			if key == "" {
+				key = s.name
+			}
+			if _, ok := fields[key]; !ok {
+				*keys = append(*keys, key)
+			}
+			fields[key] = append(fields[key], s)
+		case *fragmentSpread:
+			if !e.included(s.directives, path) {
+				continue
+			}
+			fr, ok := e.doc.fragments[s.name]
+			if !ok {
+				e.fail(path, "unknown fragment %q", s.name)
+				continue
+			}
+			if e.spreading[s.name] {
+				e.fail(path, "fragment %q spreads itself", s.name)
+				continue
+			}
+			if fr.typeCond == obj.Name {
+				e.spreading[s.name] = true
+				e.collect(obj, fr.selections, keys, fields, path)
+				delete(e.spreading, s.name)
+			}
+		case *inlineFragment:
+			if e.included(s.directives, path) && (s.typeCond == "" || s.typeCond == obj.Name) {
+				e.collect(obj, s.selections, keys, fields, path)
+			}
+		}
+	}
+}
+
+func (e *executor) included(dirs []directive, path []any) bool {
+	for _, d := range dirs {
+		if d.name != "skip" && d.name != "include" {
+			continue
+		}
+		v, ok := e.resolveValue(d.args["if"]).(bool)
+		if !ok {
+			e.fail(path, "@%s needs a boolean if argument", d.name)
+			return false
+		}
+		if v == (d.name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *executor) resolveValue(v any) any {
+	switch t := v.(type) {
+	case variable:
+		return e.vars[string(t)]
+	case []any:
+		out := make([]any, len(t))
+		for i, x := range t {
+			out[i] = e.resolveValue(x)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, x := range t {
+			out[k] = e.resolveValue(x)
+		}
+		return out
+	}
+	return v
+}
+
+func (e *executor) selectObject(ctx context.Context, obj *Object, source any, sels []selection, path []any, depth int) any {
+	if depth > MaxDepth {
+		e.fail(path, "query is nested too deeply")
+		return nil
+	}
+	var keys []string
+	fields := map[string][]*field{}
+	e.collect(obj, sels, &keys, fields, path)
+
+	out := &orderedMap{values: make(map[string]any, len(keys))}
+	for _, key := range keys {
+		fs := fields[key]
+		f := fs[0]
+		fieldPath := append(slices.Clone(path), key)
+		out.keys = append(out.keys, key)
+		if f.name == "__typename" {
+			out.values[key] = obj.Name
+			continue
+		}
+		def, ok := obj.Fields[f.name]
+		if !ok {
+			e.fail(fieldPath, "cannot query field %q on type %s", f.name, obj.Name)
+			continue
+		}
+		args := Args{}
+		unknown := ""
+		for name, v := range f.args {
+			if !slices.Contains(def.Args, name) {
+				unknown = name
+				break
+			}
+			args[name] = e.resolveValue(v)
+		}
+		if unknown != "" {
+			e.fail(fieldPath, "unknown argument %q on field %s.%s", unknown, obj.Name, f.name)
+			continue
+		}
+		var sub []selection
+		for _, x := range fs {
+			sub = append(sub, x.selections...)
+		}
+		if def.Type == nil && len(sub) > 0 {
+			e.fail(fieldPath, "field %s.%s is a scalar and takes no selection", obj.Name, f.name)
+			continue
+		}
+		if def.Type != nil && len(sub) == 0 {
+			e.fail(fieldPath, "field %s.%s needs a selection", obj.Name, f.name)
+			continue
+		}
+
+		val, err := def.Resolve(ctx, source, args)
+		if err != nil {
+			e.fail(fieldPath, "%v", err)
+			continue
+		}
+		out.values[key] = e.complete(ctx, def, val, sub, fieldPath, depth+1)
+	}
+	return out
+}
+
+func (e *executor) complete(ctx context.Context, def *Field, val any, sub []selection, path []any, depth int) any {
+	if val == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(val)
+	if (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Slice) && rv.IsNil() {
+		return nil
+	}
+	if def.Type == nil {
+		return val
+	}
+	if !def.List {
+		return e.selectObject(ctx, def.Type, val, sub, path, depth)
+	}
+	if rv.Kind() != reflect.Slice {
+		e.fail(path, "resolver returned %T for a list", val)
+		return nil
+	}
+	out := make([]any, rv.Len())
+	for i := range out {
+		out[i] = e.selectObject(ctx, def.Type, rv.Index(i).Interface(), sub, append(slices.Clone(path), i), depth)
+	}
+	return out
+}
@@ -0,0 +1,447 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string
+	name       string
+	vars       []varDef
+	selections []selection
+}
+
+type varDef struct {
+	name       string
+	def        any
+	hasDefault bool
+}
+
+type fragment struct {
+	typeCond   string
+	selections []selection
+}
+
+// selection is a *field, *fragmentSpread or *inlineFragment.
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	args       map[string]any
+	directives []directive
+	selections []selection
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []directive
+}
+
+type inlineFragment struct {
+	typeCond   string
+	directives []directive
+	selections []selection
+}
+
+type directive struct {
+	name string
+	args map[string]any
+}
+
+// Literal values parse to Go values: int64, float64, string, bool, nil,
+// []any, map[string]any, plus variable and enum references.
+type variable string
+type enumValue string
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+func parse(src string) (doc *document, err error) {
+	p := &parser{src: src}
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(syntaxError); ok {
+				doc, err = nil, e
+				return
+			}
+			panic(r)
+		}
+	}()
+	p.next()
+	doc = &document{fragments: map[string]*fragment{}}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek(tokPunct, "{"):
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: p.selectionSet()})
+		case p.peek(tokName, "fragment"):
+			p.next()
+			name := p.name()
+			p.expectName("on")
+			fr := &fragment{typeCond: p.name()}
+			p.directives()
+			fr.selections = p.selectionSet()
+			doc.fragments[name] = fr
+		case p.tok.kind == tokName:
+			op := &operation{kind: p.name()}
+			if p.tok.kind == tokName {
+				op.name = p.name()
+			}
+			if p.skip("(") {
+				for !p.skip(")") {
+					p.expect("$")
+					v := varDef{name: p.name()}
+					p.expect(":")
+					p.typeRef()
+					if p.skip("=") {
+						v.def, v.hasDefault = p.value(true), true
+					}
+					op.vars = append(op.vars, v)
+				}
+			}
+			p.directives()
+			op.selections = p.selectionSet()
+			doc.operations = append(doc.operations, op)
+		default:
+			p.fail("unexpected %q", p.tok.text)
+		}
+	}
+	if len(doc.operations) == 0 {
+		p.fail("no operation")
+	}
+	return doc, nil
+}
+
+type syntaxError struct {
+	msg string
+}
+
+func (e syntaxError) Error() string { return e.msg }
+
+func (p *parser) fail(format string, args ...any) {
+	line, col := 1, 1
+	for _, r := range p.src[:p.tok.pos] {
+		if r == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	panic(syntaxError{fmt.Sprintf("syntax error at %d:%d: %s", line, col, fmt.Sprintf(format, args...))})
+}
+
+func (p *parser) peek(kind tokenKind, text string) bool {
+	return p.tok.kind == kind && p.tok.text == text
+}
+
+func (p *parser) skip(punct string) bool {
+	if p.peek(tokPunct, punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) {
+	if !p.skip(punct) {
+		p.fail("expected %q, got %q", punct, p.tok.text)
+	}
+}
+
+func (p *parser) expectName(name string) {
+	if !p.peek(tokName, name) {
+		p.fail("expected %q, got %q", name, p.tok.text)
+	}
+	p.next()
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokName {
+		p.fail("expected name, got %q", p.tok.text)
+	}
+	s := p.tok.text
+	p.next()
+	return s
+}
+
+// typeRef skips a variable type; variables are not type checked.
+func (p *parser) typeRef() {
+	if p.skip("[") {
+		p.typeRef()
+		p.expect("]")
+	} else {
+		p.name()
+	}
+	p.skip("!")
+}
+
+func (p *parser) selectionSet() []selection {
+	p.expect("{")
+	var out []selection
+	for !p.skip("}") {
+		if p.tok.kind == tokEOF {
+			p.fail("unterminated selection set")
+		}
+		if p.skip("...") {
+			if p.peek(tokName, "on") {
+				p.next()
+				inl := &inlineFragment{typeCond: p.name()}
+				inl.directives = p.directives()
+				inl.selections = p.selectionSet()
+				out = append(out, inl)
+			} else if p.tok.kind == tokName {
+				out = append(out, &fragmentSpread{name: p.name(), directives: p.directives()})
+			} else {
+				inl := &inlineFragment{directives: p.directives()}
+				inl.selections = p.selectionSet()
+				out = append(out, inl)
+			}
+			continue
+		}
+		f := &field{name: p.name()}
+		if p.skip(":") {
+			f.alias, f.name = f.name, p.name()
+		}
+		f.args = p.arguments()
+		f.directives = p.directives()
+		if p.peek(tokPunct, "{") {
+			f.selections = p.selectionSet()
+		}
+		out = append(out, f)
+	}
+	if len(out) == 0 {
+		p.fail("empty selection set")
+	}
+	return out
+}
+
+func (p *parser) arguments() map[string]any {
+	args := map[string]any{}
+	if p.skip("(") {
+		for !p.skip(")") {
+			name := p.name()
+			p.expect(":")
+			args[name] = p.value(false)
+		}
+	}
+	return args
+}
+
+func (p *parser) directives() []directive {
+	var out []directive
+	for p.skip("@") {
+		out = append(out, directive{name: p.name(), args: p.arguments()})
+	}
+	return out
+}
+
+func (p *parser) value(constant bool) any {
+	t := p.tok
+	switch t.kind {
+	case tokInt:
+		p.next()
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			p.fail("invalid int %s", t.text)
+		}
+		return n
+	case tokFloat:
+		p.next()
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			p.fail("invalid float %s", t.text)
+		}
+		return f
+	case tokString:
+		p.next()
+		return t.text
+	case tokName:
+		p.next()
+		switch t.text {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return enumValue(t.text)
+	}
+	switch {
+	case p.skip("$"):
+		if constant {
+			p.fail("variable not allowed here")
+		}
+		return variable(p.name())
+	case p.skip("["):
+		list := []any{}
+		for !p.skip("]") {
+			list = append(list, p.value(constant))
+		}
+		return list
+	case p.skip("{"):
+		obj := map[string]any{}
+		for !p.skip("}") {
+			name := p.name()
+			p.expect(":")
+			obj[name] = p.value(constant)
+		}
+		return obj
+	}
+	p.fail("unexpected %q", t.text)
+	return nil
+}
+
+func (p *parser) next() {
+	src := p.src
+	// Skip whitespace, commas, the BOM and comments.
+	for p.pos < len(src) {
+		c := src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+		} else if strings.HasPrefix(src[p.pos:], "\uFEFF") {
+			p.pos += 3
+		} else if c == '#' {
+			for p.pos < len(src) && src[p.pos] != '\n' {
+				p.pos++
+			}
+		} else {
+			break
+		}
+	}
+	start := p.pos
+	p.tok = token{pos: start}
+	if p.pos >= len(src) {
+		p.tok.kind = tokEOF
+		return
+	}
+	c := src[p.pos]
+	switch {
+	case strings.HasPrefix(src[p.pos:], "..."):
+		p.pos += 3
+		p.tok.kind, p.tok.text = tokPunct, "..."
+	case strings.ContainsRune("!$()[]{}:=@|&", rune(c)):
+		p.pos++
+		p.tok.kind, p.tok.text = tokPunct, string(c)
+	case c == '_' || isLetter(c):
+		for p.pos < len(src) && (src[p.pos] == '_' || isLetter(src[p.pos]) || isDigit(src[p.pos])) {
+			p.pos++
+		}
+		p.tok.kind, p.tok.text = tokName, src[start:p.pos]
+	case c == '-' || isDigit(c):
+		p.pos++
+		kind := tokInt
+		for p.pos < len(src) {
+			d := src[p.pos]
+			if isDigit(d) {
+				p.pos++
+			} else if d == '.' || d == 'e' || d == 'E' {
+				kind = tokFloat
+				p.pos++
+				if p.pos < len(src) && (src[p.pos] == '+' || src[p.pos] == '-') {
+					p.pos++
+				}
+			} else {
+				break
+			}
+		}
+		p.tok.kind, p.tok.text = kind, src[start:p.pos]
+	case c == '"':
+		p.tok.kind, p.tok.text = tokString, p.readString()
+	default:
+		r, _ := utf8.DecodeRuneInString(src[p.pos:])
+		p.fail("unexpected character %q", r)
+	}
+}
+
+func (p *parser) readString() string {
+	src := p.src
+	if strings.HasPrefix(src[p.pos:], `"""`) {
+		end := strings.Index(src[p.pos+3:], `"""`)
+		if end < 0 {
+			p.fail("unterminated string")
+		}
+		s := src[p.pos+3 : p.pos+3+end]
+		p.pos += end + 6
+		return s
+	}
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(src) || src[p.pos] == '\n' {
+			p.fail("unterminated string")
+		}
+		c := src[p.pos]
+		if c == '"' {
+			p.pos++
+			return b.String()
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(src) {
+			p.fail("unterminated string")
+		}
+		esc := src[p.pos+1]
+		p.pos += 2
+		switch esc {
+		case '"', '\\', '/':
+			b.WriteByte(esc)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if p.pos+4 > len(src) {
+				p.fail("invalid unicode escape")
+			}
+			n, err := strconv.ParseUint(src[p.pos:p.pos+4], 16, 32)
+			if err != nil {
+				p.fail("invalid unicode escape")
+			}
+			b.WriteRune(rune(n))
+			p.pos += 4
+		default:
+			p.fail("invalid escape \\%c", esc)
+		}
+	}
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
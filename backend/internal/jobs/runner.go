@@ -0,0 +1,84 @@
+// Package jobs runs periodic background work inside the server process.
+// Each job runs on its own goroutine, so a job never overlaps with itself; a
+// failed run is logged and retried at the next scheduled time.
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type job struct {
+	name string
+	next func(now time.Time) time.Time
+	run  func(ctx context.Context) error
+}
+
+type Runner struct {
+	jobs      []job
+	onFailure func(name string, err error)
+}
+
+func NewRunner() *Runner {
+	return &Runner{}
+}
+
+// Daily schedules fn every day at hour:minute local time.
+func (r *Runner) Daily(name string, hour, minute int, fn func(ctx context.Context) error) {
+	r.jobs = append(r.jobs, job{
+		name: name,
+		next: func(now time.Time) time.Time {
+			t := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+			if !t.After(now) {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t
+		},
+		run: fn,
+	})
+}
+
+// Every schedules fn at a fixed interval, starting one interval after Start.
+func (r *Runner) Every(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	r.jobs = append(r.jobs, job{
+		name: name,
+		next: func(now time.Time) time.Time {
+			return now.Add(interval)
+		},
+		run: fn,
+	})
+}
+
+// OnFailure registers fn to be told about every failed run, after it is
+// logged.
+func (r *Runner) OnFailure(fn func(name string, err error)) {
+	r.onFailure = fn
+}
+
+// Start launches every scheduled job and returns immediately. Jobs stop when
+// ctx is cancelled.
+func (r *Runner) Start(ctx context.Context) {
+	for _, j := range r.jobs {
+		go r.loop(ctx, j)
+	}
+}
+
+func (r *Runner) loop(ctx context.Context, j job) {
+	for {
+		wait := time.Until(j.next(time.Now()))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := j.run(ctx); err != nil {
+			log.Printf("job %s failed: %v", j.name, err)
+			if r.onFailure != nil {
+				r.onFailure(j.name, err)
+			}
+		}
+	}
+}
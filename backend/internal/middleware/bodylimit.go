@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+)
+
+// MaxBody caps request bodies at limit bytes, and multipart ones at
+// multipartLimit, the size of the largest upload; the upload handlers
+// enforce their own per-file limits within it.
+func MaxBody(limit, multipartLimit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			capBytes := limit
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+				capBytes = multipartLimit
+			}
+			if r.ContentLength > capBytes {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, capBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Compress gzip- or deflate-encodes responses for clients that accept it.
+// Bodies are held back until minSize bytes are written, so small responses go
+// out as is; a flush (event streams) decides early. Only text-like content
+// types are compressed, and range requests are left alone.
+func Compress(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks gzip or deflate by their q-values in
+// Accept-Encoding, preferring gzip on a tie. It returns "" for neither.
+func negotiateEncoding(header string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		if name != "" {
+			q[name] = weight
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range []string{"gzip", "deflate"} {
+		w, ok := q[enc]
+		if !ok {
+			w, ok = q["*"]
+		}
+		if ok && w > bestQ {
+			best, bestQ = enc, w
+		}
+	}
+	return best
+}
+
+func compressible(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mt == "text/event-stream":
+		return false
+	case strings.HasPrefix(mt, "text/"), strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	switch mt {
+	case "application/json", "application/x-ndjson", "application/javascript", "application/xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+type compressMode int
+
+const (
+	modeUndecided compressMode = iota
+	modePassthrough
+	modeCompress
+)
+
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	mode   compressMode
+	status int
+	buf    []byte
+	zw     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.mode != modeUndecided || cw.status != 0 {
+		if cw.mode == modePassthrough {
+			cw.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	cw.status = code
+	// Bodiless and partial responses are never compressed.
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent {
+		cw.start(modePassthrough)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	switch cw.mode {
+	case modePassthrough:
+		return cw.ResponseWriter.Write(p)
+	case modeCompress:
+		return cw.zw.Write(p)
+	}
+	h := cw.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(p))
+	}
+	if h.Get("Content-Encoding") != "" || !compressible(h.Get("Content-Type")) {
+		cw.start(modePassthrough)
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		cw.start(modeCompress)
+	}
+	return len(p), nil
+}
+
+// start sends the headers and anything held back in the chosen mode.
+func (cw *compressWriter) start(mode compressMode) {
+	cw.mode = mode
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if mode == modeCompress {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		if cw.encoding == "gzip" {
+			cw.zw = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.zw = zlib.NewWriter(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) > 0 {
+		buf := cw.buf
+		cw.buf = nil
+		if mode == modeCompress {
+			_, _ = cw.zw.Write(buf)
+		} else {
+			_, _ = cw.ResponseWriter.Write(buf)
+		}
+	}
+}
+
+// Flush commits to a mode so streamed output is not held back.
+func (cw *compressWriter) Flush() {
+	if cw.mode == modeUndecided {
+		h := cw.Header()
+		if h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) && (len(cw.buf) > 0 || cw.status != 0) {
+			cw.start(modeCompress)
+		} else {
+			cw.start(modePassthrough)
+		}
+	}
+	if cw.mode == modeCompress {
+		if f, ok := cw.zw.(interface{ Flush() error }); ok {
+			_ = f.Flush()
+		}
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) close() {
+	switch cw.mode {
+	case modeUndecided:
+		// Nothing written at all leaves the default response to net/http.
+		if cw.status != 0 || len(cw.buf) > 0 {
+			cw.start(modePassthrough)
+		}
+	case modeCompress:
+		_ = cw.zw.Close()
+	}
+}
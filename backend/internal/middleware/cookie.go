@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// CookiePolicy holds the attributes every cookie issued by the server shares.
+type CookiePolicy struct {
+	Secure bool
+}
+
+// New builds an HttpOnly, SameSite=Lax cookie. A zero maxAge makes a
+// session cookie; a negative one deletes the cookie.
+func (p CookiePolicy) New(name, value string, maxAge time.Duration) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   p.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	switch {
+	case maxAge > 0:
+		c.MaxAge = int(maxAge / time.Second)
+		c.Expires = time.Now().Add(maxAge)
+	case maxAge < 0:
+		c.MaxAge = -1
+	}
+	return c
+}
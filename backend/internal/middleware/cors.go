@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy says which browser origins may call the API.
+type CORSPolicy struct {
+	// Origins are exact origins ("https://app.example.com"), wildcard
+	// subdomains ("https://*.example.com", any depth) or "*" for any.
+	Origins []string
+	// AllowCredentials lets browsers send cookies and Authorization along.
+	// It cannot be combined with "*".
+	AllowCredentials bool
+	// MaxAge is how long a browser may cache a preflight answer; zero
+	// leaves it to the browser.
+	MaxAge time.Duration
+}
+
+const (
+	corsMethods = "GET,POST,PUT,DELETE,OPTIONS"
+	corsHeaders = "Content-Type, Authorization, X-Request-ID, X-API-Key, X-Timezone"
+)
+
+// AllowsOrigin reports whether origin matches one of the policy's origins.
+func (p CORSPolicy) AllowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, o := range p.Origins {
+		o = strings.ToLower(o)
+		if o == "*" || o == origin {
+			return true
+		}
+		scheme, host, ok := strings.Cut(o, "://*.")
+		if !ok {
+			continue
+		}
+		rest, ok := strings.CutPrefix(origin, scheme+"://")
+		if !ok {
+			continue
+		}
+		if sub, ok := strings.CutSuffix(rest, "."+host); ok && sub != "" && !strings.ContainsAny(sub, "/:") {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS answers cross-origin requests from allowed origins, echoing the
+// origin back (or "*" when any origin is allowed without credentials).
+// Other origins get no CORS headers, so browsers block them. OPTIONS
+// requests end here with 204.
+func CORS(p CORSPolicy) func(http.Handler) http.Handler {
+	anyOrigin := false
+	for _, o := range p.Origins {
+		anyOrigin = anyOrigin || o == "*"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" && p.AllowsOrigin(origin) {
+				h := w.Header()
+				if anyOrigin && !p.AllowCredentials {
+					h.Set("Access-Control-Allow-Origin", "*")
+				} else {
+					h.Set("Access-Control-Allow-Origin", origin)
+					h.Add("Vary", "Origin")
+				}
+				if p.AllowCredentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+				h.Set("Access-Control-Expose-Headers", "X-Request-ID")
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					h.Set("Access-Control-Allow-Methods", corsMethods)
+					h.Set("Access-Control-Allow-Headers", corsHeaders)
+					if p.MaxAge > 0 {
+						h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+					}
+				}
+			} else if origin != "" {
+				w.Header().Add("Vary", "Origin")
+			}
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
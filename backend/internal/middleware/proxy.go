@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type forwardedProtoKey struct{}
+
+// ProxyHeaders rewrites the request from X-Forwarded-For/Proto when the
+// immediate peer is one of the trusted proxies. The client address is the
+// right-most forwarded hop that is not itself a trusted proxy, so a client
+// cannot spoof its address by sending its own header.
+func ProxyHeaders(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		addr = addr.Unmap()
+		for _, p := range trusted {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, err := netip.ParseAddr(ClientIP(r))
+			if err != nil || !isTrusted(peer) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			hops := make([]string, 0)
+			for _, h := range r.Header.Values("X-Forwarded-For") {
+				hops = append(hops, strings.Split(h, ",")...)
+			}
+			for i := len(hops) - 1; i >= 0; i-- {
+				addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+				if err != nil {
+					break
+				}
+				r.RemoteAddr = net.JoinHostPort(addr.Unmap().String(), "0")
+				if !isTrusted(addr) {
+					break
+				}
+			}
+
+			if proto := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+				r = r.WithContext(context.WithValue(r.Context(), forwardedProtoKey{}, proto))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsHTTPS reports whether the client reached us over HTTPS, either directly
+// or through a trusted proxy.
+func IsHTTPS(r *http.Request) bool {
+	if proto, ok := r.Context().Value(forwardedProtoKey{}).(string); ok {
+		return proto == "https"
+	}
+	return r.TLS != nil
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds IDs taken from clients, which end up in logs and
+// the ledger.
+const maxRequestIDLen = 64
+
+type requestIDKey struct{}
+
+// RequestID tags each request with the client's X-Request-ID, or a new one
+// when it is missing or unusable, and echoes it in the response header.
+// Handlers read it with RequestIDFrom. Server errors (5xx) are logged with
+// the ID, and plain-text ones also carry it in the body, so a user can
+// quote it when reporting the failure.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get(RequestIDHeader))
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		rw := &requestIDWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		if rw.status < http.StatusInternalServerError {
+			return
+		}
+		log.Printf("request %s: %s %s -> %d", id, r.Method, r.URL.Path, rw.status)
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+			fmt.Fprintf(w, "request id: %s\n", id)
+		}
+	})
+}
+
+// RequestIDFrom returns the ID set by RequestID, or "" outside a request.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// requestIDWriter records the response status.
+type requestIDWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rw *requestIDWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *requestIDWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *requestIDWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *requestIDWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
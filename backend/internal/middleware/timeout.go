@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type timeoutKey struct{}
+
+// Timeout puts a deadline of d on the request context, which every query
+// runs under, so a slow query is cancelled instead of holding the single
+// SQLite connection. Requests exempt reports (long-lived streams, exports)
+// get no deadline; they bound each query with QueryContext instead.
+func Timeout(d time.Duration, exempt func(*http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), timeoutKey{}, d)
+			if exempt(r) {
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// QueryContext gives one query of an exempt request the deadline Timeout
+// puts on other requests. Without Timeout in front ctx is returned as is.
+func QueryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d, ok := ctx.Value(timeoutKey{}).(time.Duration); ok {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
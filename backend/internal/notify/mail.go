@@ -0,0 +1,66 @@
+// Package notify sends operator notifications by email and change
+// notifications to a webhook.
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Mailer sends plain text mail through one SMTP server. smtp.SendMail
+// upgrades to STARTTLS when the server offers it.
+type Mailer struct {
+	cfg SMTPConfig
+}
+
+var ErrNotConfigured = errors.New("email notifications are not configured")
+
+func NewMailer(cfg SMTPConfig) *Mailer {
+	return &Mailer{cfg: cfg}
+}
+
+// Enabled reports whether a server, sender and recipients are set.
+func (m *Mailer) Enabled() bool {
+	return m.cfg.Host != "" && m.cfg.From != "" && len(m.cfg.To) > 0
+}
+
+func (m *Mailer) Send(subject, body string) error {
+	if !m.Enabled() {
+		return ErrNotConfigured
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+	if err := smtp.SendMail(addr, auth, m.cfg.From, m.cfg.To, msg.Bytes()); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Webhook posts JSON messages to one URL. With a secret, each body is signed
+// the way the order webhook expects inbound ones: the hex HMAC-SHA256 of the
+// body in X-Signature-SHA256.
+type Webhook struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhook(url, secret string) *Webhook {
+	return &Webhook{url: url, secret: secret, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// Enabled reports whether a URL is set.
+func (w *Webhook) Enabled() bool {
+	return w.url != ""
+}
+
+// Post sends body. deliveryID goes in X-Delivery-ID and is the same on every
+// retry, so the receiver can drop repeats. Any status but 2xx is an error.
+func (w *Webhook) Post(ctx context.Context, deliveryID string, body []byte) error {
+	if !w.Enabled() {
+		return errors.New("webhook: no url configured")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Delivery-ID", deliveryID)
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-SHA256", hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("webhook: %s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIDP serves the discovery document and a key set holding key as k1.
+func testIDP(t *testing.T, key *rsa.PublicKey) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/auth",
+			"token_endpoint":         srv.URL + "/token",
+			"jwks_uri":               srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	return srv
+}
+
+// signToken makes a compact JWS of header and claims signed by key.
+func signToken(t *testing.T, key *rsa.PrivateKey, header, claims map[string]any) string {
+	t.Helper()
+	seg := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := seg(header) + "." + seg(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := testIDP(t, &key.PublicKey)
+	p, err := New(Config{Issuer: srv.URL, ClientID: "stockmate", RedirectURL: "https://stock.example.com/auth/callback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		header  map[string]any
+		claims  map[string]any
+		nonce   string
+		wantErr string
+	}{
+		{name: "valid"},
+		{name: "audience list", claims: map[string]any{"aud": []string{"other", "stockmate"}}},
+		{name: "expired within skew", claims: map[string]any{"exp": now.Add(-time.Minute).Unix()}},
+		{name: "other key", key: other, wantErr: "bad signature"},
+		{name: "HS256", header: map[string]any{"alg": "HS256"}, wantErr: "unsupported alg"},
+		{name: "alg none", header: map[string]any{"alg": "none"}, wantErr: "unsupported alg"},
+		{name: "unknown key id", header: map[string]any{"kid": "k2"}, wantErr: "unknown key"},
+		{name: "wrong issuer", claims: map[string]any{"iss": "https://evil.example.com"}, wantErr: "wrong issuer"},
+		{name: "wrong audience", claims: map[string]any{"aud": "other"}, wantErr: "wrong audience"},
+		{name: "expired", claims: map[string]any{"exp": now.Add(-time.Hour).Unix()}, wantErr: "expired"},
+		{name: "no expiry", claims: map[string]any{"exp": nil}, wantErr: "expired"},
+		{name: "nonce mismatch", nonce: "n2", wantErr: "nonce mismatch"},
+		{name: "no subject", claims: map[string]any{"sub": ""}, wantErr: "no subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := map[string]any{"alg": "RS256", "kid": "k1"}
+			claims := map[string]any{
+				"iss": srv.URL, "aud": "stockmate", "sub": "u1", "nonce": "n1",
+				"exp": now.Add(time.Hour).Unix(), "email": "ann@example.com",
+			}
+			for k, v := range tt.header {
+				header[k] = v
+			}
+			for k, v := range tt.claims {
+				if v == nil {
+					delete(claims, k)
+					continue
+				}
+				claims[k] = v
+			}
+			signer, nonce := key, "n1"
+			if tt.key != nil {
+				signer = tt.key
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			got, err := p.Verify(context.Background(), signToken(t, signer, header, claims), nonce, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String("sub") != "u1" || got.String("email") != "ann@example.com" {
+				t.Fatalf("claims = %v", got)
+			}
+		})
+	}
+
+	// A payload changed after signing fails like a foreign key.
+	tok := signToken(t, key, map[string]any{"alg": "RS256", "kid": "k1"}, map[string]any{
+		"iss": srv.URL, "aud": "stockmate", "sub": "u1", "nonce": "n1", "exp": now.Add(time.Hour).Unix(),
+	})
+	parts := strings.Split(tok, ".")
+	forged, _ := json.Marshal(map[string]any{"iss": srv.URL, "aud": "stockmate", "sub": "admin", "nonce": "n1", "exp": now.Add(time.Hour).Unix()})
+	parts[1] = base64.RawURLEncoding.EncodeToString(forged)
+	if _, err := p.Verify(context.Background(), strings.Join(parts, "."), "n1", now); err == nil || !strings.Contains(err.Error(), "bad signature") {
+		t.Fatalf("forged payload: %v", err)
+	}
+}
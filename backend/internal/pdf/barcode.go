@@ -0,0 +1,73 @@
+package pdf
+
+import "fmt"
+
+// code128Patterns are the bar/space widths, in modules, of the Code 128
+// symbols by value; 103-105 are the start codes and 106 is stop.
+var code128Patterns = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const code128StartB = 104
+
+// code128 encodes s in code set B and returns the widths of its bars and
+// spaces, starting with a bar. ok is false when s is empty or has a
+// character outside printable ASCII.
+func code128(s string) (widths []int, ok bool) {
+	if s == "" {
+		return nil, false
+	}
+	values := []int{code128StartB}
+	sum := code128StartB
+	for i, r := range s {
+		if r < 32 || r > 126 {
+			return nil, false
+		}
+		v := int(r) - 32
+		values = append(values, v)
+		// i is a byte offset, but every accepted rune is one byte.
+		sum += v * (i + 1)
+	}
+	values = append(values, sum%103, 106)
+	for _, v := range values {
+		for _, c := range code128Patterns[v] {
+			widths = append(widths, int(c-'0'))
+		}
+	}
+	return widths, true
+}
+
+// barcode draws s as Code 128 bars of height h whose lower left corner is
+// at x, y, scaled to at most width points. It draws nothing for values
+// Code 128 set B can't hold.
+func (p *page) barcode(x, y, width, h float64, s string) {
+	widths, ok := code128(s)
+	if !ok {
+		return
+	}
+	modules := 0
+	for _, w := range widths {
+		modules += w
+	}
+	module := min(width/float64(modules), 1.0)
+	for i, w := range widths {
+		if i%2 == 0 {
+			p.rect(x, y, float64(w)*module, h)
+		}
+		x += float64(w) * module
+	}
+}
+
+func (p *page) rect(x, y, w, h float64) {
+	fmt.Fprintf(&p.content, "%.2f %.2f %.2f %.2f re f\n", x, y, w, h)
+}
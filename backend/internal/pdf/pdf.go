@@ -0,0 +1,325 @@
+// Package pdf renders simple printable table reports as PDF.
+//
+// Text uses the predefined Japanese CID font HeiseiKakuGo-W5, which PDF
+// viewers supply themselves, so nothing is embedded and both Japanese and
+// ASCII text print without font files.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// A4 portrait in points.
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	margin     = 40.0
+
+	titleSize = 14.0
+	groupSize = 11.0
+	textSize  = 9.0
+	rowHeight = 14.0
+	logoMaxH  = 36.0
+	// barcodeRowHeight leaves room for bars tall enough to scan.
+	barcodeRowHeight = 30.0
+)
+
+type Column struct {
+	Header string
+	// Width is a share of the table width; columns are scaled to fit.
+	Width float64
+	Right bool
+	// Barcode prints the cell as a Code 128 barcode instead of text.
+	Barcode bool
+}
+
+// Group is a run of rows under a heading.
+type Group struct {
+	Title string
+	Rows  [][]string
+}
+
+// Document is a titled table with optional totals lines under it.
+type Document struct {
+	Title    string
+	Subtitle string
+	// Header is printed at the top right of every page, e.g. the company.
+	Header string
+	// Logo is a JPEG printed at the top left of the first page.
+	Logo    []byte
+	Columns []Column
+	Rows    [][]string
+	// Groups, when set, are printed instead of Rows, each under its title.
+	Groups []Group
+	Totals []string
+}
+
+// textWidth estimates the width of s in ems: half-width for ASCII and
+// half-width kana, full-width otherwise.
+func textWidth(s string) float64 {
+	var w float64
+	for _, r := range s {
+		if r < 0x80 || (r >= 0xff61 && r <= 0xff9f) {
+			w += 0.5
+		} else {
+			w += 1
+		}
+	}
+	return w
+}
+
+// fit shortens s to at most width points at size.
+func fit(s string, width, size float64) string {
+	if textWidth(s)*size <= width {
+		return s
+	}
+	rs := []rune(s)
+	for len(rs) > 0 && (textWidth(string(rs))+0.5)*size > width {
+		rs = rs[:len(rs)-1]
+	}
+	return string(rs) + "~"
+}
+
+// hexText encodes s for the UniJIS-UCS2-HW-H CMap (UCS-2 big endian).
+func hexText(s string) string {
+	var b strings.Builder
+	b.WriteByte('<')
+	for _, r := range s {
+		if r > 0xffff {
+			r = '?'
+		}
+		for _, u := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&b, "%04X", u)
+		}
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+type page struct {
+	content bytes.Buffer
+}
+
+func (p *page) text(x, y, size float64, s string) {
+	fmt.Fprintf(&p.content, "BT /F1 %.1f Tf %.2f %.2f Td %s Tj ET\n", size, x, y, hexText(s))
+}
+
+func (p *page) line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, y1, x2, y2)
+}
+
+// image is a JPEG passed through to the PDF as is.
+type image struct {
+	data          []byte
+	width, height int
+	colorSpace    string
+}
+
+func decodeLogo(data []byte) (*image, error) {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	img := &image{data: data, width: cfg.Width, height: cfg.Height, colorSpace: "/DeviceRGB"}
+	switch cfg.ColorModel {
+	case color.GrayModel:
+		img.colorSpace = "/DeviceGray"
+	case color.CMYKModel:
+		img.colorSpace = "/DeviceCMYK"
+	}
+	return img, nil
+}
+
+// CheckLogo reports whether data can be used as Document.Logo.
+func CheckLogo(data []byte) error {
+	_, err := decodeLogo(data)
+	return err
+}
+
+// Render writes the document as a PDF.
+func (d *Document) Render(w io.Writer) error {
+	var logo *image
+	var logoW, logoH float64
+	if len(d.Logo) > 0 {
+		var err error
+		if logo, err = decodeLogo(d.Logo); err != nil {
+			return fmt.Errorf("logo: %w", err)
+		}
+		logoH = logoMaxH
+		logoW = float64(logo.width) * logoH / float64(logo.height)
+	}
+
+	tableWidth := pageWidth - 2*margin
+	var shares float64
+	for _, c := range d.Columns {
+		shares += c.Width
+	}
+	widths := make([]float64, len(d.Columns))
+	rowH := rowHeight
+	for i, c := range d.Columns {
+		widths[i] = tableWidth * c.Width / shares
+		if c.Barcode {
+			rowH = barcodeRowHeight
+		}
+	}
+	cell := func(p *page, y float64, i int, x float64, s string) {
+		s = fit(s, widths[i]-6, textSize)
+		if d.Columns[i].Right {
+			p.text(x+widths[i]-3-textWidth(s)*textSize, y, textSize, s)
+			return
+		}
+		p.text(x+3, y, textSize, s)
+	}
+	tableHeader := func(p *page, y float64) {
+		x := margin
+		for i, c := range d.Columns {
+			cell(p, y, i, x, c.Header)
+			x += widths[i]
+		}
+		p.line(margin, y-4, margin+tableWidth, y-4, 0.8)
+	}
+
+	var pages []*page
+	var p *page
+	var y float64
+	newPage := func() {
+		p = &page{}
+		pages = append(pages, p)
+		y = pageHeight - margin
+		if d.Header != "" {
+			p.text(pageWidth-margin-textWidth(d.Header)*textSize, y-textSize, textSize, d.Header)
+		}
+		if len(pages) == 1 {
+			if logoH > 0 {
+				fmt.Fprintf(&p.content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im1 Do Q\n", logoW, logoH, margin, y-logoH)
+				y -= logoH + 10
+			}
+			p.text(margin, y-titleSize, titleSize, d.Title)
+			y -= titleSize + 6
+			if d.Subtitle != "" {
+				p.text(margin, y-textSize, textSize, d.Subtitle)
+				y -= textSize + 6
+			}
+			y -= 8
+		} else {
+			y -= textSize + 12
+		}
+		y -= textSize
+		tableHeader(p, y)
+		y -= rowHeight
+	}
+	newPage()
+	groups := d.Groups
+	if len(groups) == 0 {
+		groups = []Group{{Rows: d.Rows}}
+	}
+	for _, g := range groups {
+		if g.Title != "" {
+			if y < margin+rowH+groupSize+8 {
+				newPage()
+			}
+			y -= 6
+			p.text(margin, y, groupSize, g.Title)
+			y -= groupSize + 4
+		}
+		for _, row := range g.Rows {
+			if y < margin+rowH {
+				newPage()
+			}
+			// Taller rows keep text on the lower line, level with the bars.
+			y -= rowH - rowHeight
+			x := margin
+			for i, c := range d.Columns {
+				switch {
+				case i >= len(row):
+				case c.Barcode:
+					p.barcode(x+3, y-1, widths[i]-6, rowH-8, row[i])
+				default:
+					cell(p, y, i, x, row[i])
+				}
+				x += widths[i]
+			}
+			p.line(margin, y-4, margin+tableWidth, y-4, 0.2)
+			y -= rowHeight
+		}
+	}
+	if len(d.Totals) > 0 {
+		y -= 4
+		for _, t := range d.Totals {
+			if y < margin+rowHeight {
+				newPage()
+			}
+			p.text(margin+tableWidth-textWidth(t)*textSize-3, y, textSize, t)
+			y -= rowHeight
+		}
+	}
+	for i, p := range pages {
+		footer := fmt.Sprintf("%d / %d", i+1, len(pages))
+		p.text((pageWidth-textWidth(footer)*textSize)/2, margin/2, textSize, footer)
+	}
+
+	return writePDF(w, pages, logo)
+}
+
+func writePDF(w io.Writer, pages []*page, logo *image) error {
+	var buf bytes.Buffer
+	offsets := make([]int, 0)
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	stream := func(dict string, data []byte) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n<< %s/Length %d >>\nstream\n", len(offsets), dict, len(data))
+		buf.Write(data)
+		buf.WriteString("\nendstream\nendobj\n")
+	}
+
+	// Objects 1-5 are fixed; the logo, if any, is 6; pages follow in
+	// (page, content) pairs.
+	first := 6
+	if logo != nil {
+		first = 7
+	}
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", first+2*i)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	obj("<< /Type /Font /Subtype /Type0 /BaseFont /HeiseiKakuGo-W5 /Encoding /UniJIS-UCS2-HW-H /DescendantFonts [4 0 R] >>")
+	obj("<< /Type /Font /Subtype /CIDFontType0 /BaseFont /HeiseiKakuGo-W5 " +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (Japan1) /Supplement 2 >> " +
+		"/FontDescriptor 5 0 R /DW 1000 /W [231 325 500] >>")
+	obj("<< /Type /FontDescriptor /FontName /HeiseiKakuGo-W5 /Flags 4 /FontBBox [-92 -250 1010 922] " +
+		"/ItalicAngle 0 /Ascent 752 /Descent -221 /CapHeight 737 /StemV 114 >>")
+	resources := "<< /Font << /F1 3 0 R >> >>"
+	if logo != nil {
+		stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode ",
+			logo.width, logo.height, logo.colorSpace), logo.data)
+		resources = "<< /Font << /F1 3 0 R >> /XObject << /Im1 6 0 R >> >>"
+	}
+	for i, p := range pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources %s /Contents %d 0 R >>",
+			pageWidth, pageHeight, resources, first+2*i+1))
+		stream("", p.content.Bytes())
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,101 @@
+package querybuilder
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestWhere(t *testing.T) {
+	query := url.Values{
+		"q":        {"lamp'; DROP TABLE items; --"},
+		"type":     {"in, Out"},
+		"managed":  {"no"},
+		"item_id":  {"7"},
+		"ignored":  {"x"},
+		"sellable": {""},
+	}
+	var w Where
+	w.Search(query, "q", "i.sku", "i.name")
+	if err := w.OneOf(query, "type", "st.transaction_type", "IN", "OUT", "ADJUST"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flag(query, "managed", "i.stock_managed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flag(query, "sellable", "i.is_sellable"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ID(query, "item_id", "st.item_id"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := " AND (i.sku LIKE ? OR i.name LIKE ?) AND st.transaction_type IN (?,?) AND i.stock_managed = 0 AND st.item_id = ?"
+	if got := w.SQL(); got != want {
+		t.Errorf("SQL() = %q\nwant %q", got, want)
+	}
+	like := "%lamp'; DROP TABLE items; --%"
+	wantArgs := []any{like, like, "IN", "OUT", int64(7)}
+	if got := w.Args(); !slices.Equal(got, wantArgs) {
+		t.Errorf("Args() = %v, want %v", got, wantArgs)
+	}
+
+	for _, bad := range []url.Values{
+		{"type": {"IN,SELL"}},
+		{"managed": {"maybe"}},
+		{"item_id": {"0"}},
+	} {
+		var w Where
+		err := w.OneOf(bad, "type", "t", "IN", "OUT")
+		if err == nil {
+			err = w.Flag(bad, "managed", "m")
+		}
+		if err == nil {
+			err = w.ID(bad, "item_id", "id")
+		}
+		if err == nil {
+			t.Errorf("%v: no error", bad)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	for _, tc := range []struct {
+		raw      string
+		def, max int
+		want     int
+		wantErr  bool
+	}{
+		{"", 50, 200, 50, false},
+		{"20", 50, 200, 20, false},
+		{"500", 50, 200, 200, false},
+		{"500", -1, 0, 500, false},
+		{"0", 50, 200, 0, true},
+		{"ten", 50, 200, 0, true},
+	} {
+		got, err := Limit(url.Values{"limit": {tc.raw}}, tc.def, tc.max)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("Limit(%q, %d, %d) = %d, %v", tc.raw, tc.def, tc.max, got, err)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	cols := SortColumns{"id": "i.item_id", "sku": "i.sku COLLATE NOCASE"}
+	for _, tc := range []struct {
+		raw, want string
+		wantErr   bool
+	}{
+		{"", "ORDER BY i.item_id DESC", false},
+		{"sku", "ORDER BY i.sku COLLATE NOCASE ASC, i.item_id ASC", false},
+		{"-sku", "ORDER BY i.sku COLLATE NOCASE DESC, i.item_id DESC", false},
+		{"id:desc", "ORDER BY i.item_id DESC", false},
+		{"sku:sideways", "", true},
+		{"i.name; DROP TABLE items", "", true},
+	} {
+		got, err := OrderBy(tc.raw, cols, "i.item_id")
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("OrderBy(%q) = %q, %v", tc.raw, got, err)
+		}
+	}
+}
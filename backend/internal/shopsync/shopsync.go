@@ -0,0 +1,156 @@
+// Package shopsync pushes on-hand quantities to e-commerce platforms.
+package shopsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Channel names, as stored in channel_listings.channel.
+const (
+	Shopify = "shopify"
+	BASE    = "base"
+)
+
+// Channels lists the supported channels.
+var Channels = []string{Shopify, BASE}
+
+var ErrNotConfigured = errors.New("shop sync is not configured")
+
+// Adapter sets the available quantity of one listing on a platform.
+type Adapter interface {
+	Channel() string
+	SetStock(ctx context.Context, externalID string, qty int64) error
+}
+
+type Config struct {
+	// Channel selects the adapter; empty disables syncing.
+	Channel string
+
+	// ShopifyShop is the shop domain, e.g. example.myshopify.com.
+	ShopifyShop        string
+	ShopifyAccessToken string
+	ShopifyLocationID  string
+
+	BaseAccessToken string
+}
+
+// New builds the adapter selected by cfg.Channel.
+func New(cfg Config) (Adapter, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch cfg.Channel {
+	case "":
+		return nil, ErrNotConfigured
+	case Shopify:
+		if cfg.ShopifyShop == "" || cfg.ShopifyAccessToken == "" || cfg.ShopifyLocationID == "" {
+			return nil, fmt.Errorf("shopify sync needs a shop, access token and location id")
+		}
+		locationID, err := strconv.ParseInt(cfg.ShopifyLocationID, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shopify location id: %q", cfg.ShopifyLocationID)
+		}
+		return &shopifyAdapter{
+			client:     client,
+			endpoint:   "https://" + strings.TrimSuffix(cfg.ShopifyShop, "/") + "/admin/api/2024-07/inventory_levels/set.json",
+			token:      cfg.ShopifyAccessToken,
+			locationID: locationID,
+		}, nil
+	case BASE:
+		if cfg.BaseAccessToken == "" {
+			return nil, fmt.Errorf("base sync needs an access token")
+		}
+		return &baseAdapter{
+			client:   client,
+			endpoint: "https://api.thebase.in/1/items/edit_stock",
+			token:    cfg.BaseAccessToken,
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported shop sync channel: %s", cfg.Channel)
+}
+
+// shopifyAdapter sets inventory levels at one location. External ids are
+// inventory item ids (not product or variant ids).
+type shopifyAdapter struct {
+	client     *http.Client
+	endpoint   string
+	token      string
+	locationID int64
+}
+
+func (a *shopifyAdapter) Channel() string { return Shopify }
+
+func (a *shopifyAdapter) SetStock(ctx context.Context, externalID string, qty int64) error {
+	inventoryItemID, err := strconv.ParseInt(externalID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("shopify: invalid inventory item id: %q", externalID)
+	}
+	body, err := json.Marshal(map[string]any{
+		"location_id":       a.locationID,
+		"inventory_item_id": inventoryItemID,
+		"available":         qty,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Shopify-Access-Token", a.token)
+	return do(a.client, req, Shopify)
+}
+
+// baseAdapter edits item stock through the BASE API. External ids are item
+// ids, or "item_id:variation_id" for a variation.
+type baseAdapter struct {
+	client   *http.Client
+	endpoint string
+	token    string
+}
+
+func (a *baseAdapter) Channel() string { return BASE }
+
+func (a *baseAdapter) SetStock(ctx context.Context, externalID string, qty int64) error {
+	itemID, variationID, _ := strings.Cut(externalID, ":")
+	form := url.Values{}
+	form.Set("item_id", itemID)
+	if variationID != "" {
+		form.Set("variation_id", variationID)
+		form.Set("variation_stock", strconv.FormatInt(qty, 10))
+	} else {
+		form.Set("stock", strconv.FormatInt(qty, 10))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return do(a.client, req, BASE)
+}
+
+// do sends req and turns a non-2xx answer into an error carrying the start
+// of the response body.
+func do(client *http.Client, req *http.Request, channel string) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", channel, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s: %s: %s", channel, resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrNotFound is returned when the requested object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// Store persists opaque blobs (attachment files) under string keys.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// FromEnv builds the attachment store selected by ATTACHMENT_STORAGE (disk or s3).
+func FromEnv() (Store, error) {
+	kind := strings.ToLower(strings.TrimSpace(os.Getenv("ATTACHMENT_STORAGE")))
+	switch kind {
+	case "", "disk":
+		dir := strings.TrimSpace(os.Getenv("ATTACHMENT_DIR"))
+		if dir == "" {
+			dir = "./data/attachments"
+		}
+		return NewDisk(dir)
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		})
+	}
+	return nil, fmt.Errorf("unsupported ATTACHMENT_STORAGE: %s", kind)
+}
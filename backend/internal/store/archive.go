@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"stockmate/internal/db"
+	"stockmate/internal/timeutil"
+)
+
+// OpeningBalanceReason marks the ADJUST rows that stand in for archived
+// history.
+const OpeningBalanceReason = "opening_balance"
+
+// ErrLedgerAppendOnly is returned by ArchiveTransactions when ledger rows
+// may not be deleted.
+var ErrLedgerAppendOnly = errors.New("stock_transactions is append-only; old rows cannot be archived")
+
+type ArchiveReport struct {
+	Before string `json:"before"`
+	// Archived rows moved to stock_transactions_archive.
+	Archived int64 `json:"archived"`
+	// OpeningBalances counts the items that got an opening-balance row.
+	OpeningBalances int64 `json:"opening_balances"`
+	// Held counts rows old enough to archive that were kept because a
+	// newer reversal or correction, or a landed cost, refers to them.
+	Held   int64 `json:"held"`
+	DryRun bool  `json:"dry_run"`
+}
+
+// archiveColumns are the stock_transactions columns copied to the archive.
+// A column added to the ledger must be added to both.
+const archiveColumns = `transaction_id, item_id, qty, transaction_type, note, created_at,
+  reversal_of, reason_code, bom_record_id, ref_type, ref_id, request_id, correction_of, actor`
+
+// ArchiveTransactions moves ledger rows created before before into
+// stock_transactions_archive. Each item's archived rows are replaced by one
+// ADJUST row with their net quantity, dated at the last of them, so stock
+// and every balance from then on add up as before; earlier opening balances
+// are rolled into the new one. Rows linked by reversal_of or correction_of
+// are only archived together. With dryRun nothing is kept.
+func (s *Store) ArchiveTransactions(ctx context.Context, before time.Time, dryRun bool) (ArchiveReport, error) {
+	rep := ArchiveReport{Before: timeutil.Format(before), DryRun: dryRun}
+	on, _, err := db.LedgerAppendOnly(ctx, s.db)
+	if err != nil {
+		return rep, err
+	}
+	if on {
+		return rep, ErrLedgerAppendOnly
+	}
+	err = retryBusy(ctx, func() error {
+		rep.Archived, rep.OpeningBalances, rep.Held = 0, 0, 0
+		return s.archiveTransactions(ctx, &rep)
+	})
+	return rep, err
+}
+
+func (s *Store) archiveTransactions(ctx context.Context, rep *ArchiveReport) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The candidates live in a temp table on the transaction's connection;
+	// creating it is rolled back with everything else.
+	for _, stmt := range []string{
+		`CREATE TEMP TABLE IF NOT EXISTS archive_candidates (transaction_id INTEGER PRIMARY KEY)`,
+		`DELETE FROM archive_candidates`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO archive_candidates(transaction_id)
+SELECT st.transaction_id FROM stock_transactions st
+WHERE st.created_at < ?
+  AND NOT EXISTS (SELECT 1 FROM landed_cost_allocations la WHERE la.transaction_id = st.transaction_id)
+`, rep.Before)
+	if err != nil {
+		return fmt.Errorf("select rows: %w", err)
+	}
+	old, _ := res.RowsAffected()
+
+	// Drop candidates linked to a row that stays, until none are left: a
+	// held row can hold the rest of its chain.
+	for {
+		res, err := tx.ExecContext(ctx, `
+DELETE FROM archive_candidates
+WHERE transaction_id IN (
+  SELECT st.transaction_id
+  FROM stock_transactions st
+  JOIN archive_candidates c ON c.transaction_id = st.transaction_id
+  WHERE (st.reversal_of IS NOT NULL AND st.reversal_of NOT IN (SELECT transaction_id FROM archive_candidates))
+     OR (st.correction_of IS NOT NULL AND st.correction_of NOT IN (SELECT transaction_id FROM archive_candidates))
+     OR EXISTS (
+       SELECT 1 FROM stock_transactions o
+       WHERE o.reversal_of = st.transaction_id
+         AND o.transaction_id NOT IN (SELECT transaction_id FROM archive_candidates))
+     OR EXISTS (
+       SELECT 1 FROM stock_transactions o
+       WHERE o.correction_of = st.transaction_id
+         AND o.transaction_id NOT IN (SELECT transaction_id FROM archive_candidates))
+)
+`)
+		if err != nil {
+			return fmt.Errorf("hold linked rows: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			break
+		}
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(1) FROM archive_candidates`).Scan(&rep.Archived); err != nil {
+		return err
+	}
+	rep.Held = old - rep.Archived
+	if rep.Archived == 0 {
+		return nil
+	}
+
+	// Quantities are kept to 6 decimals, the finest unit precision, so float
+	// noise in the sum neither books a tiny balance nor drops a real one.
+	res, err = tx.ExecContext(ctx, `
+INSERT INTO stock_transactions(item_id, qty, transaction_type, note, created_at, reason_code)
+SELECT st.item_id,
+  ROUND(SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END), 6),
+  'ADJUST',
+  'Opening balance: ' || COUNT(1) || ' rows before ' || ? || ' archived',
+  MAX(st.created_at),
+  ?
+FROM stock_transactions st
+JOIN archive_candidates c ON c.transaction_id = st.transaction_id
+GROUP BY st.item_id
+HAVING ROUND(SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END), 6) <> 0
+`, rep.Before, OpeningBalanceReason)
+	if err != nil {
+		return fmt.Errorf("book opening balances: %w", err)
+	}
+	rep.OpeningBalances, _ = res.RowsAffected()
+
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO stock_transactions_archive(`+archiveColumns+`)
+SELECT `+archiveColumns+` FROM stock_transactions
+WHERE transaction_id IN (SELECT transaction_id FROM archive_candidates)
+`); err != nil {
+		return fmt.Errorf("copy rows: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+DELETE FROM stock_transactions WHERE transaction_id IN (SELECT transaction_id FROM archive_candidates)
+`); err != nil {
+		return fmt.Errorf("delete rows: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DROP TABLE temp.archive_candidates`); err != nil {
+		return err
+	}
+	if rep.DryRun {
+		return nil
+	}
+	return Commit(ctx, tx)
+}
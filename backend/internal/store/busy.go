@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// busy_timeout makes SQLite wait for locks by itself, but a transaction that
+// read first and then tries to write gets SQLITE_BUSY at once when another
+// connection committed in between (its snapshot is stale under WAL). Only
+// restarting the whole transaction helps there, which retryBusy does.
+const (
+	busyRetries = 5
+	busyBackoff = 20 * time.Millisecond
+)
+
+// IsBusy reports whether err is SQLITE_BUSY or SQLITE_LOCKED.
+func IsBusy(err error) bool {
+	var se *sqlite.Error
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}
+
+// retryBusy runs fn again, with growing pauses, while it fails with
+// SQLITE_BUSY. fn must be safe to repeat, i.e. a whole transaction.
+func retryBusy(ctx context.Context, fn func() error) error {
+	wait := busyBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !IsBusy(err) || attempt == busyRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// IsUniqueViolation reports whether err is a UNIQUE or PRIMARY KEY
+// constraint failure.
+func IsUniqueViolation(err error) bool {
+	var se *sqlite.Error
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// planCheck is a query on a hot path whose plan must not walk a whole
+// table. noScan lists the tables (or their aliases) that must be reached
+// through an index.
+type planCheck struct {
+	name   string
+	query  string
+	args   []any
+	noScan []string
+}
+
+// planChecks mirror the server's per-request stock queries; each is one
+// index lookup away from a full ledger scan on a large database.
+var planChecks = []planCheck{
+	{
+		name:   "item stock",
+		query:  itemStockQuery,
+		args:   []any{1},
+		noScan: []string{"stock_transactions"},
+	},
+	{
+		// The shape of GET /api/components/stock and /api/assemblies/stock.
+		name: "stock listing",
+		query: `
+SELECT i.item_id, COALESCE(SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END), 0)
+FROM items i
+LEFT JOIN stock_transactions st ON st.item_id = i.item_id
+WHERE i.item_type = ? AND i.stock_managed = 1
+GROUP BY i.item_id
+`,
+		args:   []any{"component"},
+		noScan: []string{"i", "st"},
+	},
+	{
+		// Usage windows and last-movement lookups on the item page.
+		name: "item ledger window",
+		query: `
+SELECT COALESCE(SUM(qty), 0) FROM stock_transactions
+WHERE item_id = ? AND created_at >= ? AND transaction_type = 'OUT'
+`,
+		args:   []any{1, "2000-01-01T00:00:00Z"},
+		noScan: []string{"stock_transactions"},
+	},
+}
+
+// PlanReport is the query plan of one hot query. Scans lists the plan
+// steps that read a table without an index, which should be empty.
+type PlanReport struct {
+	Name  string   `json:"name"`
+	Plan  []string `json:"plan"`
+	Scans []string `json:"scans"`
+}
+
+func (p PlanReport) OK() bool {
+	return len(p.Scans) == 0
+}
+
+// CheckQueryPlans runs EXPLAIN QUERY PLAN on the hot stock queries and
+// reports any that would scan the ledger or items table, e.g. because an
+// index is missing from a database migrated by hand.
+func (s *Store) CheckQueryPlans(ctx context.Context) ([]PlanReport, error) {
+	out := make([]PlanReport, 0, len(planChecks))
+	for _, c := range planChecks {
+		rows, err := s.db.QueryContext(ctx, `EXPLAIN QUERY PLAN `+c.query, c.args...)
+		if err != nil {
+			return nil, fmt.Errorf("explain %s: %w", c.name, err)
+		}
+		rep := PlanReport{Name: c.name, Plan: make([]string, 0), Scans: make([]string, 0)}
+		for rows.Next() {
+			var id, parent, notUsed int
+			var detail string
+			if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			rep.Plan = append(rep.Plan, detail)
+			if fullScan(detail, c.noScan) {
+				rep.Scans = append(rep.Scans, detail)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rep)
+	}
+	return out, nil
+}
+
+// fullScan reports whether a plan step reads one of tables start to end.
+// SQLite words it "SCAN st" or, before 3.36, "SCAN TABLE stock_transactions
+// AS st"; a scan of a covering index is cheap enough to pass.
+func fullScan(detail string, tables []string) bool {
+	rest, ok := strings.CutPrefix(detail, "SCAN ")
+	if !ok || strings.Contains(rest, " USING COVERING INDEX ") {
+		return false
+	}
+	rest = strings.TrimPrefix(rest, "TABLE ")
+	fields := strings.Fields(rest)
+	for _, t := range tables {
+		for i, f := range fields {
+			// The table name, or the alias after AS.
+			if f == t && (i == 0 || fields[i-1] == "AS") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// A dry run goes through a write as usual, validation, triggers and
+// constraints included, and rolls back where it would commit, so the caller
+// sees what would change without anything being kept.
+
+type dryRunKey struct{}
+
+// WithDryRun marks ctx so that Commit and Exec keep nothing.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// IsDryRun reports whether ctx was marked by WithDryRun.
+func IsDryRun(ctx context.Context) bool {
+	dry, _ := ctx.Value(dryRunKey{}).(bool)
+	return dry
+}
+
+// Commit commits tx, first recording the message WithOutbox left on ctx,
+// or in a dry run rolls it back. Reads made on tx before Commit see the
+// changes either way.
+func Commit(ctx context.Context, tx *sql.Tx) error {
+	if IsDryRun(ctx) {
+		return tx.Rollback()
+	}
+	p := outboxFrom(ctx)
+	if p != nil {
+		if err := Enqueue(ctx, tx, p.topic, p.payload); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if p != nil {
+		p.written = true
+	}
+	return nil
+}
+
+// Exec runs a single write on db. In a dry run, or when it has an outbox
+// message to record, it runs in a transaction committed by Commit, so rows
+// affected, the insert id and constraint errors are reported either way. A
+// write that changes no row records no message.
+func Exec(ctx context.Context, db *sql.DB, query string, args ...any) (sql.Result, error) {
+	if !IsDryRun(ctx) && outboxFrom(ctx) == nil {
+		return db.ExecContext(ctx, query, args...)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return res, nil
+	}
+	return res, Commit(ctx, tx)
+}
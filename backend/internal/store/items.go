@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ItemRecord is the flat, portable view of an item used for CSV exchange.
+// Series are referred to by name so files move between instances.
+type ItemRecord struct {
+	ID           int64
+	SeriesName   string
+	SKU          string
+	Name         string
+	ItemType     string
+	ManagedUnit  string
+	StockManaged bool
+	IsSellable   bool
+	IsFinal      bool
+	PackQty      *float64
+	ReorderPoint *float64
+	Note         string
+}
+
+func (s *Store) ListItemRecords(ctx context.Context) ([]ItemRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT
+  i.item_id,
+  COALESCE(s.name, ''),
+  i.sku,
+  i.name,
+  i.item_type,
+  i.managed_unit,
+  i.stock_managed,
+  i.is_sellable,
+  i.is_final,
+  i.pack_qty,
+  i.reorder_point,
+  COALESCE(i.note, '')
+FROM items i
+LEFT JOIN series s ON s.series_id = i.series_id
+ORDER BY i.sku
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ItemRecord, 0)
+	for rows.Next() {
+		var it ItemRecord
+		var sm, sellable, final int
+		var packQty, reorderPoint sql.NullFloat64
+		if err := rows.Scan(
+			&it.ID,
+			&it.SeriesName,
+			&it.SKU,
+			&it.Name,
+			&it.ItemType,
+			&it.ManagedUnit,
+			&sm,
+			&sellable,
+			&final,
+			&packQty,
+			&reorderPoint,
+			&it.Note,
+		); err != nil {
+			return nil, err
+		}
+		it.StockManaged = sm != 0
+		it.IsSellable = sellable != 0
+		it.IsFinal = final != 0
+		if packQty.Valid {
+			v := packQty.Float64
+			it.PackQty = &v
+		}
+		if reorderPoint.Valid {
+			v := reorderPoint.Float64
+			it.ReorderPoint = &v
+		}
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// UpsertItemRecord creates the item or updates the one with the same SKU.
+// New items get their component/assembly detail row with defaults; the item
+// type of an existing item is never changed.
+func (s *Store) UpsertItemRecord(ctx context.Context, tx *sql.Tx, it ItemRecord) (id int64, created bool, err error) {
+	it.SKU = strings.TrimSpace(it.SKU)
+	it.Name = strings.TrimSpace(it.Name)
+	if it.SKU == "" || it.Name == "" {
+		return 0, false, fmt.Errorf("sku and name required")
+	}
+	if it.ItemType != "component" && it.ItemType != "assembly" {
+		return 0, false, fmt.Errorf("item_type must be component or assembly")
+	}
+	if it.ManagedUnit == "" {
+		it.ManagedUnit = "pcs"
+	}
+	if it.ManagedUnit != "g" && it.ManagedUnit != "pcs" {
+		return 0, false, fmt.Errorf("managed_unit must be g or pcs")
+	}
+	if it.PackQty != nil && *it.PackQty <= 0 {
+		return 0, false, fmt.Errorf("pack_qty must be > 0")
+	}
+
+	seriesID, err := ensureSeries(ctx, tx, it.SeriesName)
+	if err != nil {
+		return 0, false, err
+	}
+	var packQty any = nil
+	if it.PackQty != nil {
+		packQty = *it.PackQty
+	}
+	var reorderPoint any = nil
+	if it.ReorderPoint != nil && *it.ReorderPoint > 0 {
+		reorderPoint = *it.ReorderPoint
+	}
+
+	var existingType string
+	err = tx.QueryRowContext(ctx, `SELECT item_id, item_type FROM items WHERE sku = ?`, it.SKU).Scan(&id, &existingType)
+	switch {
+	case err == nil:
+		if existingType != it.ItemType {
+			return 0, false, fmt.Errorf("sku %s is a %s, not a %s", it.SKU, existingType, it.ItemType)
+		}
+		_, err = tx.ExecContext(ctx, `
+UPDATE items
+SET series_id = ?, name = ?, managed_unit = ?, stock_managed = ?, is_sellable = ?, is_final = ?,
+    pack_qty = ?, reorder_point = ?, note = ?
+WHERE item_id = ?
+`, seriesID, it.Name, it.ManagedUnit, boolInt(it.StockManaged), boolInt(it.IsSellable), boolInt(it.IsFinal),
+			packQty, reorderPoint, it.Note, id)
+		return id, false, err
+	case err != sql.ErrNoRows:
+		return 0, false, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO items(series_id, sku, name, item_type, stock_managed, is_sellable, is_final, pack_qty, reorder_point, managed_unit, note)
+VALUES(?,?,?,?,?,?,?,?,?,?,?)
+`, seriesID, it.SKU, it.Name, it.ItemType, boolInt(it.StockManaged), boolInt(it.IsSellable), boolInt(it.IsFinal),
+		packQty, reorderPoint, it.ManagedUnit, it.Note)
+	if err != nil {
+		return 0, false, err
+	}
+	id, _ = res.LastInsertId()
+	if it.ItemType == "assembly" {
+		_, err = tx.ExecContext(ctx, `INSERT INTO assemblies(item_id, manufacturer, pack_size, note) VALUES(?, '', '', '')`, id)
+	} else {
+		_, err = tx.ExecContext(ctx, `INSERT INTO components(item_id, manufacturer, component_type, color) VALUES(?, '', 'material', '')`, id)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// ensureSeries returns the id of the named series, creating it when missing.
+// An empty name means no series.
+func ensureSeries(ctx context.Context, tx *sql.Tx, name string) (any, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, nil
+	}
+	var id int64
+	err := tx.QueryRowContext(ctx, `SELECT series_id FROM series WHERE name = ?`, name).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO series(name) VALUES(?)`, name)
+	if err != nil {
+		return nil, err
+	}
+	id, _ = res.LastInsertId()
+	return id, nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
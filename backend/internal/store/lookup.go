@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+const itemStockQuery = `
+SELECT COALESCE(SUM(
+  CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END
+), 0)
+FROM stock_transactions
+WHERE item_id = ?
+`
+
+const itemTypeQuery = `SELECT item_type FROM items WHERE item_id = ?`
+
+// ItemStock sums the ledger for one item. tx may be nil.
+func (s *Store) ItemStock(ctx context.Context, tx *sql.Tx, itemID int64) (float64, error) {
+	stmt, err := s.prepared(ctx, tx, itemStockQuery)
+	if err != nil {
+		return 0, err
+	}
+	var qty float64
+	err = s.retryRead(ctx, tx, func() error {
+		return stmt.QueryRowContext(ctx, itemID).Scan(&qty)
+	})
+	return qty, err
+}
+
+// ItemType returns the item_type of an item, or sql.ErrNoRows. tx may be nil.
+func (s *Store) ItemType(ctx context.Context, tx *sql.Tx, itemID int64) (string, error) {
+	stmt, err := s.prepared(ctx, tx, itemTypeQuery)
+	if err != nil {
+		return "", err
+	}
+	var itemType string
+	err = s.retryRead(ctx, tx, func() error {
+		return stmt.QueryRowContext(ctx, itemID).Scan(&itemType)
+	})
+	return itemType, err
+}
+
+// retryRead retries a standalone read on SQLITE_BUSY; inside a transaction
+// the caller has to restart the transaction instead.
+func (s *Store) retryRead(ctx context.Context, tx *sql.Tx, fn func() error) error {
+	if tx != nil {
+		return fn()
+	}
+	return retryBusy(ctx, fn)
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Low-stock severities, from least to most urgent.
+const (
+	SeverityLow = "low"
+	SeverityOut = "out"
+)
+
+type LowStockItem struct {
+	ItemID       int64
+	SKU          string
+	Name         string
+	ManagedUnit  string
+	StockQty     float64
+	ReorderPoint float64
+	// Severity is out with nothing left, low otherwise.
+	Severity string
+	// Snoozed is true while a snooze hides the item; SnoozedUntil is empty
+	// for an acknowledgement without an end.
+	Snoozed      bool
+	SnoozedUntil string
+	SnoozeNote   string
+}
+
+// lowStockQuery lists stock-managed items at or below their reorder point
+// with their severity and whether a snooze covers them. A snooze lapses at
+// snoozed_until and when the item gets worse than the severity it was
+// snoozed at.
+const lowStockQuery = `
+SELECT item_id, sku, name, managed_unit, reorder_point, stock_qty, severity,
+  snooze_severity IS NOT NULL
+    AND (snoozed_until IS NULL OR snoozed_until > strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+    AND (snooze_severity = 'out' OR severity = 'low') AS snoozed,
+  COALESCE(snoozed_until, ''), COALESCE(snooze_note, '')
+FROM (
+  SELECT
+    i.item_id, i.sku, i.name, i.managed_unit, i.reorder_point, q.stock_qty,
+    CASE WHEN q.stock_qty <= 0 THEN 'out' ELSE 'low' END AS severity,
+    sn.severity AS snooze_severity, sn.snoozed_until, sn.note AS snooze_note
+  FROM items i
+  JOIN (
+    SELECT i2.item_id,
+      COALESCE(SUM(CASE WHEN st.transaction_type = 'OUT' THEN -st.qty ELSE st.qty END), 0) AS stock_qty
+    FROM items i2
+    LEFT JOIN stock_transactions st ON st.item_id = i2.item_id
+    GROUP BY i2.item_id
+  ) q ON q.item_id = i.item_id
+  LEFT JOIN low_stock_snoozes sn ON sn.item_id = i.item_id
+  WHERE i.stock_managed = 1 AND i.lifecycle_status != 'obsolete' AND i.reorder_point IS NOT NULL
+    AND q.stock_qty <= i.reorder_point
+)
+`
+
+// ListLowStock returns stock-managed items at or below their reorder point,
+// out of stock first, then lowest cover. Obsolete items are left out since
+// they are not bought again, and snoozed items unless includeSnoozed.
+func (s *Store) ListLowStock(ctx context.Context, includeSnoozed bool) ([]LowStockItem, error) {
+	query := lowStockQuery
+	if !includeSnoozed {
+		query += ` WHERE NOT snoozed`
+	}
+	query += ` ORDER BY severity = 'low', CASE WHEN reorder_point > 0 THEN stock_qty / reorder_point ELSE 0 END, sku`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]LowStockItem, 0)
+	for rows.Next() {
+		var it LowStockItem
+		if err := rows.Scan(&it.ItemID, &it.SKU, &it.Name, &it.ManagedUnit, &it.ReorderPoint, &it.StockQty,
+			&it.Severity, &it.Snoozed, &it.SnoozedUntil, &it.SnoozeNote); err != nil {
+			return nil, err
+		}
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// LowStockSeverity returns the severity of an item that is low on stock;
+// ok is false when it is not (or not stock-managed, or obsolete).
+func (s *Store) LowStockSeverity(ctx context.Context, itemID int64) (severity string, ok bool, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT severity FROM (`+lowStockQuery+`) WHERE item_id = ?`, itemID).Scan(&severity)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return severity, err == nil, err
+}
+
+// PruneLowStockSnoozes drops snoozes that have run out and those of items
+// no longer low on stock, so the next shortage alerts again.
+func (s *Store) PruneLowStockSnoozes(ctx context.Context) (int64, error) {
+	res, err := Exec(ctx, s.db, `
+DELETE FROM low_stock_snoozes
+WHERE (snoozed_until IS NOT NULL AND snoozed_until <= strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+   OR item_id NOT IN (SELECT item_id FROM (`+lowStockQuery+`))
+`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
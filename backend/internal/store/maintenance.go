@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+type VacuumMode string
+
+const (
+	VacuumNone        VacuumMode = "none"
+	VacuumIncremental VacuumMode = "incremental"
+	// VacuumFull rewrites the whole file and switches auto_vacuum to
+	// incremental, so later incremental runs can release free pages.
+	VacuumFull VacuumMode = "full"
+)
+
+type DBSize struct {
+	PageSize      int64 `json:"page_size"`
+	PageCount     int64 `json:"page_count"`
+	FreelistCount int64 `json:"freelist_count"`
+	FileBytes     int64 `json:"file_bytes"`
+	WALBytes      int64 `json:"wal_bytes"`
+}
+
+type MaintenanceReport struct {
+	Vacuum VacuumMode `json:"vacuum"`
+	// VacuumSkipped is set when an incremental vacuum was asked for but the
+	// database is not in incremental auto_vacuum mode yet.
+	VacuumSkipped bool `json:"vacuum_skipped"`
+	// Checkpoint reports PRAGMA wal_checkpoint: busy flag, WAL frames and
+	// frames checkpointed.
+	CheckpointBusy   bool   `json:"checkpoint_busy"`
+	CheckpointLog    int64  `json:"checkpoint_log"`
+	CheckpointFrames int64  `json:"checkpoint_frames"`
+	Before           DBSize `json:"before"`
+	After            DBSize `json:"after"`
+}
+
+// Maintain truncates the WAL and vacuums the database, measuring its size
+// before and after.
+func (s *Store) Maintain(ctx context.Context, mode VacuumMode) (MaintenanceReport, error) {
+	rep := MaintenanceReport{Vacuum: mode}
+	var err error
+	if rep.Before, err = s.size(ctx); err != nil {
+		return rep, err
+	}
+
+	switch mode {
+	case VacuumNone:
+	case VacuumIncremental:
+		var autoVacuum int
+		if err := s.db.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&autoVacuum); err != nil {
+			return rep, err
+		}
+		// 2 = INCREMENTAL
+		if autoVacuum != 2 {
+			rep.VacuumSkipped = true
+			break
+		}
+		if _, err := s.db.ExecContext(ctx, `PRAGMA incremental_vacuum`); err != nil {
+			return rep, fmt.Errorf("incremental vacuum: %w", err)
+		}
+	case VacuumFull:
+		if _, err := s.db.ExecContext(ctx, `PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+			return rep, err
+		}
+		if _, err := s.db.ExecContext(ctx, `VACUUM`); err != nil {
+			return rep, fmt.Errorf("vacuum: %w", err)
+		}
+	default:
+		return rep, fmt.Errorf("unknown vacuum mode %q", mode)
+	}
+
+	// Checkpoint last so pages freed by the vacuum leave the WAL too.
+	var busy int
+	if err := s.db.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &rep.CheckpointLog, &rep.CheckpointFrames); err != nil {
+		return rep, fmt.Errorf("wal checkpoint: %w", err)
+	}
+	rep.CheckpointBusy = busy != 0
+
+	rep.After, err = s.size(ctx)
+	return rep, err
+}
+
+func (s *Store) size(ctx context.Context) (DBSize, error) {
+	var sz DBSize
+	for _, p := range []struct {
+		pragma string
+		dst    *int64
+	}{
+		{`PRAGMA page_size`, &sz.PageSize},
+		{`PRAGMA page_count`, &sz.PageCount},
+		{`PRAGMA freelist_count`, &sz.FreelistCount},
+	} {
+		if err := s.db.QueryRowContext(ctx, p.pragma).Scan(p.dst); err != nil {
+			return sz, err
+		}
+	}
+
+	var seq int
+	var name, file string
+	if err := s.db.QueryRowContext(ctx, `PRAGMA database_list`).Scan(&seq, &name, &file); err != nil {
+		return sz, err
+	}
+	if file == "" {
+		// In-memory database.
+		return sz, nil
+	}
+	var err error
+	if sz.FileBytes, err = fileSize(file); err != nil {
+		return sz, err
+	}
+	sz.WALBytes, err = fileSize(file + "-wal")
+	return sz, err
+}
+
+func fileSize(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"stockmate/internal/timeutil"
+)
+
+// OutboxMessage is a notification recorded with the change it announces,
+// waiting to be delivered.
+type OutboxMessage struct {
+	ID        int64
+	Topic     string
+	Payload   []byte
+	Attempts  int
+	CreatedAt string
+}
+
+type outboxKey struct{}
+
+// pendingOutbox is the message a request records with its first write.
+type pendingOutbox struct {
+	topic   string
+	payload []byte
+	written bool
+}
+
+// WithOutbox has the first Commit or Exec on ctx record a message in the
+// outbox, in the transaction of the write itself. A request that writes
+// nothing, or whose write rolls back, records nothing.
+func WithOutbox(ctx context.Context, topic string, payload []byte) context.Context {
+	return context.WithValue(ctx, outboxKey{}, &pendingOutbox{topic: topic, payload: payload})
+}
+
+// outboxFrom returns the message ctx still has to record, if any.
+func outboxFrom(ctx context.Context) *pendingOutbox {
+	p, _ := ctx.Value(outboxKey{}).(*pendingOutbox)
+	if p == nil || p.written {
+		return nil
+	}
+	return p
+}
+
+// Enqueue records a message in the outbox on tx.
+func Enqueue(ctx context.Context, tx *sql.Tx, topic string, payload []byte) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO outbox(topic, payload) VALUES(?, ?)`, topic, string(payload))
+	return err
+}
+
+// DueOutbox lists up to limit pending messages whose next attempt is due by
+// now, oldest first.
+func (s *Store) DueOutbox(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT outbox_id, topic, payload, attempts, created_at FROM outbox
+WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?
+ORDER BY outbox_id
+LIMIT ?
+`, timeutil.Format(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]OutboxMessage, 0)
+	for rows.Next() {
+		var m OutboxMessage
+		var payload string
+		if err := rows.Scan(&m.ID, &m.Topic, &payload, &m.Attempts, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.Payload = []byte(payload)
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// OutboxDelivered marks a message as delivered.
+func (s *Store) OutboxDelivered(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE outbox SET attempts = attempts + 1, delivered_at = `+timeutil.SQLNow+`, last_error = NULL
+WHERE outbox_id = ?
+`, id)
+	return err
+}
+
+// OutboxFailed records a failed delivery. The message is tried again at
+// next, or with giveUp never again.
+func (s *Store) OutboxFailed(ctx context.Context, id int64, deliveryErr string, next time.Time, giveUp bool) error {
+	var failedAt any
+	if giveUp {
+		failedAt = timeutil.Format(time.Now())
+	}
+	_, err := s.db.ExecContext(ctx, `
+UPDATE outbox SET attempts = attempts + 1, last_error = ?, next_attempt_at = ?, failed_at = ?
+WHERE outbox_id = ?
+`, deliveryErr, timeutil.Format(next), failedAt, id)
+	return err
+}
+
+// PruneOutbox deletes messages delivered before before. Failed ones are
+// kept for inspection.
+func (s *Store) PruneOutbox(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM outbox WHERE delivered_at < ?`, timeutil.Format(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
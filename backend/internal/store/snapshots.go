@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"stockmate/internal/timeutil"
+)
+
+// TakeStockSnapshot records the on-hand quantity and value of every
+// stock-managed item at the end of the given day (YYYY-MM-DD) in loc. Running
+// it again for the same day overwrites that day's rows. Values are converted
+// to the base currency at the current rate; the value stays NULL when the
+// cost's currency has no rate.
+func (s *Store) TakeStockSnapshot(ctx context.Context, day string, loc *time.Location) (int64, error) {
+	start, err := timeutil.StartOfDay(day, loc)
+	if err != nil {
+		return 0, fmt.Errorf("invalid snapshot date: %q", day)
+	}
+	var n int64
+	err = retryBusy(ctx, func() error {
+		res, err := Exec(ctx, s.db, `
+INSERT OR REPLACE INTO stock_snapshots(snapshot_date, item_id, qty, unit_cost, value, currency)
+SELECT
+  ?, i.item_id, t.qty, i.unit_cost,
+  t.qty * i.unit_cost * CASE
+    WHEN i.unit_cost_currency IS NULL THEN 1
+    ELSE (SELECT rate FROM currencies WHERE code = i.unit_cost_currency)
+  END,
+  COALESCE((SELECT value FROM app_settings WHERE key = 'base_currency'), 'JPY')
+FROM items i
+JOIN (
+  SELECT item_id, COALESCE(SUM(
+    CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END
+  ), 0) AS qty
+  FROM stock_transactions
+  WHERE created_at < ?
+  GROUP BY item_id
+) t ON t.item_id = i.item_id
+WHERE i.stock_managed = 1
+`, day, timeutil.Format(start.AddDate(0, 0, 1)))
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return n, err
+}
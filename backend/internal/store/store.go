@@ -0,0 +1,104 @@
+// Package store holds database access shared between the HTTP server and the
+// command line tool.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+type Store struct {
+	db *sql.DB
+
+	// stmts caches prepared statements for hot queries, keyed by SQL text.
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+func New(db *sql.DB) *Store {
+	return &Store{db: db, stmts: map[string]*sql.Stmt{}}
+}
+
+// Close releases the cached statements; the *sql.DB stays open.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var first error
+	for q, stmt := range s.stmts {
+		if err := stmt.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(s.stmts, q)
+	}
+	return first
+}
+
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// Backup writes a consistent copy of the database to path using VACUUM INTO.
+// The target must not exist yet.
+func (s *Store) Backup(ctx context.Context, path string) error {
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return nil
+}
+
+// hotQueries are prepared up front by Prepare.
+var hotQueries = []string{itemStockQuery, itemTypeQuery}
+
+// Prepare caches the statements of the hot paths. Call it once the schema
+// is migrated; statements not prepared here are prepared on first use.
+func (s *Store) Prepare(ctx context.Context) error {
+	for _, q := range hotQueries {
+		if _, err := s.prepared(ctx, nil, q); err != nil {
+			return fmt.Errorf("prepare: %w", err)
+		}
+	}
+	return nil
+}
+
+// prepared returns the cached statement for query, preparing it on first
+// use. Inside a transaction the statement is bound to tx.
+func (s *Store) prepared(ctx context.Context, tx *sql.Tx, query string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	stmt, ok := s.stmts[query]
+	s.mu.Unlock()
+	if ok {
+		if tx != nil {
+			return tx.StmtContext(ctx, stmt), nil
+		}
+		return stmt, nil
+	}
+	// With a single connection the transaction holds it, so a miss there is
+	// prepared on tx and not cached.
+	if tx != nil {
+		return tx.PrepareContext(ctx, query)
+	}
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.stmts[query]; ok {
+		stmt.Close()
+		return cached, nil
+	}
+	s.stmts[query] = stmt
+	return stmt, nil
+}
+
+func tableExists(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, name string) (bool, error) {
+	var n int
+	if err := q.QueryRowContext(ctx, `SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
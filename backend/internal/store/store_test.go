@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"stockmate/internal/db"
+)
+
+func TestCheckQueryPlans(t *testing.T) {
+	conn, err := db.Open(db.MemoryDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	st := New(conn)
+	ctx := context.Background()
+
+	plans, err := st.CheckQueryPlans(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range plans {
+		if !p.OK() {
+			t.Errorf("%s scans: %v", p.Name, p.Scans)
+		}
+	}
+
+	// Without the item index the stock listing walks every item.
+	if _, err := conn.Exec(`DROP INDEX idx_items_type_managed`); err != nil {
+		t.Fatal(err)
+	}
+	plans, err = st.CheckQueryPlans(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := slices.IndexFunc(plans, func(p PlanReport) bool { return p.Name == "stock listing" })
+	if i < 0 || plans[i].OK() {
+		t.Fatalf("stock listing without index = %+v", plans)
+	}
+}
+
+// benchStore opens a migrated database with one item and a few hundred
+// ledger rows, using the same single-connection setup as the server.
+func benchStore(b *testing.B) (*Store, int64) {
+	b.Helper()
+	conn, err := db.Open("sqlite:" + filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	if err := db.Migrate(conn); err != nil {
+		b.Fatal(err)
+	}
+	res, err := conn.Exec(`INSERT INTO items(sku, name, item_type, managed_unit) VALUES ('BENCH-1', 'bench', 'component', 'pcs')`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	itemID, _ := res.LastInsertId()
+	for i := 0; i < 500; i++ {
+		dir := "IN"
+		if i%3 == 0 {
+			dir = "OUT"
+		}
+		if _, err := conn.Exec(`INSERT INTO stock_transactions(item_id, transaction_type, qty) VALUES (?, ?, 1)`, itemID, dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+	st := New(conn)
+	b.Cleanup(func() { st.Close() })
+	if err := st.Prepare(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	return st, itemID
+}
+
+func BenchmarkItemStockAdHoc(b *testing.B) {
+	st, itemID := benchStore(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var qty float64
+		if err := st.db.QueryRowContext(ctx, itemStockQuery, itemID).Scan(&qty); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkItemStockPrepared(b *testing.B) {
+	st, itemID := benchStore(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := st.ItemStock(ctx, nil, itemID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkItemTypeAdHoc(b *testing.B) {
+	st, itemID := benchStore(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var itemType string
+		if err := st.db.QueryRowContext(ctx, itemTypeQuery, itemID).Scan(&itemType); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkItemTypePrepared(b *testing.B) {
+	st, itemID := benchStore(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := st.ItemType(ctx, nil, itemID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkItemStockPreparedInTx(b *testing.B) {
+	st, itemID := benchStore(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx, err := st.db.BeginTx(ctx, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := st.ItemStock(ctx, tx, itemID); err != nil {
+			b.Fatal(err)
+		}
+		tx.Rollback()
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	conn, err := db.Open(db.MemoryDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const user = `INSERT INTO users(issuer, subject, role) VALUES ('idp', 'u1', 'admin')`
+	if _, err := conn.Exec(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(user); !IsUniqueViolation(err) || IsBusy(err) {
+		t.Errorf("duplicate user: %v", err)
+	}
+	if _, err := conn.Exec(`INSERT INTO users(issuer, subject, role) VALUES ('idp', 'u2', 'owner')`); IsUniqueViolation(err) {
+		t.Errorf("check constraint taken for a unique one: %v", err)
+	}
+}
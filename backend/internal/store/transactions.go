@@ -0,0 +1,242 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"stockmate/internal/timeutil"
+)
+
+// TransactionRecord is the portable view of a ledger row; items are referred
+// to by SKU.
+type TransactionRecord struct {
+	ID              int64
+	CreatedAt       string
+	SKU             string
+	TransactionType string
+	Qty             float64
+	ReasonCode      string
+	Note            string
+}
+
+func (s *Store) ListTransactionRecords(ctx context.Context) ([]TransactionRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT
+  st.transaction_id,
+  st.created_at,
+  i.sku,
+  st.transaction_type,
+  st.qty,
+  COALESCE(st.reason_code, ''),
+  COALESCE(st.note, '')
+FROM stock_transactions st
+JOIN items i ON i.item_id = st.item_id
+ORDER BY st.transaction_id
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]TransactionRecord, 0)
+	for rows.Next() {
+		var t TransactionRecord
+		if err := rows.Scan(&t.ID, &t.CreatedAt, &t.SKU, &t.TransactionType, &t.Qty, &t.ReasonCode, &t.Note); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// AddTransactionRecord appends a ledger row. CreatedAt is kept when given so
+// imported history keeps its dates; values without a zone are taken as UTC.
+func (s *Store) AddTransactionRecord(ctx context.Context, tx *sql.Tx, t TransactionRecord) (int64, error) {
+	t.TransactionType = strings.ToUpper(strings.TrimSpace(t.TransactionType))
+	if t.TransactionType != "IN" && t.TransactionType != "OUT" && t.TransactionType != "ADJUST" {
+		return 0, fmt.Errorf("transaction_type must be IN, OUT or ADJUST")
+	}
+	// ADJUST rows carry a signed delta; IN and OUT are always positive.
+	if t.TransactionType == "ADJUST" {
+		if t.Qty == 0 {
+			return 0, fmt.Errorf("qty must not be 0")
+		}
+	} else if t.Qty <= 0 {
+		return 0, fmt.Errorf("qty must be > 0")
+	}
+
+	var itemID int64
+	if err := tx.QueryRowContext(ctx, `SELECT item_id FROM items WHERE sku = ?`, strings.TrimSpace(t.SKU)).Scan(&itemID); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("unknown sku: %s", t.SKU)
+		}
+		return 0, err
+	}
+	var reason any = nil
+	if code := strings.ToLower(strings.TrimSpace(t.ReasonCode)); code != "" {
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(1) FROM reason_codes WHERE code = ?`, code).Scan(&n); err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, fmt.Errorf("unknown reason_code: %s", code)
+		}
+		reason = code
+	}
+	var createdAt any = nil
+	if v := strings.TrimSpace(t.CreatedAt); v != "" {
+		ts, err := timeutil.Normalize(v)
+		if err != nil {
+			return 0, fmt.Errorf("created_at: %w", err)
+		}
+		createdAt = ts
+	}
+
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO stock_transactions(item_id, qty, transaction_type, note, reason_code, created_at)
+VALUES(?,?,?,?,?,COALESCE(?, strftime('%Y-%m-%dT%H:%M:%SZ', 'now')))
+`, itemID, t.Qty, t.TransactionType, t.Note, reason, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// LedgerStock returns the on-hand quantity of every item with ledger rows,
+// computed from stock_transactions.
+func (s *Store) LedgerStock(ctx context.Context) (map[int64]float64, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT item_id, COALESCE(SUM(
+  CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END
+), 0)
+FROM stock_transactions
+GROUP BY item_id
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]float64)
+	for rows.Next() {
+		var itemID int64
+		var qty float64
+		if err := rows.Scan(&itemID, &qty); err != nil {
+			return nil, err
+		}
+		out[itemID] = qty
+	}
+	return out, rows.Err()
+}
+
+// StockIssue is a stock-managed item whose ledger stock breaks a rule the
+// server books movements by. Qty is its on-hand quantity and Binned what its
+// bins other than the default hold.
+type StockIssue struct {
+	ItemID  int64
+	SKU     string
+	Qty     float64
+	Binned  float64
+	Problem string
+}
+
+// CheckStock recomputes the on-hand quantity of every stock-managed item
+// from the ledger and returns how many there are and the ones that are
+// below zero although their negative stock policy blocks that, or hold
+// less than their bins. Rows written around the server, such as imported
+// history, can leave either behind.
+func (s *Store) CheckStock(ctx context.Context) (int, []StockIssue, error) {
+	var n int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM items WHERE stock_managed = 1`).Scan(&n); err != nil {
+		return 0, nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `
+WITH stock AS (
+  SELECT item_id, SUM(CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END) AS qty
+  FROM stock_transactions
+  GROUP BY item_id
+), binned AS (
+  SELECT item_id, SUM(qty) AS qty FROM item_bins WHERE is_default = 0 GROUP BY item_id
+)
+SELECT
+  i.item_id,
+  i.sku,
+  COALESCE(st.qty, 0),
+  COALESCE(b.qty, 0),
+  COALESCE(i.negative_stock_policy, (SELECT value FROM app_settings WHERE key = 'negative_stock_policy'), 'block')
+FROM items i
+LEFT JOIN stock st ON st.item_id = i.item_id
+LEFT JOIN binned b ON b.item_id = i.item_id
+WHERE i.stock_managed = 1
+  AND (COALESCE(st.qty, 0) < -1e-9 OR COALESCE(b.qty, 0) > COALESCE(st.qty, 0) + 1e-9)
+ORDER BY i.sku
+`)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	out := make([]StockIssue, 0)
+	for rows.Next() {
+		var is StockIssue
+		var policy string
+		if err := rows.Scan(&is.ItemID, &is.SKU, &is.Qty, &is.Binned, &policy); err != nil {
+			return 0, nil, err
+		}
+		var problems []string
+		if is.Qty < -1e-9 && policy == "block" {
+			problems = append(problems, fmt.Sprintf("%g on hand, below zero under the block policy", is.Qty))
+		}
+		if is.Binned > is.Qty+1e-9 {
+			problems = append(problems, fmt.Sprintf("bins hold %g but only %g is on hand", is.Binned, is.Qty))
+		}
+		if len(problems) == 0 {
+			continue
+		}
+		is.Problem = strings.Join(problems, "; ")
+		out = append(out, is)
+	}
+	return n, out, rows.Err()
+}
+
+// RebuildBalances recomputes the stock_balances cache from the ledger. It
+// reports false when the database has no balance table, in which case stock
+// is always read straight from the ledger and there is nothing to rebuild.
+func (s *Store) RebuildBalances(ctx context.Context) (rebuilt bool, n int64, err error) {
+	err = retryBusy(ctx, func() error {
+		rebuilt, n, err = s.rebuildBalances(ctx)
+		return err
+	})
+	return rebuilt, n, err
+}
+
+func (s *Store) rebuildBalances(ctx context.Context) (bool, int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	ok, err := tableExists(ctx, tx, "stock_balances")
+	if err != nil || !ok {
+		return false, 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM stock_balances`); err != nil {
+		return true, 0, fmt.Errorf("clear stock_balances: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO stock_balances(item_id, qty)
+SELECT item_id, COALESCE(SUM(
+  CASE WHEN transaction_type = 'OUT' THEN -qty ELSE qty END
+), 0)
+FROM stock_transactions
+GROUP BY item_id
+`)
+	if err != nil {
+		return true, 0, fmt.Errorf("rebuild stock_balances: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return true, n, Commit(ctx, tx)
+}
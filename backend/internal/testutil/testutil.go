@@ -0,0 +1,146 @@
+// Package testutil runs HTTP handlers against a throwaway in-memory database
+// and compares their responses with golden files.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"stockmate/internal/db"
+	"stockmate/internal/middleware"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current responses")
+
+// OpenDB returns a migrated in-memory database that is closed when the test
+// ends.
+func OpenDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+	conn, err := db.Open(db.MemoryDSN)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// SeededDB is OpenDB filled with the demo catalogue (see db.SeedDemo), whose
+// rows get the same ids on every run.
+func SeededDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+	conn := OpenDB(tb)
+	if _, err := db.SeedDemo(context.Background(), conn); err != nil {
+		tb.Fatal(err)
+	}
+	return conn
+}
+
+// Do serves one request on h. A string or []byte body is sent as is, any
+// other non-nil body as JSON.
+func Do(tb testing.TB, h http.Handler, method, target string, body any) *httptest.ResponseRecorder {
+	tb.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, target, r)
+	// A fixed ID keeps the IDs echoed in error bodies stable.
+	req.Header.Set(middleware.RequestIDHeader, "test")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// Golden compares got with testdata/golden/<name>.golden. JSON is compared
+// indented and with generated timestamps (string values of *_at keys and
+// their ?compact=1 forms) masked, so the files stay stable across runs. go test -update writes them, missing ones
+// included; review the diff before committing. Without -update a missing
+// file fails the test.
+func Golden(tb testing.TB, name string, got []byte) {
+	tb.Helper()
+	got = normalize(got)
+	path := filepath.Join("testdata", "golden", name+".golden")
+	want, err := os.ReadFile(path)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			tb.Fatal(err)
+		}
+		tb.Logf("wrote %s", path)
+		return
+	}
+	if os.IsNotExist(err) {
+		tb.Fatalf("%s is missing (run go test -update to create it)", path)
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		tb.Errorf("response differs from %s (run go test -update to accept)\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+}
+
+const maskedTime = "<time>"
+
+// compactTimeKeys are the ?compact=1 names of created_at and updated_at.
+var compactTimeKeys = map[string]bool{"ca": true, "ua": true}
+
+func normalize(body []byte) []byte {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return append(bytes.TrimRight(body, "\n"), '\n')
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(mask(v)); err != nil {
+		return body
+	}
+	return out.Bytes()
+}
+
+func mask(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, e := range t {
+			if s, ok := e.(string); ok && s != "" && (strings.HasSuffix(k, "_at") || compactTimeKeys[k]) {
+				t[k] = maskedTime
+				continue
+			}
+			t[k] = mask(e)
+		}
+	case []any:
+		for i, e := range t {
+			t[i] = mask(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,109 @@
+// Package timeutil handles the timestamps kept in the database.
+//
+// Timestamps are stored as RFC3339 text in UTC with second precision, e.g.
+// "2024-05-01T09:30:00Z", so they sort and compare correctly as strings.
+// Databases written before this format stored SQLite's datetime('now')
+// ("2024-05-01 09:30:00", also UTC); Parse accepts both.
+package timeutil
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Layout is the stored form of a timestamp.
+const Layout = "2006-01-02T15:04:05Z"
+
+// SQLNow is the SQLite expression for the current time in Layout.
+const SQLNow = "strftime('%Y-%m-%dT%H:%M:%SZ', 'now')"
+
+// Format returns t in the stored form.
+func Format(t time.Time) string {
+	return t.UTC().Format(Layout)
+}
+
+// Parse reads a timestamp. Values without a zone are taken as UTC; a bare
+// date is midnight UTC.
+func Parse(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC(), nil
+	}
+	for _, layout := range []string{time.DateTime, "2006-01-02T15:04:05", "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp: %q", s)
+}
+
+// Normalize rewrites s in the stored form.
+func Normalize(s string) (string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return Format(t), nil
+}
+
+// Time is a timestamp column. It scans the stored text and marshals to JSON
+// as an RFC3339 UTC string, or null when zero.
+type Time struct {
+	time.Time
+}
+
+func (t *Time) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v.UTC()
+		return nil
+	case string:
+		p, err := Parse(v)
+		t.Time = p
+		return err
+	case []byte:
+		p, err := Parse(string(v))
+		t.Time = p
+		return err
+	}
+	return fmt.Errorf("cannot scan %T into timeutil.Time", src)
+}
+
+func (t Time) Value() (driver.Value, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	return Format(t.Time), nil
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(Format(t.Time))
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	var s *string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == nil || *s == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+	p, err := Parse(*s)
+	t.Time = p
+	return err
+}
+
+// StartOfDay returns midnight of the day (YYYY-MM-DD) in loc.
+func StartOfDay(day string, loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(time.DateOnly, day, loc)
+}
@@ -0,0 +1,96 @@
+// Package validate collects field errors from request payloads so every
+// problem is reported at once instead of stopping at the first one.
+package validate
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors gathers field errors in the order they were found. The zero value
+// is ready to use.
+type Errors struct {
+	list []FieldError
+}
+
+func (e *Errors) Add(field, message string) {
+	e.list = append(e.list, FieldError{Field: field, Message: message})
+}
+
+// Check adds the error when ok is false.
+func (e *Errors) Check(ok bool, field, message string) {
+	if !ok {
+		e.Add(field, message)
+	}
+}
+
+// Required adds "is required" when value is blank.
+func (e *Errors) Required(field, value string) {
+	e.Check(strings.TrimSpace(value) != "", field, "is required")
+}
+
+// OneOf adds an error when value is not one of allowed.
+func (e *Errors) OneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	e.Add(field, "must be "+joinAlternatives(allowed))
+}
+
+// Has reports whether field already has an error, so dependent checks can
+// be skipped.
+func (e *Errors) Has(field string) bool {
+	for _, fe := range e.list {
+		if fe.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Errors) Empty() bool {
+	return len(e.list) == 0
+}
+
+func (e *Errors) List() []FieldError {
+	return e.list
+}
+
+// Error joins the errors as "field: message; ...".
+func (e *Errors) Error() string {
+	parts := make([]string, len(e.list))
+	for i, fe := range e.list {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Write responds 400 with {"errors":[{"field","message"}]}, plus
+// "request_id" when the request was tagged with an X-Request-ID.
+func (e *Errors) Write(w http.ResponseWriter) {
+	body := map[string]any{"errors": e.list}
+	if id := w.Header().Get("X-Request-ID"); id != "" {
+		body["request_id"] = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func joinAlternatives(values []string) string {
+	switch len(values) {
+	case 0:
+		return ""
+	case 1:
+		return values[0]
+	}
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+func (c *Client) CreateItem(ctx context.Context, req CreateItemRequest) (*Item, error) {
+	var out Item
+	if err := c.do(ctx, http.MethodPost, "/api/items", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) ListItems(ctx context.Context) ([]Item, error) {
+	var out []Item
+	if err := c.do(ctx, http.MethodGet, "/api/items", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdjustStock books a manual IN/OUT movement for an assembly and returns the
+// resulting stock level.
+func (c *Client) AdjustStock(ctx context.Context, itemID int64, req AdjustStockRequest) (*StockLevel, error) {
+	var out StockLevel
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/assemblies/%d/adjust", itemID), nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetBOM returns the component list of an item. revNo 0 means the current
+// revision.
+func (c *Client) GetBOM(ctx context.Context, itemID int64, revNo int64) (*BOM, error) {
+	q := url.Values{}
+	if revNo > 0 {
+		q.Set("rev_no", strconv.FormatInt(revNo, 10))
+	}
+	var out BOM
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/assemblies/%d/components", itemID), q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListLowStock returns stock-managed items at or below their reorder point.
+func (c *Client) ListLowStock(ctx context.Context) ([]StockSummaryRow, error) {
+	q := url.Values{"low": {"1"}, "limit": {"1000"}}
+	var out []StockSummaryRow
+	if err := c.do(ctx, http.MethodGet, "/api/stock/summary", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LookupItems resolves up to 500 SKUs in one call; SKUs without an item are
+// returned in LookupResult.NotFound.
+func (c *Client) LookupItems(ctx context.Context, skus []string) (*LookupResult, error) {
+	var out LookupResult
+	if err := c.do(ctx, http.MethodPost, "/api/items/lookup", nil, map[string]any{"skus": skus}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,162 @@
+// Package client is a typed Go client for the stockmate REST API.
+//
+//	c := client.New("http://localhost:8080")
+//	item, err := c.CreateItem(ctx, client.CreateItemRequest{SKU: "A-1", Name: "Widget", ItemType: "assembly"})
+//	if client.IsConflict(err) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+	header     http.Header
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces the default http.Client (30s timeout).
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithRetries sets how many times a failed request is retried and the base
+// delay, which doubles on every attempt. Zero retries disables retrying.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = n
+		c.backoff = backoff
+	}
+}
+
+// WithHeader adds a header to every request, e.g. for authentication.
+func WithHeader(key, value string) Option {
+	return func(c *Client) { c.header.Add(key, value) }
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		maxRetries: 3,
+		backoff:    200 * time.Millisecond,
+		header:     make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError is returned for any non-2xx response. The server answers errors
+// as plain text, which ends up in Message.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("stockmate: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func statusOf(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+func IsNotFound(err error) bool   { return statusOf(err) == http.StatusNotFound }
+func IsConflict(err error) bool   { return statusOf(err) == http.StatusConflict }
+func IsBadRequest(err error) bool { return statusOf(err) == http.StatusBadRequest }
+
+// do sends a request and decodes a JSON response into out (when non-nil).
+// GET/PUT/DELETE are retried on network errors and 429/502/503/504; POST is
+// only retried on 429 and 503, where the server has rejected it unprocessed.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for k, vs := range c.header {
+			req.Header[k] = vs
+		}
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := c.httpClient.Do(req)
+		var wait time.Duration
+		if err == nil {
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				defer resp.Body.Close()
+				if out == nil || resp.StatusCode == http.StatusNoContent {
+					return nil
+				}
+				return json.NewDecoder(resp.Body).Decode(out)
+			}
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			err = &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+			if !c.retryable(method, resp.StatusCode) {
+				return err
+			}
+			if s, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+				wait = time.Duration(s) * time.Second
+			}
+		} else if method == http.MethodPost || ctx.Err() != nil {
+			return err
+		}
+
+		if attempt >= c.maxRetries {
+			return err
+		}
+		if wait == 0 {
+			wait = c.backoff << attempt
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) retryable(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return method != http.MethodPost
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package client
+
+// The types below mirror the JSON returned by the server.
+
+type Item struct {
+	ID           int64    `json:"id"`
+	SeriesID     *int64   `json:"series_id,omitempty"`
+	SeriesName   string   `json:"series_name,omitempty"`
+	SKU          string   `json:"sku"`
+	Name         string   `json:"name"`
+	ItemType     string   `json:"item_type"`
+	PackQty      *float64 `json:"pack_qty,omitempty"`
+	ReorderPoint *float64 `json:"reorder_point,omitempty"`
+	ManagedUnit  string   `json:"managed_unit"`
+	StockManaged bool     `json:"stock_managed"`
+	IsSellable   bool     `json:"is_sellable"`
+	IsFinal      bool     `json:"is_final"`
+	Note         string   `json:"note,omitempty"`
+	CreatedAt    string   `json:"created_at,omitempty"`
+	UpdatedAt    string   `json:"updated_at,omitempty"`
+}
+
+type CreateItemRequest struct {
+	SeriesID     *int64   `json:"series_id,omitempty"`
+	SKU          string   `json:"sku"`
+	Name         string   `json:"name"`
+	ItemType     string   `json:"item_type"`
+	ManagedUnit  string   `json:"managed_unit,omitempty"`
+	PackQty      *float64 `json:"pack_qty,omitempty"`
+	ReorderPoint *float64 `json:"reorder_point,omitempty"`
+	StockManaged *bool    `json:"stock_managed,omitempty"`
+	IsSellable   bool     `json:"is_sellable,omitempty"`
+	IsFinal      bool     `json:"is_final,omitempty"`
+	Note         string   `json:"note,omitempty"`
+}
+
+type AdjustStockRequest struct {
+	// Direction is IN or OUT. OUT requires a ReasonCode.
+	Direction  string  `json:"direction"`
+	Qty        float64 `json:"qty"`
+	Note       string  `json:"note,omitempty"`
+	ReasonCode string  `json:"reason_code,omitempty"`
+}
+
+type StockLevel struct {
+	ItemID   int64   `json:"item_id"`
+	StockQty float64 `json:"stock_qty"`
+}
+
+type BOMComponent struct {
+	ComponentItemID int64   `json:"component_item_id"`
+	SKU             string  `json:"sku"`
+	Name            string  `json:"name"`
+	ItemType        string  `json:"item_type"`
+	ManagedUnit     string  `json:"managed_unit"`
+	QtyPerUnit      float64 `json:"qty_per_unit"`
+	Note            string  `json:"note,omitempty"`
+}
+
+type BOMRevision struct {
+	RecordID       int64  `json:"record_id"`
+	RevNo          int64  `json:"rev_no"`
+	CreatedAt      string `json:"created_at"`
+	ComponentCount int64  `json:"component_count"`
+}
+
+type BOM struct {
+	ParentItemID     int64          `json:"parent_item_id"`
+	CurrentRecordID  *int64         `json:"current_record_id,omitempty"`
+	CurrentRevNo     *int64         `json:"current_rev_no,omitempty"`
+	CurrentCreatedAt string         `json:"current_created_at,omitempty"`
+	Revisions        []BOMRevision  `json:"revisions"`
+	Components       []BOMComponent `json:"components"`
+}
+
+type StockSummaryRow struct {
+	ItemID        int64    `json:"item_id"`
+	SKU           string   `json:"sku"`
+	Name          string   `json:"name"`
+	ItemType      string   `json:"item_type"`
+	ComponentType string   `json:"component_type,omitempty"`
+	PurchaseURL   string   `json:"purchase_url,omitempty"`
+	ManagedUnit   string   `json:"managed_unit"`
+	StockManaged  bool     `json:"stock_managed"`
+	ReorderPoint  *float64 `json:"reorder_point,omitempty"`
+	StockQty      float64  `json:"stock_qty"`
+	UpdatedAt     string   `json:"updated_at,omitempty"`
+}
+
+type LookupItem struct {
+	Item
+	StockQty float64 `json:"stock_qty"`
+}
+
+type LookupResult struct {
+	Items    []LookupItem `json:"items"`
+	NotFound []string     `json:"not_found"`
+}
@@ -0,0 +1,26 @@
+// Package web embeds the built Vite frontend so the server can run as a
+// single executable. Copy frontend/dist into backend/web/dist before `go build`
+// (scripts/build_usb.sh and the Dockerfile do this); without it the binary
+// simply has no embedded frontend.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:dist
+var dist embed.FS
+
+// Dist returns the embedded frontend, or nil when the binary was built
+// without one.
+func Dist() fs.FS {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		return nil
+	}
+	if _, err := fs.Stat(sub, "index.html"); err != nil {
+		return nil
+	}
+	return sub
+}
@@ -17,6 +17,10 @@ type SelectedComponent = {
   itemType: Item["item_type"];
   unit: Item["managed_unit"];
   qtyPerUnit: string;
+  // Percent of the material lost in the process, e.g. "3" for 3%.
+  scrapPercent: string;
+  // Reference designators, e.g. "R1,R2,R7".
+  refs: string;
   note: string;
 };
 
@@ -25,6 +29,10 @@ type AssemblyRevision = {
   rev_no: number;
   created_at: string;
   component_count: number;
+  effective_from?: string;
+  obsolete_at?: string;
+  change_note?: string;
+  changed_by?: string;
 };
 
 type AssemblyComponentSet = {
@@ -32,6 +40,7 @@ type AssemblyComponentSet = {
   current_record_id?: number;
   current_rev_no?: number;
   current_created_at?: string;
+  current_yield?: number;
   revisions: AssemblyRevision[];
   components: Array<{
     component_item_id: number;
@@ -40,10 +49,17 @@ type AssemblyComponentSet = {
     item_type: Item["item_type"];
     managed_unit: Item["managed_unit"];
     qty_per_unit: number;
+    scrap_factor?: number;
+    refs?: string;
+    position?: number;
     note?: string;
   }>;
 };
 
+function toPercentText(fraction: number) {
+  return Number((fraction * 100).toFixed(4)).toString();
+}
+
 function isPartComponent(item: Item) {
   return item.item_type === "component" && item.component?.component_type === "part";
 }
@@ -62,6 +78,8 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
   const [components, setComponents] = useState<SelectedComponent[]>([]);
   const [revisions, setRevisions] = useState<AssemblyRevision[]>([]);
   const [currentRevNo, setCurrentRevNo] = useState<number | null>(null);
+  const [yieldPercent, setYieldPercent] = useState("100");
+  const [changeNote, setChangeNote] = useState("");
 
   const [loading, setLoading] = useState(false);
   const [saving, setSaving] = useState(false);
@@ -102,6 +120,7 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
     setComponents([]);
     setRevisions([]);
     setCurrentRevNo(null);
+    setYieldPercent("100");
     setError("");
     setMessage("");
   }, [sidebarListType]);
@@ -114,6 +133,7 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
 
     setRevisions(data.revisions ?? []);
     setCurrentRevNo(data.current_rev_no ?? null);
+    setYieldPercent(toPercentText(data.current_yield ?? 1));
     setComponents(
       (data.components ?? []).map((component) => ({
         itemId: component.component_item_id,
@@ -122,6 +142,8 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
         itemType: component.item_type,
         unit: component.managed_unit,
         qtyPerUnit: component.qty_per_unit.toString(),
+        scrapPercent: toPercentText(component.scrap_factor ?? 0),
+        refs: component.refs ?? "",
         note: component.note ?? "",
       })),
     );
@@ -132,6 +154,7 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
       setComponents([]);
       setRevisions([]);
       setCurrentRevNo(null);
+      setYieldPercent("100");
       return;
     }
 
@@ -203,6 +226,8 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
           itemType: item.item_type,
           unit: item.managed_unit,
           qtyPerUnit: "1",
+          scrapPercent: "0",
+          refs: "",
           note: "",
         },
       ];
@@ -250,21 +275,38 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
       return;
     }
 
+    const yieldValue = Number(yieldPercent);
+    if (!Number.isFinite(yieldValue) || yieldValue <= 0 || yieldValue > 100) {
+      setError("歩留まりは 0 より大きく 100 以下で入力してください。");
+      return;
+    }
+
     const payloadComponents = [] as Array<{
       component_item_id: number;
       qty_per_unit: number;
+      scrap_factor: number;
+      refs: string;
+      position: number;
       note: string;
     }>;
 
-    for (const c of components) {
+    for (const [index, c] of components.entries()) {
       const qty = Number(c.qtyPerUnit);
       if (!Number.isFinite(qty) || qty <= 0) {
         setError(`数量が不正です: ${c.sku}`);
         return;
       }
+      const scrap = Number(c.scrapPercent || "0");
+      if (!Number.isFinite(scrap) || scrap < 0 || scrap >= 100) {
+        setError(`ロス率が不正です: ${c.sku}`);
+        return;
+      }
       payloadComponents.push({
         component_item_id: c.itemId,
         qty_per_unit: Number(qty.toFixed(6)),
+        scrap_factor: Number((scrap / 100).toFixed(6)),
+        refs: c.refs.trim(),
+        position: index + 1,
         note: c.note.trim(),
       });
     }
@@ -274,7 +316,11 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
       const res = await fetch(`/api/assemblies/${selectedParentId}/components`, {
         method: "PUT",
         headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ components: payloadComponents }),
+        body: JSON.stringify({
+          components: payloadComponents,
+          yield: Number((yieldValue / 100).toFixed(6)),
+          change_note: changeNote.trim(),
+        }),
       });
 
       if (!res.ok) {
@@ -283,6 +329,7 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
 
       const body = (await res.json()) as { rev_no?: number };
       setMessage(`登録しました。rev ${body.rev_no ?? "-"}`);
+      setChangeNote("");
       await loadAssemblyData(selectedParentId);
     } catch (e) {
       setError(e instanceof Error ? e.message : "登録に失敗しました。");
@@ -296,7 +343,7 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
       setError("削除対象の revision がありません。");
       return;
     }
-    if (!window.confirm(`rev ${currentRevNo} を削除します。`)) return;
+    if (!window.confirm(`rev ${currentRevNo} を廃止します（rev 番号は振り直しません）。`)) return;
 
     setDeleting(true);
     setError("");
@@ -308,7 +355,7 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
       if (!res.ok) {
         throw new Error(await res.text());
       }
-      setMessage(`rev ${currentRevNo} を削除しました。`);
+      setMessage(`rev ${currentRevNo} を廃止しました。`);
       await loadAssemblyData(selectedParentId);
     } catch (e) {
       setError(e instanceof Error ? e.message : "削除に失敗しました。");
@@ -410,14 +457,20 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
                 {revisions.length === 0 && <option value="">rev none</option>}
                 {revisions.map((rev) => (
                   <option key={rev.record_id} value={rev.rev_no}>
-                    rev {rev.rev_no} ({formatUtcTextToLocal(rev.created_at)})
+                    rev {rev.rev_no} ({formatUtcTextToLocal(rev.created_at)}){rev.obsolete_at ? " 廃止" : ""}
                   </option>
                 ))}
               </select>
               <button
                 type="button"
                 onClick={deleteCurrentRevision}
-                disabled={!selectedParent || !currentRevNo || deleting || loading}
+                disabled={
+                  !selectedParent ||
+                  !currentRevNo ||
+                  deleting ||
+                  loading ||
+                  revisions.some((rev) => rev.rev_no === currentRevNo && rev.obsolete_at)
+                }
                 className="rounded-md border border-red-300 px-3 py-2 text-xs font-bold text-red-700 hover:bg-red-50 disabled:cursor-not-allowed disabled:opacity-50"
               >
                 {deleting ? "削除中..." : "rev削除"}
@@ -425,6 +478,16 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
 
             </div>
           </div>
+          {(() => {
+            const rev = revisions.find((r) => r.rev_no === currentRevNo);
+            if (!rev?.change_note && !rev?.changed_by) return null;
+            return (
+              <p className="mt-2 text-xs text-gray-600">
+                変更理由: {rev.change_note || "-"}
+                {rev.changed_by ? ` (${rev.changed_by})` : ""}
+              </p>
+            );
+          })()}
 
           <div className="relative mt-4 rounded-lg border border-sky-100 bg-sky-50/60 p-4">
             {components.length === 0 && (
@@ -449,7 +512,7 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
                       </button>
                     </div>
 
-                    <div className="mt-3 grid gap-3 md:grid-cols-[140px_100px_minmax(0,1fr)]">
+                    <div className="mt-3 grid gap-3 md:grid-cols-[140px_100px_100px_160px_minmax(0,1fr)]">
                       <label className="text-xs font-semibold text-gray-700">
                         Qty / 1 assy *
                         <input
@@ -473,6 +536,35 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
                           readOnly
                         />
                       </label>
+                      <label className="text-xs font-semibold text-gray-700">
+                        Scrap %
+                        <input
+                          type="number"
+                          min="0"
+                          max="99"
+                          step="0.1"
+                          className="mt-1 w-full rounded-lg border border-gray-300 px-3 py-2 text-sm"
+                          value={component.scrapPercent}
+                          onChange={(e) =>
+                            updateComponent(component.itemId, {
+                              scrapPercent: e.target.value,
+                            })
+                          }
+                        />
+                      </label>
+                      <label className="text-xs font-semibold text-gray-700">
+                        Refs
+                        <input
+                          className="mt-1 w-full rounded-lg border border-gray-300 px-3 py-2 text-sm"
+                          value={component.refs}
+                          onChange={(e) =>
+                            updateComponent(component.itemId, {
+                              refs: e.target.value,
+                            })
+                          }
+                          placeholder="R1,R2"
+                        />
+                      </label>
                       <label className="text-xs font-semibold text-gray-700">
                         Note
                         <input
@@ -506,7 +598,30 @@ export default function AssemblyBuilderPage({ items }: AssemblyBuilderPageProps)
       
           </div>
 
-          <div className="mt-4 flex justify-end">
+          <div className="mt-4 flex items-end justify-end gap-3">
+            <label className="flex-1 text-xs font-semibold text-gray-700">
+              変更理由
+              <input
+                className="mt-1 block w-full rounded-lg border border-gray-300 px-3 py-2 text-sm"
+                value={changeNote}
+                onChange={(e) => setChangeNote(e.target.value)}
+                placeholder="例: ベアリングを 608ZZ から 608-2RS に変更"
+                disabled={!selectedParent}
+              />
+            </label>
+            <label className="text-xs font-semibold text-gray-700">
+              Yield %
+              <input
+                type="number"
+                min="1"
+                max="100"
+                step="0.1"
+                className="mt-1 block w-24 rounded-lg border border-gray-300 px-3 py-2 text-sm"
+                value={yieldPercent}
+                onChange={(e) => setYieldPercent(e.target.value)}
+                disabled={!selectedParent}
+              />
+            </label>
             <button
               type="button"
               onClick={registerComponents}
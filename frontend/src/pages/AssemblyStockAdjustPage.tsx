@@ -4,20 +4,30 @@ type AssemblyStock = {
   item_id: number;
   sku: string;
   name: string;
+  stock_managed: boolean;
+  reorder_point?: number;
+  below_reorder: boolean;
   stock_qty: number;
   updated_at?: string;
 };
 
+type ReasonCode = {
+  code: string;
+  label: string;
+};
+
 type FormState = {
   qty: string;
   note: string;
+  reasonCode: string;
 };
 
 export default function AssemblyStockAdjustPage() {
   const [assemblies, setAssemblies] = useState<AssemblyStock[]>([]);
   const [selectedId, setSelectedId] = useState<number | null>(null);
   const [search, setSearch] = useState("");
-  const [form, setForm] = useState<FormState>({ qty: "1", note: "" });
+  const [form, setForm] = useState<FormState>({ qty: "1", note: "", reasonCode: "" });
+  const [reasonCodes, setReasonCodes] = useState<ReasonCode[]>([]);
   const [loading, setLoading] = useState(false);
   const [saving, setSaving] = useState(false);
   const [error, setError] = useState("");
@@ -56,16 +66,32 @@ export default function AssemblyStockAdjustPage() {
     void loadAssemblies("");
   }, [loadAssemblies]);
 
-  async function submitAdjust(direction: "IN" | "OUT") {
+  useEffect(() => {
+    void (async () => {
+      try {
+        const res = await fetch("/api/reason-codes");
+        if (!res.ok) throw new Error(await res.text());
+        setReasonCodes((await res.json()) as ReasonCode[]);
+      } catch (e) {
+        setError(e instanceof Error ? e.message : "failed to load reason codes");
+      }
+    })();
+  }, []);
+
+  async function submitAdjust(direction: "IN" | "OUT" | "SET") {
     if (!selected) {
       setError("Select an assembly.");
       return;
     }
     const qty = Number(form.qty);
-    if (!Number.isFinite(qty) || qty <= 0) {
+    if (!Number.isFinite(qty) || qty < 0 || (qty === 0 && direction !== "SET")) {
       setError("Qty must be a positive number.");
       return;
     }
+    if (direction === "OUT" && !form.reasonCode) {
+      setError("Select a reason for OUT.");
+      return;
+    }
 
     setSaving(true);
     setError("");
@@ -78,16 +104,32 @@ export default function AssemblyStockAdjustPage() {
           direction,
           qty,
           note: form.note.trim(),
+          reason_code: form.reasonCode || undefined,
         }),
       });
       if (!res.ok) throw new Error(await res.text());
-      const data = (await res.json()) as { item_id: number; stock_qty: number };
+      const data = (await res.json()) as {
+        item_id: number;
+        stock_qty: number;
+        warnings?: string[];
+      };
       setAssemblies((prev) =>
         prev.map((row) =>
-          row.item_id === data.item_id ? { ...row, stock_qty: data.stock_qty } : row,
+          row.item_id === data.item_id
+            ? {
+                ...row,
+                stock_qty: data.stock_qty,
+                below_reorder:
+                  row.stock_managed &&
+                  row.reorder_point !== undefined &&
+                  data.stock_qty <= row.reorder_point,
+              }
+            : row,
         ),
       );
-      setMessage(`${direction === "IN" ? "入庫" : "出庫"}を登録しました。`);
+      const warned = data.warnings && data.warnings.length > 0 ? `（警告: ${data.warnings.join(" / ")}）` : "";
+      const label = direction === "IN" ? "入庫" : direction === "OUT" ? "出庫" : "棚卸し";
+      setMessage(`${label}を登録しました。${warned}`);
       setForm((prev) => ({ ...prev, note: "" }));
     } catch (e) {
       setError(e instanceof Error ? e.message : "failed to adjust stock");
@@ -135,7 +177,10 @@ export default function AssemblyStockAdjustPage() {
               >
                 <p className="font-mono text-xs text-gray-500">{row.sku}</p>
                 <p className="text-sm font-semibold text-gray-900">{row.name}</p>
-                <p className="text-xs text-gray-600">Stock: {row.stock_qty}</p>
+                <p className={`text-xs ${row.below_reorder ? "font-bold text-red-600" : "text-gray-600"}`}>
+                  Stock: {row.stock_qty}
+                  {row.below_reorder && ` (reorder ≤ ${row.reorder_point})`}
+                </p>
               </button>
             ))}
           </div>
@@ -163,6 +208,22 @@ export default function AssemblyStockAdjustPage() {
                 />
               </label>
 
+              <label className="block text-sm font-medium text-gray-700">
+                Reason
+                <select
+                  className="mt-1 w-full max-w-xs rounded-lg border border-gray-300 px-3 py-2"
+                  value={form.reasonCode}
+                  onChange={(e) => setForm((prev) => ({ ...prev, reasonCode: e.target.value }))}
+                >
+                  <option value="">(required for 出庫)</option>
+                  {reasonCodes.map((rc) => (
+                    <option key={rc.code} value={rc.code}>
+                      {rc.label}
+                    </option>
+                  ))}
+                </select>
+              </label>
+
               <label className="block text-sm font-medium text-gray-700">
                 Note
                 <input
@@ -190,6 +251,15 @@ export default function AssemblyStockAdjustPage() {
                 >
                   {saving ? "Saving..." : "出庫"}
                 </button>
+                <button
+                  type="button"
+                  disabled={saving}
+                  onClick={() => void submitAdjust("SET")}
+                  title="Set the counted quantity"
+                  className="rounded-full bg-slate-600 px-5 py-2 text-sm font-bold text-white hover:bg-slate-700 disabled:cursor-not-allowed disabled:bg-slate-300"
+                >
+                  {saving ? "Saving..." : "棚卸し"}
+                </button>
               </div>
             </div>
           ) : (
@@ -1,11 +1,20 @@
 import { useCallback, useEffect, useMemo, useState } from "react";
 import ItemCsvTools from "../components/ItemCsvTools";
 import type { ComponentType, Item } from "../types/item";
+import { readApiError, type FieldErrors } from "../utils/api";
+
+const inputBase = "mt-1 w-full rounded-lg border px-3 py-2";
+
+function FieldMessage({ message }: { message?: string }) {
+  if (!message) return null;
+  return <span className="mt-1 block text-xs font-normal text-red-600">{message}</span>;
+}
 
 export default function CreateItemPage() {
   type SelectableItemType = Item["item_type"] | "";
   const [saving, setSaving] = useState(false);
   const [error, setError] = useState("");
+  const [fieldErrors, setFieldErrors] = useState<FieldErrors>({});
   const [success, setSuccess] = useState("");
   const [historyError, setHistoryError] = useState("");
   const [historyItems, setHistoryItems] = useState<Item[]>([]);
@@ -26,6 +35,7 @@ export default function CreateItemPage() {
     total_weight: "",
     pack_size: "",
     note: "",
+    phantom: false,
   });
   const [componentForm, setComponentForm] = useState({
     manufacturer: "",
@@ -57,6 +67,7 @@ export default function CreateItemPage() {
       total_weight: "",
       pack_size: "",
       note: "",
+      phantom: false,
     });
     setComponentForm({
       manufacturer: "",
@@ -91,10 +102,15 @@ export default function CreateItemPage() {
     [historyItems, form.item_type],
   );
 
+  function inputClass(field: string) {
+    return `${inputBase} ${fieldErrors[field] ? "border-red-500 bg-red-50" : "border-gray-300"}`;
+  }
+
   async function onSubmit(e: React.FormEvent<HTMLFormElement>) {
     e.preventDefault();
     setError("");
     setSuccess("");
+    setFieldErrors({});
 
     const sku = form.sku.trim();
     const name = form.name.trim();
@@ -159,6 +175,7 @@ export default function CreateItemPage() {
           total_weight: assemblyTotalWeight,
           pack_size: assemblyForm.pack_size.trim(),
           note: assemblyForm.note.trim(),
+          phantom: assemblyForm.phantom,
         };
       } else if (form.item_type === "component") {
         payload.component = {
@@ -174,7 +191,11 @@ export default function CreateItemPage() {
         body: JSON.stringify(payload),
       });
 
-      if (!res.ok) throw new Error(await res.text());
+      if (!res.ok) {
+        const err = await readApiError(res);
+        setFieldErrors(err.fields);
+        throw err;
+      }
       setSuccess("Item created.");
       resetFormsByType("");
       void loadHistoryItems();
@@ -205,6 +226,7 @@ export default function CreateItemPage() {
       total_weight: item.assembly?.total_weight?.toString() ?? "",
       pack_size: item.assembly?.pack_size ?? "",
       note: item.assembly?.note ?? "",
+      phantom: item.assembly?.phantom ?? false,
     });
     setComponentForm({
       manufacturer: item.component?.manufacturer ?? "",
@@ -253,27 +275,29 @@ export default function CreateItemPage() {
                 <label className="text-sm font-medium text-gray-700">
                   SKU *
                   <input
-                    className="mt-1 w-full rounded-lg border border-gray-300 px-3 py-2"
+                    className={inputClass("sku")}
                     value={form.sku}
                     onChange={(e) => setForm((f) => ({ ...f, sku: e.target.value }))}
                     placeholder="ITEM-001"
                   />
+                  <FieldMessage message={fieldErrors.sku} />
                 </label>
 
                 <label className="text-sm font-medium text-gray-700">
                   Name *
                   <input
-                    className="mt-1 w-full rounded-lg border border-gray-300 px-3 py-2"
+                    className={inputClass("name")}
                     value={form.name}
                     onChange={(e) => setForm((f) => ({ ...f, name: e.target.value }))}
                     placeholder="Sample Item"
                   />
+                  <FieldMessage message={fieldErrors.name} />
                 </label>
 
                 <label className="text-sm font-medium text-gray-700">
               Managed Unit *
               <select
-                className="mt-1 w-full rounded-lg border border-gray-300 px-3 py-2"
+                className={inputClass("managed_unit")}
                 value={form.managed_unit}
                 onChange={(e) =>
                   setForm((f) => ({ ...f, managed_unit: e.target.value as Item["managed_unit"] }))
@@ -282,6 +306,7 @@ export default function CreateItemPage() {
                 <option value="pcs">pcs</option>
                 <option value="g">g</option>
               </select>
+              <FieldMessage message={fieldErrors.managed_unit} />
             </label>
 
                 <label className="text-sm font-medium text-gray-700">
@@ -290,11 +315,12 @@ export default function CreateItemPage() {
                 type="number"
                 min="0"
                 step="0.01"
-                className="mt-1 w-full rounded-lg border border-gray-300 px-3 py-2"
+                className={inputClass("pack_qty")}
                 value={form.pack_qty}
                 onChange={(e) => setForm((f) => ({ ...f, pack_qty: e.target.value }))}
                 placeholder="initial stock quantity if applicable"
               />
+              <FieldMessage message={fieldErrors.pack_qty} />
             </label>
 
                 <label className="text-sm font-medium text-gray-700">
@@ -303,11 +329,12 @@ export default function CreateItemPage() {
                 type="number"
                 min="0"
                 step="0.01"
-                className="mt-1 w-full rounded-lg border border-gray-300 px-3 py-2"
+                className={inputClass("reorder_point")}
                 value={form.reorder_point}
                 onChange={(e) => setForm((f) => ({ ...f, reorder_point: e.target.value }))}
                 placeholder="minimum stock to keep"
               />
+              <FieldMessage message={fieldErrors.reorder_point} />
             </label>
 
                 <label className="text-sm font-medium text-gray-700 md:col-span-2">
@@ -339,13 +366,14 @@ export default function CreateItemPage() {
                     type="number"
                     min="0"
                     step="0.01"
-                    className="mt-1 w-full rounded-lg border border-gray-300 px-3 py-2"
+                    className={inputClass("assembly.total_weight")}
                     value={assemblyForm.total_weight}
                     onChange={(e) =>
                       setAssemblyForm((f) => ({ ...f, total_weight: e.target.value }))
                     }
                     placeholder="optional"
                   />
+                  <FieldMessage message={fieldErrors["assembly.total_weight"]} />
                 </label>
                 <label className="text-sm font-medium text-gray-700">
                   Pack Size
@@ -367,6 +395,16 @@ export default function CreateItemPage() {
                     placeholder="optional"
                   />
                 </label>
+                <label className="flex items-center gap-2 text-sm text-gray-700 md:col-span-2">
+                  <input
+                    type="checkbox"
+                    checked={assemblyForm.phantom}
+                    onChange={(e) =>
+                      setAssemblyForm((f) => ({ ...f, phantom: e.target.checked }))
+                    }
+                  />
+                  Phantom (not stocked; BOM is built straight into the parent)
+                </label>
               </>
             )}
 
@@ -386,7 +424,7 @@ export default function CreateItemPage() {
                 <label className="text-sm font-medium text-gray-700">
                   Component Type
                   <select
-                    className="mt-1 w-full rounded-lg border border-gray-300 px-3 py-2"
+                    className={inputClass("component.component_type")}
                     value={componentForm.component_type}
                     onChange={(e) =>
                       setComponentForm((f) => ({ ...f, component_type: e.target.value as ComponentType }))
@@ -396,6 +434,7 @@ export default function CreateItemPage() {
                     <option value="part">part</option>
                     <option value="consumable">consumable</option>
                   </select>
+                  <FieldMessage message={fieldErrors["component.component_type"]} />
                 </label>
                 <label className="text-sm font-medium text-gray-700 md:col-span-2">
                   Color
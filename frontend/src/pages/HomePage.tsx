@@ -2,6 +2,7 @@ import { useEffect, useMemo, useState } from "react";
 import FilterBar from "../components/FilterBar";
 import type { Item } from "../types/item";
 import { formatUtcTextToLocal } from "../utils/datetime";
+import { useServerEvents } from "../utils/events";
 
 type HomePageProps = {
   items: Item[];
@@ -35,6 +36,7 @@ export default function HomePage({ items }: HomePageProps) {
   const [stockRows, setStockRows] = useState<StockSummaryRow[]>([]);
   const [stockLoading, setStockLoading] = useState(false);
   const [stockError, setStockError] = useState("");
+  const [stockReloadKey, setStockReloadKey] = useState(0);
   const [openLinksItemID, setOpenLinksItemID] = useState<number | null>(null);
   const [stockKeyword, setStockKeyword] = useState("");
   const [stockTypeFilter, setStockTypeFilter] = useState<
@@ -112,7 +114,10 @@ export default function HomePage({ items }: HomePageProps) {
       .finally(() => setStockLoading(false));
 
     return () => controller.abort();
-  }, []);
+  }, [stockReloadKey]);
+
+  // Another device recorded a movement or changed an item: reload the list.
+  useServerEvents(["stock", "item"], () => setStockReloadKey((k) => k + 1));
 
   useEffect(() => {
     function onPointerDown(event: MouseEvent | TouchEvent) {
@@ -1,6 +1,7 @@
 import { useEffect, useMemo, useState } from "react";
 import FilterBar from "../components/FilterBar";
 import type { ComponentType, Item } from "../types/item";
+import { readApiError } from "../utils/api";
 
 type ItemsPageProps = {
   items: Item[];
@@ -26,6 +27,7 @@ type EditForm = {
   assembly_total_weight: string;
   assembly_pack_size: string;
   assembly_note: string;
+  assembly_phantom: boolean;
   component_manufacturer: string;
   component_type: ComponentType;
   component_color: string;
@@ -54,6 +56,7 @@ export default function ItemsPage({ items, error }: ItemsPageProps) {
     assembly_total_weight: "",
     assembly_pack_size: "",
     assembly_note: "",
+    assembly_phantom: false,
     component_manufacturer: "",
     component_type: "material",
     component_color: "",
@@ -114,6 +117,7 @@ export default function ItemsPage({ items, error }: ItemsPageProps) {
       assembly_total_weight: item.assembly?.total_weight?.toString() ?? "",
       assembly_pack_size: item.assembly?.pack_size ?? "",
       assembly_note: item.assembly?.note ?? "",
+      assembly_phantom: item.assembly?.phantom ?? false,
       component_manufacturer: item.component?.manufacturer ?? "",
       component_type: item.component?.component_type ?? "material",
       component_color: item.component?.color ?? "",
@@ -179,6 +183,7 @@ export default function ItemsPage({ items, error }: ItemsPageProps) {
         total_weight: totalWeight,
         pack_size: editForm.assembly_pack_size.trim(),
         note: editForm.assembly_note.trim(),
+        phantom: editForm.assembly_phantom,
       };
     } else if (selectedItem.item_type === "component") {
       const purchaseLinks = editForm.component_purchase_links
@@ -203,7 +208,7 @@ export default function ItemsPage({ items, error }: ItemsPageProps) {
         headers: { "Content-Type": "application/json" },
         body: JSON.stringify(payload),
       });
-      if (!res.ok) throw new Error(await res.text());
+      if (!res.ok) throw await readApiError(res);
 
       setLocalItems((prev) =>
         prev.map((item) => {
@@ -216,7 +221,8 @@ export default function ItemsPage({ items, error }: ItemsPageProps) {
             pack_qty: packQty ?? undefined,
             reorder_point: reorderPoint,
             note: editForm.note.trim() || undefined,
-            stock_managed: editForm.stock_managed,
+            // The server never stock-manages phantoms.
+            stock_managed: editForm.stock_managed && !(item.item_type === "assembly" && editForm.assembly_phantom),
             is_sellable: editForm.is_sellable,
             is_final: editForm.is_final,
             assembly:
@@ -226,6 +232,7 @@ export default function ItemsPage({ items, error }: ItemsPageProps) {
                   total_weight: totalWeight ?? undefined,
                   pack_size: editForm.assembly_pack_size.trim() || undefined,
                   note: editForm.assembly_note.trim() || undefined,
+                  phantom: editForm.assembly_phantom || undefined,
                 }
                 : item.assembly,
             component:
@@ -482,6 +489,15 @@ export default function ItemsPage({ items, error }: ItemsPageProps) {
                     onChange={(e) => setEditForm((f) => ({ ...f, assembly_note: e.target.value }))}
                   />
                 </label>
+                <label className="flex items-center gap-2 font-medium md:col-span-2">
+                  <input
+                    type="checkbox"
+                    disabled={!editing}
+                    checked={editing ? editForm.assembly_phantom : selectedItem.assembly?.phantom ?? false}
+                    onChange={(e) => setEditForm((f) => ({ ...f, assembly_phantom: e.target.checked }))}
+                  />
+                  Phantom
+                </label>
               </div>
             )}
 
@@ -11,12 +11,23 @@ export type ComponentPurchaseLink = {
 export type Item = {
   id: number;
   series_id?: number;
+  series_name?: string;
+  custom_fields?: Record<string, string | number | boolean>;
   sku: string;
   name: string;
   item_type: "component" | "assembly";
   pack_qty?: number;
   reorder_point?: number;
+  unit_cost?: number;
+  unit_cost_currency?: string;
   managed_unit: "g" | "pcs";
+  // Per managed unit, not set for gram-managed items: grams, millimetres
+  // and cm³.
+  weight?: number;
+  length?: number;
+  width?: number;
+  height?: number;
+  volume?: number;
   stock_managed: boolean;
   is_sellable: boolean;
   is_final: boolean;
@@ -24,12 +35,18 @@ export type Item = {
   created_at?: string;
   updated_at?: string;
   assembly?: {
+    supplier_id?: number;
     manufacturer?: string;
     total_weight?: number;
+    // total_weight follows the BOM.
+    total_weight_auto?: boolean;
     pack_size?: string;
     note?: string;
+    // Never stocked; BOM explosion goes through to its components.
+    phantom?: boolean;
   };
   component?: {
+    supplier_id?: number;
     manufacturer?: string;
     component_type?: ComponentType;
     color?: string;
@@ -0,0 +1,33 @@
+export type FieldErrors = Record<string, string>;
+
+export class ApiError extends Error {
+  fields: FieldErrors;
+
+  constructor(message: string, fields: FieldErrors = {}) {
+    super(message);
+    this.fields = fields;
+  }
+}
+
+type ValidationBody = { errors?: { field: string; message: string }[] };
+
+// readApiError turns a failed response into an ApiError. Validation failures
+// ({"errors":[{"field","message"}]}) fill fields so forms can mark every bad
+// input at once; other errors are plain text.
+export async function readApiError(res: Response): Promise<ApiError> {
+  const text = await res.text();
+  try {
+    const body = JSON.parse(text) as ValidationBody;
+    if (Array.isArray(body?.errors)) {
+      const fields: FieldErrors = {};
+      for (const e of body.errors) {
+        if (!(e.field in fields)) fields[e.field] = e.message;
+      }
+      const message = body.errors.map((e) => `${e.field} ${e.message}`).join(" / ");
+      return new ApiError(message, fields);
+    }
+  } catch {
+    // not JSON
+  }
+  return new ApiError(text || `HTTP ${res.status}`);
+}
@@ -0,0 +1,37 @@
+import { useEffect, useRef } from "react";
+
+export type ServerEventType = "item" | "stock" | "bom";
+
+export type ServerEvent = {
+  id: number;
+  type: ServerEventType;
+  action: string;
+  item_id?: number;
+  at: string;
+};
+
+// Subscribes to /api/events while the component is mounted and calls
+// onEvent for every event of the given types. EventSource reconnects on
+// its own after network errors.
+export function useServerEvents(types: ServerEventType[], onEvent: (event: ServerEvent) => void) {
+  const handlerRef = useRef(onEvent);
+  handlerRef.current = onEvent;
+  const typesKey = types.join(",");
+
+  useEffect(() => {
+    const source = new EventSource("/api/events");
+    const listener = (message: MessageEvent<string>) => {
+      try {
+        handlerRef.current(JSON.parse(message.data) as ServerEvent);
+      } catch {
+        // ignore malformed payloads
+      }
+    };
+    const subscribed = typesKey.split(",");
+    subscribed.forEach((type) => source.addEventListener(type, listener));
+    return () => {
+      subscribed.forEach((type) => source.removeEventListener(type, listener));
+      source.close();
+    };
+  }, [typesKey]);
+}
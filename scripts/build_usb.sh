@@ -22,19 +22,26 @@ npm run build
 
 echo "[2/4] prepare dist"
 rm -rf "$DIST_DIR"
-mkdir -p "$DIST_DIR/frontend" "$DIST_DIR/data"
+mkdir -p "$DIST_DIR/data"
 
-cp -r "$FRONTEND_DIR/dist" "$DIST_DIR/frontend/dist"
+# The frontend is embedded into the binary (backend/web).
+EMBED_DIR="$BACKEND_DIR/web/dist"
+find "$EMBED_DIR" -mindepth 1 ! -name .gitkeep -exec rm -rf {} +
+cp -r "$FRONTEND_DIR/dist/." "$EMBED_DIR/"
 
 echo "[3/4] build backend binary"
 cd "$BACKEND_DIR"
-GOOS="$TARGET_OS" GOARCH="$TARGET_ARCH" CGO_ENABLED=0 go build -o "$DIST_DIR/$BIN_NAME" ./cmd/server
+GIT_COMMIT="$(git -C "$ROOT_DIR" rev-parse HEAD 2>/dev/null || true)"
+BUILD_TIME="$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+GOOS="$TARGET_OS" GOARCH="$TARGET_ARCH" CGO_ENABLED=0 go build \
+  -ldflags "-X main.commit=$GIT_COMMIT -X main.buildTime=$BUILD_TIME" \
+  -o "$DIST_DIR/$BIN_NAME" ./cmd/server
 
 cat > "$DIST_DIR/run.sh" <<'EOF'
 #!/usr/bin/env bash
 set -euo pipefail
 cd "$(dirname "$0")"
-DB_DSN=sqlite:./data/app.db PORT=8080 STATIC_DIR=./frontend/dist ./stockmate
+DB_DSN=sqlite:./data/app.db PORT=8080 ./stockmate
 EOF
 chmod +x "$DIST_DIR/run.sh"
 
@@ -43,7 +50,6 @@ cat > "$DIST_DIR/run.bat" <<'EOF'
 cd /d %~dp0
 set DB_DSN=sqlite:./data/app.db
 set PORT=8080
-set STATIC_DIR=./frontend/dist
 stockmate.exe
 EOF
 